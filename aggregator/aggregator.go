@@ -0,0 +1,546 @@
+package aggregator
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"price-feed/hub"
+	"price-feed/logger"
+	"price-feed/models"
+	"price-feed/storage"
+)
+
+const (
+	// IndexExchange is the exchange key the pipeline series are stored under.
+	IndexExchange = "index"
+
+	recomputeChunk = 1000
+
+	defaultMaxJumpBuckets = 2
+)
+
+// Config represents an aggregator configuration.
+type Config struct {
+	Pipelines []*PipelineConfig `json:"pipelines"`
+	// Synthetic defines the pairs computed from other symbols.
+	Synthetic []*SyntheticConfig `json:"synthetic"`
+	// Leaderboard enables the 24h volume leaderboard when present.
+	Leaderboard *LeaderboardConfig `json:"leaderboard"`
+}
+
+// PipelineConfig describes a named aggregation of several sources into one series.
+type PipelineConfig struct {
+	Name     string          `json:"name"`
+	Interval string          `json:"interval"`
+	Sources  []*SourceConfig `json:"sources"`
+	// MaxDeviation drops the sources whose close price deviates from the median
+	// by more than this fraction. Zero disables the filter.
+	MaxDeviation   float64 `json:"max_deviation"`
+	UpdateInterval string  `json:"update_interval"`
+	// MaxJump rejects candles whose close moves from the previous one by more than
+	// this fraction unless at least two sources confirm the move. Zero disables the guard
+	// for the sources without a MaxJump of their own.
+	MaxJump float64 `json:"max_jump"`
+	// MaxJumpBuckets accepts a rejected move once it persists across this many buckets
+	// in a row, 2 by default.
+	MaxJumpBuckets int `json:"max_jump_buckets"`
+	// Version selects the stored series, so the history can be recomputed
+	// into a new version before switching to it.
+	Version int `json:"version"`
+	// IncludeAnomalous keeps the candles flagged as anomalous prints in the sources.
+	IncludeAnomalous bool `json:"include_anomalous"`
+}
+
+// Series returns the name of the stored series of the pipeline version.
+func (p *PipelineConfig) Series(version int) string {
+	if version == 0 {
+		return p.Name
+	}
+	return p.Name + "_v" + strconv.Itoa(version)
+}
+
+// SourceConfig represents a single input of a pipeline.
+type SourceConfig struct {
+	Exchange string  `json:"exchange"`
+	Symbol   string  `json:"symbol"`
+	Weight   float64 `json:"weight"`
+	// MaxJump overrides the MaxJump of the pipeline for the symbol.
+	MaxJump float64 `json:"max_jump"`
+}
+
+// Aggregator computes the configured pipelines.
+type Aggregator struct {
+	config          *Config
+	log             *logger.Logger
+	database        *storage.Client
+	hub             *hub.Hub
+	pipelines       map[string]*PipelineConfig
+	updateIntervals map[string]time.Duration
+	rejectionsMu    sync.Mutex
+	rejections      map[string]int64
+	// jumps holds the candles rejected in a row per series, guarded by rejectionsMu.
+	jumps           map[string]*jumpGuard
+	synthetic       []*SyntheticConfig
+	syntheticUpdate map[string]time.Duration
+	leaderboard     leaderboard
+	// leaderboardUpdate is zero if the leaderboard is disabled.
+	leaderboardUpdate time.Duration
+}
+
+// New returns a new aggregator instance. The hub is optional.
+func New(config *Config, log *logger.Logger, database *storage.Client, hub *hub.Hub) (*Aggregator, error) {
+	a := &Aggregator{
+		config:          config,
+		log:             log,
+		database:        database,
+		hub:             hub,
+		pipelines:       make(map[string]*PipelineConfig),
+		updateIntervals: make(map[string]time.Duration),
+		rejections:      make(map[string]int64),
+		jumps:           make(map[string]*jumpGuard),
+		syntheticUpdate: make(map[string]time.Duration),
+		leaderboard:     leaderboard{sources: make(map[string]SymbolSource)},
+	}
+
+	if config == nil {
+		return a, nil
+	}
+
+	for _, p := range config.Pipelines {
+		if p.Name == "" {
+			return nil, fmt.Errorf("pipeline name is empty")
+		}
+
+		if _, ok := a.pipelines[p.Name]; ok {
+			return nil, fmt.Errorf("duplicate pipeline %v", p.Name)
+		}
+
+		candleInterval, ok := models.NormalizeInterval(p.Interval)
+		if !ok {
+			return nil, fmt.Errorf("invalid interval %v of pipeline %v", p.Interval, p.Name)
+		}
+		p.Interval = candleInterval
+
+		if len(p.Sources) == 0 {
+			return nil, fmt.Errorf("pipeline %v has no sources", p.Name)
+		}
+
+		if p.MaxJumpBuckets <= 0 {
+			p.MaxJumpBuckets = defaultMaxJumpBuckets
+		}
+
+		interval, err := time.ParseDuration(p.UpdateInterval)
+		if err != nil {
+			return nil, errors.Wrapf(err, "couldn't parse update interval of pipeline %v", p.Name)
+		}
+
+		a.pipelines[p.Name] = p
+		a.updateIntervals[p.Name] = interval
+	}
+
+	for _, s := range config.Synthetic {
+		if err := s.parse(); err != nil {
+			return nil, err
+		}
+
+		if _, ok := a.syntheticUpdate[s.Symbol]; ok {
+			return nil, fmt.Errorf("duplicate synthetic pair %v", s.Symbol)
+		}
+
+		interval, err := time.ParseDuration(s.UpdateInterval)
+		if err != nil {
+			return nil, errors.Wrapf(err, "couldn't parse update interval of synthetic pair %v", s.Symbol)
+		}
+
+		a.synthetic = append(a.synthetic, s)
+		a.syntheticUpdate[s.Symbol] = interval
+	}
+
+	if config.Leaderboard != nil {
+		a.leaderboardUpdate = defaultLeaderboardUpdate
+		if config.Leaderboard.UpdateInterval != "" {
+			interval, err := time.ParseDuration(config.Leaderboard.UpdateInterval)
+			if err != nil {
+				return nil, errors.Wrapf(err, "couldn't parse leaderboard update interval")
+			}
+			a.leaderboardUpdate = interval
+		}
+	}
+
+	return a, nil
+}
+
+// Start starts computing every pipeline periodically.
+func (a *Aggregator) Start() {
+	for name := range a.pipelines {
+		go a.run(name)
+	}
+
+	for _, s := range a.synthetic {
+		go a.runSynthetic(s, a.syntheticUpdate[s.Symbol])
+	}
+
+	if a.leaderboardUpdate > 0 {
+		go a.runLeaderboard(a.leaderboardUpdate)
+	}
+}
+
+// Pipeline returns the pipeline config by name.
+func (a *Aggregator) Pipeline(name string) (*PipelineConfig, bool) {
+	p, ok := a.pipelines[name]
+	return p, ok
+}
+
+func (a *Aggregator) run(name string) {
+	p := a.pipelines[name]
+	for ; ; <-time.Tick(a.updateIntervals[name]) {
+		now := time.Now()
+		// The previous bucket is included so it gets its final values once closed.
+		timeStart := now.Add(-2 * models.IntervalDuration(p.Interval)).Unix()
+
+		if err := a.Update(p, p.Version, timeStart, now.Unix()); err != nil {
+			a.log.Errorf("Could not update pipeline %v: %v", name, err)
+		}
+	}
+}
+
+// Rejections returns the number of candles rejected by the deviation guard per pipeline.
+func (a *Aggregator) Rejections() map[string]int64 {
+	a.rejectionsMu.Lock()
+	defer a.rejectionsMu.Unlock()
+
+	result := make(map[string]int64, len(a.rejections))
+	for k, v := range a.rejections {
+		result[k] = v
+	}
+	return result
+}
+
+// Update computes the pipeline candles within the range and stores them into the series version.
+func (a *Aggregator) Update(p *PipelineConfig, version int, timeStart, timeEnd int64) error {
+	buckets, err := a.computeBuckets(p, timeStart, timeEnd)
+	if err != nil {
+		return err
+	}
+
+	prev, hasPrev, err := a.previousCandle(p, version, timeStart)
+	if err != nil {
+		return errors.Wrapf(err, "could not load previous candlestick")
+	}
+
+	for _, bucket := range buckets {
+		candle, ok := merge(bucket)
+		if !ok {
+			continue
+		}
+
+		candles := []models.Candle{candle}
+		if hasPrev && !isConfirmed(prev.Close, candle.Close, bucket) {
+			if candles, ok = a.reject(p, version, prev.Close, candle); !ok {
+				a.log.Warnf("Rejected index %v candle %v: close moved from %v to %v without confirmation",
+					p.Name, candle.TimeStart, prev.Close, candle.Close)
+				continue
+			}
+
+			if len(candles) > 1 {
+				a.log.Warnf("Accepted index %v candles from %v: close moved from %v to %v across %v buckets",
+					p.Name, candles[0].TimeStart, prev.Close, candle.Close, len(candles))
+			}
+		} else {
+			a.accept(p, version, candle)
+		}
+
+		for i := range candles {
+			if err = a.database.StoreCandlestick(IndexExchange, p.Series(version), p.Interval, &candles[i]); err != nil {
+				return errors.Wrapf(err, "could not store candlestick")
+			}
+		}
+
+		prev, hasPrev = candle, true
+	}
+
+	return nil
+}
+
+// reject counts the rejection of the candle and returns the candles rejected in a row,
+// and true, once the move has persisted across the MaxJumpBuckets of the pipeline.
+func (a *Aggregator) reject(p *PipelineConfig, version int, prevClose float64, candle models.Candle) ([]models.Candle, bool) {
+	a.rejectionsMu.Lock()
+	defer a.rejectionsMu.Unlock()
+
+	series := p.Series(version)
+	guard, ok := a.jumps[series]
+	if !ok {
+		guard = &jumpGuard{}
+		a.jumps[series] = guard
+	}
+
+	candles, ok := guard.reject(prevClose, candle, p.MaxJumpBuckets)
+	if !ok {
+		a.rejections[p.Name]++
+	}
+	return candles, ok
+}
+
+// accept drops the rejected candles the accepted candle supersedes.
+func (a *Aggregator) accept(p *PipelineConfig, version int, candle models.Candle) {
+	a.rejectionsMu.Lock()
+	defer a.rejectionsMu.Unlock()
+
+	if guard, ok := a.jumps[p.Series(version)]; ok {
+		guard.accept(candle)
+	}
+}
+
+// jumpGuard holds the candles of a series rejected in a row, so a move which persists
+// is accepted instead of freezing the series at the previous close.
+type jumpGuard struct {
+	pending []models.Candle
+	// accepted is the open time of the last candle of the last accepted move, whose buckets
+	// are accepted again when recomputed against the close before the move.
+	accepted int64
+}
+
+// reject keeps the candle aside, replacing an earlier rejection of its bucket. Once the
+// candles rejected in a row, moving in the same direction, span the given number of buckets,
+// they are returned to be stored and the guard is reset.
+func (g *jumpGuard) reject(prevClose float64, candle models.Candle, buckets int) ([]models.Candle, bool) {
+	if candle.TimeStart <= g.accepted {
+		return []models.Candle{candle}, true
+	}
+
+	if n := len(g.pending); n > 0 {
+		first := g.pending[0]
+		switch {
+		case (first.Close > prevClose) != (candle.Close > prevClose):
+			g.pending = g.pending[:0]
+		case g.pending[n-1].TimeStart == candle.TimeStart:
+			g.pending = g.pending[:n-1]
+		}
+	}
+	g.pending = append(g.pending, candle)
+
+	if len(g.pending) < buckets {
+		return nil, false
+	}
+
+	result := g.pending
+	g.pending = nil
+	g.accepted = candle.TimeStart
+	return result, true
+}
+
+// accept drops the rejected candles up to the accepted candle, which turned out to be spikes
+// if the series moved back, or got confirmed if their bucket did.
+func (g *jumpGuard) accept(candle models.Candle) {
+	i := 0
+	for i < len(g.pending) && g.pending[i].TimeStart <= candle.TimeStart {
+		i++
+	}
+	g.pending = g.pending[i:]
+}
+
+// previousCandle returns the last stored candle of the series before timeStart.
+func (a *Aggregator) previousCandle(p *PipelineConfig, version int, timeStart int64) (models.Candle, bool, error) {
+	lookback := int64(2 * models.IntervalDuration(p.Interval).Seconds())
+	candles, err := a.database.LoadCandlestickListByExchange(IndexExchange, p.Series(version), p.Interval,
+		timeStart-lookback, timeStart-1)
+	if err != nil {
+		return models.Candle{}, false, err
+	}
+
+	for i := len(candles) - 1; i >= 0; i-- {
+		if candles[i].TimeStart < timeStart {
+			return candles[i], true, nil
+		}
+	}
+	return models.Candle{}, false, nil
+}
+
+// isConfirmed reports whether the move from the previous close is within the limit, the largest
+// one of the source symbols, or is backed by at least two sources moving beyond their own limit
+// in the same direction.
+func isConfirmed(prevClose, close float64, bucket []weightedCandle) bool {
+	var maxJump float64
+	for _, v := range bucket {
+		if v.maxJump > maxJump {
+			maxJump = v.maxJump
+		}
+	}
+
+	if maxJump <= 0 || prevClose == 0 {
+		return true
+	}
+
+	jump := (close - prevClose) / prevClose
+	if jump <= maxJump && jump >= -maxJump {
+		return true
+	}
+
+	confirmations := 0
+	for _, v := range bucket {
+		if v.maxJump <= 0 {
+			continue
+		}
+
+		sourceJump := (v.Close - prevClose) / prevClose
+		if (jump > 0 && sourceJump > v.maxJump) || (jump < 0 && sourceJump < -v.maxJump) {
+			confirmations++
+		}
+	}
+	return confirmations >= 2
+}
+
+// Recompute rebuilds the series version from the stored source candles within the range,
+// processing it in chunks of recomputeChunk candles.
+func (a *Aggregator) Recompute(p *PipelineConfig, version int, timeStart, timeEnd int64) error {
+	step := int64(models.IntervalDuration(p.Interval).Seconds()) * recomputeChunk
+	if step <= 0 {
+		return fmt.Errorf("invalid interval %v", p.Interval)
+	}
+
+	for from := timeStart; from < timeEnd; from += step {
+		to := from + step
+		if to > timeEnd {
+			to = timeEnd
+		}
+
+		if err := a.Update(p, version, from, to); err != nil {
+			return errors.Wrapf(err, "could not recompute range [%v; %v]", from, to)
+		}
+
+		a.log.Infof("Recomputed index %v version %v: %.1f%%", p.Name, version,
+			float64(to-timeStart)*100/float64(timeEnd-timeStart))
+	}
+
+	return nil
+}
+
+// computeBuckets loads the source candles within the range, groups them by open time
+// and drops the outliers. The buckets are sorted by open time.
+func (a *Aggregator) computeBuckets(p *PipelineConfig, timeStart, timeEnd int64) ([][]weightedCandle, error) {
+	buckets := make(map[int64][]weightedCandle)
+	statuses := make(map[string]map[string]string)
+
+	for _, source := range p.Sources {
+		if _, ok := statuses[source.Exchange]; !ok {
+			exchangeStatuses, err := a.database.LoadSymbolStatuses(source.Exchange)
+			if err != nil {
+				return nil, errors.Wrapf(err, "could not load symbol statuses of %v", source.Exchange)
+			}
+			statuses[source.Exchange] = exchangeStatuses
+		}
+
+		// Halted or delisted markets would only contribute stale prices.
+		if v, ok := statuses[source.Exchange][source.Symbol]; ok && v != models.SymbolStatusTrading {
+			continue
+		}
+
+		candles, err := a.database.LoadCandlestickListByExchange(source.Exchange, source.Symbol, p.Interval, timeStart, timeEnd)
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not load candles of %v %v", source.Exchange, source.Symbol)
+		}
+
+		maxJump := source.MaxJump
+		if maxJump == 0 {
+			maxJump = p.MaxJump
+		}
+
+		for _, candle := range candles {
+			if candle.Anomalous && !p.IncludeAnomalous {
+				continue
+			}
+
+			buckets[candle.TimeStart] = append(buckets[candle.TimeStart], weightedCandle{
+				Candle:  candle,
+				weight:  source.Weight,
+				maxJump: maxJump,
+			})
+		}
+	}
+
+	result := make([][]weightedCandle, 0, len(buckets))
+	for _, bucket := range buckets {
+		if bucket = filterOutliers(bucket, p.MaxDeviation); len(bucket) > 0 {
+			result = append(result, bucket)
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i][0].TimeStart < result[j][0].TimeStart
+	})
+
+	return result, nil
+}
+
+type weightedCandle struct {
+	models.Candle
+	weight float64
+	// maxJump is the MaxJump of the source symbol, see isConfirmed.
+	maxJump float64
+}
+
+// filterOutliers drops the candles whose close deviates from the median close by more than maxDeviation.
+func filterOutliers(candles []weightedCandle, maxDeviation float64) []weightedCandle {
+	if maxDeviation <= 0 || len(candles) < 3 {
+		return candles
+	}
+
+	closes := make([]float64, 0, len(candles))
+	for _, v := range candles {
+		closes = append(closes, v.Close)
+	}
+	median := models.Median(closes)
+	if median == 0 {
+		return candles
+	}
+
+	result := make([]weightedCandle, 0, len(candles))
+	for _, v := range candles {
+		deviation := (v.Close - median) / median
+		if deviation < 0 {
+			deviation = -deviation
+		}
+
+		if deviation <= maxDeviation {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+// merge averages the prices using the source weights and sums the volumes.
+func merge(candles []weightedCandle) (models.Candle, bool) {
+	var totalWeight float64
+	for _, v := range candles {
+		totalWeight += v.weight
+	}
+
+	if totalWeight <= 0 {
+		return models.Candle{}, false
+	}
+
+	result := models.Candle{Source: models.CandleSourceIndex}
+	for _, v := range candles {
+		share := v.weight / totalWeight
+
+		result.TimeStart = v.TimeStart
+		if v.TimeEnd > result.TimeEnd {
+			result.TimeEnd = v.TimeEnd
+		}
+		if v.Time > result.Time {
+			result.Time = v.Time
+		}
+		result.Open += v.Open * share
+		result.Close += v.Close * share
+		result.High += v.High * share
+		result.Low += v.Low * share
+		result.Volume += v.Volume
+	}
+
+	return result, true
+}
@@ -0,0 +1,150 @@
+package aggregator
+
+import (
+	"reflect"
+	"testing"
+
+	"price-feed/models"
+)
+
+func source(close, maxJump float64) weightedCandle {
+	return weightedCandle{Candle: models.Candle{Close: close}, weight: 1, maxJump: maxJump}
+}
+
+func TestIsConfirmed(t *testing.T) {
+	tests := []struct {
+		name   string
+		close  float64
+		bucket []weightedCandle
+		want   bool
+	}{
+		{
+			name:   "guard disabled",
+			close:  150,
+			bucket: []weightedCandle{source(150, 0), source(150, 0)},
+			want:   true,
+		},
+		{
+			name:   "within the limit",
+			close:  105,
+			bucket: []weightedCandle{source(105, 0.1), source(105, 0.1)},
+			want:   true,
+		},
+		{
+			name:   "single source beyond the limit",
+			close:  120,
+			bucket: []weightedCandle{source(140, 0.1), source(100, 0.1)},
+		},
+		{
+			name:   "move confirmed by two sources",
+			close:  120,
+			bucket: []weightedCandle{source(120, 0.1), source(125, 0.1), source(115, 0.1)},
+			want:   true,
+		},
+		{
+			name:   "sources moving in the other direction",
+			close:  80,
+			bucket: []weightedCandle{source(120, 0.1), source(125, 0.1), source(0, 0.1)},
+		},
+		{
+			name:   "within the limit of a volatile symbol",
+			close:  120,
+			bucket: []weightedCandle{source(120, 0.1), source(120, 0.3)},
+			want:   true,
+		},
+		{
+			name:   "confirmation beyond the limits of the symbols",
+			close:  140,
+			bucket: []weightedCandle{source(140, 0.1), source(140, 0.3)},
+			want:   true,
+		},
+		{
+			name:   "volatile symbol within its own limit",
+			close:  160,
+			bucket: []weightedCandle{source(170, 0.1), source(150, 0.5)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isConfirmed(100, tt.close, tt.bucket); got != tt.want {
+				t.Errorf("confirmed = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJumpGuard(t *testing.T) {
+	type step struct {
+		candle   models.Candle
+		rejected bool
+	}
+	rejected := func(timeStart int64, close float64) step {
+		return step{candle: models.Candle{TimeStart: timeStart, Close: close}, rejected: true}
+	}
+	accepted := func(timeStart int64, close float64) step {
+		return step{candle: models.Candle{TimeStart: timeStart, Close: close}}
+	}
+
+	tests := []struct {
+		name  string
+		steps []step
+		want  []models.Candle
+	}{
+		{
+			name:  "bucket rejected again",
+			steps: []step{rejected(60, 150), rejected(60, 151)},
+		},
+		{
+			name:  "move persisting across buckets",
+			steps: []step{rejected(60, 150), rejected(60, 151), rejected(120, 152)},
+			want:  []models.Candle{{TimeStart: 60, Close: 151}, {TimeStart: 120, Close: 152}},
+		},
+		{
+			name:  "spike",
+			steps: []step{rejected(60, 150), accepted(120, 101), rejected(180, 150)},
+		},
+		{
+			name:  "move back and forth",
+			steps: []step{rejected(60, 150), rejected(120, 50)},
+		},
+		{
+			name:  "earlier bucket recomputed",
+			steps: []step{rejected(60, 150), accepted(0, 101), rejected(120, 152)},
+			want:  []models.Candle{{TimeStart: 60, Close: 150}, {TimeStart: 120, Close: 152}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var g jumpGuard
+			var got []models.Candle
+			for _, v := range tt.steps {
+				if !v.rejected {
+					g.accept(v.candle)
+					continue
+				}
+				if candles, ok := g.reject(100, v.candle, 2); ok {
+					got = candles
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("accepted = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJumpGuardRecompute(t *testing.T) {
+	var g jumpGuard
+	g.reject(100, models.Candle{TimeStart: 60, Close: 150}, 2)
+	if _, ok := g.reject(100, models.Candle{TimeStart: 120, Close: 150}, 2); !ok {
+		t.Fatalf("move persisting across buckets was rejected")
+	}
+
+	// The next update recomputes the buckets of the move against the close before it.
+	candles, ok := g.reject(100, models.Candle{TimeStart: 60, Close: 150}, 2)
+	if !ok || len(candles) != 1 {
+		t.Errorf("recomputed bucket = %+v, %v, want it accepted", candles, ok)
+	}
+}
@@ -0,0 +1,157 @@
+package aggregator
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"price-feed/models"
+)
+
+const (
+	defaultLeaderboardUpdate = 5 * time.Minute
+	leaderboardWindow        = 24 * time.Hour
+	leaderboardInterval      = "1h"
+)
+
+// LeaderboardConfig enables the 24h volume leaderboard of the tracked symbols.
+type LeaderboardConfig struct {
+	UpdateInterval string `json:"update_interval"`
+}
+
+// SymbolSource represents an exchange worker and the symbols it tracks in the Binance format.
+type SymbolSource interface {
+	Symbols() []string
+}
+
+// LeaderboardEntry represents the 24h statistics of a symbol merged across the exchanges.
+type LeaderboardEntry struct {
+	Symbol      string  `json:"symbol"`
+	Volume      float64 `json:"volume"`
+	QuoteVolume float64 `json:"quoteVolume"`
+	// USDVolume is the quote volume in USD, zero if the quote asset has no USD price.
+	USDVolume float64 `json:"usdVolume"`
+	Open      float64 `json:"open"`
+	Close     float64 `json:"close"`
+	// PriceChange is the relative change of the close over the window, e.g. 0.05.
+	PriceChange float64  `json:"priceChange"`
+	Exchanges   []string `json:"exchanges"`
+}
+
+// leaderboard holds the last computed leaderboard.
+type leaderboard struct {
+	mu      sync.RWMutex
+	sources map[string]SymbolSource
+	entries []LeaderboardEntry
+	updated time.Time
+}
+
+// AddSymbolSource adds the symbols of the exchange to the leaderboard. It must be called before Start.
+func (a *Aggregator) AddSymbolSource(exchange string, source SymbolSource) {
+	a.leaderboard.sources[exchange] = source
+}
+
+// Leaderboard returns the last computed leaderboard, sorted by the USD volume, and its time.
+// It is empty until the leaderboard is enabled and computed.
+func (a *Aggregator) Leaderboard() ([]LeaderboardEntry, time.Time) {
+	a.leaderboard.mu.RLock()
+	defer a.leaderboard.mu.RUnlock()
+
+	return a.leaderboard.entries, a.leaderboard.updated
+}
+
+func (a *Aggregator) runLeaderboard(updateInterval time.Duration) {
+	for ; ; <-time.Tick(updateInterval) {
+		entries := a.computeLeaderboard(time.Now())
+
+		a.leaderboard.mu.Lock()
+		a.leaderboard.entries = entries
+		a.leaderboard.updated = time.Now()
+		a.leaderboard.mu.Unlock()
+	}
+}
+
+// computeLeaderboard sums the hourly candles of the window per symbol across the exchanges.
+// The open and the close are averaged across the exchanges weighted by the quote volume.
+func (a *Aggregator) computeLeaderboard(now time.Time) []LeaderboardEntry {
+	timeStart := now.Add(-leaderboardWindow).Unix()
+	bySymbol := make(map[string]*LeaderboardEntry)
+
+	for exchange, source := range a.leaderboard.sources {
+		for _, symbol := range source.Symbols() {
+			candles, err := a.database.LoadCandlestickListByExchange(exchange, symbol, leaderboardInterval, timeStart, now.Unix())
+			if err != nil {
+				a.log.Errorf("Could not load %v %v candles for leaderboard: %v", exchange, symbol, err)
+				continue
+			}
+			if len(candles) == 0 {
+				continue
+			}
+
+			var volume, quoteVolume float64
+			for _, v := range candles {
+				volume += v.Volume
+				// The typical price approximates the average trade price within the candle.
+				quoteVolume += v.Volume * (v.High + v.Low + v.Close) / 3
+			}
+
+			entry, ok := bySymbol[symbol]
+			if !ok {
+				entry = &LeaderboardEntry{Symbol: symbol}
+				bySymbol[symbol] = entry
+			}
+
+			entry.Volume += volume
+			entry.QuoteVolume += quoteVolume
+			// The weighted sums are divided by the total quote volume below.
+			entry.Open += candles[0].Open * quoteVolume
+			entry.Close += candles[len(candles)-1].Close * quoteVolume
+			entry.Exchanges = append(entry.Exchanges, exchange)
+		}
+	}
+
+	entries := make([]LeaderboardEntry, 0, len(bySymbol))
+	for _, entry := range bySymbol {
+		if entry.QuoteVolume == 0 {
+			continue
+		}
+
+		entry.Open /= entry.QuoteVolume
+		entry.Close /= entry.QuoteVolume
+		if entry.Open > 0 {
+			entry.PriceChange = (entry.Close - entry.Open) / entry.Open
+		}
+		sort.Strings(entry.Exchanges)
+		entries = append(entries, *entry)
+	}
+
+	// The quote assets are priced in USD by the closes of their stablecoin pairs.
+	closes := make(map[string]float64, len(entries))
+	for _, v := range entries {
+		closes[v.Symbol] = v.Close
+	}
+
+	for i, v := range entries {
+		quote := models.QuoteAsset(v.Symbol)
+		if models.USDAssets[quote] {
+			entries[i].USDVolume = v.QuoteVolume
+			continue
+		}
+
+		for _, stablecoin := range []string{"USDT", "USDC", "BUSD"} {
+			if price, ok := closes[quote+stablecoin]; ok && price > 0 {
+				entries[i].USDVolume = v.QuoteVolume * price
+				break
+			}
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].USDVolume != entries[j].USDVolume {
+			return entries[i].USDVolume > entries[j].USDVolume
+		}
+		return entries[i].Symbol < entries[j].Symbol
+	})
+
+	return entries
+}
@@ -0,0 +1,208 @@
+package aggregator
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"price-feed/models"
+)
+
+const (
+	// SyntheticExchange is the exchange key the synthetic pairs are stored under.
+	SyntheticExchange = "synthetic"
+
+	// syntheticBackfill is the number of candles computed when the worker starts.
+	syntheticBackfill = 500
+)
+
+// defaultSyntheticIntervals are computed unless the pair lists its own intervals.
+// The daily rollups of the synthetic pairs are built from the 1h candles.
+var defaultSyntheticIntervals = []string{"1m", "5m", "15m", "30m", "1h", "4h", "1d"}
+
+// SyntheticConfig defines a pair computed from two other symbols,
+// e.g. ORNETH from the formula "ORNUSDT / ETHUSDT".
+type SyntheticConfig struct {
+	Symbol string `json:"symbol"`
+	// Formula is "A / B" or "A * B". The volume of the pair is the volume of A,
+	// so A must have the base asset of the pair.
+	Formula string `json:"formula"`
+	// Exchange selects the exchange of the legs. The candles merged across
+	// the exchanges are used by default.
+	Exchange       string   `json:"exchange"`
+	Intervals      []string `json:"intervals"`
+	UpdateInterval string   `json:"update_interval"`
+
+	left, right string
+	operator    byte
+}
+
+// parse validates the config and splits the formula into the legs.
+func (s *SyntheticConfig) parse() error {
+	if s.Symbol == "" {
+		return fmt.Errorf("synthetic pair symbol is empty")
+	}
+
+	parts := strings.Fields(s.Formula)
+	if len(parts) != 3 || (parts[1] != "/" && parts[1] != "*") {
+		return fmt.Errorf("invalid formula %q of synthetic pair %v, expected \"A / B\" or \"A * B\"", s.Formula, s.Symbol)
+	}
+	s.left, s.operator, s.right = parts[0], parts[1][0], parts[2]
+
+	if s.left == s.Symbol || s.right == s.Symbol {
+		return fmt.Errorf("synthetic pair %v refers to itself", s.Symbol)
+	}
+
+	if len(s.Intervals) == 0 {
+		s.Intervals = defaultSyntheticIntervals
+	}
+	intervals := make([]string, 0, len(s.Intervals))
+	for _, v := range s.Intervals {
+		interval, ok := models.NormalizeInterval(v)
+		if !ok {
+			return fmt.Errorf("invalid interval %v of synthetic pair %v", v, s.Symbol)
+		}
+		intervals = append(intervals, interval)
+	}
+	s.Intervals = intervals
+
+	return nil
+}
+
+// SyntheticSymbols returns the symbols of the synthetic pairs.
+func (a *Aggregator) SyntheticSymbols() []string {
+	symbols := make([]string, 0, len(a.synthetic))
+	for _, v := range a.synthetic {
+		symbols = append(symbols, v.Symbol)
+	}
+	return symbols
+}
+
+// runSynthetic computes the candles of the pair periodically, starting with a backfill.
+func (a *Aggregator) runSynthetic(s *SyntheticConfig, updateInterval time.Duration) {
+	backfill := true
+	for ; ; <-time.Tick(updateInterval) {
+		now := time.Now()
+		for _, interval := range s.Intervals {
+			// The previous candle is included so it gets its final values once closed.
+			lookback := 2 * models.IntervalDuration(interval)
+			if backfill {
+				lookback = syntheticBackfill * models.IntervalDuration(interval)
+			}
+
+			// The backfilled candles aren't live updates, so they aren't published.
+			if err := a.UpdateSynthetic(s, interval, now.Add(-lookback).Unix(), now.Unix(), !backfill); err != nil {
+				a.log.Errorf("Could not update synthetic pair %v %v: %v", s.Symbol, interval, err)
+			}
+		}
+		backfill = false
+	}
+}
+
+// UpdateSynthetic computes the candles of the pair within the range from the candles of its legs.
+// With publish, the latest candle is published to the hub.
+func (a *Aggregator) UpdateSynthetic(s *SyntheticConfig, interval string, timeStart, timeEnd int64, publish bool) error {
+	left, err := a.loadLeg(s, s.left, interval, timeStart, timeEnd)
+	if err != nil {
+		return err
+	}
+
+	right, err := a.loadLeg(s, s.right, interval, timeStart, timeEnd)
+	if err != nil {
+		return err
+	}
+
+	rightCandles := make(map[int64]models.Candle, len(right))
+	for _, v := range right {
+		rightCandles[v.TimeStart] = v
+	}
+
+	var latest models.Candle
+	for _, l := range left {
+		r, ok := rightCandles[l.TimeStart]
+		if !ok {
+			continue
+		}
+
+		candle, ok := combine(l, r, s.operator)
+		if !ok {
+			continue
+		}
+
+		if err = a.database.StoreCandlestick(SyntheticExchange, s.Symbol, interval, &candle); err != nil {
+			return errors.Wrapf(err, "could not store candlestick")
+		}
+
+		if candle.TimeStart >= latest.TimeStart {
+			latest = candle
+		}
+	}
+
+	if publish && a.hub != nil && latest.TimeStart > 0 {
+		a.hub.Publish("kline."+interval+"."+s.Symbol, models.CandleUpdate{
+			Exchange: SyntheticExchange,
+			Symbol:   s.Symbol,
+			Interval: interval,
+			Candle:   latest,
+		})
+	}
+
+	return nil
+}
+
+func (a *Aggregator) loadLeg(s *SyntheticConfig, symbol, interval string, timeStart, timeEnd int64) ([]models.Candle, error) {
+	var candles []models.Candle
+	var err error
+	if s.Exchange == "" {
+		candles, err = a.database.LoadCandlestickListAll(symbol, interval, models.MergeAverage, timeStart, timeEnd)
+	} else {
+		candles, err = a.database.LoadCandlestickListByExchange(s.Exchange, symbol, interval, timeStart, timeEnd)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not load candles of %v", symbol)
+	}
+	return candles, nil
+}
+
+// combine computes the candle of a synthetic pair. Like on charting platforms, the high
+// and low are combined leg by leg and widened to contain the open and close.
+func combine(l, r models.Candle, operator byte) (models.Candle, bool) {
+	candle := models.Candle{
+		TimeStart: l.TimeStart,
+		TimeEnd:   l.TimeEnd,
+		Time:      l.Time,
+		Volume:    l.Volume,
+		Anomalous: l.Anomalous || r.Anomalous,
+		Source:    models.CandleSourceSynthetic,
+	}
+	if r.Time > candle.Time {
+		candle.Time = r.Time
+	}
+
+	if operator == '/' {
+		if r.Open <= 0 || r.Close <= 0 || r.High <= 0 || r.Low <= 0 {
+			return models.Candle{}, false
+		}
+		candle.Open = l.Open / r.Open
+		candle.Close = l.Close / r.Close
+		candle.High = l.High / r.High
+		candle.Low = l.Low / r.Low
+	} else {
+		candle.Open = l.Open * r.Open
+		candle.Close = l.Close * r.Close
+		candle.High = l.High * r.High
+		candle.Low = l.Low * r.Low
+	}
+
+	for _, v := range []float64{candle.Open, candle.Close} {
+		if v > candle.High {
+			candle.High = v
+		}
+		if v < candle.Low {
+			candle.Low = v
+		}
+	}
+
+	return candle, true
+}
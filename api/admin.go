@@ -0,0 +1,68 @@
+package api
+
+import (
+	"net"
+	"net/http"
+	"net/http/pprof"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+)
+
+// AdminConfig represents the configuration of the admin listener.
+type AdminConfig struct {
+	// Listen is the address of the admin endpoints, e.g. 127.0.0.1:9090. They are
+	// served by the public listener only if the admin config is absent.
+	Listen string `json:"listen"`
+	// Pprof enables the profiling endpoints under /debug/pprof/.
+	Pprof bool `json:"pprof"`
+}
+
+// handleAdminRoutes registers the ops endpoints: reload, symbol management, dead letters,
+// usage, API keys, metrics and, if enabled, pprof.
+func (api *API) handleAdminRoutes(r *mux.Router) {
+	s := r.PathPrefix(v1Prefix).Subrouter()
+	s.HandleFunc("/reload", api.handleReloadRequest).Methods("POST")
+	s.HandleFunc("/subscribe", api.handleSubscribeRequest).Methods("GET")
+	s.HandleFunc("/deadLetters", api.handleDeadLettersRequest).Methods("GET")
+	s.HandleFunc("/usage", api.handleUsageRequest).Methods("GET")
+	s.HandleFunc("/apiKeys", api.handleIssueAPIKeyRequest).Methods("POST")
+	s.HandleFunc("/apiKeys", api.handleAPIKeysRequest).Methods("GET")
+	s.HandleFunc("/apiKeys/{id}", api.handleRevokeAPIKeyRequest).Methods("DELETE")
+
+	r.HandleFunc("/metrics", api.handleMetricsRequest).Methods("GET")
+
+	if api.config.Admin == nil || !api.config.Admin.Pprof {
+		return
+	}
+
+	r.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	r.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	r.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	r.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	r.PathPrefix("/debug/pprof/").HandlerFunc(pprof.Index)
+}
+
+// startAdmin binds the admin listener and serves the admin endpoints in the background.
+func (api *API) startAdmin() error {
+	listener, err := net.Listen("tcp", api.config.Admin.Listen)
+	if err != nil {
+		return errors.Wrapf(err, "could not listen on admin address %v", api.config.Admin.Listen)
+	}
+
+	r := mux.NewRouter()
+	r.Use(api.withRequestID)
+	r.Use(api.withCORS)
+	r.Use(api.withAPIKey)
+	api.handleAdminRoutes(r)
+
+	api.adminServer = &http.Server{Handler: r}
+	api.log.Infof("Serving admin endpoints on %v", api.config.Admin.Listen)
+
+	go func() {
+		if err := api.adminServer.Serve(listener); err != http.ErrServerClosed {
+			api.log.Errorf("Admin server error: %v", err)
+		}
+	}()
+	return nil
+}
@@ -1,48 +1,158 @@
 package api
 
 import (
+	"compress/gzip"
+	"context"
+	"net"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gorilla/mux"
-	"price-feed/exchanges/binance"
-	"price-feed/exchanges/bittrex"
-	"price-feed/exchanges/poloniex"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"price-feed/hub"
 	"price-feed/logger"
-	"price-feed/storage"
+	"price-feed/models"
 )
 
 const (
 	v1Prefix = "/api/v1"
+
+	defaultShutdownTimeout = 10 * time.Second
 )
 
 // Config represents an API configuration.
 type Config struct {
-	Port  int    `json:"port"`
+	Port int `json:"port"`
+	// Token is an admin API key, which issues the API keys stored in Redis.
 	Token string `json:"token"`
+	// RequireAPIKey restricts the data endpoints to the API keys with the read scope.
+	RequireAPIKey bool `json:"require_api_key"`
+	// Tenants enables API key scoping when present.
+	Tenants []*TenantConfig `json:"tenants"`
+	// Shedding enables load shedding of non-critical requests when present.
+	Shedding *SheddingConfig `json:"shedding"`
+	// RateLimit enables the rate limiting of all requests when present.
+	RateLimit *RateLimitConfig `json:"rate_limit"`
+	// ReusePort binds the port with SO_REUSEPORT, so a new binary can start
+	// serving before the old one shuts down. Requires storage persistence.
+	ReusePort bool `json:"reuse_port"`
+	// Admin moves the admin endpoints to a separate listener when present.
+	Admin *AdminConfig `json:"admin"`
+	// TLS serves HTTPS instead of HTTP when present.
+	TLS *TLSConfig `json:"tls"`
+	// GRPC enables the gRPC API when present.
+	GRPC *GRPCConfig `json:"grpc"`
+	// CompressionLevel is the gzip and deflate level of the responses, from 1 to 9;
+	// 6 by default and -1 disables compression.
+	CompressionLevel int `json:"compression_level"`
+	// ShutdownTimeout is how long the pending requests are drained on shutdown
+	// before the connections are closed, 10s by default.
+	ShutdownTimeout string `json:"shutdown_timeout"`
 }
 
 // API represents a REST API server instance.
 type API struct {
 	config   *Config
 	log      *logger.Logger
-	storage  *storage.Client
-	binance  *binance.Worker
-	bittrex  *bittrex.Worker
-	poloniex *poloniex.Worker
+	storage  CandleStore
+	binance  OrderBookExchange
+	bittrex  SymbolRegistry
+	poloniex SymbolRegistry
+	// optional holds the exchanges enabled in the config, e.g. coinbase, by name.
+	optional   map[string]OrderBookExchange
+	aggregator IndexProvider
+	hub        *hub.Hub
+	// webhooks is nil if the webhooks are disabled.
+	webhooks WebhookRegistry
+	tenants  map[string]*tenant
+	keys     *apiKeyRing
+	tickers  *tickerMap
+	// tracer serves the debug requests, it is nil if the storage can't trace queries.
+	tracer QueryTracer
+	// compressors are the response content codings in the order of preference.
+	compressors []*compressor
+	// limiter is nil if the rate limiting is disabled.
+	limiter *rateLimiter
+	// openAPI is the spec of the routes, generated on start.
+	openAPI []byte
+
+	maxEventLag     time.Duration
+	shutdownTimeout time.Duration
+	server          *http.Server
+	adminServer     *http.Server
+	// grpcServer is nil if the gRPC API is disabled.
+	grpcServer *grpc.Server
 }
 
 // New returns a new API instance.
-func New(config *Config, log *logger.Logger, storage *storage.Client,
-	binance *binance.Worker, bittrex *bittrex.Worker, poloniex *poloniex.Worker) *API {
+func New(config *Config, log *logger.Logger, storage CandleStore,
+	binance OrderBookExchange, bittrex, poloniex SymbolRegistry, optional map[string]OrderBookExchange,
+	aggregator IndexProvider, hub *hub.Hub, webhooks WebhookRegistry) *API {
 
 	api := &API{
-		config:   config,
-		log:      log,
-		storage:  storage,
-		binance:  binance,
-		bittrex:  bittrex,
-		poloniex: poloniex,
+		config:     config,
+		log:        log,
+		storage:    newCachingStore(storage),
+		binance:    binance,
+		bittrex:    bittrex,
+		poloniex:   poloniex,
+		optional:   optional,
+		aggregator: aggregator,
+		hub:        hub,
+		webhooks:   webhooks,
+		tenants:    newTenants(config.Tenants),
+		keys:       &apiKeyRing{keys: make(map[string]models.APIKey)},
+		tickers:    newTickerMap(),
+		// The server is created up front, so a shutdown during the start
+		// makes Serve return instead of racing with it.
+		server:          &http.Server{},
+		shutdownTimeout: defaultShutdownTimeout,
+	}
+
+	if tracer, ok := storage.(QueryTracer); ok {
+		api.tracer = tracer
+	}
+
+	if config.Shedding != nil && config.Shedding.MaxEventLag != "" {
+		lag, err := time.ParseDuration(config.Shedding.MaxEventLag)
+		if err != nil {
+			log.Warnf("Could not parse max event lag, lag shedding disabled: %v", err)
+		}
+		api.maxEventLag = lag
+	}
+
+	if config.ShutdownTimeout != "" {
+		timeout, err := time.ParseDuration(config.ShutdownTimeout)
+		if err != nil || timeout <= 0 {
+			log.Warnf("Invalid shutdown timeout %q, using the default one", config.ShutdownTimeout)
+		} else {
+			api.shutdownTimeout = timeout
+		}
+	}
+
+	if config.GRPC != nil {
+		api.grpcServer = newGRPCServer(api)
+	}
+
+	switch {
+	case config.RateLimit != nil:
+		api.limiter = newRateLimiter(config.RateLimit)
+	case tenantRateLimits(api.tenants):
+		// The tenant rate limits share the buckets of the per key limits.
+		api.limiter = newRateLimiter(&RateLimitConfig{})
+	}
+
+	switch level := config.CompressionLevel; {
+	case level == -1:
+	case level == 0:
+		api.compressors = newCompressors(gzip.DefaultCompression)
+	case level >= gzip.BestSpeed && level <= gzip.BestCompression:
+		api.compressors = newCompressors(level)
+	default:
+		log.Warnf("Invalid compression level %v, using the default one", level)
+		api.compressors = newCompressors(gzip.DefaultCompression)
 	}
 
 	return api
@@ -52,12 +162,109 @@ func New(config *Config, log *logger.Logger, storage *storage.Client,
 func (api *API) Start() error {
 	api.log.Infof("Starting API")
 
+	go api.watchTickers()
+	go api.watchAPIKeys()
+	if api.limiter != nil {
+		go api.limiter.sweep()
+	}
+
 	r := mux.NewRouter()
+	r.Use(api.withRequestID)
+	r.Use(api.withCORS)
+	r.Use(api.withRateLimit)
+	r.Use(api.withAPIKey)
+	r.Use(api.withCompression)
+	r.Use(api.withDegradedWarning)
+	r.Use(api.withTracing)
 	s := r.PathPrefix(v1Prefix).Subrouter()
 
-	s.HandleFunc("/orderBook", api.handleOrderBookRequest).Methods("GET")
-	s.HandleFunc("/candles", api.handleCandlestickRequest).Methods("GET")
-	s.HandleFunc("/reload", api.handleReloadRequest).Methods("GET")
+	s.HandleFunc("/orderBook", api.tenantScoped(api.handleOrderBookRequest)).Methods("GET")
+	s.HandleFunc("/orderBook/consolidated", api.tenantScoped(api.shed(api.handleConsolidatedOrderBookRequest))).Methods("GET")
+	s.HandleFunc("/bbo", api.tenantScoped(api.handleBBORequest)).Methods("GET")
+	s.HandleFunc("/candles", api.tenantScoped(api.shed(api.handleCandlestickRequest))).Methods("GET")
+	s.HandleFunc("/candles/latest", api.tenantScoped(api.handleLatestCandleRequest)).Methods("GET")
+	s.HandleFunc("/index/{name}", api.tenantScoped(api.shed(api.handleIndexRequest))).Methods("GET")
+	s.HandleFunc("/volatility", api.tenantScoped(api.shed(api.handleVolatilityRequest))).Methods("GET")
+	s.HandleFunc("/volume", api.tenantScoped(api.shed(api.handleVolumeRequest))).Methods("GET")
+	s.HandleFunc("/tape", api.tenantScoped(api.shed(api.handleTapeRequest))).Methods("GET")
+	s.HandleFunc("/trades", api.tenantScoped(api.handleTradesRequest)).Methods("GET")
+	s.HandleFunc("/twap", api.tenantScoped(api.shed(api.handleTWAPRequest))).Methods("GET")
+	s.HandleFunc("/export", api.tenantScoped(api.shed(api.handleExportRequest))).Methods("GET")
+	s.HandleFunc("/leaderboard", api.tenantScoped(api.handleLeaderboardRequest)).Methods("GET")
+	s.HandleFunc("/prices", api.tenantScoped(api.handlePricesRequest)).Methods("GET")
+	s.HandleFunc("/symbols", api.tenantScoped(api.handleSymbolsRequest)).Methods("GET")
+	s.HandleFunc("/ws", api.tenantScoped(api.handleWSRequest)).Methods("GET")
+	s.HandleFunc("/stream", api.tenantScoped(api.handleStreamRequest)).Methods("GET")
+	if api.webhooks != nil {
+		s.HandleFunc("/webhooks", api.tenantScoped(api.handleRegisterWebhookRequest)).Methods("POST")
+		s.HandleFunc("/webhooks", api.tenantScoped(api.handleWebhooksRequest)).Methods("GET")
+		s.HandleFunc("/webhooks/{id}", api.tenantScoped(api.handleDeleteWebhookRequest)).Methods("DELETE")
+	}
+	s.HandleFunc("/schemas", api.handleSchemasRequest).Methods("GET")
+	s.HandleFunc("/schemas/{name}", api.handleSchemaRequest).Methods("GET")
+	s.HandleFunc("/status", api.handleStatusRequest).Methods("GET")
+	s.HandleFunc("/openapi.json", api.handleOpenAPIRequest).Methods("GET")
+
+	if api.config.Admin == nil {
+		api.handleAdminRoutes(r)
+	} else if err := api.startAdmin(); err != nil {
+		return err
+	}
 
-	return http.ListenAndServe(":"+strconv.Itoa(api.config.Port), r)
+	spec, err := api.generateOpenAPI(r)
+	if err != nil {
+		return errors.Wrapf(err, "could not generate OpenAPI spec")
+	}
+	api.openAPI = spec
+
+	lc := net.ListenConfig{}
+	if api.config.ReusePort {
+		lc.Control = setReusePort
+	}
+
+	listener, err := lc.Listen(context.Background(), "tcp", ":"+strconv.Itoa(api.config.Port))
+	if err != nil {
+		return errors.Wrapf(err, "could not listen on port %v", api.config.Port)
+	}
+
+	api.server.Handler = r
+
+	if api.config.TLS != nil {
+		if api.server.TLSConfig, err = api.tlsConfig(); err != nil {
+			listener.Close()
+			return errors.Wrapf(err, "could not configure TLS")
+		}
+		err = api.server.ServeTLS(listener, "", "")
+	} else {
+		err = api.server.Serve(listener)
+	}
+
+	if err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// Shutdown stops accepting connections, asks the WS clients to reconnect
+// and waits for the pending requests until the shutdown timeout passes or
+// the context is done, then closes the remaining connections.
+func (api *API) Shutdown(ctx context.Context) error {
+	api.log.Infof("Shutting down API, draining requests for %v", api.shutdownTimeout)
+
+	ctx, cancel := context.WithTimeout(ctx, api.shutdownTimeout)
+	defer cancel()
+
+	api.hub.CloseAll()
+
+	if api.adminServer != nil {
+		if err := api.adminServer.Shutdown(ctx); err != nil {
+			api.log.Errorf("Could not shut down admin server gracefully: %v", err)
+		}
+	}
+
+	if err := api.server.Shutdown(ctx); err != nil {
+		api.server.Close()
+		return errors.Wrapf(err, "could not drain pending requests")
+	}
+	return nil
 }
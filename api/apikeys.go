@@ -0,0 +1,342 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"price-feed/models"
+)
+
+const (
+	// apiKeyRefreshInterval bounds the time a key issued or revoked by another instance
+	// takes to be picked up.
+	apiKeyRefreshInterval = 10 * time.Second
+	maxAPIKeyRequestSize  = 1 << 12
+)
+
+// configKey is the key of the admin token of the config, which is needed to issue the first keys.
+var configKey = models.APIKey{ID: "config", Name: "config token", Scopes: []string{models.ScopeAdmin}}
+
+type apiKeyRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+type apiKeysResponse struct {
+	Keys []models.APIKey `json:"keys"`
+}
+
+type apiKeyContextKey struct{}
+
+type authContextKey struct{}
+
+// authResult is the authentication of a request, which is done once and shared by the
+// rate limiting and the API key middlewares.
+type authResult struct {
+	key models.APIKey
+	// tenant is nil unless the key is a tenant key of the config.
+	tenant *tenant
+	found  bool
+	ok     bool
+}
+
+// apiKeyRing caches the API keys of the storage by their hashes.
+type apiKeyRing struct {
+	mu   sync.RWMutex
+	keys map[string]models.APIKey
+}
+
+func (k *apiKeyRing) lookup(hash string) (models.APIKey, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	v, ok := k.keys[hash]
+	return v, ok
+}
+
+func (k *apiKeyRing) set(keys map[string]models.APIKey) {
+	k.mu.Lock()
+	k.keys = keys
+	k.mu.Unlock()
+}
+
+func (k *apiKeyRing) add(hash string, key models.APIKey) {
+	k.mu.Lock()
+	k.keys[hash] = key
+	k.mu.Unlock()
+}
+
+func (k *apiKeyRing) remove(hash string) {
+	k.mu.Lock()
+	delete(k.keys, hash)
+	k.mu.Unlock()
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// watchAPIKeys periodically reloads the API keys from the storage.
+func (api *API) watchAPIKeys() {
+	for ; ; <-time.Tick(apiKeyRefreshInterval) {
+		keys, err := api.storage.LoadAPIKeys()
+		if err != nil {
+			api.log.Errorf("Could not load API keys: %v", err)
+			continue
+		}
+		api.keys.set(keys)
+	}
+}
+
+// bearerKey returns the key of the Authorization header, empty if there is none.
+func bearerKey(r *http.Request) string {
+	const prefix = "Bearer "
+	if v := r.Header.Get("Authorization"); len(v) > len(prefix) && strings.EqualFold(v[:len(prefix)], prefix) {
+		return strings.TrimSpace(v[len(prefix):])
+	}
+	return ""
+}
+
+// authenticate looks up the key of the Authorization header, or else of the X-API-Key header
+// or the apiKey query parameter, among the admin token, the tenants of the config and the
+// keys stored in Redis. The result isn't ok if the request carries an unknown key.
+// The result is attached to the request, so the key is looked up once per request.
+func (api *API) authenticate(r *http.Request) (*http.Request, authResult) {
+	if result, ok := r.Context().Value(authContextKey{}).(authResult); ok {
+		return r, result
+	}
+
+	result := api.lookupAPIKey(r)
+	return r.WithContext(context.WithValue(r.Context(), authContextKey{}, result)), result
+}
+
+func (api *API) lookupAPIKey(r *http.Request) authResult {
+	secret := bearerKey(r)
+	if secret == "" {
+		secret = requestAPIKey(r)
+	}
+	return api.lookupSecret(secret)
+}
+
+// lookupSecret looks up the key of the secret, the result is ok without a secret.
+func (api *API) lookupSecret(secret string) authResult {
+	if secret == "" {
+		return authResult{ok: true}
+	}
+
+	if api.config.Token != "" && subtle.ConstantTimeCompare([]byte(secret), []byte(api.config.Token)) == 1 {
+		return authResult{key: configKey, found: true, ok: true}
+	}
+
+	// The keys are looked up by their hashes, so the lookups don't leak their secrets.
+	hash := hashAPIKey(secret)
+	if t, ok := api.tenants[hash]; ok {
+		return authResult{key: t.key, tenant: t, found: true, ok: true}
+	}
+	if key, ok := api.keys.lookup(hash); ok {
+		return authResult{key: key, found: true, ok: true}
+	}
+	return authResult{}
+}
+
+// withAPIKey authenticates the requests carrying an API key, rejecting the unknown keys,
+// and attaches the key and its tenant to the request context.
+func (api *API) withAPIKey(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r, result := api.authenticate(r)
+		if !result.ok {
+			writeError(w, http.StatusUnauthorized, "api key is invalid")
+			return
+		}
+		if result.found {
+			ctx := context.WithValue(r.Context(), apiKeyContextKey{}, result.key)
+			if result.tenant != nil {
+				ctx = context.WithValue(ctx, tenantContextKey{}, result.tenant)
+			}
+			r = r.WithContext(ctx)
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireScope verifies the API key of the request grants the scope and writes
+// an error response if it doesn't.
+func (api *API) requireScope(w http.ResponseWriter, r *http.Request, scope string) bool {
+	key, ok := r.Context().Value(apiKeyContextKey{}).(models.APIKey)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "no api key specified")
+		return false
+	}
+
+	if !key.HasScope(scope) {
+		writeErrorDetails(w, http.StatusForbidden, errorForbidden, fmt.Sprintf("api key lacks the %v scope", scope),
+			map[string]interface{}{"scope": scope})
+		return false
+	}
+
+	return true
+}
+
+func (api *API) handleIssueAPIKeyRequest(w http.ResponseWriter, r *http.Request) {
+	if !api.requireScope(w, r, models.ScopeAdmin) {
+		return
+	}
+
+	var req apiKeyRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAPIKeyRequestSize)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "could not parse api key: "+err.Error())
+		return
+	}
+
+	if req.Name == "" {
+		writeError(w, http.StatusBadRequest, "no name specified")
+		return
+	}
+	if len(req.Scopes) == 0 {
+		req.Scopes = []string{models.ScopeRead}
+	}
+	for _, v := range req.Scopes {
+		if !models.IsValidScope(v) {
+			writeError(w, http.StatusBadRequest, "scopes should be read or admin")
+			return
+		}
+	}
+
+	key, err := api.issueAPIKey(req.Name, req.Scopes)
+	if err != nil {
+		api.requestLog(r).Errorf("Could not issue API key: %v", err)
+		api.writeStorageError(w, "could not issue api key")
+		return
+	}
+	api.requestLog(r).Infof("Issued API key %v (%v) with scopes %v", key.ID, key.Name, key.Scopes)
+
+	data, err := json.Marshal(key)
+	if err != nil {
+		api.requestLog(r).Errorf("Could not marshal json: %v", err)
+		writeError(w, http.StatusInternalServerError, "could not issue api key")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if _, err = w.Write(data); err != nil {
+		api.requestLog(r).Errorf("Could not write response: %v", err)
+		return
+	}
+}
+
+// issueAPIKey generates and stores a new API key, which is returned with its secret.
+func (api *API) issueAPIKey(name string, scopes []string) (models.APIKey, error) {
+	id, err := randomHex(8)
+	if err != nil {
+		return models.APIKey{}, err
+	}
+
+	secret, err := randomHex(32)
+	if err != nil {
+		return models.APIKey{}, err
+	}
+
+	key := models.APIKey{
+		ID:      id,
+		Name:    name,
+		Scopes:  scopes,
+		Created: time.Now().Unix(),
+	}
+
+	hash := hashAPIKey(secret)
+	if err = api.storage.StoreAPIKey(hash, key); err != nil {
+		return models.APIKey{}, err
+	}
+	api.keys.add(hash, key)
+
+	key.Key = secret
+	return key, nil
+}
+
+func (api *API) handleAPIKeysRequest(w http.ResponseWriter, r *http.Request) {
+	if !api.requireScope(w, r, models.ScopeAdmin) {
+		return
+	}
+
+	stored, err := api.storage.LoadAPIKeys()
+	if err != nil {
+		api.requestLog(r).Errorf("Could not load API keys: %v", err)
+		api.writeStorageError(w, "could not load api keys")
+		return
+	}
+
+	keys := make([]models.APIKey, 0, len(stored))
+	for _, v := range stored {
+		keys = append(keys, v)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Created < keys[j].Created })
+
+	data, err := json.Marshal(apiKeysResponse{Keys: keys})
+	if err != nil {
+		api.requestLog(r).Errorf("Could not marshal json: %v", err)
+		writeError(w, http.StatusInternalServerError, "could not load api keys")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if _, err = w.Write(data); err != nil {
+		api.requestLog(r).Errorf("Could not write response: %v", err)
+		return
+	}
+}
+
+func (api *API) handleRevokeAPIKeyRequest(w http.ResponseWriter, r *http.Request) {
+	if !api.requireScope(w, r, models.ScopeAdmin) {
+		return
+	}
+
+	stored, err := api.storage.LoadAPIKeys()
+	if err != nil {
+		api.requestLog(r).Errorf("Could not load API keys: %v", err)
+		api.writeStorageError(w, "could not revoke api key")
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	for hash, v := range stored {
+		if v.ID != id {
+			continue
+		}
+
+		if _, err = api.storage.DeleteAPIKey(hash); err != nil {
+			api.requestLog(r).Errorf("Could not delete API key: %v", err)
+			api.writeStorageError(w, "could not revoke api key")
+			return
+		}
+		api.keys.remove(hash)
+
+		api.requestLog(r).Infof("Revoked API key %v (%v)", v.ID, v.Name)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	writeError(w, http.StatusNotFound, "api key not found")
+}
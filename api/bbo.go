@@ -0,0 +1,123 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+
+	"price-feed/models"
+)
+
+type bboItem struct {
+	models.BBO
+	// Spread is the ask price minus the bid price, SpreadBps relative to the mid price.
+	Spread    float64 `json:"spread"`
+	SpreadBps float64 `json:"spreadBps"`
+}
+
+// compositeBBO is the best bid and the best ask across the exchanges. Its spread is
+// negative if the books of two exchanges cross.
+type compositeBBO struct {
+	BidExchange string  `json:"bidExchange"`
+	BidPrice    float64 `json:"bidPrice"`
+	BidSize     float64 `json:"bidSize"`
+	AskExchange string  `json:"askExchange"`
+	AskPrice    float64 `json:"askPrice"`
+	AskSize     float64 `json:"askSize"`
+	Spread      float64 `json:"spread"`
+	SpreadBps   float64 `json:"spreadBps"`
+}
+
+type bboResponse struct {
+	Symbol    string       `json:"symbol"`
+	Exchanges []bboItem    `json:"exchanges"`
+	Composite compositeBBO `json:"composite"`
+}
+
+// orderBookProviders returns the exchanges maintaining order books, by name.
+func (api *API) orderBookProviders() map[string]OrderBookProvider {
+	providers := map[string]OrderBookProvider{"binance": api.binance}
+	for name, v := range api.optional {
+		providers[name] = v
+	}
+	return providers
+}
+
+// handleBBORequest returns the best bid and offer of the symbol on every exchange with a live
+// order book and the composite one, taken from the in-memory order books.
+func (api *API) handleBBORequest(w http.ResponseWriter, r *http.Request) {
+	symbol := r.URL.Query().Get("symbol")
+	if symbol == "" {
+		writeError(w, http.StatusBadRequest, "no pair specified")
+		return
+	}
+
+	resp := bboResponse{
+		Symbol:    symbol,
+		Exchanges: make([]bboItem, 0),
+	}
+
+	for name, provider := range api.orderBookProviders() {
+		if !allowedExchange(r, name) {
+			continue
+		}
+
+		orderBook, ok := provider.GetOrderBook(symbol)
+		if !ok {
+			continue
+		}
+
+		bbo := orderBook.BestBidOffer()
+		if bbo.BidPrice == 0 || bbo.AskPrice == 0 {
+			continue
+		}
+		bbo.Exchange = name
+		bbo.Symbol = symbol
+
+		resp.Exchanges = append(resp.Exchanges, bboItem{
+			BBO:       bbo,
+			Spread:    bbo.AskPrice - bbo.BidPrice,
+			SpreadBps: spreadBps(bbo.BidPrice, bbo.AskPrice),
+		})
+	}
+
+	if len(resp.Exchanges) == 0 {
+		writeError(w, http.StatusNotFound, "no order books for the pair")
+		return
+	}
+
+	sort.Slice(resp.Exchanges, func(i, j int) bool {
+		return resp.Exchanges[i].Spread < resp.Exchanges[j].Spread
+	})
+
+	c := &resp.Composite
+	for _, v := range resp.Exchanges {
+		if v.BidPrice > c.BidPrice {
+			c.BidExchange, c.BidPrice, c.BidSize = v.Exchange, v.BidPrice, v.BidSize
+		}
+		if c.AskPrice == 0 || v.AskPrice < c.AskPrice {
+			c.AskExchange, c.AskPrice, c.AskSize = v.Exchange, v.AskPrice, v.AskSize
+		}
+	}
+	c.Spread = c.AskPrice - c.BidPrice
+	c.SpreadBps = spreadBps(c.BidPrice, c.AskPrice)
+
+	data, err := json.Marshal(resp)
+	if err != nil {
+		api.requestLog(r).Errorf("Could not marshal json: %v", err)
+		writeError(w, http.StatusInternalServerError, "could not load best bid and offer")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if _, err = w.Write(data); err != nil {
+		api.requestLog(r).Errorf("Could not write response: %v", err)
+		return
+	}
+}
+
+// spreadBps returns the spread in basis points of the mid price.
+func spreadBps(bid, ask float64) float64 {
+	return (ask - bid) / ((ask + bid) / 2) * 10000
+}
@@ -0,0 +1,93 @@
+package api
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"price-feed/models"
+)
+
+func TestHandleBBORequest(t *testing.T) {
+	binance := &fakeExchange{orderBooks: map[string]models.OrderBookInternal{
+		"BTCUSDT": {
+			Bids: map[string]string{"99": "1", "98": "2"},
+			Asks: map[string]string{"101": "3", "102": "4"},
+		},
+		"ETHUSDT": {
+			Bids: map[string]string{"9": "1"},
+		},
+	}}
+	coinbase := &fakeExchange{orderBooks: map[string]models.OrderBookInternal{
+		"BTCUSDT": {
+			Bids: map[string]string{"99.5": "5"},
+			Asks: map[string]string{"100.5": "6"},
+		},
+	}}
+
+	tests := []struct {
+		name   string
+		target string
+		status int
+		code   string
+		want   bboResponse
+	}{
+		{
+			name:   "no symbol",
+			target: "/api/v1/bbo",
+			status: http.StatusBadRequest,
+			code:   errorInvalidRequest,
+		},
+		{
+			name:   "unknown symbol",
+			target: "/api/v1/bbo?symbol=XRPUSDT",
+			status: http.StatusNotFound,
+			code:   errorNotFound,
+		},
+		{
+			name:   "one sided book",
+			target: "/api/v1/bbo?symbol=ETHUSDT",
+			status: http.StatusNotFound,
+			code:   errorNotFound,
+		},
+		{
+			name:   "composite of the exchanges",
+			target: "/api/v1/bbo?symbol=BTCUSDT",
+			status: http.StatusOK,
+			want: bboResponse{
+				Symbol: "BTCUSDT",
+				Exchanges: []bboItem{
+					{
+						BBO: models.BBO{Exchange: "coinbase", Symbol: "BTCUSDT",
+							BidPrice: 99.5, BidSize: 5, AskPrice: 100.5, AskSize: 6, Mid: 100},
+						Spread:    1,
+						SpreadBps: spreadBps(99.5, 100.5),
+					},
+					{
+						BBO: models.BBO{Exchange: "binance", Symbol: "BTCUSDT",
+							BidPrice: 99, BidSize: 1, AskPrice: 101, AskSize: 3, Mid: 100},
+						Spread:    2,
+						SpreadBps: spreadBps(99, 101),
+					},
+				},
+				Composite: compositeBBO{
+					BidExchange: "coinbase", BidPrice: 99.5, BidSize: 5,
+					AskExchange: "coinbase", AskPrice: 100.5, AskSize: 6,
+					Spread: 1, SpreadBps: spreadBps(99.5, 100.5),
+				},
+			},
+		},
+	}
+
+	api := newTestAPI(&fakeStore{}, binance, map[string]OrderBookExchange{"coinbase": coinbase})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var resp bboResponse
+			checkResponse(t, serve(api.handleBBORequest, tt.target), tt.status, tt.code, &resp)
+
+			if tt.status == http.StatusOK && !reflect.DeepEqual(resp, tt.want) {
+				t.Errorf("bbo = %+v, want %+v", resp, tt.want)
+			}
+		})
+	}
+}
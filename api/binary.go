@@ -0,0 +1,261 @@
+package api
+
+import (
+	"encoding/binary"
+	"math"
+	"net/http"
+	"strings"
+
+	"price-feed/models"
+)
+
+// Binary media types of the /candles and /orderBook responses. The protobuf messages
+// are CandlesResponse and OrderBook of proto/pricefeed.proto, the msgpack ones mirror
+// the JSON responses.
+const (
+	mediaTypeProtobuf = "application/x-protobuf"
+	mediaTypeMsgpack  = "application/msgpack"
+)
+
+var binaryMediaTypes = map[string]string{
+	"application/x-protobuf":  mediaTypeProtobuf,
+	"application/protobuf":    mediaTypeProtobuf,
+	"application/msgpack":     mediaTypeMsgpack,
+	"application/x-msgpack":   mediaTypeMsgpack,
+	"application/vnd.msgpack": mediaTypeMsgpack,
+}
+
+// negotiateBinary returns the first binary media type listed in the Accept header,
+// empty for JSON. Quality values aren't weighed: a client asks for a binary encoding
+// by listing it, and anything else is answered with JSON.
+func negotiateBinary(r *http.Request) string {
+	for _, v := range strings.Split(r.Header.Get("Accept"), ",") {
+		if i := strings.IndexByte(v, ';'); i >= 0 {
+			v = v[:i]
+		}
+		if mediaType, ok := binaryMediaTypes[strings.ToLower(strings.TrimSpace(v))]; ok {
+			return mediaType
+		}
+	}
+	return ""
+}
+
+// writeBinary writes a response encoded with the negotiated media type.
+func (api *API) writeBinary(w http.ResponseWriter, r *http.Request, mediaType string, data []byte) {
+	w.Header().Set("Content-Type", mediaType)
+	w.Header().Add("Vary", "Accept")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(data); err != nil {
+		api.requestLog(r).Errorf("Could not write response: %v", err)
+	}
+}
+
+// encodeCandles encodes the candles response with the media type.
+func encodeCandles(mediaType string, resp models.CandlestickResponse) []byte {
+	if mediaType == mediaTypeProtobuf {
+		var b []byte
+		b = appendProtoInt(b, 1, resp.TimeStart)
+		b = appendProtoInt(b, 2, resp.TimeEnd)
+		for i := range resp.Candles {
+			b = appendProtoMessage(b, 3, protoCandle(&resp.Candles[i]))
+		}
+		return appendProtoString(b, 4, resp.NextCursor)
+	}
+
+	fields := 3
+	if resp.NextCursor != "" {
+		fields++
+	}
+	b := appendMsgpackMap(nil, fields)
+	b = appendMsgpackInt(appendMsgpackString(b, "timeStart"), resp.TimeStart)
+	b = appendMsgpackInt(appendMsgpackString(b, "timeEnd"), resp.TimeEnd)
+	b = appendMsgpackArray(appendMsgpackString(b, "candles"), len(resp.Candles))
+	for i := range resp.Candles {
+		b = msgpackCandle(b, &resp.Candles[i])
+	}
+	if resp.NextCursor != "" {
+		b = appendMsgpackString(appendMsgpackString(b, "nextCursor"), resp.NextCursor)
+	}
+	return b
+}
+
+func protoCandle(c *models.Candle) []byte {
+	var b []byte
+	b = appendProtoInt(b, 1, c.TimeStart)
+	b = appendProtoInt(b, 2, c.TimeEnd)
+	b = appendProtoInt(b, 3, c.Time)
+	b = appendProtoDouble(b, 4, c.Open)
+	b = appendProtoDouble(b, 5, c.Close)
+	b = appendProtoDouble(b, 6, c.High)
+	b = appendProtoDouble(b, 7, c.Low)
+	b = appendProtoDouble(b, 8, c.Volume)
+	b = appendProtoBool(b, 9, c.Anomalous)
+	return appendProtoString(b, 10, c.Source)
+}
+
+func msgpackCandle(b []byte, c *models.Candle) []byte {
+	fields := 8
+	if c.Anomalous {
+		fields++
+	}
+	if c.Source != "" {
+		fields++
+	}
+
+	b = appendMsgpackMap(b, fields)
+	b = appendMsgpackInt(appendMsgpackString(b, "timeStart"), c.TimeStart)
+	b = appendMsgpackInt(appendMsgpackString(b, "timeEnd"), c.TimeEnd)
+	b = appendMsgpackInt(appendMsgpackString(b, "time"), c.Time)
+	b = appendMsgpackFloat(appendMsgpackString(b, "open"), c.Open)
+	b = appendMsgpackFloat(appendMsgpackString(b, "close"), c.Close)
+	b = appendMsgpackFloat(appendMsgpackString(b, "high"), c.High)
+	b = appendMsgpackFloat(appendMsgpackString(b, "low"), c.Low)
+	b = appendMsgpackFloat(appendMsgpackString(b, "volume"), c.Volume)
+	if c.Anomalous {
+		b = appendMsgpackBool(appendMsgpackString(b, "anomalous"), true)
+	}
+	if c.Source != "" {
+		b = appendMsgpackString(appendMsgpackString(b, "source"), c.Source)
+	}
+	return b
+}
+
+// encodeOrderBook encodes the order book response of the exchange with the media type.
+func encodeOrderBook(mediaType, exchange string, resp orderBookResponseInternal) []byte {
+	if mediaType == mediaTypeProtobuf {
+		var b []byte
+		b = appendProtoString(b, 1, exchange)
+		b = appendProtoString(b, 2, resp.Symbol)
+		for _, side := range []struct {
+			field  int
+			levels []models.AskBid
+		}{{3, resp.Bids}, {4, resp.Asks}} {
+			for _, v := range side.levels {
+				level := appendProtoDouble(nil, 1, v.Price)
+				level = appendProtoDouble(level, 2, v.Size)
+				b = appendProtoMessage(b, side.field, level)
+			}
+		}
+		return appendProtoDouble(b, 6, resp.GroupBy)
+	}
+
+	fields := 3
+	if resp.GroupBy != 0 {
+		fields++
+	}
+	b := appendMsgpackMap(nil, fields)
+	b = appendMsgpackString(appendMsgpackString(b, "symbol"), resp.Symbol)
+	if resp.GroupBy != 0 {
+		b = appendMsgpackFloat(appendMsgpackString(b, "groupBy"), resp.GroupBy)
+	}
+	for _, side := range []struct {
+		name   string
+		levels []models.AskBid
+	}{{"asks", resp.Asks}, {"bids", resp.Bids}} {
+		b = appendMsgpackArray(appendMsgpackString(b, side.name), len(side.levels))
+		for _, v := range side.levels {
+			b = appendMsgpackMap(b, 2)
+			b = appendMsgpackFloat(appendMsgpackString(b, "size"), v.Size)
+			b = appendMsgpackFloat(appendMsgpackString(b, "price"), v.Price)
+		}
+	}
+	return b
+}
+
+// Protobuf wire types.
+const (
+	protoVarint  = 0
+	protoFixed64 = 1
+	protoBytes   = 2
+)
+
+// The appendProto functions skip the zero values like proto3 does.
+
+func appendProtoTag(b []byte, field, wireType int) []byte {
+	return binary.AppendUvarint(b, uint64(field)<<3|uint64(wireType))
+}
+
+func appendProtoInt(b []byte, field int, v int64) []byte {
+	if v == 0 {
+		return b
+	}
+	return binary.AppendUvarint(appendProtoTag(b, field, protoVarint), uint64(v))
+}
+
+func appendProtoBool(b []byte, field int, v bool) []byte {
+	if !v {
+		return b
+	}
+	return append(appendProtoTag(b, field, protoVarint), 1)
+}
+
+func appendProtoDouble(b []byte, field int, v float64) []byte {
+	if v == 0 {
+		return b
+	}
+	return binary.LittleEndian.AppendUint64(appendProtoTag(b, field, protoFixed64), math.Float64bits(v))
+}
+
+func appendProtoString(b []byte, field int, v string) []byte {
+	if v == "" {
+		return b
+	}
+	b = binary.AppendUvarint(appendProtoTag(b, field, protoBytes), uint64(len(v)))
+	return append(b, v...)
+}
+
+// appendProtoMessage appends an embedded message, which is kept even if empty
+// as an element of a repeated field.
+func appendProtoMessage(b []byte, field int, msg []byte) []byte {
+	b = binary.AppendUvarint(appendProtoTag(b, field, protoBytes), uint64(len(msg)))
+	return append(b, msg...)
+}
+
+func appendMsgpackMap(b []byte, n int) []byte {
+	if n < 16 {
+		return append(b, 0x80|byte(n))
+	}
+	return binary.BigEndian.AppendUint16(append(b, 0xde), uint16(n))
+}
+
+func appendMsgpackArray(b []byte, n int) []byte {
+	switch {
+	case n < 16:
+		return append(b, 0x90|byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, 0xdc), uint16(n))
+	}
+	return binary.BigEndian.AppendUint32(append(b, 0xdd), uint32(n))
+}
+
+func appendMsgpackString(b []byte, v string) []byte {
+	switch n := len(v); {
+	case n < 32:
+		b = append(b, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		b = append(b, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		b = binary.BigEndian.AppendUint16(append(b, 0xda), uint16(n))
+	default:
+		b = binary.BigEndian.AppendUint32(append(b, 0xdb), uint32(n))
+	}
+	return append(b, v...)
+}
+
+func appendMsgpackInt(b []byte, v int64) []byte {
+	if v >= -32 && v <= math.MaxInt8 {
+		return append(b, byte(v))
+	}
+	return binary.BigEndian.AppendUint64(append(b, 0xd3), uint64(v))
+}
+
+func appendMsgpackFloat(b []byte, v float64) []byte {
+	return binary.BigEndian.AppendUint64(append(b, 0xcb), math.Float64bits(v))
+}
+
+func appendMsgpackBool(b []byte, v bool) []byte {
+	if v {
+		return append(b, 0xc3)
+	}
+	return append(b, 0xc2)
+}
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"strings"
+	"time"
+
+	"price-feed/models"
+)
+
+// candlesETag returns the validators of a candles response. The weak ETag is keyed on
+// the query, the negotiated encoding and the latest stored score, i.e. the start of the
+// last candle, along with the count and the last update of the candles, so an updated
+// or trimmed candle changes it too. The echoed range of a range relative to now doesn't.
+func candlesETag(r *http.Request, mediaType string, candles []models.Candle) (string, time.Time) {
+	var latestScore, modified int64
+	for i := range candles {
+		if candles[i].TimeStart > latestScore {
+			latestScore = candles[i].TimeStart
+		}
+		if candles[i].Time > modified {
+			modified = candles[i].Time
+		}
+	}
+
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%v|%v|%v|%v|%v", r.URL.Query().Encode(), mediaType, len(candles), latestScore, modified)
+	return fmt.Sprintf(`W/"%x"`, h.Sum64()), time.Unix(modified, 0)
+}
+
+// notModified sets the validators of the response and answers 304 Not Modified
+// if the client has the current representation.
+func notModified(w http.ResponseWriter, r *http.Request, etag string, modified time.Time) bool {
+	w.Header().Set("ETag", etag)
+	if modified.Unix() > 0 {
+		w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	}
+
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		if !etagMatches(inm, etag) {
+			return false
+		}
+	} else if ims := r.Header.Get("If-Modified-Since"); ims != "" && modified.Unix() > 0 {
+		since, err := http.ParseTime(ims)
+		if err != nil || modified.After(since) {
+			return false
+		}
+	} else {
+		return false
+	}
+
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// etagMatches compares the ETags of an If-None-Match header weakly.
+func etagMatches(header, etag string) bool {
+	for _, v := range strings.Split(header, ",") {
+		v = strings.TrimSpace(v)
+		if v == "*" || strings.TrimPrefix(v, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
@@ -4,90 +4,211 @@ import (
 	"encoding/json"
 	"net/http"
 	"strconv"
+	"time"
 
 	"price-feed/models"
+	"price-feed/tracing"
 )
 
+type projectedCandlestickResponse struct {
+	TimeStart int64                    `json:"timeStart"`
+	TimeEnd   int64                    `json:"timeEnd"`
+	Candles   []map[string]interface{} `json:"candles"`
+	// NextCursor is passed as cursor to get the next page, it is empty on the last page.
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
 func (api *API) handleCandlestickRequest(w http.ResponseWriter, r *http.Request) {
 	vars := r.URL.Query()
 
 	symbols, ok := vars["symbol"]
 	if !ok || len(symbols) == 0 {
-		http.Error(w, "no pair specified", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, "no pair specified")
 		return
 	}
 	symbol := symbols[0]
 
 	intervals, ok := vars["interval"]
 	if !ok || len(intervals) == 0 {
-		http.Error(w, "no interval specified", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, "no interval specified")
+		return
+	}
+	interval, ok := models.NormalizeInterval(intervals[0])
+	if !ok {
+		writeError(w, http.StatusBadRequest, "interval is invalid")
+		return
+	}
+
+	page, err := parseCandlePage(vars)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Without a range, e.g. for the last 500 candles, the range ends now and spans limit candles.
+	timeEnd := time.Now().Unix()
+	if timeEnds, ok := vars["timeEnd"]; ok && len(timeEnds) > 0 {
+		if timeEnd, err = strconv.ParseInt(timeEnds[0], 10, 64); err != nil {
+			writeError(w, http.StatusBadRequest, "timeEnd is not a number")
+			return
+		}
+	}
+
+	var timeStart int64
+	if timeStarts, ok := vars["timeStart"]; ok && len(timeStarts) > 0 {
+		if timeStart, err = strconv.ParseInt(timeStarts[0], 10, 64); err != nil {
+			writeError(w, http.StatusBadRequest, "timeStart is not a number")
+			return
+		}
+	} else if vars.Get("limit") != "" {
+		timeStart = timeEnd - int64(page.limit)*int64(models.IntervalDuration(interval).Seconds())
+	} else {
+		writeError(w, http.StatusBadRequest, "no timeStart specified")
 		return
 	}
-	interval := intervals[0]
+	loadStart, loadEnd := page.rangeOf(timeStart, timeEnd)
 
-	if !models.IsValidInterval(interval) {
-		http.Error(w, "interval is invalid", http.StatusBadRequest)
+	filter, err := parseCandleFilter(vars)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	timeStarts, ok := vars["timeStart"]
-	if !ok || len(timeStarts) == 0 {
-		http.Error(w, "no timeStart specified", http.StatusBadRequest)
+	encoding, err := parseEncoding(vars)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
 		return
 	}
-	timeStartStr := timeStarts[0]
-	timeStart, err := strconv.ParseInt(timeStartStr, 10, 64)
+
+	format, err := parseFormat(vars)
 	if err != nil {
-		http.Error(w, "timeStart is not a number", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	timeEnds, ok := vars["timeEnd"]
-	if !ok || len(timeEnds) == 0 {
-		http.Error(w, "no timeEnd specified", http.StatusBadRequest)
+	units, err := parseUnits(vars)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
 		return
 	}
-	timeEndStr := timeEnds[0]
-	timeEnd, err := strconv.ParseInt(timeEndStr, 10, 64)
+
+	factor, err := api.unitsFactor(symbol, units)
 	if err != nil {
-		http.Error(w, "timeEnd is not a number", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
+	store, trace, ok := api.queryStorage(w, r)
+	if !ok {
+		return
+	}
+
+	merge := models.MergeAverage
+	if v := vars.Get("merge"); v != "" {
+		if !models.IsValidMerge(v) {
+			writeError(w, http.StatusBadRequest, "merge should be one of average, vwap, median, primary-with-fallback")
+			return
+		}
+		merge = v
+	}
+
+	loadAll, loadByExchange := store.LoadCandlestickListAll, store.LoadCandlestickListByExchange
+	if rollup := vars.Get("rollup"); rollup == "true" {
+		if interval != "1d" && interval != "1w" {
+			writeError(w, http.StatusBadRequest, "rollups are available for 1d and 1w intervals only")
+			return
+		}
+		loadAll, loadByExchange = store.LoadRollupListAll, store.LoadRollupListByExchange
+	}
+
+	span := tracing.FromContext(r.Context()).Child("storage.load_candles")
+	span.SetAttribute("symbol", symbol)
+	span.SetAttribute("interval", interval)
+
 	var candles []models.Candle
 	exchange, ok := vars["exchange"]
 	if !ok || len(exchange) == 0 {
-		candles, err = api.storage.LoadCandlestickListAll(symbol, interval, timeStart, timeEnd)
-		if err != nil {
-			http.Error(w, "no pair specified", http.StatusBadRequest)
-			return
-		}
+		candles, err = loadAll(symbol, interval, merge, loadStart, loadEnd)
 	} else {
-		candles, err = api.storage.LoadCandlestickListByExchange(exchange[0], symbol, interval, timeStart, timeEnd)
-		if err != nil {
-			http.Error(w, "no pair specified", http.StatusBadRequest)
+		candles, err = loadByExchange(exchange[0], symbol, interval, loadStart, loadEnd)
+	}
+	span.SetAttribute("candles", len(candles))
+	span.SetError(err)
+	span.End()
+	if err != nil {
+		api.requestLog(r).Errorf("Could not load candles: %v", err)
+		api.writeStorageError(w, "could not load candles")
+		return
+	}
+
+	start := time.Now()
+	candles = filter.apply(candles, interval)
+	trace.Record("filter", "", len(candles), start)
+
+	candles = convertCandles(candles, factor)
+	candles, nextCursor := page.apply(candles, interval)
+
+	// The traced responses aren't cached, their traces differ on every request.
+	mediaType := negotiateBinary(r)
+	if trace == nil {
+		etag, modified := candlesETag(r, mediaType, candles)
+		if notModified(w, r, etag, modified) {
 			return
 		}
 	}
 
-	response := models.CandlestickResponse{
-		TimeStart: timeStart,
-		TimeEnd:   timeEnd,
-		Candles:   candles,
+	if format != formatJSON {
+		writeRowsHeader(w, format, nextCursor)
+		if err = writeCandleRows(w, format, candles, filter.fields); err != nil {
+			api.requestLog(r).Errorf("Could not write response: %v", err)
+		}
+		return
+	}
+
+	resp := models.CandlestickResponse{
+		TimeStart:  timeStart,
+		TimeEnd:    timeEnd,
+		Candles:    candles,
+		NextCursor: nextCursor,
+	}
+
+	// The binary encodings carry whole candles, so they are skipped for the projected
+	// and columnar responses.
+	if mediaType != "" && encoding == encodingObjects && len(filter.fields) == 0 {
+		api.writeBinary(w, r, mediaType, encodeCandles(mediaType, resp))
+		return
+	}
+
+	var response interface{} = resp
+
+	if encoding == encodingColumns {
+		response = columnarCandlestickResponse{
+			TimeStart:  timeStart,
+			TimeEnd:    timeEnd,
+			Encoding:   encoding,
+			Candles:    columnarCandles(candles, filter.fields),
+			NextCursor: nextCursor,
+		}
+	} else if projected := filter.project(candles); projected != nil {
+		response = projectedCandlestickResponse{
+			TimeStart:  timeStart,
+			TimeEnd:    timeEnd,
+			Candles:    projected,
+			NextCursor: nextCursor,
+		}
 	}
 
-	data, err := json.Marshal(response)
+	data, err := json.Marshal(traced(response, trace))
 	if err != nil {
-		api.log.Errorf("Could not marshal json: %v", err)
-		http.Error(w, "could not load candles", http.StatusInternalServerError)
+		api.requestLog(r).Errorf("Could not marshal json: %v", err)
+		writeError(w, http.StatusInternalServerError, "could not load candles")
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.WriteHeader(http.StatusOK)
 	if _, err = w.Write(data); err != nil {
-		api.log.Errorf("Could not write response: %v", err)
+		api.requestLog(r).Errorf("Could not write response: %v", err)
 		return
 	}
 }
@@ -0,0 +1,141 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"price-feed/models"
+)
+
+func TestHandleCandlestickRequest(t *testing.T) {
+	merged := []models.Candle{
+		{TimeStart: 60, TimeEnd: 119, Open: 1, Close: 2, High: 3, Low: 0.5, Volume: 10},
+		{TimeStart: 120, TimeEnd: 179, Open: 2, Close: 3, High: 4, Low: 1.5, Volume: 20},
+		{TimeStart: 180, TimeEnd: 239, Open: 3, Close: 2, High: 3, Low: 2, Volume: 5},
+	}
+	binance := []models.Candle{
+		{TimeStart: 60, TimeEnd: 119, Open: 1.1, Close: 2.1, High: 3.1, Low: 0.6, Volume: 7},
+	}
+	rollups := []models.Candle{
+		{TimeStart: 86400, TimeEnd: 172799, Open: 1, Close: 3, High: 4, Low: 0.5, Volume: 35},
+	}
+
+	tests := []struct {
+		name    string
+		target  string
+		err     error
+		status  int
+		code    string
+		candles []models.Candle
+		load    candleLoad
+	}{
+		{
+			name:   "no symbol",
+			target: "/api/v1/candles?interval=1m&timeStart=0&timeEnd=300",
+			status: http.StatusBadRequest,
+			code:   errorInvalidRequest,
+		},
+		{
+			name:   "no interval",
+			target: "/api/v1/candles?symbol=BTCUSDT&timeStart=0&timeEnd=300",
+			status: http.StatusBadRequest,
+			code:   errorInvalidRequest,
+		},
+		{
+			name:   "invalid interval",
+			target: "/api/v1/candles?symbol=BTCUSDT&interval=7m&timeStart=0&timeEnd=300",
+			status: http.StatusBadRequest,
+			code:   errorInvalidRequest,
+		},
+		{
+			name:   "no time start",
+			target: "/api/v1/candles?symbol=BTCUSDT&interval=1m&timeEnd=300",
+			status: http.StatusBadRequest,
+			code:   errorInvalidRequest,
+		},
+		{
+			name:   "invalid time end",
+			target: "/api/v1/candles?symbol=BTCUSDT&interval=1m&timeStart=0&timeEnd=now",
+			status: http.StatusBadRequest,
+			code:   errorInvalidRequest,
+		},
+		{
+			name:   "invalid merge",
+			target: "/api/v1/candles?symbol=BTCUSDT&interval=1m&timeStart=0&timeEnd=300&merge=max",
+			status: http.StatusBadRequest,
+			code:   errorInvalidRequest,
+		},
+		{
+			name:   "rollup of a short interval",
+			target: "/api/v1/candles?symbol=BTCUSDT&interval=1m&timeStart=0&timeEnd=300&rollup=true",
+			status: http.StatusBadRequest,
+			code:   errorInvalidRequest,
+		},
+		{
+			name:    "merged candles",
+			target:  "/api/v1/candles?symbol=BTCUSDT&interval=1m&timeStart=0&timeEnd=150",
+			status:  http.StatusOK,
+			candles: merged[:2],
+			load:    candleLoad{symbol: "BTCUSDT", interval: "1m", merge: models.MergeAverage, timeStart: 0, timeEnd: 150},
+		},
+		{
+			name:    "candles of an exchange",
+			target:  "/api/v1/candles?symbol=BTCUSDT&interval=1m&timeStart=0&timeEnd=300&exchange=binance",
+			status:  http.StatusOK,
+			candles: binance,
+			load:    candleLoad{exchange: "binance", symbol: "BTCUSDT", interval: "1m", timeStart: 0, timeEnd: 300},
+		},
+		{
+			name:    "descending page",
+			target:  "/api/v1/candles?symbol=BTCUSDT&interval=1m&timeStart=0&timeEnd=300&order=desc&limit=2",
+			status:  http.StatusOK,
+			candles: []models.Candle{merged[2], merged[1]},
+			load:    candleLoad{symbol: "BTCUSDT", interval: "1m", merge: models.MergeAverage, timeStart: 0, timeEnd: 300},
+		},
+		{
+			name:    "rollups",
+			target:  "/api/v1/candles?symbol=BTCUSDT&interval=1d&timeStart=0&timeEnd=172800&rollup=true",
+			status:  http.StatusOK,
+			candles: rollups,
+			load:    candleLoad{symbol: "BTCUSDT", interval: "1d", merge: models.MergeAverage, timeStart: 0, timeEnd: 172800},
+		},
+		{
+			name:   "storage failure",
+			target: "/api/v1/candles?symbol=BTCUSDT&interval=1m&timeStart=0&timeEnd=300",
+			err:    errors.New("connection refused"),
+			status: http.StatusServiceUnavailable,
+			code:   errorStorageUnavailable,
+			load:   candleLoad{symbol: "BTCUSDT", interval: "1m", merge: models.MergeAverage, timeStart: 0, timeEnd: 300},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &fakeStore{
+				candles: map[string][]models.Candle{"": merged, "binance": binance},
+				rollups: map[string][]models.Candle{"": rollups},
+				err:     tt.err,
+			}
+			api := newTestAPI(store, &fakeExchange{}, nil)
+
+			var resp models.CandlestickResponse
+			checkResponse(t, serve(api.handleCandlestickRequest, tt.target), tt.status, tt.code, &resp)
+
+			if tt.load == (candleLoad{}) {
+				if len(store.loads) != 0 {
+					t.Fatalf("loads = %+v, want none", store.loads)
+				}
+				return
+			}
+			if len(store.loads) != 1 || store.loads[0] != tt.load {
+				t.Fatalf("loads = %+v, want %+v", store.loads, tt.load)
+			}
+
+			if tt.status == http.StatusOK && !reflect.DeepEqual(resp.Candles, tt.candles) {
+				t.Errorf("candles = %+v, want %+v", resp.Candles, tt.candles)
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package api
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// compressor pools the writers of a content coding.
+type compressor struct {
+	encoding string
+	pool     sync.Pool
+}
+
+func newCompressors(level int) []*compressor {
+	gzipCompressor := &compressor{encoding: "gzip"}
+	gzipCompressor.pool.New = func() interface{} {
+		w, _ := gzip.NewWriterLevel(io.Discard, level)
+		return w
+	}
+
+	deflateCompressor := &compressor{encoding: "deflate"}
+	deflateCompressor.pool.New = func() interface{} {
+		w, _ := flate.NewWriter(io.Discard, level)
+		return w
+	}
+
+	// The first coding accepted by the client is used.
+	return []*compressor{gzipCompressor, deflateCompressor}
+}
+
+// compressWriter is an io.WriteCloser which can be reused after Reset.
+type compressWriter interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// withCompression compresses the responses with gzip or deflate, whichever the client
+// accepts. WebSocket upgrades and responses served in byte ranges are passed through.
+func (api *API) withCompression(next http.Handler) http.Handler {
+	if len(api.compressors) == 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		c := api.acceptedCompressor(r)
+		if c == nil || r.Header.Get("Upgrade") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressResponseWriter{ResponseWriter: w, compressor: c}
+		defer cw.close()
+
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// acceptedCompressor returns the compressor of the Accept-Encoding header, nil if
+// the client accepts none of them.
+func (api *API) acceptedCompressor(r *http.Request) *compressor {
+	accepted := make(map[string]bool)
+	for _, v := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		coding, params, _ := strings.Cut(v, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if weight, err := strconv.ParseFloat(q, 64); err == nil && weight == 0 {
+				continue
+			}
+		}
+		accepted[coding] = true
+	}
+
+	for _, c := range api.compressors {
+		if accepted[c.encoding] || accepted["*"] {
+			return c
+		}
+	}
+	return nil
+}
+
+// compressResponseWriter decides on the first write whether to compress the response.
+type compressResponseWriter struct {
+	http.ResponseWriter
+	compressor  *compressor
+	writer      compressWriter
+	wroteHeader bool
+}
+
+func (cw *compressResponseWriter) WriteHeader(status int) {
+	if cw.wroteHeader {
+		return
+	}
+	cw.wroteHeader = true
+
+	h := cw.Header()
+	compress := status != http.StatusNoContent && status != http.StatusNotModified &&
+		h.Get("Content-Encoding") == "" && h.Get("Accept-Ranges") == "" && h.Get("Content-Range") == ""
+	if compress {
+		h.Set("Content-Encoding", cw.compressor.encoding)
+		h.Del("Content-Length")
+		cw.writer = cw.compressor.pool.Get().(compressWriter)
+		cw.writer.Reset(cw.ResponseWriter)
+	}
+
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+func (cw *compressResponseWriter) Write(data []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.writer == nil {
+		return cw.ResponseWriter.Write(data)
+	}
+	return cw.writer.Write(data)
+}
+
+// Flush flushes the compressed data, so the streamed responses keep streaming.
+func (cw *compressResponseWriter) Flush() {
+	if cw.writer != nil {
+		cw.writer.Flush()
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (cw *compressResponseWriter) close() {
+	if cw.writer == nil {
+		return
+	}
+	cw.writer.Close()
+	cw.writer.Reset(io.Discard)
+	cw.compressor.pool.Put(cw.writer)
+}
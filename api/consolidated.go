@@ -0,0 +1,111 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"price-feed/models"
+)
+
+const defaultConsolidatedDepth = 100
+
+type consolidatedOrderBookResponse struct {
+	Symbol string `json:"symbol"`
+	// Exchanges are the exchanges whose order books are merged.
+	Exchanges []string `json:"exchanges"`
+	GroupBy   float64  `json:"groupBy,omitempty"`
+	models.OrderBookAPI
+}
+
+// handleConsolidatedOrderBookRequest merges the live order books of the symbol on all
+// exchanges, or the ones listed in exchanges, into one book with the sizes of equal price
+// levels summed. The symbol is in the Binance format, which all workers key their books by.
+func (api *API) handleConsolidatedOrderBookRequest(w http.ResponseWriter, r *http.Request) {
+	vars := r.URL.Query()
+
+	symbol := vars.Get("symbol")
+	if symbol == "" {
+		writeError(w, http.StatusBadRequest, "no pair specified")
+		return
+	}
+
+	depth := defaultConsolidatedDepth
+	if v := vars.Get("depth"); v != "" {
+		var err error
+		depth, err = strconv.Atoi(v)
+		if err != nil || depth < minDepth || depth > maxDepth {
+			writeErrorDetails(w, http.StatusBadRequest, errorInvalidRequest,
+				fmt.Sprintf("depth should be in range [%v; %v]", minDepth, maxDepth),
+				map[string]interface{}{"min": minDepth, "max": maxDepth})
+			return
+		}
+	}
+
+	groupBy, ok := parseGroupBy(w, vars)
+	if !ok {
+		return
+	}
+
+	providers := api.orderBookProviders()
+	names := make([]string, 0, len(providers))
+	if v := vars.Get("exchanges"); v != "" {
+		for _, name := range strings.Split(v, ",") {
+			if _, ok := providers[name]; !ok {
+				writeError(w, http.StatusBadRequest, "order books are not available for the exchange "+name)
+				return
+			}
+			names = append(names, name)
+		}
+	} else {
+		for name := range providers {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	resp := consolidatedOrderBookResponse{
+		Symbol:    symbol,
+		Exchanges: make([]string, 0, len(names)),
+	}
+
+	books := make([]models.OrderBookInternal, 0, len(names))
+	for _, name := range names {
+		if !allowedExchange(r, name) {
+			continue
+		}
+
+		orderBook, ok := providers[name].GetOrderBook(symbol)
+		if !ok {
+			continue
+		}
+		books = append(books, orderBook)
+		resp.Exchanges = append(resp.Exchanges, name)
+	}
+
+	if len(books) == 0 {
+		writeError(w, http.StatusNotFound, "no order books for the pair")
+		return
+	}
+
+	merged := models.MergeOrderBooks(books...)
+	resp.GroupBy = groupBy
+	resp.OrderBookAPI = merged.Format(depth, groupBy)
+
+	data, err := json.Marshal(resp)
+	if err != nil {
+		api.requestLog(r).Errorf("Could not marshal json: %v", err)
+		writeError(w, http.StatusInternalServerError, "could not load order book")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if _, err = w.Write(data); err != nil {
+		api.requestLog(r).Errorf("Could not write response: %v", err)
+		return
+	}
+}
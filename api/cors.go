@@ -0,0 +1,14 @@
+package api
+
+import (
+	"net/http"
+)
+
+// withCORS lets browsers on any origin read the responses, whatever their format,
+// including the errors and the not modified responses.
+func (api *API) withCORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,50 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"price-feed/models"
+)
+
+const (
+	defaultDeadLetterLimit = 100
+)
+
+func (api *API) handleDeadLettersRequest(w http.ResponseWriter, r *http.Request) {
+	if !api.requireScope(w, r, models.ScopeAdmin) {
+		return
+	}
+
+	limit := int64(defaultDeadLetterLimit)
+	if limits, ok := r.URL.Query()["limit"]; ok && len(limits) > 0 {
+		var err error
+		limit, err = strconv.ParseInt(limits[0], 10, 64)
+		if err != nil || limit < 1 {
+			writeError(w, http.StatusBadRequest, "limit should be a positive number")
+			return
+		}
+	}
+
+	deadLetters, err := api.storage.LoadDeadLetters(limit)
+	if err != nil {
+		api.requestLog(r).Errorf("Could not load dead letters: %v", err)
+		api.writeStorageError(w, "could not load dead letters")
+		return
+	}
+
+	data, err := json.Marshal(deadLetters)
+	if err != nil {
+		api.requestLog(r).Errorf("Could not marshal json: %v", err)
+		writeError(w, http.StatusInternalServerError, "could not load dead letters")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if _, err = w.Write(data); err != nil {
+		api.requestLog(r).Errorf("Could not write response: %v", err)
+		return
+	}
+}
@@ -0,0 +1,43 @@
+package api
+
+import (
+	"net/http"
+
+	"price-feed/models"
+	"price-feed/storage"
+)
+
+// tracedResponse wraps the response of a debug request with the trace of its queries.
+type tracedResponse struct {
+	Result interface{}    `json:"result"`
+	Trace  *storage.Trace `json:"trace"`
+}
+
+// queryStorage returns the storage to serve the request with. Requests with debug=true,
+// which need an admin API key, bypass the cache and record their queries to the returned trace.
+// It writes an error response and returns false if the request can't be served.
+func (api *API) queryStorage(w http.ResponseWriter, r *http.Request) (CandleStore, *storage.Trace, bool) {
+	if r.URL.Query().Get("debug") != "true" {
+		return api.storage, nil, true
+	}
+
+	if !api.requireScope(w, r, models.ScopeAdmin) {
+		return nil, nil, false
+	}
+
+	if api.tracer == nil {
+		writeError(w, http.StatusNotImplemented, "query tracing is not supported")
+		return nil, nil, false
+	}
+
+	trace := &storage.Trace{}
+	return api.tracer.WithTrace(trace), trace, true
+}
+
+// traced wraps the response with the trace when the request is traced.
+func traced(response interface{}, trace *storage.Trace) interface{} {
+	if trace == nil {
+		return response
+	}
+	return tracedResponse{Result: response, Trace: trace}
+}
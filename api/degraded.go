@@ -0,0 +1,166 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+
+	"price-feed/models"
+)
+
+const (
+	// maxCachedCandles is the number of the most recent candles kept per series.
+	maxCachedCandles = 1000
+	degradedWarning  = `199 - "storage is unavailable, serving cached data"`
+)
+
+// cachingStore keeps the candles loaded from the storage in memory, so they are served
+// while the storage is down instead of failing every request.
+type cachingStore struct {
+	CandleStore
+
+	mu     sync.RWMutex
+	series map[string]*cachedSeries
+}
+
+// cachedSeries holds the candles of a series by their open time.
+type cachedSeries struct {
+	// from is the start of the loaded windows, the candles before it aren't known.
+	from    int64
+	candles map[int64]models.Candle
+}
+
+func newCachingStore(storage CandleStore) *cachingStore {
+	return &cachingStore{
+		CandleStore: storage,
+		series:      make(map[string]*cachedSeries),
+	}
+}
+
+func (s *cachingStore) LoadCandlestickListByExchange(exchange, symbol, interval string, timeStart, timeEnd int64) ([]models.Candle, error) {
+	return s.load(fmt.Sprintf("candlestick:%v:%v:%v", exchange, symbol, interval), timeStart, timeEnd,
+		func() ([]models.Candle, error) {
+			return s.CandleStore.LoadCandlestickListByExchange(exchange, symbol, interval, timeStart, timeEnd)
+		})
+}
+
+func (s *cachingStore) LoadCandlestickListAll(symbol, interval, merge string, timeStart, timeEnd int64) ([]models.Candle, error) {
+	return s.load(fmt.Sprintf("candlestick:all:%v:%v:%v", merge, symbol, interval), timeStart, timeEnd,
+		func() ([]models.Candle, error) {
+			return s.CandleStore.LoadCandlestickListAll(symbol, interval, merge, timeStart, timeEnd)
+		})
+}
+
+func (s *cachingStore) LoadRollupListByExchange(exchange, symbol, interval string, timeStart, timeEnd int64) ([]models.Candle, error) {
+	return s.load(fmt.Sprintf("rollup:%v:%v:%v", exchange, symbol, interval), timeStart, timeEnd,
+		func() ([]models.Candle, error) {
+			return s.CandleStore.LoadRollupListByExchange(exchange, symbol, interval, timeStart, timeEnd)
+		})
+}
+
+func (s *cachingStore) LoadRollupListAll(symbol, interval, merge string, timeStart, timeEnd int64) ([]models.Candle, error) {
+	return s.load(fmt.Sprintf("rollup:all:%v:%v:%v", merge, symbol, interval), timeStart, timeEnd,
+		func() ([]models.Candle, error) {
+			return s.CandleStore.LoadRollupListAll(symbol, interval, merge, timeStart, timeEnd)
+		})
+}
+
+// load returns the candles from the storage and remembers them. The cached candles are
+// returned instead if the storage is unhealthy or fails and the cache covers the window.
+func (s *cachingStore) load(key string, timeStart, timeEnd int64, loadStored func() ([]models.Candle, error)) ([]models.Candle, error) {
+	if !s.Healthy() {
+		if candles, ok := s.cached(key, timeStart, timeEnd); ok {
+			return candles, nil
+		}
+	}
+
+	candles, err := loadStored()
+	if err != nil {
+		if cached, ok := s.cached(key, timeStart, timeEnd); ok {
+			return cached, nil
+		}
+		return nil, err
+	}
+
+	s.remember(key, timeStart, candles)
+	return candles, nil
+}
+
+// cached returns the cached candles within [timeStart; timeEnd]. It returns false if the cache
+// doesn't reach back to timeStart, e.g. the window was never loaded or has been evicted,
+// or has no candles in the window, so an empty or partial result isn't served as complete.
+func (s *cachingStore) cached(key string, timeStart, timeEnd int64) ([]models.Candle, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	series, ok := s.series[key]
+	if !ok || timeStart < series.from {
+		return nil, false
+	}
+
+	candles := make([]models.Candle, 0)
+	for _, v := range series.candles {
+		if v.TimeStart >= timeStart && v.TimeStart <= timeEnd {
+			candles = append(candles, v)
+		}
+	}
+	if len(candles) == 0 {
+		return nil, false
+	}
+
+	sort.Slice(candles, func(i, j int) bool {
+		return candles[i].TimeStart < candles[j].TimeStart
+	})
+	return candles, true
+}
+
+// remember caches the candles loaded for the window starting at timeStart.
+func (s *cachingStore) remember(key string, timeStart int64, candles []models.Candle) {
+	if len(candles) == 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	series, ok := s.series[key]
+	if !ok {
+		series = &cachedSeries{from: timeStart, candles: make(map[int64]models.Candle, len(candles))}
+		s.series[key] = series
+	}
+	if timeStart < series.from {
+		series.from = timeStart
+	}
+
+	for _, v := range candles {
+		series.candles[v.TimeStart] = v
+	}
+
+	if len(series.candles) <= maxCachedCandles {
+		return
+	}
+
+	times := make([]int64, 0, len(series.candles))
+	for k := range series.candles {
+		times = append(times, k)
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+
+	evicted := times[:len(times)-maxCachedCandles]
+	for _, v := range evicted {
+		delete(series.candles, v)
+	}
+	// The evicted candles are no longer known, up to the first one kept.
+	series.from = evicted[len(evicted)-1] + 1
+}
+
+// withDegradedWarning marks the responses served while the storage is unavailable.
+func (api *API) withDegradedWarning(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !api.storage.Healthy() {
+			w.Header().Set("Warning", degradedWarning)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"price-feed/models"
+)
+
+func TestCachingStoreLoad(t *testing.T) {
+	binance := []models.Candle{
+		{TimeStart: 60, TimeEnd: 119, Open: 1, Close: 2, High: 3, Low: 0.5, Volume: 10},
+		{TimeStart: 120, TimeEnd: 179, Open: 2, Close: 3, High: 4, Low: 1.5, Volume: 20},
+		{TimeStart: 180, TimeEnd: 239, Open: 3, Close: 2, High: 3, Low: 2, Volume: 5},
+	}
+
+	tests := []struct {
+		name               string
+		timeStart, timeEnd int64
+		candles            []models.Candle
+		ok                 bool
+	}{
+		{
+			name:      "cached window",
+			timeStart: 60,
+			timeEnd:   180,
+			candles:   binance,
+			ok:        true,
+		},
+		{
+			name:      "part of the cached window",
+			timeStart: 120,
+			timeEnd:   120,
+			candles:   binance[1:2],
+			ok:        true,
+		},
+		{
+			name:      "window starting before the cache",
+			timeStart: 0,
+			timeEnd:   180,
+		},
+		{
+			name:      "window without cached candles",
+			timeStart: 240,
+			timeEnd:   300,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &fakeStore{candles: map[string][]models.Candle{"binance": binance}}
+			s := newCachingStore(store)
+			if _, err := s.LoadCandlestickListByExchange("binance", "BTCUSDT", "1m", 60, 180); err != nil {
+				t.Fatalf("could not load candles: %v", err)
+			}
+
+			store.err = errors.New("storage is down")
+			candles, err := s.LoadCandlestickListByExchange("binance", "BTCUSDT", "1m", tt.timeStart, tt.timeEnd)
+			if (err == nil) != tt.ok {
+				t.Fatalf("err = %v, want ok %v", err, tt.ok)
+			}
+			if !reflect.DeepEqual(candles, tt.candles) {
+				t.Errorf("candles = %+v, want %+v", candles, tt.candles)
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"time"
+
+	"price-feed/aggregator"
+	"price-feed/models"
+	"price-feed/status"
+	"price-feed/storage"
+)
+
+// CandleStore loads the stored candles and the service data served by the API.
+type CandleStore interface {
+	LoadCandlestickListByExchange(exchange, symbol, interval string, timeStart, timeEnd int64) ([]models.Candle, error)
+	LoadCandlestickListAll(symbol, interval, merge string, timeStart, timeEnd int64) ([]models.Candle, error)
+	LoadRollupListByExchange(exchange, symbol, interval string, timeStart, timeEnd int64) ([]models.Candle, error)
+	LoadRollupListAll(symbol, interval, merge string, timeStart, timeEnd int64) ([]models.Candle, error)
+	LoadLastCandlestick(exchange, symbol, interval string) (models.Candle, bool, error)
+	LoadTrades(exchange, symbol string, timeStart, timeEnd, limit int64) ([]models.Trade, error)
+	LoadMidPrices(symbol string, timeStart, timeEnd int64) ([]models.MidPrice, error)
+	LoadDeadLetters(limit int64) ([]models.DeadLetter, error)
+	IncrementUsage(tenant, month string, usage models.Usage) error
+	LoadUsage(tenant, month string) (models.Usage, error)
+	StoreAPIKey(hash string, key models.APIKey) error
+	DeleteAPIKey(hash string) (bool, error)
+	LoadAPIKeys() (map[string]models.APIKey, error)
+	PendingQueries() int
+	DroppedWrites() map[string]int64
+	Healthy() bool
+}
+
+// QueryTracer is implemented by the storage which can record the queries of a single request.
+type QueryTracer interface {
+	WithTrace(trace *storage.Trace) *storage.Client
+}
+
+// SymbolRegistry represents an exchange worker and the symbols it is subscribed to.
+type SymbolRegistry interface {
+	Symbols() []string
+	SymbolStatuses() map[string]string
+	Streams() []status.Stream
+	ClockSkew() time.Duration
+	AddSymbol(symbol string) error
+	Reload()
+}
+
+// OrderBookProvider serves the live order books of an exchange.
+type OrderBookProvider interface {
+	GetOrderBook(symbol string) (models.OrderBookInternal, bool)
+	Resyncs() int64
+	ChaosStats() (faults map[string]int64, violations int64, ok bool)
+	BookDrift() (drifts map[string]float64, corrections int64, ok bool)
+	Reconnects() (outcomes map[string]map[string]int64, ok bool)
+	Checksums() (checksums map[string]status.Checksum, ok bool)
+}
+
+// OrderBookExchange represents an exchange worker which also maintains order books.
+type OrderBookExchange interface {
+	SymbolRegistry
+	OrderBookProvider
+}
+
+// IndexProvider exposes the index pipelines.
+type IndexProvider interface {
+	Pipeline(name string) (*aggregator.PipelineConfig, bool)
+	Rejections() map[string]int64
+	SyntheticSymbols() []string
+	Leaderboard() ([]aggregator.LeaderboardEntry, time.Time)
+}
+
+// WebhookRegistry manages the webhooks registered through the API.
+type WebhookRegistry interface {
+	Validate(webhook models.Webhook) error
+	Register(owner string, webhook models.Webhook) (models.Webhook, error)
+	List(owner string) []models.Webhook
+	Delete(owner, id string) (bool, error)
+}
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"fmt"
+	"net/url"
+
+	"price-feed/models"
+)
+
+const (
+	encodingObjects = "objects"
+	encodingColumns = "columns"
+)
+
+// deltaFields are the columns encoded as differences from the previous value.
+var deltaFields = map[string]bool{
+	"timeStart": true,
+	"timeEnd":   true,
+	"time":      true,
+}
+
+var allCandleFields = []string{"timeStart", "timeEnd", "time", "open", "close", "high", "low", "volume"}
+
+type columnarCandlestickResponse struct {
+	TimeStart int64                  `json:"timeStart"`
+	TimeEnd   int64                  `json:"timeEnd"`
+	Encoding  string                 `json:"encoding"`
+	Candles   map[string]interface{} `json:"candles"`
+	// NextCursor is passed as cursor to get the next page, it is empty on the last page.
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+func parseEncoding(vars url.Values) (string, error) {
+	switch v := vars.Get("encoding"); v {
+	case "", encodingObjects:
+		return encodingObjects, nil
+	case encodingColumns:
+		return encodingColumns, nil
+	default:
+		return "", fmt.Errorf("unknown encoding %v", v)
+	}
+}
+
+// columnarCandles encodes the candles as one array per field. The first value of
+// the timestamp columns is absolute and the rest are deltas from the previous one.
+func columnarCandles(candles []models.Candle, fields []string) map[string]interface{} {
+	if len(fields) == 0 {
+		fields = allCandleFields
+	}
+
+	columns := make(map[string]interface{}, len(fields))
+	for _, field := range fields {
+		get := candleFields[field]
+
+		if deltaFields[field] {
+			column := make([]int64, 0, len(candles))
+			var prev int64
+			for i := range candles {
+				v := get(&candles[i]).(int64)
+				column = append(column, v-prev)
+				prev = v
+			}
+			columns[field] = column
+			continue
+		}
+
+		column := make([]interface{}, 0, len(candles))
+		for i := range candles {
+			column = append(column, get(&candles[i]))
+		}
+		columns[field] = column
+	}
+
+	return columns
+}
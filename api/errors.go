@@ -0,0 +1,85 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Error codes of the error responses. Most follow the status, the rest tell apart
+// the failures sharing one.
+const (
+	errorInvalidRequest      = "invalid_request"
+	errorUnauthorized        = "unauthorized"
+	errorForbidden           = "forbidden"
+	errorNotFound            = "not_found"
+	errorRangeNotSatisfiable = "range_not_satisfiable"
+	errorRateLimited         = "rate_limited"
+	errorQuotaExceeded       = "quota_exceeded"
+	errorInternal            = "internal_error"
+	errorNotImplemented      = "not_implemented"
+	errorOverloaded          = "overloaded"
+	errorStorageUnavailable  = "storage_unavailable"
+)
+
+var statusErrorCodes = map[int]string{
+	http.StatusBadRequest:                   errorInvalidRequest,
+	http.StatusUnauthorized:                 errorUnauthorized,
+	http.StatusForbidden:                    errorForbidden,
+	http.StatusNotFound:                     errorNotFound,
+	http.StatusRequestedRangeNotSatisfiable: errorRangeNotSatisfiable,
+	http.StatusTooManyRequests:              errorRateLimited,
+	http.StatusInternalServerError:          errorInternal,
+	http.StatusNotImplemented:               errorNotImplemented,
+	http.StatusServiceUnavailable:           errorOverloaded,
+}
+
+type errorResponse struct {
+	Error apiError `json:"error"`
+}
+
+type apiError struct {
+	Code    string                 `json:"code"`
+	Message string                 `json:"message"`
+	Details map[string]interface{} `json:"details,omitempty"`
+	// RequestID is the X-Request-ID of the request, to be quoted in reports.
+	RequestID string `json:"requestId,omitempty"`
+}
+
+// writeError writes an error response with the code of the status.
+func writeError(w http.ResponseWriter, status int, message string) {
+	code, ok := statusErrorCodes[status]
+	if !ok {
+		code = errorInternal
+	}
+	writeErrorDetails(w, status, code, message, nil)
+}
+
+// writeErrorDetails writes an error response with the code and the details.
+func writeErrorDetails(w http.ResponseWriter, status int, code, message string, details map[string]interface{}) {
+	data, err := json.Marshal(errorResponse{Error: apiError{
+		Code:      code,
+		Message:   message,
+		Details:   details,
+		RequestID: w.Header().Get(requestIDHeader),
+	}})
+	if err != nil {
+		http.Error(w, message, status)
+		return
+	}
+
+	w.Header().Del("Content-Length")
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	_, _ = w.Write(data)
+}
+
+// writeStorageError writes the error response of a failed storage operation, which
+// is 503 while the storage is unhealthy, so clients know to retry, and 500 otherwise.
+func (api *API) writeStorageError(w http.ResponseWriter, message string) {
+	if !api.storage.Healthy() {
+		writeErrorDetails(w, http.StatusServiceUnavailable, errorStorageUnavailable, message, nil)
+		return
+	}
+	writeError(w, http.StatusInternalServerError, message)
+}
@@ -0,0 +1,284 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"price-feed/models"
+)
+
+const (
+	// exportPageSize is the number of intervals loaded from the storage at once.
+	exportPageSize = 1000
+)
+
+// errRangeNotSatisfiable is returned by parseRange if the range starts beyond the content.
+var errRangeNotSatisfiable = fmt.Errorf("range not satisfiable")
+
+// exportRequest represents the parameters of a candle export.
+type exportRequest struct {
+	exchange  string
+	symbol    string
+	interval  string
+	timeStart int64
+	timeEnd   int64
+	filter    candleFilter
+}
+
+// handleExportRequest streams the closed candles of the symbol as newline delimited JSON.
+// The export is loaded page by page, so the first bytes are sent before the whole range is read.
+// Byte ranges are supported to resume an interrupted download, which is stable because
+// the candles which are still open are never exported.
+func (api *API) handleExportRequest(w http.ResponseWriter, r *http.Request) {
+	vars := r.URL.Query()
+
+	req := exportRequest{
+		exchange: vars.Get("exchange"),
+		symbol:   vars.Get("symbol"),
+		interval: vars.Get("interval"),
+	}
+
+	if req.symbol == "" {
+		writeError(w, http.StatusBadRequest, "no pair specified")
+		return
+	}
+
+	var ok bool
+	if req.interval, ok = models.NormalizeInterval(req.interval); !ok {
+		writeError(w, http.StatusBadRequest, "interval is invalid")
+		return
+	}
+
+	var err error
+	if req.timeStart, err = strconv.ParseInt(vars.Get("timeStart"), 10, 64); err != nil {
+		writeError(w, http.StatusBadRequest, "timeStart is not a number")
+		return
+	}
+
+	if req.timeEnd, err = strconv.ParseInt(vars.Get("timeEnd"), 10, 64); err != nil {
+		writeError(w, http.StatusBadRequest, "timeEnd is not a number")
+		return
+	}
+
+	length := int64(models.IntervalDuration(req.interval).Seconds())
+	if closedEnd := time.Now().Unix() - length; req.timeEnd > closedEnd {
+		req.timeEnd = closedEnd
+	}
+
+	if req.filter, err = parseCandleFilter(vars); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Accept-Ranges", "bytes")
+
+	rangeHeader := r.Header.Get("Range")
+	if rangeHeader == "" {
+		w.WriteHeader(http.StatusOK)
+		if err = api.exportCandles(req, flushWriter{w}); err != nil {
+			api.requestLog(r).Errorf("Could not export candles: %v", err)
+		}
+		return
+	}
+
+	// The total size is needed for the Content-Range header, so the candles are read twice.
+	var size countWriter
+	if err = api.exportCandles(req, &size); err != nil {
+		api.requestLog(r).Errorf("Could not export candles: %v", err)
+		api.writeStorageError(w, "could not load candles")
+		return
+	}
+
+	first, last, err := parseRange(rangeHeader, int64(size))
+	if err == errRangeNotSatisfiable {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		writeError(w, http.StatusRequestedRangeNotSatisfiable, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", first, last, size))
+	w.Header().Set("Content-Length", strconv.FormatInt(last-first+1, 10))
+	w.WriteHeader(http.StatusPartialContent)
+
+	err = api.exportCandles(req, &rangeWriter{w: flushWriter{w}, skip: first, left: last - first + 1})
+	if err != nil && err != errRangeWritten {
+		api.requestLog(r).Errorf("Could not export candles: %v", err)
+	}
+}
+
+// exportCandles writes the candles of the request to w one page at a time.
+func (api *API) exportCandles(req exportRequest, w io.Writer) error {
+	pageLength := int64(models.IntervalDuration(req.interval).Seconds()) * exportPageSize
+
+	for pageStart := req.timeStart; pageStart <= req.timeEnd; pageStart += pageLength {
+		pageEnd := pageStart + pageLength - 1
+		if pageEnd > req.timeEnd {
+			pageEnd = req.timeEnd
+		}
+
+		var candles []models.Candle
+		var err error
+		if req.exchange == "" {
+			candles, err = api.storage.LoadCandlestickListAll(req.symbol, req.interval, models.MergeAverage, pageStart, pageEnd)
+		} else {
+			candles, err = api.storage.LoadCandlestickListByExchange(req.exchange, req.symbol, req.interval, pageStart, pageEnd)
+		}
+		if err != nil {
+			return err
+		}
+
+		// The storage rounds the start of the range down, so the previous page may hold the first candle.
+		page := candles[:0]
+		for _, v := range candles {
+			if v.TimeStart >= pageStart {
+				page = append(page, v)
+			}
+		}
+		page = req.filter.apply(page, req.interval)
+
+		var rows []interface{}
+		if projected := req.filter.project(page); projected != nil {
+			for _, v := range projected {
+				rows = append(rows, v)
+			}
+		} else {
+			for _, v := range page {
+				rows = append(rows, v)
+			}
+		}
+
+		for _, v := range rows {
+			data, err := json.Marshal(v)
+			if err != nil {
+				return err
+			}
+
+			if _, err = w.Write(append(data, '\n')); err != nil {
+				return err
+			}
+		}
+
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+	}
+
+	return nil
+}
+
+// parseRange parses a single byte range of the Range header, e.g. "bytes=100-" or "bytes=-500".
+func parseRange(header string, size int64) (int64, int64, error) {
+	if !strings.HasPrefix(header, "bytes=") || strings.Contains(header, ",") {
+		return 0, 0, fmt.Errorf("only a single byte range is supported")
+	}
+
+	bounds := strings.SplitN(strings.TrimPrefix(header, "bytes="), "-", 2)
+	if len(bounds) != 2 {
+		return 0, 0, fmt.Errorf("range is invalid")
+	}
+
+	if bounds[0] == "" {
+		suffix, err := strconv.ParseInt(bounds[1], 10, 64)
+		if err != nil || suffix < 1 {
+			return 0, 0, fmt.Errorf("range is invalid")
+		}
+		if size == 0 {
+			return 0, 0, errRangeNotSatisfiable
+		}
+		if suffix > size {
+			suffix = size
+		}
+		return size - suffix, size - 1, nil
+	}
+
+	first, err := strconv.ParseInt(bounds[0], 10, 64)
+	if err != nil || first < 0 {
+		return 0, 0, fmt.Errorf("range is invalid")
+	}
+	if first >= size {
+		return 0, 0, errRangeNotSatisfiable
+	}
+
+	last := size - 1
+	if bounds[1] != "" {
+		if last, err = strconv.ParseInt(bounds[1], 10, 64); err != nil || last < first {
+			return 0, 0, fmt.Errorf("range is invalid")
+		}
+		if last > size-1 {
+			last = size - 1
+		}
+	}
+
+	return first, last, nil
+}
+
+// flushWriter flushes the response after every page of an export.
+type flushWriter struct {
+	w http.ResponseWriter
+}
+
+func (f flushWriter) Write(p []byte) (int, error) {
+	return f.w.Write(p)
+}
+
+func (f flushWriter) Flush() {
+	if flusher, ok := f.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// countWriter counts the bytes written to it.
+type countWriter int64
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	*c += countWriter(len(p))
+	return len(p), nil
+}
+
+// errRangeWritten stops the export once the requested range is written.
+var errRangeWritten = fmt.Errorf("range is written")
+
+// rangeWriter skips the bytes before the range and stops after it.
+type rangeWriter struct {
+	w    flushWriter
+	skip int64
+	left int64
+}
+
+func (rw *rangeWriter) Write(p []byte) (int, error) {
+	n := len(p)
+
+	if rw.skip >= int64(len(p)) {
+		rw.skip -= int64(len(p))
+		return n, nil
+	}
+	p = p[rw.skip:]
+	rw.skip = 0
+
+	if int64(len(p)) > rw.left {
+		p = p[:rw.left]
+	}
+	if _, err := rw.w.Write(p); err != nil {
+		return 0, err
+	}
+
+	rw.left -= int64(len(p))
+	if rw.left == 0 {
+		return n, errRangeWritten
+	}
+	return n, nil
+}
+
+func (rw *rangeWriter) Flush() {
+	rw.w.Flush()
+}
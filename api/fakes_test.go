@@ -0,0 +1,213 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"price-feed/logger"
+	"price-feed/models"
+	"price-feed/status"
+)
+
+var (
+	_ CandleStore       = (*fakeStore)(nil)
+	_ OrderBookExchange = (*fakeExchange)(nil)
+)
+
+// fakeStore serves the candles of every exchange from memory and records the loads.
+type fakeStore struct {
+	// candles holds the candles by exchange, the merged ones under the empty name.
+	candles map[string][]models.Candle
+	rollups map[string][]models.Candle
+	err     error
+	loads   []candleLoad
+	// usage is the usage of every tenant, usageLoads counts its loads.
+	usage      models.Usage
+	usageLoads int
+}
+
+// candleLoad is a recorded load of candles, the exchange is empty for the merged candles.
+type candleLoad struct {
+	exchange, symbol, interval, merge string
+	timeStart, timeEnd                int64
+}
+
+func (s *fakeStore) load(series map[string][]models.Candle, load candleLoad) ([]models.Candle, error) {
+	s.loads = append(s.loads, load)
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	candles := make([]models.Candle, 0)
+	for _, v := range series[load.exchange] {
+		if v.TimeStart >= load.timeStart && v.TimeStart <= load.timeEnd {
+			candles = append(candles, v)
+		}
+	}
+	return candles, nil
+}
+
+func (s *fakeStore) LoadCandlestickListByExchange(exchange, symbol, interval string, timeStart, timeEnd int64) ([]models.Candle, error) {
+	return s.load(s.candles, candleLoad{exchange: exchange, symbol: symbol, interval: interval, timeStart: timeStart, timeEnd: timeEnd})
+}
+
+func (s *fakeStore) LoadCandlestickListAll(symbol, interval, merge string, timeStart, timeEnd int64) ([]models.Candle, error) {
+	return s.load(s.candles, candleLoad{symbol: symbol, interval: interval, merge: merge, timeStart: timeStart, timeEnd: timeEnd})
+}
+
+func (s *fakeStore) LoadRollupListByExchange(exchange, symbol, interval string, timeStart, timeEnd int64) ([]models.Candle, error) {
+	return s.load(s.rollups, candleLoad{exchange: exchange, symbol: symbol, interval: interval, timeStart: timeStart, timeEnd: timeEnd})
+}
+
+func (s *fakeStore) LoadRollupListAll(symbol, interval, merge string, timeStart, timeEnd int64) ([]models.Candle, error) {
+	return s.load(s.rollups, candleLoad{symbol: symbol, interval: interval, merge: merge, timeStart: timeStart, timeEnd: timeEnd})
+}
+
+func (s *fakeStore) LoadLastCandlestick(exchange, symbol, interval string) (models.Candle, bool, error) {
+	return models.Candle{}, false, nil
+}
+
+func (s *fakeStore) LoadTrades(exchange, symbol string, timeStart, timeEnd, limit int64) ([]models.Trade, error) {
+	return nil, nil
+}
+
+func (s *fakeStore) LoadMidPrices(symbol string, timeStart, timeEnd int64) ([]models.MidPrice, error) {
+	return nil, nil
+}
+
+func (s *fakeStore) LoadDeadLetters(limit int64) ([]models.DeadLetter, error) {
+	return nil, nil
+}
+
+func (s *fakeStore) IncrementUsage(tenant, month string, usage models.Usage) error {
+	return nil
+}
+
+func (s *fakeStore) LoadUsage(tenant, month string) (models.Usage, error) {
+	s.usageLoads++
+	return s.usage, s.err
+}
+
+func (s *fakeStore) StoreAPIKey(hash string, key models.APIKey) error {
+	return nil
+}
+
+func (s *fakeStore) DeleteAPIKey(hash string) (bool, error) {
+	return false, nil
+}
+
+func (s *fakeStore) LoadAPIKeys() (map[string]models.APIKey, error) {
+	return map[string]models.APIKey{}, nil
+}
+
+func (s *fakeStore) PendingQueries() int {
+	return 0
+}
+
+func (s *fakeStore) DroppedWrites() map[string]int64 {
+	return map[string]int64{}
+}
+
+func (s *fakeStore) Healthy() bool {
+	return s.err == nil
+}
+
+// fakeExchange serves the order books of an exchange from memory.
+type fakeExchange struct {
+	orderBooks map[string]models.OrderBookInternal
+}
+
+func (e *fakeExchange) Symbols() []string {
+	symbols := make([]string, 0, len(e.orderBooks))
+	for symbol := range e.orderBooks {
+		symbols = append(symbols, symbol)
+	}
+	return symbols
+}
+
+func (e *fakeExchange) SymbolStatuses() map[string]string {
+	return map[string]string{}
+}
+
+func (e *fakeExchange) Streams() []status.Stream {
+	return nil
+}
+
+func (e *fakeExchange) ClockSkew() time.Duration {
+	return 0
+}
+
+func (e *fakeExchange) AddSymbol(symbol string) error {
+	return nil
+}
+
+func (e *fakeExchange) Reload() {}
+
+func (e *fakeExchange) GetOrderBook(symbol string) (models.OrderBookInternal, bool) {
+	orderBook, ok := e.orderBooks[symbol]
+	return orderBook, ok
+}
+
+func (e *fakeExchange) Resyncs() int64 {
+	return 0
+}
+
+func (e *fakeExchange) ChaosStats() (faults map[string]int64, violations int64, ok bool) {
+	return nil, 0, false
+}
+
+func (e *fakeExchange) BookDrift() (drifts map[string]float64, corrections int64, ok bool) {
+	return nil, 0, false
+}
+
+func (e *fakeExchange) Reconnects() (outcomes map[string]map[string]int64, ok bool) {
+	return nil, false
+}
+
+func (e *fakeExchange) Checksums() (checksums map[string]status.Checksum, ok bool) {
+	return nil, false
+}
+
+// newTestAPI returns an API serving the store and the order books of Binance and the optional exchanges.
+func newTestAPI(store CandleStore, binance OrderBookExchange, optional map[string]OrderBookExchange) *API {
+	log := logger.New(&logger.Config{Level: "error"})
+	return New(&Config{}, log, store, binance, nil, nil, optional, nil, nil, nil)
+}
+
+// serve serves the request to the target with the handler and returns the response.
+func serve(handler http.HandlerFunc, target string) *httptest.ResponseRecorder {
+	rw := httptest.NewRecorder()
+	handler(rw, httptest.NewRequest(http.MethodGet, target, nil))
+	return rw
+}
+
+// checkResponse checks the status of the response and decodes its body, the error code
+// of an error response or the response into v otherwise.
+func checkResponse(t *testing.T, rw *httptest.ResponseRecorder, status int, code string, v interface{}) {
+	t.Helper()
+
+	if rw.Code != status {
+		t.Fatalf("status = %v, want %v: %v", rw.Code, status, rw.Body)
+	}
+	if contentType := rw.Header().Get("Content-Type"); contentType != "application/json" {
+		t.Fatalf("content type = %q, want application/json", contentType)
+	}
+
+	if status != http.StatusOK {
+		var resp errorResponse
+		if err := json.Unmarshal(rw.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("could not decode error response: %v", err)
+		}
+		if resp.Error.Code != code {
+			t.Fatalf("error code = %q, want %q", resp.Error.Code, code)
+		}
+		return
+	}
+
+	if err := json.Unmarshal(rw.Body.Bytes(), v); err != nil {
+		t.Fatalf("could not decode response: %v", err)
+	}
+}
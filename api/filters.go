@@ -0,0 +1,120 @@
+package api
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"price-feed/models"
+)
+
+var candleFields = map[string]func(c *models.Candle) interface{}{
+	"timeStart": func(c *models.Candle) interface{} { return c.TimeStart },
+	"timeEnd":   func(c *models.Candle) interface{} { return c.TimeEnd },
+	"time":      func(c *models.Candle) interface{} { return c.Time },
+	"open":      func(c *models.Candle) interface{} { return c.Open },
+	"close":     func(c *models.Candle) interface{} { return c.Close },
+	"high":      func(c *models.Candle) interface{} { return c.High },
+	"low":       func(c *models.Candle) interface{} { return c.Low },
+	"volume":    func(c *models.Candle) interface{} { return c.Volume },
+	"anomalous": func(c *models.Candle) interface{} { return c.Anomalous },
+	"source":    func(c *models.Candle) interface{} { return c.Source },
+}
+
+// candleFilter represents the optional row and column filters of a candle query.
+type candleFilter struct {
+	minVolume  float64
+	onlyClosed bool
+	provenance bool
+	fields     []string
+}
+
+func parseCandleFilter(vars url.Values) (candleFilter, error) {
+	var f candleFilter
+
+	if v := vars.Get("minVolume"); v != "" {
+		minVolume, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return f, fmt.Errorf("minVolume is not a number")
+		}
+		f.minVolume = minVolume
+	}
+
+	if v := vars.Get("onlyClosed"); v != "" {
+		onlyClosed, err := strconv.ParseBool(v)
+		if err != nil {
+			return f, fmt.Errorf("onlyClosed should be a boolean")
+		}
+		f.onlyClosed = onlyClosed
+	}
+
+	if v := vars.Get("provenance"); v != "" {
+		provenance, err := strconv.ParseBool(v)
+		if err != nil {
+			return f, fmt.Errorf("provenance should be a boolean")
+		}
+		f.provenance = provenance
+	}
+
+	if v := vars.Get("fields"); v != "" {
+		for _, field := range strings.Split(v, ",") {
+			if _, ok := candleFields[field]; !ok {
+				return f, fmt.Errorf("unknown field %v", field)
+			}
+			f.fields = append(f.fields, field)
+			if field == "source" {
+				f.provenance = true
+			}
+		}
+	}
+
+	return f, nil
+}
+
+// apply drops the candles not matching the row filters.
+// The candle sources are kept only if provenance is requested.
+func (f candleFilter) apply(candles []models.Candle, interval string) []models.Candle {
+	if f.minVolume == 0 && !f.onlyClosed && f.provenance {
+		return candles
+	}
+
+	now := time.Now().Unix()
+	length := int64(models.IntervalDuration(interval).Seconds())
+
+	result := make([]models.Candle, 0, len(candles))
+	for _, v := range candles {
+		if v.Volume < f.minVolume {
+			continue
+		}
+
+		if f.onlyClosed && v.TimeStart+length > now {
+			continue
+		}
+
+		if !f.provenance {
+			v.Source = ""
+		}
+
+		result = append(result, v)
+	}
+	return result
+}
+
+// project returns the candles with only the selected fields, or nil if all fields are requested.
+func (f candleFilter) project(candles []models.Candle) []map[string]interface{} {
+	if len(f.fields) == 0 {
+		return nil
+	}
+
+	result := make([]map[string]interface{}, 0, len(candles))
+	for i := range candles {
+		item := make(map[string]interface{}, len(f.fields))
+		for _, field := range f.fields {
+			item[field] = candleFields[field](&candles[i])
+		}
+		result = append(result, item)
+	}
+	return result
+}
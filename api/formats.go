@@ -0,0 +1,166 @@
+package api
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"price-feed/models"
+)
+
+const (
+	formatJSON   = "json"
+	formatCSV    = "csv"
+	formatNDJSON = "ndjson"
+	// formatFlushRows is the number of rows between the flushes of a streamed response.
+	formatFlushRows = 1000
+)
+
+var formatContentTypes = map[string]string{
+	formatCSV:    "text/csv",
+	formatNDJSON: "application/x-ndjson",
+}
+
+var tradeFields = []string{"exchange", "symbol", "id", "price", "quantity", "side", "time"}
+
+func parseFormat(vars url.Values) (string, error) {
+	switch v := vars.Get("format"); v {
+	case "", formatJSON:
+		return formatJSON, nil
+	case formatCSV, formatNDJSON:
+		return v, nil
+	default:
+		return "", fmt.Errorf("format should be one of json, csv, ndjson")
+	}
+}
+
+// writeRowsHeader starts a streamed response of the format. The cursor of the next page,
+// which is a field of the JSON responses, is sent in the X-Next-Cursor header.
+func writeRowsHeader(w http.ResponseWriter, format, nextCursor string) {
+	w.Header().Set("Content-Type", formatContentTypes[format])
+	if nextCursor != "" {
+		w.Header().Set("X-Next-Cursor", nextCursor)
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// rowWriter writes the rows of a streamed response, one line per row, flushing
+// the response every formatFlushRows rows.
+type rowWriter struct {
+	w      io.Writer
+	format string
+	csv    *csv.Writer
+	rows   int
+}
+
+func newRowWriter(w io.Writer, format string, header []string) (*rowWriter, error) {
+	rw := &rowWriter{w: w, format: format}
+	if format == formatCSV {
+		rw.csv = csv.NewWriter(w)
+		if err := rw.csv.Write(header); err != nil {
+			return nil, err
+		}
+	}
+	return rw, nil
+}
+
+// write writes the values of the fields, CSV columns or the keys of an NDJSON object.
+func (rw *rowWriter) write(fields []string, values []interface{}) error {
+	if rw.format == formatCSV {
+		record := make([]string, 0, len(values))
+		for _, v := range values {
+			record = append(record, formatValue(v))
+		}
+		if err := rw.csv.Write(record); err != nil {
+			return err
+		}
+	} else {
+		item := make(map[string]interface{}, len(fields))
+		for i, field := range fields {
+			item[field] = values[i]
+		}
+		data, err := json.Marshal(item)
+		if err != nil {
+			return err
+		}
+		if _, err = rw.w.Write(append(data, '\n')); err != nil {
+			return err
+		}
+	}
+
+	rw.rows++
+	if rw.rows%formatFlushRows == 0 {
+		return rw.flush()
+	}
+	return nil
+}
+
+func (rw *rowWriter) flush() error {
+	if rw.csv != nil {
+		rw.csv.Flush()
+		if err := rw.csv.Error(); err != nil {
+			return err
+		}
+	}
+	if f, ok := rw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
+
+func formatValue(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	return fmt.Sprint(v)
+}
+
+// writeCandleRows streams the candles with the selected fields, all of them by default.
+func writeCandleRows(w http.ResponseWriter, format string, candles []models.Candle, fields []string) error {
+	if len(fields) == 0 {
+		fields = allCandleFields
+	}
+
+	rw, err := newRowWriter(w, format, fields)
+	if err != nil {
+		return err
+	}
+
+	values := make([]interface{}, len(fields))
+	for i := range candles {
+		for j, field := range fields {
+			values[j] = candleFields[field](&candles[i])
+		}
+		if err = rw.write(fields, values); err != nil {
+			return err
+		}
+	}
+	return rw.flush()
+}
+
+// writeTradeRows streams the trades.
+func writeTradeRows(w http.ResponseWriter, format string, trades []models.Trade) error {
+	rw, err := newRowWriter(w, format, tradeFields)
+	if err != nil {
+		return err
+	}
+
+	for _, v := range trades {
+		values := []interface{}{v.Exchange, v.Symbol, v.ID, v.Price, v.Quantity, v.Side, v.Time}
+		if err = rw.write(tradeFields, values); err != nil {
+			return err
+		}
+	}
+	return rw.flush()
+}
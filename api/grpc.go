@@ -0,0 +1,421 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"price-feed/models"
+	pricefeed "price-feed/proto"
+)
+
+// defaultStreamDepth is the number of the levels per side pushed by StreamDepth.
+const defaultStreamDepth = 20
+
+// GRPCConfig represents the configuration of the gRPC API, which serves the same data
+// as the REST API with the same API keys and tenants.
+type GRPCConfig struct {
+	Port int `json:"port"`
+}
+
+// grpcServer implements the PriceFeed service on top of the API.
+type grpcServer struct {
+	pricefeed.UnimplementedPriceFeedServer
+	api *API
+}
+
+// StartGRPC starts the gRPC server, it returns once the server is shut down.
+func (api *API) StartGRPC() error {
+	api.log.Infof("Starting gRPC API on port %v", api.config.GRPC.Port)
+
+	listener, err := net.Listen("tcp", ":"+strconv.Itoa(api.config.GRPC.Port))
+	if err != nil {
+		return errors.Wrapf(err, "could not listen on gRPC port %v", api.config.GRPC.Port)
+	}
+
+	pricefeed.RegisterPriceFeedServer(api.grpcServer, &grpcServer{api: api})
+	return api.grpcServer.Serve(listener)
+}
+
+// ShutdownGRPC stops accepting connections and waits for the pending calls until the
+// shutdown timeout passes or the context is done, then closes the remaining connections.
+// The streams end once Shutdown closes the hub clients.
+func (api *API) ShutdownGRPC(ctx context.Context) error {
+	api.log.Infof("Shutting down gRPC API")
+
+	ctx, cancel := context.WithTimeout(ctx, api.shutdownTimeout)
+	defer cancel()
+
+	stopped := make(chan struct{})
+	go func() {
+		api.grpcServer.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		api.grpcServer.Stop()
+		return errors.Wrapf(ctx.Err(), "could not drain pending calls")
+	}
+}
+
+func newGRPCServer(api *API) *grpc.Server {
+	return grpc.NewServer(
+		grpc.UnaryInterceptor(api.grpcUnaryAuth),
+		grpc.StreamInterceptor(api.grpcStreamAuth),
+	)
+}
+
+// grpcAuthenticate authenticates the call like withAPIKey and tenantScoped do the requests,
+// with the key in the authorization metadata as a bearer token or in the x-api-key metadata.
+// The returned context carries the tenant of the key, if any.
+func (api *API) grpcAuthenticate(ctx context.Context) (context.Context, *tenant, error) {
+	var secret string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		const prefix = "bearer "
+		if v := md.Get("authorization"); len(v) > 0 && len(v[0]) > len(prefix) && strings.EqualFold(v[0][:len(prefix)], prefix) {
+			secret = strings.TrimSpace(v[0][len(prefix):])
+		} else if v := md.Get("x-api-key"); len(v) > 0 {
+			secret = v[0]
+		}
+	}
+
+	result := api.lookupSecret(secret)
+	if !result.ok {
+		return ctx, nil, status.Error(codes.Unauthenticated, "api key is invalid")
+	}
+
+	if api.config.RequireAPIKey || len(api.tenants) > 0 {
+		if !result.found {
+			return ctx, nil, status.Error(codes.Unauthenticated, "no api key specified")
+		}
+		if !result.key.HasScope(models.ScopeRead) {
+			return ctx, nil, status.Errorf(codes.PermissionDenied, "api key lacks the %v scope", models.ScopeRead)
+		}
+	}
+
+	if result.tenant == nil {
+		return ctx, nil, nil
+	}
+	if api.quotaExceeded(result.tenant) {
+		return ctx, nil, status.Error(codes.ResourceExhausted, "monthly quota exceeded")
+	}
+	return context.WithValue(ctx, tenantContextKey{}, result.tenant), result.tenant, nil
+}
+
+func (api *API) grpcUnaryAuth(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler) (interface{}, error) {
+
+	ctx, t, err := api.grpcAuthenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := handler(ctx, req)
+	if t != nil {
+		usage := models.Usage{Requests: 1}
+		if m, ok := resp.(proto.Message); ok && err == nil {
+			usage.Bytes = int64(proto.Size(m))
+		}
+		api.recordUsage(t, usage)
+	}
+	return resp, err
+}
+
+// countingServerStream counts the bytes of the messages sent to the stream.
+type countingServerStream struct {
+	grpc.ServerStream
+	ctx   context.Context
+	bytes int64
+}
+
+func (s *countingServerStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *countingServerStream) SendMsg(m interface{}) error {
+	if v, ok := m.(proto.Message); ok {
+		s.bytes += int64(proto.Size(v))
+	}
+	return s.ServerStream.SendMsg(m)
+}
+
+func (api *API) grpcStreamAuth(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler) error {
+
+	ctx, t, err := api.grpcAuthenticate(ss.Context())
+	if err != nil {
+		return err
+	}
+
+	cs := &countingServerStream{ServerStream: ss, ctx: ctx}
+	err = handler(srv, cs)
+	if t != nil {
+		api.recordUsage(t, models.Usage{Requests: 1, Bytes: cs.bytes})
+	}
+	return err
+}
+
+// checkTenant verifies the tenant of the call, if any, may query the symbols and the exchange.
+func checkTenant(ctx context.Context, symbols []string, exchange string) error {
+	t, ok := tenantFromContext(ctx)
+	if !ok {
+		return nil
+	}
+
+	if t.symbols != nil {
+		for _, v := range symbols {
+			if !t.symbols[v] {
+				return status.Error(codes.PermissionDenied, "symbol is not allowed")
+			}
+		}
+	}
+	if t.exchanges != nil && exchange != "" && !t.exchanges[exchange] {
+		return status.Error(codes.PermissionDenied, "exchange is not allowed")
+	}
+	return nil
+}
+
+// GetCandles returns the candles like /candles, paged with the limit and the cursor.
+func (s *grpcServer) GetCandles(ctx context.Context, req *pricefeed.GetCandlesRequest) (*pricefeed.CandlesResponse, error) {
+	if req.Symbol == "" {
+		return nil, status.Error(codes.InvalidArgument, "no pair specified")
+	}
+	if req.Interval == "" {
+		return nil, status.Error(codes.InvalidArgument, "no interval specified")
+	}
+	interval, ok := models.NormalizeInterval(req.Interval)
+	if !ok {
+		return nil, status.Error(codes.InvalidArgument, "interval is invalid")
+	}
+	if err := checkTenant(ctx, []string{req.Symbol}, req.Exchange); err != nil {
+		return nil, err
+	}
+
+	vars := url.Values{}
+	if req.Limit != 0 {
+		vars.Set("limit", strconv.Itoa(int(req.Limit)))
+	}
+	if req.Cursor != "" {
+		vars.Set("cursor", req.Cursor)
+	}
+	page, err := parseCandlePage(vars)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	// Without a range the range ends now and spans limit candles, like /candles.
+	timeStart, timeEnd := req.TimeStart, req.TimeEnd
+	if timeEnd == 0 {
+		timeEnd = time.Now().Unix()
+	}
+	if timeStart == 0 {
+		if req.Limit == 0 {
+			return nil, status.Error(codes.InvalidArgument, "no time_start specified")
+		}
+		timeStart = timeEnd - int64(page.limit)*int64(models.IntervalDuration(interval).Seconds())
+	}
+	loadStart, loadEnd := page.rangeOf(timeStart, timeEnd)
+
+	merge := models.MergeAverage
+	if req.Merge != "" {
+		if !models.IsValidMerge(req.Merge) {
+			return nil, status.Error(codes.InvalidArgument, "merge should be one of average, vwap, median, primary-with-fallback")
+		}
+		merge = req.Merge
+	}
+
+	var candles []models.Candle
+	if req.Exchange == "" {
+		candles, err = s.api.storage.LoadCandlestickListAll(req.Symbol, interval, merge, loadStart, loadEnd)
+	} else {
+		candles, err = s.api.storage.LoadCandlestickListByExchange(req.Exchange, req.Symbol, interval, loadStart, loadEnd)
+	}
+	if err != nil {
+		s.api.log.Errorf("Could not load candles: %v", err)
+		return nil, status.Error(codes.Unavailable, "could not load candles")
+	}
+
+	candles, nextCursor := page.apply(candles, interval)
+
+	resp := &pricefeed.CandlesResponse{
+		TimeStart:  timeStart,
+		TimeEnd:    timeEnd,
+		Candles:    make([]*pricefeed.Candle, 0, len(candles)),
+		NextCursor: nextCursor,
+	}
+	for _, v := range candles {
+		resp.Candles = append(resp.Candles, &pricefeed.Candle{
+			TimeStart: v.TimeStart,
+			TimeEnd:   v.TimeEnd,
+			Time:      v.Time,
+			Open:      v.Open,
+			Close:     v.Close,
+			High:      v.High,
+			Low:       v.Low,
+			Volume:    v.Volume,
+			Anomalous: v.Anomalous,
+			Source:    v.Source,
+		})
+	}
+	return resp, nil
+}
+
+// GetOrderBook returns the top levels of the live order book like /orderBook.
+func (s *grpcServer) GetOrderBook(ctx context.Context, req *pricefeed.GetOrderBookRequest) (*pricefeed.OrderBook, error) {
+	if req.Symbol == "" {
+		return nil, status.Error(codes.InvalidArgument, "no pair specified")
+	}
+	if req.Depth < minDepth || req.Depth > maxDepth {
+		return nil, status.Errorf(codes.InvalidArgument, "depth should be in range [%v; %v]", minDepth, maxDepth)
+	}
+
+	exchange := req.Exchange
+	if exchange == "" {
+		exchange = "binance"
+	}
+	if err := checkTenant(ctx, []string{req.Symbol}, exchange); err != nil {
+		return nil, err
+	}
+
+	provider, ok := s.api.orderBookProviders()[exchange]
+	if !ok {
+		return nil, status.Error(codes.InvalidArgument, "order books are not available for the exchange")
+	}
+
+	orderBook, ok := provider.GetOrderBook(req.Symbol)
+	if !ok {
+		return nil, status.Error(codes.NotFound, "symbol not exists")
+	}
+	return grpcOrderBook(exchange, req.Symbol, orderBook.Format(int(req.Depth), 0)), nil
+}
+
+// StreamTicker pushes the best bid and offer of the symbols on every exchange, like the bbo
+// channels of /ws.
+func (s *grpcServer) StreamTicker(req *pricefeed.StreamRequest, stream pricefeed.PriceFeed_StreamTickerServer) error {
+	return s.api.streamBBO(stream.Context(), req, func(bbo models.BBO) error {
+		return stream.Send(&pricefeed.BBO{
+			Exchange: bbo.Exchange,
+			Symbol:   bbo.Symbol,
+			BidPrice: bbo.BidPrice,
+			BidSize:  bbo.BidSize,
+			AskPrice: bbo.AskPrice,
+			AskSize:  bbo.AskSize,
+			Mid:      bbo.Mid,
+		})
+	})
+}
+
+// StreamDepth pushes the top levels of the order books of the symbols whenever they change.
+// The best bid and offer updates trigger the pushes, so every exchange with a live order book
+// is covered whether the notifier publishes the books or not.
+func (s *grpcServer) StreamDepth(req *pricefeed.StreamRequest, stream pricefeed.PriceFeed_StreamDepthServer) error {
+	depth := defaultStreamDepth
+	if req.Depth != 0 {
+		if req.Depth < minDepth || req.Depth > maxDepth {
+			return status.Errorf(codes.InvalidArgument, "depth should be in range [%v; %v]", minDepth, maxDepth)
+		}
+		depth = int(req.Depth)
+	}
+
+	providers := s.api.orderBookProviders()
+	// sent holds the last levels pushed by exchange and symbol, so unchanged tops aren't repeated.
+	sent := make(map[string]models.OrderBookAPI)
+
+	return s.api.streamBBO(stream.Context(), req, func(bbo models.BBO) error {
+		provider, ok := providers[bbo.Exchange]
+		if !ok {
+			return nil
+		}
+		orderBook, ok := provider.GetOrderBook(bbo.Symbol)
+		if !ok {
+			return nil
+		}
+
+		levels := orderBook.Format(depth, 0)
+		key := bbo.Exchange + "." + bbo.Symbol
+		if last, ok := sent[key]; ok && reflect.DeepEqual(last, levels) {
+			return nil
+		}
+		sent[key] = levels
+
+		return stream.Send(grpcOrderBook(bbo.Exchange, bbo.Symbol, levels))
+	})
+}
+
+// streamBBO subscribes to the bbo channels of the symbols and calls send with every update
+// of the exchanges the tenant of the call may query, until the call or the hub client ends.
+func (api *API) streamBBO(ctx context.Context, req *pricefeed.StreamRequest, send func(models.BBO) error) error {
+	if len(req.Symbols) == 0 {
+		return status.Error(codes.InvalidArgument, "no pair specified")
+	}
+	if err := checkTenant(ctx, req.Symbols, ""); err != nil {
+		return err
+	}
+
+	channels := make([]string, 0, len(req.Symbols))
+	for _, symbol := range req.Symbols {
+		channels = append(channels, "bbo."+symbol)
+	}
+
+	client := api.hub.NewClient()
+	defer client.Close()
+
+	if err := client.Subscribe(channels...); err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-client.Done():
+			return status.Error(codes.Unavailable, "stream closed by the server")
+		case data := <-client.Send():
+			var message struct {
+				Data models.BBO `json:"data"`
+			}
+			if err := json.Unmarshal(data, &message); err != nil {
+				api.log.Warnf("Could not decode hub message: %v", err)
+				continue
+			}
+			if checkTenant(ctx, nil, message.Data.Exchange) != nil {
+				continue
+			}
+			if err := send(message.Data); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func grpcOrderBook(exchange, symbol string, levels models.OrderBookAPI) *pricefeed.OrderBook {
+	return &pricefeed.OrderBook{
+		Exchange: exchange,
+		Symbol:   symbol,
+		Bids:     grpcLevels(levels.Bids),
+		Asks:     grpcLevels(levels.Asks),
+		Time:     time.Now().UnixNano() / int64(time.Millisecond),
+	}
+}
+
+func grpcLevels(levels []models.AskBid) []*pricefeed.Level {
+	result := make([]*pricefeed.Level, 0, len(levels))
+	for _, v := range levels {
+		result = append(result, &pricefeed.Level{Price: v.Price, Size: v.Size})
+	}
+	return result
+}
@@ -0,0 +1,178 @@
+package api
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"price-feed/models"
+	pricefeed "price-feed/proto"
+)
+
+// newTestGRPCClient serves the API over gRPC on a local port and returns a client of it.
+func newTestGRPCClient(t *testing.T, api *API) pricefeed.PriceFeedClient {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %v", err)
+	}
+
+	server := newGRPCServer(api)
+	pricefeed.RegisterPriceFeedServer(server, &grpcServer{api: api})
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("could not dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return pricefeed.NewPriceFeedClient(conn)
+}
+
+func TestGRPCGetOrderBook(t *testing.T) {
+	binance := &fakeExchange{orderBooks: map[string]models.OrderBookInternal{
+		"BTCUSDT": {
+			Bids: map[string]string{"99.5": "1", "99": "2"},
+			Asks: map[string]string{"100": "1.5", "100.5": "2.5"},
+		},
+	}}
+
+	tests := []struct {
+		name string
+		req  *pricefeed.GetOrderBookRequest
+		code codes.Code
+		bids []*pricefeed.Level
+		asks []*pricefeed.Level
+	}{
+		{
+			name: "no symbol",
+			req:  &pricefeed.GetOrderBookRequest{Depth: 10},
+			code: codes.InvalidArgument,
+		},
+		{
+			name: "depth out of range",
+			req:  &pricefeed.GetOrderBookRequest{Symbol: "BTCUSDT", Depth: 1001},
+			code: codes.InvalidArgument,
+		},
+		{
+			name: "exchange without order books",
+			req:  &pricefeed.GetOrderBookRequest{Symbol: "BTCUSDT", Depth: 10, Exchange: "bittrex"},
+			code: codes.InvalidArgument,
+		},
+		{
+			name: "unknown symbol",
+			req:  &pricefeed.GetOrderBookRequest{Symbol: "ETHUSDT", Depth: 10},
+			code: codes.NotFound,
+		},
+		{
+			name: "best levels",
+			req:  &pricefeed.GetOrderBookRequest{Symbol: "BTCUSDT", Depth: 1},
+			code: codes.OK,
+			bids: []*pricefeed.Level{{Price: 99.5, Size: 1}},
+			asks: []*pricefeed.Level{{Price: 100, Size: 1.5}},
+		},
+	}
+
+	client := newTestGRPCClient(t, newTestAPI(&fakeStore{}, binance, nil))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := client.GetOrderBook(context.Background(), tt.req)
+			if code := status.Code(err); code != tt.code {
+				t.Fatalf("code = %v, want %v: %v", code, tt.code, err)
+			}
+			if tt.code != codes.OK {
+				return
+			}
+
+			if resp.Exchange != "binance" || resp.Symbol != tt.req.Symbol {
+				t.Errorf("order book of %v %v, want binance %v", resp.Exchange, resp.Symbol, tt.req.Symbol)
+			}
+			checkLevels(t, "bids", resp.Bids, tt.bids)
+			checkLevels(t, "asks", resp.Asks, tt.asks)
+		})
+	}
+}
+
+func TestGRPCGetCandles(t *testing.T) {
+	candles := []models.Candle{
+		{TimeStart: 60, TimeEnd: 119, Open: 1, Close: 2, High: 3, Low: 0.5, Volume: 10},
+		{TimeStart: 120, TimeEnd: 179, Open: 2, Close: 3, High: 4, Low: 1.5, Volume: 20},
+	}
+	store := &fakeStore{candles: map[string][]models.Candle{"": candles}}
+	client := newTestGRPCClient(t, newTestAPI(store, &fakeExchange{}, nil))
+
+	req := &pricefeed.GetCandlesRequest{Symbol: "BTCUSDT", Interval: "1m", TimeStart: 60, TimeEnd: 300, Limit: 1}
+	if _, err := client.GetCandles(context.Background(), &pricefeed.GetCandlesRequest{Symbol: "BTCUSDT", Interval: "7m", TimeEnd: 300, Limit: 1}); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("invalid interval code = %v, want %v", status.Code(err), codes.InvalidArgument)
+	}
+
+	resp, err := client.GetCandles(context.Background(), req)
+	if err != nil {
+		t.Fatalf("could not get candles: %v", err)
+	}
+	if len(resp.Candles) != 1 || resp.Candles[0].TimeStart != 60 || resp.Candles[0].Volume != 10 {
+		t.Fatalf("candles = %v, want the first one", resp.Candles)
+	}
+	if resp.NextCursor != "120" {
+		t.Fatalf("next cursor = %q, want 120", resp.NextCursor)
+	}
+
+	req.Cursor = resp.NextCursor
+	if resp, err = client.GetCandles(context.Background(), req); err != nil {
+		t.Fatalf("could not get next page: %v", err)
+	}
+	if len(resp.Candles) != 1 || resp.Candles[0].TimeStart != 120 || resp.NextCursor != "" {
+		t.Fatalf("candles = %v, cursor %q, want the last one", resp.Candles, resp.NextCursor)
+	}
+}
+
+func TestGRPCRequireAPIKey(t *testing.T) {
+	api := newTestAPI(&fakeStore{}, &fakeExchange{}, nil)
+	api.config.RequireAPIKey = true
+	api.config.Token = "secret"
+	client := newTestGRPCClient(t, api)
+
+	tests := []struct {
+		name string
+		md   metadata.MD
+		code codes.Code
+	}{
+		{name: "no key", code: codes.Unauthenticated},
+		{name: "unknown key", md: metadata.Pairs("x-api-key", "guess"), code: codes.Unauthenticated},
+		{name: "bearer token", md: metadata.Pairs("authorization", "Bearer secret"), code: codes.NotFound},
+		{name: "key header", md: metadata.Pairs("x-api-key", "secret"), code: codes.NotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(metadata.NewOutgoingContext(context.Background(), tt.md), 5*time.Second)
+			defer cancel()
+
+			// The book is missing, so an authenticated call fails with NotFound.
+			_, err := client.GetOrderBook(ctx, &pricefeed.GetOrderBookRequest{Symbol: "BTCUSDT", Depth: 10})
+			if code := status.Code(err); code != tt.code {
+				t.Fatalf("code = %v, want %v: %v", code, tt.code, err)
+			}
+		})
+	}
+}
+
+func checkLevels(t *testing.T, side string, levels, want []*pricefeed.Level) {
+	t.Helper()
+
+	if len(levels) != len(want) {
+		t.Fatalf("%v = %v, want %v", side, levels, want)
+	}
+	for i := range levels {
+		if levels[i].Price != want[i].Price || levels[i].Size != want[i].Size {
+			t.Fatalf("%v = %v, want %v", side, levels, want)
+		}
+	}
+}
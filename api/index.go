@@ -0,0 +1,73 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"price-feed/aggregator"
+	"price-feed/models"
+)
+
+func (api *API) handleIndexRequest(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	pipeline, ok := api.aggregator.Pipeline(name)
+	if !ok {
+		writeError(w, http.StatusNotFound, "index not exists")
+		return
+	}
+
+	vars := r.URL.Query()
+
+	timeStarts, ok := vars["timeStart"]
+	if !ok || len(timeStarts) == 0 {
+		writeError(w, http.StatusBadRequest, "no timeStart specified")
+		return
+	}
+	timeStart, err := strconv.ParseInt(timeStarts[0], 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "timeStart is not a number")
+		return
+	}
+
+	timeEnds, ok := vars["timeEnd"]
+	if !ok || len(timeEnds) == 0 {
+		writeError(w, http.StatusBadRequest, "no timeEnd specified")
+		return
+	}
+	timeEnd, err := strconv.ParseInt(timeEnds[0], 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "timeEnd is not a number")
+		return
+	}
+
+	candles, err := api.storage.LoadCandlestickListByExchange(aggregator.IndexExchange, pipeline.Series(pipeline.Version),
+		pipeline.Interval, timeStart, timeEnd)
+	if err != nil {
+		api.requestLog(r).Errorf("Could not load index candles: %v", err)
+		api.writeStorageError(w, "could not load candles")
+		return
+	}
+
+	response := models.CandlestickResponse{
+		TimeStart: timeStart,
+		TimeEnd:   timeEnd,
+		Candles:   candles,
+	}
+
+	data, err := json.Marshal(response)
+	if err != nil {
+		api.requestLog(r).Errorf("Could not marshal json: %v", err)
+		writeError(w, http.StatusInternalServerError, "could not load candles")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if _, err = w.Write(data); err != nil {
+		api.requestLog(r).Errorf("Could not write response: %v", err)
+		return
+	}
+}
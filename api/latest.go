@@ -0,0 +1,154 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"price-feed/hub"
+	"price-feed/models"
+	"price-feed/tracing"
+)
+
+const (
+	defaultLatestExchange = "binance"
+	// defaultLongPollTimeout is how long a waitForUpdate request is held, at most maxLongPollTimeout.
+	defaultLongPollTimeout = 30 * time.Second
+	maxLongPollTimeout     = time.Minute
+)
+
+type latestCandleResponse struct {
+	Exchange string `json:"exchange"`
+	Symbol   string `json:"symbol"`
+	Interval string `json:"interval"`
+	// Updated is false if there is no candle newer than since, e.g. the wait timed out.
+	Updated bool           `json:"updated"`
+	Candle  *models.Candle `json:"candle"`
+}
+
+// handleLatestCandleRequest serves the latest candle of the exchange. With waitForUpdate=true
+// the request is held until a candle newer than the since open time exists or the timeout
+// expires, so the clients which can't use WS or SSE get the updates in near real time.
+func (api *API) handleLatestCandleRequest(w http.ResponseWriter, r *http.Request) {
+	vars := r.URL.Query()
+
+	symbol := vars.Get("symbol")
+	if symbol == "" {
+		writeError(w, http.StatusBadRequest, "no pair specified")
+		return
+	}
+
+	interval, ok := models.NormalizeInterval(vars.Get("interval"))
+	if !ok {
+		writeError(w, http.StatusBadRequest, "interval is invalid")
+		return
+	}
+
+	exchange := defaultLatestExchange
+	if v := vars.Get("exchange"); v != "" {
+		exchange = v
+	}
+
+	var since int64
+	if v := vars.Get("since"); v != "" {
+		var err error
+		if since, err = strconv.ParseInt(v, 10, 64); err != nil {
+			writeError(w, http.StatusBadRequest, "since is not a number")
+			return
+		}
+	}
+
+	wait := vars.Get("waitForUpdate") == "true"
+	timeout := defaultLongPollTimeout
+	if v := vars.Get("timeout"); v != "" {
+		var err error
+		if timeout, err = time.ParseDuration(v); err != nil || timeout <= 0 {
+			writeError(w, http.StatusBadRequest, "timeout is invalid")
+			return
+		}
+		if timeout > maxLongPollTimeout {
+			timeout = maxLongPollTimeout
+		}
+	}
+
+	resp := latestCandleResponse{Exchange: exchange, Symbol: symbol, Interval: interval}
+
+	// Subscribe before loading the stored candle, so an update in between isn't missed.
+	var client *hub.Client
+	if wait {
+		client = api.hub.NewClient()
+		defer client.Close()
+
+		if err := client.Subscribe("kline." + interval + "." + symbol); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	span := tracing.FromContext(r.Context()).Child("storage.load_last_candle")
+	candle, ok, err := api.storage.LoadLastCandlestick(exchange, symbol, interval)
+	span.SetError(err)
+	span.End()
+	if err != nil {
+		api.requestLog(r).Errorf("Could not load latest candle: %v", err)
+		api.writeStorageError(w, "could not load candle")
+		return
+	}
+	if ok {
+		resp.Candle = &candle
+		resp.Updated = candle.TimeStart > since
+	}
+
+	if wait && !resp.Updated {
+		if update, ok := api.waitForCandle(r, client, exchange, since, timeout); ok {
+			resp.Candle, resp.Updated = &update, true
+		}
+	}
+
+	data, err := json.Marshal(resp)
+	if err != nil {
+		api.requestLog(r).Errorf("Could not marshal json: %v", err)
+		writeError(w, http.StatusInternalServerError, "could not load candle")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if _, err = w.Write(data); err != nil {
+		api.requestLog(r).Errorf("Could not write response: %v", err)
+		return
+	}
+}
+
+// waitForCandle returns the first candle update of the exchange opened after since.
+// ok is false if the timeout expired or the request was cancelled first.
+func (api *API) waitForCandle(r *http.Request, client *hub.Client, exchange string, since int64,
+	timeout time.Duration) (candle models.Candle, ok bool) {
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return models.Candle{}, false
+		case <-client.Done():
+			return models.Candle{}, false
+		case <-timer.C:
+			return models.Candle{}, false
+		case message := <-client.Send():
+			var update struct {
+				Data models.CandleUpdate `json:"data"`
+			}
+			if err := json.Unmarshal(message, &update); err != nil {
+				api.requestLog(r).Errorf("Could not unmarshal hub message: %v", err)
+				continue
+			}
+
+			if update.Data.Exchange == exchange && update.Data.Candle.TimeStart > since {
+				return update.Data.Candle, true
+			}
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package api
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"price-feed/aggregator"
+)
+
+const (
+	defaultLeaderboardLimit = 20
+	leaderboardSortVolume   = "volume"
+	leaderboardSortMovers   = "movers"
+	leaderboardSortGainers  = "gainers"
+	leaderboardSortLosers   = "losers"
+)
+
+type leaderboardResponse struct {
+	Updated int64                         `json:"updated"`
+	Window  string                        `json:"window"`
+	Sort    string                        `json:"sort"`
+	Symbols []aggregator.LeaderboardEntry `json:"symbols"`
+}
+
+// handleLeaderboardRequest ranks the tracked symbols by their 24h USD volume, or by their
+// absolute price change (movers), the price rise (gainers) or the price fall (losers).
+func (api *API) handleLeaderboardRequest(w http.ResponseWriter, r *http.Request) {
+	vars := r.URL.Query()
+
+	order := vars.Get("sort")
+	switch order {
+	case "":
+		order = leaderboardSortVolume
+	case leaderboardSortVolume, leaderboardSortMovers, leaderboardSortGainers, leaderboardSortLosers:
+	default:
+		writeError(w, http.StatusBadRequest, "sort should be one of volume, movers, gainers, losers")
+		return
+	}
+
+	limit := defaultLeaderboardLimit
+	if v := vars.Get("limit"); v != "" {
+		var err error
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit < 1 {
+			writeError(w, http.StatusBadRequest, "limit should be a positive number")
+			return
+		}
+	}
+
+	entries, updated := api.aggregator.Leaderboard()
+
+	allowed := make(map[string]bool)
+	symbols := make([]string, 0, len(entries))
+	for _, v := range entries {
+		symbols = append(symbols, v.Symbol)
+	}
+	for _, v := range allowedSymbols(r, symbols) {
+		allowed[v] = true
+	}
+
+	resp := leaderboardResponse{
+		Window:  "24h",
+		Sort:    order,
+		Symbols: make([]aggregator.LeaderboardEntry, 0, len(entries)),
+	}
+	if !updated.IsZero() {
+		resp.Updated = updated.Unix()
+	}
+
+	for _, v := range entries {
+		if allowed[v.Symbol] {
+			resp.Symbols = append(resp.Symbols, v)
+		}
+	}
+
+	// The entries come sorted by volume, which breaks the ties of the other orders.
+	sort.SliceStable(resp.Symbols, func(i, j int) bool {
+		a, b := resp.Symbols[i].PriceChange, resp.Symbols[j].PriceChange
+		switch order {
+		case leaderboardSortMovers:
+			return math.Abs(a) > math.Abs(b)
+		case leaderboardSortGainers:
+			return a > b
+		case leaderboardSortLosers:
+			return a < b
+		}
+		return false
+	})
+
+	if len(resp.Symbols) > limit {
+		resp.Symbols = resp.Symbols[:limit]
+	}
+
+	data, err := json.Marshal(resp)
+	if err != nil {
+		api.requestLog(r).Errorf("Could not marshal json: %v", err)
+		writeError(w, http.StatusInternalServerError, "could not load leaderboard")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if _, err = w.Write(data); err != nil {
+		api.requestLog(r).Errorf("Could not write response: %v", err)
+		return
+	}
+}
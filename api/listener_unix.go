@@ -0,0 +1,22 @@
+//go:build !windows
+// +build !windows
+
+package api
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// setReusePort lets a new process bind the port while the old one still serves it.
+func setReusePort(network, address string, conn syscall.RawConn) error {
+	var opErr error
+	err := conn.Control(func(fd uintptr) {
+		opErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+	})
+	if err != nil {
+		return err
+	}
+	return opErr
+}
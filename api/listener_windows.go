@@ -0,0 +1,13 @@
+//go:build windows
+// +build windows
+
+package api
+
+import (
+	"fmt"
+	"syscall"
+)
+
+func setReusePort(network, address string, conn syscall.RawConn) error {
+	return fmt.Errorf("SO_REUSEPORT is not supported on windows")
+}
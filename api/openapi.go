@@ -0,0 +1,472 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"price-feed/models"
+)
+
+const openAPIVersion = "3.1.0"
+
+// apiParam represents a parameter of an endpoint.
+type apiParam struct {
+	name, in, typ, description string
+	required                   bool
+}
+
+func queryParam(name, typ, description string) apiParam {
+	return apiParam{name: name, in: "query", typ: typ, description: description}
+}
+
+func requiredParam(name, typ, description string) apiParam {
+	return apiParam{name: name, in: "query", typ: typ, description: description, required: true}
+}
+
+func pathParam(name, description string) apiParam {
+	return apiParam{name: name, in: "path", typ: "string", description: description, required: true}
+}
+
+// apiEndpoint documents a route of the API in the OpenAPI spec.
+type apiEndpoint struct {
+	summary string
+	params  []apiParam
+	// body is the schema of the JSON request body, if any.
+	body string
+	// status is the status of a successful response, 200 by default.
+	status int
+	// schemas are the JSON response schemas, any of which may be returned.
+	schemas []string
+	// mediaTypes are the other types the response may be encoded with.
+	mediaTypes []string
+	// scope is the scope of the API key the endpoint requires.
+	scope string
+}
+
+var (
+	symbolParam   = requiredParam("symbol", "string", "Symbol in the Binance format, e.g. ETHBTC.")
+	intervalParam = requiredParam("interval", "string", "Candle interval, e.g. 1m, 1h or 1d.")
+	debugParam    = queryParam("debug", "boolean", "Bypasses the cache and returns the trace of the storage queries; needs an admin API key.")
+	formatParam   = queryParam("format", "string", "json, csv or ndjson; json by default.")
+
+	candleFilterParams = []apiParam{
+		queryParam("minVolume", "number", "Skips the candles with a lower volume."),
+		queryParam("onlyClosed", "boolean", "Skips the candle of the current interval."),
+		queryParam("provenance", "boolean", "Adds the ingestion path of the candles."),
+		queryParam("fields", "string", "Comma separated candle fields to return."),
+	}
+)
+
+// endpointDocs documents the routes by method and path template.
+var endpointDocs = map[string]apiEndpoint{
+	"GET /api/v1/orderBook": {
+		summary: "Returns the top levels of the live order book.",
+		params: []apiParam{
+			symbolParam,
+			requiredParam("depth", "integer", "Number of levels per side, from 1 to 1000."),
+			queryParam("exchange", "string", "Exchange of the order book, binance by default."),
+			queryParam("groupBy", "number", "Price increment the levels are bucketed by."),
+		},
+		schemas:    []string{"orderBook"},
+		mediaTypes: []string{mediaTypeProtobuf, mediaTypeMsgpack},
+		scope:      models.ScopeRead,
+	},
+	"GET /api/v1/orderBook/consolidated": {
+		summary: "Returns the order books of the exchanges merged into one.",
+		params: []apiParam{
+			symbolParam,
+			queryParam("depth", "integer", "Number of levels per side, 100 by default."),
+			queryParam("exchanges", "string", "Comma separated exchanges to merge, all by default."),
+			queryParam("groupBy", "number", "Price increment the levels are bucketed by."),
+		},
+		schemas: []string{"consolidatedBook"},
+		scope:   models.ScopeRead,
+	},
+	"GET /api/v1/bbo": {
+		summary: "Returns the best bid and offer of every exchange and the composite one.",
+		params:  []apiParam{symbolParam},
+		schemas: []string{"bbo"},
+		scope:   models.ScopeRead,
+	},
+	"GET /api/v1/candles": {
+		summary: "Returns the stored candles of the symbol.",
+		params: append([]apiParam{
+			symbolParam,
+			intervalParam,
+			queryParam("timeStart", "integer", "Start of the range in seconds; required without limit."),
+			queryParam("timeEnd", "integer", "End of the range in seconds, now by default."),
+			queryParam("exchange", "string", "Exchange of the candles, all exchanges merged by default."),
+			queryParam("merge", "string", "average, vwap, median or primary-with-fallback; average by default."),
+			queryParam("rollup", "boolean", "Returns the rollups of the 1d and 1w intervals."),
+			queryParam("limit", "integer", "Maximum number of candles, up to 5000."),
+			queryParam("order", "string", "asc or desc; asc by default."),
+			queryParam("cursor", "string", "nextCursor of the previous page."),
+			queryParam("encoding", "string", "objects or columns; objects by default."),
+			queryParam("units", "string", "quote, usd or sat; quote by default."),
+			formatParam,
+			debugParam,
+		}, candleFilterParams...),
+		schemas:    []string{"candles", "candlesProjected", "candlesColumnar"},
+		mediaTypes: []string{formatContentTypes[formatCSV], formatContentTypes[formatNDJSON], mediaTypeProtobuf, mediaTypeMsgpack},
+		scope:      models.ScopeRead,
+	},
+	"GET /api/v1/candles/latest": {
+		summary: "Returns the latest candle, optionally waiting for a newer one.",
+		params: []apiParam{
+			symbolParam,
+			intervalParam,
+			queryParam("exchange", "string", "Exchange of the candle, binance by default."),
+			queryParam("since", "integer", "Open time the returned candle must be newer than."),
+			queryParam("waitForUpdate", "boolean", "Holds the request until a newer candle exists."),
+			queryParam("timeout", "string", "Longest wait for an update, e.g. 30s."),
+		},
+		schemas: []string{"latestCandle"},
+		scope:   models.ScopeRead,
+	},
+	"GET /api/v1/index/{name}": {
+		summary: "Returns the candles of an index.",
+		params: []apiParam{
+			pathParam("name", "Name of the index pipeline."),
+			requiredParam("timeStart", "integer", "Start of the range in seconds."),
+			requiredParam("timeEnd", "integer", "End of the range in seconds."),
+		},
+		schemas: []string{"index"},
+		scope:   models.ScopeRead,
+	},
+	"GET /api/v1/volatility": {
+		summary: "Returns the realized volatility of the symbol.",
+		params: []apiParam{
+			symbolParam,
+			intervalParam,
+			queryParam("exchange", "string", "Exchange of the candles, all exchanges merged by default."),
+			queryParam("window", "integer", "Number of candles, from 2 to 1000; may be repeated."),
+			debugParam,
+		},
+		schemas: []string{"volatility"},
+		scope:   models.ScopeRead,
+	},
+	"GET /api/v1/volume": {
+		summary: "Returns the traded volume of the symbol by exchange.",
+		params: []apiParam{
+			symbolParam,
+			queryParam("window", "string", "Duration up to 30d, e.g. 24h or 7d; 24h by default."),
+		},
+		schemas: []string{"volume"},
+		scope:   models.ScopeRead,
+	},
+	"GET /api/v1/tape": {
+		summary: "Returns the trades of all exchanges, newest first.",
+		params: []apiParam{
+			symbolParam,
+			queryParam("limit", "integer", "Maximum number of trades, up to 5000."),
+			queryParam("from", "integer", "Start of the range in milliseconds."),
+			queryParam("to", "integer", "End of the range in milliseconds."),
+			formatParam,
+			debugParam,
+		},
+		schemas:    []string{"tape"},
+		mediaTypes: []string{formatContentTypes[formatCSV], formatContentTypes[formatNDJSON]},
+		scope:      models.ScopeRead,
+	},
+	"GET /api/v1/trades": {
+		summary: "Returns the latest trades of the exchange.",
+		params: []apiParam{
+			symbolParam,
+			queryParam("exchange", "string", "Exchange of the trades, binance by default."),
+			queryParam("limit", "integer", "Maximum number of trades, up to 1000."),
+			formatParam,
+			debugParam,
+		},
+		schemas:    []string{"trades"},
+		mediaTypes: []string{formatContentTypes[formatCSV], formatContentTypes[formatNDJSON]},
+		scope:      models.ScopeRead,
+	},
+	"GET /api/v1/twap": {
+		summary: "Returns the time weighted average of the order book mid price.",
+		params: []apiParam{
+			symbolParam,
+			queryParam("window", "string", "Duration of the average, 1h by default."),
+			queryParam("resolution", "string", "Sampling step, at least 100ms; 1s by default."),
+			queryParam("to", "integer", "End of the window in milliseconds, now by default."),
+			debugParam,
+		},
+		schemas: []string{"twap"},
+		scope:   models.ScopeRead,
+	},
+	"GET /api/v1/export": {
+		summary: "Streams the candles as NDJSON, resumable with a Range header.",
+		params: append([]apiParam{
+			symbolParam,
+			intervalParam,
+			queryParam("exchange", "string", "Exchange of the candles, all exchanges merged by default."),
+			queryParam("timeStart", "integer", "Start of the range in seconds."),
+			queryParam("timeEnd", "integer", "End of the range in seconds."),
+		}, candleFilterParams...),
+		mediaTypes: []string{formatContentTypes[formatNDJSON]},
+		scope:      models.ScopeRead,
+	},
+	"GET /api/v1/leaderboard": {
+		summary: "Returns the symbols ranked by volume or price change.",
+		params: []apiParam{
+			queryParam("sort", "string", "volume, movers, gainers or losers."),
+			queryParam("limit", "integer", "Number of symbols, 20 by default."),
+		},
+		schemas: []string{"leaderboard"},
+		scope:   models.ScopeRead,
+	},
+	"GET /api/v1/prices": {
+		summary: "Returns the latest prices of all symbols.",
+		params:  []apiParam{queryParam("units", "string", "quote, usd or sat; quote by default.")},
+		schemas: []string{"prices"},
+		scope:   models.ScopeRead,
+	},
+	"GET /api/v1/symbols": {
+		summary: "Returns the symbols of every exchange and their trading statuses.",
+		schemas: []string{"symbols"},
+		scope:   models.ScopeRead,
+	},
+	"GET /api/v1/ws": {
+		summary: "Upgrades to a WebSocket streaming the subscribed channels, see the ws schemas.",
+		status:  http.StatusSwitchingProtocols,
+		scope:   models.ScopeRead,
+	},
+	"GET /api/v1/stream": {
+		summary: "Streams the candle updates of the symbol as server-sent events.",
+		params: []apiParam{
+			symbolParam,
+			queryParam("interval", "string", "Candle interval, 1m by default."),
+		},
+		mediaTypes: []string{"text/event-stream"},
+		scope:      models.ScopeRead,
+	},
+	"POST /api/v1/webhooks": {
+		summary: "Registers a webhook.",
+		body:    "webhookRequest",
+		status:  http.StatusCreated,
+		schemas: []string{"webhook"},
+		scope:   models.ScopeRead,
+	},
+	"GET /api/v1/webhooks": {
+		summary: "Returns the registered webhooks.",
+		schemas: []string{"webhooks"},
+		scope:   models.ScopeRead,
+	},
+	"DELETE /api/v1/webhooks/{id}": {
+		summary: "Deletes a webhook.",
+		params:  []apiParam{pathParam("id", "ID of the webhook.")},
+		status:  http.StatusNoContent,
+		scope:   models.ScopeRead,
+	},
+	"GET /api/v1/schemas": {
+		summary: "Returns the names of the JSON schemas.",
+		schemas: []string{"schemas"},
+	},
+	"GET /api/v1/schemas/{name}": {
+		summary:    "Returns a JSON schema.",
+		params:     []apiParam{pathParam("name", "Name of the schema.")},
+		mediaTypes: []string{"application/schema+json"},
+	},
+	"GET /api/v1/status": {
+		summary: "Returns the health of the streams and the storage.",
+		schemas: []string{"status"},
+	},
+	"GET /api/v1/openapi.json": {
+		summary:    "Returns this specification.",
+		mediaTypes: []string{"application/json"},
+	},
+	"POST /api/v1/reload": {
+		summary: "Reloads the candle caches of the workers.",
+		scope:   models.ScopeAdmin,
+	},
+	"GET /api/v1/subscribe": {
+		summary: "Subscribes a worker to a new symbol.",
+		params: []apiParam{
+			requiredParam("exchange", "string", "Exchange of the worker."),
+			requiredParam("symbol", "string", "Symbol in the format of the exchange."),
+		},
+		scope: models.ScopeAdmin,
+	},
+	"GET /api/v1/deadLetters": {
+		summary: "Returns the latest events which could not be processed.",
+		params:  []apiParam{queryParam("limit", "integer", "Number of dead letters, 100 by default.")},
+		schemas: []string{"deadLetters"},
+		scope:   models.ScopeAdmin,
+	},
+	"GET /api/v1/usage": {
+		summary: "Returns the usage of the tenants.",
+		params:  []apiParam{queryParam("month", "string", "Month in YYYY-MM format, the current one by default.")},
+		schemas: []string{"usage"},
+		scope:   models.ScopeAdmin,
+	},
+	"POST /api/v1/apiKeys": {
+		summary: "Issues an API key, which is only returned once.",
+		body:    "apiKeyRequest",
+		status:  http.StatusCreated,
+		schemas: []string{"apiKey"},
+		scope:   models.ScopeAdmin,
+	},
+	"GET /api/v1/apiKeys": {
+		summary: "Returns the API keys without the keys themselves.",
+		schemas: []string{"apiKeys"},
+		scope:   models.ScopeAdmin,
+	},
+	"DELETE /api/v1/apiKeys/{id}": {
+		summary: "Revokes an API key.",
+		params:  []apiParam{pathParam("id", "ID of the API key.")},
+		status:  http.StatusNoContent,
+		scope:   models.ScopeAdmin,
+	},
+	"GET /metrics": {
+		summary:    "Returns the Prometheus metrics.",
+		mediaTypes: []string{"text/plain"},
+	},
+}
+
+// generateOpenAPI returns the OpenAPI spec of the routes of the router. The routes
+// missing from endpointDocs are logged, so the docs are kept in sync with the handlers.
+func (api *API) generateOpenAPI(r *mux.Router) ([]byte, error) {
+	paths := make(map[string]map[string]interface{})
+
+	err := r.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		// The routes without methods are the subrouters and the profiling endpoints.
+		methods, err := route.GetMethods()
+		if err != nil {
+			return nil
+		}
+
+		for _, method := range methods {
+			doc, ok := endpointDocs[method+" "+path]
+			if !ok {
+				api.log.Warnf("Route %v %v is missing from the OpenAPI spec", method, path)
+				doc = apiEndpoint{summary: "Undocumented."}
+			}
+
+			if paths[path] == nil {
+				paths[path] = make(map[string]interface{})
+			}
+			paths[path][strings.ToLower(method)] = api.openAPIOperation(doc)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	componentSchemas := make(map[string]interface{}, len(schemas))
+	for name, s := range schemas {
+		// The schemas are draft-07 documents, the dialect of the spec is set once.
+		component := make(map[string]interface{}, len(s))
+		for k, v := range s {
+			if k != "$schema" {
+				component[k] = v
+			}
+		}
+		componentSchemas[name] = component
+	}
+
+	return json.Marshal(map[string]interface{}{
+		"openapi": openAPIVersion,
+		"info": map[string]interface{}{
+			"title":   "Price feed API",
+			"version": strings.TrimPrefix(v1Prefix, "/api/"),
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": componentSchemas,
+			"securitySchemes": map[string]interface{}{
+				"apiKey": map[string]interface{}{"type": "http", "scheme": "bearer"},
+			},
+		},
+	})
+}
+
+func (api *API) openAPIOperation(doc apiEndpoint) map[string]interface{} {
+	op := map[string]interface{}{"summary": doc.summary}
+
+	if len(doc.params) > 0 {
+		params := make([]interface{}, 0, len(doc.params))
+		for _, v := range doc.params {
+			params = append(params, map[string]interface{}{
+				"name":        v.name,
+				"in":          v.in,
+				"description": v.description,
+				"required":    v.required,
+				"schema":      map[string]interface{}{"type": v.typ},
+			})
+		}
+		op["parameters"] = params
+	}
+
+	if doc.body != "" {
+		op["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": schemaRef(doc.body)},
+			},
+		}
+	}
+
+	// The read scope is only required if the config says so.
+	if doc.scope == models.ScopeAdmin || doc.scope == models.ScopeRead && api.config.RequireAPIKey {
+		op["security"] = []interface{}{map[string]interface{}{"apiKey": []string{}}}
+		op["description"] = "Needs an API key with the " + doc.scope + " scope."
+	}
+
+	content := make(map[string]interface{})
+	switch len(doc.schemas) {
+	case 0:
+	case 1:
+		content["application/json"] = map[string]interface{}{"schema": schemaRef(doc.schemas[0])}
+	default:
+		refs := make([]interface{}, 0, len(doc.schemas))
+		for _, v := range doc.schemas {
+			refs = append(refs, schemaRef(v))
+		}
+		content["application/json"] = map[string]interface{}{"schema": map[string]interface{}{"oneOf": refs}}
+	}
+	for _, v := range doc.mediaTypes {
+		if _, ok := content[v]; !ok {
+			content[v] = map[string]interface{}{}
+		}
+	}
+
+	status := doc.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	response := map[string]interface{}{"description": http.StatusText(status)}
+	if len(content) > 0 {
+		response["content"] = content
+	}
+
+	op["responses"] = map[string]interface{}{
+		strconv.Itoa(status): response,
+		"default": map[string]interface{}{
+			"description": "The error.",
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": schemaRef("error")},
+			},
+		},
+	}
+	return op
+}
+
+func schemaRef(name string) map[string]interface{} {
+	return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+}
+
+func (api *API) handleOpenAPIRequest(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(api.openAPI); err != nil {
+		api.requestLog(r).Errorf("Could not write response: %v", err)
+		return
+	}
+}
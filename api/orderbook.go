@@ -3,7 +3,9 @@ package api
 import (
 	"encoding/json"
 	"fmt"
+	"math"
 	"net/http"
+	"net/url"
 	"strconv"
 
 	"price-feed/models"
@@ -21,60 +23,104 @@ type orderBookResponse struct {
 
 type orderBookResponseInternal struct {
 	Symbol string `json:"symbol"`
+	// GroupBy is the price increment the levels are bucketed by, zero for the raw levels.
+	GroupBy float64 `json:"groupBy,omitempty"`
 	models.OrderBookAPI
 }
 
+// parseGroupBy returns the price increment of the groupBy parameter, zero if it is absent.
+func parseGroupBy(w http.ResponseWriter, vars url.Values) (float64, bool) {
+	v := vars.Get("groupBy")
+	if v == "" {
+		return 0, true
+	}
+
+	groupBy, err := strconv.ParseFloat(v, 64)
+	if err != nil || !(groupBy > 0) || math.IsInf(groupBy, 0) {
+		writeError(w, http.StatusBadRequest, "groupBy should be a positive price increment")
+		return 0, false
+	}
+	return groupBy, true
+}
+
 func (api *API) handleOrderBookRequest(w http.ResponseWriter, r *http.Request) {
 	vars := r.URL.Query()
 
 	symbols, ok := vars["symbol"]
 	if !ok || len(symbols) == 0 {
-		http.Error(w, "no pair specified", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, "no pair specified")
 		return
 	}
 	symbol := symbols[0]
 
 	depths, ok := vars["depth"]
 	if !ok || len(depths) == 0 {
-		http.Error(w, "no depth specified", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, "no depth specified")
 		return
 	}
 	depthStr := depths[0]
 
 	depth, err := strconv.Atoi(depthStr)
 	if err != nil {
-		http.Error(w, "depth should be a number", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, "depth should be a number")
 		return
 	}
 
 	if depth < minDepth || depth > maxDepth {
-		http.Error(w, fmt.Sprintf("depth should be in range [%v; %v]", minDepth, maxDepth), http.StatusBadRequest)
+		writeErrorDetails(w, http.StatusBadRequest, errorInvalidRequest,
+			fmt.Sprintf("depth should be in range [%v; %v]", minDepth, maxDepth),
+			map[string]interface{}{"min": minDepth, "max": maxDepth})
+		return
+	}
+
+	groupBy, ok := parseGroupBy(w, vars)
+	if !ok {
 		return
 	}
 
-	orderBook, ok := api.binance.GetOrderBook(symbol)
+	exchange := vars.Get("exchange")
+	if exchange == "" {
+		exchange = "binance"
+	}
+
+	var provider OrderBookProvider = api.binance
+	if exchange != "binance" {
+		optional, ok := api.optional[exchange]
+		if !ok {
+			writeError(w, http.StatusBadRequest, "order books are not available for the exchange")
+			return
+		}
+		provider = optional
+	}
+
+	orderBook, ok := provider.GetOrderBook(symbol)
 	if !ok {
-		http.Error(w, "symbol not exists", http.StatusBadRequest)
+		writeError(w, http.StatusNotFound, "symbol not exists")
 		return
 	}
 
 	resp := orderBookResponseInternal{
 		Symbol:       symbol,
-		OrderBookAPI: orderBook.Format(depth),
+		GroupBy:      groupBy,
+		OrderBookAPI: orderBook.Format(depth, groupBy),
+	}
+
+	if mediaType := negotiateBinary(r); mediaType != "" {
+		api.writeBinary(w, r, mediaType, encodeOrderBook(mediaType, exchange, resp))
+		return
 	}
 
 	data, err := json.Marshal(resp)
 	if err != nil {
-		api.log.Errorf("Could not marshal json: %v", err)
-		http.Error(w, "could not load order book", http.StatusInternalServerError)
+		api.requestLog(r).Errorf("Could not marshal json: %v", err)
+		writeError(w, http.StatusInternalServerError, "could not load order book")
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.WriteHeader(http.StatusOK)
 	if _, err = w.Write(data); err != nil {
-		api.log.Errorf("Could not write response: %v", err)
+		api.requestLog(r).Errorf("Could not write response: %v", err)
 		return
 	}
 }
@@ -0,0 +1,124 @@
+package api
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"price-feed/models"
+)
+
+func TestHandleOrderBookRequest(t *testing.T) {
+	binance := &fakeExchange{orderBooks: map[string]models.OrderBookInternal{
+		"BTCUSDT": {
+			Bids: map[string]string{"99.5": "1", "99": "2", "98": "3"},
+			Asks: map[string]string{"100": "1.5", "100.5": "2.5", "101": "4"},
+		},
+	}}
+	coinbase := &fakeExchange{orderBooks: map[string]models.OrderBookInternal{
+		"BTCUSDT": {
+			Bids: map[string]string{"99.4": "5"},
+			Asks: map[string]string{"100.2": "6"},
+		},
+	}}
+
+	tests := []struct {
+		name   string
+		target string
+		status int
+		code   string
+		want   orderBookResponseInternal
+	}{
+		{
+			name:   "no symbol",
+			target: "/api/v1/orderBook?depth=10",
+			status: http.StatusBadRequest,
+			code:   errorInvalidRequest,
+		},
+		{
+			name:   "no depth",
+			target: "/api/v1/orderBook?symbol=BTCUSDT",
+			status: http.StatusBadRequest,
+			code:   errorInvalidRequest,
+		},
+		{
+			name:   "invalid depth",
+			target: "/api/v1/orderBook?symbol=BTCUSDT&depth=ten",
+			status: http.StatusBadRequest,
+			code:   errorInvalidRequest,
+		},
+		{
+			name:   "depth out of range",
+			target: "/api/v1/orderBook?symbol=BTCUSDT&depth=1001",
+			status: http.StatusBadRequest,
+			code:   errorInvalidRequest,
+		},
+		{
+			name:   "invalid group by",
+			target: "/api/v1/orderBook?symbol=BTCUSDT&depth=10&groupBy=-1",
+			status: http.StatusBadRequest,
+			code:   errorInvalidRequest,
+		},
+		{
+			name:   "exchange without order books",
+			target: "/api/v1/orderBook?symbol=BTCUSDT&depth=10&exchange=bittrex",
+			status: http.StatusBadRequest,
+			code:   errorInvalidRequest,
+		},
+		{
+			name:   "unknown symbol",
+			target: "/api/v1/orderBook?symbol=ETHUSDT&depth=10",
+			status: http.StatusNotFound,
+			code:   errorNotFound,
+		},
+		{
+			name:   "best levels",
+			target: "/api/v1/orderBook?symbol=BTCUSDT&depth=2",
+			status: http.StatusOK,
+			want: orderBookResponseInternal{
+				Symbol: "BTCUSDT",
+				OrderBookAPI: models.OrderBookAPI{
+					Asks: []models.AskBid{{Price: 100, Size: 1.5}, {Price: 100.5, Size: 2.5}},
+					Bids: []models.AskBid{{Price: 99, Size: 2}, {Price: 99.5, Size: 1}},
+				},
+			},
+		},
+		{
+			name:   "grouped levels",
+			target: "/api/v1/orderBook?symbol=BTCUSDT&depth=10&groupBy=1",
+			status: http.StatusOK,
+			want: orderBookResponseInternal{
+				Symbol:  "BTCUSDT",
+				GroupBy: 1,
+				OrderBookAPI: models.OrderBookAPI{
+					Asks: []models.AskBid{{Price: 100, Size: 1.5}, {Price: 101, Size: 6.5}},
+					Bids: []models.AskBid{{Price: 98, Size: 3}, {Price: 99, Size: 3}},
+				},
+			},
+		},
+		{
+			name:   "optional exchange",
+			target: "/api/v1/orderBook?symbol=BTCUSDT&depth=10&exchange=coinbase",
+			status: http.StatusOK,
+			want: orderBookResponseInternal{
+				Symbol: "BTCUSDT",
+				OrderBookAPI: models.OrderBookAPI{
+					Asks: []models.AskBid{{Price: 100.2, Size: 6}},
+					Bids: []models.AskBid{{Price: 99.4, Size: 5}},
+				},
+			},
+		},
+	}
+
+	api := newTestAPI(&fakeStore{}, binance, map[string]OrderBookExchange{"coinbase": coinbase})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var resp orderBookResponseInternal
+			checkResponse(t, serve(api.handleOrderBookRequest, tt.target), tt.status, tt.code, &resp)
+
+			if tt.status == http.StatusOK && !reflect.DeepEqual(resp, tt.want) {
+				t.Errorf("order book = %+v, want %+v", resp, tt.want)
+			}
+		})
+	}
+}
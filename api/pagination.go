@@ -0,0 +1,95 @@
+package api
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+
+	"price-feed/models"
+)
+
+const (
+	// maxCandlesLimit caps the candles of a single response, the rest is paged with the cursor.
+	maxCandlesLimit = 5000
+	orderAsc        = "asc"
+	orderDesc       = "desc"
+)
+
+// candlePage represents the limit, order and cursor parameters of a candle request.
+type candlePage struct {
+	limit int
+	order string
+	// cursor is the nextCursor of the previous page, zero for the first page.
+	cursor int64
+}
+
+func parseCandlePage(vars url.Values) (candlePage, error) {
+	page := candlePage{limit: maxCandlesLimit, order: orderAsc}
+
+	if v := vars.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxCandlesLimit {
+			return page, fmt.Errorf("limit should be a number in range [1; %v]", maxCandlesLimit)
+		}
+		page.limit = limit
+	}
+
+	switch v := vars.Get("order"); v {
+	case "", orderAsc:
+	case orderDesc:
+		page.order = orderDesc
+	default:
+		return page, fmt.Errorf("order should be asc or desc")
+	}
+
+	if v := vars.Get("cursor"); v != "" {
+		cursor, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || cursor <= 0 {
+			return page, fmt.Errorf("cursor is invalid")
+		}
+		page.cursor = cursor
+	}
+
+	return page, nil
+}
+
+// rangeOf narrows the requested range to the page. A cursor continues an ascending page
+// from its time and a descending one up to it.
+func (p candlePage) rangeOf(timeStart, timeEnd int64) (int64, int64) {
+	if p.cursor == 0 {
+		return timeStart, timeEnd
+	}
+	if p.order == orderDesc {
+		if p.cursor < timeEnd {
+			timeEnd = p.cursor
+		}
+		return timeStart, timeEnd
+	}
+	if p.cursor > timeStart {
+		timeStart = p.cursor
+	}
+	return timeStart, timeEnd
+}
+
+// apply orders the candles of the interval, sorted by open time, and keeps the first limit ones.
+// It returns the cursor of the next page, empty on the last page. An ascending page continues
+// from the open time of the next candle, as the close time isn't set alike by every exchange.
+func (p candlePage) apply(candles []models.Candle, interval string) ([]models.Candle, string) {
+	if p.order == orderDesc {
+		for i, j := 0, len(candles)-1; i < j; i, j = i+1, j-1 {
+			candles[i], candles[j] = candles[j], candles[i]
+		}
+	}
+
+	if len(candles) <= p.limit {
+		return candles, ""
+	}
+
+	candles = candles[:p.limit]
+	last := candles[len(candles)-1]
+	if p.order == orderDesc {
+		return candles, strconv.FormatInt(last.TimeStart-1, 10)
+	}
+	next := models.IntervalStart(last.TimeStart+int64(models.IntervalDuration(interval).Seconds()), interval)
+	return candles, strconv.FormatInt(next, 10)
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+
+	"price-feed/models"
+)
+
+func TestCandlePagePaging(t *testing.T) {
+	tests := []struct {
+		name     string
+		interval string
+		order    string
+		candles  []models.Candle
+	}{
+		{
+			name:     "close time set",
+			interval: "1m",
+			order:    orderAsc,
+			candles: []models.Candle{
+				{TimeStart: 60, TimeEnd: 119}, {TimeStart: 120, TimeEnd: 179}, {TimeStart: 180, TimeEnd: 239},
+				{TimeStart: 240, TimeEnd: 299}, {TimeStart: 300, TimeEnd: 359},
+			},
+		},
+		{
+			// Bittrex and Poloniex candles and the merged ones have no close time.
+			name:     "close time not set",
+			interval: "1m",
+			order:    orderAsc,
+			candles: []models.Candle{
+				{TimeStart: 60}, {TimeStart: 120}, {TimeStart: 180}, {TimeStart: 240}, {TimeStart: 300},
+			},
+		},
+		{
+			name:     "months",
+			interval: "1M",
+			order:    orderAsc,
+			candles: []models.Candle{
+				{TimeStart: 1577836800}, {TimeStart: 1580515200}, {TimeStart: 1583020800},
+				{TimeStart: 1585699200}, {TimeStart: 1588291200},
+			},
+		},
+		{
+			name:     "descending",
+			interval: "1m",
+			order:    orderDesc,
+			candles: []models.Candle{
+				{TimeStart: 60}, {TimeStart: 120}, {TimeStart: 180}, {TimeStart: 240}, {TimeStart: 300},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// load returns the stored candles within the range, as the storage does.
+			load := func(timeStart, timeEnd int64) []models.Candle {
+				timeStart = models.IntervalStart(timeStart, tt.interval)
+				var result []models.Candle
+				for _, v := range tt.candles {
+					if v.TimeStart >= timeStart && v.TimeStart <= timeEnd {
+						result = append(result, v)
+					}
+				}
+				return result
+			}
+
+			timeStart, timeEnd := tt.candles[0].TimeStart, tt.candles[len(tt.candles)-1].TimeStart
+			page := candlePage{limit: 2, order: tt.order}
+			var got []models.Candle
+			for i := 0; i < len(tt.candles); i++ {
+				candles, cursor := page.apply(load(page.rangeOf(timeStart, timeEnd)), tt.interval)
+				got = append(got, candles...)
+				if cursor == "" {
+					break
+				}
+
+				var err error
+				if page.cursor, err = strconv.ParseInt(cursor, 10, 64); err != nil {
+					t.Fatalf("cursor %v is invalid: %v", cursor, err)
+				}
+			}
+
+			want := append([]models.Candle(nil), tt.candles...)
+			if tt.order == orderDesc {
+				for i, j := 0, len(want)-1; i < j; i, j = i+1, j-1 {
+					want[i], want[j] = want[j], want[i]
+				}
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("candles = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
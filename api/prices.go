@@ -0,0 +1,197 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"price-feed/models"
+)
+
+const (
+	tickerInterval = time.Second
+	// tickerReferenceInterval is how often the price of 24 hours ago is reloaded from the storage.
+	tickerReferenceInterval = 5 * time.Minute
+	tickerReferenceAge      = 24 * time.Hour
+)
+
+type priceItem struct {
+	Symbol string  `json:"symbol"`
+	Price  float64 `json:"price"`
+	// Change24h is the relative price change over the last 24 hours, 0 if unknown.
+	Change24h float64 `json:"change24h"`
+	// Time is when the price last changed, in milliseconds.
+	Time int64 `json:"time"`
+	// Staleness is the number of seconds since the price last changed.
+	Staleness float64 `json:"staleness"`
+}
+
+type pricesResponse struct {
+	// Units are quote, usd or sat.
+	Units  string      `json:"units"`
+	Prices []priceItem `json:"prices"`
+}
+
+// ticker is the latest price of a symbol.
+type ticker struct {
+	price   float64
+	updated time.Time
+	// reference is the price of 24 hours ago, reloaded at referenceLoaded.
+	reference       float64
+	referenceLoaded time.Time
+}
+
+// tickerMap keeps the latest price of every symbol with a live order book in memory,
+// so the prices of all symbols are served without touching the storage.
+type tickerMap struct {
+	mu      sync.RWMutex
+	tickers map[string]*ticker
+}
+
+func newTickerMap() *tickerMap {
+	return &tickerMap{tickers: make(map[string]*ticker)}
+}
+
+// watchTickers periodically updates the tickers with the mid prices of the order books.
+// Binance is preferred, the other exchanges are used for the symbols it doesn't have.
+func (api *API) watchTickers() {
+	for ; ; <-time.Tick(tickerInterval) {
+		names := make([]string, 0, len(api.optional))
+		for name := range api.optional {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		exchanges := []OrderBookExchange{api.binance}
+		for _, name := range names {
+			exchanges = append(exchanges, api.optional[name])
+		}
+
+		prices := make(map[string]float64)
+		for _, exchange := range exchanges {
+			for _, symbol := range exchange.Symbols() {
+				if _, ok := prices[symbol]; ok {
+					continue
+				}
+
+				orderBook, ok := exchange.GetOrderBook(symbol)
+				if !ok {
+					continue
+				}
+				if price := orderBook.BestBidOffer().Price(); price > 0 {
+					prices[symbol] = price
+				}
+			}
+		}
+
+		now := time.Now()
+		for symbol, price := range prices {
+			api.updateTicker(symbol, price, now)
+		}
+	}
+}
+
+func (api *API) updateTicker(symbol string, price float64, now time.Time) {
+	api.tickers.mu.Lock()
+	t, ok := api.tickers.tickers[symbol]
+	if !ok {
+		t = &ticker{}
+		api.tickers.tickers[symbol] = t
+	}
+	if t.price != price {
+		t.price = price
+		t.updated = now
+	}
+	reload := now.Sub(t.referenceLoaded) >= tickerReferenceInterval
+	if reload {
+		t.referenceLoaded = now
+	}
+	api.tickers.mu.Unlock()
+
+	if !reload {
+		return
+	}
+
+	reference, err := api.referencePrice(symbol, now.Add(-tickerReferenceAge))
+	if err != nil {
+		api.log.Warnf("Could not load 24h reference price of %v: %v", symbol, err)
+		return
+	}
+
+	api.tickers.mu.Lock()
+	t.reference = reference
+	api.tickers.mu.Unlock()
+}
+
+// referencePrice returns the close of the last hourly candle before at, or 0 if there is none.
+func (api *API) referencePrice(symbol string, at time.Time) (float64, error) {
+	candles, err := api.storage.LoadCandlestickListAll(symbol, "1h", models.MergePrimary,
+		at.Add(-time.Hour).Unix(), at.Unix())
+	if err != nil {
+		return 0, err
+	}
+	if len(candles) == 0 {
+		return 0, nil
+	}
+	return candles[len(candles)-1].Close, nil
+}
+
+func (api *API) handlePricesRequest(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+
+	units, err := parseUnits(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	api.tickers.mu.RLock()
+	symbols := make([]string, 0, len(api.tickers.tickers))
+	for symbol := range api.tickers.tickers {
+		symbols = append(symbols, symbol)
+	}
+	symbols = allowedSymbols(r, symbols)
+	sort.Strings(symbols)
+
+	api.tickers.mu.RUnlock()
+
+	resp := pricesResponse{Units: units, Prices: make([]priceItem, 0, len(symbols))}
+	for _, symbol := range symbols {
+		// Symbols without a conversion rate are left out rather than served in the quote asset.
+		factor, err := api.unitsFactor(symbol, units)
+		if err != nil {
+			continue
+		}
+
+		api.tickers.mu.RLock()
+		t := *api.tickers.tickers[symbol]
+		api.tickers.mu.RUnlock()
+
+		item := priceItem{
+			Symbol:    symbol,
+			Price:     t.price * factor,
+			Time:      t.updated.UnixNano() / int64(time.Millisecond),
+			Staleness: now.Sub(t.updated).Seconds(),
+		}
+		if t.reference > 0 {
+			item.Change24h = (t.price - t.reference) / t.reference
+		}
+		resp.Prices = append(resp.Prices, item)
+	}
+
+	data, err := json.Marshal(resp)
+	if err != nil {
+		api.requestLog(r).Errorf("Could not marshal json: %v", err)
+		writeError(w, http.StatusInternalServerError, "could not load prices")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if _, err = w.Write(data); err != nil {
+		api.requestLog(r).Errorf("Could not write response: %v", err)
+		return
+	}
+}
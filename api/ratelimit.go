@@ -0,0 +1,192 @@
+package api
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	rateLimitSweepInterval = time.Minute
+	rateLimitScopeKey      = "key"
+	rateLimitScopeIP       = "ip"
+)
+
+// RateLimitConfig represents the token buckets limiting the API requests. The requests
+// carrying the key of a tenant or a known API key are limited by the key, the rest by
+// the client IP, so made up keys can't escape the IP limit.
+type RateLimitConfig struct {
+	// PerKey limits the requests of every key; unlimited if absent or of zero rate.
+	PerKey *BucketConfig `json:"per_key"`
+	// PerIP limits the requests of every client IP; unlimited if absent or of zero rate.
+	PerIP *BucketConfig `json:"per_ip"`
+	// TrustForwardedFor takes the client IP from the X-Forwarded-For header set by a proxy.
+	TrustForwardedFor bool `json:"trust_forwarded_for"`
+}
+
+// BucketConfig represents a token bucket.
+type BucketConfig struct {
+	// Rate is the number of requests per second the bucket is refilled with.
+	Rate float64 `json:"rate"`
+	// Burst is the capacity of the bucket, the rate rounded up by default.
+	Burst int `json:"burst"`
+}
+
+type bucket struct {
+	config *BucketConfig
+	tokens float64
+	last   time.Time
+}
+
+// refill adds the tokens accrued since the last request.
+func (b *bucket) refill(now time.Time) {
+	b.tokens = math.Min(float64(b.config.Burst), b.tokens+now.Sub(b.last).Seconds()*b.config.Rate)
+	b.last = now
+}
+
+// rateLimiter holds a token bucket per API key and client IP.
+type rateLimiter struct {
+	config  *RateLimitConfig
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	limited map[string]*int64
+}
+
+func newRateLimiter(config *RateLimitConfig) *rateLimiter {
+	for _, v := range []**BucketConfig{&config.PerKey, &config.PerIP} {
+		switch {
+		case *v == nil:
+		case (*v).Rate <= 0:
+			*v = nil
+		case (*v).Burst <= 0:
+			(*v).Burst = int(math.Ceil((*v).Rate))
+		}
+	}
+
+	return &rateLimiter{
+		config:  config,
+		buckets: make(map[string]*bucket),
+		limited: map[string]*int64{rateLimitScopeKey: new(int64), rateLimitScopeIP: new(int64)},
+	}
+}
+
+// take takes a token from the bucket of the key. It returns the tokens left and the time
+// until the bucket is full, or until the next token if the request is limited.
+func (l *rateLimiter) take(key string, config *BucketConfig, now time.Time) (bool, int, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{config: config, tokens: float64(config.Burst), last: now}
+		l.buckets[key] = b
+	}
+	b.refill(now)
+
+	if b.tokens < 1 {
+		return false, 0, time.Duration((1 - b.tokens) / config.Rate * float64(time.Second))
+	}
+
+	b.tokens--
+	return true, int(b.tokens), time.Duration((float64(config.Burst) - b.tokens) / config.Rate * float64(time.Second))
+}
+
+// sweep periodically drops the full buckets, which are the same as new ones.
+func (l *rateLimiter) sweep() {
+	for range time.Tick(rateLimitSweepInterval) {
+		now := time.Now()
+
+		l.mu.Lock()
+		for key, b := range l.buckets {
+			if b.refill(now); b.tokens >= float64(b.config.Burst) {
+				delete(l.buckets, key)
+			}
+		}
+		l.mu.Unlock()
+	}
+}
+
+// tenantRateLimits reports whether any tenant has a rate limit.
+func tenantRateLimits(tenants map[string]*tenant) bool {
+	for _, t := range tenants {
+		if t.rateLimit != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Limited returns the number of rejected requests by scope, key or ip.
+func (l *rateLimiter) Limited() map[string]int64 {
+	result := make(map[string]int64, len(l.limited))
+	for scope, v := range l.limited {
+		result[scope] = atomic.LoadInt64(v)
+	}
+	return result
+}
+
+// clientIP returns the IP of the client of the request.
+func (l *rateLimiter) clientIP(r *http.Request) string {
+	if l.config.TrustForwardedFor {
+		if v := r.Header.Get("X-Forwarded-For"); v != "" {
+			ip, _, _ := strings.Cut(v, ",")
+			return strings.TrimSpace(ip)
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// withRateLimit rejects the requests over the limit of their API key or client IP
+// with 429, and reports the state of the bucket in the X-RateLimit headers.
+// The rate limit of a tenant replaces the per key limit for its key.
+func (api *API) withRateLimit(next http.Handler) http.Handler {
+	l := api.limiter
+	if l == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r, auth := api.authenticate(r)
+
+		scope, key, config := rateLimitScopeIP, "", l.config.PerIP
+		switch {
+		case auth.tenant != nil && auth.tenant.rateLimit != nil:
+			scope, key, config = rateLimitScopeKey, "id:"+auth.key.ID, auth.tenant.rateLimit
+		case auth.found && l.config.PerKey != nil:
+			scope, key, config = rateLimitScopeKey, "id:"+auth.key.ID, l.config.PerKey
+		default:
+			key = l.clientIP(r)
+		}
+		if config == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		allowed, remaining, reset := l.take(scope+":"+key, config, time.Now())
+
+		w.Header().Set("X-RateLimit-Limit", strconv.Itoa(config.Burst))
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+		w.Header().Set("X-RateLimit-Reset", strconv.Itoa(int(math.Ceil(reset.Seconds()))))
+
+		if !allowed {
+			atomic.AddInt64(l.limited[scope], 1)
+			retryAfter := int(math.Max(1, math.Ceil(reset.Seconds())))
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+			writeErrorDetails(w, http.StatusTooManyRequests, errorRateLimited, "rate limit exceeded",
+				map[string]interface{}{"scope": scope, "limit": config.Burst, "retryAfter": retryAfter})
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,33 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"price-feed/logger"
+)
+
+func TestTenantRateLimit(t *testing.T) {
+	config := &Config{Tenants: []*TenantConfig{{Name: "acme", Key: "secret", RateLimit: 2}}}
+	api := New(config, logger.New(&logger.Config{Level: "error"}), &fakeStore{}, &fakeExchange{}, nil, nil, nil, nil, nil, nil)
+	handler := api.withRateLimit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for i, want := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		r := httptest.NewRequest(http.MethodGet, "/candles", nil)
+		r.Header.Set("X-API-Key", "secret")
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, r)
+
+		if rw.Code != want {
+			t.Fatalf("request %v status = %v, want %v", i, rw.Code, want)
+		}
+		if limit := rw.Header().Get("X-RateLimit-Limit"); limit != "2" {
+			t.Errorf("request %v limit = %q, want the tenant rate limit", i, limit)
+		}
+	}
+
+	if limited := api.limiter.Limited()[rateLimitScopeKey]; limited != 1 {
+		t.Errorf("limited = %v, want 1", limited)
+	}
+}
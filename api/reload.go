@@ -2,26 +2,21 @@ package api
 
 import (
 	"net/http"
+
+	"price-feed/models"
 )
 
 func (api *API) handleReloadRequest(w http.ResponseWriter, r *http.Request) {
-	vars := r.URL.Query()
-
-	tokens, ok := vars["token"]
-	if !ok || len(tokens) == 0 {
-		http.Error(w, "no token specified", http.StatusBadRequest)
-		return
-	}
-	token := tokens[0]
-
-	if token != api.config.Token {
-		http.Error(w, "token is invalid", http.StatusUnauthorized)
+	if !api.requireScope(w, r, models.ScopeAdmin) {
 		return
 	}
 
 	api.binance.Reload()
 	api.bittrex.Reload()
 	api.poloniex.Reload()
+	for _, v := range api.optional {
+		v.Reload()
+	}
 
 	w.WriteHeader(http.StatusOK)
 }
@@ -0,0 +1,145 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	requestIDHeader    = "X-Request-ID"
+	maxRequestIDLength = 128
+)
+
+// redactedParams are the query parameters kept out of the access log.
+var redactedParams = []string{"apiKey", "token"}
+
+type requestIDContextKey struct{}
+
+// withRequestID accepts the request ID sent by the client or generates a new one,
+// returns it in the response and attaches it to the request context. Every served
+// request is written to the access log along with its ID.
+func (api *API) withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if id == "" || len(id) > maxRequestIDLength {
+			id = newRequestID()
+		}
+
+		w.Header().Set(requestIDHeader, id)
+		r = r.WithContext(context.WithValue(r.Context(), requestIDContextKey{}, id))
+
+		aw := &accessLogWriter{ResponseWriter: w}
+		start := time.Now()
+		next.ServeHTTP(aw, r)
+
+		api.logAccess(r, aw, time.Since(start))
+	})
+}
+
+// logAccess logs the method, path, status, size and latency of a served request,
+// the server errors as warnings.
+func (api *API) logAccess(r *http.Request, aw *accessLogWriter, latency time.Duration) {
+	status := aw.status
+	switch {
+	case aw.hijacked:
+		status = http.StatusSwitchingProtocols
+	case status == 0:
+		status = http.StatusOK
+	}
+
+	log := api.requestLog(r).WithFields(logrus.Fields{
+		"method":     r.Method,
+		"path":       redactedURI(r.URL),
+		"status":     status,
+		"bytes":      aw.bytes,
+		"latency_ms": float64(latency.Microseconds()) / 1000,
+		"remote":     r.RemoteAddr,
+	})
+	if status >= http.StatusInternalServerError {
+		log.Warnf("Served %v %v with %v", r.Method, r.URL.Path, status)
+		return
+	}
+	log.Infof("Served %v %v", r.Method, r.URL.Path)
+}
+
+// redactedURI returns the path and query of the URL without the credentials.
+func redactedURI(u *url.URL) string {
+	if u.RawQuery == "" {
+		return u.Path
+	}
+
+	query := u.Query()
+	for _, v := range redactedParams {
+		if _, ok := query[v]; ok {
+			query.Set(v, "REDACTED")
+		}
+	}
+	return u.Path + "?" + query.Encode()
+}
+
+// accessLogWriter records the status and the size of a response.
+type accessLogWriter struct {
+	http.ResponseWriter
+	status   int
+	bytes    int64
+	hijacked bool
+}
+
+func (w *accessLogWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *accessLogWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(data)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Hijack lets WS connections be upgraded through the access log writer.
+func (w *accessLogWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer does not support hijacking")
+	}
+	w.hijacked = true
+	return hijacker.Hijack()
+}
+
+// Flush sends any buffered data to the client.
+func (w *accessLogWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// requestLog returns a logger which tags every line with the request ID.
+func (api *API) requestLog(r *http.Request) logrus.FieldLogger {
+	id, ok := r.Context().Value(requestIDContextKey{}).(string)
+	if !ok {
+		return api.log
+	}
+	return api.log.WithField("request_id", id)
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,113 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+
+	"github.com/gorilla/mux"
+	"price-feed/hub"
+	"price-feed/models"
+	"price-feed/schema"
+)
+
+// responseModels lists the models returned by the API, by schema name.
+var responseModels = map[string]interface{}{
+	"candles":          models.CandlestickResponse{},
+	"candlesProjected": projectedCandlestickResponse{},
+	"candlesColumnar":  columnarCandlestickResponse{},
+	"latestCandle":     latestCandleResponse{},
+	"orderBook":        orderBookResponse{},
+	"consolidatedBook": consolidatedOrderBookResponse{},
+	"bbo":              bboResponse{},
+	"index":            models.CandlestickResponse{},
+	"volatility":       volatilityResponse{},
+	"volume":           volumeResponse{},
+	"tape":             tapeResponse{},
+	"trades":           tradesResponse{},
+	"twap":             twapResponse{},
+	"prices":           pricesResponse{},
+	"leaderboard":      leaderboardResponse{},
+	"symbols":          symbolsResponse{},
+	"status":           statusResponse{},
+	"usage":            usageResponse{},
+	"deadLetters":      []models.DeadLetter{},
+	"webhook":          models.Webhook{},
+	"webhooks":         webhooksResponse{},
+	"webhookEvent":     models.WebhookEvent{},
+	"apiKey":           models.APIKey{},
+	"apiKeys":          apiKeysResponse{},
+	"apiKeyRequest":    apiKeyRequest{},
+	"webhookRequest":   webhookRequest{},
+	"schemas":          schemasResponse{},
+	"error":            errorResponse{},
+	"wsRequest":        wsRequest{},
+	"wsResponse":       wsResponse{},
+	"wsMessage":        hub.Message{},
+	"wsCandleUpdate":   models.CandleUpdate{},
+	"wsBestBidOffer":   models.BBO{},
+	"wsTrade":          models.Trade{},
+}
+
+// schemas are generated once from the response models.
+var schemas = generateSchemas()
+
+func generateSchemas() map[string]schema.Schema {
+	result := make(map[string]schema.Schema, len(responseModels))
+	for name, model := range responseModels {
+		result[name] = schema.Generate(model)
+	}
+	return result
+}
+
+type schemasResponse struct {
+	Schemas []string `json:"schemas"`
+}
+
+func (api *API) handleSchemasRequest(w http.ResponseWriter, r *http.Request) {
+	resp := schemasResponse{
+		Schemas: make([]string, 0, len(schemas)),
+	}
+	for name := range schemas {
+		resp.Schemas = append(resp.Schemas, name)
+	}
+	sort.Strings(resp.Schemas)
+
+	data, err := json.Marshal(resp)
+	if err != nil {
+		api.requestLog(r).Errorf("Could not marshal json: %v", err)
+		writeError(w, http.StatusInternalServerError, "could not load schemas")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if _, err = w.Write(data); err != nil {
+		api.requestLog(r).Errorf("Could not write response: %v", err)
+		return
+	}
+}
+
+func (api *API) handleSchemaRequest(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	s, ok := schemas[name]
+	if !ok {
+		writeError(w, http.StatusNotFound, "unknown schema")
+		return
+	}
+
+	data, err := json.Marshal(s)
+	if err != nil {
+		api.requestLog(r).Errorf("Could not marshal json: %v", err)
+		writeError(w, http.StatusInternalServerError, "could not load schema")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/schema+json")
+	w.WriteHeader(http.StatusOK)
+	if _, err = w.Write(data); err != nil {
+		api.requestLog(r).Errorf("Could not write response: %v", err)
+		return
+	}
+}
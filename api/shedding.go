@@ -0,0 +1,76 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultRetryAfter = 5
+)
+
+// SheddingConfig represents the limits above which non-critical requests are rejected.
+type SheddingConfig struct {
+	// MaxPendingQueries is the maximum number of storage operations in flight.
+	MaxPendingQueries int `json:"max_pending_queries"`
+	// MaxEventLag is the maximum delay between an exchange event and its receipt, e.g. "5s".
+	MaxEventLag string `json:"max_event_lag"`
+	// RetryAfter is the number of seconds clients are told to wait.
+	RetryAfter int `json:"retry_after"`
+}
+
+// overloaded reports whether ingestion is under pressure and the reason for it.
+func (api *API) overloaded() (bool, string) {
+	cfg := api.config.Shedding
+	if cfg == nil {
+		return false, ""
+	}
+
+	if cfg.MaxPendingQueries > 0 && api.storage.PendingQueries() > cfg.MaxPendingQueries {
+		return true, "storage queue"
+	}
+
+	if api.maxEventLag > 0 && api.eventLag() > api.maxEventLag {
+		return true, "event lag"
+	}
+
+	return false, ""
+}
+
+// eventLag returns the largest delay between the exchange time of the last event
+// of a streaming subscription and its receipt.
+func (api *API) eventLag() time.Duration {
+	var lag int64
+	for _, v := range api.binance.Streams() {
+		if v.LastEventTime == 0 {
+			continue
+		}
+		if d := v.ReceivedAt - v.LastEventTime; d > lag {
+			lag = d
+		}
+	}
+	return time.Duration(lag) * time.Millisecond
+}
+
+// shed rejects the request with 503 while the service is overloaded,
+// leaving the capacity to ingestion.
+func (api *API) shed(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if ok, reason := api.overloaded(); ok {
+			api.requestLog(r).Warnf("Shedding request %v: %v", r.URL.Path, reason)
+
+			retryAfter := api.config.Shedding.RetryAfter
+			if retryAfter <= 0 {
+				retryAfter = defaultRetryAfter
+			}
+
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+			writeErrorDetails(w, http.StatusServiceUnavailable, errorOverloaded, "service overloaded",
+				map[string]interface{}{"reason": reason, "retryAfter": retryAfter})
+			return
+		}
+
+		next(w, r)
+	}
+}
@@ -0,0 +1,222 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"price-feed/status"
+)
+
+type statusResponse struct {
+	Time    int64                      `json:"time"`
+	Streams map[string][]status.Stream `json:"streams"`
+	Latency latencyStatus              `json:"latency"`
+}
+
+// latencyStatus represents the end-to-end latency of the hub deliveries per symbol.
+type latencyStatus struct {
+	// Budget is the expected latency in milliseconds.
+	Budget  int64            `json:"budget"`
+	Symbols []status.Latency `json:"symbols"`
+}
+
+// exchanges returns the workers of all enabled exchanges.
+func (api *API) exchanges() map[string]SymbolRegistry {
+	exchanges := map[string]SymbolRegistry{
+		"binance":  api.binance,
+		"bittrex":  api.bittrex,
+		"poloniex": api.poloniex,
+	}
+	for name, v := range api.optional {
+		exchanges[name] = v
+	}
+	return exchanges
+}
+
+func (api *API) exchangeStreams() map[string][]status.Stream {
+	streams := make(map[string][]status.Stream)
+	for name, v := range api.exchanges() {
+		streams[name] = v.Streams()
+	}
+	return streams
+}
+
+func (api *API) handleStatusRequest(w http.ResponseWriter, r *http.Request) {
+	resp := statusResponse{
+		Time:    time.Now().UnixNano() / int64(time.Millisecond),
+		Streams: api.exchangeStreams(),
+	}
+	latencies, budget := api.hub.Latency()
+	resp.Latency = latencyStatus{
+		Budget:  int64(budget / time.Millisecond),
+		Symbols: latencies,
+	}
+
+	data, err := json.Marshal(resp)
+	if err != nil {
+		api.requestLog(r).Errorf("Could not marshal json: %v", err)
+		writeError(w, http.StatusInternalServerError, "could not load status")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if _, err = w.Write(data); err != nil {
+		api.requestLog(r).Errorf("Could not write response: %v", err)
+		return
+	}
+}
+
+// handleMetricsRequest exposes metrics in the Prometheus text format.
+func (api *API) handleMetricsRequest(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.WriteHeader(http.StatusOK)
+
+	fmt.Fprintln(w, "# HELP price_feed_stream_last_event_timestamp_seconds Time of the last event received on the stream.")
+	fmt.Fprintln(w, "# TYPE price_feed_stream_last_event_timestamp_seconds gauge")
+	for exchange, streams := range api.exchangeStreams() {
+		for _, v := range streams {
+			fmt.Fprintf(w, "price_feed_stream_last_event_timestamp_seconds{exchange=%q,stream=%q} %.3f\n",
+				exchange, v.Name, float64(v.ReceivedAt)/1000)
+		}
+	}
+
+	fmt.Fprintln(w, "# HELP price_feed_stream_last_event_id ID of the last event received on the stream.")
+	fmt.Fprintln(w, "# TYPE price_feed_stream_last_event_id gauge")
+	for exchange, streams := range api.exchangeStreams() {
+		for _, v := range streams {
+			fmt.Fprintf(w, "price_feed_stream_last_event_id{exchange=%q,stream=%q} %d\n",
+				exchange, v.Name, v.LastEventID)
+		}
+	}
+
+	storageUp := 0
+	if api.storage.Healthy() {
+		storageUp = 1
+	}
+	fmt.Fprintln(w, "# HELP price_feed_storage_up Whether the last storage health check succeeded.")
+	fmt.Fprintln(w, "# TYPE price_feed_storage_up gauge")
+	fmt.Fprintf(w, "price_feed_storage_up %d\n", storageUp)
+
+	fmt.Fprintln(w, "# HELP price_feed_storage_dropped_writes_total Storage writes which were never applied.")
+	fmt.Fprintln(w, "# TYPE price_feed_storage_dropped_writes_total counter")
+	for store, v := range api.storage.DroppedWrites() {
+		fmt.Fprintf(w, "price_feed_storage_dropped_writes_total{store=%q} %d\n", store, v)
+	}
+
+	fmt.Fprintln(w, "# HELP price_feed_index_rejected_total Index candles rejected by the deviation guard.")
+	fmt.Fprintln(w, "# TYPE price_feed_index_rejected_total counter")
+	for name, v := range api.aggregator.Rejections() {
+		fmt.Fprintf(w, "price_feed_index_rejected_total{index=%q} %d\n", name, v)
+	}
+
+	fmt.Fprintln(w, "# HELP price_feed_exchange_clock_skew_seconds Last measured skew of the exchange clock.")
+	fmt.Fprintln(w, "# TYPE price_feed_exchange_clock_skew_seconds gauge")
+	for name, v := range api.exchanges() {
+		fmt.Fprintf(w, "price_feed_exchange_clock_skew_seconds{exchange=%q} %.3f\n", name, v.ClockSkew().Seconds())
+	}
+
+	fmt.Fprintln(w, "# HELP price_feed_orderbook_resyncs_total Order book resyncs caused by gaps in the updates.")
+	fmt.Fprintln(w, "# TYPE price_feed_orderbook_resyncs_total counter")
+	fmt.Fprintf(w, "price_feed_orderbook_resyncs_total{exchange=\"binance\"} %d\n", api.binance.Resyncs())
+	for name, v := range api.optional {
+		fmt.Fprintf(w, "price_feed_orderbook_resyncs_total{exchange=%q} %d\n", name, v.Resyncs())
+	}
+
+	if faults, violations, ok := api.binance.ChaosStats(); ok {
+		fmt.Fprintln(w, "# HELP price_feed_chaos_faults_total Faults injected into the streams in chaos mode.")
+		fmt.Fprintln(w, "# TYPE price_feed_chaos_faults_total counter")
+		for fault, v := range faults {
+			fmt.Fprintf(w, "price_feed_chaos_faults_total{exchange=\"binance\",fault=%q} %d\n", fault, v)
+		}
+
+		fmt.Fprintln(w, "# HELP price_feed_chaos_violations_total Crossed order books found in chaos mode.")
+		fmt.Fprintln(w, "# TYPE price_feed_chaos_violations_total counter")
+		fmt.Fprintf(w, "price_feed_chaos_violations_total{exchange=\"binance\"} %d\n", violations)
+	}
+
+	if drifts, corrections, ok := api.binance.BookDrift(); ok {
+		fmt.Fprintln(w, "# HELP price_feed_orderbook_drift Last measured drift of the order book from the REST snapshot, 0 to 1.")
+		fmt.Fprintln(w, "# TYPE price_feed_orderbook_drift gauge")
+		for symbol, v := range drifts {
+			fmt.Fprintf(w, "price_feed_orderbook_drift{exchange=\"binance\",symbol=%q} %.4f\n", symbol, v)
+		}
+
+		fmt.Fprintln(w, "# HELP price_feed_orderbook_drift_corrections_total Order books replaced because of their drift.")
+		fmt.Fprintln(w, "# TYPE price_feed_orderbook_drift_corrections_total counter")
+		fmt.Fprintf(w, "price_feed_orderbook_drift_corrections_total{exchange=\"binance\"} %d\n", corrections)
+	}
+
+	fmt.Fprintln(w, "# HELP price_feed_orderbook_checksum_valid Whether the last order book checksum verification succeeded.")
+	fmt.Fprintln(w, "# TYPE price_feed_orderbook_checksum_valid gauge")
+	for name, v := range api.optional {
+		checksums, ok := v.Checksums()
+		if !ok {
+			continue
+		}
+		for symbol, checksum := range checksums {
+			valid := 0
+			if checksum.Valid {
+				valid = 1
+			}
+			fmt.Fprintf(w, "price_feed_orderbook_checksum_valid{exchange=%q,symbol=%q} %d\n", name, symbol, valid)
+		}
+	}
+
+	fmt.Fprintln(w, "# HELP price_feed_orderbook_checksum_mismatches_total Order book checksum mismatches, each resyncing the book.")
+	fmt.Fprintln(w, "# TYPE price_feed_orderbook_checksum_mismatches_total counter")
+	for name, v := range api.optional {
+		checksums, ok := v.Checksums()
+		if !ok {
+			continue
+		}
+		for symbol, checksum := range checksums {
+			fmt.Fprintf(w, "price_feed_orderbook_checksum_mismatches_total{exchange=%q,symbol=%q} %d\n", name, symbol, checksum.Mismatches)
+		}
+	}
+
+	if outcomes, ok := api.binance.Reconnects(); ok {
+		fmt.Fprintln(w, "# HELP price_feed_ws_reconnects_total Stream connections by stream kind and outcome: connected, failed or abandoned.")
+		fmt.Fprintln(w, "# TYPE price_feed_ws_reconnects_total counter")
+		for kind, counts := range outcomes {
+			for outcome, v := range counts {
+				fmt.Fprintf(w, "price_feed_ws_reconnects_total{exchange=\"binance\",stream=%q,outcome=%q} %d\n", kind, outcome, v)
+			}
+		}
+	}
+
+	if api.limiter != nil {
+		fmt.Fprintln(w, "# HELP price_feed_api_rate_limited_total Requests rejected by the rate limit of their API key or IP.")
+		fmt.Fprintln(w, "# TYPE price_feed_api_rate_limited_total counter")
+		for scope, v := range api.limiter.Limited() {
+			fmt.Fprintf(w, "price_feed_api_rate_limited_total{scope=%q} %d\n", scope, v)
+		}
+	}
+
+	stats := api.hub.Stats()
+	fmt.Fprintln(w, "# HELP price_feed_hub_clients Clients connected to the hub.")
+	fmt.Fprintln(w, "# TYPE price_feed_hub_clients gauge")
+	fmt.Fprintf(w, "price_feed_hub_clients %d\n", stats.Clients)
+
+	fmt.Fprintln(w, "# HELP price_feed_hub_dropped_messages_total Messages dropped from the full client queues.")
+	fmt.Fprintln(w, "# TYPE price_feed_hub_dropped_messages_total counter")
+	fmt.Fprintf(w, "price_feed_hub_dropped_messages_total{policy=%q} %d\n", stats.OverflowPolicy, stats.Dropped)
+
+	fmt.Fprintln(w, "# HELP price_feed_hub_evicted_clients_total Clients evicted because their queue was full.")
+	fmt.Fprintln(w, "# TYPE price_feed_hub_evicted_clients_total counter")
+	fmt.Fprintf(w, "price_feed_hub_evicted_clients_total %d\n", stats.Evicted)
+
+	latencies, budget := api.hub.Latency()
+	fmt.Fprintln(w, "# HELP price_feed_delivery_latency_budget_seconds Expected end-to-end latency of the deliveries.")
+	fmt.Fprintln(w, "# TYPE price_feed_delivery_latency_budget_seconds gauge")
+	fmt.Fprintf(w, "price_feed_delivery_latency_budget_seconds %.3f\n", budget.Seconds())
+
+	fmt.Fprintln(w, "# HELP price_feed_delivery_latency_seconds Time from the ingest of the events to their delivery over the last deliveries.")
+	fmt.Fprintln(w, "# TYPE price_feed_delivery_latency_seconds gauge")
+	for _, v := range latencies {
+		fmt.Fprintf(w, "price_feed_delivery_latency_seconds{symbol=%q,quantile=\"0.5\"} %.4f\n", v.Symbol, v.P50/1000)
+		fmt.Fprintf(w, "price_feed_delivery_latency_seconds{symbol=%q,quantile=\"0.99\"} %.4f\n", v.Symbol, v.P99/1000)
+	}
+}
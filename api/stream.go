@@ -0,0 +1,87 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"price-feed/models"
+)
+
+const (
+	defaultStreamInterval = "1m"
+	// streamKeepAliveInterval is how often a comment is sent, so proxies don't close idle streams.
+	streamKeepAliveInterval = 15 * time.Second
+)
+
+// handleStreamRequest pushes the best bid and offer, with the mid price, and the candle updates
+// of the symbols as Server-Sent Events, for the clients which can't use WebSockets. Every event
+// carries a hub message, the same as the WS endpoint sends.
+func (api *API) handleStreamRequest(w http.ResponseWriter, r *http.Request) {
+	vars := r.URL.Query()
+
+	symbols, ok := vars["symbol"]
+	if !ok || len(symbols) == 0 {
+		writeError(w, http.StatusBadRequest, "no pair specified")
+		return
+	}
+
+	interval := defaultStreamInterval
+	if v := vars.Get("interval"); v != "" {
+		var ok bool
+		if interval, ok = models.NormalizeInterval(v); !ok {
+			writeError(w, http.StatusBadRequest, "interval is invalid")
+			return
+		}
+	}
+
+	channels := make([]string, 0, 2*len(symbols))
+	for _, symbol := range symbols {
+		channels = append(channels, "bbo."+symbol, "kline."+interval+"."+symbol)
+	}
+	if err := api.checkChannels(r, channels); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming is not supported")
+		return
+	}
+
+	client := api.hub.NewClient()
+	defer client.Close()
+
+	if err := client.Subscribe(channels...); err != nil {
+		api.requestLog(r).Errorf("Could not subscribe stream: %v", err)
+		writeError(w, http.StatusInternalServerError, "could not subscribe")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ticker := time.NewTicker(streamKeepAliveInterval)
+	defer ticker.Stop()
+
+	for {
+		var err error
+		select {
+		case <-r.Context().Done():
+			return
+		case <-client.Done():
+			return
+		case message := <-client.Send():
+			_, err = fmt.Fprintf(w, "data: %s\n\n", message)
+		case <-ticker.C:
+			_, err = fmt.Fprint(w, ": keep-alive\n\n")
+		}
+		if err != nil {
+			return
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,54 @@
+package api
+
+import (
+	"net/http"
+
+	"price-feed/models"
+)
+
+// handleSubscribeRequest subscribes a worker to a new symbol at runtime.
+// The symbol is given in the format of the exchange, e.g. BTC-ETH for Bittrex.
+func (api *API) handleSubscribeRequest(w http.ResponseWriter, r *http.Request) {
+	if !api.requireScope(w, r, models.ScopeAdmin) {
+		return
+	}
+
+	vars := r.URL.Query()
+
+	exchanges, ok := vars["exchange"]
+	if !ok || len(exchanges) == 0 {
+		writeError(w, http.StatusBadRequest, "no exchange specified")
+		return
+	}
+
+	symbols, ok := vars["symbol"]
+	if !ok || len(symbols) == 0 {
+		writeError(w, http.StatusBadRequest, "no symbol specified")
+		return
+	}
+
+	var err error
+	switch exchanges[0] {
+	case "binance":
+		err = api.binance.AddSymbol(symbols[0])
+	case "bittrex":
+		err = api.bittrex.AddSymbol(symbols[0])
+	case "poloniex":
+		err = api.poloniex.AddSymbol(symbols[0])
+	default:
+		optional, ok := api.optional[exchanges[0]]
+		if !ok {
+			writeError(w, http.StatusBadRequest, "unknown exchange")
+			return
+		}
+		err = optional.AddSymbol(symbols[0])
+	}
+
+	if err != nil {
+		api.requestLog(r).Errorf("Could not subscribe to %v symbol %v: %v", exchanges[0], symbols[0], err)
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,94 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"price-feed/aggregator"
+)
+
+type symbolsResponse struct {
+	Binance  []string `json:"binance"`
+	Bittrex  []string `json:"bittrex"`
+	Poloniex []string `json:"poloniex"`
+	Coinbase []string `json:"coinbase"`
+	OKX      []string `json:"okx"`
+	Bitfinex []string `json:"bitfinex"`
+	KuCoin   []string `json:"kucoin"`
+	Bybit    []string `json:"bybit"`
+	Huobi    []string `json:"huobi"`
+	// Synthetic lists the pairs computed from other symbols.
+	Synthetic []string `json:"synthetic"`
+	// Statuses maps exchanges to the trading status of their symbols.
+	Statuses map[string]map[string]string `json:"statuses"`
+}
+
+func (api *API) handleSymbolsRequest(w http.ResponseWriter, r *http.Request) {
+	resp := symbolsResponse{
+		Binance:  []string{},
+		Bittrex:  []string{},
+		Poloniex: []string{},
+		Statuses: make(map[string]map[string]string),
+	}
+
+	if allowedExchange(r, "binance") {
+		resp.Binance = allowedSymbols(r, api.binance.Symbols())
+		resp.Statuses["binance"] = symbolStatuses(resp.Binance, api.binance.SymbolStatuses())
+	}
+	if allowedExchange(r, "bittrex") {
+		resp.Bittrex = allowedSymbols(r, api.bittrex.Symbols())
+		resp.Statuses["bittrex"] = symbolStatuses(resp.Bittrex, api.bittrex.SymbolStatuses())
+	}
+	if allowedExchange(r, "poloniex") {
+		resp.Poloniex = allowedSymbols(r, api.poloniex.Symbols())
+		resp.Statuses["poloniex"] = symbolStatuses(resp.Poloniex, api.poloniex.SymbolStatuses())
+	}
+	resp.Coinbase = api.optionalSymbols(r, "coinbase", resp.Statuses)
+	resp.OKX = api.optionalSymbols(r, "okx", resp.Statuses)
+	resp.Bitfinex = api.optionalSymbols(r, "bitfinex", resp.Statuses)
+	resp.KuCoin = api.optionalSymbols(r, "kucoin", resp.Statuses)
+	resp.Bybit = api.optionalSymbols(r, "bybit", resp.Statuses)
+	resp.Huobi = api.optionalSymbols(r, "huobi", resp.Statuses)
+	resp.Synthetic = []string{}
+	if allowedExchange(r, aggregator.SyntheticExchange) {
+		resp.Synthetic = allowedSymbols(r, api.aggregator.SyntheticSymbols())
+	}
+
+	data, err := json.Marshal(resp)
+	if err != nil {
+		api.requestLog(r).Errorf("Could not marshal json: %v", err)
+		writeError(w, http.StatusInternalServerError, "could not load symbols")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if _, err = w.Write(data); err != nil {
+		api.requestLog(r).Errorf("Could not write response: %v", err)
+		return
+	}
+}
+
+// optionalSymbols returns the symbols of an optional exchange and adds their statuses,
+// or an empty list if the exchange is not enabled.
+func (api *API) optionalSymbols(r *http.Request, exchange string, statuses map[string]map[string]string) []string {
+	optional, ok := api.optional[exchange]
+	if !ok || !allowedExchange(r, exchange) {
+		return []string{}
+	}
+
+	symbols := allowedSymbols(r, optional.Symbols())
+	statuses[exchange] = symbolStatuses(symbols, optional.SymbolStatuses())
+	return symbols
+}
+
+// symbolStatuses returns the known statuses of the symbols.
+func symbolStatuses(symbols []string, statuses map[string]string) map[string]string {
+	result := make(map[string]string, len(symbols))
+	for _, v := range symbols {
+		if status, ok := statuses[v]; ok {
+			result[v] = status
+		}
+	}
+	return result
+}
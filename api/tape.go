@@ -0,0 +1,125 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"price-feed/models"
+)
+
+const (
+	defaultTapeLimit = 500
+	maxTapeLimit     = 5000
+)
+
+type tapeResponse struct {
+	Symbol string         `json:"symbol"`
+	Trades []models.Trade `json:"trades"`
+}
+
+// handleTapeRequest returns the most recent trades of the symbol on all exchanges in time order.
+func (api *API) handleTapeRequest(w http.ResponseWriter, r *http.Request) {
+	vars := r.URL.Query()
+
+	symbol := vars.Get("symbol")
+	if symbol == "" {
+		writeError(w, http.StatusBadRequest, "no pair specified")
+		return
+	}
+
+	limit := int64(defaultTapeLimit)
+	if v := vars.Get("limit"); v != "" {
+		var err error
+		limit, err = strconv.ParseInt(v, 10, 64)
+		if err != nil || limit < 1 || limit > maxTapeLimit {
+			writeError(w, http.StatusBadRequest, "limit should be a number in range [1; 5000]")
+			return
+		}
+	}
+
+	timeEnd := time.Now().UnixNano() / int64(time.Millisecond)
+	if v := vars.Get("to"); v != "" {
+		var err error
+		if timeEnd, err = strconv.ParseInt(v, 10, 64); err != nil {
+			writeError(w, http.StatusBadRequest, "to should be a timestamp in milliseconds")
+			return
+		}
+	}
+
+	var timeStart int64
+	if v := vars.Get("from"); v != "" {
+		var err error
+		if timeStart, err = strconv.ParseInt(v, 10, 64); err != nil {
+			writeError(w, http.StatusBadRequest, "from should be a timestamp in milliseconds")
+			return
+		}
+	}
+
+	format, err := parseFormat(vars)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	store, trace, ok := api.queryStorage(w, r)
+	if !ok {
+		return
+	}
+
+	trades := make([]models.Trade, 0, limit)
+	for _, exchange := range exchangeList {
+		if !allowedExchange(r, exchange) {
+			continue
+		}
+
+		exchangeTrades, err := store.LoadTrades(exchange, symbol, timeStart, timeEnd, limit)
+		if err != nil {
+			api.requestLog(r).Errorf("Could not load %v trades: %v", exchange, err)
+			api.writeStorageError(w, "could not load trades")
+			return
+		}
+		trades = append(trades, exchangeTrades...)
+	}
+
+	sort.Slice(trades, func(i, j int) bool {
+		if trades[i].Time != trades[j].Time {
+			return trades[i].Time < trades[j].Time
+		}
+		if trades[i].Exchange != trades[j].Exchange {
+			return trades[i].Exchange < trades[j].Exchange
+		}
+		return trades[i].ID < trades[j].ID
+	})
+
+	if int64(len(trades)) > limit {
+		trades = trades[int64(len(trades))-limit:]
+	}
+
+	if format != formatJSON {
+		writeRowsHeader(w, format, "")
+		if err := writeTradeRows(w, format, trades); err != nil {
+			api.requestLog(r).Errorf("Could not write response: %v", err)
+		}
+		return
+	}
+
+	data, err := json.Marshal(traced(tapeResponse{
+		Symbol: symbol,
+		Trades: trades,
+	}, trace))
+	if err != nil {
+		api.requestLog(r).Errorf("Could not marshal json: %v", err)
+		writeError(w, http.StatusInternalServerError, "could not load trades")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if _, err = w.Write(data); err != nil {
+		api.requestLog(r).Errorf("Could not write response: %v", err)
+		return
+	}
+}
@@ -0,0 +1,155 @@
+package api
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"price-feed/models"
+)
+
+// TenantConfig represents an API key with the read scope restricted to a set of symbols
+// and exchanges.
+type TenantConfig struct {
+	Name string `json:"name"`
+	Key  string `json:"key"`
+	// Symbols and Exchanges list what the tenant may query; empty means everything.
+	Symbols   []string `json:"symbols"`
+	Exchanges []string `json:"exchanges"`
+	// RateLimit is the number of requests per second allowed; zero means unlimited.
+	RateLimit int `json:"rate_limit"`
+	// MonthlyQuota is the number of requests allowed per calendar month; zero means unlimited.
+	MonthlyQuota int64 `json:"monthly_quota"`
+}
+
+type tenant struct {
+	config *TenantConfig
+	// key is the API key the tenant is authenticated as.
+	key       models.APIKey
+	symbols   map[string]bool
+	exchanges map[string]bool
+	// rateLimit is nil if the tenant is unlimited, see withRateLimit.
+	rateLimit *BucketConfig
+	usage     cachedUsage
+}
+
+type tenantContextKey struct{}
+
+// newTenants returns the tenants of the config by the hashes of their keys.
+func newTenants(configs []*TenantConfig) map[string]*tenant {
+	tenants := make(map[string]*tenant, len(configs))
+	for _, v := range configs {
+		t := &tenant{
+			config:    v,
+			key:       models.APIKey{ID: "tenant:" + v.Name, Name: v.Name, Scopes: []string{models.ScopeRead}},
+			symbols:   toSet(v.Symbols),
+			exchanges: toSet(v.Exchanges),
+		}
+
+		if v.RateLimit > 0 {
+			t.rateLimit = &BucketConfig{Rate: float64(v.RateLimit), Burst: v.RateLimit}
+		}
+
+		tenants[hashAPIKey(v.Key)] = t
+	}
+	return tenants
+}
+
+func toSet(values []string) map[string]bool {
+	if len(values) == 0 {
+		return nil
+	}
+
+	set := make(map[string]bool, len(values))
+	for _, v := range values {
+		set[v] = true
+	}
+	return set
+}
+
+// requestAPIKey returns the API key from the X-API-Key header or the apiKey query parameter.
+func requestAPIKey(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	return r.URL.Query().Get("apiKey")
+}
+
+// tenantFromContext returns the tenant the request was authorized for.
+func tenantFromContext(ctx context.Context) (*tenant, bool) {
+	t, ok := ctx.Value(tenantContextKey{}).(*tenant)
+	return t, ok
+}
+
+// tenantScoped restricts the handler to the API keys with the read scope if they are
+// required or if there are tenants configured. A tenant key may only query the symbols
+// and exchanges of its tenant within its quota, its rate limit is enforced by withRateLimit.
+func (api *API) tenantScoped(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if (api.config.RequireAPIKey || len(api.tenants) > 0) && !api.requireScope(w, r, models.ScopeRead) {
+			return
+		}
+
+		t, ok := tenantFromContext(r.Context())
+		if !ok {
+			next(w, r)
+			return
+		}
+
+		if api.quotaExceeded(t) {
+			writeErrorDetails(w, http.StatusTooManyRequests, errorQuotaExceeded, "monthly quota exceeded", nil)
+			return
+		}
+
+		vars := r.URL.Query()
+		if t.symbols != nil {
+			symbols := vars["symbol"]
+			if name, ok := mux.Vars(r)["name"]; ok {
+				symbols = append(symbols, name)
+			}
+
+			for _, v := range symbols {
+				if !t.symbols[v] {
+					writeError(w, http.StatusForbidden, "symbol is not allowed")
+					return
+				}
+			}
+		}
+
+		if t.exchanges != nil {
+			for _, v := range vars["exchange"] {
+				if !t.exchanges[v] {
+					writeError(w, http.StatusForbidden, "exchange is not allowed")
+					return
+				}
+			}
+		}
+
+		cw := &countingResponseWriter{ResponseWriter: w}
+		next(cw, r)
+
+		api.recordUsage(t, models.Usage{Requests: 1, Bytes: cw.bytes})
+	}
+}
+
+// allowedSymbols filters the symbols by the tenant of the request.
+func allowedSymbols(r *http.Request, symbols []string) []string {
+	t, ok := tenantFromContext(r.Context())
+	if !ok || t.symbols == nil {
+		return symbols
+	}
+
+	result := make([]string, 0, len(symbols))
+	for _, v := range symbols {
+		if t.symbols[v] {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+// allowedExchange reports whether the tenant of the request may query the exchange.
+func allowedExchange(r *http.Request, exchange string) bool {
+	t, ok := tenantFromContext(r.Context())
+	return !ok || t.exchanges == nil || t.exchanges[exchange]
+}
@@ -0,0 +1,130 @@
+package api
+
+import (
+	"crypto/tls"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"price-feed/logger"
+)
+
+const (
+	certCheckInterval       = time.Minute
+	defaultAutocertCacheDir = "autocert"
+)
+
+// TLSConfig represents the HTTPS configuration of the API listener. The admin
+// listener keeps serving plain HTTP.
+type TLSConfig struct {
+	// CertFile and KeyFile are the PEM certificate chain and private key. They are
+	// reloaded when the files change, e.g. on a renewal by certbot.
+	CertFile string `json:"cert_file"`
+	KeyFile  string `json:"key_file"`
+	// Autocert obtains the certificates from Let's Encrypt instead when present.
+	Autocert *AutocertConfig `json:"autocert"`
+}
+
+// AutocertConfig represents the automatic certificates. The TLS-ALPN-01 challenges are
+// answered on the API listener, so its port must be 443.
+type AutocertConfig struct {
+	// Domains are the host names certificates are requested for.
+	Domains []string `json:"domains"`
+	// Email is the contact of the ACME account, optional.
+	Email string `json:"email"`
+	// CacheDir keeps the account key and the certificates, autocert by default.
+	CacheDir string `json:"cache_dir"`
+	// DirectoryURL is the ACME directory, Let's Encrypt production by default.
+	DirectoryURL string `json:"directory_url"`
+}
+
+// tlsConfig returns the TLS configuration of the API listener.
+func (api *API) tlsConfig() (*tls.Config, error) {
+	cfg := api.config.TLS
+
+	if cfg.Autocert != nil {
+		if len(cfg.Autocert.Domains) == 0 {
+			return nil, errors.New("no autocert domains specified")
+		}
+		if cfg.Autocert.CacheDir == "" {
+			cfg.Autocert.CacheDir = defaultAutocertCacheDir
+		}
+		return autocertConfig(cfg.Autocert), nil
+	}
+
+	if cfg.CertFile == "" || cfg.KeyFile == "" {
+		return nil, errors.New("cert_file and key_file are required without autocert")
+	}
+
+	reloader, err := newCertReloader(cfg.CertFile, cfg.KeyFile, api.log)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.certificate,
+	}, nil
+}
+
+// certReloader serves a certificate and reloads it when its files are modified.
+type certReloader struct {
+	certFile, keyFile string
+	log               *logger.Logger
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+	checked time.Time
+}
+
+func newCertReloader(certFile, keyFile string, log *logger.Logger) (*certReloader, error) {
+	c := &certReloader{certFile: certFile, keyFile: keyFile, log: log}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// load reads the certificate if its files were modified since the last load.
+func (c *certReloader) load() error {
+	var modTime time.Time
+	for _, v := range []string{c.certFile, c.keyFile} {
+		info, err := os.Stat(v)
+		if err != nil {
+			return errors.Wrapf(err, "could not stat %v", v)
+		}
+		if info.ModTime().After(modTime) {
+			modTime = info.ModTime()
+		}
+	}
+
+	if c.cert != nil && !modTime.After(c.modTime) {
+		return nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return errors.Wrapf(err, "could not load certificate %v", c.certFile)
+	}
+
+	c.cert = &cert
+	c.modTime = modTime
+	return nil
+}
+
+func (c *certReloader) certificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if time.Since(c.checked) > certCheckInterval {
+		c.checked = time.Now()
+		// A half written renewal is picked up on a later check, the current certificate is kept meanwhile.
+		if err := c.load(); err != nil {
+			c.log.Warnf("Could not reload TLS certificate: %v", err)
+		}
+	}
+
+	return c.cert, nil
+}
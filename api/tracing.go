@@ -0,0 +1,30 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"price-feed/tracing"
+)
+
+const traceparentHeader = "traceparent"
+
+// withTracing records a span of every request, continuing the trace of the client
+// if it sends a traceparent header. Handlers add the storage reads as child spans.
+func (api *API) withTracing(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := r.URL.Path
+		if current := mux.CurrentRoute(r); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+
+		span := tracing.StartFromHeader(r.Method+" "+route, r.Header.Get(traceparentHeader))
+		span.SetAttribute("http.method", r.Method)
+		span.SetAttribute("http.route", route)
+		defer span.End()
+
+		next.ServeHTTP(w, r.WithContext(tracing.NewContext(r.Context(), span)))
+	})
+}
@@ -0,0 +1,95 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"price-feed/models"
+)
+
+const (
+	defaultTradesLimit = 100
+	maxTradesLimit     = 1000
+)
+
+type tradesResponse struct {
+	Exchange string         `json:"exchange"`
+	Symbol   string         `json:"symbol"`
+	Trades   []models.Trade `json:"trades"`
+}
+
+// handleTradesRequest returns the most recent trades of the symbol on a single exchange, newest first.
+func (api *API) handleTradesRequest(w http.ResponseWriter, r *http.Request) {
+	vars := r.URL.Query()
+
+	symbol := vars.Get("symbol")
+	if symbol == "" {
+		writeError(w, http.StatusBadRequest, "no pair specified")
+		return
+	}
+
+	exchange := vars.Get("exchange")
+	if exchange == "" {
+		exchange = "binance"
+	}
+	if !allowedExchange(r, exchange) {
+		writeError(w, http.StatusForbidden, "exchange is not allowed")
+		return
+	}
+
+	limit := int64(defaultTradesLimit)
+	if v := vars.Get("limit"); v != "" {
+		var err error
+		limit, err = strconv.ParseInt(v, 10, 64)
+		if err != nil || limit < 1 || limit > maxTradesLimit {
+			writeError(w, http.StatusBadRequest, "limit should be a number in range [1; 1000]")
+			return
+		}
+	}
+
+	format, err := parseFormat(vars)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	store, trace, ok := api.queryStorage(w, r)
+	if !ok {
+		return
+	}
+
+	trades, err := store.LoadTrades(exchange, symbol, 0, time.Now().UnixNano()/int64(time.Millisecond), limit)
+	if err != nil {
+		api.requestLog(r).Errorf("Could not load %v trades: %v", exchange, err)
+		api.writeStorageError(w, "could not load trades")
+		return
+	}
+
+	if format != formatJSON {
+		writeRowsHeader(w, format, "")
+		if err := writeTradeRows(w, format, trades); err != nil {
+			api.requestLog(r).Errorf("Could not write response: %v", err)
+		}
+		return
+	}
+
+	data, err := json.Marshal(traced(tradesResponse{
+		Exchange: exchange,
+		Symbol:   symbol,
+		Trades:   trades,
+	}, trace))
+	if err != nil {
+		api.requestLog(r).Errorf("Could not marshal json: %v", err)
+		writeError(w, http.StatusInternalServerError, "could not load trades")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if _, err = w.Write(data); err != nil {
+		api.requestLog(r).Errorf("Could not write response: %v", err)
+		return
+	}
+}
@@ -0,0 +1,139 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"price-feed/models"
+)
+
+const (
+	defaultTWAPWindow     = time.Hour
+	defaultTWAPResolution = time.Second
+	minTWAPResolution     = 100 * time.Millisecond
+	// maxTWAPSamples bounds the sampling grid of a single request.
+	maxTWAPSamples = 100000
+)
+
+type twapResponse struct {
+	Symbol string `json:"symbol"`
+	// TimeStart and TimeEnd are in milliseconds.
+	TimeStart  int64   `json:"timeStart"`
+	TimeEnd    int64   `json:"timeEnd"`
+	Resolution string  `json:"resolution"`
+	TWAP       float64 `json:"twap"`
+	// Samples is the number of the grid points with a known mid price.
+	Samples int `json:"samples"`
+}
+
+// handleTWAPRequest returns the time-weighted average mid price of the symbol over the window.
+// The stored mid prices are sampled every resolution, each sample taking the last mid price
+// known at its time, so a price weighs as long as it lasted.
+func (api *API) handleTWAPRequest(w http.ResponseWriter, r *http.Request) {
+	vars := r.URL.Query()
+
+	symbol := vars.Get("symbol")
+	if symbol == "" {
+		writeError(w, http.StatusBadRequest, "no pair specified")
+		return
+	}
+
+	window := defaultTWAPWindow
+	if v := vars.Get("window"); v != "" {
+		var err error
+		if window, err = time.ParseDuration(v); err != nil || window <= 0 {
+			writeError(w, http.StatusBadRequest, "window should be a positive duration, e.g. 15m")
+			return
+		}
+	}
+
+	resolution := defaultTWAPResolution
+	if v := vars.Get("resolution"); v != "" {
+		var err error
+		if resolution, err = time.ParseDuration(v); err != nil || resolution < minTWAPResolution {
+			writeError(w, http.StatusBadRequest, "resolution should be a duration of at least 100ms")
+			return
+		}
+	}
+
+	timeEnd := time.Now().UnixNano() / int64(time.Millisecond)
+	if v := vars.Get("to"); v != "" {
+		var err error
+		if timeEnd, err = strconv.ParseInt(v, 10, 64); err != nil {
+			writeError(w, http.StatusBadRequest, "to should be a timestamp in milliseconds")
+			return
+		}
+	}
+	timeStart := timeEnd - int64(window/time.Millisecond)
+
+	step := int64(resolution / time.Millisecond)
+	if (timeEnd-timeStart)/step > maxTWAPSamples {
+		writeError(w, http.StatusBadRequest, "window has too many samples, increase the resolution")
+		return
+	}
+
+	store, trace, ok := api.queryStorage(w, r)
+	if !ok {
+		return
+	}
+
+	prices, err := store.LoadMidPrices(symbol, timeStart, timeEnd)
+	if err != nil {
+		api.requestLog(r).Errorf("Could not load mid prices: %v", err)
+		api.writeStorageError(w, "could not load mid prices")
+		return
+	}
+
+	twap, samples := timeWeightedAverage(prices, timeStart, timeEnd, step)
+	if samples == 0 {
+		writeError(w, http.StatusNotFound, "no order book snapshots in the window")
+		return
+	}
+
+	data, err := json.Marshal(traced(twapResponse{
+		Symbol:     symbol,
+		TimeStart:  timeStart,
+		TimeEnd:    timeEnd,
+		Resolution: resolution.String(),
+		TWAP:       twap,
+		Samples:    samples,
+	}, trace))
+	if err != nil {
+		api.requestLog(r).Errorf("Could not marshal json: %v", err)
+		writeError(w, http.StatusInternalServerError, "could not load mid prices")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if _, err = w.Write(data); err != nil {
+		api.requestLog(r).Errorf("Could not write response: %v", err)
+		return
+	}
+}
+
+// timeWeightedAverage samples the prices, sorted by time, every step within [timeStart; timeEnd]
+// and returns the average of the samples and their number. The grid points before the first
+// price are skipped.
+func timeWeightedAverage(prices []models.MidPrice, timeStart, timeEnd, step int64) (float64, int) {
+	var sum float64
+	var samples, i int
+	for t := timeStart; t <= timeEnd; t += step {
+		for i < len(prices) && prices[i].Time <= t {
+			i++
+		}
+		if i == 0 {
+			continue
+		}
+
+		sum += prices[i-1].Price
+		samples++
+	}
+
+	if samples == 0 {
+		return 0, 0
+	}
+	return sum / float64(samples), samples
+}
@@ -0,0 +1,94 @@
+package api
+
+import (
+	"fmt"
+	"net/url"
+
+	"price-feed/models"
+)
+
+const (
+	unitsQuote = "quote"
+	unitsUSD   = "usd"
+	unitsSat   = "sat"
+
+	satoshisPerBTC = 1e8
+)
+
+func parseUnits(vars url.Values) (string, error) {
+	switch v := vars.Get("units"); v {
+	case "", unitsQuote:
+		return unitsQuote, nil
+	case unitsUSD, unitsSat:
+		return v, nil
+	default:
+		return "", fmt.Errorf("units should be one of quote, usd, sat")
+	}
+}
+
+// unitsFactor returns the factor converting the prices of the symbol from its quote asset
+// to the units. The conversion uses the latest prices of the ticker map, so historical
+// candles are converted at the current rate.
+func (api *API) unitsFactor(symbol, units string) (float64, error) {
+	if units == unitsQuote {
+		return 1, nil
+	}
+
+	quote := models.QuoteAsset(symbol)
+	if quote == "" {
+		return 0, fmt.Errorf("unknown quote asset of %v", symbol)
+	}
+
+	if units == unitsSat && quote == "BTC" {
+		return satoshisPerBTC, nil
+	}
+
+	factor, err := api.usdPrice(quote)
+	if err != nil {
+		return 0, err
+	}
+
+	if units == unitsSat {
+		btc, err := api.usdPrice("BTC")
+		if err != nil {
+			return 0, err
+		}
+		factor *= satoshisPerBTC / btc
+	}
+	return factor, nil
+}
+
+// usdPrice returns the latest USD price of the asset, taking USD stablecoins at par.
+func (api *API) usdPrice(asset string) (float64, error) {
+	if models.USDAssets[asset] {
+		return 1, nil
+	}
+
+	api.tickers.mu.RLock()
+	defer api.tickers.mu.RUnlock()
+
+	for _, quote := range []string{"USDT", "USDC", "BUSD"} {
+		if t, ok := api.tickers.tickers[asset+quote]; ok && t.price > 0 {
+			return t.price, nil
+		}
+	}
+	return 0, fmt.Errorf("no USD price of %v", asset)
+}
+
+// convertCandles returns the candles with the prices multiplied by the factor.
+// The volumes are in the base asset and are left as they are.
+func convertCandles(candles []models.Candle, factor float64) []models.Candle {
+	if factor == 1 {
+		return candles
+	}
+
+	converted := make([]models.Candle, len(candles))
+	for i, v := range candles {
+		v.Open *= factor
+		v.Close *= factor
+		v.High *= factor
+		v.Low *= factor
+		converted[i] = v
+	}
+	return converted
+}
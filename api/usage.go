@@ -0,0 +1,167 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"price-feed/models"
+)
+
+// countingResponseWriter counts the bytes written to the response.
+type countingResponseWriter struct {
+	http.ResponseWriter
+	bytes int64
+}
+
+func (w *countingResponseWriter) Write(data []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(data)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Hijack lets WS connections be upgraded through the counting writer.
+func (w *countingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer does not support hijacking")
+	}
+	return hijacker.Hijack()
+}
+
+// Flush sends any buffered data to the client.
+func (w *countingResponseWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// usageCacheTTL is how long the usage of a tenant is checked against its quota without
+// reloading it, the requests of the other API instances are missed meanwhile.
+const usageCacheTTL = 10 * time.Second
+
+func usageMonth(t time.Time) string {
+	return t.UTC().Format("2006-01")
+}
+
+// cachedUsage holds the requests of a tenant in the month as last loaded, plus the ones
+// served since, so the quota isn't checked against the storage on every request.
+type cachedUsage struct {
+	mu       sync.Mutex
+	month    string
+	requests int64
+	loaded   time.Time
+}
+
+// get returns the requests of the month unless they are to be reloaded.
+func (u *cachedUsage) get(month string, now time.Time) (int64, bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.month != month || now.Sub(u.loaded) >= usageCacheTTL {
+		return 0, false
+	}
+	return u.requests, true
+}
+
+func (u *cachedUsage) set(month string, requests int64, now time.Time) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.month, u.requests, u.loaded = month, requests, now
+}
+
+func (u *cachedUsage) add(month string, requests int64) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.month == month {
+		u.requests += requests
+	}
+}
+
+// recordUsage adds the usage to the counters of the tenant.
+func (api *API) recordUsage(t *tenant, usage models.Usage) {
+	month := usageMonth(time.Now())
+	t.usage.add(month, usage.Requests)
+
+	if err := api.storage.IncrementUsage(t.config.Name, month, usage); err != nil {
+		api.log.Errorf("Could not record usage of tenant %v: %v", t.config.Name, err)
+	}
+}
+
+// quotaExceeded reports whether the tenant has used up its monthly request quota.
+func (api *API) quotaExceeded(t *tenant) bool {
+	if t.config.MonthlyQuota <= 0 {
+		return false
+	}
+
+	now := time.Now()
+	month := usageMonth(now)
+	requests, ok := t.usage.get(month, now)
+	if !ok {
+		usage, err := api.storage.LoadUsage(t.config.Name, month)
+		if err != nil {
+			api.log.Errorf("Could not load usage of tenant %v: %v", t.config.Name, err)
+			return false
+		}
+		requests = usage.Requests
+		t.usage.set(month, requests, now)
+	}
+
+	return requests >= t.config.MonthlyQuota
+}
+
+type usageResponse struct {
+	Month   string                  `json:"month"`
+	Tenants map[string]models.Usage `json:"tenants"`
+}
+
+func (api *API) handleUsageRequest(w http.ResponseWriter, r *http.Request) {
+	if !api.requireScope(w, r, models.ScopeAdmin) {
+		return
+	}
+
+	month := r.URL.Query().Get("month")
+	if month == "" {
+		month = usageMonth(time.Now())
+	}
+
+	if _, err := time.Parse("2006-01", month); err != nil {
+		writeError(w, http.StatusBadRequest, "month should be in YYYY-MM format")
+		return
+	}
+
+	resp := usageResponse{
+		Month:   month,
+		Tenants: make(map[string]models.Usage, len(api.tenants)),
+	}
+
+	for _, t := range api.tenants {
+		usage, err := api.storage.LoadUsage(t.config.Name, month)
+		if err != nil {
+			api.requestLog(r).Errorf("Could not load usage of tenant %v: %v", t.config.Name, err)
+			api.writeStorageError(w, "could not load usage")
+			return
+		}
+		resp.Tenants[t.config.Name] = usage
+	}
+
+	data, err := json.Marshal(resp)
+	if err != nil {
+		api.log.Errorf("Could not marshal json: %v", err)
+		writeError(w, http.StatusInternalServerError, "could not load usage")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if _, err = w.Write(data); err != nil {
+		api.log.Errorf("Could not write response: %v", err)
+		return
+	}
+}
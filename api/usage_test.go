@@ -0,0 +1,24 @@
+package api
+
+import (
+	"testing"
+
+	"price-feed/models"
+)
+
+func TestQuotaExceeded(t *testing.T) {
+	store := &fakeStore{usage: models.Usage{Requests: 8}}
+	api := newTestAPI(store, &fakeExchange{}, nil)
+	tenant := newTenants([]*TenantConfig{{Name: "acme", Key: "secret", MonthlyQuota: 10}})[hashAPIKey("secret")]
+
+	for i, want := range []bool{false, false, true} {
+		if got := api.quotaExceeded(tenant); got != want {
+			t.Fatalf("request %v exceeded = %v, want %v", i, got, want)
+		}
+		api.recordUsage(tenant, models.Usage{Requests: 1})
+	}
+
+	if store.usageLoads != 1 {
+		t.Errorf("usage loads = %v, want the cached usage to be used", store.usageLoads)
+	}
+}
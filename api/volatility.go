@@ -0,0 +1,123 @@
+package api
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"price-feed/models"
+)
+
+const (
+	defaultVolatilityWindow = 30
+	maxVolatilityWindow     = 1000
+	year                    = 365 * 24 * time.Hour
+)
+
+type volatilityResponse struct {
+	Symbol   string             `json:"symbol"`
+	Interval string             `json:"interval"`
+	Windows  []volatilityWindow `json:"windows"`
+}
+
+type volatilityWindow struct {
+	Window     int     `json:"window"`
+	Volatility float64 `json:"volatility"`
+	Annualized float64 `json:"annualized"`
+}
+
+func (api *API) handleVolatilityRequest(w http.ResponseWriter, r *http.Request) {
+	vars := r.URL.Query()
+
+	symbol := vars.Get("symbol")
+	if symbol == "" {
+		writeError(w, http.StatusBadRequest, "no pair specified")
+		return
+	}
+
+	interval, ok := models.NormalizeInterval(vars.Get("interval"))
+	if !ok {
+		writeError(w, http.StatusBadRequest, "interval is invalid")
+		return
+	}
+
+	windows := []int{defaultVolatilityWindow}
+	if v := vars.Get("window"); v != "" {
+		windows = windows[:0]
+		for _, item := range strings.Split(v, ",") {
+			window, err := strconv.Atoi(item)
+			if err != nil || window < 2 || window > maxVolatilityWindow {
+				writeError(w, http.StatusBadRequest, "window should be a number in range [2; 1000]")
+				return
+			}
+			windows = append(windows, window)
+		}
+	}
+	sort.Ints(windows)
+
+	length := models.IntervalDuration(interval)
+	timeEnd := time.Now()
+	// One more candle is needed for the first return of the largest window.
+	timeStart := timeEnd.Add(-time.Duration(windows[len(windows)-1]+1) * length)
+
+	store, trace, ok := api.queryStorage(w, r)
+	if !ok {
+		return
+	}
+
+	var candles []models.Candle
+	var err error
+	if exchange := vars.Get("exchange"); exchange != "" {
+		candles, err = store.LoadCandlestickListByExchange(exchange, symbol, interval, timeStart.Unix(), timeEnd.Unix())
+	} else {
+		candles, err = store.LoadCandlestickListAll(symbol, interval, models.MergeAverage, timeStart.Unix(), timeEnd.Unix())
+	}
+	if err != nil {
+		api.requestLog(r).Errorf("Could not load candles: %v", err)
+		api.writeStorageError(w, "could not load candles")
+		return
+	}
+
+	sort.Slice(candles, func(i, j int) bool {
+		return candles[i].TimeStart < candles[j].TimeStart
+	})
+
+	resp := volatilityResponse{
+		Symbol:   symbol,
+		Interval: interval,
+		Windows:  make([]volatilityWindow, 0, len(windows)),
+	}
+
+	periodsPerYear := float64(year) / float64(length)
+	for _, window := range windows {
+		from := len(candles) - window - 1
+		if from < 0 {
+			from = 0
+		}
+
+		volatility := models.Volatility(candles[from:])
+		resp.Windows = append(resp.Windows, volatilityWindow{
+			Window:     window,
+			Volatility: volatility,
+			Annualized: volatility * math.Sqrt(periodsPerYear),
+		})
+	}
+
+	data, err := json.Marshal(traced(resp, trace))
+	if err != nil {
+		api.requestLog(r).Errorf("Could not marshal json: %v", err)
+		writeError(w, http.StatusInternalServerError, "could not calculate volatility")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if _, err = w.Write(data); err != nil {
+		api.requestLog(r).Errorf("Could not write response: %v", err)
+		return
+	}
+}
@@ -0,0 +1,131 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultVolumeWindow = 24 * time.Hour
+	maxVolumeWindow     = 30 * 24 * time.Hour
+	// Windows up to a week are summed from 5m candles, longer ones from daily candles.
+	fineVolumeWindow = 7 * 24 * time.Hour
+)
+
+// exchangeList holds the exchanges whose data is merged by the API.
+var exchangeList = []string{"binance", "bittrex", "poloniex", "coinbase", "okx", "bitfinex", "kucoin", "bybit", "huobi"}
+
+type volumeResponse struct {
+	Symbol      string           `json:"symbol"`
+	Window      string           `json:"window"`
+	TimeStart   int64            `json:"timeStart"`
+	TimeEnd     int64            `json:"timeEnd"`
+	Volume      float64          `json:"volume"`
+	QuoteVolume float64          `json:"quoteVolume"`
+	Exchanges   []exchangeVolume `json:"exchanges"`
+}
+
+type exchangeVolume struct {
+	Exchange    string  `json:"exchange"`
+	Volume      float64 `json:"volume"`
+	QuoteVolume float64 `json:"quoteVolume"`
+	Share       float64 `json:"share"`
+}
+
+func (api *API) handleVolumeRequest(w http.ResponseWriter, r *http.Request) {
+	vars := r.URL.Query()
+
+	symbol := vars.Get("symbol")
+	if symbol == "" {
+		writeError(w, http.StatusBadRequest, "no pair specified")
+		return
+	}
+
+	window := defaultVolumeWindow
+	if v := vars.Get("window"); v != "" {
+		var err error
+		window, err = parseWindow(v)
+		if err != nil || window <= 0 || window > maxVolumeWindow {
+			writeError(w, http.StatusBadRequest, "window should be a duration up to 30d, e.g. 24h or 7d")
+			return
+		}
+	}
+
+	interval := "5m"
+	if window > fineVolumeWindow {
+		interval = "1d"
+	}
+
+	timeEnd := time.Now()
+	timeStart := timeEnd.Add(-window)
+
+	resp := volumeResponse{
+		Symbol:    symbol,
+		Window:    vars.Get("window"),
+		TimeStart: timeStart.Unix(),
+		TimeEnd:   timeEnd.Unix(),
+		Exchanges: make([]exchangeVolume, 0, len(exchangeList)),
+	}
+	if resp.Window == "" {
+		resp.Window = defaultVolumeWindow.String()
+	}
+
+	for _, exchange := range exchangeList {
+		if !allowedExchange(r, exchange) {
+			continue
+		}
+
+		candles, err := api.storage.LoadCandlestickListByExchange(exchange, symbol, interval, timeStart.Unix(), timeEnd.Unix())
+		if err != nil {
+			api.requestLog(r).Errorf("Could not load %v candles: %v", exchange, err)
+			api.writeStorageError(w, "could not load candles")
+			return
+		}
+
+		item := exchangeVolume{Exchange: exchange}
+		for _, v := range candles {
+			item.Volume += v.Volume
+			// The typical price approximates the average trade price within the candle.
+			item.QuoteVolume += v.Volume * (v.High + v.Low + v.Close) / 3
+		}
+
+		resp.Volume += item.Volume
+		resp.QuoteVolume += item.QuoteVolume
+		resp.Exchanges = append(resp.Exchanges, item)
+	}
+
+	for i := range resp.Exchanges {
+		if resp.Volume > 0 {
+			resp.Exchanges[i].Share = resp.Exchanges[i].Volume / resp.Volume
+		}
+	}
+
+	data, err := json.Marshal(resp)
+	if err != nil {
+		api.requestLog(r).Errorf("Could not marshal json: %v", err)
+		writeError(w, http.StatusInternalServerError, "could not calculate volume")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if _, err = w.Write(data); err != nil {
+		api.requestLog(r).Errorf("Could not write response: %v", err)
+		return
+	}
+}
+
+// parseWindow parses a duration which may also be given in days, e.g. 7d.
+func parseWindow(v string) (time.Duration, error) {
+	if strings.HasSuffix(v, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(v, "d"))
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(v)
+}
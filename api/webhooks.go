@@ -0,0 +1,141 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"price-feed/models"
+)
+
+const (
+	maxWebhookRequestSize = 1 << 16
+)
+
+type webhookRequest struct {
+	URL       string  `json:"url"`
+	Exchange  string  `json:"exchange"`
+	Symbol    string  `json:"symbol"`
+	Condition string  `json:"condition"`
+	Price     float64 `json:"price"`
+	Spread    float64 `json:"spread"`
+	MaxAge    string  `json:"maxAge"`
+}
+
+type webhooksResponse struct {
+	Webhooks []models.Webhook `json:"webhooks"`
+}
+
+// webhookOwner returns the owner of the webhooks of the request, which is its tenant.
+// Without tenants the webhooks are managed with an admin key and have no owner.
+func (api *API) webhookOwner(w http.ResponseWriter, r *http.Request) (string, bool) {
+	if t, ok := tenantFromContext(r.Context()); ok {
+		return t.config.Name, true
+	}
+
+	if !api.requireScope(w, r, models.ScopeAdmin) {
+		return "", false
+	}
+	return "", true
+}
+
+func (api *API) handleRegisterWebhookRequest(w http.ResponseWriter, r *http.Request) {
+	owner, ok := api.webhookOwner(w, r)
+	if !ok {
+		return
+	}
+
+	var req webhookRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxWebhookRequestSize)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "could not parse webhook: "+err.Error())
+		return
+	}
+
+	if !allowedExchange(r, req.Exchange) {
+		writeError(w, http.StatusForbidden, "exchange is not allowed")
+		return
+	}
+
+	if len(allowedSymbols(r, []string{req.Symbol})) == 0 {
+		writeError(w, http.StatusForbidden, "symbol is not allowed")
+		return
+	}
+
+	webhook := models.Webhook{
+		URL:       req.URL,
+		Exchange:  req.Exchange,
+		Symbol:    req.Symbol,
+		Condition: req.Condition,
+		Price:     req.Price,
+		Spread:    req.Spread,
+		MaxAge:    req.MaxAge,
+	}
+
+	if err := api.webhooks.Validate(webhook); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	webhook, err := api.webhooks.Register(owner, webhook)
+	if err != nil {
+		api.requestLog(r).Errorf("Could not register webhook: %v", err)
+		api.writeStorageError(w, "could not register webhook")
+		return
+	}
+
+	data, err := json.Marshal(webhook)
+	if err != nil {
+		api.requestLog(r).Errorf("Could not marshal json: %v", err)
+		writeError(w, http.StatusInternalServerError, "could not register webhook")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if _, err = w.Write(data); err != nil {
+		api.requestLog(r).Errorf("Could not write response: %v", err)
+		return
+	}
+}
+
+func (api *API) handleWebhooksRequest(w http.ResponseWriter, r *http.Request) {
+	owner, ok := api.webhookOwner(w, r)
+	if !ok {
+		return
+	}
+
+	data, err := json.Marshal(webhooksResponse{Webhooks: api.webhooks.List(owner)})
+	if err != nil {
+		api.requestLog(r).Errorf("Could not marshal json: %v", err)
+		writeError(w, http.StatusInternalServerError, "could not load webhooks")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if _, err = w.Write(data); err != nil {
+		api.requestLog(r).Errorf("Could not write response: %v", err)
+		return
+	}
+}
+
+func (api *API) handleDeleteWebhookRequest(w http.ResponseWriter, r *http.Request) {
+	owner, ok := api.webhookOwner(w, r)
+	if !ok {
+		return
+	}
+
+	deleted, err := api.webhooks.Delete(owner, mux.Vars(r)["id"])
+	if err != nil {
+		api.requestLog(r).Errorf("Could not delete webhook: %v", err)
+		api.writeStorageError(w, "could not delete webhook")
+		return
+	}
+
+	if !deleted {
+		writeError(w, http.StatusNotFound, "webhook not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
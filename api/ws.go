@@ -0,0 +1,160 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"price-feed/hub"
+	"price-feed/models"
+)
+
+const (
+	wsWriteTimeout = 10 * time.Second
+	wsPingInterval = 30 * time.Second
+	wsPongTimeout  = 2 * wsPingInterval
+	wsMaxMessage   = 4096
+)
+
+var upgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool {
+		return true
+	},
+}
+
+// wsRequest represents a subscription message sent by a streaming client, e.g.
+// {"method": "subscribe", "channels": ["kline.1m.BTCUSDT", "candle.1h.BTCUSDT", "bbo.ETHBTC", "book.ETHBTC", "trades.BTCUSDT"]} or
+// {"method": "filter", "minChange": 0.001, "maxRate": 2}.
+type wsRequest struct {
+	Method   string   `json:"method"`
+	Channels []string `json:"channels"`
+	hub.Filter
+}
+
+type wsResponse struct {
+	Method   string   `json:"method,omitempty"`
+	Result   string   `json:"result,omitempty"`
+	Error    string   `json:"error,omitempty"`
+	Channels []string `json:"channels"`
+}
+
+func (api *API) handleWSRequest(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		api.requestLog(r).Errorf("Could not upgrade WS connection: %v", err)
+		return
+	}
+
+	client := api.hub.NewClient()
+	defer client.Close()
+
+	conn.SetReadLimit(wsMaxMessage)
+
+	go api.writeWS(r, conn, client)
+
+	conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+	})
+
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		var req wsRequest
+		if err = json.Unmarshal(message, &req); err != nil {
+			api.replyWS(client, wsResponse{Error: "could not parse request"})
+			continue
+		}
+
+		req.Channels = hub.NormalizeChannels(req.Channels)
+
+		switch req.Method {
+		case "subscribe":
+			if err = api.checkChannels(r, req.Channels); err == nil {
+				err = client.Subscribe(req.Channels...)
+			}
+		case "unsubscribe":
+			client.Unsubscribe(req.Channels...)
+		case "list":
+			req.Channels = client.Channels()
+		case "filter":
+			err = client.SetFilter(req.Filter)
+		default:
+			api.replyWS(client, wsResponse{Method: req.Method, Error: "unknown method"})
+			continue
+		}
+
+		if err != nil {
+			api.replyWS(client, wsResponse{Method: req.Method, Error: err.Error(), Channels: req.Channels})
+			continue
+		}
+
+		api.replyWS(client, wsResponse{Method: req.Method, Result: "ok", Channels: req.Channels})
+	}
+}
+
+// writeWS delivers the client queue to the connection until the client is closed,
+// which happens when the reader exits or the hub evicts a slow client.
+func (api *API) writeWS(r *http.Request, conn *websocket.Conn, client *hub.Client) {
+	ticker := time.NewTicker(wsPingInterval)
+	defer ticker.Stop()
+	defer conn.Close()
+
+	var sent int64
+	if t, ok := tenantFromContext(r.Context()); ok {
+		defer func() {
+			api.recordUsage(t, models.Usage{WsMessages: sent})
+		}()
+	}
+
+	for {
+		select {
+		case <-client.Done():
+			conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "closed"), time.Now().Add(wsWriteTimeout))
+			return
+		case message := <-client.Send():
+			conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if err := conn.WriteMessage(websocket.TextMessage, message); err != nil {
+				return
+			}
+			sent++
+		case <-ticker.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// replyWS queues a response to a client request.
+func (api *API) replyWS(client *hub.Client, resp wsResponse) {
+	data, err := json.Marshal(resp)
+	if err != nil {
+		api.log.Errorf("Could not marshal json: %v", err)
+		return
+	}
+
+	if !client.Push(data) {
+		client.Close()
+	}
+}
+
+// checkChannels verifies that the tenant of the request may subscribe to the channels.
+func (api *API) checkChannels(r *http.Request, channels []string) error {
+	for _, v := range channels {
+		if err := hub.ValidateChannel(v); err != nil {
+			return err
+		}
+
+		if len(allowedSymbols(r, []string{hub.ChannelSymbol(v)})) == 0 {
+			return fmt.Errorf("symbol of channel %v is not allowed", v)
+		}
+	}
+	return nil
+}
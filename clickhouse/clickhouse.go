@@ -0,0 +1,301 @@
+package clickhouse
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"price-feed/hub"
+	"price-feed/logger"
+	"price-feed/models"
+)
+
+const (
+	defaultDatabase       = "default"
+	defaultTradesTable    = "trades"
+	defaultDepthTable     = "depth_diffs"
+	defaultFlushInterval  = time.Second
+	defaultBatchSize      = 10000
+	defaultRequestTimeout = 30 * time.Second
+	queueSize             = 100000
+	timeFormat            = "2006-01-02 15:04:05.000"
+)
+
+// Config represents a ClickHouse archive configuration. The rows are inserted through
+// the HTTP interface, so no ClickHouse client is linked in.
+type Config struct {
+	// URL is the HTTP interface, e.g. http://clickhouse:8123.
+	URL      string `json:"url"`
+	Database string `json:"database"`
+	User     string `json:"user"`
+	Password string `json:"password"`
+	// TradesTable and DepthTable are trades and depth_diffs by default.
+	TradesTable   string `json:"trades_table"`
+	DepthTable    string `json:"depth_table"`
+	BatchSize     int    `json:"batch_size"`
+	FlushInterval string `json:"flush_interval"`
+	// CreateTables creates the MergeTree tables on start if they don't exist.
+	CreateTables bool `json:"create_tables"`
+}
+
+// tradeRow is a row of the trades table.
+type tradeRow struct {
+	Exchange string  `json:"exchange"`
+	Symbol   string  `json:"symbol"`
+	ID       string  `json:"id"`
+	Price    float64 `json:"price"`
+	Quantity float64 `json:"quantity"`
+	Side     string  `json:"side"`
+	Time     string  `json:"time"`
+	Received string  `json:"received"`
+}
+
+// depthRow is a row of the depth diffs table, the levels are split into parallel arrays.
+type depthRow struct {
+	Exchange      string    `json:"exchange"`
+	Symbol        string    `json:"symbol"`
+	FirstUpdateID int64     `json:"first_update_id"`
+	UpdateID      int64     `json:"update_id"`
+	BidPrices     []float64 `json:"bid_prices"`
+	BidSizes      []float64 `json:"bid_sizes"`
+	AskPrices     []float64 `json:"ask_prices"`
+	AskSizes      []float64 `json:"ask_sizes"`
+	Time          string    `json:"time"`
+	Received      string    `json:"received"`
+}
+
+type row struct {
+	table string
+	data  []byte
+}
+
+// Sink archives the raw trades and depth diffs published to the hub into ClickHouse.
+type Sink struct {
+	config        *Config
+	log           *logger.Logger
+	hub           *hub.Hub
+	flushInterval time.Duration
+	client        *http.Client
+	queue         chan row
+	dropped       int64
+}
+
+// New returns a new ClickHouse sink of the hub messages.
+func New(config *Config, log *logger.Logger, hub *hub.Hub) (*Sink, error) {
+	if config.URL == "" {
+		return nil, errors.New("ClickHouse URL is empty")
+	}
+
+	if config.Database == "" {
+		config.Database = defaultDatabase
+	}
+	if config.TradesTable == "" {
+		config.TradesTable = defaultTradesTable
+	}
+	if config.DepthTable == "" {
+		config.DepthTable = defaultDepthTable
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = defaultBatchSize
+	}
+
+	s := &Sink{
+		config:        config,
+		log:           log,
+		hub:           hub,
+		flushInterval: defaultFlushInterval,
+		client:        &http.Client{Timeout: defaultRequestTimeout},
+		queue:         make(chan row, queueSize),
+	}
+
+	if config.FlushInterval != "" {
+		interval, err := time.ParseDuration(config.FlushInterval)
+		if err != nil {
+			return nil, errors.Wrapf(err, "couldn't parse ClickHouse flush interval")
+		}
+		s.flushInterval = interval
+	}
+
+	return s, nil
+}
+
+// Start creates the tables if configured and starts archiving the hub messages.
+func (s *Sink) Start() error {
+	if s.config.CreateTables {
+		if err := s.createTables(); err != nil {
+			return errors.Wrapf(err, "couldn't create ClickHouse tables")
+		}
+	}
+
+	s.hub.AddTap(s.tap)
+	go s.run()
+	return nil
+}
+
+func (s *Sink) createTables() error {
+	for _, query := range []string{
+		`CREATE TABLE IF NOT EXISTS ` + s.table(s.config.TradesTable) + ` (
+			exchange LowCardinality(String),
+			symbol   LowCardinality(String),
+			id       String,
+			price    Float64,
+			quantity Float64,
+			side     LowCardinality(String),
+			time     DateTime64(3, 'UTC'),
+			received DateTime64(3, 'UTC')
+		) ENGINE = MergeTree
+		PARTITION BY toYYYYMM(time)
+		ORDER BY (exchange, symbol, time)`,
+		`CREATE TABLE IF NOT EXISTS ` + s.table(s.config.DepthTable) + ` (
+			exchange        LowCardinality(String),
+			symbol          LowCardinality(String),
+			first_update_id Int64,
+			update_id       Int64,
+			bid_prices      Array(Float64),
+			bid_sizes       Array(Float64),
+			ask_prices      Array(Float64),
+			ask_sizes       Array(Float64),
+			time            DateTime64(3, 'UTC'),
+			received        DateTime64(3, 'UTC')
+		) ENGINE = MergeTree
+		PARTITION BY toYYYYMMDD(time)
+		ORDER BY (exchange, symbol, time, update_id)`,
+	} {
+		if err := s.exec(query, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tap queues the trades and the depth diffs as rows of their tables.
+func (s *Sink) tap(channel string, data interface{}) {
+	now := formatTime(time.Now().UnixNano() / int64(time.Millisecond))
+
+	var r row
+	var err error
+	switch v := data.(type) {
+	case models.Trade:
+		r.table = s.config.TradesTable
+		r.data, err = json.Marshal(tradeRow{
+			Exchange: v.Exchange,
+			Symbol:   v.Symbol,
+			ID:       v.ID,
+			Price:    v.Price,
+			Quantity: v.Quantity,
+			Side:     v.Side,
+			Time:     formatTime(v.Time),
+			Received: now,
+		})
+	case models.DepthDiff:
+		d := depthRow{
+			Exchange:      v.Exchange,
+			Symbol:        v.Symbol,
+			FirstUpdateID: v.FirstUpdateID,
+			UpdateID:      v.UpdateID,
+			BidPrices:     make([]float64, 0, len(v.Bids)),
+			BidSizes:      make([]float64, 0, len(v.Bids)),
+			AskPrices:     make([]float64, 0, len(v.Asks)),
+			AskSizes:      make([]float64, 0, len(v.Asks)),
+			Time:          formatTime(v.Time),
+			Received:      now,
+		}
+		for _, level := range v.Bids {
+			d.BidPrices = append(d.BidPrices, level.Price)
+			d.BidSizes = append(d.BidSizes, level.Size)
+		}
+		for _, level := range v.Asks {
+			d.AskPrices = append(d.AskPrices, level.Price)
+			d.AskSizes = append(d.AskSizes, level.Size)
+		}
+		r.table = s.config.DepthTable
+		r.data, err = json.Marshal(d)
+	default:
+		return
+	}
+	if err != nil {
+		s.log.Errorf("Could not marshal ClickHouse row: %v", err)
+		return
+	}
+
+	select {
+	case s.queue <- r:
+	default:
+		if atomic.AddInt64(&s.dropped, 1)%1000 == 1 {
+			s.log.Warnf("ClickHouse queue is full, %v rows dropped", atomic.LoadInt64(&s.dropped))
+		}
+	}
+}
+
+// run inserts the queued rows in batches per table, at least every flush interval.
+func (s *Sink) run() {
+	ticker := time.NewTicker(s.flushInterval)
+	defer ticker.Stop()
+
+	batches := make(map[string][][]byte)
+	for {
+		select {
+		case r := <-s.queue:
+			batches[r.table] = append(batches[r.table], r.data)
+			if len(batches[r.table]) < s.config.BatchSize {
+				continue
+			}
+			s.insert(r.table, batches[r.table])
+			delete(batches, r.table)
+		case <-ticker.C:
+			for table, rows := range batches {
+				s.insert(table, rows)
+			}
+			batches = make(map[string][][]byte)
+		}
+	}
+}
+
+func (s *Sink) insert(table string, rows [][]byte) {
+	body := append(bytes.Join(rows, []byte("\n")), '\n')
+	if err := s.exec("INSERT INTO "+s.table(table)+" FORMAT JSONEachRow", body); err != nil {
+		s.log.Errorf("Could not insert %v rows into ClickHouse table %v: %v", len(rows), table, err)
+	}
+}
+
+// exec runs the query with the body as its data.
+func (s *Sink) exec(query string, body []byte) error {
+	u := strings.TrimSuffix(s.config.URL, "/") + "/?query=" + url.QueryEscape(query)
+	req, err := http.NewRequest(http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	if s.config.User != "" {
+		req.Header.Set("X-ClickHouse-User", s.config.User)
+		req.Header.Set("X-ClickHouse-Key", s.config.Password)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		message, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("received bad status code %v: %s", resp.StatusCode, bytes.TrimSpace(message))
+	}
+	return nil
+}
+
+func (s *Sink) table(name string) string {
+	return s.config.Database + "." + name
+}
+
+// formatTime formats the milliseconds as a DateTime64(3) value in UTC.
+func formatTime(ms int64) string {
+	return time.Unix(0, ms*int64(time.Millisecond)).UTC().Format(timeFormat)
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"price-feed/aggregator"
+	"price-feed/config"
+	"price-feed/exchanges/binance"
+	"price-feed/logger"
+	"price-feed/storage"
+)
+
+// runCommand runs a one-off command given after the config path instead of starting the service.
+func runCommand(cfg *config.Config, l *logger.Logger, database *storage.Client, name string, args []string) error {
+	switch name {
+	case "recompute":
+		return recomputeIndex(cfg, l, database, args)
+	case "migrate":
+		return migrateStorage(database, args)
+	case "backfill":
+		return backfillCandles(l, database, args)
+	case "export":
+		return exportSnapshot(l, database, args)
+	case "import":
+		return importSnapshot(l, database, args)
+	}
+
+	return fmt.Errorf("unknown command %v", name)
+}
+
+// recomputeIndex rebuilds an index series from the stored exchange candles,
+// e.g. after the pipeline weights or sources have changed.
+func recomputeIndex(cfg *config.Config, l *logger.Logger, database *storage.Client, args []string) error {
+	flags := flag.NewFlagSet("recompute", flag.ContinueOnError)
+	name := flags.String("index", "", "pipeline name")
+	from := flags.Int64("from", 0, "range start, unix time")
+	to := flags.Int64("to", time.Now().Unix(), "range end, unix time")
+	version := flags.Int("version", -1, "series version to write, the configured one by default")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	a, err := aggregator.New(cfg.Aggregator, l, database, nil)
+	if err != nil {
+		return err
+	}
+
+	pipeline, ok := a.Pipeline(*name)
+	if !ok {
+		return fmt.Errorf("unknown index %v", *name)
+	}
+
+	if *version < 0 {
+		*version = pipeline.Version
+	}
+
+	if *from >= *to {
+		return fmt.Errorf("invalid range [%v; %v]", *from, *to)
+	}
+
+	return a.Recompute(pipeline, *version, *from, *to)
+}
+
+// migrateStorage upgrades or rolls back the stored data to the given schema version.
+func migrateStorage(database *storage.Client, args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	to := flags.Int("to", storage.LatestSchemaVersion(), "schema version to migrate to, the latest by default")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	return database.Migrate(*to)
+}
+
+// backfillCandles stores the exchange candles of the symbol within the range, paging
+// through the REST API beyond the candles loaded by the warmup.
+func backfillCandles(l *logger.Logger, database *storage.Client, args []string) error {
+	flags := flag.NewFlagSet("backfill", flag.ContinueOnError)
+	exchange := flags.String("exchange", "binance", "exchange, only binance is supported")
+	symbol := flags.String("symbol", "", "symbol in the Binance format")
+	interval := flags.String("interval", "1m", "kline interval")
+	from := flags.Int64("from", 0, "range start, unix time")
+	to := flags.Int64("to", time.Now().Unix(), "range end, unix time")
+	requestInterval := flags.Duration("request-interval", 250*time.Millisecond, "pause between the requests")
+	restart := flags.Bool("restart", false, "ignore the progress of a previous run")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *exchange != "binance" {
+		return fmt.Errorf("backfill of %v is not supported", *exchange)
+	}
+	if *symbol == "" {
+		return fmt.Errorf("symbol is required")
+	}
+
+	return binance.Backfill(database, l, *symbol, *interval, *from, *to, *requestInterval, *restart)
+}
+
+// exportSnapshot writes the stored candles and order books to a file, see storage.Export.
+func exportSnapshot(l *logger.Logger, database *storage.Client, args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	path := flags.String("out", "snapshot.ndjson", "file to write")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	f, err := os.Create(*path)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	n, err := database.Export(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	l.Infof("Exported %v keys to %v", n, *path)
+	return nil
+}
+
+// importSnapshot stores the candles and order books of a file written by export.
+func importSnapshot(l *logger.Logger, database *storage.Client, args []string) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	path := flags.String("in", "snapshot.ndjson", "file to read")
+	replace := flags.Bool("replace", false, "replace the existing keys instead of merging the members")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	f, err := os.Open(*path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	n, err := database.Import(bufio.NewReader(f), *replace)
+	if err != nil {
+		return err
+	}
+
+	l.Infof("Imported %v keys from %v", n, *path)
+	return nil
+}
@@ -7,13 +7,27 @@ import (
 	"path/filepath"
 
 	"price-feed/exchanges/bittrex"
+	"price-feed/exchanges/coinbase"
+	"price-feed/exchanges/kucoin"
+	"price-feed/exchanges/okx"
 	"price-feed/exchanges/poloniex"
 
 	"github.com/pkg/errors"
+	"price-feed/aggregator"
 	"price-feed/api"
+	"price-feed/clickhouse"
 	"price-feed/exchanges/binance"
+	"price-feed/exchanges/bitfinex"
+	"price-feed/exchanges/bybit"
+	"price-feed/exchanges/huobi"
+	"price-feed/hub"
+	"price-feed/kafka"
 	"price-feed/logger"
+	"price-feed/nats"
+	"price-feed/notify"
 	"price-feed/storage"
+	"price-feed/tracing"
+	"price-feed/webhook"
 )
 
 const (
@@ -25,9 +39,38 @@ type Config struct {
 	Binance  *binance.Config  `json:"binance"`
 	Bittrex  *bittrex.Config  `json:"bittrex"`
 	Poloniex *poloniex.Config `json:"poloniex"`
-	Logger   *logger.Config   `json:"logger"`
-	API      *api.Config      `json:"api"`
-	Storage  *storage.Config  `json:"storage"`
+	// Coinbase enables the Coinbase worker when present.
+	Coinbase *coinbase.Config `json:"coinbase"`
+	// OKX enables the OKX worker when present.
+	OKX *okx.Config `json:"okx"`
+	// Bitfinex enables the Bitfinex worker when present.
+	Bitfinex *bitfinex.Config `json:"bitfinex"`
+	// KuCoin enables the KuCoin worker when present.
+	KuCoin *kucoin.Config `json:"kucoin"`
+	// Bybit enables the Bybit spot worker when present.
+	Bybit *bybit.Config `json:"bybit"`
+	// Huobi enables the Huobi worker when present.
+	Huobi      *huobi.Config      `json:"huobi"`
+	Logger     *logger.Config     `json:"logger"`
+	API        *api.Config        `json:"api"`
+	Storage    *storage.Config    `json:"storage"`
+	Aggregator *aggregator.Config `json:"aggregator"`
+	Hub        *hub.Config        `json:"hub"`
+	// Notifier enables the order book change notifications when present.
+	Notifier *notify.Config `json:"notifier"`
+	// Kafka enables the publishing of the market data to Kafka when present.
+	Kafka *kafka.Config `json:"kafka"`
+	// Webhooks enables the webhook registration API when present.
+	Webhooks *webhook.Config `json:"webhooks"`
+	// ClickHouse enables the archive of the raw trades and depth diffs when present.
+	ClickHouse *clickhouse.Config `json:"clickhouse"`
+	// NATS enables the publishing of the market data to NATS when present.
+	NATS *nats.Config `json:"nats"`
+	// DryRun lists the workers, e.g. "okx", which process their streams but only log
+	// the storage writes, to validate a new integration against production traffic.
+	DryRun []string `json:"dry_run"`
+	// Tracing enables the export of the ingestion and API spans over OTLP when present.
+	Tracing *tracing.Config `json:"tracing"`
 }
 
 // FromFile reads a config from the file specified in `filename`.
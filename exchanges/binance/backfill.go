@@ -0,0 +1,93 @@
+package binance
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/adshao/go-binance"
+	"github.com/pkg/errors"
+	"price-feed/logger"
+	"price-feed/models"
+)
+
+// backfillProgressEvery is the number of pages between the progress logs.
+const backfillProgressEvery = 10
+
+// BackfillStore persists the backfilled candles and the progress of the backfill.
+type BackfillStore interface {
+	StoreCandlesticks(exchange, symbol, interval string, candles []models.Candle) error
+	StoreBackfillCursor(exchange, symbol, interval string, openTime int64) error
+	LoadBackfillCursor(exchange, symbol, interval string) (int64, bool, error)
+	DeleteBackfillCursor(exchange, symbol, interval string) error
+}
+
+// Backfill pages through the REST klines of the symbol within [from; to], unix times,
+// storing candlestickLimit candles per request every requestInterval. The open time of
+// the last stored candle is recorded after every page, so an interrupted backfill of
+// the same symbol and interval resumes after it unless restart is set.
+func Backfill(store BackfillStore, log *logger.Logger, symbol, interval string, from, to int64,
+	requestInterval time.Duration, restart bool) error {
+	step := int64(models.IntervalDuration(interval).Seconds())
+	if step == 0 {
+		return fmt.Errorf("unknown Binance kline interval %v", interval)
+	}
+	if from >= to {
+		return fmt.Errorf("invalid range [%v; %v]", from, to)
+	}
+
+	start := from
+	if !restart {
+		cursor, ok, err := store.LoadBackfillCursor("binance", symbol, interval)
+		if err != nil {
+			return errors.Wrapf(err, "could not load backfill cursor")
+		}
+		if ok && cursor >= from && cursor < to {
+			start = cursor + 1
+			log.Infof("Resuming backfill of %v %v after %v", symbol, interval, time.Unix(cursor, 0).UTC())
+		}
+	}
+
+	client := binance.NewClient("", "")
+	var pages, stored int
+	for start <= to {
+		klines, err := client.NewKlinesService().Symbol(symbol).Interval(interval).
+			StartTime(start * 1000).EndTime(to * 1000).Limit(candlestickLimit).Do(context.Background())
+		if err != nil {
+			return errors.Wrapf(err, "could not load candlesticks of %v %v from %v", symbol, interval, start)
+		}
+		if len(klines) == 0 {
+			break
+		}
+
+		candles := make([]models.Candle, 0, len(klines))
+		for _, k := range klines {
+			candles = append(candles, *models.CandleFromBinanceAPI(k))
+		}
+
+		if err = store.StoreCandlesticks("binance", symbol, interval, candles); err != nil {
+			return errors.Wrapf(err, "could not store candlesticks of %v %v", symbol, interval)
+		}
+
+		last := candles[len(candles)-1].TimeStart
+		if err = store.StoreBackfillCursor("binance", symbol, interval, last); err != nil {
+			return errors.Wrapf(err, "could not store backfill cursor")
+		}
+
+		pages++
+		stored += len(candles)
+		if pages%backfillProgressEvery == 0 {
+			log.Infof("Backfill of %v %v: %v candles stored, %.1f%% done", symbol, interval, stored,
+				float64(last-from)*100/float64(to-from))
+		}
+
+		if len(klines) < candlestickLimit {
+			break
+		}
+		start = last + 1
+		time.Sleep(requestInterval)
+	}
+
+	log.Infof("Backfill of %v %v done: %v candles stored", symbol, interval, stored)
+	return store.DeleteBackfillCursor("binance", symbol, interval)
+}
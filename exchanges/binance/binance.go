@@ -8,52 +8,159 @@ import (
 	"net/url"
 	"os"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/adshao/go-binance"
 	"github.com/pkg/errors"
+	"price-feed/exchanges/common"
+	"price-feed/hub"
 	"price-feed/logger"
 	"price-feed/models"
-	"price-feed/storage"
+	"price-feed/pipeline"
+	"price-feed/status"
+	"price-feed/tracing"
+	"price-feed/warmup"
 )
 
 const (
-	priceURL          = "https://api.binance.com/api/v3/ticker/price"
-	depthURL          = "https://api.binance.com/api/v1/depth"
+	exchangeInfoURL   = "https://api.binance.com/api/v1/exchangeInfo"
+	ticker24hURL      = "https://api.binance.com/api/v1/ticker/24hr"
+	statusInterval    = 5 * time.Minute
+	clockInterval     = time.Minute
 	zero              = "0.00000000"
 	orderBookMaxLimit = 1000
 	candlestickLimit  = 1000
 	apiInterval       = 1 * time.Second
 )
 
+// depthURL serves the order book snapshots, the tests point it at a fake server.
+var depthURL = "https://api.binance.com/api/v1/depth"
+
 // Config represents an order book config
 type Config struct {
+	// WsTimeout is the WS handshake timeout.
 	WsTimeout       string `json:"ws_timeout"`
 	RequestInterval string `json:"request_interval"`
+	// PingInterval enables WS keepalive pings; a connection is dropped if no pong
+	// or message arrives within PongTimeout after a ping.
+	PingInterval string `json:"ping_interval"`
+	PongTimeout  string `json:"pong_timeout"`
+	// The streams reconnect with an exponential backoff with jitter from the longer of
+	// ReconnectMinBackoff, 1s by default, and the request interval of the symbol up to
+	// ReconnectMaxBackoff, 1m by default. A stream is abandoned after ReconnectMaxRetries
+	// consecutive failed or dropped connections; zero retries forever.
+	ReconnectMinBackoff string `json:"reconnect_min_backoff"`
+	ReconnectMaxBackoff string `json:"reconnect_max_backoff"`
+	ReconnectMaxRetries int    `json:"reconnect_max_retries"`
+	// StreamsPerConnection is the number of kline and trade streams multiplexed over
+	// a combined stream connection, 200 by default and at most 1024; -1 opens
+	// a connection per stream. The streams of the priority symbols are multiplexed over
+	// connections of their own. The depth streams always have their own connections,
+	// since the order books are synced per connection.
+	StreamsPerConnection int `json:"streams_per_connection"`
+	// ClosedCandlesOnly makes the worker persist only closed klines,
+	// keeping the in-progress one under a separate key.
+	ClosedCandlesOnly bool `json:"closed_candles_only"`
+	// PartialDepth maps symbols to the number of levels (5, 10 or 20) of a partial
+	// depth stream used instead of maintaining the full order book.
+	PartialDepth map[string]int `json:"partial_depth"`
+	// AutoDiscover makes the worker subscribe to all trading symbols of the exchange
+	// matching QuoteAssets and MinQuoteVolume instead of the built-in symbol list.
+	AutoDiscover   bool     `json:"auto_discover"`
+	QuoteAssets    []string `json:"quote_assets"`
+	MinQuoteVolume float64  `json:"min_quote_volume"`
+	// Blacklist lists symbols which are never subscribed to.
+	Blacklist []string `json:"blacklist"`
+	// WarmupConcurrency limits the candle backfill requests running at once, 4 by default.
+	WarmupConcurrency int `json:"warmup_concurrency"`
+	// PrioritySymbols get their own persistence throttle and snapshot refresh
+	// intervals, while the rest of the symbols use the relaxed ones.
+	PrioritySymbols          []string `json:"priority_symbols"`
+	PersistInterval          string   `json:"persist_interval"`
+	PriorityPersistInterval  string   `json:"priority_persist_interval"`
+	SnapshotInterval         string   `json:"snapshot_interval"`
+	PrioritySnapshotInterval string   `json:"priority_snapshot_interval"`
+	// SnapshotDepth is the depth of the order book snapshots, 1000 by default.
+	SnapshotDepth int `json:"snapshot_depth"`
+	// MaxLevels caps the levels per side kept of the order books, in memory and in
+	// the storage; zero keeps all of them. Levels deleted from the capped top are
+	// not backfilled from the trimmed ones, so a symbol with a snapshot interval
+	// recovers its full depth on the next snapshot.
+	MaxLevels int `json:"max_levels"`
+	// Symbols holds per-symbol overrides.
+	Symbols map[string]*SymbolConfig `json:"symbols"`
+	// Intervals limits the kline streams subscribed to per symbol, all intervals by default.
+	// The storage compaction builds the rest from the subscribed ones.
+	Intervals []string `json:"intervals"`
+	// Trades enables the ingestion of the trades of every symbol.
+	Trades bool `json:"trades"`
+	// AggTrades makes the worker ingest the aggregated trades instead of the raw ones.
+	AggTrades bool `json:"agg_trades"`
+	// BookValidationInterval enables the periodic comparison of the order books to REST
+	// snapshots. A book which drifted by more than MaxBookDrift, 0.2 by default, is replaced.
+	BookValidationInterval string  `json:"book_validation_interval"`
+	MaxBookDrift           float64 `json:"max_book_drift"`
+	// Chaos enables fault injection into the streams. Never enable it in production.
+	Chaos *ChaosConfig `json:"chaos"`
+}
+
+// SymbolConfig overrides the exchange settings for a single symbol.
+type SymbolConfig struct {
+	RequestInterval string `json:"request_interval"`
+	SnapshotDepth   int    `json:"snapshot_depth"`
+	MaxLevels       int    `json:"max_levels"`
 }
 
 // OrderBookAPI represents a Binance order book worker.
 type Worker struct {
-	config                *Config
-	log                   *logger.Logger
-	database              *storage.Client
-	requestInterval       time.Duration
-	wsTimeout             time.Duration
-	symbols               []string
-	quitC                 chan os.Signal
-	AggTradesC            chan *binance.WsAggTradeEvent
-	TradesC               chan *binance.WsTradeEvent
-	KlinesC               chan *binance.WsKlineEvent
-	AllMarketMiniTickersC chan binance.WsAllMiniMarketsStatEvent
-	AllMarketTickersC     chan binance.WsAllMarketsStatEvent
-	PartialBookDepthsC    chan *binance.WsPartialDepthEvent
-	DiffDepthsC           chan *binance.WsDepthEvent
-	StopC                 chan struct{}
-	stops                 []chan struct{}
-	dones                 []chan struct{}
-	orderBookCacheMu      sync.Mutex
-	orderBookCache        map[string]models.OrderBookInternal
+	config           *Config
+	log              *logger.Logger
+	database         CandleStore
+	hub              *hub.Hub
+	requestInterval  time.Duration
+	handshakeTimeout time.Duration
+	pingInterval     time.Duration
+	pongTimeout      time.Duration
+	reconnect        *reconnectPolicy
+	// combined is nil if every stream has its own connection.
+	combined               *combinedStreams
+	subscriptions          *common.Subscriptions
+	quitC                  chan os.Signal
+	AggTradesC             chan *binance.WsAggTradeEvent
+	TradesC                chan *binance.WsTradeEvent
+	KlinesC                chan *binance.WsKlineEvent
+	AllMarketMiniTickersC  chan binance.WsAllMiniMarketsStatEvent
+	AllMarketTickersC      chan binance.WsAllMarketsStatEvent
+	PartialBookDepthsC     chan *binance.WsPartialDepthEvent
+	DiffDepthsC            chan *binance.WsDepthEvent
+	StopC                  chan struct{}
+	stops                  []chan struct{}
+	dones                  []chan struct{}
+	depthSyncsMu           sync.Mutex
+	depthSyncs             map[string]*depthSync
+	orderBookCacheMu       sync.Mutex
+	orderBookCache         map[string]models.OrderBookInternal
+	candleCacheMu          sync.Mutex
+	candleCache            map[string]models.Candle
+	prioritySymbols        map[string]bool
+	persistInterval        [2]time.Duration
+	snapshotInterval       [2]time.Duration
+	lastPersisted          map[string]time.Time
+	symbolIntervals        map[string]time.Duration
+	streams                *status.Tracker
+	warmup                 *warmup.Scheduler
+	clock                  *status.Clock
+	sequences              *sequenceFilter
+	symbolStatuses         *status.SymbolStatuses
+	chaos                  *chaos
+	resyncs                int64
+	bookValidationInterval time.Duration
+	maxBookDrift           float64
+	drifts                 *bookDrifts
+	pipeline               *pipeline.Pipeline
 }
 
 type SymbolInterval struct {
@@ -62,7 +169,7 @@ type SymbolInterval struct {
 }
 
 // NewWorker returns a new Binance worker.
-func NewWorker(config *Config, log *logger.Logger, database *storage.Client, quitC chan os.Signal) (*Worker, error) {
+func NewWorker(config *Config, log *logger.Logger, database CandleStore, hub *hub.Hub, quitC chan os.Signal) (*Worker, error) {
 	wsTimeout, err := time.ParseDuration(config.WsTimeout)
 	if err != nil {
 		return nil, errors.Wrapf(err, "couldn't parse Binance WS timeout")
@@ -73,44 +180,274 @@ func NewWorker(config *Config, log *logger.Logger, database *storage.Client, qui
 		return nil, errors.Wrapf(err, "couldn't parse Binance request interval")
 	}
 
+	pingInterval, err := parseOptionalDuration(config.PingInterval)
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldn't parse Binance WS ping interval")
+	}
+
+	pongTimeout, err := parseOptionalDuration(config.PongTimeout)
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldn't parse Binance WS pong timeout")
+	}
+	if pingInterval > 0 && pongTimeout == 0 {
+		pongTimeout = pingInterval
+	}
+
+	for _, v := range config.Intervals {
+		if models.IntervalDuration(v) == 0 {
+			return nil, fmt.Errorf("unknown Binance kline interval %v", v)
+		}
+	}
+
+	var persistInterval, snapshotInterval [2]time.Duration
+	for i, v := range []string{config.PersistInterval, config.PriorityPersistInterval} {
+		if persistInterval[i], err = parseOptionalDuration(v); err != nil {
+			return nil, errors.Wrapf(err, "couldn't parse Binance persist interval")
+		}
+	}
+	for i, v := range []string{config.SnapshotInterval, config.PrioritySnapshotInterval} {
+		if snapshotInterval[i], err = parseOptionalDuration(v); err != nil {
+			return nil, errors.Wrapf(err, "couldn't parse Binance snapshot interval")
+		}
+	}
+
+	bookValidationInterval, err := parseOptionalDuration(config.BookValidationInterval)
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldn't parse Binance book validation interval")
+	}
+
+	reconnect, err := newReconnectPolicy(config)
+	if err != nil {
+		return nil, err
+	}
+
+	maxBookDrift := defaultMaxBookDrift
+	if config.MaxBookDrift > 0 {
+		maxBookDrift = config.MaxBookDrift
+	}
+
+	if config.SnapshotDepth != 0 && !isValidSnapshotDepth(config.SnapshotDepth) {
+		return nil, fmt.Errorf("invalid Binance snapshot depth %v", config.SnapshotDepth)
+	}
+
+	streamsPerConnection := config.StreamsPerConnection
+	if streamsPerConnection == 0 {
+		streamsPerConnection = defaultStreamsPerConnection
+	}
+	if streamsPerConnection < -1 || streamsPerConnection > maxStreamsPerConnection {
+		return nil, fmt.Errorf("invalid Binance streams per connection %v", config.StreamsPerConnection)
+	}
+
+	if config.MaxLevels < 0 {
+		return nil, fmt.Errorf("invalid Binance max levels %v", config.MaxLevels)
+	}
+
+	symbolIntervals := make(map[string]time.Duration, len(config.Symbols))
+	for symbol, v := range config.Symbols {
+		if v.RequestInterval != "" {
+			if symbolIntervals[symbol], err = time.ParseDuration(v.RequestInterval); err != nil {
+				return nil, errors.Wrapf(err, "couldn't parse Binance request interval for symbol %v", symbol)
+			}
+		}
+
+		if v.SnapshotDepth != 0 && !isValidSnapshotDepth(v.SnapshotDepth) {
+			return nil, fmt.Errorf("invalid Binance snapshot depth %v for symbol %v", v.SnapshotDepth, symbol)
+		}
+
+		if v.MaxLevels < 0 {
+			return nil, fmt.Errorf("invalid Binance max levels %v for symbol %v", v.MaxLevels, symbol)
+		}
+	}
+
+	prioritySymbols := make(map[string]bool, len(config.PrioritySymbols))
+	for _, v := range config.PrioritySymbols {
+		prioritySymbols[v] = true
+	}
+
 	ob := &Worker{
-		config:                config,
-		log:                   log,
-		database:              database,
-		wsTimeout:             wsTimeout,
-		requestInterval:       requestInterval,
-		quitC:                 quitC,
-		AggTradesC:            make(chan *binance.WsAggTradeEvent),
-		TradesC:               make(chan *binance.WsTradeEvent),
-		KlinesC:               make(chan *binance.WsKlineEvent),
-		AllMarketMiniTickersC: make(chan binance.WsAllMiniMarketsStatEvent),
-		AllMarketTickersC:     make(chan binance.WsAllMarketsStatEvent),
-		PartialBookDepthsC:    make(chan *binance.WsPartialDepthEvent),
-		DiffDepthsC:           make(chan *binance.WsDepthEvent, 10000),
-		StopC:                 make(chan struct{}),
-		orderBookCache:        make(map[string]models.OrderBookInternal),
-	}
-
-	if err = ob.fillSymbolListWithTestData(); err != nil {
+		config:                 config,
+		log:                    log,
+		database:               database,
+		hub:                    hub,
+		handshakeTimeout:       wsTimeout,
+		pingInterval:           pingInterval,
+		pongTimeout:            pongTimeout,
+		reconnect:              reconnect,
+		requestInterval:        requestInterval,
+		quitC:                  quitC,
+		AggTradesC:             make(chan *binance.WsAggTradeEvent),
+		TradesC:                make(chan *binance.WsTradeEvent),
+		KlinesC:                make(chan *binance.WsKlineEvent),
+		AllMarketMiniTickersC:  make(chan binance.WsAllMiniMarketsStatEvent),
+		AllMarketTickersC:      make(chan binance.WsAllMarketsStatEvent),
+		PartialBookDepthsC:     make(chan *binance.WsPartialDepthEvent),
+		DiffDepthsC:            make(chan *binance.WsDepthEvent, 10000),
+		StopC:                  make(chan struct{}),
+		depthSyncs:             make(map[string]*depthSync),
+		orderBookCache:         make(map[string]models.OrderBookInternal),
+		candleCache:            make(map[string]models.Candle),
+		prioritySymbols:        prioritySymbols,
+		persistInterval:        persistInterval,
+		snapshotInterval:       snapshotInterval,
+		lastPersisted:          make(map[string]time.Time),
+		symbolIntervals:        symbolIntervals,
+		streams:                status.NewTracker(),
+		warmup:                 warmup.New("Binance", config.WarmupConcurrency, log),
+		clock:                  status.NewClock(time.Millisecond),
+		sequences:              newSequenceFilter(),
+		symbolStatuses:         status.NewSymbolStatuses(),
+		bookValidationInterval: bookValidationInterval,
+		maxBookDrift:           maxBookDrift,
+		drifts:                 newBookDrifts(),
+	}
+	ob.subscriptions = common.NewSubscriptions("binance", "Binance", nil, database, log, nil)
+
+	ob.pipeline = pipeline.New(log,
+		pipeline.Validate(),
+		pipeline.Func("cache", ob.cacheEvent),
+		pipeline.Publish(hub),
+		pipeline.Persist(database, config.ClosedCandlesOnly),
+	)
+
+	for symbol, levels := range config.PartialDepth {
+		if levels != 5 && levels != 10 && levels != 20 {
+			return nil, fmt.Errorf("invalid Binance partial depth levels %v for symbol %v", levels, symbol)
+		}
+	}
+
+	if streamsPerConnection > 0 {
+		ob.combined = newCombinedStreams(ob, streamsPerConnection)
+	}
+
+	if config.Chaos != nil {
+		if ob.chaos, err = newChaos(config.Chaos); err != nil {
+			return nil, err
+		}
+		log.Warnf("Binance chaos mode is enabled")
+	}
+
+	if config.AutoDiscover {
+		err = ob.fillSymbolList()
+	} else {
+		err = ob.fillSymbolListWithTestData()
+	}
+	if err != nil {
 		return nil, errors.Wrapf(err, "couldn't parse Binance symbol list")
 	}
 
+	if err = ob.subscriptions.Restore(config.Blacklist); err != nil {
+		return nil, errors.Wrapf(err, "couldn't restore Binance subscriptions")
+	}
+
 	return ob, nil
 }
 
 // Start starts a new Binance worker.
 func (w *Worker) Start() {
-	for _, symbol := range w.symbols {
-		go func(symbol string) {
-			err := w.SubscribeOrderBook(symbol)
-			if err != nil {
-				w.log.Printf("Couldn't get diff depths on symbol %s: %v", symbol, err)
+	w.warmStart()
+
+	go w.watchSymbolStatuses()
+	go w.watchClock()
+	if w.bookValidationInterval > 0 {
+		go w.validateOrderBooks()
+	}
+
+	for _, symbol := range w.subscriptions.List() {
+		w.subscribe(symbol)
+	}
+}
+
+// subscribe starts the order book and candlestick streams of the symbol.
+func (w *Worker) subscribe(symbol string) {
+	go func() {
+		var err error
+		if levels, ok := w.config.PartialDepth[symbol]; ok {
+			err = w.SubscribePartialOrderBook(symbol, levels)
+		} else {
+			err = w.SubscribeOrderBook(symbol)
+		}
+		if err != nil {
+			w.log.Printf("Couldn't get diff depths on symbol %s: %v", symbol, err)
+		}
+	}()
+	go w.SubscribeCandlestickAll(symbol)
+
+	if w.config.Trades && w.config.AggTrades {
+		go w.SubscribeAggTrades(symbol)
+	} else if w.config.Trades {
+		go w.SubscribeTrades(symbol)
+	}
+}
+
+// Streams returns the last event status of every stream.
+func (w *Worker) Streams() []status.Stream {
+	return w.streams.Streams()
+}
+
+// SymbolStatuses returns the trading status of every symbol the worker is subscribed to.
+func (w *Worker) SymbolStatuses() map[string]string {
+	return w.symbolStatuses.All()
+}
+
+// ClockSkew returns the last measured skew of the Binance clock.
+func (w *Worker) ClockSkew() time.Duration {
+	return w.clock.Skew()
+}
+
+// watchClock periodically measures the skew of the Binance clock.
+func (w *Worker) watchClock() {
+	for ; ; <-time.Tick(clockInterval) {
+		if err := w.clock.Measure(serverTime); err != nil {
+			w.log.Warnf("Could not measure Binance clock skew: %v", err)
+		}
+	}
+}
+
+// watchSymbolStatuses periodically refreshes the trading statuses of the symbols.
+func (w *Worker) watchSymbolStatuses() {
+	for ; ; <-time.Tick(statusInterval) {
+		var info struct {
+			Symbols []struct {
+				Symbol string `json:"symbol"`
+				Status string `json:"status"`
+			} `json:"symbols"`
+		}
+
+		if err := getJSON(exchangeInfoURL, &info); err != nil {
+			w.log.Errorf("Could not get Binance exchange info: %v", err)
+			continue
+		}
+
+		listed := make(map[string]string, len(info.Symbols))
+		for _, v := range info.Symbols {
+			listed[v.Symbol] = v.Status
+		}
+
+		symbols := w.subscriptions.List()
+		statuses := make(map[string]string, len(symbols))
+		for _, symbol := range symbols {
+			switch v, ok := listed[symbol]; {
+			case !ok:
+				statuses[symbol] = models.SymbolStatusDelisted
+			case v == models.SymbolStatusTrading:
+				statuses[symbol] = models.SymbolStatusTrading
+			default:
+				statuses[symbol] = models.SymbolStatusBreak
 			}
-		}(symbol)
-		go w.SubscribeCandlestickAll(symbol)
+		}
+
+		w.symbolStatuses.Set(statuses)
+		if err := w.database.StoreSymbolStatuses("binance", statuses); err != nil {
+			w.log.Errorf("Could not store Binance symbol statuses: %v", err)
+		}
 	}
 }
 
+// Symbols returns the symbols the worker is subscribed to.
+func (w *Worker) Symbols() []string {
+	return w.subscriptions.List()
+}
+
 func (w *Worker) GetOrderBook(symbol string) (models.OrderBookInternal, bool) {
 	w.orderBookCacheMu.Lock()
 	defer w.orderBookCacheMu.Unlock()
@@ -119,6 +456,59 @@ func (w *Worker) GetOrderBook(symbol string) (models.OrderBookInternal, bool) {
 	return ob, ok
 }
 
+// GetLastCandle returns the most recent candle seen for the symbol and interval.
+func (w *Worker) GetLastCandle(symbol, interval string) (models.Candle, bool) {
+	w.candleCacheMu.Lock()
+	defer w.candleCacheMu.Unlock()
+
+	candle, ok := w.candleCache[candleCacheKey(symbol, interval)]
+	return candle, ok
+}
+
+// warmStart fills the in-memory caches with the data stored by a previous run,
+// so the API can serve it before fresh snapshots arrive.
+func (w *Worker) warmStart() {
+	for _, symbol := range w.subscriptions.List() {
+		orderBook, ok, err := w.database.LoadOrderBookSnapshot(symbol)
+		if err != nil {
+			w.log.Errorf("Could not load stored order book for symbol %v: %v", symbol, err)
+		} else if ok {
+			w.orderBookCacheMu.Lock()
+			w.orderBookCache[symbol] = orderBook
+			w.orderBookCacheMu.Unlock()
+		}
+
+		for _, interval := range w.intervals() {
+			candle, ok, err := w.database.LoadLastCandlestick("binance", symbol, interval)
+			if err != nil {
+				w.log.Errorf("Could not load stored candlestick for symbol %v interval %v: %v", symbol, interval, err)
+				continue
+			}
+
+			if ok {
+				w.cacheCandle(symbol, interval, candle)
+			}
+		}
+	}
+}
+
+// cacheCandle stores the candle in the cache unless a newer one is already there.
+func (w *Worker) cacheCandle(symbol, interval string, candle models.Candle) {
+	w.candleCacheMu.Lock()
+	defer w.candleCacheMu.Unlock()
+
+	key := candleCacheKey(symbol, interval)
+	if cached, ok := w.candleCache[key]; ok && cached.TimeStart > candle.TimeStart {
+		return
+	}
+
+	w.candleCache[key] = candle
+}
+
+func candleCacheKey(symbol, interval string) string {
+	return symbol + ":" + interval
+}
+
 func (w *Worker) AggTrades(symbol string) error {
 	wsAggTradesHandler := func(event *binance.WsAggTradeEvent) {
 		w.AggTradesC <- event
@@ -225,53 +615,216 @@ func (w *Worker) DiffDepths(symbol string) error {
 	return nil
 }
 
-// https://github.com/binance-exchange/binance-official-api-docs/blob/master/web-socket-streams.md#how-to-manage-a-local-order-book-correctly
+// SubscribeOrderBook maintains the order book of the symbol from its diff depth stream,
+// synced to a snapshot every time the stream connects.
 func (w *Worker) SubscribeOrderBook(symbol string) error {
-	for ; ; <-time.Tick(w.requestInterval) {
-		// Get a depth snapshot from https://www.binance.com/api/v1/depth?symbol=BNBBTC&limit=1000
-		orderBook, err := w.getOrderBook(symbol, orderBookMaxLimit)
+	stream := strings.ToLower(symbol) + "@depth"
+	depth := w.depthSync(symbol)
+	wsDiffDepthsHandler := func(event *binance.WsDepthEvent) {
+		event.Time = w.clock.Adjust(event.Time)
+		w.streams.Track(stream, event.UpdateID, event.Time)
+		if !w.sequences.accept(stream, event.UpdateID, 0) {
+			return
+		}
+		w.handleDepthEvent(symbol, event)
 
-		// b.log.Debugf("Got order book for symbol %v: %+v", symbol, orderBook)
+		// The depth channel isn't subscribable, it only feeds the hub taps, e.g. the archive.
+		w.hub.Publish("depth."+symbol, models.DepthDiffFromBinanceEvent(symbol, event))
+	}
 
+	reconnect := w.newReconnector("depth", stream, symbol)
+	for {
+		// Open a stream to wss://stream.binance.com:9443/ws/bnbbtc@depth
+		doneC, _, err := w.wsDepthServe(symbol, wsDiffDepthsHandler)
 		if err != nil {
-			return errors.Wrapf(err, "could not get order book")
+			if err = reconnect.wait(err); err != nil {
+				return err
+			}
+			continue
+		}
+
+		reconnect.connected()
+		// The events are buffered until the snapshot is fetched.
+		depth.mu.Lock()
+		w.resyncOrderBook(symbol, depth)
+		depth.mu.Unlock()
+
+		w.refreshOrderBook(symbol, doneC)
+		depth.stop()
+		if err = reconnect.wait(nil); err != nil {
+			return err
+		}
+	}
+}
+
+// Resyncs returns the number of order book resyncs caused by gaps in the updates.
+func (w *Worker) Resyncs() int64 {
+	return atomic.LoadInt64(&w.resyncs)
+}
+
+// ChaosStats returns the injected faults by kind and the invariant violations found
+// in chaos mode. ok is false if chaos mode is disabled.
+func (w *Worker) ChaosStats() (faults map[string]int64, violations int64, ok bool) {
+	if w.chaos == nil {
+		return nil, 0, false
+	}
+
+	faults, violations = w.chaos.stats()
+	return faults, violations, true
+}
+
+// refreshOrderBook replaces the cached order book with a fresh snapshot
+// every snapshot interval of the symbol until the stream is done.
+func (w *Worker) refreshOrderBook(symbol string, doneC chan struct{}) {
+	interval := w.snapshotInterval[w.priorityIndex(symbol)]
+	if interval == 0 {
+		<-doneC
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-doneC:
+			return
+		case <-ticker.C:
+			orderBook, err := w.getOrderBook(symbol, w.snapshotDepth(symbol))
+			if err != nil {
+				w.log.Errorf("Could not refresh order book for symbol %v: %v", symbol, err)
+				continue
+			}
+			if !w.refreshSnapshot(symbol, orderBook) {
+				w.log.Debugf("Skipped order book refresh of symbol %v, the snapshot is behind the updates", symbol)
+			}
 		}
-		w.orderBookCacheMu.Lock()
-		w.orderBookCache[symbol] = orderBook
-		w.orderBookCacheMu.Unlock()
+	}
+}
+
+// newReconnector returns a reconnector of a stream of the symbol.
+func (w *Worker) newReconnector(kind, stream, symbol string) *reconnector {
+	return w.reconnect.newReconnector(w.log, kind, stream, w.symbolRequestInterval(symbol))
+}
+
+// symbolRequestInterval returns the request interval of the symbol.
+func (w *Worker) symbolRequestInterval(symbol string) time.Duration {
+	if interval, ok := w.symbolIntervals[symbol]; ok {
+		return interval
+	}
+	return w.requestInterval
+}
+
+// snapshotDepth returns the depth of the order book snapshots of the symbol.
+func (w *Worker) snapshotDepth(symbol string) int {
+	if v, ok := w.config.Symbols[symbol]; ok && v.SnapshotDepth != 0 {
+		return v.SnapshotDepth
+	}
+	if w.config.SnapshotDepth != 0 {
+		return w.config.SnapshotDepth
+	}
+	return orderBookMaxLimit
+}
+
+// maxLevels returns the number of levels per side kept of the order book of the symbol,
+// zero if it is not capped.
+func (w *Worker) maxLevels(symbol string) int {
+	if v, ok := w.config.Symbols[symbol]; ok && v.MaxLevels != 0 {
+		return v.MaxLevels
+	}
+	return w.config.MaxLevels
+}
 
-		// Buffer the events you receive from the stream
-		wsDiffDepthsHandler := func(event *binance.WsDepthEvent) {
-			if err = w.updateOrderBook(symbol, event); err != nil {
+// trimOrderBook removes the levels beyond the cap of the symbol from the order book.
+func (w *Worker) trimOrderBook(symbol string, orderBook *models.OrderBookInternal) {
+	if levels := w.maxLevels(symbol); levels > 0 {
+		orderBook.Trim(levels)
+	}
+}
+
+func isValidSnapshotDepth(depth int) bool {
+	switch depth {
+	case 5, 10, 20, 50, 100, 500, 1000:
+		return true
+	}
+	return false
+}
+
+// priorityIndex returns 1 for priority symbols and 0 for the rest,
+// to pick the matching value of the interval pairs.
+func (w *Worker) priorityIndex(symbol string) int {
+	if w.prioritySymbols[symbol] {
+		return 1
+	}
+	return 0
+}
+
+// shouldPersistOrderBook reports whether the persistence throttle of the symbol has passed.
+// It must be called with orderBookCacheMu held.
+func (w *Worker) shouldPersistOrderBook(symbol string) bool {
+	now := time.Now()
+	if now.Sub(w.lastPersisted[symbol]) < w.persistInterval[w.priorityIndex(symbol)] {
+		return false
+	}
+
+	w.lastPersisted[symbol] = now
+	return true
+}
+
+// SubscribePartialOrderBook keeps the top levels of the order book from a partial depth stream.
+func (w *Worker) SubscribePartialOrderBook(symbol string, levels int) error {
+	reconnect := w.newReconnector("partial_depth", fmt.Sprintf("%s@depth%d", strings.ToLower(symbol), levels), symbol)
+	for {
+		wsPartialDepthHandler := func(event *binance.WsPartialDepthEvent) {
+			w.streams.Track(fmt.Sprintf("%s@depth%d", strings.ToLower(symbol), levels), event.LastUpdateID, 0)
+			if err := w.replaceOrderBook(symbol, models.SerializeBinancePartialDepthWS(event)); err != nil {
 				w.log.Errorf("Could not update order book: %v", err)
 			}
 		}
 
-		// Open a stream to wss://stream.binance.com:9443/ws/bnbbtc@depth
-		doneC, _, err := binance.WsDepthServe(symbol, wsDiffDepthsHandler, w.makeErrorHandler())
+		// Open a stream to wss://stream.binance.com:9443/ws/bnbbtc@depth20
+		doneC, _, err := w.wsPartialDepthServe(symbol, levels, wsPartialDepthHandler)
 		if err != nil {
-			return err
+			if err = reconnect.wait(err); err != nil {
+				return err
+			}
+			continue
 		}
 
+		reconnect.connected()
 		<-doneC
+		if err = reconnect.wait(nil); err != nil {
+			return err
+		}
 	}
 }
 
+// intervals returns the kline intervals the worker subscribes to.
+func (w *Worker) intervals() []string {
+	if len(w.config.Intervals) > 0 {
+		return w.config.Intervals
+	}
+	return models.BinanceCandlestickIntervalList
+}
+
 func (w *Worker) Reload() {
-	for _, symbol := range w.symbols {
-		for _, v := range models.BinanceCandlestickIntervalList {
-			go func(s string) {
-				w.initCandlesticks(symbol, s)
-			}(v)
+	for _, symbol := range w.subscriptions.List() {
+		for _, v := range w.intervals() {
+			symbol, interval := symbol, v
+			w.warmup.Submit(func() {
+				w.initCandlesticks(symbol, interval)
+			})
 		}
 	}
 	w.log.Infof("Binance cache reloaded")
 }
 
 func (w *Worker) SubscribeCandlestickAll(symbol string) {
-	for _, v := range models.BinanceCandlestickIntervalList {
+	for _, v := range w.intervals() {
 		go func(s string) {
-			w.initCandlesticks(symbol, s)
+			w.warmup.Run(func() {
+				w.initCandlesticks(symbol, s)
+			})
 
 			if err := w.SubscribeCandlestick(symbol, s); err != nil {
 				w.log.Errorf("Could not subscribe to candlestick interval %v symbol %v: %v", v, symbol, err)
@@ -291,28 +844,165 @@ func (w *Worker) initCandlesticks(symbol, interval string) {
 		return
 	}
 
+	candles := make([]models.Candle, 0, len(candlesticks))
 	for _, k := range candlesticks {
-		if err := w.updateCandlestickAPI(symbol, interval, k); err != nil {
-			w.log.Errorf("Could not update candlesticks from REST API: %v", err)
+		candle := models.CandleFromBinanceAPI(k)
+		w.cacheCandle(symbol, interval, *candle)
+
+		if w.config.ClosedCandlesOnly && k.CloseTime > time.Now().Unix()*1000 {
+			if err := w.database.StoreCurrentCandlestick("binance", symbol, interval, candle); err != nil {
+				w.log.Errorf("Could not store current candlestick to database: %v", err)
+			}
+			continue
 		}
+
+		candles = append(candles, *candle)
+	}
+
+	if err := w.database.StoreCandlesticks("binance", symbol, interval, candles); err != nil {
+		w.log.Errorf("Could not store candlesticks from REST API to database: %v", err)
 	}
 }
 
 func (w *Worker) SubscribeCandlestick(symbol, interval string) error {
-	for ; ; <-time.Tick(w.requestInterval) {
-		wsCandlestickHandler := func(event *binance.WsKlineEvent) {
-			if err := w.updateCandlestick(symbol, interval, event); err != nil {
-				w.log.Errorf("Could not update order book: %v", err)
-			}
+	stream := strings.ToLower(symbol) + "@kline_" + interval
+	wsCandlestickHandler := func(event *binance.WsKlineEvent) {
+		span := tracing.Start("binance.kline")
+		defer span.End()
+		span.SetAttribute("symbol", symbol)
+		span.SetAttribute("interval", interval)
+
+		event.Time = w.clock.Adjust(event.Time)
+		// The lag is the time the event spent between the exchange and the worker.
+		span.SetAttribute("exchange.lag_ms", time.Now().UnixNano()/int64(time.Millisecond)-event.Time)
+		w.streams.Track(stream, event.Kline.LastTradeID, event.Time)
+		// Stale or replayed updates must not overwrite a newer state of the candle.
+		if !w.sequences.accept(stream, event.Kline.StartTime, klineMinor(&event.Kline)) {
+			return
 		}
+		w.pipeline.Process(&pipeline.CandleEvent{
+			Exchange: "binance",
+			Symbol:   symbol,
+			Interval: interval,
+			Candle:   *models.CandleFromEvent(event),
+			Final:    event.Kline.IsFinal,
+			Received: time.Now(),
+			Span:     span,
+		})
+	}
 
+	if w.combined != nil {
+		w.combined.subscribe(stream, w.prioritySymbols[symbol], w.klineMessageHandler(wsBaseURL+"/"+stream, wsCandlestickHandler))
+		return nil
+	}
+
+	reconnect := w.newReconnector("kline", stream, symbol)
+	for {
 		// Open a stream to wss://stream.binance.com:9443/ws/bnbbtc@depth
-		doneC, _, err := binance.WsKlineServe(symbol, interval, wsCandlestickHandler, w.makeErrorHandler())
+		doneC, _, err := w.wsKlineServe(symbol, interval, wsCandlestickHandler)
 		if err != nil {
+			if err = reconnect.wait(err); err != nil {
+				return err
+			}
+			continue
+		}
+
+		reconnect.connected()
+		<-doneC
+		if err = reconnect.wait(nil); err != nil {
 			return err
 		}
+	}
+}
+
+// SubscribeTrades stores the trades of the symbol, reconnecting when the stream is done.
+func (w *Worker) SubscribeTrades(symbol string) {
+	stream := strings.ToLower(symbol) + "@trade"
+	wsTradeHandler := func(event *binance.WsTradeEvent) {
+		// The trades are bucketed by time, so they are adjusted to the local clock.
+		event.Time = w.clock.Adjust(event.Time)
+		event.TradeTime = w.clock.Adjust(event.TradeTime)
+		w.streams.Track(stream, event.TradeID, event.Time)
+		if !w.sequences.accept(stream, event.TradeID, 0) {
+			return
+		}
+
+		trade, err := models.TradeFromBinanceEvent(event)
+		if err != nil {
+			w.log.Errorf("Could not parse trade of symbol %v: %v", symbol, err)
+			return
+		}
+
+		w.pipeline.Process(&pipeline.TradeEvent{Trade: trade, Received: time.Now()})
+	}
+
+	if w.combined != nil {
+		w.combined.subscribe(stream, w.prioritySymbols[symbol], w.tradeMessageHandler(wsBaseURL+"/"+stream, wsTradeHandler))
+		return
+	}
+
+	reconnect := w.newReconnector("trade", stream, symbol)
+	for {
+		doneC, _, err := w.wsTradeServe(symbol, wsTradeHandler)
+		if err != nil {
+			if err = reconnect.wait(err); err != nil {
+				w.log.Errorf("Could not subscribe to trades of symbol %v: %v", symbol, err)
+				return
+			}
+			continue
+		}
+
+		reconnect.connected()
+		<-doneC
+		if err = reconnect.wait(nil); err != nil {
+			w.log.Errorf("Could not subscribe to trades of symbol %v: %v", symbol, err)
+			return
+		}
+	}
+}
+
+// SubscribeAggTrades stores the aggregated trades of the symbol, reconnecting when the stream is done.
+func (w *Worker) SubscribeAggTrades(symbol string) {
+	stream := strings.ToLower(symbol) + "@aggTrade"
+	wsAggTradeHandler := func(event *binance.WsAggTradeEvent) {
+		event.Time = w.clock.Adjust(event.Time)
+		event.TradeTime = w.clock.Adjust(event.TradeTime)
+		w.streams.Track(stream, event.AggTradeID, event.Time)
+		if !w.sequences.accept(stream, event.AggTradeID, 0) {
+			return
+		}
+
+		trade, err := models.TradeFromBinanceAggEvent(event)
+		if err != nil {
+			w.log.Errorf("Could not parse aggregated trade of symbol %v: %v", symbol, err)
+			return
+		}
+
+		w.pipeline.Process(&pipeline.TradeEvent{Trade: trade, Received: time.Now()})
+	}
+
+	if w.combined != nil {
+		w.combined.subscribe(stream, w.prioritySymbols[symbol], w.aggTradeMessageHandler(wsBaseURL+"/"+stream, wsAggTradeHandler))
+		return
+	}
+
+	reconnect := w.newReconnector("agg_trade", stream, symbol)
+	for {
+		doneC, _, err := w.wsAggTradeServe(symbol, wsAggTradeHandler)
+		if err != nil {
+			if err = reconnect.wait(err); err != nil {
+				w.log.Errorf("Could not subscribe to aggregated trades of symbol %v: %v", symbol, err)
+				return
+			}
+			continue
+		}
 
+		reconnect.connected()
 		<-doneC
+		if err = reconnect.wait(nil); err != nil {
+			w.log.Errorf("Could not subscribe to aggregated trades of symbol %v: %v", symbol, err)
+			return
+		}
 	}
 }
 
@@ -345,6 +1035,21 @@ func (w *Worker) updateOrderBook(symbol string, event *binance.WsDepthEvent) err
 		w.orderBookCache[symbol].Asks[ask.Price] = ask.Quantity
 	}
 
+	orderBook := w.orderBookCache[symbol]
+	w.trimOrderBook(symbol, &orderBook)
+
+	if w.chaos != nil {
+		if !w.chaos.checkOrderBook(&orderBook) {
+			w.log.Errorf("Binance order book of symbol %v is crossed after update %v", symbol, event.UpdateID)
+		}
+	}
+
+	w.publishBBO(symbol)
+
+	if !w.shouldPersistOrderBook(symbol) {
+		return nil
+	}
+
 	if err := w.database.StoreOrderBookInternal(symbol, w.orderBookCache[symbol]); err != nil {
 		w.log.Errorf("Could not store order book to database: %v", err)
 	}
@@ -352,20 +1057,47 @@ func (w *Worker) updateOrderBook(symbol string, event *binance.WsDepthEvent) err
 	return nil
 }
 
-func (w *Worker) updateCandlestick(symbol, interval string, event *binance.WsKlineEvent) error {
-	if err := w.database.StoreCandlestickBinance(symbol, interval, event); err != nil {
-		w.log.Errorf("Could not store candlestick to database: %v", err)
+func (w *Worker) replaceOrderBook(symbol string, orderBook models.OrderBookInternal) error {
+	w.orderBookCacheMu.Lock()
+	defer w.orderBookCacheMu.Unlock()
+
+	w.trimOrderBook(symbol, &orderBook)
+	w.orderBookCache[symbol] = orderBook
+
+	w.publishBBO(symbol)
+
+	if !w.shouldPersistOrderBook(symbol) {
+		return nil
+	}
+
+	if err := w.database.StoreOrderBookInternal(symbol, orderBook); err != nil {
+		w.log.Errorf("Could not store order book to database: %v", err)
 	}
 
 	return nil
 }
 
-func (w *Worker) updateCandlestickAPI(symbol, interval string, candlestick *binance.Kline) error {
-	if err := w.database.StoreCandlestickBinanceAPI(symbol, interval, candlestick); err != nil {
-		w.log.Errorf("Could not store candlestick from REST API to database: %v", err)
+// publishBBO pushes the best bid and offer of the symbol to the hub subscribers.
+// It must be called with orderBookCacheMu held.
+func (w *Worker) publishBBO(symbol string) {
+	channel := "bbo." + symbol
+	if !w.hub.HasSubscribers(channel) {
+		return
 	}
 
-	return nil
+	orderBook := w.orderBookCache[symbol]
+	bbo := orderBook.BestBidOffer()
+	bbo.Exchange = "binance"
+	bbo.Symbol = symbol
+	w.hub.Publish(channel, bbo)
+}
+
+// cacheEvent keeps the latest candle of every series in memory, see cacheCandle.
+func (w *Worker) cacheEvent(event pipeline.Event) (bool, error) {
+	if e, ok := event.(*pipeline.CandleEvent); ok {
+		w.cacheCandle(e.Symbol, e.Interval, e.Candle)
+	}
+	return true, nil
 }
 
 func (w *Worker) StopAll() {
@@ -387,38 +1119,96 @@ func (w *Worker) makeErrorHandler() binance.ErrHandler {
 }
 
 func (w *Worker) fillSymbolList() error {
-	resp, err := http.Get(priceURL)
-	if err != nil {
-		return err
+	var info struct {
+		Symbols []struct {
+			Symbol     string `json:"symbol"`
+			Status     string `json:"status"`
+			QuoteAsset string `json:"quoteAsset"`
+		} `json:"symbols"`
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("fillSymbolList received bad status code: %v", resp.StatusCode)
+	if err := getJSON(exchangeInfoURL, &info); err != nil {
+		return errors.Wrapf(err, "could not get exchange info")
 	}
 
-	var data []struct {
-		Symbol string `json:"symbol"`
-		Price  string `json:"price"`
-	}
+	var volumes map[string]float64
+	if w.config.MinQuoteVolume > 0 {
+		var tickers []struct {
+			Symbol      string `json:"symbol"`
+			QuoteVolume string `json:"quoteVolume"`
+		}
 
-	if err = json.NewDecoder(resp.Body).Decode(&data); err != nil {
-		return err
+		if err := getJSON(ticker24hURL, &tickers); err != nil {
+			return errors.Wrapf(err, "could not get 24h tickers")
+		}
+
+		volumes = make(map[string]float64, len(tickers))
+		for _, ticker := range tickers {
+			volume, err := strconv.ParseFloat(ticker.QuoteVolume, 64)
+			if err != nil {
+				continue
+			}
+			volumes[ticker.Symbol] = volume
+		}
 	}
 
-	symbols := make([]string, 0, len(data))
+	symbols := make([]string, 0, len(info.Symbols))
+
+	for _, item := range info.Symbols {
+		if item.Status != models.SymbolStatusTrading || !w.isQuoteAssetAllowed(item.QuoteAsset) {
+			continue
+		}
+
+		if volumes != nil && volumes[item.Symbol] < w.config.MinQuoteVolume {
+			continue
+		}
 
-	for _, item := range data {
 		symbols = append(symbols, item.Symbol)
 	}
 
-	w.log.Infof("Working with %v symbols on Binance", len(symbols))
+	symbols = models.ExcludeSymbols(symbols, w.config.Blacklist)
+	w.subscriptions.Set(symbols)
 
-	w.symbols = symbols
+	w.log.Infof("Working with %v symbols on Binance", len(symbols))
 	return nil
 }
 
+func (w *Worker) isQuoteAssetAllowed(asset string) bool {
+	if len(w.config.QuoteAssets) == 0 {
+		return true
+	}
+
+	for _, v := range w.config.QuoteAssets {
+		if v == asset {
+			return true
+		}
+	}
+	return false
+}
+
+func parseOptionalDuration(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(s)
+}
+
+func getJSON(url string, v interface{}) error {
+	resp, err := http.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%v received bad status code: %v", url, resp.StatusCode)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
 func (w *Worker) fillSymbolListWithTestData() error {
-	w.symbols = models.BinanceSymbols
+	w.subscriptions.Set(models.ExcludeSymbols(models.BinanceSymbols, w.config.Blacklist))
 	return nil
 }
 
@@ -432,9 +1222,15 @@ func (w *Worker) getOrderBook(symbol string, depth int) (response models.OrderBo
 	if err != nil {
 		return models.OrderBookInternal{}, err
 	}
-
-	if resp.StatusCode == http.StatusTooManyRequests {
-		time.Sleep(apiInterval)
+	defer resp.Body.Close()
+
+	// Binance answers 429 to a client over the request weight limit and 418 once it
+	// bans the IP for ignoring them, both with the seconds to back off in Retry-After.
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusTeapot {
+		retryAfter := retryAfterDelay(resp.Header.Get("Retry-After"))
+		w.log.Warnf("Binance rate limited the order book request of symbol %v, backing off for %v", symbol, retryAfter)
+		time.Sleep(retryAfter)
+		return models.OrderBookInternal{}, fmt.Errorf("getOrderBook was rate limited with status code %v", resp.StatusCode)
 	} else if resp.StatusCode != http.StatusOK {
 		return models.OrderBookInternal{}, fmt.Errorf("getOrderBook received bad status code: %v", resp.StatusCode)
 	}
@@ -448,6 +1244,16 @@ func (w *Worker) getOrderBook(symbol string, depth int) (response models.OrderBo
 	return models.SerializeBinanceOrderBookREST(data), nil
 }
 
+// retryAfterDelay returns the delay of a Retry-After header in seconds, or the API
+// interval if the header is missing or invalid.
+func retryAfterDelay(header string) time.Duration {
+	seconds, err := strconv.Atoi(header)
+	if err != nil || seconds <= 0 {
+		return apiInterval
+	}
+	return time.Duration(seconds) * time.Second
+}
+
 func (w *Worker) makeOrderBookURL(symbol string, depth int) (string, error) {
 	u, err := url.Parse(depthURL)
 	if err != nil {
@@ -459,3 +1265,12 @@ func (w *Worker) makeOrderBookURL(symbol string, depth int) (string, error) {
 	u.RawQuery = q.Encode()
 	return u.String(), nil
 }
+
+// serverTime returns the time of the Binance server.
+func serverTime() (time.Time, error) {
+	ms, err := binance.NewClient("", "").NewServerTimeService().Do(context.Background())
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(0, ms*int64(time.Millisecond)), nil
+}
@@ -0,0 +1,141 @@
+package binance
+
+import (
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"price-feed/models"
+)
+
+// ChaosConfig enables fault injection into the WS streams, so the order book resync
+// and gap repair paths can be exercised against a live or test exchange.
+type ChaosConfig struct {
+	// DisconnectProbability is the chance that a message drops the connection instead.
+	DisconnectProbability float64 `json:"disconnect_probability"`
+	// DelayProbability is the chance that a message is held for up to MaxDelay.
+	DelayProbability float64 `json:"delay_probability"`
+	MaxDelay         string  `json:"max_delay"`
+	// ReorderProbability is the chance that a message is delivered after the next one.
+	ReorderProbability float64 `json:"reorder_probability"`
+	// DropProbability is the chance that a message is lost.
+	DropProbability float64 `json:"drop_probability"`
+	// DuplicateProbability is the chance that a message is delivered twice.
+	DuplicateProbability float64 `json:"duplicate_probability"`
+}
+
+// chaos injects the configured faults and counts the invariant violations found afterwards.
+type chaos struct {
+	config     *ChaosConfig
+	maxDelay   time.Duration
+	randMu     sync.Mutex
+	rand       *rand.Rand
+	faultsMu   sync.Mutex
+	faults     map[string]int64
+	violations int64
+}
+
+func newChaos(config *ChaosConfig) (*chaos, error) {
+	maxDelay, err := parseOptionalDuration(config.MaxDelay)
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldn't parse Binance chaos max delay")
+	}
+
+	return &chaos{
+		config:   config,
+		maxDelay: maxDelay,
+		rand:     rand.New(rand.NewSource(time.Now().UnixNano())),
+		faults:   make(map[string]int64),
+	}, nil
+}
+
+func (c *chaos) roll(probability float64) bool {
+	if probability <= 0 {
+		return false
+	}
+
+	c.randMu.Lock()
+	defer c.randMu.Unlock()
+
+	return c.rand.Float64() < probability
+}
+
+func (c *chaos) delay() time.Duration {
+	c.randMu.Lock()
+	defer c.randMu.Unlock()
+
+	return time.Duration(c.rand.Int63n(int64(c.maxDelay) + 1))
+}
+
+func (c *chaos) count(fault string) {
+	c.faultsMu.Lock()
+	c.faults[fault]++
+	c.faultsMu.Unlock()
+}
+
+// wrap returns a handler of a single connection which applies the faults before
+// passing the messages on. disconnect closes the connection.
+func (c *chaos) wrap(handler func(message []byte), disconnect func()) func(message []byte) {
+	var held []byte
+
+	return func(message []byte) {
+		if c.roll(c.config.DisconnectProbability) {
+			c.count("disconnect")
+			disconnect()
+			return
+		}
+
+		if c.roll(c.config.DropProbability) {
+			c.count("drop")
+			return
+		}
+
+		if c.maxDelay > 0 && c.roll(c.config.DelayProbability) {
+			c.count("delay")
+			time.Sleep(c.delay())
+		}
+
+		if held == nil && c.roll(c.config.ReorderProbability) {
+			c.count("reorder")
+			held = message
+			return
+		}
+
+		handler(message)
+
+		if c.roll(c.config.DuplicateProbability) {
+			c.count("duplicate")
+			handler(message)
+		}
+
+		if held != nil {
+			message, held = held, nil
+			handler(message)
+		}
+	}
+}
+
+// checkOrderBook verifies that the order book is not crossed, which would mean
+// that an update was lost and the book was not resynced.
+func (c *chaos) checkOrderBook(orderBook *models.OrderBookInternal) bool {
+	bbo := orderBook.BestBidOffer()
+	if bbo.BidPrice > 0 && bbo.AskPrice > 0 && bbo.BidPrice >= bbo.AskPrice {
+		atomic.AddInt64(&c.violations, 1)
+		return false
+	}
+	return true
+}
+
+// stats returns the number of injected faults by kind and of invariant violations.
+func (c *chaos) stats() (map[string]int64, int64) {
+	c.faultsMu.Lock()
+	defer c.faultsMu.Unlock()
+
+	faults := make(map[string]int64, len(c.faults))
+	for k, v := range c.faults {
+		faults[k] = v
+	}
+	return faults, atomic.LoadInt64(&c.violations)
+}
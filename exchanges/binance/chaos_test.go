@@ -0,0 +1,131 @@
+package binance
+
+import (
+	"math/rand"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/adshao/go-binance"
+	"price-feed/models"
+)
+
+func TestChaosDepthStream(t *testing.T) {
+	const (
+		rounds           = 10
+		eventsPerRound   = 50
+		firstUpdateID    = 101
+		snapshotPrice    = "1000"
+		snapshotQuantity = "1"
+	)
+
+	c, err := newChaos(&ChaosConfig{
+		ReorderProbability:   0.05,
+		DropProbability:      0.05,
+		DuplicateProbability: 0.1,
+	})
+	if err != nil {
+		t.Fatalf("could not create chaos: %v", err)
+	}
+	c.rand = rand.New(rand.NewSource(1))
+
+	w, snapshots := newTestDepthWorker(t)
+	s := w.depthSync(testSymbol)
+	defer s.stop()
+
+	// The book of the exchange, every event sets the bid of one of 20 levels.
+	bids := make(map[string]string)
+	asks := map[string]string{snapshotPrice: snapshotQuantity}
+	events := make(map[int64]*binance.WsDepthEvent)
+	lastUpdateID := int64(firstUpdateID - 1)
+
+	// snapshot returns the book of the exchange as of the last event.
+	snapshot := func() models.OrderBookResponse {
+		result := models.OrderBookResponse{LastUpdateID: lastUpdateID, Asks: [][2]string{{snapshotPrice, snapshotQuantity}}}
+		for price, quantity := range bids {
+			result.Bids = append(result.Bids, [2]string{price, quantity})
+		}
+		return result
+	}
+	// repair serves the snapshot if the book is resyncing and waits for it to be synced.
+	repair := func() {
+		s.mu.Lock()
+		synced := s.synced
+		s.mu.Unlock()
+		if synced {
+			return
+		}
+
+		select {
+		case snapshots <- snapshot():
+		case <-time.After(5 * time.Second):
+			t.Fatalf("snapshot %v was never requested", lastUpdateID)
+		}
+		waitSynced(t, s)
+	}
+	next := func() *binance.WsDepthEvent {
+		lastUpdateID++
+		bid := binance.Bid{Price: strconv.FormatInt(lastUpdateID%20+1, 10), Quantity: strconv.FormatInt(lastUpdateID, 10)}
+		bids[bid.Price] = bid.Quantity
+
+		e := &binance.WsDepthEvent{Symbol: testSymbol, FirstUpdateID: lastUpdateID, UpdateID: lastUpdateID, Bids: []binance.Bid{bid}}
+		events[e.UpdateID] = e
+		return e
+	}
+
+	handler := c.wrap(func(message []byte) {
+		updateID, err := strconv.ParseInt(string(message), 10, 64)
+		if err != nil {
+			t.Fatalf("message %s is invalid: %v", message, err)
+		}
+		w.handleDepthEvent(testSymbol, events[updateID])
+	}, func() {})
+
+	s.mu.Lock()
+	w.resyncOrderBook(testSymbol, s)
+	s.mu.Unlock()
+	repair()
+
+	// The gaps left by the faults are repaired between the rounds.
+	for i := 0; i < rounds; i++ {
+		for j := 0; j < eventsPerRound; j++ {
+			handler([]byte(strconv.FormatInt(next().UpdateID, 10)))
+		}
+		repair()
+	}
+
+	// An event delivered as is reveals a drop of the last event of the stream.
+	w.handleDepthEvent(testSymbol, next())
+	repair()
+
+	faults, _ := c.stats()
+	for _, fault := range []string{"drop", "duplicate", "reorder"} {
+		if faults[fault] == 0 {
+			t.Errorf("no %v was injected", fault)
+		}
+	}
+	if w.Resyncs() == 0 {
+		t.Errorf("the gaps were never detected")
+	}
+
+	s.mu.Lock()
+	synced, last := s.synced, s.lastUpdateID
+	s.mu.Unlock()
+	if !synced {
+		t.Fatalf("order book isn't synced")
+	}
+	if last != lastUpdateID {
+		t.Errorf("last update = %v, want %v", last, lastUpdateID)
+	}
+
+	w.orderBookCacheMu.Lock()
+	orderBook := w.orderBookCache[testSymbol]
+	w.orderBookCacheMu.Unlock()
+	if !reflect.DeepEqual(orderBook.Bids, bids) {
+		t.Errorf("bids = %v, want %v", orderBook.Bids, bids)
+	}
+	if !reflect.DeepEqual(orderBook.Asks, asks) {
+		t.Errorf("asks = %v, want %v", orderBook.Asks, asks)
+	}
+}
@@ -0,0 +1,211 @@
+package binance
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	combinedBaseURL             = "wss://stream.binance.com:9443/stream"
+	defaultStreamsPerConnection = 200
+	maxStreamsPerConnection     = 1024
+	// subscribeInterval batches the streams subscribed to on a live connection,
+	// since Binance drops connections sending more than 5 messages per second.
+	subscribeInterval = 500 * time.Millisecond
+)
+
+// combinedMessage is a message of a combined stream connection, either an event
+// of one of the streams or the response to a subscription request.
+type combinedMessage struct {
+	Stream string          `json:"stream"`
+	Data   json.RawMessage `json:"data"`
+	ID     int64           `json:"id"`
+	Error  *struct {
+		Code int    `json:"code"`
+		Msg  string `json:"msg"`
+	} `json:"error"`
+}
+
+type subscribeRequest struct {
+	Method string   `json:"method"`
+	Params []string `json:"params"`
+	ID     int64    `json:"id"`
+}
+
+// combinedStreams multiplexes the streams over as few combined stream connections
+// as the streams per connection allow. The streams of the priority symbols get
+// connections of their own, so a busy or reconnecting connection of the rest of
+// the symbols never delays them.
+type combinedStreams struct {
+	w             *Worker
+	perConnection int
+	mu            sync.Mutex
+	conns         []*combinedConn
+}
+
+func newCombinedStreams(w *Worker, perConnection int) *combinedStreams {
+	return &combinedStreams{
+		w:             w,
+		perConnection: perConnection,
+	}
+}
+
+// subscribe passes the messages of the stream, e.g. btcusdt@kline_1m, to the handler.
+// The stream is added to the first connection of its priority with room for it,
+// or to a new one.
+func (s *combinedStreams) subscribe(stream string, priority bool, handler func(message []byte)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	kind, n := "combined", 0
+	if priority {
+		kind = "priority"
+	}
+	for _, c := range s.conns {
+		if c.priority != priority {
+			continue
+		}
+		if c.add(stream, handler) {
+			return
+		}
+		n++
+	}
+
+	c := &combinedConn{
+		w:        s.w,
+		name:     fmt.Sprintf("%v#%d", kind, n),
+		priority: priority,
+		capacity: s.perConnection,
+		handlers: make(map[string]func(message []byte)),
+	}
+	c.add(stream, handler)
+	s.conns = append(s.conns, c)
+
+	go c.run()
+}
+
+// combinedConn is a single combined stream connection, reconnected with all its streams.
+type combinedConn struct {
+	w        *Worker
+	name     string
+	priority bool
+	capacity int
+	mu       sync.RWMutex
+	handlers map[string]func(message []byte)
+	streams  []string
+	// pending holds the streams added since the connection was dialed.
+	pending []string
+	// requestID is only used by the goroutine of the connection.
+	requestID int64
+}
+
+// add adds the stream to the connection, it returns false if the connection is full.
+func (c *combinedConn) add(stream string, handler func(message []byte)) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.handlers[stream]; ok {
+		c.handlers[stream] = handler
+		return true
+	}
+	if len(c.streams) >= c.capacity {
+		return false
+	}
+
+	c.handlers[stream] = handler
+	c.streams = append(c.streams, stream)
+	c.pending = append(c.pending, stream)
+	return true
+}
+
+// run keeps the connection open, dialing it with all the streams added so far.
+func (c *combinedConn) run() {
+	reconnect := c.w.reconnect.newReconnector(c.w.log, "combined", c.name, c.w.requestInterval)
+	for {
+		c.mu.Lock()
+		endpoint := combinedBaseURL + "?streams=" + strings.Join(c.streams, "/")
+		streams := len(c.streams)
+		c.pending = nil
+		c.mu.Unlock()
+
+		conn, doneC, stopC, err := c.w.wsServeConn(endpoint, c.dispatch)
+		if err != nil {
+			if err = reconnect.wait(err); err != nil {
+				c.w.log.Errorf("Could not connect Binance combined streams: %v", err)
+				return
+			}
+			continue
+		}
+
+		c.w.log.Infof("Connected Binance %v with %v streams", c.name, streams)
+		reconnect.connected()
+		c.subscribePending(conn, doneC, stopC)
+
+		if err = reconnect.wait(nil); err != nil {
+			c.w.log.Errorf("Could not connect Binance combined streams: %v", err)
+			return
+		}
+	}
+}
+
+// subscribePending subscribes to the streams added to the live connection
+// every subscribe interval until the connection is done.
+func (c *combinedConn) subscribePending(conn *websocket.Conn, doneC, stopC chan struct{}) {
+	ticker := time.NewTicker(subscribeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-doneC:
+			return
+		case <-ticker.C:
+			c.mu.Lock()
+			pending := c.pending
+			c.pending = nil
+			c.mu.Unlock()
+
+			if len(pending) == 0 {
+				continue
+			}
+			c.requestID++
+
+			// A failed request closes the connection, which resubscribes to every stream.
+			request := subscribeRequest{Method: "SUBSCRIBE", Params: pending, ID: c.requestID}
+			if err := conn.WriteJSON(request); err != nil {
+				c.w.log.Errorf("Could not subscribe to Binance streams %v: %v", pending, err)
+				close(stopC)
+				<-doneC
+				return
+			}
+		}
+	}
+}
+
+// dispatch passes the event to the handler of its stream.
+func (c *combinedConn) dispatch(message []byte) {
+	var m combinedMessage
+	if err := json.Unmarshal(message, &m); err != nil {
+		c.w.deadLetter(combinedBaseURL, message, err)
+		return
+	}
+
+	if m.Stream == "" {
+		if m.Error != nil {
+			c.w.log.Errorf("Binance rejected subscription request %v of %v: %v (%v)", m.ID, c.name, m.Error.Msg, m.Error.Code)
+		}
+		return
+	}
+
+	c.mu.RLock()
+	handler, ok := c.handlers[m.Stream]
+	c.mu.RUnlock()
+
+	if ok {
+		handler(m.Data)
+	}
+}
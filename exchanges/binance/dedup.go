@@ -0,0 +1,67 @@
+package binance
+
+import (
+	"sync"
+
+	"github.com/adshao/go-binance"
+)
+
+// sequence orders events of a stream: first by major, then by minor.
+type sequence struct {
+	major int64
+	minor int64
+}
+
+func (s sequence) after(other sequence) bool {
+	if s.major != other.major {
+		return s.major > other.major
+	}
+	return s.minor > other.minor
+}
+
+// sequenceFilter drops events which are not newer than the last accepted event of their stream,
+// e.g. duplicates delivered after a reconnect.
+type sequenceFilter struct {
+	mu   sync.Mutex
+	last map[string]sequence
+}
+
+func newSequenceFilter() *sequenceFilter {
+	return &sequenceFilter{
+		last: make(map[string]sequence),
+	}
+}
+
+// accept reports whether the event is new and remembers it if so.
+func (f *sequenceFilter) accept(stream string, major, minor int64) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	seq := sequence{major: major, minor: minor}
+	if last, ok := f.last[stream]; ok && !seq.after(last) {
+		return false
+	}
+
+	f.last[stream] = seq
+	return true
+}
+
+// lastMajor returns the major sequence number of the last accepted event of the stream.
+func (f *sequenceFilter) lastMajor(stream string) (int64, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	last, ok := f.last[stream]
+	return last.major, ok
+}
+
+// klineMinor returns the minor sequence number of a kline update, which changes with the last
+// trade of the kline rather than the event time, so a replayed update isn't mistaken for a new one.
+// The final update repeats the last trade of the previous one, so it is ordered after it.
+func klineMinor(kline *binance.WsKline) int64 {
+	minor := kline.LastTradeID * 2
+	if kline.IsFinal {
+		minor++
+	}
+	return minor
+}
@@ -0,0 +1,61 @@
+package binance
+
+import (
+	"testing"
+
+	"github.com/adshao/go-binance"
+)
+
+func TestSequenceFilterKlines(t *testing.T) {
+	kline := func(startTime, lastTradeID int64, final bool) binance.WsKline {
+		return binance.WsKline{StartTime: startTime, LastTradeID: lastTradeID, IsFinal: final}
+	}
+
+	tests := []struct {
+		name   string
+		klines []binance.WsKline
+		want   []bool
+	}{
+		{
+			name:   "updates of a kline",
+			klines: []binance.WsKline{kline(60000, 10, false), kline(60000, 12, false), kline(60000, 12, true)},
+			want:   []bool{true, true, true},
+		},
+		{
+			name:   "replayed update",
+			klines: []binance.WsKline{kline(60000, 10, false), kline(60000, 12, false), kline(60000, 10, false)},
+			want:   []bool{true, true, false},
+		},
+		{
+			name:   "duplicated final update",
+			klines: []binance.WsKline{kline(60000, 12, true), kline(60000, 12, true)},
+			want:   []bool{true, false},
+		},
+		{
+			name:   "update after the final one",
+			klines: []binance.WsKline{kline(60000, 12, true), kline(60000, 12, false)},
+			want:   []bool{true, false},
+		},
+		{
+			name:   "kline without trades",
+			klines: []binance.WsKline{kline(60000, 12, true), kline(120000, -1, false), kline(120000, -1, true)},
+			want:   []bool{true, true, true},
+		},
+		{
+			name:   "update of the previous kline",
+			klines: []binance.WsKline{kline(120000, 13, false), kline(60000, 12, true)},
+			want:   []bool{true, false},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newSequenceFilter()
+			for i, v := range tt.klines {
+				if got := f.accept("btcusdt@kline_1m", v.StartTime, klineMinor(&v)); got != tt.want[i] {
+					t.Errorf("update %v accepted = %v, want %v", i, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,185 @@
+package binance
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/adshao/go-binance"
+	"price-feed/models"
+)
+
+// maxDepthBuffer caps the events buffered while a snapshot is fetched, the oldest are dropped.
+const maxDepthBuffer = 10000
+
+// depthSync keeps the order book of a symbol in sync with its diff depth stream following
+// https://binance-docs.github.io/apidocs/spot/en/#how-to-manage-a-local-order-book-correctly:
+// the events are buffered while a snapshot is fetched and an event is applied only if it
+// continues the book, U <= lastUpdateId+1 <= u. A gap in the updates resyncs the book.
+type depthSync struct {
+	mu sync.Mutex
+	// synced is false while a snapshot is fetched, the events are buffered meanwhile.
+	synced bool
+	// lastUpdateID is u of the last applied event or lastUpdateId of the snapshot.
+	lastUpdateID int64
+	buffer       []*binance.WsDepthEvent
+	// generation changes on every resync, so a stale snapshot fetch gives up.
+	generation int64
+}
+
+// depthSync returns the sync state of the order book of the symbol.
+func (w *Worker) depthSync(symbol string) *depthSync {
+	w.depthSyncsMu.Lock()
+	defer w.depthSyncsMu.Unlock()
+
+	s, ok := w.depthSyncs[symbol]
+	if !ok {
+		s = &depthSync{}
+		w.depthSyncs[symbol] = s
+	}
+	return s
+}
+
+// resyncOrderBook starts buffering the events of the symbol and fetches a snapshot to apply
+// them to. It must be called with the mutex of the sync state held.
+func (w *Worker) resyncOrderBook(symbol string, s *depthSync) {
+	s.synced = false
+	s.generation++
+
+	go w.syncOrderBook(symbol, s, s.generation)
+}
+
+// stop drops the buffered events and stops the snapshot fetch, e.g. when the stream is done.
+func (s *depthSync) stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.synced = false
+	s.buffer = nil
+	s.generation++
+}
+
+// syncOrderBook fetches snapshots until one is recent enough for the buffered events.
+func (w *Worker) syncOrderBook(symbol string, s *depthSync, generation int64) {
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			time.Sleep(apiInterval)
+		}
+
+		s.mu.Lock()
+		stale := s.generation != generation
+		s.mu.Unlock()
+		if stale {
+			return
+		}
+
+		// Get a depth snapshot from https://www.binance.com/api/v1/depth?symbol=BNBBTC&limit=1000
+		snapshot, err := w.getOrderBook(symbol, w.snapshotDepth(symbol))
+		if err != nil {
+			w.log.Errorf("Could not get order book snapshot of symbol %v: %v", symbol, err)
+			continue
+		}
+
+		s.mu.Lock()
+		ok := s.generation != generation || w.applySnapshot(symbol, s, snapshot)
+		s.mu.Unlock()
+		if ok {
+			return
+		}
+	}
+}
+
+// applySnapshot replaces the order book with the snapshot and applies the buffered events
+// after it. It returns false if the snapshot is behind the events, so it can't be applied.
+// It must be called with the mutex of the sync state held.
+func (w *Worker) applySnapshot(symbol string, s *depthSync, snapshot models.OrderBookInternal) bool {
+	// Drop any event where u is <= lastUpdateId in the snapshot
+	events := s.buffer
+	for len(events) > 0 && events[0].UpdateID <= snapshot.LastUpdateID {
+		events = events[1:]
+	}
+
+	// The first event must straddle the snapshot, U <= lastUpdateId+1 <= u.
+	if len(events) > 0 && events[0].FirstUpdateID > snapshot.LastUpdateID+1 {
+		w.log.Warnf("Binance order book snapshot %v of symbol %v is behind update %v, refetching",
+			snapshot.LastUpdateID, symbol, events[0].FirstUpdateID)
+		return false
+	}
+
+	w.applySnapshotBook(symbol, s, snapshot)
+	s.synced = true
+	s.buffer = nil
+
+	for i, event := range events {
+		// A duplicate or reordered event was already applied.
+		if event.UpdateID <= s.lastUpdateID {
+			continue
+		}
+		// The buffer dropped some events, a newer snapshot is needed.
+		if event.FirstUpdateID > s.lastUpdateID+1 {
+			s.synced = false
+			s.buffer = events[i:]
+			return false
+		}
+		w.applyDepthEvent(symbol, s, event)
+	}
+	return true
+}
+
+func (w *Worker) applySnapshotBook(symbol string, s *depthSync, snapshot models.OrderBookInternal) {
+	if err := w.replaceOrderBook(symbol, snapshot); err != nil {
+		w.log.Errorf("Could not replace order book of symbol %v: %v", symbol, err)
+	}
+	s.lastUpdateID = snapshot.LastUpdateID
+}
+
+func (w *Worker) applyDepthEvent(symbol string, s *depthSync, event *binance.WsDepthEvent) {
+	if err := w.updateOrderBook(symbol, event); err != nil {
+		w.log.Errorf("Could not update order book: %v", err)
+	}
+	s.lastUpdateID = event.UpdateID
+}
+
+// handleDepthEvent applies the event to the order book of the symbol, buffers it while
+// a snapshot is fetched, or starts a resync if the event doesn't continue the book.
+func (w *Worker) handleDepthEvent(symbol string, event *binance.WsDepthEvent) {
+	s := w.depthSync(symbol)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.synced {
+		switch {
+		case event.UpdateID <= s.lastUpdateID:
+			return
+		case event.FirstUpdateID <= s.lastUpdateID+1:
+			w.applyDepthEvent(symbol, s, event)
+			return
+		}
+
+		atomic.AddInt64(&w.resyncs, 1)
+		w.log.Warnf("Gap in Binance order book updates of symbol %v, expected update %v, got %v, resyncing",
+			symbol, s.lastUpdateID+1, event.FirstUpdateID)
+		w.resyncOrderBook(symbol, s)
+	}
+
+	if len(s.buffer) >= maxDepthBuffer {
+		s.buffer = s.buffer[1:]
+	}
+	s.buffer = append(s.buffer, event)
+}
+
+// refreshSnapshot replaces a synced order book with the snapshot unless the snapshot is
+// behind the applied events. It returns false if the snapshot wasn't applied.
+func (w *Worker) refreshSnapshot(symbol string, snapshot models.OrderBookInternal) bool {
+	s := w.depthSync(symbol)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// The snapshot must cover every applied event, the REST snapshots may lag the stream.
+	if !s.synced || snapshot.LastUpdateID < s.lastUpdateID {
+		return false
+	}
+
+	w.applySnapshotBook(symbol, s, snapshot)
+	return true
+}
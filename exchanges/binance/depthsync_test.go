@@ -0,0 +1,232 @@
+package binance
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/adshao/go-binance"
+	"price-feed/hub"
+	"price-feed/logger"
+	"price-feed/models"
+)
+
+const testSymbol = "BTCUSDT"
+
+// depthStep is a step of a scripted depth stream, either an event received from the
+// stream or a snapshot served to the next snapshot request.
+type depthStep struct {
+	event    *binance.WsDepthEvent
+	snapshot *models.OrderBookResponse
+	// applied reports whether the snapshot syncs the book, otherwise it is refetched.
+	applied bool
+}
+
+func event(first, last int64, bids, asks [][2]string) depthStep {
+	e := &binance.WsDepthEvent{Symbol: testSymbol, FirstUpdateID: first, UpdateID: last}
+	for _, v := range bids {
+		e.Bids = append(e.Bids, binance.Bid{Price: v[0], Quantity: v[1]})
+	}
+	for _, v := range asks {
+		e.Asks = append(e.Asks, binance.Ask{Price: v[0], Quantity: v[1]})
+	}
+	return depthStep{event: e}
+}
+
+func snapshot(lastUpdateID int64, applied bool, bids, asks [][2]string) depthStep {
+	return depthStep{
+		snapshot: &models.OrderBookResponse{LastUpdateID: lastUpdateID, Bids: bids, Asks: asks},
+		applied:  applied,
+	}
+}
+
+// nopStore drops the order books, the other methods of the store aren't used by the sync.
+type nopStore struct {
+	CandleStore
+}
+
+func (nopStore) StoreOrderBookInternal(symbol string, orderBook models.OrderBookInternal) error {
+	return nil
+}
+
+func TestHandleDepthEvent(t *testing.T) {
+	tests := []struct {
+		name    string
+		steps   []depthStep
+		resyncs int64
+		last    int64
+		bids    map[string]string
+		asks    map[string]string
+	}{
+		{
+			name: "buffered events are applied after the snapshot",
+			steps: []depthStep{
+				event(95, 99, [][2]string{{"99", "5"}}, nil),
+				event(100, 102, [][2]string{{"100", "2"}}, nil),
+				event(103, 104, nil, [][2]string{{"101", "0.00000000"}, {"102", "4"}}),
+				snapshot(101, true, [][2]string{{"100", "1"}}, [][2]string{{"101", "1"}}),
+			},
+			last: 104,
+			bids: map[string]string{"100": "2"},
+			asks: map[string]string{"102": "4"},
+		},
+		{
+			name: "duplicates are skipped",
+			steps: []depthStep{
+				event(101, 102, [][2]string{{"100", "2"}}, nil),
+				event(103, 104, [][2]string{{"100", "3"}}, nil),
+				event(101, 102, [][2]string{{"100", "2"}}, nil),
+				snapshot(100, true, [][2]string{{"100", "1"}}, [][2]string{{"101", "1"}}),
+				event(103, 104, [][2]string{{"100", "3"}}, nil),
+				event(101, 102, [][2]string{{"100", "2"}}, nil),
+				event(105, 106, nil, [][2]string{{"101", "2"}}),
+			},
+			last: 106,
+			bids: map[string]string{"100": "3"},
+			asks: map[string]string{"101": "2"},
+		},
+		{
+			name: "a gap resyncs the book",
+			steps: []depthStep{
+				snapshot(100, true, [][2]string{{"100", "1"}}, [][2]string{{"101", "1"}}),
+				event(101, 102, [][2]string{{"99", "1"}}, nil),
+				event(105, 106, [][2]string{{"98", "1"}}, nil),
+				event(107, 108, nil, [][2]string{{"102", "1"}}),
+				snapshot(106, true, [][2]string{{"100", "2"}}, [][2]string{{"101", "2"}}),
+			},
+			resyncs: 1,
+			last:    108,
+			bids:    map[string]string{"100": "2"},
+			asks:    map[string]string{"101": "2", "102": "1"},
+		},
+		{
+			name: "reordered events resync the book",
+			steps: []depthStep{
+				snapshot(104, true, [][2]string{{"100", "1"}}, [][2]string{{"101", "1"}}),
+				event(107, 108, [][2]string{{"100", "3"}}, nil),
+				event(105, 106, [][2]string{{"100", "2"}}, nil),
+				snapshot(106, true, [][2]string{{"100", "2"}}, [][2]string{{"101", "1"}}),
+			},
+			resyncs: 1,
+			last:    108,
+			bids:    map[string]string{"100": "3"},
+			asks:    map[string]string{"101": "1"},
+		},
+		{
+			name: "a snapshot behind the events is refetched",
+			steps: []depthStep{
+				event(110, 112, [][2]string{{"100", "2"}}, nil),
+				snapshot(105, false, [][2]string{{"100", "1"}}, [][2]string{{"101", "1"}}),
+				snapshot(111, true, [][2]string{{"100", "1"}}, [][2]string{{"101", "1"}}),
+			},
+			last: 112,
+			bids: map[string]string{"100": "2"},
+			asks: map[string]string{"101": "1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, snapshots := newTestDepthWorker(t)
+			s := w.depthSync(testSymbol)
+			defer s.stop()
+
+			// The stream connects, the events are buffered until the snapshot is fetched.
+			s.mu.Lock()
+			w.resyncOrderBook(testSymbol, s)
+			s.mu.Unlock()
+
+			for _, step := range tt.steps {
+				if step.event != nil {
+					w.handleDepthEvent(testSymbol, step.event)
+					continue
+				}
+
+				select {
+				case snapshots <- *step.snapshot:
+				case <-time.After(5 * time.Second):
+					t.Fatalf("snapshot %v was never requested", step.snapshot.LastUpdateID)
+				}
+				if step.applied {
+					waitSynced(t, s)
+				}
+			}
+
+			s.mu.Lock()
+			synced, last := s.synced, s.lastUpdateID
+			s.mu.Unlock()
+			if !synced {
+				t.Fatalf("order book isn't synced")
+			}
+			if last != tt.last {
+				t.Errorf("last update = %v, want %v", last, tt.last)
+			}
+			if resyncs := w.Resyncs(); resyncs != tt.resyncs {
+				t.Errorf("resyncs = %v, want %v", resyncs, tt.resyncs)
+			}
+
+			w.orderBookCacheMu.Lock()
+			orderBook := w.orderBookCache[testSymbol]
+			w.orderBookCacheMu.Unlock()
+			if !reflect.DeepEqual(orderBook.Bids, tt.bids) {
+				t.Errorf("bids = %v, want %v", orderBook.Bids, tt.bids)
+			}
+			if !reflect.DeepEqual(orderBook.Asks, tt.asks) {
+				t.Errorf("asks = %v, want %v", orderBook.Asks, tt.asks)
+			}
+		})
+	}
+}
+
+// newTestDepthWorker returns a worker fetching its snapshots from a fake server, which
+// answers every snapshot request with the next snapshot sent to the channel.
+func newTestDepthWorker(t *testing.T) (*Worker, chan<- models.OrderBookResponse) {
+	snapshots := make(chan models.OrderBookResponse)
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		select {
+		case v := <-snapshots:
+			json.NewEncoder(rw).Encode(v)
+		case <-done:
+			rw.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	t.Cleanup(func() {
+		close(done)
+		server.Close()
+	})
+
+	previous := depthURL
+	depthURL = server.URL + "/api/v1/depth"
+	t.Cleanup(func() { depthURL = previous })
+
+	log := logger.New(&logger.Config{Level: "error"})
+	return &Worker{
+		config:         &Config{},
+		log:            log,
+		database:       nopStore{},
+		hub:            hub.New(nil, log),
+		depthSyncs:     make(map[string]*depthSync),
+		orderBookCache: make(map[string]models.OrderBookInternal),
+		lastPersisted:  make(map[string]time.Time),
+	}, snapshots
+}
+
+func waitSynced(t *testing.T, s *depthSync) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		s.mu.Lock()
+		synced := s.synced
+		s.mu.Unlock()
+		if synced {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("order book never synced")
+}
@@ -0,0 +1,141 @@
+package binance
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"price-feed/models"
+	"price-feed/status"
+)
+
+const (
+	defaultMaxBookDrift = 0.2
+	// driftLevels is the number of top levels per side compared to the snapshot.
+	driftLevels = 20
+	// driftSnapshotDepth is the cheapest snapshot depth covering driftLevels.
+	driftSnapshotDepth = 50
+)
+
+// bookDrifts holds the last measured drift of every order book and the number of corrections.
+type bookDrifts struct {
+	mu          sync.Mutex
+	drifts      map[string]float64
+	corrections int64
+}
+
+func newBookDrifts() *bookDrifts {
+	return &bookDrifts{drifts: make(map[string]float64)}
+}
+
+// BookDrift returns the last measured drift of every order book from the REST snapshots
+// and the number of order books replaced because of it. ok is false if the validation is disabled.
+func (w *Worker) BookDrift() (drifts map[string]float64, corrections int64, ok bool) {
+	if w.bookValidationInterval == 0 {
+		return nil, 0, false
+	}
+
+	w.drifts.mu.Lock()
+	defer w.drifts.mu.Unlock()
+
+	drifts = make(map[string]float64, len(w.drifts.drifts))
+	for k, v := range w.drifts.drifts {
+		drifts[k] = v
+	}
+	return drifts, atomic.LoadInt64(&w.drifts.corrections), true
+}
+
+// validateOrderBooks periodically compares every maintained order book to a fresh snapshot.
+func (w *Worker) validateOrderBooks() {
+	for range time.Tick(w.bookValidationInterval) {
+		for _, symbol := range w.subscriptions.List() {
+			// Partial depth books are replaced by every message, they can't drift.
+			if _, ok := w.config.PartialDepth[symbol]; ok {
+				continue
+			}
+			w.validateOrderBook(symbol)
+		}
+	}
+}
+
+// validateOrderBook measures the drift of the order book from a snapshot and replaces the book
+// with a full snapshot if the drift exceeds MaxBookDrift, so silent desyncs are repaired.
+func (w *Worker) validateOrderBook(symbol string) {
+	snapshot, err := w.getOrderBook(symbol, driftSnapshotDepth)
+	if err != nil {
+		w.log.Errorf("Could not get order book snapshot of symbol %v to validate: %v", symbol, err)
+		return
+	}
+
+	w.orderBookCacheMu.Lock()
+	orderBook, ok := w.orderBookCache[symbol]
+	var drift float64
+	if ok {
+		drift = orderBookDrift(orderBook, snapshot, driftLevels)
+	}
+	w.orderBookCacheMu.Unlock()
+	if !ok {
+		return
+	}
+
+	w.drifts.mu.Lock()
+	w.drifts.drifts[symbol] = drift
+	w.drifts.mu.Unlock()
+
+	if drift <= w.maxBookDrift {
+		return
+	}
+
+	atomic.AddInt64(&w.drifts.corrections, 1)
+	w.log.Warnf("Binance order book of symbol %v drifted from the snapshot by %.3f, replacing it", symbol, drift)
+
+	if orderBook, err = w.getOrderBook(symbol, w.snapshotDepth(symbol)); err != nil {
+		w.log.Errorf("Could not correct order book for symbol %v: %v", symbol, err)
+		return
+	}
+
+	// A snapshot behind the updates can't correct the book, a resync replaces it instead.
+	if !w.refreshSnapshot(symbol, orderBook) {
+		s := w.depthSync(symbol)
+		s.mu.Lock()
+		if s.synced {
+			w.resyncOrderBook(symbol, s)
+		}
+		s.mu.Unlock()
+	}
+}
+
+// orderBookDrift compares the top levels of both books: it is the sum of the size differences
+// over the union of their levels divided by the sum of the larger sizes, 0 for equal books
+// and 1 for books without common levels.
+func orderBookDrift(orderBook, snapshot models.OrderBookInternal, levels int) float64 {
+	local, remote := orderBook.Format(levels, 0), snapshot.Format(levels, 0)
+
+	var diff, total float64
+	for _, sides := range [][2][]models.AskBid{{local.Bids, remote.Bids}, {local.Asks, remote.Asks}} {
+		sizes := make(map[float64][2]float64)
+		for i, side := range sides {
+			for _, v := range side {
+				size := sizes[v.Price]
+				size[i] = v.Size
+				sizes[v.Price] = size
+			}
+		}
+
+		for _, v := range sizes {
+			diff += math.Abs(v[0] - v[1])
+			total += math.Max(v[0], v[1])
+		}
+	}
+
+	if total == 0 {
+		return 0
+	}
+	return diff / total
+}
+
+// Checksums is not supported by Binance, the order books are validated by BookDrift instead.
+func (w *Worker) Checksums() (checksums map[string]status.Checksum, ok bool) {
+	return nil, false
+}
@@ -0,0 +1,182 @@
+package binance
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"price-feed/logger"
+)
+
+const (
+	defaultReconnectMinBackoff = time.Second
+	defaultReconnectMaxBackoff = time.Minute
+)
+
+// Reconnect outcomes, counted per stream kind.
+const (
+	reconnectConnected = "connected"
+	reconnectFailed    = "failed"
+	reconnectAbandoned = "abandoned"
+)
+
+// reconnectPolicy spaces out the reconnects of the streams with an exponential
+// backoff with jitter, so an exchange outage doesn't get the IP banned.
+type reconnectPolicy struct {
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	// maxRetries is the number of consecutive failed reconnects after which
+	// a stream is abandoned, zero retries forever.
+	maxRetries int
+	randMu     sync.Mutex
+	rand       *rand.Rand
+	outcomesMu sync.Mutex
+	// outcomes counts the reconnect outcomes by stream kind.
+	outcomes map[string]map[string]int64
+}
+
+func newReconnectPolicy(config *Config) (*reconnectPolicy, error) {
+	minBackoff, err := parseOptionalDuration(config.ReconnectMinBackoff)
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldn't parse Binance reconnect min backoff")
+	}
+	if minBackoff <= 0 {
+		minBackoff = defaultReconnectMinBackoff
+	}
+
+	maxBackoff, err := parseOptionalDuration(config.ReconnectMaxBackoff)
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldn't parse Binance reconnect max backoff")
+	}
+	if maxBackoff <= 0 {
+		maxBackoff = defaultReconnectMaxBackoff
+	}
+	if maxBackoff < minBackoff {
+		return nil, fmt.Errorf("Binance reconnect max backoff %v is less than min backoff %v", maxBackoff, minBackoff)
+	}
+
+	if config.ReconnectMaxRetries < 0 {
+		return nil, fmt.Errorf("invalid Binance reconnect max retries %v", config.ReconnectMaxRetries)
+	}
+
+	return &reconnectPolicy{
+		minBackoff: minBackoff,
+		maxBackoff: maxBackoff,
+		maxRetries: config.ReconnectMaxRetries,
+		rand:       rand.New(rand.NewSource(time.Now().UnixNano())),
+		outcomes:   make(map[string]map[string]int64),
+	}, nil
+}
+
+func (p *reconnectPolicy) count(kind, outcome string) {
+	p.outcomesMu.Lock()
+	if p.outcomes[kind] == nil {
+		p.outcomes[kind] = make(map[string]int64)
+	}
+	p.outcomes[kind][outcome]++
+	p.outcomesMu.Unlock()
+}
+
+// jitter returns a random delay between the half and the whole backoff,
+// so the streams dropped by the same outage don't reconnect at once.
+func (p *reconnectPolicy) jitter(backoff time.Duration) time.Duration {
+	p.randMu.Lock()
+	defer p.randMu.Unlock()
+
+	return backoff/2 + time.Duration(p.rand.Int63n(int64(backoff/2)+1))
+}
+
+// reconnector tracks the consecutive failed connections of a single stream.
+type reconnector struct {
+	policy      *reconnectPolicy
+	log         *logger.Logger
+	kind        string
+	stream      string
+	minBackoff  time.Duration
+	backoff     time.Duration
+	retries     int
+	connectedAt time.Time
+}
+
+// newReconnector returns a reconnector of the stream, kind groups the metrics, e.g. depth.
+// The backoff starts from the min backoff of the policy or the request interval
+// of the symbol, whichever is longer.
+func (p *reconnectPolicy) newReconnector(log *logger.Logger, kind, stream string, requestInterval time.Duration) *reconnector {
+	minBackoff := p.minBackoff
+	if requestInterval > minBackoff {
+		minBackoff = requestInterval
+	}
+	if minBackoff > p.maxBackoff {
+		minBackoff = p.maxBackoff
+	}
+
+	return &reconnector{
+		policy:     p,
+		log:        log,
+		kind:       kind,
+		stream:     stream,
+		minBackoff: minBackoff,
+		backoff:    minBackoff,
+	}
+}
+
+// connected records an established connection.
+func (r *reconnector) connected() {
+	r.policy.count(r.kind, reconnectConnected)
+	r.connectedAt = time.Now()
+}
+
+// wait sleeps before the next connection attempt after the stream failed to
+// connect, or dropped if the cause is nil. A connection which stayed up for the
+// max backoff resets the backoff. It returns an error if the stream ran out of retries.
+func (r *reconnector) wait(cause error) error {
+	if !r.connectedAt.IsZero() && time.Since(r.connectedAt) >= r.policy.maxBackoff {
+		r.backoff = r.minBackoff
+		r.retries = 0
+	}
+	r.connectedAt = time.Time{}
+
+	if cause != nil {
+		r.policy.count(r.kind, reconnectFailed)
+	}
+
+	r.retries++
+	if r.policy.maxRetries > 0 && r.retries > r.policy.maxRetries {
+		r.policy.count(r.kind, reconnectAbandoned)
+		if cause == nil {
+			return fmt.Errorf("stream %v dropped after %v retries", r.stream, r.policy.maxRetries)
+		}
+		return errors.Wrapf(cause, "stream %v failed after %v retries", r.stream, r.policy.maxRetries)
+	}
+
+	delay := r.policy.jitter(r.backoff)
+	if cause != nil {
+		r.log.Warnf("Could not connect Binance stream %v, retrying in %v: %v", r.stream, delay, cause)
+	} else {
+		r.log.Warnf("Binance stream %v is done, reconnecting in %v", r.stream, delay)
+	}
+	time.Sleep(delay)
+
+	if r.backoff *= 2; r.backoff > r.policy.maxBackoff {
+		r.backoff = r.policy.maxBackoff
+	}
+	return nil
+}
+
+// Reconnects returns the number of the stream reconnects by stream kind,
+// e.g. depth, and outcome: connected, failed or abandoned.
+func (w *Worker) Reconnects() (outcomes map[string]map[string]int64, ok bool) {
+	w.reconnect.outcomesMu.Lock()
+	defer w.reconnect.outcomesMu.Unlock()
+
+	outcomes = make(map[string]map[string]int64, len(w.reconnect.outcomes))
+	for kind, counts := range w.reconnect.outcomes {
+		outcomes[kind] = make(map[string]int64, len(counts))
+		for outcome, v := range counts {
+			outcomes[kind][outcome] = v
+		}
+	}
+	return outcomes, true
+}
@@ -0,0 +1,18 @@
+package binance
+
+import (
+	"price-feed/exchanges/common"
+	"price-feed/models"
+	"price-feed/pipeline"
+)
+
+// CandleStore persists the data received by the worker.
+type CandleStore interface {
+	common.Store
+	pipeline.Store
+	StoreCandlesticks(exchange, symbol, interval string, candles []models.Candle) error
+	LoadLastCandlestick(exchange, symbol, interval string) (models.Candle, bool, error)
+	StoreOrderBookInternal(symbol string, orderBook models.OrderBookInternal) error
+	LoadOrderBookSnapshot(symbol string) (models.OrderBookInternal, bool, error)
+	StoreDeadLetter(deadLetter models.DeadLetter) error
+}
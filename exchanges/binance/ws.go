@@ -0,0 +1,279 @@
+package binance
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adshao/go-binance"
+	"github.com/gorilla/websocket"
+	"price-feed/models"
+)
+
+const (
+	wsBaseURL = "wss://stream.binance.com:9443/ws"
+)
+
+// wsServe dials the endpoint and passes every received message to the handler in order.
+// The connection is pinged every ping interval and closed if neither a pong nor a message
+// arrives within the pong timeout, so half-open connections are detected quickly.
+func (w *Worker) wsServe(endpoint string, handler func(message []byte)) (doneC, stopC chan struct{}, err error) {
+	_, doneC, stopC, err = w.wsServeConn(endpoint, handler)
+	return doneC, stopC, err
+}
+
+// wsServeConn is wsServe which also returns the connection, so the caller can send
+// messages, e.g. subscription requests, from a single goroutine.
+func (w *Worker) wsServeConn(endpoint string, handler func(message []byte)) (c *websocket.Conn, doneC, stopC chan struct{}, err error) {
+	dialer := websocket.Dialer{
+		HandshakeTimeout: w.handshakeTimeout,
+	}
+
+	c, _, err = dialer.Dial(endpoint, nil)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	doneC = make(chan struct{})
+	stopC = make(chan struct{})
+
+	extendDeadline := func() error {
+		if w.pingInterval == 0 {
+			return nil
+		}
+		return c.SetReadDeadline(time.Now().Add(w.pingInterval + w.pongTimeout))
+	}
+
+	if err = extendDeadline(); err != nil {
+		c.Close()
+		return nil, nil, nil, err
+	}
+	c.SetPongHandler(func(string) error {
+		return extendDeadline()
+	})
+
+	var closeOnce sync.Once
+	closeConn := func() {
+		closeOnce.Do(func() {
+			if err := c.Close(); err != nil {
+				w.makeErrorHandler()(err)
+			}
+		})
+	}
+
+	if w.chaos != nil {
+		handler = w.chaos.wrap(handler, closeConn)
+	}
+
+	go func() {
+		defer close(doneC)
+		defer closeConn()
+
+		for {
+			_, message, err := c.ReadMessage()
+			if err != nil {
+				select {
+				case <-stopC:
+				default:
+					w.makeErrorHandler()(err)
+				}
+				return
+			}
+
+			if err = extendDeadline(); err != nil {
+				w.makeErrorHandler()(err)
+				return
+			}
+
+			handler(message)
+		}
+	}()
+
+	go func() {
+		var pingC <-chan time.Time
+		if w.pingInterval > 0 {
+			ticker := time.NewTicker(w.pingInterval)
+			defer ticker.Stop()
+			pingC = ticker.C
+		}
+
+		for {
+			select {
+			case <-doneC:
+				return
+			case <-stopC:
+				closeConn()
+				return
+			case <-pingC:
+				if err := c.WriteControl(websocket.PingMessage, nil, time.Now().Add(w.pongTimeout)); err != nil {
+					w.makeErrorHandler()(err)
+					closeConn()
+					return
+				}
+			}
+		}
+	}()
+
+	return c, doneC, stopC, nil
+}
+
+// wsDepthEvent mirrors binance.WsDepthEvent with price levels in their wire format.
+type wsDepthEvent struct {
+	Event         string      `json:"e"`
+	Time          int64       `json:"E"`
+	Symbol        string      `json:"s"`
+	UpdateID      int64       `json:"u"`
+	FirstUpdateID int64       `json:"U"`
+	Bids          [][2]string `json:"b"`
+	Asks          [][2]string `json:"a"`
+}
+
+// wsPartialDepthEvent mirrors binance.WsPartialDepthEvent with price levels in their wire format.
+type wsPartialDepthEvent struct {
+	LastUpdateID int64       `json:"lastUpdateId"`
+	Bids         [][2]string `json:"bids"`
+	Asks         [][2]string `json:"asks"`
+}
+
+func (w *Worker) wsDepthServe(symbol string, handler binance.WsDepthHandler) (doneC, stopC chan struct{}, err error) {
+	endpoint := fmt.Sprintf("%s/%s@depth", wsBaseURL, strings.ToLower(symbol))
+	return w.wsServe(endpoint, func(message []byte) {
+		var raw wsDepthEvent
+		if err := json.Unmarshal(message, &raw); err != nil {
+			w.deadLetter(endpoint, message, err)
+			return
+		}
+
+		handler(&binance.WsDepthEvent{
+			Event:         raw.Event,
+			Time:          raw.Time,
+			Symbol:        raw.Symbol,
+			UpdateID:      raw.UpdateID,
+			FirstUpdateID: raw.FirstUpdateID,
+			Bids:          toBids(raw.Bids),
+			Asks:          toAsks(raw.Asks),
+		})
+	})
+}
+
+func (w *Worker) wsPartialDepthServe(symbol string, levels int, handler binance.WsPartialDepthHandler) (doneC, stopC chan struct{}, err error) {
+	endpoint := fmt.Sprintf("%s/%s@depth%d", wsBaseURL, strings.ToLower(symbol), levels)
+	return w.wsServe(endpoint, func(message []byte) {
+		var raw wsPartialDepthEvent
+		if err := json.Unmarshal(message, &raw); err != nil {
+			w.deadLetter(endpoint, message, err)
+			return
+		}
+
+		handler(&binance.WsPartialDepthEvent{
+			Symbol:       symbol,
+			LastUpdateID: raw.LastUpdateID,
+			Bids:         toBids(raw.Bids),
+			Asks:         toAsks(raw.Asks),
+		})
+	})
+}
+
+func (w *Worker) wsKlineServe(symbol, interval string, handler binance.WsKlineHandler) (doneC, stopC chan struct{}, err error) {
+	endpoint := fmt.Sprintf("%s/%s@kline_%s", wsBaseURL, strings.ToLower(symbol), interval)
+	return w.wsServe(endpoint, w.klineMessageHandler(endpoint, handler))
+}
+
+// klineMessageHandler parses the messages of a kline stream for the handler.
+func (w *Worker) klineMessageHandler(endpoint string, handler binance.WsKlineHandler) func(message []byte) {
+	return func(message []byte) {
+		event := new(binance.WsKlineEvent)
+		if err := json.Unmarshal(message, event); err != nil {
+			w.deadLetter(endpoint, message, err)
+			return
+		}
+
+		if err := validateKline(&event.Kline); err != nil {
+			w.deadLetter(endpoint, message, err)
+			return
+		}
+
+		handler(event)
+	}
+}
+
+func (w *Worker) wsTradeServe(symbol string, handler binance.WsTradeHandler) (doneC, stopC chan struct{}, err error) {
+	endpoint := fmt.Sprintf("%s/%s@trade", wsBaseURL, strings.ToLower(symbol))
+	return w.wsServe(endpoint, w.tradeMessageHandler(endpoint, handler))
+}
+
+// tradeMessageHandler parses the messages of a trade stream for the handler.
+func (w *Worker) tradeMessageHandler(endpoint string, handler binance.WsTradeHandler) func(message []byte) {
+	return func(message []byte) {
+		event := new(binance.WsTradeEvent)
+		if err := json.Unmarshal(message, event); err != nil {
+			w.deadLetter(endpoint, message, err)
+			return
+		}
+
+		handler(event)
+	}
+}
+
+func (w *Worker) wsAggTradeServe(symbol string, handler binance.WsAggTradeHandler) (doneC, stopC chan struct{}, err error) {
+	endpoint := fmt.Sprintf("%s/%s@aggTrade", wsBaseURL, strings.ToLower(symbol))
+	return w.wsServe(endpoint, w.aggTradeMessageHandler(endpoint, handler))
+}
+
+// aggTradeMessageHandler parses the messages of an aggregated trade stream for the handler.
+func (w *Worker) aggTradeMessageHandler(endpoint string, handler binance.WsAggTradeHandler) func(message []byte) {
+	return func(message []byte) {
+		event := new(binance.WsAggTradeEvent)
+		if err := json.Unmarshal(message, event); err != nil {
+			w.deadLetter(endpoint, message, err)
+			return
+		}
+
+		handler(event)
+	}
+}
+
+// deadLetter stores a raw message which could not be parsed.
+func (w *Worker) deadLetter(stream string, message []byte, err error) {
+	w.log.Errorf("Could not parse message from %v: %v", stream, err)
+
+	deadLetter := models.DeadLetter{
+		Exchange: "binance",
+		Stream:   stream,
+		Error:    err.Error(),
+		Payload:  string(message),
+		Time:     time.Now().Unix(),
+	}
+
+	if err = w.database.StoreDeadLetter(deadLetter); err != nil {
+		w.log.Errorf("Could not store dead letter: %v", err)
+	}
+}
+
+func validateKline(k *binance.WsKline) error {
+	for _, v := range []string{k.Open, k.Close, k.High, k.Low, k.Volume} {
+		if _, err := strconv.ParseFloat(v, 64); err != nil {
+			return fmt.Errorf("invalid kline value: %v", err)
+		}
+	}
+	return nil
+}
+
+func toBids(levels [][2]string) []binance.Bid {
+	bids := make([]binance.Bid, 0, len(levels))
+	for _, v := range levels {
+		bids = append(bids, binance.Bid{Price: v[0], Quantity: v[1]})
+	}
+	return bids
+}
+
+func toAsks(levels [][2]string) []binance.Ask {
+	asks := make([]binance.Ask, 0, len(levels))
+	for _, v := range levels {
+		asks = append(asks, binance.Ask{Price: v[0], Quantity: v[1]})
+	}
+	return asks
+}
@@ -0,0 +1,264 @@
+package bitfinex
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"price-feed/exchanges/common"
+	"price-feed/hub"
+	"price-feed/logger"
+	"price-feed/models"
+	"price-feed/pipeline"
+	"price-feed/status"
+	"price-feed/warmup"
+)
+
+const (
+	pairsURL         = "https://api-pub.bitfinex.com/v2/conf/pub:list:pair:exchange"
+	currenciesURL    = "https://api-pub.bitfinex.com/v2/conf/pub:map:currency:sym"
+	candlesURL       = "https://api-pub.bitfinex.com/v2/candles/trade:%s:%s/hist?limit=%d"
+	candlestickLimit = 1000
+)
+
+// candleTimeframes maps the Bitfinex candle timeframes to the Binance intervals.
+var candleTimeframes = map[string]string{
+	"1m":  "1m",
+	"5m":  "5m",
+	"15m": "15m",
+	"30m": "30m",
+	"1h":  "1h",
+	"6h":  "6h",
+	"12h": "12h",
+	"1D":  "1d",
+	"1W":  "1w",
+	"1M":  "1M",
+}
+
+// candleTimeframeList lists the timeframes of candleTimeframes from the shortest interval.
+var candleTimeframeList = []string{"1m", "5m", "15m", "30m", "1h", "6h", "12h", "1D", "1W", "1M"}
+
+// Config represents a Bitfinex worker configuration.
+type Config struct {
+	// WsTimeout is the WS handshake timeout.
+	WsTimeout       string   `json:"ws_timeout"`
+	RequestInterval string   `json:"request_interval"`
+	Blacklist       []string `json:"blacklist"`
+	// PingInterval and PongTimeout override the WS keepalive of the exchange, see common.Keepalive.
+	PingInterval string `json:"ping_interval"`
+	PongTimeout  string `json:"pong_timeout"`
+	// WarmupConcurrency limits the candle backfill requests running at once, 4 by default.
+	WarmupConcurrency int `json:"warmup_concurrency"`
+	// SymbolMap overrides the canonical symbols derived from the pairs.
+	SymbolMap map[string]string `json:"symbol_map"`
+	// AssetAliases renames assets to their Binance names. They are applied after
+	// the Bitfinex currency codes are translated, e.g. UST to USDT.
+	AssetAliases map[string]string `json:"asset_aliases"`
+	// BookLength is the number of orders per side of the raw books, 100 by default.
+	BookLength int `json:"book_length"`
+}
+
+// Worker maintains the raw order books and the candles of the Bitfinex trading pairs.
+type Worker struct {
+	config           *Config
+	log              *logger.Logger
+	database         common.CandleStore
+	hub              *hub.Hub
+	handshakeTimeout time.Duration
+	keepalive        common.Keepalive
+	requestInterval  time.Duration
+	subscriptions    *common.Subscriptions
+	pairs            map[string]bool
+	currencies       map[string]string
+	orderBookCacheMu sync.Mutex
+	orderBookCache   map[string]models.OrderBookInternal
+	quit             chan os.Signal
+	streams          *status.Tracker
+	warmup           *warmup.Scheduler
+	clock            *status.Clock
+	symbolStatuses   *status.SymbolStatuses
+	checksums        *status.Checksums
+	pipeline         *pipeline.Pipeline
+	resyncs          int64
+}
+
+// NewWorker returns a new Bitfinex worker.
+func NewWorker(config *Config, log *logger.Logger, database common.CandleStore, hub *hub.Hub, quit chan os.Signal) (*Worker, error) {
+	wsTimeout, err := time.ParseDuration(config.WsTimeout)
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldn't parse Bitfinex WS timeout")
+	}
+
+	keepalive, err := common.ParseKeepalive(config.PingInterval, config.PongTimeout, wsKeepalive)
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldn't parse Bitfinex WS keepalive")
+	}
+
+	interval, err := time.ParseDuration(config.RequestInterval)
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldn't parse Bitfinex request interval")
+	}
+
+	w := &Worker{
+		config:           config,
+		log:              log,
+		database:         database,
+		hub:              hub,
+		handshakeTimeout: wsTimeout,
+		keepalive:        keepalive,
+		requestInterval:  interval,
+		orderBookCache:   make(map[string]models.OrderBookInternal),
+		quit:             quit,
+		streams:          status.NewTracker(),
+		warmup:           warmup.New("Bitfinex", config.WarmupConcurrency, log),
+		clock:            status.NewClock(time.Second),
+		symbolStatuses:   status.NewSymbolStatuses(),
+		checksums:        status.NewChecksums(),
+	}
+	w.subscriptions = common.NewSubscriptions("bitfinex", "Bitfinex", models.ExcludeSymbols(models.BitfinexSymbols, config.Blacklist), database, log, w.mapSymbol)
+	w.pipeline = pipeline.New(log, pipeline.Validate(), pipeline.Publish(hub), pipeline.Persist(database, false))
+
+	if err = w.subscriptions.Restore(config.Blacklist); err != nil {
+		return nil, errors.Wrapf(err, "couldn't restore Bitfinex subscriptions")
+	}
+
+	w.fillSymbolMap()
+
+	return w, nil
+}
+
+// Streams returns the last event status of every stream.
+func (w *Worker) Streams() []status.Stream {
+	return w.streams.Streams()
+}
+
+// fetchStatuses returns the trading statuses of the listed Bitfinex pairs.
+func (w *Worker) fetchStatuses() (map[string]string, error) {
+	pairs, err := fetchPairs()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make(map[string]string, len(pairs))
+	for symbol := range pairs {
+		statuses[symbol] = models.SymbolStatusTrading
+	}
+	return statuses, nil
+}
+
+// SymbolStatuses returns the trading status of every symbol in the Binance format.
+func (w *Worker) SymbolStatuses() map[string]string {
+	return w.symbolStatuses.All()
+}
+
+// ClockSkew returns the last measured skew of the Bitfinex clock.
+func (w *Worker) ClockSkew() time.Duration {
+	return w.clock.Skew()
+}
+
+// Symbols returns the symbols the worker is subscribed to in the Binance format.
+func (w *Worker) Symbols() []string {
+	return w.subscriptions.Symbols()
+}
+
+// AddSymbol subscribes to the symbol at runtime and persists the subscription,
+// so it is restored on startup.
+func (w *Worker) AddSymbol(symbol string) error {
+	return w.subscriptions.Subscribe(symbol, w.subscribe)
+}
+
+// Start starts a new Bitfinex worker.
+func (w *Worker) Start() {
+	go w.subscriptions.WatchStatuses(w.symbolStatuses, w.fetchStatuses)
+	go common.WatchClock("Bitfinex", w.clock, serverTime, w.log)
+
+	for _, symbol := range w.subscriptions.List() {
+		w.subscribe(symbol)
+	}
+}
+
+// subscribe starts the order book and candlestick streams of the symbol.
+func (w *Worker) subscribe(symbol string) {
+	go func() {
+		w.initCandlesticksAll(symbol)
+		w.SubscribeAll(symbol)
+	}()
+}
+
+func (w *Worker) Reload() {
+	for _, symbol := range w.subscriptions.List() {
+		for _, v := range candleTimeframeList {
+			symbol, timeframe := symbol, v
+			w.warmup.Submit(func() {
+				w.initCandlesticks(symbol, timeframe)
+			})
+		}
+	}
+	w.log.Infof("Bitfinex cache reloaded")
+}
+
+// initCandlesticksAll backfills the candles of all intervals of the symbol one by one.
+func (w *Worker) initCandlesticksAll(symbol string) {
+	for _, timeframe := range candleTimeframeList {
+		timeframe := timeframe
+		w.warmup.Run(func() {
+			w.initCandlesticks(symbol, timeframe)
+		})
+	}
+}
+
+func (w *Worker) initCandlesticks(symbol, timeframe string) {
+	var candles [][]float64
+	if err := common.GetJSON(fmt.Sprintf(candlesURL, timeframe, symbol, candlestickLimit), &candles); err != nil {
+		w.log.Errorf("Could not load candlesticks from Bitfinex REST API with interval %v and symbol %v: %v",
+			timeframe, symbol, err)
+
+		return
+	}
+
+	parsed := make([]models.Candle, 0, len(candles))
+	for _, v := range candles {
+		candle, err := parseCandle(v, candleTimeframes[timeframe], models.CandleSourceREST)
+		if err != nil {
+			w.log.Errorf("Could not parse Bitfinex candle of symbol %v: %v", symbol, err)
+			continue
+		}
+
+		parsed = append(parsed, *candle)
+	}
+	w.storeCandlesticks(symbol, candleTimeframes[timeframe], parsed)
+}
+
+// storeCandlesticks stores the backfilled candles in batches.
+func (w *Worker) storeCandlesticks(symbol, interval string, candles []models.Candle) {
+	if err := w.database.StoreCandlesticks("bitfinex", w.subscriptions.Canonical(symbol), interval, candles); err != nil {
+		w.log.Errorf("Could not store candlesticks to database: %v", err)
+	}
+}
+
+// parseCandle converts a Bitfinex candle, [MTS, OPEN, CLOSE, HIGH, LOW, VOLUME].
+func parseCandle(v []float64, interval, source string) (*models.Candle, error) {
+	if len(v) < 6 {
+		return nil, fmt.Errorf("candle has %v fields", len(v))
+	}
+
+	timeStart := int64(v[0]) / 1000
+	return &models.Candle{
+		TimeStart: timeStart,
+		TimeEnd:   timeStart + int64(models.IntervalDuration(interval).Seconds()) - 1,
+		Time:      time.Now().Unix(),
+		Open:      v[1],
+		Close:     v[2],
+		High:      v[3],
+		Low:       v[4],
+		Volume:    v[5],
+		Source:    source,
+	}, nil
+}
+
+// serverTime returns the time of the Bitfinex server, which has no time endpoint.
+func serverTime() (time.Time, error) {
+	return status.ServerDate(pairsURL)
+}
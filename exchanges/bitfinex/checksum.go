@@ -0,0 +1,94 @@
+package bitfinex
+
+import (
+	"hash/crc32"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"price-feed/status"
+)
+
+const (
+	// checksumLevels is the number of the best orders of each side covered by the checksum.
+	checksumLevels = 25
+	// flagChecksum makes Bitfinex send a checksum message after every book update.
+	flagChecksum = 131072
+)
+
+var errChecksumMismatch = errors.New("order book checksum mismatch")
+
+// wsConf enables the connection flags, e.g. the book checksums.
+type wsConf struct {
+	Event string `json:"event"`
+	Flags int    `json:"flags"`
+}
+
+// checksum returns the CRC32 of the best orders of the raw book interleaved as
+// bid id:bid amount:ask id:ask amount, the asks with negative amounts. The orders
+// of a price level are in the order of their IDs.
+func checksum(orders map[int64]rawOrder) int32 {
+	type order struct {
+		id int64
+		rawOrder
+	}
+
+	var bids, asks []order
+	for id, v := range orders {
+		if v.amount > 0 {
+			bids = append(bids, order{id: id, rawOrder: v})
+		} else {
+			asks = append(asks, order{id: id, rawOrder: v})
+		}
+	}
+
+	sort.Slice(bids, func(i, j int) bool {
+		if bids[i].price != bids[j].price {
+			return bids[i].price > bids[j].price
+		}
+		return bids[i].id < bids[j].id
+	})
+	sort.Slice(asks, func(i, j int) bool {
+		if asks[i].price != asks[j].price {
+			return asks[i].price < asks[j].price
+		}
+		return asks[i].id < asks[j].id
+	})
+
+	parts := make([]string, 0, 4*checksumLevels)
+	for i := 0; i < checksumLevels; i++ {
+		if i < len(bids) {
+			parts = append(parts, strconv.FormatInt(bids[i].id, 10), formatNumber(bids[i].amount))
+		}
+		if i < len(asks) {
+			parts = append(parts, strconv.FormatInt(asks[i].id, 10), formatNumber(asks[i].amount))
+		}
+	}
+	return int32(crc32.ChecksumIEEE([]byte(strings.Join(parts, ":"))))
+}
+
+// formatNumber formats the number like JavaScript does, which Bitfinex hashes:
+// in the exponent notation, e.g. 1e-7, if it is smaller than 1e-6.
+func formatNumber(v float64) string {
+	if v == 0 || math.Abs(v) >= 1e-6 {
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+
+	s := strconv.FormatFloat(v, 'e', -1, 64)
+	i := strings.Index(s, "e-")
+	return s[:i+2] + strings.TrimLeft(s[i+2:], "0")
+}
+
+// verifyChecksum compares the checksum of the raw book of the symbol to the received one.
+func (w *Worker) verifyChecksum(symbol string, orders map[int64]rawOrder, expected int32) bool {
+	valid := checksum(orders) == expected
+	w.checksums.Record(w.subscriptions.Canonical(symbol), valid)
+	return valid
+}
+
+// Checksums returns the order book checksum verifications by symbol.
+func (w *Worker) Checksums() (checksums map[string]status.Checksum, ok bool) {
+	return w.checksums.All(), true
+}
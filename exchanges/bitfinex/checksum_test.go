@@ -0,0 +1,69 @@
+package bitfinex
+
+import "testing"
+
+func TestChecksum(t *testing.T) {
+	// deep has more orders than the checksum covers, the orders beyond the best 25 are left out.
+	deep := make(map[int64]rawOrder)
+	for i := int64(0); i < 30; i++ {
+		deep[i+1] = rawOrder{price: float64(1000 - i), amount: 1}
+		deep[i+100] = rawOrder{price: float64(2000 + i), amount: -1}
+	}
+
+	tests := []struct {
+		name   string
+		orders map[int64]rawOrder
+		want   int32
+	}{
+		{
+			// Hashed as 1:0.5:4:-2:2:1:5:-0.25:3:1e-7, the orders of a price by ID
+			// and the tiny amount in the exponent notation.
+			name: "raw book",
+			orders: map[int64]rawOrder{
+				1: {price: 100, amount: 0.5},
+				2: {price: 100, amount: 1},
+				3: {price: 99, amount: 0.0000001},
+				4: {price: 101, amount: -2},
+				5: {price: 102, amount: -0.25},
+			},
+			want: -2139816455,
+		},
+		{
+			name:   "more orders than covered",
+			orders: deep,
+			want:   -2126681303,
+		},
+		{
+			name: "empty book",
+			want: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := checksum(tt.orders); got != tt.want {
+				t.Errorf("checksum = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatNumber(t *testing.T) {
+	tests := []struct {
+		v    float64
+		want string
+	}{
+		{v: 0, want: "0"},
+		{v: 1.5, want: "1.5"},
+		{v: -0.25, want: "-0.25"},
+		{v: 0.000001, want: "0.000001"},
+		{v: 0.0000001, want: "1e-7"},
+		{v: -0.00000012, want: "-1.2e-7"},
+	}
+
+	for _, tt := range tests {
+		if got := formatNumber(tt.v); got != tt.want {
+			t.Errorf("formatNumber(%v) = %v, want %v", tt.v, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package bitfinex
+
+import (
+	"strconv"
+	"sync/atomic"
+
+	"price-feed/models"
+)
+
+// rawOrder represents an order of a raw book. A positive amount is a bid, a negative one an ask.
+type rawOrder struct {
+	price  float64
+	amount float64
+}
+
+// GetOrderBook returns the order book of the symbol in the Binance format.
+func (w *Worker) GetOrderBook(symbol string) (models.OrderBookInternal, bool) {
+	w.orderBookCacheMu.Lock()
+	defer w.orderBookCacheMu.Unlock()
+
+	ob, ok := w.orderBookCache[symbol]
+	return ob, ok
+}
+
+// Resyncs returns the number of reconnects requested by the exchange or caused by checksum
+// mismatches, which start with new snapshots.
+func (w *Worker) Resyncs() int64 {
+	return atomic.LoadInt64(&w.resyncs)
+}
+
+// ChaosStats is not supported by Bitfinex.
+func (w *Worker) ChaosStats() (faults map[string]int64, violations int64, ok bool) {
+	return nil, 0, false
+}
+
+// BookDrift is not supported by Bitfinex.
+func (w *Worker) BookDrift() (drifts map[string]float64, corrections int64, ok bool) {
+	return nil, 0, false
+}
+
+// Reconnects is not supported by Bitfinex.
+func (w *Worker) Reconnects() (outcomes map[string]map[string]int64, ok bool) {
+	return nil, false
+}
+
+// updateOrderBook applies the raw book entries, [ORDER_ID, PRICE, AMOUNT], to the orders
+// and rebuilds the price levels of the symbol. A zero price removes the order.
+func (w *Worker) updateOrderBook(symbol string, orders map[int64]rawOrder, entries [][]float64) {
+	for _, v := range entries {
+		if len(v) < 3 {
+			continue
+		}
+
+		id := int64(v[0])
+		if v[1] == 0 {
+			delete(orders, id)
+			continue
+		}
+		orders[id] = rawOrder{price: v[1], amount: v[2]}
+	}
+
+	bids := make(map[float64]float64)
+	asks := make(map[float64]float64)
+	for _, v := range orders {
+		if v.amount > 0 {
+			bids[v.price] += v.amount
+		} else {
+			asks[v.price] -= v.amount
+		}
+	}
+
+	orderBook := models.OrderBookInternal{
+		Bids: make(map[string]string, len(bids)),
+		Asks: make(map[string]string, len(asks)),
+	}
+	for price, amount := range bids {
+		orderBook.Bids[strconv.FormatFloat(price, 'f', -1, 64)] = strconv.FormatFloat(amount, 'f', -1, 64)
+	}
+	for price, amount := range asks {
+		orderBook.Asks[strconv.FormatFloat(price, 'f', -1, 64)] = strconv.FormatFloat(amount, 'f', -1, 64)
+	}
+
+	canonical := w.subscriptions.Canonical(symbol)
+
+	w.orderBookCacheMu.Lock()
+	defer w.orderBookCacheMu.Unlock()
+
+	w.orderBookCache[canonical] = orderBook
+	w.publishBBO(canonical)
+}
+
+// publishBBO pushes the best bid and offer of the symbol to the hub subscribers.
+// It must be called with orderBookCacheMu held.
+func (w *Worker) publishBBO(symbol string) {
+	channel := "bbo." + symbol
+	if !w.hub.HasSubscribers(channel) {
+		return
+	}
+
+	orderBook := w.orderBookCache[symbol]
+	bbo := orderBook.BestBidOffer()
+	bbo.Exchange = "bitfinex"
+	bbo.Symbol = symbol
+	w.hub.Publish(channel, bbo)
+}
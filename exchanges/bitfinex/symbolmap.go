@@ -0,0 +1,117 @@
+package bitfinex
+
+import (
+	"strings"
+
+	"price-feed/exchanges/common"
+	"price-feed/models"
+)
+
+// defaultCurrencies maps the Bitfinex currency codes which differ from the common ones.
+// They are used when the currency map can't be fetched.
+var defaultCurrencies = map[string]string{
+	"UST": "USDT",
+	"DSH": "DASH",
+	"IOT": "IOTA",
+	"QTM": "QTUM",
+	"DAT": "DATA",
+	"MNA": "MANA",
+	"YYW": "YOYOW",
+	"QSH": "QASH",
+	"ALG": "ALGO",
+}
+
+// fillSymbolMap fetches the Bitfinex pairs and currencies and maps every subscribed
+// symbol to the canonical format. Symbols which can't be mapped are dropped, so they
+// never end up in the storage keys.
+func (w *Worker) fillSymbolMap() {
+	pairs, err := fetchPairs()
+	if err != nil {
+		w.log.Warnf("Could not get Bitfinex pairs, mapping symbols by name: %v", err)
+	}
+	w.pairs = pairs
+
+	currencies, err := fetchCurrencies()
+	if err != nil {
+		w.log.Warnf("Could not get Bitfinex currencies, using the built-in map: %v", err)
+	}
+	w.currencies = currencies
+
+	w.subscriptions.MapSymbols()
+}
+
+// mapSymbol derives the canonical symbol from the Bitfinex trading pair, unless it is
+// overridden in the config. Trading pairs are prefixed with "t", funding currencies
+// with "f"; the latter have no canonical symbol.
+func (w *Worker) mapSymbol(symbol string) (string, bool) {
+	if v, ok := w.config.SymbolMap[symbol]; ok {
+		return v, true
+	}
+
+	if !strings.HasPrefix(symbol, "t") {
+		return "", false
+	}
+	if w.pairs != nil && !w.pairs[symbol] {
+		return "", false
+	}
+
+	// Pairs of 3-letter codes are concatenated, longer codes are separated by a colon,
+	// e.g. tBTCUSD and tTESTBTC:TESTUSD.
+	pair := symbol[1:]
+	var base, quote string
+	if i := strings.Index(pair, ":"); i >= 0 {
+		base, quote = pair[:i], pair[i+1:]
+	} else if len(pair) == 6 {
+		base, quote = pair[:3], pair[3:]
+	}
+	if base == "" || quote == "" {
+		return "", false
+	}
+
+	return models.CanonicalSymbol(w.currency(base), w.currency(quote), w.config.AssetAliases), true
+}
+
+// currency translates the Bitfinex currency code to the common one.
+func (w *Worker) currency(code string) string {
+	if v, ok := w.currencies[code]; ok {
+		return v
+	}
+	if v, ok := defaultCurrencies[code]; ok {
+		return v
+	}
+	return code
+}
+
+// fetchPairs returns the symbols of the pairs open for trading.
+func fetchPairs() (map[string]bool, error) {
+	var response [][]string
+	if err := common.GetJSON(pairsURL, &response); err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]bool)
+	for _, list := range response {
+		for _, v := range list {
+			result["t"+v] = true
+		}
+	}
+	return result, nil
+}
+
+// fetchCurrencies returns the map of the Bitfinex currency codes to the common ones.
+func fetchCurrencies() (map[string]string, error) {
+	var response [][][]string
+	if err := common.GetJSON(currenciesURL, &response); err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]string)
+	for _, list := range response {
+		for _, v := range list {
+			if len(v) == 2 {
+				result[v[0]] = v[1]
+			}
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,198 @@
+package bitfinex
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
+	"price-feed/exchanges/common"
+	"price-feed/models"
+	"price-feed/pipeline"
+)
+
+const (
+	wsURL             = "wss://api-pub.bitfinex.com/ws/2"
+	defaultBookLength = 100
+
+	infoReconnect          = 20051
+	infoMaintenanceStarted = 20060
+	infoMaintenanceEnded   = 20061
+)
+
+// wsKeepalive is the default keepalive. Bitfinex sends a heartbeat every 15 seconds
+// on every channel, so no pings are sent.
+var wsKeepalive = common.Keepalive{PongTimeout: time.Minute}
+
+var errResubscribe = errors.New("exchange asked to reconnect")
+
+// wsEvent represents a control message, e.g. a subscription confirmation.
+type wsEvent struct {
+	Event   string `json:"event"`
+	Channel string `json:"channel"`
+	ChanID  int64  `json:"chanId"`
+	Key     string `json:"key"`
+	Symbol  string `json:"symbol"`
+	Code    int    `json:"code"`
+	Msg     string `json:"msg"`
+}
+
+type wsSubscribe struct {
+	Event   string `json:"event"`
+	Channel string `json:"channel"`
+	Key     string `json:"key,omitempty"`
+	Symbol  string `json:"symbol,omitempty"`
+	Prec    string `json:"prec,omitempty"`
+	Len     string `json:"len,omitempty"`
+}
+
+// SubscribeAll streams the candles of all intervals and the raw order book of the symbol
+// over one connection, reconnecting when the stream is done. A reconnect starts with
+// a new snapshot, so it also resyncs the book after a checksum mismatch.
+func (w *Worker) SubscribeAll(symbol string) {
+	for ; ; <-time.Tick(w.requestInterval) {
+		err := w.serve(symbol)
+		if err == errResubscribe || err == errChecksumMismatch {
+			atomic.AddInt64(&w.resyncs, 1)
+		}
+		w.log.Warnf("Bitfinex stream of symbol %v is done: %v", symbol, err)
+	}
+}
+
+func (w *Worker) serve(symbol string) error {
+	dialer := websocket.Dialer{HandshakeTimeout: w.handshakeTimeout}
+	conn, _, err := dialer.Dial(wsURL, nil)
+	if err != nil {
+		return errors.Wrapf(err, "could not connect")
+	}
+	defer conn.Close()
+
+	bookLength := defaultBookLength
+	if w.config.BookLength > 0 {
+		bookLength = w.config.BookLength
+	}
+
+	// The checksums cover the best orders, which a shorter book doesn't have.
+	if bookLength >= checksumLevels {
+		if err = conn.WriteJSON(wsConf{Event: "conf", Flags: flagChecksum}); err != nil {
+			return errors.Wrapf(err, "could not enable checksums")
+		}
+	}
+
+	requests := []wsSubscribe{
+		{Event: "subscribe", Channel: "book", Symbol: symbol, Prec: "R0", Len: strconv.Itoa(bookLength)},
+	}
+	for _, timeframe := range candleTimeframeList {
+		requests = append(requests, wsSubscribe{Event: "subscribe", Channel: "candles", Key: "trade:" + timeframe + ":" + symbol})
+	}
+	for _, v := range requests {
+		if err = conn.WriteJSON(v); err != nil {
+			return errors.Wrapf(err, "could not subscribe")
+		}
+	}
+
+	// Channels maps the channel IDs to the candle intervals, or to "" for the book.
+	channels := make(map[int64]string)
+	orders := make(map[int64]rawOrder)
+
+	defer w.keepalive.Start(conn, nil)()
+
+	for {
+		if err = w.keepalive.ExtendDeadline(conn); err != nil {
+			return err
+		}
+
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+
+		if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+			var event wsEvent
+			if err = json.Unmarshal(data, &event); err != nil {
+				return errors.Wrapf(err, "could not unmarshal event")
+			}
+
+			switch {
+			case event.Event == "error":
+				return errors.Errorf("received error %v: %v", event.Code, event.Msg)
+			case event.Event == "info" && (event.Code == infoReconnect || event.Code == infoMaintenanceStarted ||
+				event.Code == infoMaintenanceEnded):
+				return errResubscribe
+			case event.Event == "subscribed" && event.Channel == "book":
+				channels[event.ChanID] = ""
+			case event.Event == "subscribed" && event.Channel == "candles":
+				parts := strings.Split(event.Key, ":")
+				if len(parts) >= 2 {
+					channels[event.ChanID] = candleTimeframes[parts[1]]
+				}
+			}
+			continue
+		}
+
+		var frame []json.RawMessage
+		if err = json.Unmarshal(data, &frame); err != nil || len(frame) < 2 {
+			return errors.Errorf("could not unmarshal message %s", data)
+		}
+
+		var chanID int64
+		if err = json.Unmarshal(frame[0], &chanID); err != nil {
+			return errors.Wrapf(err, "could not unmarshal channel ID")
+		}
+
+		interval, ok := channels[chanID]
+		if !ok || bytes.Equal(frame[1], []byte(`"hb"`)) {
+			continue
+		}
+
+		if bytes.Equal(frame[1], []byte(`"cs"`)) {
+			var expected int32
+			if len(frame) < 3 || json.Unmarshal(frame[2], &expected) != nil {
+				return errors.Errorf("could not unmarshal checksum %s", data)
+			}
+			if interval == "" && !w.verifyChecksum(symbol, orders, expected) {
+				return errChecksumMismatch
+			}
+			continue
+		}
+
+		// Snapshots are lists of entries, updates are single entries.
+		var entries [][]float64
+		if err = json.Unmarshal(frame[1], &entries); err != nil {
+			var entry []float64
+			if err = json.Unmarshal(frame[1], &entry); err != nil {
+				return errors.Wrapf(err, "could not unmarshal entries")
+			}
+			entries = [][]float64{entry}
+		} else if interval == "" {
+			orders = make(map[int64]rawOrder)
+		}
+
+		if interval == "" {
+			w.streams.Track(symbol+"@book", 0, time.Now().UnixNano()/int64(time.Millisecond))
+			w.updateOrderBook(symbol, orders, entries)
+			continue
+		}
+
+		for _, v := range entries {
+			candle, err := parseCandle(v, interval, models.CandleSourceWS)
+			if err != nil {
+				w.log.Errorf("Could not parse Bitfinex candle of symbol %v: %v", symbol, err)
+				continue
+			}
+
+			w.streams.Track(symbol+"@candles_"+interval, 0, int64(v[0]))
+			w.pipeline.Process(&pipeline.CandleEvent{
+				Exchange: "bitfinex",
+				Symbol:   w.subscriptions.Canonical(symbol),
+				Interval: interval,
+				Candle:   *candle,
+				Received: time.Now(),
+			})
+		}
+	}
+}
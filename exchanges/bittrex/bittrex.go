@@ -6,62 +6,175 @@ import (
 
 	"github.com/toorop/go-bittrex"
 
+	"price-feed/exchanges/common"
+	"price-feed/hub"
 	"price-feed/logger"
 	"price-feed/models"
-	"price-feed/storage"
+	"price-feed/pipeline"
+	"price-feed/status"
+	"price-feed/warmup"
+)
+
+const (
+	marketsURL = "https://api.bittrex.com/api/v1.1/public/getmarkets"
 )
 
 type Config struct {
 	RequestInterval string `json:"request_interval"`
+	// Blacklist lists symbols which are never subscribed to, in the Bittrex or the Binance format.
+	Blacklist []string `json:"blacklist"`
+	// WarmupConcurrency limits the candle backfill requests running at once, 4 by default.
+	WarmupConcurrency int `json:"warmup_concurrency"`
+	// SymbolMap overrides the canonical symbols derived from the markets.
+	SymbolMap map[string]string `json:"symbol_map"`
+	// AssetAliases renames assets to their Binance names, e.g. "USD": "USDT".
+	AssetAliases map[string]string `json:"asset_aliases"`
+	// Trades enables the polling of the trades of every symbol.
+	Trades bool `json:"trades"`
+	// Symbols holds per-symbol overrides.
+	Symbols map[string]*SymbolConfig `json:"symbols"`
+	// ClosedCandlesOnly makes the worker persist only closed candles,
+	// keeping the in-progress one under a separate key.
+	ClosedCandlesOnly bool `json:"closed_candles_only"`
+}
+
+// SymbolConfig overrides the exchange settings for a single symbol.
+type SymbolConfig struct {
+	RequestInterval string `json:"request_interval"`
 }
 
 type Worker struct {
 	config          *Config
 	log             *logger.Logger
-	database        *storage.Client
+	database        common.CandleStore
+	hub             *hub.Hub
 	requestInterval time.Duration
-	symbols         []string
+	symbolIntervals map[string]time.Duration
+	subscriptions   *common.Subscriptions
+	markets         map[string]bittrex.Market
 	bittrex         *bittrex.Bittrex
 	quit            chan os.Signal
+	streams         *status.Tracker
+	warmup          *warmup.Scheduler
+	clock           *status.Clock
+	symbolStatuses  *status.SymbolStatuses
+	pipeline        *pipeline.Pipeline
 }
 
-func NewWorker(config *Config, log *logger.Logger, database *storage.Client, quit chan os.Signal) (*Worker, error) {
+func NewWorker(config *Config, log *logger.Logger, database common.CandleStore, hub *hub.Hub, quit chan os.Signal) (*Worker, error) {
 	interval, err := time.ParseDuration(config.RequestInterval)
 	if err != nil {
 		return nil, err
 	}
 
+	symbolIntervals := make(map[string]time.Duration, len(config.Symbols))
+	for symbol, v := range config.Symbols {
+		if v.RequestInterval == "" {
+			continue
+		}
+
+		if symbolIntervals[symbol], err = time.ParseDuration(v.RequestInterval); err != nil {
+			return nil, err
+		}
+	}
+
 	w := &Worker{
 		config:          config,
 		log:             log,
 		database:        database,
+		hub:             hub,
 		requestInterval: interval,
-		symbols:         models.BittrexSymbols,
+		symbolIntervals: symbolIntervals,
 		bittrex:         bittrex.New("", ""),
 		quit:            quit,
+		streams:         status.NewTracker(),
+		warmup:          warmup.New("Bittrex", config.WarmupConcurrency, log),
+		clock:           status.NewClock(time.Second),
+		symbolStatuses:  status.NewSymbolStatuses(),
 	}
+	// The default symbols are in the format of the exchange, the blacklist may be in the Binance one.
+	blacklist := common.CanonicalBlacklist(models.BittrexSymbols, config.Blacklist, w.mapSymbol)
+	w.subscriptions = common.NewSubscriptions("bittrex", "Bittrex", models.ExcludeSymbols(models.BittrexSymbols, blacklist), database, log, w.mapSymbol)
+	w.pipeline = pipeline.New(log, pipeline.Validate(), pipeline.Publish(hub), pipeline.Persist(database, config.ClosedCandlesOnly))
+
+	if err = w.subscriptions.Restore(config.Blacklist); err != nil {
+		return nil, err
+	}
+
+	w.fillSymbolMap()
 
 	return w, nil
 }
 
+// Streams returns the last event status of every polled stream.
+func (w *Worker) Streams() []status.Stream {
+	return w.streams.Streams()
+}
+
+// SymbolStatuses returns the trading status of every symbol in the Binance format.
+func (w *Worker) SymbolStatuses() map[string]string {
+	return w.symbolStatuses.All()
+}
+
+// ClockSkew returns the last measured skew of the Bittrex clock.
+func (w *Worker) ClockSkew() time.Duration {
+	return w.clock.Skew()
+}
+
+// fetchStatuses returns the trading statuses of the listed Bittrex markets.
+func (w *Worker) fetchStatuses() (map[string]string, error) {
+	markets, err := w.bittrex.GetMarkets()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make(map[string]string, len(markets))
+	for _, v := range markets {
+		if v.IsActive {
+			statuses[v.MarketName] = models.SymbolStatusTrading
+		} else {
+			statuses[v.MarketName] = models.SymbolStatusBreak
+		}
+	}
+	return statuses, nil
+}
+
+// Symbols returns the symbols the worker is subscribed to in the Binance format.
+func (w *Worker) Symbols() []string {
+	return w.subscriptions.Symbols()
+}
+
 func (w *Worker) Start() {
-	for _, symbol := range w.symbols {
+	go w.subscriptions.WatchStatuses(w.symbolStatuses, w.fetchStatuses)
+	go common.WatchClock("Bittrex", w.clock, serverTime, w.log)
+
+	for _, symbol := range w.subscriptions.List() {
 		// go func(symbol string) {
 		// 	err := w.SubscribeOrderBook(symbol)
 		// 	if err != nil {
 		// 		w.log.Printf("Couldn't get diff depths on symbol %s: %v", symbol, err)
 		// 	}
 		// }(symbol)
-		go w.SubscribeCandlestickAll(symbol)
+		w.subscribe(symbol)
+	}
+}
+
+// subscribe starts polling the candlesticks and, if enabled, the trades of the symbol.
+func (w *Worker) subscribe(symbol string) {
+	go w.SubscribeCandlestickAll(symbol)
+
+	if w.config.Trades {
+		go w.SubscribeTrades(symbol)
 	}
 }
 
 func (w *Worker) Reload() {
-	for _, symbol := range w.symbols {
+	for _, symbol := range w.subscriptions.List() {
 		for _, v := range models.BittrexCandlestickIntervalList {
-			go func(s string) {
-				w.initCandlesticks(symbol, s)
-			}(v)
+			symbol, interval := symbol, v
+			w.warmup.Submit(func() {
+				w.initCandlesticks(symbol, interval)
+			})
 		}
 	}
 	w.log.Infof("Bittrex cache reloaded")
@@ -70,7 +183,9 @@ func (w *Worker) Reload() {
 func (w *Worker) SubscribeCandlestickAll(symbol string) {
 	for _, v := range models.BittrexCandlestickIntervalList {
 		go func(s string) {
-			w.initCandlesticks(symbol, s)
+			w.warmup.Run(func() {
+				w.initCandlesticks(symbol, s)
+			})
 
 			if err := w.SubscribeCandlestick(symbol, s); err != nil {
 				w.log.Errorf("Could not subscribe to candlestick interval %v symbol %v: %v", v, symbol, err)
@@ -88,32 +203,82 @@ func (w *Worker) initCandlesticks(symbol, interval string) {
 		return
 	}
 
-	for _, k := range candlesticks {
-		if err := w.updateCandlestickAPI(symbol, interval, &k); err != nil {
-			w.log.Errorf("Could not update candlesticks from REST API: %v", err)
-		}
+	candles := make([]models.Candle, 0, len(candlesticks))
+	for i := range candlesticks {
+		candles = append(candles, *models.CandleFromBittrexAPI(&candlesticks[i]))
 	}
+	w.storeCandlesticks(symbol, models.BittrexIntervalToBinance(interval), candles)
 }
 
-func (w *Worker) updateCandlestickAPI(symbol, interval string, candlestick *bittrex.Candle) error {
-	if err := w.database.StoreCandlestickBittrexAPI(symbol, models.BittrexIntervalToBinance(interval), candlestick); err != nil {
-		w.log.Errorf("Could not store candlestick from REST API to database: %v", err)
+// storeCandlesticks stores the backfilled candles in batches.
+func (w *Worker) storeCandlesticks(symbol, interval string, candles []models.Candle) {
+	if err := w.database.StoreCandlesticks("bittrex", w.subscriptions.Canonical(symbol), interval, candles); err != nil {
+		w.log.Errorf("Could not store candlesticks to database: %v", err)
 	}
-
-	return nil
 }
 
 func (w *Worker) SubscribeCandlestick(symbol, interval string) error {
-	for ; ; <-time.Tick(w.requestInterval) {
+	requestInterval := w.symbolRequestInterval(symbol)
+	for ; ; <-time.Tick(requestInterval) {
 		candles, err := w.bittrex.GetLatestTick(symbol, interval)
 		if err != nil {
 			w.log.Errorf("Could not get latest tick on bittrex: %v", err)
 		}
 
-		for _, candle := range candles {
-			if err := w.updateCandlestickAPI(symbol, interval, &candle); err != nil {
-				w.log.Errorf("Could not update candlesticks from REST API: %v", err)
-			}
+		now := time.Now()
+		// The candles carry their open time only, so the stream is tracked by the poll time.
+		if err == nil {
+			w.streams.Track(symbol+"@kline_"+interval, 0, now.UnixNano()/int64(time.Millisecond))
+		}
+		binanceSymbol, binanceInterval := w.subscriptions.Canonical(symbol), models.BittrexIntervalToBinance(interval)
+		for i := range candles {
+			candle := models.CandleFromBittrexAPI(&candles[i])
+			w.pipeline.Process(&pipeline.CandleEvent{
+				Exchange: "bittrex",
+				Symbol:   binanceSymbol,
+				Interval: binanceInterval,
+				Candle:   *candle,
+				Final:    models.CandleClosed(*candle, binanceInterval, now),
+				Received: now,
+			})
 		}
 	}
 }
+
+// symbolRequestInterval returns the request interval of the symbol.
+func (w *Worker) symbolRequestInterval(symbol string) time.Duration {
+	if interval, ok := w.symbolIntervals[symbol]; ok {
+		return interval
+	}
+	return w.requestInterval
+}
+
+// SubscribeTrades polls the market history of the symbol and processes the new trades.
+func (w *Worker) SubscribeTrades(symbol string) {
+	var seen common.TradeHistory
+	for ; ; <-time.Tick(w.symbolRequestInterval(symbol)) {
+		history, err := w.bittrex.GetMarketHistory(symbol)
+		if err != nil {
+			w.log.Errorf("Could not get Bittrex market history of symbol %v: %v", symbol, err)
+			continue
+		}
+
+		canonical := w.subscriptions.Canonical(symbol)
+		trades := make([]models.Trade, 0, len(history))
+		for i := range history {
+			trade := models.TradeFromBittrexAPI(canonical, &history[i])
+			trade.Time = w.clock.Adjust(trade.Time)
+			trades = append(trades, trade)
+		}
+
+		received := time.Now()
+		for _, trade := range seen.New(trades) {
+			w.pipeline.Process(&pipeline.TradeEvent{Trade: trade, Received: received})
+		}
+	}
+}
+
+// serverTime returns the time of the Bittrex server, which has no time endpoint.
+func serverTime() (time.Time, error) {
+	return status.ServerDate(marketsURL)
+}
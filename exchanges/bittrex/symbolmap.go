@@ -0,0 +1,58 @@
+package bittrex
+
+import (
+	"strings"
+
+	"github.com/toorop/go-bittrex"
+
+	"price-feed/models"
+)
+
+// fillSymbolMap fetches the Bittrex markets and maps every subscribed symbol to
+// the canonical format. Symbols which can't be mapped are dropped, so they never
+// end up in the storage keys.
+func (w *Worker) fillSymbolMap() {
+	markets, err := w.fetchMarkets()
+	if err != nil {
+		w.log.Warnf("Could not get Bittrex markets, mapping symbols by name: %v", err)
+	}
+	w.markets = markets
+
+	w.subscriptions.MapSymbols()
+}
+
+// mapSymbol derives the canonical symbol from the Bittrex market, unless it is
+// overridden in the config.
+func (w *Worker) mapSymbol(symbol string) (string, bool) {
+	if v, ok := w.config.SymbolMap[symbol]; ok {
+		return v, true
+	}
+
+	if w.markets != nil {
+		market, ok := w.markets[symbol]
+		if !ok {
+			return "", false
+		}
+		return models.CanonicalSymbol(market.MarketCurrency, market.BaseCurrency, w.config.AssetAliases), true
+	}
+
+	// Bittrex market names are QUOTE-BASE.
+	parts := strings.Split(symbol, "-")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", false
+	}
+	return models.CanonicalSymbol(parts[1], parts[0], w.config.AssetAliases), true
+}
+
+func (w *Worker) fetchMarkets() (map[string]bittrex.Market, error) {
+	markets, err := w.bittrex.GetMarkets()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]bittrex.Market, len(markets))
+	for _, v := range markets {
+		result[v.MarketName] = v
+	}
+	return result, nil
+}
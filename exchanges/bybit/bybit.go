@@ -0,0 +1,322 @@
+package bybit
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"price-feed/exchanges/common"
+	"price-feed/hub"
+	"price-feed/logger"
+	"price-feed/models"
+	"price-feed/pipeline"
+	"price-feed/status"
+	"price-feed/warmup"
+)
+
+const (
+	timeURL          = "https://api.bybit.com/v5/market/time"
+	instrumentsURL   = "https://api.bybit.com/v5/market/instruments-info?category=spot"
+	candlesURL       = "https://api.bybit.com/v5/market/kline?category=spot&symbol=%s&interval=%s&limit=%d"
+	candlestickLimit = 1000
+)
+
+// klineIntervals maps the Bybit kline intervals to the Binance ones.
+var klineIntervals = map[string]string{
+	"1":   "1m",
+	"3":   "3m",
+	"5":   "5m",
+	"15":  "15m",
+	"30":  "30m",
+	"60":  "1h",
+	"120": "2h",
+	"240": "4h",
+	"360": "6h",
+	"720": "12h",
+	"D":   "1d",
+	"W":   "1w",
+	"M":   "1M",
+}
+
+// klineIntervalList lists the kline intervals of klineIntervals from the shortest interval.
+var klineIntervalList = []string{"1", "3", "5", "15", "30", "60", "120", "240", "360", "720", "D", "W", "M"}
+
+// Config represents a Bybit worker configuration.
+type Config struct {
+	// WsTimeout is the WS handshake timeout.
+	WsTimeout       string   `json:"ws_timeout"`
+	RequestInterval string   `json:"request_interval"`
+	Blacklist       []string `json:"blacklist"`
+	// PingInterval and PongTimeout override the WS keepalive of the exchange, see common.Keepalive.
+	PingInterval string `json:"ping_interval"`
+	PongTimeout  string `json:"pong_timeout"`
+	// WarmupConcurrency limits the candle backfill requests running at once, 4 by default.
+	WarmupConcurrency int `json:"warmup_concurrency"`
+	// SymbolMap overrides the canonical symbols derived from the instruments.
+	SymbolMap map[string]string `json:"symbol_map"`
+	// AssetAliases renames assets to their Binance names.
+	AssetAliases map[string]string `json:"asset_aliases"`
+	// Depth is the number of order book levels, 1, 50 or 200; 50 by default.
+	Depth int `json:"depth"`
+}
+
+// Worker maintains the order books and the candles of the Bybit spot instruments.
+type Worker struct {
+	config           *Config
+	log              *logger.Logger
+	database         common.CandleStore
+	hub              *hub.Hub
+	handshakeTimeout time.Duration
+	keepalive        common.Keepalive
+	requestInterval  time.Duration
+	subscriptions    *common.Subscriptions
+	markets          map[string]instrument
+	orderBookCacheMu sync.Mutex
+	orderBookCache   map[string]models.OrderBookInternal
+	quit             chan os.Signal
+	streams          *status.Tracker
+	warmup           *warmup.Scheduler
+	clock            *status.Clock
+	symbolStatuses   *status.SymbolStatuses
+	pipeline         *pipeline.Pipeline
+	resyncs          int64
+}
+
+// instrument represents a spot instrument of the public API.
+type instrument struct {
+	Symbol    string `json:"symbol"`
+	BaseCoin  string `json:"baseCoin"`
+	QuoteCoin string `json:"quoteCoin"`
+	Status    string `json:"status"`
+}
+
+// NewWorker returns a new Bybit worker.
+func NewWorker(config *Config, log *logger.Logger, database common.CandleStore, hub *hub.Hub, quit chan os.Signal) (*Worker, error) {
+	wsTimeout, err := time.ParseDuration(config.WsTimeout)
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldn't parse Bybit WS timeout")
+	}
+
+	keepalive, err := common.ParseKeepalive(config.PingInterval, config.PongTimeout, wsKeepalive)
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldn't parse Bybit WS keepalive")
+	}
+
+	interval, err := time.ParseDuration(config.RequestInterval)
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldn't parse Bybit request interval")
+	}
+
+	switch config.Depth {
+	case 0:
+		config.Depth = 50
+	case 1, 50, 200:
+	default:
+		return nil, fmt.Errorf("invalid Bybit order book depth %v", config.Depth)
+	}
+
+	w := &Worker{
+		config:           config,
+		log:              log,
+		database:         database,
+		hub:              hub,
+		handshakeTimeout: wsTimeout,
+		keepalive:        keepalive,
+		requestInterval:  interval,
+		orderBookCache:   make(map[string]models.OrderBookInternal),
+		quit:             quit,
+		streams:          status.NewTracker(),
+		warmup:           warmup.New("Bybit", config.WarmupConcurrency, log),
+		clock:            status.NewClock(time.Millisecond),
+		symbolStatuses:   status.NewSymbolStatuses(),
+	}
+	w.subscriptions = common.NewSubscriptions("bybit", "Bybit", models.ExcludeSymbols(models.BybitSymbols, config.Blacklist), database, log, w.mapSymbol)
+	w.pipeline = pipeline.New(log, pipeline.Validate(), pipeline.Publish(hub), pipeline.Persist(database, false))
+
+	if err = w.subscriptions.Restore(config.Blacklist); err != nil {
+		return nil, errors.Wrapf(err, "couldn't restore Bybit subscriptions")
+	}
+
+	w.fillSymbolMap()
+
+	return w, nil
+}
+
+// Streams returns the last event status of every stream.
+func (w *Worker) Streams() []status.Stream {
+	return w.streams.Streams()
+}
+
+// fetchStatuses returns the trading statuses of the listed Bybit instruments.
+func (w *Worker) fetchStatuses() (map[string]string, error) {
+	markets, err := fetchMarkets()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make(map[string]string, len(markets))
+	for symbol, v := range markets {
+		if v.Status == "Trading" {
+			statuses[symbol] = models.SymbolStatusTrading
+		} else {
+			statuses[symbol] = models.SymbolStatusBreak
+		}
+	}
+	return statuses, nil
+}
+
+// SymbolStatuses returns the trading status of every symbol in the Binance format.
+func (w *Worker) SymbolStatuses() map[string]string {
+	return w.symbolStatuses.All()
+}
+
+// ClockSkew returns the last measured skew of the Bybit clock.
+func (w *Worker) ClockSkew() time.Duration {
+	return w.clock.Skew()
+}
+
+// Symbols returns the symbols the worker is subscribed to in the Binance format.
+func (w *Worker) Symbols() []string {
+	return w.subscriptions.Symbols()
+}
+
+// AddSymbol subscribes to the symbol at runtime and persists the subscription,
+// so it is restored on startup.
+func (w *Worker) AddSymbol(symbol string) error {
+	return w.subscriptions.Subscribe(symbol, w.subscribe)
+}
+
+// Start starts a new Bybit worker.
+func (w *Worker) Start() {
+	go w.subscriptions.WatchStatuses(w.symbolStatuses, w.fetchStatuses)
+	go common.WatchClock("Bybit", w.clock, serverTime, w.log)
+
+	for _, symbol := range w.subscriptions.List() {
+		w.subscribe(symbol)
+	}
+}
+
+// subscribe starts the order book and candlestick streams of the symbol.
+func (w *Worker) subscribe(symbol string) {
+	go w.SubscribeOrderBook(symbol)
+	go func() {
+		w.initCandlesticksAll(symbol)
+		w.SubscribeCandlestickAll(symbol)
+	}()
+}
+
+func (w *Worker) Reload() {
+	for _, symbol := range w.subscriptions.List() {
+		for _, v := range klineIntervalList {
+			symbol, klineInterval := symbol, v
+			w.warmup.Submit(func() {
+				w.initCandlesticks(symbol, klineInterval)
+			})
+		}
+	}
+	w.log.Infof("Bybit cache reloaded")
+}
+
+// initCandlesticksAll backfills the candles of all intervals of the symbol one by one.
+func (w *Worker) initCandlesticksAll(symbol string) {
+	for _, klineInterval := range klineIntervalList {
+		klineInterval := klineInterval
+		w.warmup.Run(func() {
+			w.initCandlesticks(symbol, klineInterval)
+		})
+	}
+}
+
+func (w *Worker) initCandlesticks(symbol, klineInterval string) {
+	var result struct {
+		List [][]string `json:"list"`
+	}
+
+	if err := getJSON(fmt.Sprintf(candlesURL, symbol, klineInterval, candlestickLimit), &result); err != nil {
+		w.log.Errorf("Could not load candlesticks from Bybit REST API with interval %v and symbol %v: %v",
+			klineInterval, symbol, err)
+
+		return
+	}
+
+	interval := klineIntervals[klineInterval]
+	candles := make([]models.Candle, 0, len(result.List))
+	for _, v := range result.List {
+		candle, err := parseCandle(v, interval)
+		if err != nil {
+			w.log.Errorf("Could not parse Bybit candle of symbol %v: %v", symbol, err)
+			continue
+		}
+
+		candles = append(candles, *candle)
+	}
+	w.storeCandlesticks(symbol, interval, candles)
+}
+
+// storeCandlesticks stores the backfilled candles in batches.
+func (w *Worker) storeCandlesticks(symbol, interval string, candles []models.Candle) {
+	if err := w.database.StoreCandlesticks("bybit", w.subscriptions.Canonical(symbol), interval, candles); err != nil {
+		w.log.Errorf("Could not store candlesticks to database: %v", err)
+	}
+}
+
+// parseCandle converts a REST Bybit candle, [startTime, open, high, low, close, volume, turnover].
+func parseCandle(v []string, interval string) (*models.Candle, error) {
+	if len(v) < 6 {
+		return nil, fmt.Errorf("candle has %v fields", len(v))
+	}
+
+	startTime, err := strconv.ParseInt(v[0], 10, 64)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not parse candle time %v", v[0])
+	}
+
+	candle := &models.Candle{
+		TimeStart: startTime / 1000,
+		TimeEnd:   startTime/1000 + int64(models.IntervalDuration(interval).Seconds()) - 1,
+		Time:      time.Now().Unix(),
+		Source:    models.CandleSourceREST,
+	}
+
+	for i, dst := range []*float64{&candle.Open, &candle.High, &candle.Low, &candle.Close, &candle.Volume} {
+		if *dst, err = strconv.ParseFloat(v[i+1], 64); err != nil {
+			return nil, errors.Wrapf(err, "could not parse candle of %v", startTime)
+		}
+	}
+
+	return candle, nil
+}
+
+// getJSON calls the public API and decodes the result of the response.
+func getJSON(url string, v interface{}) error {
+	var response struct {
+		RetCode int             `json:"retCode"`
+		RetMsg  string          `json:"retMsg"`
+		Result  json.RawMessage `json:"result"`
+	}
+	if err := common.GetJSON(url, &response); err != nil {
+		return err
+	}
+	if response.RetCode != 0 {
+		return fmt.Errorf("%v received error %v: %v", url, response.RetCode, response.RetMsg)
+	}
+
+	return json.Unmarshal(response.Result, v)
+}
+
+// serverTime returns the time of the Bybit server.
+func serverTime() (time.Time, error) {
+	var result struct {
+		TimeNano string `json:"timeNano"`
+	}
+
+	if err := getJSON(timeURL, &result); err != nil {
+		return time.Time{}, err
+	}
+
+	return common.ParseServerTime(result.TimeNano, time.Nanosecond)
+}
@@ -0,0 +1,92 @@
+package bybit
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"price-feed/models"
+	"price-feed/pipeline"
+)
+
+// kline represents a kline push. Times are in milliseconds.
+type kline struct {
+	Start     int64  `json:"start"`
+	End       int64  `json:"end"`
+	Open      string `json:"open"`
+	Close     string `json:"close"`
+	High      string `json:"high"`
+	Low       string `json:"low"`
+	Volume    string `json:"volume"`
+	Confirm   bool   `json:"confirm"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// SubscribeCandlestickAll streams the candles of all intervals of the symbol
+// over one connection, reconnecting when the stream is done.
+func (w *Worker) SubscribeCandlestickAll(symbol string) {
+	topics := make([]string, 0, len(klineIntervals))
+	for _, klineInterval := range klineIntervalList {
+		topics = append(topics, "kline."+klineInterval+"."+symbol)
+	}
+
+	for ; ; <-time.Tick(w.requestInterval) {
+		err := w.wsServe(topics, func(message *wsMessage) error {
+			parts := strings.Split(message.Topic, ".")
+			if len(parts) != 3 || parts[0] != "kline" {
+				return nil
+			}
+			interval, ok := klineIntervals[parts[1]]
+			if !ok {
+				return nil
+			}
+
+			var klines []kline
+			if err := json.Unmarshal(message.Data, &klines); err != nil {
+				return errors.Wrapf(err, "could not unmarshal klines")
+			}
+
+			for _, v := range klines {
+				candle, err := v.candle(interval)
+				if err != nil {
+					w.log.Errorf("Could not parse Bybit candle of symbol %v: %v", symbol, err)
+					continue
+				}
+
+				w.streams.Track(message.Topic, 0, w.clock.Adjust(v.Timestamp))
+				w.pipeline.Process(&pipeline.CandleEvent{
+					Exchange: "bybit",
+					Symbol:   w.subscriptions.Canonical(symbol),
+					Interval: interval,
+					Candle:   *candle,
+					Received: time.Now(),
+				})
+			}
+			return nil
+		})
+		w.log.Warnf("Bybit candle stream of symbol %v is done: %v", symbol, err)
+	}
+}
+
+func (k kline) candle(interval string) (*models.Candle, error) {
+	candle := &models.Candle{
+		TimeStart: k.Start / 1000,
+		TimeEnd:   k.Start/1000 + int64(models.IntervalDuration(interval).Seconds()) - 1,
+		Time:      time.Now().Unix(),
+		Source:    models.CandleSourceWS,
+	}
+
+	var err error
+	for _, v := range []struct {
+		dst   *float64
+		value string
+	}{{&candle.Open, k.Open}, {&candle.High, k.High}, {&candle.Low, k.Low}, {&candle.Close, k.Close}, {&candle.Volume, k.Volume}} {
+		if *v.dst, err = strconv.ParseFloat(v.value, 64); err != nil {
+			return nil, errors.Wrapf(err, "could not parse candle of %v", k.Start)
+		}
+	}
+
+	return candle, nil
+}
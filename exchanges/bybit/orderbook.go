@@ -0,0 +1,143 @@
+package bybit
+
+import (
+	"encoding/json"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"price-feed/models"
+	"price-feed/status"
+)
+
+var errSequenceGap = errors.New("gap in update IDs")
+
+// depth represents an order book push. The levels are [price, size].
+type depth struct {
+	Symbol   string     `json:"s"`
+	Bids     [][]string `json:"b"`
+	Asks     [][]string `json:"a"`
+	UpdateID int64      `json:"u"`
+	Seq      int64      `json:"seq"`
+}
+
+// GetOrderBook returns the order book of the symbol in the Binance format.
+func (w *Worker) GetOrderBook(symbol string) (models.OrderBookInternal, bool) {
+	w.orderBookCacheMu.Lock()
+	defer w.orderBookCacheMu.Unlock()
+
+	ob, ok := w.orderBookCache[symbol]
+	return ob, ok
+}
+
+// Resyncs returns the number of order book snapshots requested after a gap in the updates.
+func (w *Worker) Resyncs() int64 {
+	return atomic.LoadInt64(&w.resyncs)
+}
+
+// ChaosStats is not supported by Bybit.
+func (w *Worker) ChaosStats() (faults map[string]int64, violations int64, ok bool) {
+	return nil, 0, false
+}
+
+// BookDrift is not supported by Bybit.
+func (w *Worker) BookDrift() (drifts map[string]float64, corrections int64, ok bool) {
+	return nil, 0, false
+}
+
+// Reconnects is not supported by Bybit.
+func (w *Worker) Reconnects() (outcomes map[string]map[string]int64, ok bool) {
+	return nil, false
+}
+
+// Checksums is not supported by Bybit.
+func (w *Worker) Checksums() (checksums map[string]status.Checksum, ok bool) {
+	return nil, false
+}
+
+// SubscribeOrderBook maintains the order book of the symbol, reconnecting when the stream
+// is done. Every subscription starts with a snapshot, so a reconnect also resyncs the book.
+func (w *Worker) SubscribeOrderBook(symbol string) {
+	topic := "orderbook." + strconv.Itoa(w.config.Depth) + "." + symbol
+
+	for ; ; <-time.Tick(w.requestInterval) {
+		var lastUpdateID int64
+		err := w.wsServe([]string{topic}, func(message *wsMessage) error {
+			if message.Topic != topic {
+				return nil
+			}
+
+			var update depth
+			if err := json.Unmarshal(message.Data, &update); err != nil {
+				return errors.Wrapf(err, "could not unmarshal depth")
+			}
+
+			// The service restarts send a snapshot with the update ID 1.
+			snapshot := message.Type == "snapshot"
+			if !snapshot && update.UpdateID != lastUpdateID+1 {
+				return errSequenceGap
+			}
+			lastUpdateID = update.UpdateID
+
+			w.streams.Track(topic, update.UpdateID, w.clock.Adjust(message.Ts))
+			w.updateOrderBook(symbol, update, snapshot)
+			return nil
+		})
+		if err == errSequenceGap {
+			atomic.AddInt64(&w.resyncs, 1)
+		}
+		w.log.Warnf("Bybit order book stream of symbol %v is done: %v", symbol, err)
+	}
+}
+
+func (w *Worker) updateOrderBook(symbol string, update depth, snapshot bool) {
+	w.orderBookCacheMu.Lock()
+	defer w.orderBookCacheMu.Unlock()
+
+	canonical := w.subscriptions.Canonical(symbol)
+
+	orderBook, ok := w.orderBookCache[canonical]
+	if snapshot || !ok {
+		orderBook = models.OrderBookInternal{
+			Bids: make(map[string]string),
+			Asks: make(map[string]string),
+		}
+	}
+
+	for _, side := range []struct {
+		levels  map[string]string
+		updates [][]string
+	}{{orderBook.Bids, update.Bids}, {orderBook.Asks, update.Asks}} {
+		for _, v := range side.updates {
+			if len(v) < 2 {
+				continue
+			}
+
+			if size, err := strconv.ParseFloat(v[1], 64); err != nil || size == 0 {
+				delete(side.levels, v[0])
+				continue
+			}
+			side.levels[v[0]] = v[1]
+		}
+	}
+	orderBook.LastUpdateID = update.UpdateID
+
+	w.orderBookCache[canonical] = orderBook
+	w.publishBBO(canonical)
+}
+
+// publishBBO pushes the best bid and offer of the symbol to the hub subscribers.
+// It must be called with orderBookCacheMu held.
+func (w *Worker) publishBBO(symbol string) {
+	channel := "bbo." + symbol
+	if !w.hub.HasSubscribers(channel) {
+		return
+	}
+
+	orderBook := w.orderBookCache[symbol]
+	bbo := orderBook.BestBidOffer()
+	bbo.Exchange = "bybit"
+	bbo.Symbol = symbol
+	w.hub.Publish(channel, bbo)
+}
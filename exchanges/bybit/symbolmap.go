@@ -0,0 +1,57 @@
+package bybit
+
+import (
+	"price-feed/models"
+)
+
+// fillSymbolMap fetches the Bybit instruments and maps every subscribed symbol to
+// the canonical format. Symbols which can't be mapped are dropped, so they never
+// end up in the storage keys.
+func (w *Worker) fillSymbolMap() {
+	markets, err := fetchMarkets()
+	if err != nil {
+		w.log.Warnf("Could not get Bybit instruments, keeping the symbols as they are: %v", err)
+	}
+	w.markets = markets
+
+	w.subscriptions.MapSymbols()
+}
+
+// mapSymbol derives the canonical symbol from the Bybit instrument, unless it is
+// overridden in the config.
+func (w *Worker) mapSymbol(symbol string) (string, bool) {
+	if v, ok := w.config.SymbolMap[symbol]; ok {
+		return v, true
+	}
+
+	if w.markets != nil {
+		market, ok := w.markets[symbol]
+		if !ok {
+			return "", false
+		}
+		return models.CanonicalSymbol(market.BaseCoin, market.QuoteCoin, w.config.AssetAliases), true
+	}
+
+	// Bybit spot symbols are in the Binance format already, BASEQUOTE.
+	if symbol == "" {
+		return "", false
+	}
+	return symbol, true
+}
+
+// fetchMarkets returns the Bybit spot instruments by symbol.
+func fetchMarkets() (map[string]instrument, error) {
+	var result struct {
+		List []instrument `json:"list"`
+	}
+
+	if err := getJSON(instrumentsURL, &result); err != nil {
+		return nil, err
+	}
+
+	markets := make(map[string]instrument, len(result.List))
+	for _, v := range result.List {
+		markets[v.Symbol] = v
+	}
+	return markets, nil
+}
@@ -0,0 +1,88 @@
+package bybit
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
+	"price-feed/exchanges/common"
+)
+
+const (
+	wsURL = "wss://stream.bybit.com/v5/public/spot"
+	// wsMaxArgs is the number of topics a single spot subscribe request may carry.
+	wsMaxArgs = 10
+)
+
+// wsKeepalive is the default keepalive. Bybit recommends a ping every 20 seconds
+// to keep the connection alive.
+var wsKeepalive = common.Keepalive{PingInterval: 20 * time.Second, PongTimeout: 40 * time.Second}
+
+type wsRequest struct {
+	Op   string   `json:"op"`
+	Args []string `json:"args,omitempty"`
+}
+
+// wsMessage represents either an operation response, e.g. a subscription confirmation, or a data push.
+type wsMessage struct {
+	Op      string          `json:"op"`
+	Success bool            `json:"success"`
+	RetMsg  string          `json:"ret_msg"`
+	Topic   string          `json:"topic"`
+	Type    string          `json:"type"`
+	Ts      int64           `json:"ts"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// wsServe subscribes to the topics and passes the data pushes to the handler
+// until the connection fails or the handler returns an error.
+func (w *Worker) wsServe(topics []string, handler func(message *wsMessage) error) error {
+	dialer := websocket.Dialer{HandshakeTimeout: w.handshakeTimeout}
+	conn, _, err := dialer.Dial(wsURL, nil)
+	if err != nil {
+		return errors.Wrapf(err, "could not connect")
+	}
+	defer conn.Close()
+
+	for i := 0; i < len(topics); i += wsMaxArgs {
+		end := i + wsMaxArgs
+		if end > len(topics) {
+			end = len(topics)
+		}
+		if err = conn.WriteJSON(wsRequest{Op: "subscribe", Args: topics[i:end]}); err != nil {
+			return errors.Wrapf(err, "could not subscribe")
+		}
+	}
+
+	defer w.keepalive.Start(conn, func() error {
+		return conn.WriteJSON(wsRequest{Op: "ping"})
+	})()
+
+	for {
+		if err = w.keepalive.ExtendDeadline(conn); err != nil {
+			return err
+		}
+
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+
+		var message wsMessage
+		if err = json.Unmarshal(data, &message); err != nil {
+			return errors.Wrapf(err, "could not unmarshal message")
+		}
+
+		if message.Op == "subscribe" && !message.Success {
+			return errors.Errorf("could not subscribe: %v", message.RetMsg)
+		}
+		if message.Op != "" {
+			continue
+		}
+
+		if err = handler(&message); err != nil {
+			return err
+		}
+	}
+}
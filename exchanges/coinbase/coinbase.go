@@ -0,0 +1,365 @@
+package coinbase
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"price-feed/exchanges/common"
+	"price-feed/hub"
+	"price-feed/logger"
+	"price-feed/models"
+	"price-feed/pipeline"
+	"price-feed/status"
+	"price-feed/warmup"
+)
+
+const (
+	timeURL          = "https://api.coinbase.com/api/v3/brokerage/time"
+	productsURL      = "https://api.coinbase.com/api/v3/brokerage/market/products"
+	candlesURL       = "https://api.coinbase.com/api/v3/brokerage/market/products/%s/candles?start=%d&end=%d&granularity=%s"
+	wsURL            = "wss://advanced-trade-ws.coinbase.com"
+	candlestickLimit = 350
+)
+
+// Config represents a Coinbase Advanced Trade worker configuration.
+type Config struct {
+	// WsTimeout is the WS handshake timeout.
+	WsTimeout       string   `json:"ws_timeout"`
+	RequestInterval string   `json:"request_interval"`
+	Blacklist       []string `json:"blacklist"`
+	// PingInterval and PongTimeout override the WS keepalive of the exchange, see common.Keepalive.
+	PingInterval string `json:"ping_interval"`
+	PongTimeout  string `json:"pong_timeout"`
+	// WarmupConcurrency limits the candle backfill requests running at once, 4 by default.
+	WarmupConcurrency int `json:"warmup_concurrency"`
+	// SymbolMap overrides the canonical symbols derived from the products.
+	SymbolMap map[string]string `json:"symbol_map"`
+	// AssetAliases renames assets to their Binance names, e.g. "USD": "USDT".
+	AssetAliases map[string]string `json:"asset_aliases"`
+	// Symbols holds per-symbol overrides.
+	Symbols map[string]*SymbolConfig `json:"symbols"`
+	// ClosedCandlesOnly makes the worker persist only closed candles,
+	// keeping the in-progress one under a separate key.
+	ClosedCandlesOnly bool `json:"closed_candles_only"`
+}
+
+// SymbolConfig overrides the exchange settings for a single symbol.
+type SymbolConfig struct {
+	RequestInterval string `json:"request_interval"`
+}
+
+// Worker maintains the level2 order books and polls the candles of the Coinbase products.
+type Worker struct {
+	config           *Config
+	log              *logger.Logger
+	database         common.CandleStore
+	hub              *hub.Hub
+	handshakeTimeout time.Duration
+	keepalive        common.Keepalive
+	requestInterval  time.Duration
+	symbolIntervals  map[string]time.Duration
+	subscriptions    *common.Subscriptions
+	markets          map[string]product
+	orderBookCacheMu sync.Mutex
+	orderBookCache   map[string]models.OrderBookInternal
+	quit             chan os.Signal
+	streams          *status.Tracker
+	warmup           *warmup.Scheduler
+	clock            *status.Clock
+	symbolStatuses   *status.SymbolStatuses
+	resyncs          int64
+	pipeline         *pipeline.Pipeline
+}
+
+// product represents a product of the public market API.
+type product struct {
+	ProductID       string `json:"product_id"`
+	BaseCurrencyID  string `json:"base_currency_id"`
+	QuoteCurrencyID string `json:"quote_currency_id"`
+	Status          string `json:"status"`
+	TradingDisabled bool   `json:"trading_disabled"`
+}
+
+// candle represents a candle of the public market API.
+type candle struct {
+	Start  string `json:"start"`
+	Low    string `json:"low"`
+	High   string `json:"high"`
+	Open   string `json:"open"`
+	Close  string `json:"close"`
+	Volume string `json:"volume"`
+}
+
+// NewWorker returns a new Coinbase worker.
+func NewWorker(config *Config, log *logger.Logger, database common.CandleStore, hub *hub.Hub, quit chan os.Signal) (*Worker, error) {
+	wsTimeout, err := time.ParseDuration(config.WsTimeout)
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldn't parse Coinbase WS timeout")
+	}
+
+	keepalive, err := common.ParseKeepalive(config.PingInterval, config.PongTimeout, wsKeepalive)
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldn't parse Coinbase WS keepalive")
+	}
+
+	interval, err := time.ParseDuration(config.RequestInterval)
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldn't parse Coinbase request interval")
+	}
+
+	symbolIntervals := make(map[string]time.Duration, len(config.Symbols))
+	for symbol, v := range config.Symbols {
+		if v.RequestInterval == "" {
+			continue
+		}
+
+		if symbolIntervals[symbol], err = time.ParseDuration(v.RequestInterval); err != nil {
+			return nil, errors.Wrapf(err, "couldn't parse Coinbase request interval for symbol %v", symbol)
+		}
+	}
+
+	w := &Worker{
+		config:           config,
+		log:              log,
+		database:         database,
+		hub:              hub,
+		handshakeTimeout: wsTimeout,
+		keepalive:        keepalive,
+		requestInterval:  interval,
+		symbolIntervals:  symbolIntervals,
+		orderBookCache:   make(map[string]models.OrderBookInternal),
+		quit:             quit,
+		streams:          status.NewTracker(),
+		warmup:           warmup.New("Coinbase", config.WarmupConcurrency, log),
+		clock:            status.NewClock(time.Millisecond),
+		symbolStatuses:   status.NewSymbolStatuses(),
+	}
+	w.subscriptions = common.NewSubscriptions("coinbase", "Coinbase", models.ExcludeSymbols(models.CoinbaseSymbols, config.Blacklist), database, log, w.mapSymbol)
+	w.pipeline = pipeline.New(log, pipeline.Validate(), pipeline.Publish(hub), pipeline.Persist(database, config.ClosedCandlesOnly))
+
+	if err = w.subscriptions.Restore(config.Blacklist); err != nil {
+		return nil, errors.Wrapf(err, "couldn't restore Coinbase subscriptions")
+	}
+
+	w.fillSymbolMap()
+
+	return w, nil
+}
+
+// Streams returns the last event status of every stream.
+func (w *Worker) Streams() []status.Stream {
+	return w.streams.Streams()
+}
+
+// SymbolStatuses returns the trading status of every symbol in the Binance format.
+func (w *Worker) SymbolStatuses() map[string]string {
+	return w.symbolStatuses.All()
+}
+
+// ClockSkew returns the last measured skew of the Coinbase clock.
+func (w *Worker) ClockSkew() time.Duration {
+	return w.clock.Skew()
+}
+
+// fetchStatuses returns the trading statuses of the listed Coinbase products.
+func (w *Worker) fetchStatuses() (map[string]string, error) {
+	markets, err := w.fetchMarkets()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make(map[string]string, len(markets))
+	for symbol, v := range markets {
+		if v.Status == "online" && !v.TradingDisabled {
+			statuses[symbol] = models.SymbolStatusTrading
+		} else {
+			statuses[symbol] = models.SymbolStatusBreak
+		}
+	}
+	return statuses, nil
+}
+
+// Symbols returns the symbols the worker is subscribed to in the Binance format.
+func (w *Worker) Symbols() []string {
+	return w.subscriptions.Symbols()
+}
+
+// Start starts a new Coinbase worker.
+func (w *Worker) Start() {
+	go w.subscriptions.WatchStatuses(w.symbolStatuses, w.fetchStatuses)
+	go common.WatchClock("Coinbase", w.clock, serverTime, w.log)
+
+	for _, symbol := range w.subscriptions.List() {
+		w.subscribe(symbol)
+	}
+}
+
+// subscribe starts the order book stream and the candlestick polling of the symbol.
+func (w *Worker) subscribe(symbol string) {
+	go w.SubscribeOrderBook(symbol)
+	go w.SubscribeCandlestickAll(symbol)
+}
+
+func (w *Worker) Reload() {
+	for _, symbol := range w.subscriptions.List() {
+		for _, v := range models.CoinbaseCandlestickIntervalList {
+			symbol, interval := symbol, v
+			w.warmup.Submit(func() {
+				w.initCandlesticks(symbol, interval)
+			})
+		}
+	}
+	w.log.Infof("Coinbase cache reloaded")
+}
+
+func (w *Worker) SubscribeCandlestickAll(symbol string) {
+	for _, v := range models.CoinbaseCandlestickIntervalList {
+		go func(s string) {
+			w.warmup.Run(func() {
+				w.initCandlesticks(symbol, s)
+			})
+			w.SubscribeCandlestick(symbol, s)
+		}(v)
+	}
+}
+
+func (w *Worker) initCandlesticks(symbol, interval string) {
+	length := models.IntervalDuration(models.CoinbaseIntervalToBinance(interval))
+	now := time.Now()
+
+	candles, err := w.getCandles(symbol, interval, now.Add(-candlestickLimit*length), now)
+	if err != nil {
+		w.log.Errorf("Could not load candlesticks from Coinbase REST API with interval %v and symbol %v: %v",
+			interval, symbol, err)
+
+		return
+	}
+
+	backfill := make([]models.Candle, 0, len(candles))
+	for _, v := range candles {
+		backfill = append(backfill, *v)
+	}
+	w.storeCandlesticks(symbol, models.CoinbaseIntervalToBinance(interval), backfill)
+}
+
+// SubscribeCandlestick polls the most recent candles of the symbol.
+func (w *Worker) SubscribeCandlestick(symbol, interval string) {
+	requestInterval := w.symbolRequestInterval(symbol)
+	for ; ; <-time.Tick(requestInterval) {
+		candles, err := w.getCandles(symbol, interval, time.Now().Add(-3*requestInterval), time.Now())
+		if err != nil {
+			w.log.Errorf("Could not get latest candles on Coinbase: %v", err)
+			continue
+		}
+
+		now := time.Now()
+		binanceSymbol, binanceInterval := w.subscriptions.Canonical(symbol), models.CoinbaseIntervalToBinance(interval)
+		for _, v := range candles {
+			w.streams.Track(symbol+"@kline_"+interval, 0, v.TimeStart*1000)
+			w.pipeline.Process(&pipeline.CandleEvent{
+				Exchange: "coinbase",
+				Symbol:   binanceSymbol,
+				Interval: binanceInterval,
+				Candle:   *v,
+				Final:    models.CandleClosed(*v, binanceInterval, now),
+				Received: now,
+			})
+		}
+	}
+}
+
+// storeCandlesticks stores the backfilled candles in batches.
+func (w *Worker) storeCandlesticks(symbol, interval string, candles []models.Candle) {
+	if err := w.database.StoreCandlesticks("coinbase", w.subscriptions.Canonical(symbol), interval, candles); err != nil {
+		w.log.Errorf("Could not store candlesticks to database: %v", err)
+	}
+}
+
+// getCandles returns the candles of the symbol within [start; end] in time order.
+func (w *Worker) getCandles(symbol, interval string, start, end time.Time) ([]*models.Candle, error) {
+	var response struct {
+		Candles []candle `json:"candles"`
+	}
+
+	if err := getJSON(fmt.Sprintf(candlesURL, symbol, start.Unix(), end.Unix(), interval), &response); err != nil {
+		return nil, err
+	}
+
+	length := int64(models.IntervalDuration(models.CoinbaseIntervalToBinance(interval)).Seconds())
+
+	// The candles are returned newest first.
+	candles := make([]*models.Candle, 0, len(response.Candles))
+	for i := len(response.Candles) - 1; i >= 0; i-- {
+		v := response.Candles[i]
+
+		timeStart, err := strconv.ParseInt(v.Start, 10, 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not parse candle start %v", v.Start)
+		}
+
+		c := &models.Candle{
+			TimeStart: timeStart,
+			TimeEnd:   timeStart + length - 1,
+			Time:      time.Now().Unix(),
+			Source:    models.CandleSourceREST,
+		}
+
+		for _, f := range []struct {
+			dst *float64
+			src string
+		}{{&c.Open, v.Open}, {&c.Close, v.Close}, {&c.High, v.High}, {&c.Low, v.Low}, {&c.Volume, v.Volume}} {
+			if *f.dst, err = strconv.ParseFloat(f.src, 64); err != nil {
+				return nil, errors.Wrapf(err, "could not parse candle of %v", timeStart)
+			}
+		}
+
+		candles = append(candles, c)
+	}
+
+	return candles, nil
+}
+
+// symbolRequestInterval returns the request interval of the symbol.
+func (w *Worker) symbolRequestInterval(symbol string) time.Duration {
+	if interval, ok := w.symbolIntervals[symbol]; ok {
+		return interval
+	}
+	return w.requestInterval
+}
+
+func getJSON(url string, v interface{}) error {
+	resp, err := http.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%v received bad status code: %v", url, resp.StatusCode)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// serverTime returns the time of the Coinbase server.
+func serverTime() (time.Time, error) {
+	var response struct {
+		EpochMillis string `json:"epochMillis"`
+	}
+
+	if err := getJSON(timeURL, &response); err != nil {
+		return time.Time{}, err
+	}
+
+	ms, err := strconv.ParseInt(response.EpochMillis, 10, 64)
+	if err != nil {
+		return time.Time{}, errors.Wrapf(err, "could not parse server time %v", response.EpochMillis)
+	}
+	return time.Unix(0, ms*int64(time.Millisecond)), nil
+}
@@ -0,0 +1,193 @@
+package coinbase
+
+import (
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
+	"price-feed/exchanges/common"
+	"price-feed/models"
+	"price-feed/status"
+)
+
+// wsKeepalive is the default keepalive. The heartbeats channel pushes a message every second,
+// so no pings are sent and a connection which got no message for 30 seconds is dropped.
+var wsKeepalive = common.Keepalive{PongTimeout: 30 * time.Second}
+
+// wsSubscribe represents a subscription request of the WS API.
+type wsSubscribe struct {
+	Type       string   `json:"type"`
+	ProductIDs []string `json:"product_ids,omitempty"`
+	Channel    string   `json:"channel"`
+}
+
+// wsMessage represents a message of the WS API. The sequence number is per connection.
+type wsMessage struct {
+	Type        string    `json:"type"`
+	Message     string    `json:"message"`
+	Channel     string    `json:"channel"`
+	Timestamp   time.Time `json:"timestamp"`
+	SequenceNum int64     `json:"sequence_num"`
+	Events      []wsEvent `json:"events"`
+}
+
+type wsEvent struct {
+	Type      string    `json:"type"`
+	ProductID string    `json:"product_id"`
+	Updates   []wsLevel `json:"updates"`
+}
+
+type wsLevel struct {
+	Side        string `json:"side"`
+	PriceLevel  string `json:"price_level"`
+	NewQuantity string `json:"new_quantity"`
+}
+
+// GetOrderBook returns the order book of the symbol in the Binance format.
+func (w *Worker) GetOrderBook(symbol string) (models.OrderBookInternal, bool) {
+	w.orderBookCacheMu.Lock()
+	defer w.orderBookCacheMu.Unlock()
+
+	ob, ok := w.orderBookCache[symbol]
+	return ob, ok
+}
+
+// Resyncs returns the number of order book snapshots requested after a sequence gap.
+func (w *Worker) Resyncs() int64 {
+	return atomic.LoadInt64(&w.resyncs)
+}
+
+// ChaosStats is not supported by Coinbase.
+func (w *Worker) ChaosStats() (faults map[string]int64, violations int64, ok bool) {
+	return nil, 0, false
+}
+
+// BookDrift is not supported by Coinbase.
+func (w *Worker) BookDrift() (drifts map[string]float64, corrections int64, ok bool) {
+	return nil, 0, false
+}
+
+// Reconnects is not supported by Coinbase.
+func (w *Worker) Reconnects() (outcomes map[string]map[string]int64, ok bool) {
+	return nil, false
+}
+
+// Checksums is not supported by Coinbase.
+func (w *Worker) Checksums() (checksums map[string]status.Checksum, ok bool) {
+	return nil, false
+}
+
+// SubscribeOrderBook maintains the level2 order book of the symbol, reconnecting when the
+// stream is done. Every connection starts with a snapshot, so a reconnect also resyncs the book.
+func (w *Worker) SubscribeOrderBook(symbol string) {
+	for ; ; <-time.Tick(w.symbolRequestInterval(symbol)) {
+		err := w.serveOrderBook(symbol)
+		if err == errSequenceGap {
+			atomic.AddInt64(&w.resyncs, 1)
+		}
+		w.log.Warnf("Coinbase level2 stream of symbol %v is done: %v", symbol, err)
+	}
+}
+
+var errSequenceGap = errors.New("gap in sequence numbers")
+
+func (w *Worker) serveOrderBook(symbol string) error {
+	dialer := websocket.Dialer{HandshakeTimeout: w.handshakeTimeout}
+	conn, _, err := dialer.Dial(wsURL, nil)
+	if err != nil {
+		return errors.Wrapf(err, "could not connect")
+	}
+	defer conn.Close()
+
+	for _, v := range []wsSubscribe{
+		{Type: "subscribe", ProductIDs: []string{symbol}, Channel: "level2"},
+		{Type: "subscribe", Channel: "heartbeats"},
+	} {
+		if err = conn.WriteJSON(v); err != nil {
+			return errors.Wrapf(err, "could not subscribe")
+		}
+	}
+
+	stream := symbol + "@level2"
+	lastSequence := int64(-1)
+	defer w.keepalive.Start(conn, nil)()
+
+	for {
+		if err = w.keepalive.ExtendDeadline(conn); err != nil {
+			return err
+		}
+
+		var message wsMessage
+		if err = conn.ReadJSON(&message); err != nil {
+			return err
+		}
+
+		if message.Type == "error" {
+			return errors.Errorf("received error: %v", message.Message)
+		}
+
+		if lastSequence >= 0 && message.SequenceNum != lastSequence+1 {
+			return errSequenceGap
+		}
+		lastSequence = message.SequenceNum
+
+		if message.Channel != "l2_data" {
+			continue
+		}
+
+		w.streams.Track(stream, message.SequenceNum, w.clock.Adjust(message.Timestamp.UnixNano()/int64(time.Millisecond)))
+		for _, event := range message.Events {
+			if event.ProductID == symbol {
+				w.updateOrderBook(symbol, event)
+			}
+		}
+	}
+}
+
+func (w *Worker) updateOrderBook(symbol string, event wsEvent) {
+	w.orderBookCacheMu.Lock()
+	defer w.orderBookCacheMu.Unlock()
+
+	canonical := w.subscriptions.Canonical(symbol)
+
+	orderBook, ok := w.orderBookCache[canonical]
+	if event.Type == "snapshot" || !ok {
+		orderBook = models.OrderBookInternal{
+			Bids: make(map[string]string),
+			Asks: make(map[string]string),
+		}
+	}
+
+	for _, v := range event.Updates {
+		levels := orderBook.Bids
+		if v.Side == "offer" {
+			levels = orderBook.Asks
+		}
+
+		if quantity, err := strconv.ParseFloat(v.NewQuantity, 64); err != nil || quantity == 0 {
+			delete(levels, v.PriceLevel)
+			continue
+		}
+		levels[v.PriceLevel] = v.NewQuantity
+	}
+
+	w.orderBookCache[canonical] = orderBook
+	w.publishBBO(canonical)
+}
+
+// publishBBO pushes the best bid and offer of the symbol to the hub subscribers.
+// It must be called with orderBookCacheMu held.
+func (w *Worker) publishBBO(symbol string) {
+	channel := "bbo." + symbol
+	if !w.hub.HasSubscribers(channel) {
+		return
+	}
+
+	orderBook := w.orderBookCache[symbol]
+	bbo := orderBook.BestBidOffer()
+	bbo.Exchange = "coinbase"
+	bbo.Symbol = symbol
+	w.hub.Publish(channel, bbo)
+}
@@ -0,0 +1,12 @@
+package coinbase
+
+// AddSymbol subscribes to the symbol at runtime and persists the subscription,
+// so it is restored on startup.
+func (w *Worker) AddSymbol(symbol string) error {
+	if err := w.subscriptions.Add(symbol); err != nil {
+		return err
+	}
+
+	w.subscribe(symbol)
+	return nil
+}
@@ -0,0 +1,59 @@
+package coinbase
+
+import (
+	"strings"
+
+	"price-feed/models"
+)
+
+// fillSymbolMap fetches the Coinbase products and maps every subscribed symbol to
+// the canonical format. Symbols which can't be mapped are dropped, so they never
+// end up in the storage keys.
+func (w *Worker) fillSymbolMap() {
+	markets, err := w.fetchMarkets()
+	if err != nil {
+		w.log.Warnf("Could not get Coinbase products, mapping symbols by name: %v", err)
+	}
+	w.markets = markets
+
+	w.subscriptions.MapSymbols()
+}
+
+// mapSymbol derives the canonical symbol from the Coinbase product, unless it is
+// overridden in the config.
+func (w *Worker) mapSymbol(symbol string) (string, bool) {
+	if v, ok := w.config.SymbolMap[symbol]; ok {
+		return v, true
+	}
+
+	if w.markets != nil {
+		market, ok := w.markets[symbol]
+		if !ok {
+			return "", false
+		}
+		return models.CanonicalSymbol(market.BaseCurrencyID, market.QuoteCurrencyID, w.config.AssetAliases), true
+	}
+
+	// Coinbase product IDs are BASE-QUOTE.
+	parts := strings.Split(symbol, "-")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", false
+	}
+	return models.CanonicalSymbol(parts[0], parts[1], w.config.AssetAliases), true
+}
+
+func (w *Worker) fetchMarkets() (map[string]product, error) {
+	var response struct {
+		Products []product `json:"products"`
+	}
+
+	if err := getJSON(productsURL, &response); err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]product, len(response.Products))
+	for _, v := range response.Products {
+		result[v.ProductID] = v
+	}
+	return result, nil
+}
@@ -0,0 +1,45 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// GetJSON decodes the response of the GET request into v.
+func GetJSON(url string, v interface{}) error {
+	return Request(http.MethodGet, url, v)
+}
+
+// Request decodes the response of the request without a body into v.
+func Request(method, url string, v interface{}) error {
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%v received bad status code: %v", url, resp.StatusCode)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// ParseServerTime parses a Unix time in the unit, as sent as a string by some servers.
+func ParseServerTime(value string, unit time.Duration) (time.Time, error) {
+	v, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, errors.Wrapf(err, "could not parse server time %v", value)
+	}
+	return time.Unix(0, v*int64(unit)), nil
+}
@@ -0,0 +1,21 @@
+package common
+
+import (
+	"price-feed/models"
+	"price-feed/pipeline"
+)
+
+// Store persists the subscriptions and the symbol statuses of an exchange worker.
+type Store interface {
+	StoreSymbolStatuses(exchange string, statuses map[string]string) error
+	StoreSubscription(exchange, symbol string) error
+	LoadSubscriptions(exchange string) ([]string, error)
+}
+
+// CandleStore persists the data received by a worker which streams through the pipeline
+// and backfills its candles in batches.
+type CandleStore interface {
+	Store
+	pipeline.Store
+	StoreCandlesticks(exchange, symbol, interval string, candles []models.Candle) error
+}
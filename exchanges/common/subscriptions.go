@@ -0,0 +1,209 @@
+// Package common holds the symbol bookkeeping and the REST helpers shared by the exchange workers.
+package common
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/pkg/errors"
+	"price-feed/logger"
+)
+
+// Subscriptions is the list of the symbols an exchange worker is subscribed to, in the
+// format of the exchange, along with their canonical symbols in the Binance format.
+type Subscriptions struct {
+	// exchange is the storage name of the exchange, e.g. coinbase, and name the logged one.
+	exchange string
+	name     string
+	store    Store
+	log      *logger.Logger
+	// mapSymbol returns the canonical symbol, nil keeps the symbols as they are.
+	mapSymbol func(symbol string) (string, bool)
+
+	mu        sync.RWMutex
+	symbols   []string
+	canonical map[string]string
+}
+
+// NewSubscriptions returns the subscriptions of the exchange to the symbols.
+func NewSubscriptions(exchange, name string, symbols []string, store Store, log *logger.Logger,
+	mapSymbol func(symbol string) (string, bool)) *Subscriptions {
+	return &Subscriptions{
+		exchange:  exchange,
+		name:      name,
+		store:     store,
+		log:       log,
+		mapSymbol: mapSymbol,
+		symbols:   symbols,
+		canonical: make(map[string]string),
+	}
+}
+
+// List returns a copy of the symbols the worker is subscribed to.
+func (s *Subscriptions) List() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	symbols := make([]string, len(s.symbols))
+	copy(symbols, s.symbols)
+	return symbols
+}
+
+// Symbols returns the symbols the worker is subscribed to in the canonical Binance format.
+func (s *Subscriptions) Symbols() []string {
+	list := s.List()
+	symbols := make([]string, 0, len(list))
+	for _, v := range list {
+		symbols = append(symbols, s.Canonical(v))
+	}
+	return symbols
+}
+
+// Set replaces the symbols the worker is subscribed to.
+func (s *Subscriptions) Set(symbols []string) {
+	s.mu.Lock()
+	s.symbols = symbols
+	s.mu.Unlock()
+}
+
+// Has reports whether the worker is subscribed to the symbol.
+func (s *Subscriptions) Has(symbol string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, v := range s.symbols {
+		if v == symbol {
+			return true
+		}
+	}
+	return false
+}
+
+// Canonical returns the symbol in the canonical Binance format.
+func (s *Subscriptions) Canonical(symbol string) string {
+	if s.mapSymbol == nil {
+		return symbol
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.canonical[symbol]
+}
+
+// Add adds the symbol at runtime and persists the subscription, so it is restored
+// on startup. The worker subscribes to the streams of the symbol afterwards.
+func (s *Subscriptions) Add(symbol string) error {
+	if symbol == "" {
+		return fmt.Errorf("no symbol specified")
+	}
+
+	canonical := symbol
+	if s.mapSymbol != nil {
+		var ok bool
+		if canonical, ok = s.mapSymbol(symbol); !ok {
+			return fmt.Errorf("unknown symbol %v", symbol)
+		}
+	}
+
+	s.mu.Lock()
+	for _, v := range s.symbols {
+		if v == symbol {
+			s.mu.Unlock()
+			return fmt.Errorf("already subscribed to symbol %v", symbol)
+		}
+	}
+	s.symbols = append(s.symbols, symbol)
+	s.canonical[symbol] = canonical
+	s.mu.Unlock()
+
+	if err := s.store.StoreSubscription(s.exchange, symbol); err != nil {
+		return errors.Wrapf(err, "could not store %v subscription", s.name)
+	}
+
+	s.log.Infof("Subscribing to %v symbol %v", s.name, symbol)
+	return nil
+}
+
+// Subscribe adds the symbol like Add and subscribes the worker to its streams.
+func (s *Subscriptions) Subscribe(symbol string, subscribe func(symbol string)) error {
+	if err := s.Add(symbol); err != nil {
+		return err
+	}
+
+	subscribe(symbol)
+	return nil
+}
+
+// Restore adds the symbols subscribed at runtime by a previous run, except the blacklisted ones,
+// which may be listed in the format of the exchange or in the canonical one.
+func (s *Subscriptions) Restore(blacklist []string) error {
+	symbols, err := s.store.LoadSubscriptions(s.exchange)
+	if err != nil {
+		return err
+	}
+
+	if s.mapSymbol != nil {
+		blacklist = CanonicalBlacklist(symbols, blacklist, s.mapSymbol)
+	}
+	blacklisted := make(map[string]bool, len(blacklist))
+	for _, v := range blacklist {
+		blacklisted[v] = true
+	}
+
+	for _, v := range symbols {
+		if blacklisted[v] || s.Has(v) {
+			continue
+		}
+
+		s.mu.Lock()
+		s.symbols = append(s.symbols, v)
+		s.mu.Unlock()
+	}
+
+	return nil
+}
+
+// CanonicalBlacklist returns the blacklist along with the symbols which are mapped to a blacklisted
+// symbol, so a blacklist in the canonical Binance format excludes the symbols of the exchange.
+func CanonicalBlacklist(symbols, blacklist []string, mapSymbol func(symbol string) (string, bool)) []string {
+	if len(blacklist) == 0 {
+		return blacklist
+	}
+
+	blacklisted := make(map[string]bool, len(blacklist))
+	for _, v := range blacklist {
+		blacklisted[v] = true
+	}
+
+	result := append([]string(nil), blacklist...)
+	for _, v := range symbols {
+		if canonical, ok := mapSymbol(v); ok && blacklisted[canonical] && !blacklisted[v] {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+// MapSymbols maps every symbol to the canonical format. Symbols which can't be mapped
+// are dropped, so they never end up in the storage keys.
+func (s *Subscriptions) MapSymbols() {
+	if s.mapSymbol == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	symbols := make([]string, 0, len(s.symbols))
+	for _, v := range s.symbols {
+		canonical, ok := s.mapSymbol(v)
+		if !ok {
+			s.log.Warnf("Could not map %v symbol %v, skipping it", s.name, v)
+			continue
+		}
+		s.canonical[v] = canonical
+		symbols = append(symbols, v)
+	}
+	s.symbols = symbols
+}
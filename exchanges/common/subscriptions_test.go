@@ -0,0 +1,85 @@
+package common
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"price-feed/logger"
+)
+
+// fakeStore serves the subscriptions stored by a previous run.
+type fakeStore struct {
+	subscriptions []string
+}
+
+func (s *fakeStore) StoreSymbolStatuses(exchange string, statuses map[string]string) error {
+	return nil
+}
+
+func (s *fakeStore) StoreSubscription(exchange, symbol string) error {
+	return nil
+}
+
+func (s *fakeStore) LoadSubscriptions(exchange string) ([]string, error) {
+	return s.subscriptions, nil
+}
+
+// mapBittrexSymbol maps the Bittrex QUOTE-BASE market names.
+func mapBittrexSymbol(symbol string) (string, bool) {
+	parts := strings.Split(symbol, "-")
+	if len(parts) != 2 {
+		return "", false
+	}
+	return parts[1] + parts[0], true
+}
+
+func TestCanonicalBlacklist(t *testing.T) {
+	symbols := []string{"USDT-BTC", "USDT-ETH", "BTC-ORN"}
+
+	tests := []struct {
+		name      string
+		blacklist []string
+		want      []string
+	}{
+		{
+			name: "no blacklist",
+		},
+		{
+			name:      "symbol of the exchange",
+			blacklist: []string{"USDT-ETH"},
+			want:      []string{"USDT-ETH"},
+		},
+		{
+			name:      "canonical symbol",
+			blacklist: []string{"ETHUSDT", "ORNBTC"},
+			want:      []string{"ETHUSDT", "ORNBTC", "USDT-ETH", "BTC-ORN"},
+		},
+		{
+			name:      "both formats",
+			blacklist: []string{"ETHUSDT", "USDT-ETH"},
+			want:      []string{"ETHUSDT", "USDT-ETH"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := CanonicalBlacklist(symbols, tt.blacklist, mapBittrexSymbol)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("blacklist = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSubscriptionsRestore(t *testing.T) {
+	store := &fakeStore{subscriptions: []string{"USDT-BTC", "USDT-ETH", "BTC-ORN"}}
+	s := NewSubscriptions("bittrex", "Bittrex", nil, store, logger.New(&logger.Config{Level: "error"}), mapBittrexSymbol)
+
+	if err := s.Restore([]string{"ETHUSDT", "BTC-ORN"}); err != nil {
+		t.Fatalf("could not restore subscriptions: %v", err)
+	}
+	if got, want := s.List(), []string{"USDT-BTC"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("symbols = %v, want %v", got, want)
+	}
+}
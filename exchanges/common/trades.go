@@ -0,0 +1,31 @@
+package common
+
+import (
+	"sort"
+
+	"price-feed/models"
+)
+
+// TradeHistory filters the trades of a polled trade history, which are returned
+// again by every poll until they drop out of the history window.
+type TradeHistory struct {
+	seen map[string]bool
+}
+
+// New returns the trades which weren't returned by the previous poll, oldest first.
+func (h *TradeHistory) New(trades []models.Trade) []models.Trade {
+	seen := make(map[string]bool, len(trades))
+	result := make([]models.Trade, 0, len(trades))
+	for _, v := range trades {
+		seen[v.ID] = true
+		if !h.seen[v.ID] {
+			result = append(result, v)
+		}
+	}
+	h.seen = seen
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Time < result[j].Time
+	})
+	return result
+}
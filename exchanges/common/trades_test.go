@@ -0,0 +1,24 @@
+package common
+
+import (
+	"reflect"
+	"testing"
+
+	"price-feed/models"
+)
+
+func TestTradeHistoryNew(t *testing.T) {
+	first := []models.Trade{{ID: "3", Time: 30}, {ID: "2", Time: 20}, {ID: "1", Time: 10}}
+	second := []models.Trade{{ID: "5", Time: 50}, {ID: "4", Time: 40}, {ID: "3", Time: 30}}
+
+	var history TradeHistory
+	if got, want := history.New(first), []models.Trade{first[2], first[1], first[0]}; !reflect.DeepEqual(got, want) {
+		t.Errorf("first poll = %v, want %v", got, want)
+	}
+	if got, want := history.New(second), []models.Trade{second[1], second[0]}; !reflect.DeepEqual(got, want) {
+		t.Errorf("second poll = %v, want %v", got, want)
+	}
+	if got := history.New(second); len(got) != 0 {
+		t.Errorf("repeated poll = %v, want no trades", got)
+	}
+}
@@ -0,0 +1,51 @@
+package common
+
+import (
+	"time"
+
+	"price-feed/logger"
+	"price-feed/models"
+	"price-feed/status"
+)
+
+const (
+	clockInterval  = time.Minute
+	statusInterval = 5 * time.Minute
+)
+
+// WatchClock periodically measures the skew of the clock of the exchange.
+func WatchClock(name string, clock *status.Clock, serverTime func() (time.Time, error), log *logger.Logger) {
+	for ; ; <-time.Tick(clockInterval) {
+		if err := clock.Measure(serverTime); err != nil {
+			log.Warnf("Could not measure %v clock skew: %v", name, err)
+		}
+	}
+}
+
+// WatchStatuses periodically refreshes the trading statuses of the symbols by their
+// canonical symbols. fetch returns the statuses of the symbols listed by the exchange,
+// the symbols it doesn't list are delisted.
+func (s *Subscriptions) WatchStatuses(statuses *status.SymbolStatuses, fetch func() (map[string]string, error)) {
+	for ; ; <-time.Tick(statusInterval) {
+		listed, err := fetch()
+		if err != nil {
+			s.log.Errorf("Could not get %v symbols: %v", s.name, err)
+			continue
+		}
+
+		symbols := s.List()
+		result := make(map[string]string, len(symbols))
+		for _, symbol := range symbols {
+			if v, ok := listed[symbol]; ok {
+				result[s.Canonical(symbol)] = v
+			} else {
+				result[s.Canonical(symbol)] = models.SymbolStatusDelisted
+			}
+		}
+
+		statuses.Set(result)
+		if err := s.store.StoreSymbolStatuses(s.exchange, result); err != nil {
+			s.log.Errorf("Could not store %v symbol statuses: %v", s.name, err)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package common
+
+import (
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
+)
+
+// Keepalive represents the WS keepalive settings of a worker. A connection is pinged
+// every PingInterval and dropped if nothing, a pong included, arrives within
+// PingInterval+PongTimeout, so half-open connections are detected quickly.
+type Keepalive struct {
+	// PingInterval disables the pings if 0, e.g. for exchanges which ping the client.
+	PingInterval time.Duration
+	PongTimeout  time.Duration
+}
+
+// ParseKeepalive parses the configured ping interval and pong timeout, either of which
+// falls back to the default of the exchange if empty.
+func ParseKeepalive(pingInterval, pongTimeout string, defaults Keepalive) (Keepalive, error) {
+	k := defaults
+
+	if pingInterval != "" {
+		v, err := time.ParseDuration(pingInterval)
+		if err != nil {
+			return k, errors.Wrapf(err, "couldn't parse ping interval")
+		}
+		k.PingInterval = v
+	}
+
+	if pongTimeout != "" {
+		v, err := time.ParseDuration(pongTimeout)
+		if err != nil {
+			return k, errors.Wrapf(err, "couldn't parse pong timeout")
+		}
+		k.PongTimeout = v
+	}
+
+	return k, nil
+}
+
+// ExtendDeadline extends the read deadline of the connection, or clears it if both
+// the ping interval and the pong timeout are 0.
+func (k Keepalive) ExtendDeadline(conn *websocket.Conn) error {
+	timeout := k.PingInterval + k.PongTimeout
+	if timeout <= 0 {
+		return conn.SetReadDeadline(time.Time{})
+	}
+	return conn.SetReadDeadline(time.Now().Add(timeout))
+}
+
+// Start pings the connection every ping interval until the returned stop function is called.
+// The pings are sent with ping, which must be the only writer once started, or as control
+// frames if ping is nil, which may run alongside other writes. A failed ping stops the pings;
+// the connection is then dropped when its read deadline passes.
+func (k Keepalive) Start(conn *websocket.Conn, ping func() error) (stop func()) {
+	if k.PingInterval <= 0 {
+		return func() {}
+	}
+
+	if ping == nil {
+		conn.SetPongHandler(func(string) error {
+			return k.ExtendDeadline(conn)
+		})
+		ping = func() error {
+			return conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(k.PingInterval))
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(k.PingInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := ping(); err != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
@@ -0,0 +1,75 @@
+package huobi
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"price-feed/models"
+	"price-feed/pipeline"
+)
+
+// tick represents a kline of both the REST API and the stream. ID is the open time
+// in seconds, Amount is the base volume.
+type tick struct {
+	ID     int64   `json:"id"`
+	Open   float64 `json:"open"`
+	Close  float64 `json:"close"`
+	Low    float64 `json:"low"`
+	High   float64 `json:"high"`
+	Amount float64 `json:"amount"`
+}
+
+// SubscribeCandlestickAll streams the candles of all periods of the symbol
+// over one connection, reconnecting when the stream is done.
+func (w *Worker) SubscribeCandlestickAll(symbol string) {
+	channels := make([]string, 0, len(klinePeriods))
+	for _, period := range klinePeriodList {
+		channels = append(channels, "market."+symbol+".kline."+period)
+	}
+
+	for ; ; <-time.Tick(w.requestInterval) {
+		err := w.wsServe(channels, func(message *wsMessage) error {
+			parts := strings.Split(message.Ch, ".")
+			if len(parts) != 4 || parts[2] != "kline" {
+				return nil
+			}
+			interval, ok := klinePeriods[parts[3]]
+			if !ok {
+				return nil
+			}
+
+			var v tick
+			if err := json.Unmarshal(message.Tick, &v); err != nil {
+				return errors.Wrapf(err, "could not unmarshal kline")
+			}
+			candle := v.candle(interval, models.CandleSourceWS)
+
+			w.streams.Track(message.Ch, 0, w.clock.Adjust(message.Ts))
+			w.pipeline.Process(&pipeline.CandleEvent{
+				Exchange: "huobi",
+				Symbol:   w.subscriptions.Canonical(symbol),
+				Interval: interval,
+				Candle:   *candle,
+				Received: time.Now(),
+			})
+			return nil
+		})
+		w.log.Warnf("Huobi candle stream of symbol %v is done: %v", symbol, err)
+	}
+}
+
+func (t tick) candle(interval, source string) *models.Candle {
+	return &models.Candle{
+		TimeStart: t.ID,
+		TimeEnd:   t.ID + int64(models.IntervalDuration(interval).Seconds()) - 1,
+		Open:      t.Open,
+		High:      t.High,
+		Low:       t.Low,
+		Close:     t.Close,
+		Volume:    t.Amount,
+		Time:      time.Now().Unix(),
+		Source:    source,
+	}
+}
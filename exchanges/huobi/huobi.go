@@ -0,0 +1,270 @@
+package huobi
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"price-feed/exchanges/common"
+	"price-feed/hub"
+	"price-feed/logger"
+	"price-feed/models"
+	"price-feed/pipeline"
+	"price-feed/status"
+	"price-feed/warmup"
+)
+
+const (
+	timeURL          = "https://api.huobi.pro/v1/common/timestamp"
+	symbolsURL       = "https://api.huobi.pro/v1/common/symbols"
+	candlesURL       = "https://api.huobi.pro/market/history/kline?symbol=%s&period=%s&size=%d"
+	candlestickLimit = 2000
+)
+
+// klinePeriods maps the Huobi kline periods to the Binance intervals.
+var klinePeriods = map[string]string{
+	"1min":  "1m",
+	"5min":  "5m",
+	"15min": "15m",
+	"30min": "30m",
+	"60min": "1h",
+	"4hour": "4h",
+	"1day":  "1d",
+	"1week": "1w",
+	"1mon":  "1M",
+}
+
+// klinePeriodList lists the periods of klinePeriods from the shortest interval.
+var klinePeriodList = []string{"1min", "5min", "15min", "30min", "60min", "4hour", "1day", "1week", "1mon"}
+
+// Config represents a Huobi worker configuration.
+type Config struct {
+	// WsTimeout is the WS handshake timeout.
+	WsTimeout       string   `json:"ws_timeout"`
+	RequestInterval string   `json:"request_interval"`
+	Blacklist       []string `json:"blacklist"`
+	// PingInterval and PongTimeout override the WS keepalive of the exchange, see common.Keepalive.
+	PingInterval string `json:"ping_interval"`
+	PongTimeout  string `json:"pong_timeout"`
+	// WarmupConcurrency limits the candle backfill requests running at once, 4 by default.
+	WarmupConcurrency int `json:"warmup_concurrency"`
+	// SymbolMap overrides the canonical symbols derived from the markets.
+	SymbolMap map[string]string `json:"symbol_map"`
+	// AssetAliases renames assets to their Binance names.
+	AssetAliases map[string]string `json:"asset_aliases"`
+}
+
+// Worker maintains the order books and the candles of the Huobi markets.
+type Worker struct {
+	config           *Config
+	log              *logger.Logger
+	database         common.CandleStore
+	hub              *hub.Hub
+	handshakeTimeout time.Duration
+	keepalive        common.Keepalive
+	requestInterval  time.Duration
+	subscriptions    *common.Subscriptions
+	markets          map[string]market
+	orderBookCacheMu sync.Mutex
+	orderBookCache   map[string]models.OrderBookInternal
+	quit             chan os.Signal
+	streams          *status.Tracker
+	warmup           *warmup.Scheduler
+	clock            *status.Clock
+	symbolStatuses   *status.SymbolStatuses
+	pipeline         *pipeline.Pipeline
+}
+
+// market represents a symbol of the public API.
+type market struct {
+	Symbol        string `json:"symbol"`
+	BaseCurrency  string `json:"base-currency"`
+	QuoteCurrency string `json:"quote-currency"`
+	State         string `json:"state"`
+}
+
+// NewWorker returns a new Huobi worker.
+func NewWorker(config *Config, log *logger.Logger, database common.CandleStore, hub *hub.Hub, quit chan os.Signal) (*Worker, error) {
+	wsTimeout, err := time.ParseDuration(config.WsTimeout)
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldn't parse Huobi WS timeout")
+	}
+
+	keepalive, err := common.ParseKeepalive(config.PingInterval, config.PongTimeout, wsKeepalive)
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldn't parse Huobi WS keepalive")
+	}
+
+	interval, err := time.ParseDuration(config.RequestInterval)
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldn't parse Huobi request interval")
+	}
+
+	w := &Worker{
+		config:           config,
+		log:              log,
+		database:         database,
+		hub:              hub,
+		handshakeTimeout: wsTimeout,
+		keepalive:        keepalive,
+		requestInterval:  interval,
+		orderBookCache:   make(map[string]models.OrderBookInternal),
+		quit:             quit,
+		streams:          status.NewTracker(),
+		warmup:           warmup.New("Huobi", config.WarmupConcurrency, log),
+		clock:            status.NewClock(time.Millisecond),
+		symbolStatuses:   status.NewSymbolStatuses(),
+	}
+	w.subscriptions = common.NewSubscriptions("huobi", "Huobi", models.ExcludeSymbols(models.HuobiSymbols, config.Blacklist), database, log, w.mapSymbol)
+	w.pipeline = pipeline.New(log, pipeline.Validate(), pipeline.Publish(hub), pipeline.Persist(database, false))
+
+	if err = w.subscriptions.Restore(config.Blacklist); err != nil {
+		return nil, errors.Wrapf(err, "couldn't restore Huobi subscriptions")
+	}
+
+	w.fillSymbolMap()
+
+	return w, nil
+}
+
+// Streams returns the last event status of every stream.
+func (w *Worker) Streams() []status.Stream {
+	return w.streams.Streams()
+}
+
+// fetchStatuses returns the trading statuses of the listed Huobi symbols.
+func (w *Worker) fetchStatuses() (map[string]string, error) {
+	markets, err := fetchMarkets()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make(map[string]string, len(markets))
+	for symbol, v := range markets {
+		switch v.State {
+		case "online":
+			statuses[symbol] = models.SymbolStatusTrading
+		case "offline":
+			statuses[symbol] = models.SymbolStatusDelisted
+		default:
+			statuses[symbol] = models.SymbolStatusBreak
+		}
+	}
+	return statuses, nil
+}
+
+// SymbolStatuses returns the trading status of every symbol in the Binance format.
+func (w *Worker) SymbolStatuses() map[string]string {
+	return w.symbolStatuses.All()
+}
+
+// ClockSkew returns the last measured skew of the Huobi clock.
+func (w *Worker) ClockSkew() time.Duration {
+	return w.clock.Skew()
+}
+
+// Symbols returns the symbols the worker is subscribed to in the Binance format.
+func (w *Worker) Symbols() []string {
+	return w.subscriptions.Symbols()
+}
+
+// AddSymbol subscribes to the symbol at runtime and persists the subscription,
+// so it is restored on startup.
+func (w *Worker) AddSymbol(symbol string) error {
+	return w.subscriptions.Subscribe(symbol, w.subscribe)
+}
+
+// Start starts a new Huobi worker.
+func (w *Worker) Start() {
+	go w.subscriptions.WatchStatuses(w.symbolStatuses, w.fetchStatuses)
+	go common.WatchClock("Huobi", w.clock, serverTime, w.log)
+
+	for _, symbol := range w.subscriptions.List() {
+		w.subscribe(symbol)
+	}
+}
+
+// subscribe starts the order book and candlestick streams of the symbol.
+func (w *Worker) subscribe(symbol string) {
+	go w.SubscribeOrderBook(symbol)
+	go func() {
+		w.initCandlesticksAll(symbol)
+		w.SubscribeCandlestickAll(symbol)
+	}()
+}
+
+func (w *Worker) Reload() {
+	for _, symbol := range w.subscriptions.List() {
+		for _, v := range klinePeriodList {
+			symbol, period := symbol, v
+			w.warmup.Submit(func() {
+				w.initCandlesticks(symbol, period)
+			})
+		}
+	}
+	w.log.Infof("Huobi cache reloaded")
+}
+
+// initCandlesticksAll backfills the candles of all periods of the symbol one by one.
+func (w *Worker) initCandlesticksAll(symbol string) {
+	for _, period := range klinePeriodList {
+		period := period
+		w.warmup.Run(func() {
+			w.initCandlesticks(symbol, period)
+		})
+	}
+}
+
+func (w *Worker) initCandlesticks(symbol, period string) {
+	var ticks []tick
+	if err := getJSON(fmt.Sprintf(candlesURL, symbol, period, candlestickLimit), &ticks); err != nil {
+		w.log.Errorf("Could not load candlesticks from Huobi REST API with period %v and symbol %v: %v",
+			period, symbol, err)
+
+		return
+	}
+
+	interval := klinePeriods[period]
+	candles := make([]models.Candle, 0, len(ticks))
+	for _, v := range ticks {
+		candles = append(candles, *v.candle(interval, models.CandleSourceREST))
+	}
+	w.storeCandlesticks(symbol, interval, candles)
+}
+
+// storeCandlesticks stores the backfilled candles in batches.
+func (w *Worker) storeCandlesticks(symbol, interval string, candles []models.Candle) {
+	if err := w.database.StoreCandlesticks("huobi", w.subscriptions.Canonical(symbol), interval, candles); err != nil {
+		w.log.Errorf("Could not store candlesticks to database: %v", err)
+	}
+}
+
+// getJSON calls the public API and decodes the data of the response.
+func getJSON(url string, v interface{}) error {
+	var response struct {
+		Status  string          `json:"status"`
+		ErrCode string          `json:"err-code"`
+		ErrMsg  string          `json:"err-msg"`
+		Data    json.RawMessage `json:"data"`
+	}
+	if err := common.GetJSON(url, &response); err != nil {
+		return err
+	}
+	if response.Status != "ok" {
+		return fmt.Errorf("%v received error %v: %v", url, response.ErrCode, response.ErrMsg)
+	}
+
+	return json.Unmarshal(response.Data, v)
+}
+
+// serverTime returns the time of the Huobi server.
+func serverTime() (time.Time, error) {
+	var ms int64
+	if err := getJSON(timeURL, &ms); err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(0, ms*int64(time.Millisecond)), nil
+}
@@ -0,0 +1,117 @@
+package huobi
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+	"price-feed/models"
+	"price-feed/status"
+)
+
+// depth represents an order book push. The levels are [price, size].
+type depth struct {
+	Bids    [][]json.Number `json:"bids"`
+	Asks    [][]json.Number `json:"asks"`
+	Version int64           `json:"version"`
+}
+
+// GetOrderBook returns the order book of the symbol in the Binance format.
+func (w *Worker) GetOrderBook(symbol string) (models.OrderBookInternal, bool) {
+	w.orderBookCacheMu.Lock()
+	defer w.orderBookCacheMu.Unlock()
+
+	ob, ok := w.orderBookCache[symbol]
+	return ob, ok
+}
+
+// Resyncs always returns 0, the depth stream pushes the whole book every time.
+func (w *Worker) Resyncs() int64 {
+	return 0
+}
+
+// ChaosStats is not supported by Huobi.
+func (w *Worker) ChaosStats() (faults map[string]int64, violations int64, ok bool) {
+	return nil, 0, false
+}
+
+// BookDrift is not supported by Huobi.
+func (w *Worker) BookDrift() (drifts map[string]float64, corrections int64, ok bool) {
+	return nil, 0, false
+}
+
+// Reconnects is not supported by Huobi.
+func (w *Worker) Reconnects() (outcomes map[string]map[string]int64, ok bool) {
+	return nil, false
+}
+
+// Checksums is not supported by Huobi.
+func (w *Worker) Checksums() (checksums map[string]status.Checksum, ok bool) {
+	return nil, false
+}
+
+// SubscribeOrderBook maintains the order book of the symbol from the unaggregated
+// depth snapshots, reconnecting when the stream is done.
+func (w *Worker) SubscribeOrderBook(symbol string) {
+	channel := "market." + symbol + ".depth.step0"
+
+	for ; ; <-time.Tick(w.requestInterval) {
+		err := w.wsServe([]string{channel}, func(message *wsMessage) error {
+			if message.Ch != channel {
+				return nil
+			}
+
+			var snapshot depth
+			if err := json.Unmarshal(message.Tick, &snapshot); err != nil {
+				return errors.Wrapf(err, "could not unmarshal depth")
+			}
+
+			w.streams.Track(channel, snapshot.Version, w.clock.Adjust(message.Ts))
+			w.updateOrderBook(symbol, snapshot)
+			return nil
+		})
+		w.log.Warnf("Huobi order book stream of symbol %v is done: %v", symbol, err)
+	}
+}
+
+func (w *Worker) updateOrderBook(symbol string, snapshot depth) {
+	w.orderBookCacheMu.Lock()
+	defer w.orderBookCacheMu.Unlock()
+
+	orderBook := models.OrderBookInternal{
+		Bids:         make(map[string]string, len(snapshot.Bids)),
+		Asks:         make(map[string]string, len(snapshot.Asks)),
+		LastUpdateID: snapshot.Version,
+	}
+
+	for _, side := range []struct {
+		levels map[string]string
+		values [][]json.Number
+	}{{orderBook.Bids, snapshot.Bids}, {orderBook.Asks, snapshot.Asks}} {
+		for _, v := range side.values {
+			if len(v) < 2 {
+				continue
+			}
+			side.levels[v[0].String()] = v[1].String()
+		}
+	}
+
+	canonical := w.subscriptions.Canonical(symbol)
+	w.orderBookCache[canonical] = orderBook
+	w.publishBBO(canonical)
+}
+
+// publishBBO pushes the best bid and offer of the symbol to the hub subscribers.
+// It must be called with orderBookCacheMu held.
+func (w *Worker) publishBBO(symbol string) {
+	channel := "bbo." + symbol
+	if !w.hub.HasSubscribers(channel) {
+		return
+	}
+
+	orderBook := w.orderBookCache[symbol]
+	bbo := orderBook.BestBidOffer()
+	bbo.Exchange = "huobi"
+	bbo.Symbol = symbol
+	w.hub.Publish(channel, bbo)
+}
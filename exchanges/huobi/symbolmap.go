@@ -0,0 +1,56 @@
+package huobi
+
+import (
+	"strings"
+
+	"price-feed/models"
+)
+
+// fillSymbolMap fetches the Huobi markets and maps every subscribed symbol to
+// the canonical format. Symbols which can't be mapped are dropped, so they never
+// end up in the storage keys.
+func (w *Worker) fillSymbolMap() {
+	markets, err := fetchMarkets()
+	if err != nil {
+		w.log.Warnf("Could not get Huobi symbols, keeping the symbols as they are: %v", err)
+	}
+	w.markets = markets
+
+	w.subscriptions.MapSymbols()
+}
+
+// mapSymbol derives the canonical symbol from the Huobi market, unless it is
+// overridden in the config.
+func (w *Worker) mapSymbol(symbol string) (string, bool) {
+	if v, ok := w.config.SymbolMap[symbol]; ok {
+		return v, true
+	}
+
+	if w.markets != nil {
+		market, ok := w.markets[symbol]
+		if !ok {
+			return "", false
+		}
+		return models.CanonicalSymbol(market.BaseCurrency, market.QuoteCurrency, w.config.AssetAliases), true
+	}
+
+	// Huobi symbols are the lowercase Binance ones, basequote.
+	if symbol == "" {
+		return "", false
+	}
+	return strings.ToUpper(symbol), true
+}
+
+// fetchMarkets returns the Huobi markets by symbol.
+func fetchMarkets() (map[string]market, error) {
+	var list []market
+	if err := getJSON(symbolsURL, &list); err != nil {
+		return nil, err
+	}
+
+	markets := make(map[string]market, len(list))
+	for _, v := range list {
+		markets[v.Symbol] = v
+	}
+	return markets, nil
+}
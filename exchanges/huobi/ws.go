@@ -0,0 +1,106 @@
+package huobi
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
+	"price-feed/exchanges/common"
+)
+
+const (
+	wsURL = "wss://api.huobi.pro/ws"
+)
+
+// wsKeepalive is the default keepalive. Huobi pings every 5 seconds and drops the connection
+// after two missed pongs, so no pings are sent and a silent minute means the connection is dead.
+var wsKeepalive = common.Keepalive{PongTimeout: time.Minute}
+
+type wsRequest struct {
+	Sub string `json:"sub"`
+	ID  string `json:"id"`
+}
+
+// wsMessage represents a server ping, a subscription response or a data push.
+type wsMessage struct {
+	Ping    int64           `json:"ping"`
+	ID      string          `json:"id"`
+	Status  string          `json:"status"`
+	ErrCode string          `json:"err-code"`
+	ErrMsg  string          `json:"err-msg"`
+	Ch      string          `json:"ch"`
+	Ts      int64           `json:"ts"`
+	Tick    json.RawMessage `json:"tick"`
+}
+
+// wsServe subscribes to the channels and passes the data pushes to the handler
+// until the connection fails or the handler returns an error. Every frame
+// sent by Huobi is gzip-compressed.
+func (w *Worker) wsServe(channels []string, handler func(message *wsMessage) error) error {
+	dialer := websocket.Dialer{HandshakeTimeout: w.handshakeTimeout}
+	conn, _, err := dialer.Dial(wsURL, nil)
+	if err != nil {
+		return errors.Wrapf(err, "could not connect")
+	}
+	defer conn.Close()
+
+	for i, v := range channels {
+		if err = conn.WriteJSON(wsRequest{Sub: v, ID: strconv.Itoa(i)}); err != nil {
+			return errors.Wrapf(err, "could not subscribe")
+		}
+	}
+
+	// The pongs are written by the read loop, so the keepalive pings are control frames.
+	defer w.keepalive.Start(conn, nil)()
+
+	for {
+		if err = w.keepalive.ExtendDeadline(conn); err != nil {
+			return err
+		}
+
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+
+		if data, err = gunzip(data); err != nil {
+			return errors.Wrapf(err, "could not decompress message")
+		}
+
+		var message wsMessage
+		if err = json.Unmarshal(data, &message); err != nil {
+			return errors.Wrapf(err, "could not unmarshal message")
+		}
+
+		switch {
+		case message.Ping != 0:
+			if err = conn.WriteJSON(map[string]int64{"pong": message.Ping}); err != nil {
+				return errors.Wrapf(err, "could not pong")
+			}
+			continue
+		case message.Status == "error":
+			return errors.Errorf("received error %v: %v", message.ErrCode, message.ErrMsg)
+		case message.Ch == "":
+			continue
+		}
+
+		if err = handler(&message); err != nil {
+			return err
+		}
+	}
+}
+
+func gunzip(data []byte) ([]byte, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	return ioutil.ReadAll(reader)
+}
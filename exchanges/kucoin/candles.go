@@ -0,0 +1,58 @@
+package kucoin
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"price-feed/models"
+	"price-feed/pipeline"
+)
+
+// candleData represents a candle push. Time is in nanoseconds.
+type candleData struct {
+	Symbol  string   `json:"symbol"`
+	Candles []string `json:"candles"`
+	Time    int64    `json:"time"`
+}
+
+// SubscribeCandlestickAll streams the candles of all intervals of the symbol
+// over one connection, reconnecting when the stream is done.
+func (w *Worker) SubscribeCandlestickAll(symbol string) {
+	topics := make([]string, 0, len(candleTypes))
+	for _, candleType := range candleTypeList {
+		topics = append(topics, "/market/candles:"+symbol+"_"+candleType)
+	}
+
+	for ; ; <-time.Tick(w.requestInterval) {
+		err := w.wsServe(topics, func(message *wsMessage) error {
+			interval, ok := candleTypes[message.Topic[strings.LastIndex(message.Topic, "_")+1:]]
+			if !ok {
+				return nil
+			}
+
+			var data candleData
+			if err := json.Unmarshal(message.Data, &data); err != nil {
+				return errors.Wrapf(err, "could not unmarshal candle")
+			}
+
+			candle, err := parseCandle(data.Candles, interval, models.CandleSourceWS)
+			if err != nil {
+				w.log.Errorf("Could not parse KuCoin candle of symbol %v: %v", symbol, err)
+				return nil
+			}
+
+			w.streams.Track(message.Topic, 0, w.clock.Adjust(data.Time/int64(time.Millisecond)))
+			w.pipeline.Process(&pipeline.CandleEvent{
+				Exchange: "kucoin",
+				Symbol:   w.subscriptions.Canonical(symbol),
+				Interval: interval,
+				Candle:   *candle,
+				Received: time.Now(),
+			})
+			return nil
+		})
+		w.log.Warnf("KuCoin candle stream of symbol %v is done: %v", symbol, err)
+	}
+}
@@ -0,0 +1,314 @@
+package kucoin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"price-feed/exchanges/common"
+	"price-feed/hub"
+	"price-feed/logger"
+	"price-feed/models"
+	"price-feed/pipeline"
+	"price-feed/status"
+	"price-feed/warmup"
+)
+
+const (
+	timeURL          = "https://api.kucoin.com/api/v1/timestamp"
+	symbolsURL       = "https://api.kucoin.com/api/v2/symbols"
+	candlesURL       = "https://api.kucoin.com/api/v1/market/candles?type=%s&symbol=%s&startAt=%d&endAt=%d"
+	candlestickLimit = 1500
+	codeSuccess      = "200000"
+)
+
+// candleTypes maps the KuCoin candle types to the Binance intervals.
+var candleTypes = map[string]string{
+	"1min":   "1m",
+	"3min":   "3m",
+	"5min":   "5m",
+	"15min":  "15m",
+	"30min":  "30m",
+	"1hour":  "1h",
+	"2hour":  "2h",
+	"4hour":  "4h",
+	"6hour":  "6h",
+	"8hour":  "8h",
+	"12hour": "12h",
+	"1day":   "1d",
+	"1week":  "1w",
+}
+
+// candleTypeList lists the candle types of candleTypes from the shortest interval.
+var candleTypeList = []string{"1min", "3min", "5min", "15min", "30min", "1hour", "2hour", "4hour", "6hour", "8hour", "12hour", "1day", "1week"}
+
+// Config represents a KuCoin worker configuration.
+type Config struct {
+	// WsTimeout is the WS handshake timeout.
+	WsTimeout       string   `json:"ws_timeout"`
+	RequestInterval string   `json:"request_interval"`
+	Blacklist       []string `json:"blacklist"`
+	// PingInterval and PongTimeout override the WS keepalive of the exchange, see common.Keepalive.
+	PingInterval string `json:"ping_interval"`
+	PongTimeout  string `json:"pong_timeout"`
+	// WarmupConcurrency limits the candle backfill requests running at once, 4 by default.
+	WarmupConcurrency int `json:"warmup_concurrency"`
+	// SymbolMap overrides the canonical symbols derived from the markets.
+	SymbolMap map[string]string `json:"symbol_map"`
+	// AssetAliases renames assets to their Binance names.
+	AssetAliases map[string]string `json:"asset_aliases"`
+}
+
+// Worker maintains the order books and the candles of the KuCoin markets.
+type Worker struct {
+	config           *Config
+	log              *logger.Logger
+	database         common.CandleStore
+	hub              *hub.Hub
+	handshakeTimeout time.Duration
+	keepalive        common.Keepalive
+	requestInterval  time.Duration
+	subscriptions    *common.Subscriptions
+	markets          map[string]market
+	orderBookCacheMu sync.Mutex
+	orderBookCache   map[string]models.OrderBookInternal
+	quit             chan os.Signal
+	streams          *status.Tracker
+	warmup           *warmup.Scheduler
+	clock            *status.Clock
+	symbolStatuses   *status.SymbolStatuses
+	pipeline         *pipeline.Pipeline
+	resyncs          int64
+}
+
+// market represents a market of the public API.
+type market struct {
+	Symbol        string `json:"symbol"`
+	BaseCurrency  string `json:"baseCurrency"`
+	QuoteCurrency string `json:"quoteCurrency"`
+	EnableTrading bool   `json:"enableTrading"`
+}
+
+// NewWorker returns a new KuCoin worker.
+func NewWorker(config *Config, log *logger.Logger, database common.CandleStore, hub *hub.Hub, quit chan os.Signal) (*Worker, error) {
+	wsTimeout, err := time.ParseDuration(config.WsTimeout)
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldn't parse KuCoin WS timeout")
+	}
+
+	keepalive, err := common.ParseKeepalive(config.PingInterval, config.PongTimeout, wsKeepalive)
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldn't parse KuCoin WS keepalive")
+	}
+
+	interval, err := time.ParseDuration(config.RequestInterval)
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldn't parse KuCoin request interval")
+	}
+
+	w := &Worker{
+		config:           config,
+		log:              log,
+		database:         database,
+		hub:              hub,
+		handshakeTimeout: wsTimeout,
+		keepalive:        keepalive,
+		requestInterval:  interval,
+		orderBookCache:   make(map[string]models.OrderBookInternal),
+		quit:             quit,
+		streams:          status.NewTracker(),
+		warmup:           warmup.New("KuCoin", config.WarmupConcurrency, log),
+		clock:            status.NewClock(time.Millisecond),
+		symbolStatuses:   status.NewSymbolStatuses(),
+	}
+	w.subscriptions = common.NewSubscriptions("kucoin", "KuCoin", models.ExcludeSymbols(models.KuCoinSymbols, config.Blacklist), database, log, w.mapSymbol)
+	w.pipeline = pipeline.New(log, pipeline.Validate(), pipeline.Publish(hub), pipeline.Persist(database, false))
+
+	if err = w.subscriptions.Restore(config.Blacklist); err != nil {
+		return nil, errors.Wrapf(err, "couldn't restore KuCoin subscriptions")
+	}
+
+	w.fillSymbolMap()
+
+	return w, nil
+}
+
+// Streams returns the last event status of every stream.
+func (w *Worker) Streams() []status.Stream {
+	return w.streams.Streams()
+}
+
+// fetchStatuses returns the trading statuses of the listed KuCoin markets.
+func (w *Worker) fetchStatuses() (map[string]string, error) {
+	markets, err := fetchMarkets()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make(map[string]string, len(markets))
+	for symbol, v := range markets {
+		if v.EnableTrading {
+			statuses[symbol] = models.SymbolStatusTrading
+		} else {
+			statuses[symbol] = models.SymbolStatusBreak
+		}
+	}
+	return statuses, nil
+}
+
+// SymbolStatuses returns the trading status of every symbol in the Binance format.
+func (w *Worker) SymbolStatuses() map[string]string {
+	return w.symbolStatuses.All()
+}
+
+// ClockSkew returns the last measured skew of the KuCoin clock.
+func (w *Worker) ClockSkew() time.Duration {
+	return w.clock.Skew()
+}
+
+// Symbols returns the symbols the worker is subscribed to in the Binance format.
+func (w *Worker) Symbols() []string {
+	return w.subscriptions.Symbols()
+}
+
+// AddSymbol subscribes to the symbol at runtime and persists the subscription,
+// so it is restored on startup.
+func (w *Worker) AddSymbol(symbol string) error {
+	return w.subscriptions.Subscribe(symbol, w.subscribe)
+}
+
+// Start starts a new KuCoin worker.
+func (w *Worker) Start() {
+	go w.subscriptions.WatchStatuses(w.symbolStatuses, w.fetchStatuses)
+	go common.WatchClock("KuCoin", w.clock, serverTime, w.log)
+
+	for _, symbol := range w.subscriptions.List() {
+		w.subscribe(symbol)
+	}
+}
+
+// subscribe starts the order book and candlestick streams of the symbol.
+func (w *Worker) subscribe(symbol string) {
+	go w.SubscribeOrderBook(symbol)
+	go func() {
+		w.initCandlesticksAll(symbol)
+		w.SubscribeCandlestickAll(symbol)
+	}()
+}
+
+func (w *Worker) Reload() {
+	for _, symbol := range w.subscriptions.List() {
+		for _, v := range candleTypeList {
+			symbol, candleType := symbol, v
+			w.warmup.Submit(func() {
+				w.initCandlesticks(symbol, candleType)
+			})
+		}
+	}
+	w.log.Infof("KuCoin cache reloaded")
+}
+
+// initCandlesticksAll backfills the candles of all intervals of the symbol one by one.
+func (w *Worker) initCandlesticksAll(symbol string) {
+	for _, candleType := range candleTypeList {
+		candleType := candleType
+		w.warmup.Run(func() {
+			w.initCandlesticks(symbol, candleType)
+		})
+	}
+}
+
+func (w *Worker) initCandlesticks(symbol, candleType string) {
+	interval := candleTypes[candleType]
+	endAt := time.Now().Unix()
+	startAt := endAt - candlestickLimit*int64(models.IntervalDuration(interval).Seconds())
+
+	var candles [][]string
+	if err := getJSON(fmt.Sprintf(candlesURL, candleType, symbol, startAt, endAt), &candles); err != nil {
+		w.log.Errorf("Could not load candlesticks from KuCoin REST API with interval %v and symbol %v: %v",
+			candleType, symbol, err)
+
+		return
+	}
+
+	parsed := make([]models.Candle, 0, len(candles))
+	for _, v := range candles {
+		candle, err := parseCandle(v, interval, models.CandleSourceREST)
+		if err != nil {
+			w.log.Errorf("Could not parse KuCoin candle of symbol %v: %v", symbol, err)
+			continue
+		}
+
+		parsed = append(parsed, *candle)
+	}
+	w.storeCandlesticks(symbol, interval, parsed)
+}
+
+// storeCandlesticks stores the backfilled candles in batches.
+func (w *Worker) storeCandlesticks(symbol, interval string, candles []models.Candle) {
+	if err := w.database.StoreCandlesticks("kucoin", w.subscriptions.Canonical(symbol), interval, candles); err != nil {
+		w.log.Errorf("Could not store candlesticks to database: %v", err)
+	}
+}
+
+// parseCandle converts a KuCoin candle, [time, open, close, high, low, volume, turnover].
+func parseCandle(v []string, interval, source string) (*models.Candle, error) {
+	if len(v) < 6 {
+		return nil, fmt.Errorf("candle has %v fields", len(v))
+	}
+
+	timeStart, err := strconv.ParseInt(v[0], 10, 64)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not parse candle time %v", v[0])
+	}
+
+	candle := &models.Candle{
+		TimeStart: timeStart,
+		TimeEnd:   timeStart + int64(models.IntervalDuration(interval).Seconds()) - 1,
+		Time:      time.Now().Unix(),
+		Source:    source,
+	}
+
+	for i, dst := range []*float64{&candle.Open, &candle.Close, &candle.High, &candle.Low, &candle.Volume} {
+		if *dst, err = strconv.ParseFloat(v[i+1], 64); err != nil {
+			return nil, errors.Wrapf(err, "could not parse candle of %v", timeStart)
+		}
+	}
+
+	return candle, nil
+}
+
+func getJSON(url string, v interface{}) error {
+	return request(http.MethodGet, url, v)
+}
+
+// request calls the public API and decodes the data of the response.
+func request(method, url string, v interface{}) error {
+	var response struct {
+		Code string          `json:"code"`
+		Msg  string          `json:"msg"`
+		Data json.RawMessage `json:"data"`
+	}
+	if err := common.Request(method, url, &response); err != nil {
+		return err
+	}
+	if response.Code != codeSuccess {
+		return fmt.Errorf("%v received error %v: %v", url, response.Code, response.Msg)
+	}
+
+	return json.Unmarshal(response.Data, v)
+}
+
+// serverTime returns the time of the KuCoin server.
+func serverTime() (time.Time, error) {
+	var ms int64
+	if err := getJSON(timeURL, &ms); err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(0, ms*int64(time.Millisecond)), nil
+}
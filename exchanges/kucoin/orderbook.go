@@ -0,0 +1,203 @@
+package kucoin
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"price-feed/models"
+	"price-feed/status"
+)
+
+const depthURL = "https://api.kucoin.com/api/v1/market/orderbook/level2_100?symbol=%s"
+
+var errSequenceGap = errors.New("gap in sequence numbers")
+
+// level2Data represents an order book increment. The changes are [price, size, sequence].
+type level2Data struct {
+	SequenceStart int64 `json:"sequenceStart"`
+	SequenceEnd   int64 `json:"sequenceEnd"`
+	Changes       struct {
+		Asks [][]string `json:"asks"`
+		Bids [][]string `json:"bids"`
+	} `json:"changes"`
+	Time int64 `json:"time"`
+}
+
+// depthSnapshot represents the top levels of an order book, [price, size].
+type depthSnapshot struct {
+	Sequence string     `json:"sequence"`
+	Asks     [][]string `json:"asks"`
+	Bids     [][]string `json:"bids"`
+}
+
+// GetOrderBook returns the order book of the symbol in the Binance format.
+func (w *Worker) GetOrderBook(symbol string) (models.OrderBookInternal, bool) {
+	w.orderBookCacheMu.Lock()
+	defer w.orderBookCacheMu.Unlock()
+
+	ob, ok := w.orderBookCache[symbol]
+	return ob, ok
+}
+
+// Resyncs returns the number of order book snapshots requested after a sequence gap.
+func (w *Worker) Resyncs() int64 {
+	return atomic.LoadInt64(&w.resyncs)
+}
+
+// ChaosStats is not supported by KuCoin.
+func (w *Worker) ChaosStats() (faults map[string]int64, violations int64, ok bool) {
+	return nil, 0, false
+}
+
+// BookDrift is not supported by KuCoin.
+func (w *Worker) BookDrift() (drifts map[string]float64, corrections int64, ok bool) {
+	return nil, 0, false
+}
+
+// Reconnects is not supported by KuCoin.
+func (w *Worker) Reconnects() (outcomes map[string]map[string]int64, ok bool) {
+	return nil, false
+}
+
+// Checksums is not supported by KuCoin.
+func (w *Worker) Checksums() (checksums map[string]status.Checksum, ok bool) {
+	return nil, false
+}
+
+// SubscribeOrderBook maintains the order book of the symbol, reconnecting when the stream
+// is done. The snapshot is requested after the first increment is received, so the
+// increments following it are never missed; a reconnect also resyncs the book.
+func (w *Worker) SubscribeOrderBook(symbol string) {
+	topic := "/market/level2:" + symbol
+
+	for ; ; <-time.Tick(w.requestInterval) {
+		lastSequence := int64(-1)
+		err := w.wsServe([]string{topic}, func(message *wsMessage) error {
+			var data level2Data
+			if err := json.Unmarshal(message.Data, &data); err != nil {
+				return errors.Wrapf(err, "could not unmarshal increment")
+			}
+
+			if lastSequence < 0 {
+				sequence, err := w.loadSnapshot(symbol)
+				if err != nil {
+					return err
+				}
+				lastSequence = sequence
+			}
+
+			if data.SequenceEnd <= lastSequence {
+				return nil
+			}
+			if data.SequenceStart > lastSequence+1 {
+				return errSequenceGap
+			}
+
+			w.streams.Track(topic, data.SequenceEnd, w.clock.Adjust(data.Time))
+			w.updateOrderBook(symbol, data, lastSequence)
+			lastSequence = data.SequenceEnd
+			return nil
+		})
+		if err == errSequenceGap {
+			atomic.AddInt64(&w.resyncs, 1)
+		}
+		w.log.Warnf("KuCoin level2 stream of symbol %v is done: %v", symbol, err)
+	}
+}
+
+// loadSnapshot replaces the order book of the symbol with the snapshot and returns its sequence.
+func (w *Worker) loadSnapshot(symbol string) (int64, error) {
+	var snapshot depthSnapshot
+	if err := getJSON(fmt.Sprintf(depthURL, symbol), &snapshot); err != nil {
+		return 0, errors.Wrapf(err, "could not get order book")
+	}
+
+	sequence, err := strconv.ParseInt(snapshot.Sequence, 10, 64)
+	if err != nil {
+		return 0, errors.Wrapf(err, "could not parse order book sequence %v", snapshot.Sequence)
+	}
+
+	orderBook := models.OrderBookInternal{
+		LastUpdateID: sequence,
+		Bids:         make(map[string]string, len(snapshot.Bids)),
+		Asks:         make(map[string]string, len(snapshot.Asks)),
+	}
+	for _, side := range []struct {
+		levels   map[string]string
+		snapshot [][]string
+	}{{orderBook.Bids, snapshot.Bids}, {orderBook.Asks, snapshot.Asks}} {
+		for _, v := range side.snapshot {
+			if len(v) >= 2 {
+				side.levels[v[0]] = v[1]
+			}
+		}
+	}
+
+	canonical := w.subscriptions.Canonical(symbol)
+
+	w.orderBookCacheMu.Lock()
+	defer w.orderBookCacheMu.Unlock()
+
+	w.orderBookCache[canonical] = orderBook
+	w.publishBBO(canonical)
+
+	return sequence, nil
+}
+
+// updateOrderBook applies the changes newer than the last sequence. A zero size removes
+// the level, a zero price only advances the sequence.
+func (w *Worker) updateOrderBook(symbol string, update level2Data, lastSequence int64) {
+	w.orderBookCacheMu.Lock()
+	defer w.orderBookCacheMu.Unlock()
+
+	canonical := w.subscriptions.Canonical(symbol)
+
+	orderBook := w.orderBookCache[canonical]
+	for _, side := range []struct {
+		levels  map[string]string
+		changes [][]string
+	}{{orderBook.Bids, update.Changes.Bids}, {orderBook.Asks, update.Changes.Asks}} {
+		for _, v := range side.changes {
+			if len(v) < 3 {
+				continue
+			}
+
+			if sequence, err := strconv.ParseInt(v[2], 10, 64); err != nil || sequence <= lastSequence {
+				continue
+			}
+
+			if price, err := strconv.ParseFloat(v[0], 64); err != nil || price == 0 {
+				continue
+			}
+
+			if size, err := strconv.ParseFloat(v[1], 64); err != nil || size == 0 {
+				delete(side.levels, v[0])
+				continue
+			}
+			side.levels[v[0]] = v[1]
+		}
+	}
+	orderBook.LastUpdateID = update.SequenceEnd
+
+	w.orderBookCache[canonical] = orderBook
+	w.publishBBO(canonical)
+}
+
+// publishBBO pushes the best bid and offer of the symbol to the hub subscribers.
+// It must be called with orderBookCacheMu held.
+func (w *Worker) publishBBO(symbol string) {
+	channel := "bbo." + symbol
+	if !w.hub.HasSubscribers(channel) {
+		return
+	}
+
+	orderBook := w.orderBookCache[symbol]
+	bbo := orderBook.BestBidOffer()
+	bbo.Exchange = "kucoin"
+	bbo.Symbol = symbol
+	w.hub.Publish(channel, bbo)
+}
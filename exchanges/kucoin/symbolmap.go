@@ -0,0 +1,57 @@
+package kucoin
+
+import (
+	"strings"
+
+	"price-feed/models"
+)
+
+// fillSymbolMap fetches the KuCoin markets and maps every subscribed symbol to
+// the canonical format. Symbols which can't be mapped are dropped, so they never
+// end up in the storage keys.
+func (w *Worker) fillSymbolMap() {
+	markets, err := fetchMarkets()
+	if err != nil {
+		w.log.Warnf("Could not get KuCoin markets, mapping symbols by name: %v", err)
+	}
+	w.markets = markets
+
+	w.subscriptions.MapSymbols()
+}
+
+// mapSymbol derives the canonical symbol from the KuCoin market, unless it is
+// overridden in the config.
+func (w *Worker) mapSymbol(symbol string) (string, bool) {
+	if v, ok := w.config.SymbolMap[symbol]; ok {
+		return v, true
+	}
+
+	if w.markets != nil {
+		market, ok := w.markets[symbol]
+		if !ok {
+			return "", false
+		}
+		return models.CanonicalSymbol(market.BaseCurrency, market.QuoteCurrency, w.config.AssetAliases), true
+	}
+
+	// KuCoin symbols are BASE-QUOTE.
+	parts := strings.Split(symbol, "-")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", false
+	}
+	return models.CanonicalSymbol(parts[0], parts[1], w.config.AssetAliases), true
+}
+
+// fetchMarkets returns the KuCoin markets by symbol.
+func fetchMarkets() (map[string]market, error) {
+	var markets []market
+	if err := getJSON(symbolsURL, &markets); err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]market, len(markets))
+	for _, v := range markets {
+		result[v.Symbol] = v
+	}
+	return result, nil
+}
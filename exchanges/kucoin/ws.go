@@ -0,0 +1,123 @@
+package kucoin
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
+	"price-feed/exchanges/common"
+)
+
+const (
+	bulletURL = "https://api.kucoin.com/api/v1/bullet-public"
+	// wsPongTimeout is used unless configured or set by the server.
+	wsPongTimeout = time.Minute
+)
+
+// wsKeepalive is the default keepalive, empty as the server sends its own settings
+// with the connection token, which are used unless configured.
+var wsKeepalive = common.Keepalive{}
+
+// bullet represents the token and the servers of a public WS connection.
+type bullet struct {
+	Token           string `json:"token"`
+	InstanceServers []struct {
+		Endpoint     string `json:"endpoint"`
+		PingInterval int64  `json:"pingInterval"`
+		PingTimeout  int64  `json:"pingTimeout"`
+	} `json:"instanceServers"`
+}
+
+type wsRequest struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Topic    string `json:"topic,omitempty"`
+	Response bool   `json:"response,omitempty"`
+}
+
+// wsMessage represents either a control message, e.g. a welcome or an ack, or a data push.
+type wsMessage struct {
+	ID      string          `json:"id"`
+	Type    string          `json:"type"`
+	Topic   string          `json:"topic"`
+	Subject string          `json:"subject"`
+	Code    json.Number     `json:"code"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// wsServe requests a connection token, subscribes to the topics once the server welcomes
+// the connection and passes the data pushes to the handler until the connection fails
+// or the handler returns an error.
+func (w *Worker) wsServe(topics []string, handler func(message *wsMessage) error) error {
+	var b bullet
+	if err := request(http.MethodPost, bulletURL, &b); err != nil {
+		return errors.Wrapf(err, "could not get WS token")
+	}
+	if len(b.InstanceServers) == 0 {
+		return errors.New("no WS servers received")
+	}
+	server := b.InstanceServers[0]
+
+	connectID := strconv.FormatInt(time.Now().UnixNano(), 10)
+	dialer := websocket.Dialer{HandshakeTimeout: w.handshakeTimeout}
+	conn, _, err := dialer.Dial(server.Endpoint+"?token="+b.Token+"&connectId="+connectID, nil)
+	if err != nil {
+		return errors.Wrapf(err, "could not connect")
+	}
+	defer conn.Close()
+
+	keepalive := w.keepalive
+	if keepalive.PingInterval == 0 {
+		keepalive.PingInterval = time.Duration(server.PingInterval) * time.Millisecond
+	}
+	if keepalive.PongTimeout == 0 {
+		keepalive.PongTimeout = time.Duration(server.PingTimeout) * time.Millisecond
+	}
+	if keepalive.PongTimeout == 0 {
+		keepalive.PongTimeout = wsPongTimeout
+	}
+
+	stop := func() {}
+	defer func() { stop() }()
+
+	for {
+		if err = keepalive.ExtendDeadline(conn); err != nil {
+			return err
+		}
+
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+
+		var message wsMessage
+		if err = json.Unmarshal(data, &message); err != nil {
+			return errors.Wrapf(err, "could not unmarshal message")
+		}
+
+		switch message.Type {
+		case "welcome":
+			for i, topic := range topics {
+				request := wsRequest{ID: strconv.Itoa(i), Type: "subscribe", Topic: topic, Response: true}
+				if err = conn.WriteJSON(request); err != nil {
+					return errors.Wrapf(err, "could not subscribe")
+				}
+			}
+
+			// The pings are sent only after the subscriptions, so there is a single writer.
+			stop()
+			stop = keepalive.Start(conn, func() error {
+				return conn.WriteJSON(wsRequest{ID: strconv.FormatInt(time.Now().UnixNano(), 10), Type: "ping"})
+			})
+		case "error":
+			return errors.Errorf("received error %v: %s", message.Code, message.Data)
+		case "message":
+			if err = handler(&message); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package okx
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"price-feed/models"
+	"price-feed/pipeline"
+)
+
+// SubscribeCandlestickAll streams the candles of all intervals of the symbol
+// over one connection, reconnecting when the stream is done.
+func (w *Worker) SubscribeCandlestickAll(symbol string) {
+	args := make([]wsArg, 0, len(candleChannels))
+	for _, bar := range candleBars {
+		args = append(args, wsArg{Channel: "candle" + bar, InstID: symbol})
+	}
+
+	for ; ; <-time.Tick(w.requestInterval) {
+		err := w.wsServe(businessWsURL, args, func(message *wsMessage) error {
+			interval, ok := candleChannels[strings.TrimPrefix(message.Arg.Channel, "candle")]
+			if !ok {
+				return nil
+			}
+
+			var candles [][]string
+			if err := json.Unmarshal(message.Data, &candles); err != nil {
+				return errors.Wrapf(err, "could not unmarshal candles")
+			}
+
+			for _, v := range candles {
+				candle, _, err := parseCandle(v, interval, models.CandleSourceWS)
+				if err != nil {
+					w.log.Errorf("Could not parse OKX candle of symbol %v: %v", symbol, err)
+					continue
+				}
+
+				ts, _ := strconv.ParseInt(v[0], 10, 64)
+				w.streams.Track(symbol+"@"+message.Arg.Channel, 0, ts)
+				w.pipeline.Process(&pipeline.CandleEvent{
+					Exchange: "okx",
+					Symbol:   w.subscriptions.Canonical(symbol),
+					Interval: interval,
+					Candle:   *candle,
+					Received: time.Now(),
+				})
+			}
+			return nil
+		})
+		w.log.Warnf("OKX candle stream of symbol %v is done: %v", symbol, err)
+	}
+}
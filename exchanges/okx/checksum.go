@@ -0,0 +1,81 @@
+package okx
+
+import (
+	"hash/crc32"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"price-feed/models"
+	"price-feed/status"
+)
+
+// checksumLevels is the number of the best levels of each side covered by the checksum.
+const checksumLevels = 25
+
+var errChecksumMismatch = errors.New("order book checksum mismatch")
+
+// checksum returns the CRC32 of the best levels of the order book interleaved as
+// bid price:bid size:ask price:ask size, which OKX pushes with every book update.
+// The levels keep the strings received from OKX, so they hash the same.
+func checksum(orderBook models.OrderBookInternal) int32 {
+	bids := sortedLevels(orderBook.Bids, func(a, b float64) bool { return a > b })
+	asks := sortedLevels(orderBook.Asks, func(a, b float64) bool { return a < b })
+
+	parts := make([]string, 0, 4*checksumLevels)
+	for i := 0; i < checksumLevels; i++ {
+		if i < len(bids) {
+			parts = append(parts, bids[i][0], bids[i][1])
+		}
+		if i < len(asks) {
+			parts = append(parts, asks[i][0], asks[i][1])
+		}
+	}
+	return int32(crc32.ChecksumIEEE([]byte(strings.Join(parts, ":"))))
+}
+
+// sortedLevels returns the price and size of the levels in the order of better.
+func sortedLevels(side map[string]string, better func(a, b float64) bool) [][2]string {
+	type level struct {
+		price float64
+		key   string
+		size  string
+	}
+
+	sorted := make([]level, 0, len(side))
+	for k, v := range side {
+		price, err := strconv.ParseFloat(k, 64)
+		if err != nil {
+			continue
+		}
+		sorted = append(sorted, level{price: price, key: k, size: v})
+	}
+
+	sort.Slice(sorted, func(i, j int) bool {
+		return better(sorted[i].price, sorted[j].price)
+	})
+
+	levels := make([][2]string, len(sorted))
+	for i, v := range sorted {
+		levels[i] = [2]string{v.key, v.size}
+	}
+	return levels
+}
+
+// verifyChecksum compares the checksum of the order book of the symbol to the pushed one.
+func (w *Worker) verifyChecksum(symbol string, expected int32) bool {
+	canonical := w.subscriptions.Canonical(symbol)
+
+	w.orderBookCacheMu.Lock()
+	valid := checksum(w.orderBookCache[canonical]) == expected
+	w.orderBookCacheMu.Unlock()
+
+	w.checksums.Record(canonical, valid)
+	return valid
+}
+
+// Checksums returns the order book checksum verifications by symbol.
+func (w *Worker) Checksums() (checksums map[string]status.Checksum, ok bool) {
+	return w.checksums.All(), true
+}
@@ -0,0 +1,60 @@
+package okx
+
+import (
+	"strconv"
+	"testing"
+
+	"price-feed/models"
+)
+
+func TestChecksum(t *testing.T) {
+	// deep has more levels than the checksum covers, the levels beyond the best 25 are left out.
+	deep := models.OrderBookInternal{Bids: make(map[string]string), Asks: make(map[string]string)}
+	for i := 0; i < 30; i++ {
+		deep.Bids[strconv.Itoa(100-i)] = "1"
+		deep.Asks[strconv.Itoa(200+i)] = "2"
+	}
+
+	tests := []struct {
+		name      string
+		orderBook models.OrderBookInternal
+		want      int32
+	}{
+		{
+			// The example of the OKX docs, hashed as 3366.1:7:3366.8:9:3366:6:3368:8.
+			name: "sides of the same depth",
+			orderBook: models.OrderBookInternal{
+				Bids: map[string]string{"3366.1": "7", "3366": "6"},
+				Asks: map[string]string{"3366.8": "9", "3368": "8"},
+			},
+			want: -1881014294,
+		},
+		{
+			// The example of the OKX docs, hashed as 3366.1:7:3366.8:9:3368:8:3372:8.
+			name: "sides of different depths",
+			orderBook: models.OrderBookInternal{
+				Bids: map[string]string{"3366.1": "7"},
+				Asks: map[string]string{"3366.8": "9", "3368": "8", "3372": "8"},
+			},
+			want: 831078360,
+		},
+		{
+			name:      "more levels than covered",
+			orderBook: deep,
+			want:      120246431,
+		},
+		{
+			name:      "empty book",
+			orderBook: models.OrderBookInternal{},
+			want:      0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := checksum(tt.orderBook); got != tt.want {
+				t.Errorf("checksum = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,304 @@
+package okx
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"price-feed/exchanges/common"
+	"price-feed/hub"
+	"price-feed/logger"
+	"price-feed/models"
+	"price-feed/pipeline"
+	"price-feed/status"
+	"price-feed/warmup"
+)
+
+const (
+	timeURL          = "https://www.okx.com/api/v5/public/time"
+	instrumentsURL   = "https://www.okx.com/api/v5/public/instruments?instType=SPOT"
+	candlesURL       = "https://www.okx.com/api/v5/market/candles?instId=%s&bar=%s&limit=%d"
+	candlestickLimit = 300
+)
+
+// candleChannels maps the OKX candle bars to the Binance intervals.
+var candleChannels = map[string]string{
+	"1m":  "1m",
+	"5m":  "5m",
+	"15m": "15m",
+	"30m": "30m",
+	"1H":  "1h",
+	"2H":  "2h",
+	"4H":  "4h",
+	"6H":  "6h",
+	"12H": "12h",
+	"1D":  "1d",
+	"1W":  "1w",
+	"1M":  "1M",
+}
+
+// candleBars lists the bars of candleChannels from the shortest interval.
+var candleBars = []string{"1m", "5m", "15m", "30m", "1H", "2H", "4H", "6H", "12H", "1D", "1W", "1M"}
+
+// Config represents an OKX worker configuration.
+type Config struct {
+	// WsTimeout is the WS handshake timeout.
+	WsTimeout       string   `json:"ws_timeout"`
+	RequestInterval string   `json:"request_interval"`
+	Blacklist       []string `json:"blacklist"`
+	// PingInterval and PongTimeout override the WS keepalive of the exchange, see common.Keepalive.
+	PingInterval string `json:"ping_interval"`
+	PongTimeout  string `json:"pong_timeout"`
+	// WarmupConcurrency limits the candle backfill requests running at once, 4 by default.
+	WarmupConcurrency int `json:"warmup_concurrency"`
+	// SymbolMap overrides the canonical symbols derived from the instruments.
+	SymbolMap map[string]string `json:"symbol_map"`
+	// AssetAliases renames assets to their Binance names.
+	AssetAliases map[string]string `json:"asset_aliases"`
+	// Books5 makes the worker keep only the top 5 levels of the order books,
+	// which are pushed as snapshots, instead of maintaining the full books.
+	Books5 bool `json:"books5"`
+}
+
+// Worker maintains the order books and the candles of the OKX spot instruments.
+type Worker struct {
+	config           *Config
+	log              *logger.Logger
+	database         common.CandleStore
+	hub              *hub.Hub
+	handshakeTimeout time.Duration
+	keepalive        common.Keepalive
+	requestInterval  time.Duration
+	subscriptions    *common.Subscriptions
+	markets          map[string]instrument
+	orderBookCacheMu sync.Mutex
+	orderBookCache   map[string]models.OrderBookInternal
+	quit             chan os.Signal
+	streams          *status.Tracker
+	warmup           *warmup.Scheduler
+	clock            *status.Clock
+	symbolStatuses   *status.SymbolStatuses
+	checksums        *status.Checksums
+	pipeline         *pipeline.Pipeline
+	resyncs          int64
+}
+
+// instrument represents a spot instrument of the public API.
+type instrument struct {
+	InstID   string `json:"instId"`
+	BaseCcy  string `json:"baseCcy"`
+	QuoteCcy string `json:"quoteCcy"`
+	State    string `json:"state"`
+}
+
+// NewWorker returns a new OKX worker.
+func NewWorker(config *Config, log *logger.Logger, database common.CandleStore, hub *hub.Hub, quit chan os.Signal) (*Worker, error) {
+	wsTimeout, err := time.ParseDuration(config.WsTimeout)
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldn't parse OKX WS timeout")
+	}
+
+	keepalive, err := common.ParseKeepalive(config.PingInterval, config.PongTimeout, wsKeepalive)
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldn't parse OKX WS keepalive")
+	}
+
+	interval, err := time.ParseDuration(config.RequestInterval)
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldn't parse OKX request interval")
+	}
+
+	w := &Worker{
+		config:           config,
+		log:              log,
+		database:         database,
+		hub:              hub,
+		handshakeTimeout: wsTimeout,
+		keepalive:        keepalive,
+		requestInterval:  interval,
+		orderBookCache:   make(map[string]models.OrderBookInternal),
+		quit:             quit,
+		streams:          status.NewTracker(),
+		warmup:           warmup.New("OKX", config.WarmupConcurrency, log),
+		clock:            status.NewClock(time.Millisecond),
+		symbolStatuses:   status.NewSymbolStatuses(),
+		checksums:        status.NewChecksums(),
+	}
+	w.subscriptions = common.NewSubscriptions("okx", "OKX", models.ExcludeSymbols(models.OKXSymbols, config.Blacklist), database, log, w.mapSymbol)
+	w.pipeline = pipeline.New(log, pipeline.Validate(), pipeline.Publish(hub), pipeline.Persist(database, false))
+
+	if err = w.subscriptions.Restore(config.Blacklist); err != nil {
+		return nil, errors.Wrapf(err, "couldn't restore OKX subscriptions")
+	}
+
+	w.fillSymbolMap()
+
+	return w, nil
+}
+
+// Streams returns the last event status of every stream.
+func (w *Worker) Streams() []status.Stream {
+	return w.streams.Streams()
+}
+
+// fetchStatuses returns the trading statuses of the listed OKX instruments.
+func (w *Worker) fetchStatuses() (map[string]string, error) {
+	markets, err := w.fetchMarkets()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make(map[string]string, len(markets))
+	for symbol, v := range markets {
+		if v.State == "live" {
+			statuses[symbol] = models.SymbolStatusTrading
+		} else {
+			statuses[symbol] = models.SymbolStatusBreak
+		}
+	}
+	return statuses, nil
+}
+
+// SymbolStatuses returns the trading status of every symbol in the Binance format.
+func (w *Worker) SymbolStatuses() map[string]string {
+	return w.symbolStatuses.All()
+}
+
+// ClockSkew returns the last measured skew of the OKX clock.
+func (w *Worker) ClockSkew() time.Duration {
+	return w.clock.Skew()
+}
+
+// Symbols returns the symbols the worker is subscribed to in the Binance format.
+func (w *Worker) Symbols() []string {
+	return w.subscriptions.Symbols()
+}
+
+// AddSymbol subscribes to the symbol at runtime and persists the subscription,
+// so it is restored on startup.
+func (w *Worker) AddSymbol(symbol string) error {
+	return w.subscriptions.Subscribe(symbol, w.subscribe)
+}
+
+// Start starts a new OKX worker.
+func (w *Worker) Start() {
+	go w.subscriptions.WatchStatuses(w.symbolStatuses, w.fetchStatuses)
+	go common.WatchClock("OKX", w.clock, serverTime, w.log)
+
+	for _, symbol := range w.subscriptions.List() {
+		w.subscribe(symbol)
+	}
+}
+
+// subscribe starts the order book and candlestick streams of the symbol.
+func (w *Worker) subscribe(symbol string) {
+	go w.SubscribeOrderBook(symbol)
+	go func() {
+		w.initCandlesticksAll(symbol)
+		w.SubscribeCandlestickAll(symbol)
+	}()
+}
+
+func (w *Worker) Reload() {
+	for _, symbol := range w.subscriptions.List() {
+		for _, v := range candleBars {
+			symbol, bar := symbol, v
+			w.warmup.Submit(func() {
+				w.initCandlesticks(symbol, bar)
+			})
+		}
+	}
+	w.log.Infof("OKX cache reloaded")
+}
+
+// initCandlesticksAll backfills the candles of all intervals of the symbol one by one.
+func (w *Worker) initCandlesticksAll(symbol string) {
+	for _, bar := range candleBars {
+		bar := bar
+		w.warmup.Run(func() {
+			w.initCandlesticks(symbol, bar)
+		})
+	}
+}
+
+func (w *Worker) initCandlesticks(symbol, bar string) {
+	var response struct {
+		Data [][]string `json:"data"`
+	}
+
+	if err := common.GetJSON(fmt.Sprintf(candlesURL, symbol, bar, candlestickLimit), &response); err != nil {
+		w.log.Errorf("Could not load candlesticks from OKX REST API with interval %v and symbol %v: %v",
+			bar, symbol, err)
+
+		return
+	}
+
+	candles := make([]models.Candle, 0, len(response.Data))
+	for _, v := range response.Data {
+		candle, _, err := parseCandle(v, candleChannels[bar], models.CandleSourceREST)
+		if err != nil {
+			w.log.Errorf("Could not parse OKX candle of symbol %v: %v", symbol, err)
+			continue
+		}
+
+		candles = append(candles, *candle)
+	}
+	w.storeCandlesticks(symbol, candleChannels[bar], candles)
+}
+
+// storeCandlesticks stores the backfilled candles in batches.
+func (w *Worker) storeCandlesticks(symbol, interval string, candles []models.Candle) {
+	if err := w.database.StoreCandlesticks("okx", w.subscriptions.Canonical(symbol), interval, candles); err != nil {
+		w.log.Errorf("Could not store candlesticks to database: %v", err)
+	}
+}
+
+// parseCandle converts an OKX candle, [ts, o, h, l, c, vol, volCcy, volCcyQuote, confirm],
+// and reports whether it is closed.
+func parseCandle(v []string, interval, source string) (*models.Candle, bool, error) {
+	if len(v) < 9 {
+		return nil, false, fmt.Errorf("candle has %v fields", len(v))
+	}
+
+	ts, err := strconv.ParseInt(v[0], 10, 64)
+	if err != nil {
+		return nil, false, errors.Wrapf(err, "could not parse candle time %v", v[0])
+	}
+
+	length := int64(models.IntervalDuration(interval).Seconds())
+	candle := &models.Candle{
+		TimeStart: ts / 1000,
+		TimeEnd:   ts/1000 + length - 1,
+		Time:      time.Now().Unix(),
+		Source:    source,
+	}
+
+	for i, dst := range []*float64{&candle.Open, &candle.High, &candle.Low, &candle.Close, &candle.Volume} {
+		if *dst, err = strconv.ParseFloat(v[i+1], 64); err != nil {
+			return nil, false, errors.Wrapf(err, "could not parse candle of %v", ts)
+		}
+	}
+
+	return candle, v[8] == "1", nil
+}
+
+// serverTime returns the time of the OKX server.
+func serverTime() (time.Time, error) {
+	var response struct {
+		Data []struct {
+			Ts string `json:"ts"`
+		} `json:"data"`
+	}
+
+	if err := common.GetJSON(timeURL, &response); err != nil {
+		return time.Time{}, err
+	}
+	if len(response.Data) == 0 {
+		return time.Time{}, fmt.Errorf("no server time received")
+	}
+
+	return common.ParseServerTime(response.Data[0].Ts, time.Millisecond)
+}
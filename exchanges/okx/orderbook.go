@@ -0,0 +1,146 @@
+package okx
+
+import (
+	"encoding/json"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"price-feed/models"
+)
+
+var errSequenceGap = errors.New("gap in sequence numbers")
+
+// book represents an order book push. The levels are [price, size, deprecated, orders].
+type book struct {
+	Asks      [][]string `json:"asks"`
+	Bids      [][]string `json:"bids"`
+	Ts        string     `json:"ts"`
+	SeqID     int64      `json:"seqId"`
+	PrevSeqID int64      `json:"prevSeqId"`
+	// Checksum is the CRC32 of the best 25 levels of the book after the push.
+	Checksum *int32 `json:"checksum"`
+}
+
+// GetOrderBook returns the order book of the symbol in the Binance format.
+func (w *Worker) GetOrderBook(symbol string) (models.OrderBookInternal, bool) {
+	w.orderBookCacheMu.Lock()
+	defer w.orderBookCacheMu.Unlock()
+
+	ob, ok := w.orderBookCache[symbol]
+	return ob, ok
+}
+
+// Resyncs returns the number of order book snapshots requested after a sequence gap
+// or a checksum mismatch.
+func (w *Worker) Resyncs() int64 {
+	return atomic.LoadInt64(&w.resyncs)
+}
+
+// ChaosStats is not supported by OKX.
+func (w *Worker) ChaosStats() (faults map[string]int64, violations int64, ok bool) {
+	return nil, 0, false
+}
+
+// BookDrift is not supported by OKX.
+func (w *Worker) BookDrift() (drifts map[string]float64, corrections int64, ok bool) {
+	return nil, 0, false
+}
+
+// Reconnects is not supported by OKX.
+func (w *Worker) Reconnects() (outcomes map[string]map[string]int64, ok bool) {
+	return nil, false
+}
+
+// SubscribeOrderBook maintains the order book of the symbol, reconnecting when the stream
+// is done. Every subscription starts with a snapshot, so a reconnect also resyncs the book
+// after a sequence gap or a checksum mismatch.
+func (w *Worker) SubscribeOrderBook(symbol string) {
+	channel := "books"
+	if w.config.Books5 {
+		channel = "books5"
+	}
+
+	for ; ; <-time.Tick(w.requestInterval) {
+		var lastSeqID int64
+		err := w.wsServe(publicWsURL, []wsArg{{Channel: channel, InstID: symbol}}, func(message *wsMessage) error {
+			var books []book
+			if err := json.Unmarshal(message.Data, &books); err != nil {
+				return errors.Wrapf(err, "could not unmarshal books")
+			}
+
+			for _, v := range books {
+				// books5 pushes are snapshots, while books are updates unless told otherwise.
+				snapshot := channel == "books5" || message.Action == "snapshot"
+				if !snapshot && v.PrevSeqID != lastSeqID {
+					return errSequenceGap
+				}
+				lastSeqID = v.SeqID
+
+				ts, _ := strconv.ParseInt(v.Ts, 10, 64)
+				w.streams.Track(symbol+"@"+channel, v.SeqID, w.clock.Adjust(ts))
+				w.updateOrderBook(symbol, v, snapshot)
+
+				if v.Checksum != nil && !w.verifyChecksum(symbol, *v.Checksum) {
+					return errChecksumMismatch
+				}
+			}
+			return nil
+		})
+		if err == errSequenceGap || err == errChecksumMismatch {
+			atomic.AddInt64(&w.resyncs, 1)
+		}
+		w.log.Warnf("OKX %v stream of symbol %v is done: %v", channel, symbol, err)
+	}
+}
+
+func (w *Worker) updateOrderBook(symbol string, update book, snapshot bool) {
+	w.orderBookCacheMu.Lock()
+	defer w.orderBookCacheMu.Unlock()
+
+	canonical := w.subscriptions.Canonical(symbol)
+
+	orderBook, ok := w.orderBookCache[canonical]
+	if snapshot || !ok {
+		orderBook = models.OrderBookInternal{
+			Bids: make(map[string]string),
+			Asks: make(map[string]string),
+		}
+	}
+
+	for _, side := range []struct {
+		levels  map[string]string
+		updates [][]string
+	}{{orderBook.Bids, update.Bids}, {orderBook.Asks, update.Asks}} {
+		for _, v := range side.updates {
+			if len(v) < 2 {
+				continue
+			}
+
+			if size, err := strconv.ParseFloat(v[1], 64); err != nil || size == 0 {
+				delete(side.levels, v[0])
+				continue
+			}
+			side.levels[v[0]] = v[1]
+		}
+	}
+
+	w.orderBookCache[canonical] = orderBook
+	w.publishBBO(canonical)
+}
+
+// publishBBO pushes the best bid and offer of the symbol to the hub subscribers.
+// It must be called with orderBookCacheMu held.
+func (w *Worker) publishBBO(symbol string) {
+	channel := "bbo." + symbol
+	if !w.hub.HasSubscribers(channel) {
+		return
+	}
+
+	orderBook := w.orderBookCache[symbol]
+	bbo := orderBook.BestBidOffer()
+	bbo.Exchange = "okx"
+	bbo.Symbol = symbol
+	w.hub.Publish(channel, bbo)
+}
@@ -0,0 +1,60 @@
+package okx
+
+import (
+	"strings"
+
+	"price-feed/exchanges/common"
+	"price-feed/models"
+)
+
+// fillSymbolMap fetches the OKX instruments and maps every subscribed symbol to
+// the canonical format. Symbols which can't be mapped are dropped, so they never
+// end up in the storage keys.
+func (w *Worker) fillSymbolMap() {
+	markets, err := w.fetchMarkets()
+	if err != nil {
+		w.log.Warnf("Could not get OKX instruments, mapping symbols by name: %v", err)
+	}
+	w.markets = markets
+
+	w.subscriptions.MapSymbols()
+}
+
+// mapSymbol derives the canonical symbol from the OKX instrument, unless it is
+// overridden in the config.
+func (w *Worker) mapSymbol(symbol string) (string, bool) {
+	if v, ok := w.config.SymbolMap[symbol]; ok {
+		return v, true
+	}
+
+	if w.markets != nil {
+		market, ok := w.markets[symbol]
+		if !ok {
+			return "", false
+		}
+		return models.CanonicalSymbol(market.BaseCcy, market.QuoteCcy, w.config.AssetAliases), true
+	}
+
+	// OKX instrument IDs are BASE-QUOTE.
+	parts := strings.Split(symbol, "-")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", false
+	}
+	return models.CanonicalSymbol(parts[0], parts[1], w.config.AssetAliases), true
+}
+
+func (w *Worker) fetchMarkets() (map[string]instrument, error) {
+	var response struct {
+		Data []instrument `json:"data"`
+	}
+
+	if err := common.GetJSON(instrumentsURL, &response); err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]instrument, len(response.Data))
+	for _, v := range response.Data {
+		result[v.InstID] = v
+	}
+	return result, nil
+}
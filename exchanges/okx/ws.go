@@ -0,0 +1,89 @@
+package okx
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
+	"price-feed/exchanges/common"
+)
+
+const (
+	publicWsURL   = "wss://ws.okx.com:8443/ws/v5/public"
+	businessWsURL = "wss://ws.okx.com:8443/ws/v5/business"
+)
+
+// wsKeepalive is the default keepalive. OKX drops connections idle for 30 seconds,
+// so a ping is sent more often.
+var wsKeepalive = common.Keepalive{PingInterval: 25 * time.Second, PongTimeout: 35 * time.Second}
+
+type wsArg struct {
+	Channel string `json:"channel"`
+	InstID  string `json:"instId"`
+}
+
+type wsRequest struct {
+	Op   string  `json:"op"`
+	Args []wsArg `json:"args"`
+}
+
+// wsMessage represents either an event, e.g. a subscription confirmation, or a data push.
+type wsMessage struct {
+	Event  string          `json:"event"`
+	Code   string          `json:"code"`
+	Msg    string          `json:"msg"`
+	Arg    wsArg           `json:"arg"`
+	Action string          `json:"action"`
+	Data   json.RawMessage `json:"data"`
+}
+
+// wsServe subscribes to the channels and passes the data pushes to the handler
+// until the connection fails or the handler returns an error.
+func (w *Worker) wsServe(url string, args []wsArg, handler func(message *wsMessage) error) error {
+	dialer := websocket.Dialer{HandshakeTimeout: w.handshakeTimeout}
+	conn, _, err := dialer.Dial(url, nil)
+	if err != nil {
+		return errors.Wrapf(err, "could not connect")
+	}
+	defer conn.Close()
+
+	if err = conn.WriteJSON(wsRequest{Op: "subscribe", Args: args}); err != nil {
+		return errors.Wrapf(err, "could not subscribe")
+	}
+
+	defer w.keepalive.Start(conn, func() error {
+		return conn.WriteMessage(websocket.TextMessage, []byte("ping"))
+	})()
+
+	for {
+		if err = w.keepalive.ExtendDeadline(conn); err != nil {
+			return err
+		}
+
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+
+		if string(data) == "pong" {
+			continue
+		}
+
+		var message wsMessage
+		if err = json.Unmarshal(data, &message); err != nil {
+			return errors.Wrapf(err, "could not unmarshal message")
+		}
+
+		if message.Event == "error" {
+			return errors.Errorf("received error %v: %v", message.Code, message.Msg)
+		}
+		if message.Event != "" {
+			continue
+		}
+
+		if err = handler(&message); err != nil {
+			return err
+		}
+	}
+}
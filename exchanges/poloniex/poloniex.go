@@ -1,67 +1,201 @@
 package poloniex
 
 import (
+	"fmt"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/jyap808/go-poloniex"
 
+	"price-feed/exchanges/common"
+	"price-feed/hub"
 	"price-feed/logger"
 	"price-feed/models"
-	"price-feed/storage"
+	"price-feed/pipeline"
+	"price-feed/status"
+	"price-feed/warmup"
+)
+
+const (
+	tickerURL = "https://poloniex.com/public?command=returnTicker"
 )
 
 type Config struct {
 	RequestInterval string `json:"request_interval"`
+	// Blacklist lists symbols which are never subscribed to, in the Poloniex or the Binance format.
+	Blacklist []string `json:"blacklist"`
+	// WarmupConcurrency limits the candle backfill requests running at once, 4 by default.
+	WarmupConcurrency int `json:"warmup_concurrency"`
+	// SymbolMap overrides the canonical symbols derived from the markets.
+	SymbolMap map[string]string `json:"symbol_map"`
+	// AssetAliases renames assets to their Binance names, e.g. "USD": "USDT".
+	AssetAliases map[string]string `json:"asset_aliases"`
+	// Trades enables the polling of the trades of every symbol.
+	Trades bool `json:"trades"`
+	// Symbols holds per-symbol overrides.
+	Symbols map[string]*SymbolConfig `json:"symbols"`
+	// ClosedCandlesOnly makes the worker persist only closed candles,
+	// keeping the in-progress one under a separate key.
+	ClosedCandlesOnly bool `json:"closed_candles_only"`
+}
+
+// SymbolConfig overrides the exchange settings for a single symbol.
+type SymbolConfig struct {
+	RequestInterval string `json:"request_interval"`
 }
 
 type Worker struct {
 	config          *Config
 	log             *logger.Logger
-	database        *storage.Client
+	database        common.CandleStore
+	hub             *hub.Hub
 	requestInterval time.Duration
-	symbols         []string
+	symbolIntervals map[string]time.Duration
+	subscriptions   *common.Subscriptions
+	markets         map[string]bool
 	poloniex        *poloniex.Poloniex
 	quit            chan os.Signal
+	streams         *status.Tracker
+	warmup          *warmup.Scheduler
+	clock           *status.Clock
+	symbolStatuses  *status.SymbolStatuses
+	pipeline        *pipeline.Pipeline
 }
 
-func NewWorker(config *Config, log *logger.Logger, database *storage.Client, quit chan os.Signal) (*Worker, error) {
+func NewWorker(config *Config, log *logger.Logger, database common.CandleStore, hub *hub.Hub, quit chan os.Signal) (*Worker, error) {
 	interval, err := time.ParseDuration(config.RequestInterval)
 	if err != nil {
 		return nil, err
 	}
 
+	for _, v := range models.PoloniexCandlestickIntervalList {
+		if models.PoloniexIntervalToBinance(v) == "" {
+			return nil, fmt.Errorf("Poloniex period %v has no Binance interval", v)
+		}
+	}
+
+	var unsupported []string
+	for _, v := range models.BinanceCandlestickIntervalList {
+		_, native := models.BinanceIntervalToPoloniex(v)
+		_, resampled := models.PoloniexResampleSource(v)
+		if !native && !resampled {
+			unsupported = append(unsupported, v)
+		}
+	}
+	if len(unsupported) > 0 {
+		log.Infof("Poloniex has no candles of intervals %v", strings.Join(unsupported, ", "))
+	}
+
+	symbolIntervals := make(map[string]time.Duration, len(config.Symbols))
+	for symbol, v := range config.Symbols {
+		if v.RequestInterval == "" {
+			continue
+		}
+
+		if symbolIntervals[symbol], err = time.ParseDuration(v.RequestInterval); err != nil {
+			return nil, err
+		}
+	}
+
 	w := &Worker{
 		config:          config,
 		log:             log,
 		database:        database,
+		hub:             hub,
 		requestInterval: interval,
-		symbols:         models.PoloniexSymbols,
+		symbolIntervals: symbolIntervals,
 		poloniex:        poloniex.New("", ""),
 		quit:            quit,
+		streams:         status.NewTracker(),
+		warmup:          warmup.New("Poloniex", config.WarmupConcurrency, log),
+		clock:           status.NewClock(time.Second),
+		symbolStatuses:  status.NewSymbolStatuses(),
+	}
+	// The default symbols are in the format of the exchange, the blacklist may be in the Binance one.
+	blacklist := common.CanonicalBlacklist(models.PoloniexSymbols, config.Blacklist, w.mapSymbol)
+	w.subscriptions = common.NewSubscriptions("poloniex", "Poloniex", models.ExcludeSymbols(models.PoloniexSymbols, blacklist), database, log, w.mapSymbol)
+	w.pipeline = pipeline.New(log, pipeline.Validate(), pipeline.Publish(hub), pipeline.Persist(database, config.ClosedCandlesOnly))
+
+	if err = w.subscriptions.Restore(config.Blacklist); err != nil {
+		return nil, err
 	}
 
+	w.fillSymbolMap()
+
 	return w, nil
 }
 
+// Streams returns the last event status of every polled stream.
+func (w *Worker) Streams() []status.Stream {
+	return w.streams.Streams()
+}
+
+// SymbolStatuses returns the trading status of every symbol in the Binance format.
+func (w *Worker) SymbolStatuses() map[string]string {
+	return w.symbolStatuses.All()
+}
+
+// ClockSkew returns the last measured skew of the Poloniex clock.
+func (w *Worker) ClockSkew() time.Duration {
+	return w.clock.Skew()
+}
+
+// fetchStatuses returns the trading statuses of the listed Poloniex markets.
+func (w *Worker) fetchStatuses() (map[string]string, error) {
+	tickers, err := w.poloniex.GetTickers()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make(map[string]string, len(tickers))
+	for k, v := range tickers {
+		if v.IsFrozen == 0 {
+			statuses[k] = models.SymbolStatusTrading
+		} else {
+			statuses[k] = models.SymbolStatusBreak
+		}
+	}
+	return statuses, nil
+}
+
+// Symbols returns the symbols the worker is subscribed to in the Binance format.
+func (w *Worker) Symbols() []string {
+	return w.subscriptions.Symbols()
+}
+
 func (w *Worker) Start() {
-	for _, symbol := range w.symbols {
+	go w.subscriptions.WatchStatuses(w.symbolStatuses, w.fetchStatuses)
+	go common.WatchClock("Poloniex", w.clock, serverTime, w.log)
+
+	for _, symbol := range w.subscriptions.List() {
 		// go func(symbol string) {
 		// 	err := w.SubscribeOrderBook(symbol)
 		// 	if err != nil {
 		// 		w.log.Printf("Couldn't get diff depths on symbol %s: %v", symbol, err)
 		// 	}
 		// }(symbol)
-		go w.SubscribeCandlestickAll(symbol)
+		w.subscribe(symbol)
+	}
+}
+
+// subscribe starts polling the candlesticks and, if enabled, the trades of the symbol.
+func (w *Worker) subscribe(symbol string) {
+	go w.SubscribeCandlestickAll(symbol)
+
+	if w.config.Trades {
+		go w.SubscribeTrades(symbol)
 	}
 }
 
 func (w *Worker) Reload() {
-	for _, symbol := range w.symbols {
+	for _, symbol := range w.subscriptions.List() {
 		for _, v := range models.PoloniexCandlestickIntervalList {
-			go func(s int) {
-				w.initCandlesticks(symbol, s)
-			}(v)
+			symbol, interval := symbol, v
+			w.warmup.Submit(func() {
+				w.initCandlesticks(symbol, interval)
+			})
 		}
 	}
 	w.log.Infof("Poloniex cache reloaded")
@@ -70,13 +204,19 @@ func (w *Worker) Reload() {
 func (w *Worker) SubscribeCandlestickAll(symbol string) {
 	for _, v := range models.PoloniexCandlestickIntervalList {
 		go func(s int) {
-			w.initCandlesticks(symbol, s)
+			w.warmup.Run(func() {
+				w.initCandlesticks(symbol, s)
+			})
 
 			if err := w.SubscribeCandlestick(symbol, s); err != nil {
 				w.log.Errorf("Could not subscribe to candlestick interval %v symbol %v: %v", v, symbol, err)
 			}
 		}(v)
 	}
+
+	for _, v := range models.PoloniexResampledIntervalList() {
+		go w.SubscribeResampledCandlestick(symbol, v)
+	}
 }
 
 func (w *Worker) initCandlesticks(symbol string, interval int) {
@@ -88,33 +228,58 @@ func (w *Worker) initCandlesticks(symbol string, interval int) {
 		return
 	}
 
-	for _, k := range candlesticks {
-		if err := w.updateCandlestickAPI(symbol, interval, k); err != nil {
-			w.log.Errorf("Could not update candlesticks from REST API: %v", err)
-		}
+	candles := make([]models.Candle, 0, len(candlesticks))
+	for _, v := range candlesticks {
+		candles = append(candles, *models.CandleFromPoloniexApi(v))
 	}
+	w.storeCandlesticks(symbol, models.PoloniexIntervalToBinance(interval), candles)
 }
 
-func (w *Worker) updateCandlestickAPI(symbol string, interval int, candlestick *poloniex.CandleStick) error {
-	if err := w.database.StoreCandlestickPoloniexAPI(symbol, models.PoloniexIntervalToBinance(interval), candlestick); err != nil {
-		w.log.Errorf("Could not store candlestick from REST API to database: %v", err)
+// storeCandlesticks stores the backfilled candles in batches.
+func (w *Worker) storeCandlesticks(symbol, interval string, candles []models.Candle) {
+	if err := w.database.StoreCandlesticks("poloniex", w.subscriptions.Canonical(symbol), interval, candles); err != nil {
+		w.log.Errorf("Could not store candlesticks to database: %v", err)
 	}
-
-	return nil
 }
 
 func (w *Worker) SubscribeCandlestick(symbol string, interval int) error {
-	for ; ; <-time.Tick(w.requestInterval) {
-		candles, err := w.poloniex.ChartData(symbol, interval, time.Now().Add(-3*w.requestInterval), time.Now().Add(3*w.requestInterval))
+	requestInterval := w.symbolRequestInterval(symbol)
+	for ; ; <-time.Tick(requestInterval) {
+		candles, err := w.poloniex.ChartData(symbol, interval, time.Now().Add(-3*requestInterval), time.Now().Add(3*requestInterval))
 
 		if err != nil {
 			w.log.Errorf("Could not get latest tick on poloniex: %v", err)
 		}
 
-		for _, candle := range candles {
-			if err := w.updateCandlestickAPI(symbol, interval, candle); err != nil {
-				w.log.Errorf("Could not update candlesticks from REST API: %v", err)
-			}
+		now := time.Now()
+		// The candles carry their open time only, so the stream is tracked by the poll time.
+		if err == nil {
+			w.streams.Track(symbol+"@kline_"+strconv.Itoa(interval), 0, now.UnixNano()/int64(time.Millisecond))
+		}
+		binanceSymbol, binanceInterval := w.subscriptions.Canonical(symbol), models.PoloniexIntervalToBinance(interval)
+		for _, v := range candles {
+			candle := models.CandleFromPoloniexApi(v)
+			w.pipeline.Process(&pipeline.CandleEvent{
+				Exchange: "poloniex",
+				Symbol:   binanceSymbol,
+				Interval: binanceInterval,
+				Candle:   *candle,
+				Final:    models.CandleClosed(*candle, binanceInterval, now),
+				Received: now,
+			})
 		}
 	}
 }
+
+// symbolRequestInterval returns the request interval of the symbol.
+func (w *Worker) symbolRequestInterval(symbol string) time.Duration {
+	if interval, ok := w.symbolIntervals[symbol]; ok {
+		return interval
+	}
+	return w.requestInterval
+}
+
+// serverTime returns the time of the Poloniex server, which has no time endpoint.
+func serverTime() (time.Time, error) {
+	return status.ServerDate(tickerURL)
+}
@@ -0,0 +1,75 @@
+package poloniex
+
+import (
+	"sort"
+	"time"
+
+	"price-feed/models"
+)
+
+const (
+	// resampleBackfill is the number of candles of the interval built when the worker starts.
+	resampleBackfill = 30
+	// resampleLookback is the number of latest candles rebuilt on every poll, so the
+	// previous candle gets its final values once closed.
+	resampleLookback = 2
+)
+
+// SubscribeResampledCandlestick builds the candles of an interval Poloniex doesn't serve
+// from its longest period dividing the interval, starting with a backfill.
+func (w *Worker) SubscribeResampledCandlestick(symbol, interval string) {
+	period, ok := models.PoloniexResampleSource(interval)
+	if !ok {
+		return
+	}
+
+	backfill := true
+	for ; ; <-time.Tick(w.symbolRequestInterval(symbol)) {
+		count := resampleLookback
+		if backfill {
+			count = resampleBackfill
+		}
+
+		now := time.Now()
+		timeStart := models.IntervalStart(now.Add(-time.Duration(count-1)*models.IntervalDuration(interval)).Unix(), interval)
+		if err := w.resample(symbol, interval, period, time.Unix(timeStart, 0), now); err != nil {
+			w.log.Errorf("Could not resample Poloniex candles of symbol %v to interval %v: %v", symbol, interval, err)
+			continue
+		}
+		backfill = false
+	}
+}
+
+// resample loads the candles of the period within the range and stores the resampled ones.
+func (w *Worker) resample(symbol, interval string, period int, timeStart, timeEnd time.Time) error {
+	candlesticks, err := w.poloniex.ChartData(symbol, period, timeStart, timeEnd)
+	if err != nil {
+		return err
+	}
+
+	candles := make([]models.Candle, 0, len(candlesticks))
+	for _, v := range candlesticks {
+		candles = append(candles, *models.CandleFromPoloniexApi(v))
+	}
+	sort.Slice(candles, func(i, j int) bool {
+		return candles[i].TimeStart < candles[j].TimeStart
+	})
+
+	resampled := models.ResampleCandles(candles, interval)
+	if len(resampled) == 0 {
+		return nil
+	}
+
+	canonical := w.subscriptions.Canonical(symbol)
+	if err = w.database.StoreCandlesticks("poloniex", canonical, interval, resampled); err != nil {
+		return err
+	}
+
+	w.hub.Publish("kline."+interval+"."+canonical, models.CandleUpdate{
+		Exchange: "poloniex",
+		Symbol:   canonical,
+		Interval: interval,
+		Candle:   resampled[len(resampled)-1],
+	})
+	return nil
+}
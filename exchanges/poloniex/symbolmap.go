@@ -0,0 +1,52 @@
+package poloniex
+
+import (
+	"strings"
+
+	"price-feed/models"
+)
+
+// fillSymbolMap fetches the Poloniex markets and maps every subscribed symbol to
+// the canonical format. Symbols which can't be mapped are dropped, so they never
+// end up in the storage keys.
+func (w *Worker) fillSymbolMap() {
+	markets, err := w.fetchMarkets()
+	if err != nil {
+		w.log.Warnf("Could not get Poloniex markets, mapping symbols by name: %v", err)
+	}
+	w.markets = markets
+
+	w.subscriptions.MapSymbols()
+}
+
+// mapSymbol derives the canonical symbol from the Poloniex market, unless it is
+// overridden in the config.
+func (w *Worker) mapSymbol(symbol string) (string, bool) {
+	if v, ok := w.config.SymbolMap[symbol]; ok {
+		return v, true
+	}
+
+	if w.markets != nil && !w.markets[symbol] {
+		return "", false
+	}
+
+	// Poloniex currency pairs are QUOTE_BASE.
+	parts := strings.Split(symbol, "_")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", false
+	}
+	return models.CanonicalSymbol(parts[1], parts[0], w.config.AssetAliases), true
+}
+
+func (w *Worker) fetchMarkets() (map[string]bool, error) {
+	tickers, err := w.poloniex.GetTickers()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]bool, len(tickers))
+	for k := range tickers {
+		result[k] = true
+	}
+	return result, nil
+}
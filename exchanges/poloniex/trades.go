@@ -0,0 +1,99 @@
+package poloniex
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"price-feed/exchanges/common"
+	"price-feed/models"
+	"price-feed/pipeline"
+)
+
+const (
+	tradeHistoryURL  = "https://poloniex.com/public?command=returnTradeHistory&currencyPair=%s"
+	tradeHistoryTime = "2006-01-02 15:04:05"
+)
+
+// publicTrade represents a trade of the public Poloniex trade history.
+type publicTrade struct {
+	TradeID int64  `json:"tradeID"`
+	Date    string `json:"date"`
+	Type    string `json:"type"`
+	Rate    string `json:"rate"`
+	Amount  string `json:"amount"`
+}
+
+// SubscribeTrades polls the public trade history of the symbol and processes the new trades.
+// The vendored client only implements the private trade history, so it is requested directly.
+func (w *Worker) SubscribeTrades(symbol string) {
+	var seen common.TradeHistory
+	for ; ; <-time.Tick(w.symbolRequestInterval(symbol)) {
+		var history []publicTrade
+		if err := getJSON(fmt.Sprintf(tradeHistoryURL, symbol), &history); err != nil {
+			w.log.Errorf("Could not get Poloniex trade history of symbol %v: %v", symbol, err)
+			continue
+		}
+
+		canonical := w.subscriptions.Canonical(symbol)
+		trades := make([]models.Trade, 0, len(history))
+		for _, v := range history {
+			trade, err := v.toTrade(canonical)
+			if err != nil {
+				w.log.Errorf("Could not parse Poloniex trade %v: %v", v.TradeID, err)
+				continue
+			}
+			trade.Time = w.clock.Adjust(trade.Time)
+			trades = append(trades, trade)
+		}
+
+		received := time.Now()
+		for _, trade := range seen.New(trades) {
+			w.pipeline.Process(&pipeline.TradeEvent{Trade: trade, Received: received})
+		}
+	}
+}
+
+func (t publicTrade) toTrade(symbol string) (models.Trade, error) {
+	date, err := time.Parse(tradeHistoryTime, t.Date)
+	if err != nil {
+		return models.Trade{}, err
+	}
+
+	price, err := strconv.ParseFloat(t.Rate, 64)
+	if err != nil {
+		return models.Trade{}, err
+	}
+
+	quantity, err := strconv.ParseFloat(t.Amount, 64)
+	if err != nil {
+		return models.Trade{}, err
+	}
+
+	return models.Trade{
+		Exchange: "poloniex",
+		Symbol:   symbol,
+		ID:       strconv.FormatInt(t.TradeID, 10),
+		Price:    price,
+		Quantity: quantity,
+		Side:     strings.ToLower(t.Type),
+		Time:     date.UnixNano() / int64(time.Millisecond),
+	}, nil
+}
+
+func getJSON(url string, v interface{}) error {
+	resp, err := http.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%v received bad status code: %v", url, resp.StatusCode)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
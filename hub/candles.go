@@ -0,0 +1,29 @@
+package hub
+
+import (
+	"strings"
+
+	"price-feed/models"
+)
+
+const (
+	klineChannelPrefix  = "kline."
+	candleChannelPrefix = "candle."
+)
+
+// trackCandle remembers the last update of the kline series and, once an update of a later
+// candle arrives, publishes the previous candle as closed to candle.<interval>.<symbol>.
+func (h *Hub) trackCandle(channel string, update models.CandleUpdate) {
+	key := update.Exchange + ":" + channel
+
+	h.candlesMu.Lock()
+	last, ok := h.candles[key]
+	h.candles[key] = update
+	h.candlesMu.Unlock()
+
+	if !ok || update.TimeStart <= last.TimeStart {
+		return
+	}
+
+	h.Publish(candleChannelPrefix+strings.TrimPrefix(channel, klineChannelPrefix), last)
+}
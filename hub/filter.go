@@ -0,0 +1,85 @@
+package hub
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Pricer is implemented by the published data which carries a price,
+// so the clients can filter out insignificant changes.
+type Pricer interface {
+	Price() float64
+}
+
+// Filter limits the messages delivered to a client on each of its channels.
+type Filter struct {
+	// MinChange skips the messages whose price differs from the last delivered one
+	// by less than this fraction.
+	MinChange float64 `json:"minChange,omitempty"`
+	// MaxRate is the maximum number of messages per second. Zero means unlimited.
+	MaxRate float64 `json:"maxRate,omitempty"`
+}
+
+// Validate checks that the filter values are in range.
+func (f Filter) Validate() error {
+	if f.MinChange < 0 || f.MinChange >= 1 {
+		return fmt.Errorf("minChange should be in range [0; 1)")
+	}
+	if f.MaxRate < 0 {
+		return fmt.Errorf("maxRate should not be negative")
+	}
+	return nil
+}
+
+// channelState is the last message delivered to a client on a channel.
+type channelState struct {
+	price float64
+	sent  time.Time
+}
+
+// SetFilter replaces the filter of the client. It applies to the next published messages.
+func (c *Client) SetFilter(f Filter) error {
+	if err := f.Validate(); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.filter = f
+	c.states = make(map[string]*channelState)
+	return nil
+}
+
+// allow applies the client filter to a message on the channel and remembers it if it passes.
+func (c *Client) allow(channel string, data interface{}, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.filter == (Filter{}) {
+		return true
+	}
+
+	state, ok := c.states[channel]
+	if !ok {
+		state = &channelState{}
+		c.states[channel] = state
+	}
+
+	if c.filter.MaxRate > 0 && now.Sub(state.sent) < time.Duration(float64(time.Second)/c.filter.MaxRate) {
+		return false
+	}
+
+	var price float64
+	if p, ok := data.(Pricer); ok {
+		price = p.Price()
+		if c.filter.MinChange > 0 && state.price > 0 && math.Abs(price-state.price)/state.price < c.filter.MinChange {
+			return false
+		}
+	}
+
+	state.price = price
+	state.sent = now
+	return true
+}
@@ -0,0 +1,393 @@
+package hub
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"price-feed/logger"
+	"price-feed/models"
+	"price-feed/status"
+)
+
+const (
+	defaultQueueSize     = 256
+	defaultLatencyBudget = time.Second
+)
+
+// Config represents a hub configuration.
+type Config struct {
+	// QueueSize is the number of messages buffered per client
+	// before it is considered slow and evicted.
+	QueueSize int `json:"queue_size"`
+	// OverflowPolicy is applied when the queue of a client is full, one of disconnect,
+	// drop_oldest or conflate; disconnect by default.
+	OverflowPolicy string `json:"overflow_policy"`
+	// LatencySamples is the number of the last deliveries per symbol the latency
+	// percentiles are computed over, 1024 by default.
+	LatencySamples int `json:"latency_samples"`
+	// LatencyBudget is the end-to-end latency the deliveries are expected to meet, 1s by default.
+	LatencyBudget string `json:"latency_budget"`
+}
+
+// Message represents a payload pushed to the subscribers of a channel.
+type Message struct {
+	Channel string      `json:"channel"`
+	Data    interface{} `json:"data"`
+}
+
+// Hub fans out published messages to the clients subscribed to their channels.
+type Hub struct {
+	config         *Config
+	log            *logger.Logger
+	queueSize      int
+	overflowPolicy string
+	// dropped and evicted count the messages dropped and the clients evicted on overflow.
+	dropped int64
+	evicted int64
+	// latency records the time from the ingest of the events to their delivery.
+	latency       *status.Latencies
+	latencyBudget time.Duration
+	mu            sync.RWMutex
+	channels      map[string]map[*Client]bool
+	clients       map[*Client]bool
+	// candles holds the last kline update of every exchange series, see trackCandle.
+	candlesMu sync.Mutex
+	candles   map[string]models.CandleUpdate
+	tapsMu    sync.RWMutex
+	taps      []Tap
+}
+
+// Tap receives every published message, whether the channel has subscribers or not.
+// It is called synchronously by the publisher, so it must not block.
+type Tap func(channel string, data interface{})
+
+// Client represents a single consumer connection with its own send queue.
+type Client struct {
+	hub      *Hub
+	queue    *queue
+	send     chan []byte
+	done     chan struct{}
+	once     sync.Once
+	mu       sync.Mutex
+	channels map[string]bool
+	filter   Filter
+	states   map[string]*channelState
+}
+
+// New returns a new hub instance.
+func New(config *Config, log *logger.Logger) *Hub {
+	queueSize := defaultQueueSize
+	if config != nil && config.QueueSize > 0 {
+		queueSize = config.QueueSize
+	}
+
+	overflowPolicy := OverflowDisconnect
+	if config != nil && config.OverflowPolicy != "" {
+		if err := validateOverflowPolicy(config.OverflowPolicy); err != nil {
+			log.Warnf("Invalid hub overflow policy, using %v: %v", overflowPolicy, err)
+		} else {
+			overflowPolicy = config.OverflowPolicy
+		}
+	}
+
+	latencySamples := 0
+	latencyBudget := defaultLatencyBudget
+	if config != nil {
+		latencySamples = config.LatencySamples
+		if config.LatencyBudget != "" {
+			budget, err := time.ParseDuration(config.LatencyBudget)
+			if err != nil {
+				log.Warnf("Could not parse hub latency budget, using %v: %v", latencyBudget, err)
+			} else {
+				latencyBudget = budget
+			}
+		}
+	}
+
+	return &Hub{
+		config:         config,
+		log:            log,
+		queueSize:      queueSize,
+		overflowPolicy: overflowPolicy,
+		latency:        status.NewLatencies(latencySamples),
+		latencyBudget:  latencyBudget,
+		channels:       make(map[string]map[*Client]bool),
+		clients:        make(map[*Client]bool),
+		candles:        make(map[string]models.CandleUpdate),
+	}
+}
+
+// Stats represents the overflow counters of the client queues.
+type Stats struct {
+	Clients        int
+	OverflowPolicy string
+	Dropped        int64
+	Evicted        int64
+}
+
+// Stats returns the number of clients and the overflow counters.
+func (h *Hub) Stats() Stats {
+	h.mu.RLock()
+	clients := len(h.clients)
+	h.mu.RUnlock()
+
+	return Stats{
+		Clients:        clients,
+		OverflowPolicy: h.overflowPolicy,
+		Dropped:        atomic.LoadInt64(&h.dropped),
+		Evicted:        atomic.LoadInt64(&h.evicted),
+	}
+}
+
+// NormalizeChannels converts the interval aliases of the kline and candle channels
+// to the canonical intervals, leaving the rest of the channels as they are.
+func NormalizeChannels(channels []string) []string {
+	normalized := make([]string, 0, len(channels))
+	for _, v := range channels {
+		parts := strings.Split(v, ".")
+		if (parts[0] == "kline" || parts[0] == "candle") && len(parts) == 3 {
+			if interval, ok := models.NormalizeInterval(parts[1]); ok {
+				v = parts[0] + "." + interval + "." + parts[2]
+			}
+		}
+		normalized = append(normalized, v)
+	}
+	return normalized
+}
+
+// ValidateChannel checks that the channel name is one of kline.<interval>.<symbol>,
+// candle.<interval>.<symbol>, bbo.<symbol>, book.<symbol> or trades.<symbol>.
+func ValidateChannel(channel string) error {
+	parts := strings.Split(channel, ".")
+	switch {
+	case (parts[0] == "kline" || parts[0] == "candle") && len(parts) == 3:
+		if !models.IsValidInterval(parts[1]) {
+			return fmt.Errorf("invalid interval %v", parts[1])
+		}
+	case (parts[0] == "bbo" || parts[0] == "book" || parts[0] == "trades") && len(parts) == 2:
+	default:
+		return fmt.Errorf("unknown channel %v", channel)
+	}
+
+	if parts[len(parts)-1] == "" {
+		return fmt.Errorf("no symbol in channel %v", channel)
+	}
+	return nil
+}
+
+// ChannelSymbol returns the symbol of the channel, which is always its last part.
+func ChannelSymbol(channel string) string {
+	return channel[strings.LastIndex(channel, ".")+1:]
+}
+
+// AddTap adds a receiver of every published message, e.g. to forward them to a broker.
+func (h *Hub) AddTap(tap Tap) {
+	h.tapsMu.Lock()
+	defer h.tapsMu.Unlock()
+
+	h.taps = append(h.taps, tap)
+}
+
+// NewClient returns a new client without subscriptions.
+func (h *Hub) NewClient() *Client {
+	c := &Client{
+		hub:      h,
+		queue:    newQueue(h.queueSize, h.overflowPolicy),
+		send:     make(chan []byte),
+		done:     make(chan struct{}),
+		channels: make(map[string]bool),
+		states:   make(map[string]*channelState),
+	}
+
+	h.mu.Lock()
+	h.clients[c] = true
+	h.mu.Unlock()
+
+	go c.pump()
+
+	return c
+}
+
+// CloseAll closes every client, e.g. to let them reconnect to another instance on shutdown.
+func (h *Hub) CloseAll() {
+	h.mu.RLock()
+	clients := make([]*Client, 0, len(h.clients))
+	for c := range h.clients {
+		clients = append(clients, c)
+	}
+	h.mu.RUnlock()
+
+	for _, c := range clients {
+		c.Close()
+	}
+}
+
+// Latency returns the end-to-end latency percentiles of every symbol and the latency budget.
+func (h *Hub) Latency() ([]status.Latency, time.Duration) {
+	return h.latency.All(), h.latencyBudget
+}
+
+// HasSubscribers reports whether anybody listens to the channel,
+// so publishers can skip preparing the message.
+func (h *Hub) HasSubscribers(channel string) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return len(h.channels[channel]) > 0
+}
+
+// Publish sends the data to every subscriber of the channel whose filter lets it through.
+// The overflow policy is applied to the clients whose queue is full instead of blocking
+// the publisher.
+// Kline updates also close the previous candle of their series, see trackCandle.
+func (h *Hub) Publish(channel string, data interface{}) {
+	h.PublishAt(channel, data, time.Time{})
+}
+
+// PublishAt publishes the data of an event ingested at the given time. The time from
+// the ingest to the delivery to every client is recorded per symbol, see Latency.
+func (h *Hub) PublishAt(channel string, data interface{}, ingested time.Time) {
+	if update, ok := data.(models.CandleUpdate); ok && strings.HasPrefix(channel, klineChannelPrefix) {
+		h.trackCandle(channel, update)
+	}
+
+	h.tapsMu.RLock()
+	for _, tap := range h.taps {
+		tap(channel, data)
+	}
+	h.tapsMu.RUnlock()
+
+	if !h.HasSubscribers(channel) {
+		return
+	}
+
+	message, err := json.Marshal(Message{Channel: channel, Data: data})
+	if err != nil {
+		h.log.Errorf("Could not marshal hub message: %v", err)
+		return
+	}
+
+	now := time.Now()
+
+	h.mu.RLock()
+	slow := make([]*Client, 0)
+	for c := range h.channels[channel] {
+		if !c.allow(channel, data, now) {
+			continue
+		}
+
+		dropped, ok := c.queue.push(channel, message, ingested)
+		if !ok {
+			slow = append(slow, c)
+		}
+		if dropped > 0 {
+			atomic.AddInt64(&h.dropped, int64(dropped))
+		}
+	}
+	h.mu.RUnlock()
+
+	for _, c := range slow {
+		h.log.Warnf("Evicting slow hub client subscribed to %v", channel)
+		atomic.AddInt64(&h.evicted, 1)
+		c.Close()
+	}
+}
+
+// Send returns the queue of messages to deliver to the client.
+func (c *Client) Send() <-chan []byte {
+	return c.send
+}
+
+// Push queues a message for the client only. It returns false if the queue is full.
+func (c *Client) Push(message []byte) bool {
+	_, ok := c.queue.push("", message, time.Time{})
+	return ok
+}
+
+// Done is closed when the client is closed.
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Subscribe adds the channels to the client subscriptions.
+func (c *Client) Subscribe(channels ...string) error {
+	for _, v := range channels {
+		if err := ValidateChannel(v); err != nil {
+			return err
+		}
+	}
+
+	c.hub.mu.Lock()
+	defer c.hub.mu.Unlock()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	select {
+	case <-c.done:
+		return fmt.Errorf("client is closed")
+	default:
+	}
+
+	for _, v := range channels {
+		if c.hub.channels[v] == nil {
+			c.hub.channels[v] = make(map[*Client]bool)
+		}
+		c.hub.channels[v][c] = true
+		c.channels[v] = true
+	}
+	return nil
+}
+
+// Unsubscribe removes the channels from the client subscriptions.
+func (c *Client) Unsubscribe(channels ...string) {
+	c.hub.mu.Lock()
+	defer c.hub.mu.Unlock()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, v := range channels {
+		c.hub.unsubscribe(c, v)
+		delete(c.channels, v)
+		delete(c.states, v)
+	}
+}
+
+// Channels returns the channels the client is subscribed to.
+func (c *Client) Channels() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	channels := make([]string, 0, len(c.channels))
+	for v := range c.channels {
+		channels = append(channels, v)
+	}
+	return channels
+}
+
+// Close unsubscribes the client from everything and closes Done.
+func (c *Client) Close() {
+	c.once.Do(func() {
+		c.hub.mu.Lock()
+		c.mu.Lock()
+		for v := range c.channels {
+			c.hub.unsubscribe(c, v)
+		}
+		c.channels = make(map[string]bool)
+		delete(c.hub.clients, c)
+		close(c.done)
+		c.mu.Unlock()
+		c.hub.mu.Unlock()
+	})
+}
+
+// unsubscribe must be called with h.mu held.
+func (h *Hub) unsubscribe(c *Client, channel string) {
+	delete(h.channels[channel], c)
+	if len(h.channels[channel]) == 0 {
+		delete(h.channels, channel)
+	}
+}
@@ -0,0 +1,149 @@
+package hub
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Overflow policies of the client queues.
+const (
+	// OverflowDisconnect evicts the client, which has to reconnect and resubscribe.
+	OverflowDisconnect = "disconnect"
+	// OverflowDropOldest drops the oldest queued message to make room for the new one.
+	OverflowDropOldest = "drop_oldest"
+	// OverflowConflate keeps only the latest queued message of every channel, so a slow
+	// client skips the intermediate updates but still receives the latest snapshot.
+	OverflowConflate = "conflate"
+)
+
+func validateOverflowPolicy(policy string) error {
+	switch policy {
+	case OverflowDisconnect, OverflowDropOldest, OverflowConflate:
+		return nil
+	}
+	return fmt.Errorf("overflow policy should be one of %v, %v, %v", OverflowDisconnect, OverflowDropOldest, OverflowConflate)
+}
+
+type queuedMessage struct {
+	// channel is empty for the messages pushed to the client only, which are never conflated.
+	channel string
+	data    []byte
+	// ingested is the ingest time of the event, zero if the latency isn't recorded.
+	ingested time.Time
+}
+
+// queue is the bounded send buffer of a client. The messages are moved to the send
+// channel of the client by its pump, see Client.pump.
+type queue struct {
+	mu     sync.Mutex
+	items  []queuedMessage
+	size   int
+	policy string
+	// wake is signalled when a message is added to an empty queue.
+	wake chan struct{}
+}
+
+func newQueue(size int, policy string) *queue {
+	return &queue{
+		items:  make([]queuedMessage, 0, size),
+		size:   size,
+		policy: policy,
+		wake:   make(chan struct{}, 1),
+	}
+}
+
+// push adds the message, applying the overflow policy if the queue is full. It returns
+// the number of messages dropped to make room, and false if the client must be evicted
+// instead, in which case the message isn't added.
+func (q *queue) push(channel string, data []byte, ingested time.Time) (dropped int, ok bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.items) >= q.size {
+		// The responses pushed to the client only are never dropped for the updates.
+		if q.policy == OverflowDisconnect || channel == "" {
+			return 0, false
+		}
+
+		if q.policy == OverflowConflate {
+			dropped = q.conflate()
+		}
+		if len(q.items) >= q.size {
+			q.items = q.items[1:]
+			dropped++
+		}
+	}
+
+	q.items = append(q.items, queuedMessage{channel: channel, data: data, ingested: ingested})
+	if len(q.items) == 1 {
+		select {
+		case q.wake <- struct{}{}:
+		default:
+		}
+	}
+	return dropped, true
+}
+
+// conflate removes the queued messages superseded by a later one of the same channel
+// and returns the number of removed messages. It must be called with mu held.
+func (q *queue) conflate() int {
+	latest := make(map[string]int, len(q.items))
+	for i, v := range q.items {
+		if v.channel != "" {
+			latest[v.channel] = i
+		}
+	}
+
+	kept := q.items[:0]
+	for i, v := range q.items {
+		if v.channel == "" || latest[v.channel] == i {
+			kept = append(kept, v)
+		}
+	}
+
+	removed := len(q.items) - len(kept)
+	q.items = kept
+	return removed
+}
+
+// pop removes and returns the oldest message, ok is false if the queue is empty.
+func (q *queue) pop() (message queuedMessage, ok bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.items) == 0 {
+		return queuedMessage{}, false
+	}
+
+	message = q.items[0]
+	q.items[0] = queuedMessage{}
+	q.items = q.items[1:]
+	return message, true
+}
+
+// pump moves the queued messages to the send channel until the client is closed.
+// A message is delivered once the API handler takes it to write it to the connection.
+func (c *Client) pump() {
+	for {
+		message, ok := c.queue.pop()
+		if !ok {
+			select {
+			case <-c.queue.wake:
+				continue
+			case <-c.done:
+				return
+			}
+		}
+
+		select {
+		case c.send <- message.data:
+		case <-c.done:
+			return
+		}
+
+		if !message.ingested.IsZero() {
+			c.hub.latency.Record(ChannelSymbol(message.channel), time.Since(message.ingested))
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package kafka
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"price-feed/hub"
+	"price-feed/logger"
+	"price-feed/models"
+)
+
+const (
+	defaultFlushInterval  = time.Second
+	defaultBatchSize      = 500
+	defaultRequestTimeout = 10 * time.Second
+	queueSize             = 10000
+	// contentType is the embedded JSON format of the REST Proxy v2 API.
+	contentType = "application/vnd.kafka.json.v2+json"
+)
+
+// Config represents a Kafka publisher configuration. The records are produced
+// through the Confluent REST Proxy, so no Kafka client is linked in.
+type Config struct {
+	// RestProxy is the base URL of the REST Proxy, e.g. http://kafka-rest:8082.
+	RestProxy string `json:"rest_proxy"`
+	// CandlesTopic receives the closed candles, TradesTopic the trades and BookTopic
+	// the top level changes of the order books. An empty topic isn't published.
+	CandlesTopic  string `json:"candles_topic"`
+	TradesTopic   string `json:"trades_topic"`
+	BookTopic     string `json:"book_topic"`
+	BatchSize     int    `json:"batch_size"`
+	FlushInterval string `json:"flush_interval"`
+}
+
+type record struct {
+	Key   string      `json:"key"`
+	Value interface{} `json:"value"`
+}
+
+type message struct {
+	topic  string
+	record record
+}
+
+// Publisher forwards the market data published to the hub to the Kafka topics.
+type Publisher struct {
+	config        *Config
+	log           *logger.Logger
+	hub           *hub.Hub
+	flushInterval time.Duration
+	client        *http.Client
+	queue         chan message
+	dropped       int64
+	quit          chan struct{}
+	// done is closed once the pending records are produced after Stop.
+	done chan struct{}
+}
+
+// New returns a new Kafka publisher of the hub messages.
+func New(config *Config, log *logger.Logger, hub *hub.Hub) (*Publisher, error) {
+	if config.RestProxy == "" {
+		return nil, errors.New("Kafka REST Proxy URL is empty")
+	}
+
+	if config.BatchSize <= 0 {
+		config.BatchSize = defaultBatchSize
+	}
+
+	p := &Publisher{
+		config:        config,
+		log:           log,
+		hub:           hub,
+		flushInterval: defaultFlushInterval,
+		client:        &http.Client{Timeout: defaultRequestTimeout},
+		queue:         make(chan message, queueSize),
+		quit:          make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+
+	if config.FlushInterval != "" {
+		interval, err := time.ParseDuration(config.FlushInterval)
+		if err != nil {
+			return nil, errors.Wrapf(err, "couldn't parse Kafka flush interval")
+		}
+		p.flushInterval = interval
+	}
+
+	return p, nil
+}
+
+// Start starts forwarding the hub messages.
+func (p *Publisher) Start() {
+	p.hub.AddTap(p.tap)
+	go p.run()
+}
+
+// Stop produces the queued and batched records and returns once they are sent.
+func (p *Publisher) Stop() {
+	close(p.quit)
+	<-p.done
+}
+
+// tap queues the closed candles, the trades and the book changes for their topics.
+func (p *Publisher) tap(channel string, data interface{}) {
+	var m message
+	switch v := data.(type) {
+	case models.CandleUpdate:
+		if !strings.HasPrefix(channel, "candle.") {
+			return
+		}
+		m = message{topic: p.config.CandlesTopic, record: record{Key: v.Exchange + ":" + v.Symbol, Value: v}}
+	case models.Trade:
+		m = message{topic: p.config.TradesTopic, record: record{Key: v.Exchange + ":" + v.Symbol, Value: v}}
+	case models.BookChange:
+		m = message{topic: p.config.BookTopic, record: record{Key: v.Exchange + ":" + v.Symbol, Value: v}}
+	default:
+		return
+	}
+	if m.topic == "" {
+		return
+	}
+
+	select {
+	case p.queue <- m:
+	default:
+		if atomic.AddInt64(&p.dropped, 1)%1000 == 1 {
+			p.log.Warnf("Kafka queue is full, %v records dropped", atomic.LoadInt64(&p.dropped))
+		}
+	}
+}
+
+// run produces the queued records in batches per topic, at least every flush interval,
+// until the publisher is stopped.
+func (p *Publisher) run() {
+	defer close(p.done)
+
+	ticker := time.NewTicker(p.flushInterval)
+	defer ticker.Stop()
+
+	batches := make(map[string][]record)
+	add := func(m message) {
+		batches[m.topic] = append(batches[m.topic], m.record)
+		if len(batches[m.topic]) < p.config.BatchSize {
+			return
+		}
+		p.produce(m.topic, batches[m.topic])
+		delete(batches, m.topic)
+	}
+	flush := func() {
+		for topic, records := range batches {
+			p.produce(topic, records)
+		}
+		batches = make(map[string][]record)
+	}
+
+	for {
+		select {
+		case m := <-p.queue:
+			add(m)
+		case <-ticker.C:
+			flush()
+		case <-p.quit:
+			for {
+				select {
+				case m := <-p.queue:
+					add(m)
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+func (p *Publisher) produce(topic string, records []record) {
+	if err := p.post(topic, records); err != nil {
+		p.log.Errorf("Could not produce %v records to Kafka topic %v: %v", len(records), topic, err)
+	}
+}
+
+func (p *Publisher) post(topic string, records []record) error {
+	data, err := json.Marshal(struct {
+		Records []record `json:"records"`
+	}{records})
+	if err != nil {
+		return err
+	}
+
+	url := strings.TrimSuffix(p.config.RestProxy, "/") + "/topics/" + topic
+	resp, err := p.client.Post(url, contentType, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%v received bad status code: %v", url, resp.StatusCode)
+	}
+	return nil
+}
@@ -1,24 +1,40 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
 	"os/signal"
+	"syscall"
 
 	"price-feed/exchanges/poloniex"
 
 	"price-feed/exchanges/bittrex"
+	"price-feed/exchanges/coinbase"
+	"price-feed/exchanges/kucoin"
+	"price-feed/exchanges/okx"
 
+	"price-feed/aggregator"
 	"price-feed/api"
+	"price-feed/clickhouse"
 	"price-feed/config"
 	"price-feed/exchanges/binance"
+	"price-feed/exchanges/bitfinex"
+	"price-feed/exchanges/bybit"
+	"price-feed/exchanges/huobi"
+	"price-feed/hub"
+	"price-feed/kafka"
 	"price-feed/logger"
+	"price-feed/nats"
+	"price-feed/notify"
 	"price-feed/storage"
+	"price-feed/tracing"
+	"price-feed/webhook"
 )
 
 func main() {
-	quit := make(chan os.Signal)
-	signal.Notify(quit, os.Interrupt)
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
 
 	cfg, err := config.FromFile()
 	if err != nil {
@@ -32,39 +48,225 @@ func main() {
 		}
 	}()
 
+	if err = tracing.Init(cfg.Tracing, l); err != nil {
+		l.Fatalf("Could not init tracing: %v", err)
+	}
+
+	// The workers are only started once the database answers, so a Redis failover
+	// at boot delays the start instead of crash looping the service.
 	database := storage.New(cfg.Storage, l)
-	pong, err := database.Check()
+	pong, err := database.WaitHealthy(quit)
 	if err != nil {
 		l.Fatalf("Can't establish connection to database: %v", err)
 	}
 	l.Infof("Database check reply: %v", pong)
 
-	if err := database.Flush(); err != nil {
-		l.Fatalf("Could not flush database")
+	if len(os.Args) > 2 {
+		if err = runCommand(cfg, l, database, os.Args[2], os.Args[3:]); err != nil {
+			l.Fatalf("Command %v failed: %v", os.Args[2], err)
+		}
+		if err = database.FlushWrites(); err != nil {
+			l.Fatalf("Could not flush batched writes: %v", err)
+		}
+		return
+	}
+
+	if !cfg.Storage.Persist {
+		if err := database.Flush(); err != nil {
+			l.Fatalf("Could not flush database")
+		}
+	}
+
+	if err = database.Migrate(storage.LatestSchemaVersion()); err != nil {
+		l.Fatalf("Could not migrate storage: %v", err)
 	}
 
-	binanceWorker, err := binance.NewWorker(cfg.Binance, l, database, quit)
+	if err = database.InitHistory(); err != nil {
+		l.Fatalf("Could not init candle history: %v", err)
+	}
+
+	if err = database.StartRetention(); err != nil {
+		l.Fatalf("Could not start candle retention: %v", err)
+	}
+
+	if err = database.StartCompaction(); err != nil {
+		l.Fatalf("Could not start candle compaction: %v", err)
+	}
+
+	go database.WatchHealth()
+
+	dryRun := make(map[string]bool, len(cfg.DryRun))
+	for _, name := range cfg.DryRun {
+		l.Warnf("Worker %v runs in dry run mode and stores nothing", name)
+		dryRun[name] = true
+	}
+
+	workerStore := func(name string) *storage.Client {
+		if dryRun[name] {
+			return database.WithDryRun()
+		}
+		return database
+	}
+
+	streamHub := hub.New(cfg.Hub, l)
+
+	// The publishers tap the hub, so they are started before the workers publish.
+	var kafkaPublisher *kafka.Publisher
+	if cfg.Kafka != nil {
+		kafkaPublisher, err = kafka.New(cfg.Kafka, l, streamHub)
+		if err != nil {
+			l.Fatalf("Could not create Kafka publisher: %v", err)
+		}
+
+		kafkaPublisher.Start()
+	}
+
+	var natsPublisher *nats.Publisher
+	if cfg.NATS != nil {
+		natsPublisher, err = nats.New(cfg.NATS, l, streamHub)
+		if err != nil {
+			l.Fatalf("Could not create NATS publisher: %v", err)
+		}
+
+		natsPublisher.Start()
+	}
+
+	if cfg.ClickHouse != nil {
+		sink, err := clickhouse.New(cfg.ClickHouse, l, streamHub)
+		if err != nil {
+			l.Fatalf("Could not create ClickHouse sink: %v", err)
+		}
+
+		if err = sink.Start(); err != nil {
+			l.Fatalf("Could not start ClickHouse sink: %v", err)
+		}
+	}
+
+	binanceWorker, err := binance.NewWorker(cfg.Binance, l, workerStore("binance"), streamHub, quit)
 	if err != nil {
 		l.Fatalf("Could not connect to Binance: %v", err)
 	}
 
 	binanceWorker.Start()
 
-	bittrexWorker, err := bittrex.NewWorker(cfg.Bittrex, l, database, quit)
+	bittrexWorker, err := bittrex.NewWorker(cfg.Bittrex, l, workerStore("bittrex"), streamHub, quit)
 	if err != nil {
 		l.Fatalf("Could not connect to Bittrex: %v", err)
 	}
 
 	bittrexWorker.Start()
 
-	poloniexWorker, err := poloniex.NewWorker(cfg.Poloniex, l, database, quit)
+	poloniexWorker, err := poloniex.NewWorker(cfg.Poloniex, l, workerStore("poloniex"), streamHub, quit)
 	if err != nil {
 		l.Fatalf("Could not connect to Bittrex: %v", err)
 	}
 
 	poloniexWorker.Start()
 
-	apiServer := api.New(cfg.API, l, database, binanceWorker, bittrexWorker, poloniexWorker)
+	optional := make(map[string]api.OrderBookExchange)
+	if cfg.Coinbase != nil {
+		coinbaseWorker, err := coinbase.NewWorker(cfg.Coinbase, l, workerStore("coinbase"), streamHub, quit)
+		if err != nil {
+			l.Fatalf("Could not connect to Coinbase: %v", err)
+		}
+
+		coinbaseWorker.Start()
+		optional["coinbase"] = coinbaseWorker
+	}
+
+	if cfg.OKX != nil {
+		okxWorker, err := okx.NewWorker(cfg.OKX, l, workerStore("okx"), streamHub, quit)
+		if err != nil {
+			l.Fatalf("Could not connect to OKX: %v", err)
+		}
+
+		okxWorker.Start()
+		optional["okx"] = okxWorker
+	}
+
+	if cfg.Bitfinex != nil {
+		bitfinexWorker, err := bitfinex.NewWorker(cfg.Bitfinex, l, workerStore("bitfinex"), streamHub, quit)
+		if err != nil {
+			l.Fatalf("Could not connect to Bitfinex: %v", err)
+		}
+
+		bitfinexWorker.Start()
+		optional["bitfinex"] = bitfinexWorker
+	}
+
+	if cfg.KuCoin != nil {
+		kucoinWorker, err := kucoin.NewWorker(cfg.KuCoin, l, workerStore("kucoin"), streamHub, quit)
+		if err != nil {
+			l.Fatalf("Could not connect to KuCoin: %v", err)
+		}
+
+		kucoinWorker.Start()
+		optional["kucoin"] = kucoinWorker
+	}
+
+	if cfg.Bybit != nil {
+		bybitWorker, err := bybit.NewWorker(cfg.Bybit, l, workerStore("bybit"), streamHub, quit)
+		if err != nil {
+			l.Fatalf("Could not connect to Bybit: %v", err)
+		}
+
+		bybitWorker.Start()
+		optional["bybit"] = bybitWorker
+	}
+
+	if cfg.Huobi != nil {
+		huobiWorker, err := huobi.NewWorker(cfg.Huobi, l, workerStore("huobi"), streamHub, quit)
+		if err != nil {
+			l.Fatalf("Could not connect to Huobi: %v", err)
+		}
+
+		huobiWorker.Start()
+		optional["huobi"] = huobiWorker
+	}
+
+	if cfg.Notifier != nil {
+		sources := map[string]notify.OrderBookSource{"binance": binanceWorker}
+		for name, v := range optional {
+			sources[name] = v
+		}
+
+		notifier, err := notify.New(cfg.Notifier, l, streamHub, sources)
+		if err != nil {
+			l.Fatalf("Could not create order book notifier: %v", err)
+		}
+
+		notifier.Start()
+	}
+
+	var webhooks api.WebhookRegistry
+	if cfg.Webhooks != nil {
+		sources := map[string]webhook.OrderBookSource{"binance": binanceWorker}
+		for name, v := range optional {
+			sources[name] = v
+		}
+
+		manager, err := webhook.New(cfg.Webhooks, l, database, streamHub, sources)
+		if err != nil {
+			l.Fatalf("Could not create webhook manager: %v", err)
+		}
+
+		manager.Start()
+		webhooks = manager
+	}
+
+	aggregatorWorker, err := aggregator.New(cfg.Aggregator, l, database, streamHub)
+	if err != nil {
+		l.Fatalf("Could not create aggregator: %v", err)
+	}
+
+	aggregatorWorker.AddSymbolSource("binance", binanceWorker)
+	for name, v := range optional {
+		aggregatorWorker.AddSymbolSource(name, v)
+	}
+
+	aggregatorWorker.Start()
+
+	apiServer := api.New(cfg.API, l, database, binanceWorker, bittrexWorker, poloniexWorker, optional, aggregatorWorker, streamHub, webhooks)
 
 	go func() {
 		if err = apiServer.Start(); err != nil {
@@ -72,5 +274,35 @@ func main() {
 		}
 	}()
 
+	if cfg.API.GRPC != nil {
+		go func() {
+			if err := apiServer.StartGRPC(); err != nil {
+				l.Fatalf("gRPC server error: %v", err)
+			}
+		}()
+	}
+
 	<-quit
+
+	if err = apiServer.Shutdown(context.Background()); err != nil {
+		l.Errorf("Could not shut down API gracefully: %v", err)
+	}
+
+	if cfg.API.GRPC != nil {
+		if err = apiServer.ShutdownGRPC(context.Background()); err != nil {
+			l.Errorf("Could not shut down gRPC API gracefully: %v", err)
+		}
+	}
+
+	if natsPublisher != nil {
+		natsPublisher.Stop()
+	}
+
+	if kafkaPublisher != nil {
+		kafkaPublisher.Stop()
+	}
+
+	if err = database.FlushWrites(); err != nil {
+		l.Errorf("Could not flush batched writes: %v", err)
+	}
 }
@@ -1,8 +1,10 @@
 package models
 
 import (
+	"math"
 	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/jyap808/go-poloniex"
@@ -38,6 +40,11 @@ var (
 	PoloniexCandlestickIntervalList = []int{
 		300, 900, 1800, 7200, 14400, 86400,
 	}
+
+	CoinbaseCandlestickIntervalList = []string{
+		"ONE_MINUTE", "FIVE_MINUTE", "FIFTEEN_MINUTE", "THIRTY_MINUTE",
+		"ONE_HOUR", "TWO_HOUR", "SIX_HOUR", "ONE_DAY",
+	}
 )
 
 func BittrexIntervalToBinance(v string) string {
@@ -57,19 +64,81 @@ func BittrexIntervalToBinance(v string) string {
 	return ""
 }
 
+// poloniexIntervals maps the Poloniex chart periods, in seconds, to the Binance intervals.
+var poloniexIntervals = map[int]string{
+	300:   "5m",
+	900:   "15m",
+	1800:  "30m",
+	7200:  "2h",
+	14400: "4h",
+	86400: "1d",
+}
+
+// PoloniexIntervalToBinance returns the Binance interval of the Poloniex period, or "" if it is unknown.
 func PoloniexIntervalToBinance(v int) string {
+	return poloniexIntervals[v]
+}
+
+// BinanceIntervalToPoloniex returns the Poloniex period of the interval. ok is false
+// if Poloniex has no such period.
+func BinanceIntervalToPoloniex(interval string) (period int, ok bool) {
+	for k, v := range poloniexIntervals {
+		if v == interval {
+			return k, true
+		}
+	}
+	return 0, false
+}
+
+// PoloniexResampleSource returns the longest Poloniex period the candles of the interval
+// can be resampled from, i.e. one which divides the interval. Monthly candles are built
+// from the daily ones. ok is false for the intervals shorter than every period, and for
+// the ones Poloniex serves as they are.
+func PoloniexResampleSource(interval string) (period int, ok bool) {
+	if _, native := BinanceIntervalToPoloniex(interval); native {
+		return 0, false
+	}
+	if interval == "1M" {
+		return 86400, true
+	}
+
+	seconds := int(IntervalDuration(interval).Seconds())
+	for _, v := range PoloniexCandlestickIntervalList {
+		if v < seconds && seconds%v == 0 && v > period {
+			period = v
+		}
+	}
+	return period, period > 0
+}
+
+// PoloniexResampledIntervalList returns the Binance intervals resampled from the Poloniex periods.
+func PoloniexResampledIntervalList() []string {
+	intervals := make([]string, 0, len(BinanceCandlestickIntervalList))
+	for _, v := range BinanceCandlestickIntervalList {
+		if _, ok := PoloniexResampleSource(v); ok {
+			intervals = append(intervals, v)
+		}
+	}
+	return intervals
+}
+
+func CoinbaseIntervalToBinance(v string) string {
 	switch v {
-	case 300:
+	case "ONE_MINUTE":
+		return "1m"
+	case "FIVE_MINUTE":
 		return "5m"
-	case 900:
+	case "FIFTEEN_MINUTE":
 		return "15m"
-	case 1800:
+	case "THIRTY_MINUTE":
 		return "30m"
-	case 7200:
+	case "ONE_HOUR":
+		return "1h"
+	case "TWO_HOUR":
 		return "2h"
-	case 14400:
-		return "4h"
-	case 86400:
+	case "SIX_HOUR":
+		return "6h"
+	case "ONE_DAY":
 		return "1d"
 	}
 	return ""
@@ -84,6 +153,157 @@ func IsValidInterval(s string) bool {
 	return false
 }
 
+// intervalUnits maps the unit spellings of the interval aliases to the canonical units.
+var intervalUnits = map[string]string{
+	"m": "m", "min": "m", "mins": "m", "minute": "m", "minutes": "m", "t": "m",
+	"h": "h", "hr": "h", "hour": "h", "hours": "h",
+	"d": "d", "day": "d", "days": "d",
+	"w": "w", "wk": "w", "week": "w", "weeks": "w",
+	"mn": "M", "mo": "M", "mon": "M", "month": "M", "months": "M",
+}
+
+// NormalizeInterval converts an interval alias to the canonical Binance interval, e.g.
+// "60" (minutes), "1min", "1hour", "H1", "M5", "MN1", "D" or "1D". ok is false if s
+// isn't an alias of a supported interval.
+func NormalizeInterval(s string) (interval string, ok bool) {
+	s = strings.TrimSpace(s)
+	if IsValidInterval(s) {
+		return s, true
+	}
+	// A single M is a month, like in the charting libraries; m and M1 are minutes.
+	if s == "M" {
+		return "1M", true
+	}
+
+	lower := strings.ToLower(s)
+	i := strings.IndexFunc(lower, func(r rune) bool { return r < '0' || r > '9' })
+
+	var count, unit string
+	switch {
+	case i == -1:
+		count, unit = lower, "m"
+	case i > 0:
+		count, unit = lower[:i], lower[i:]
+	default:
+		j := strings.IndexFunc(lower, func(r rune) bool { return r >= '0' && r <= '9' })
+		if j == -1 {
+			count, unit = "1", lower
+		} else {
+			count, unit = lower[j:], lower[:j]
+		}
+	}
+
+	n, err := strconv.Atoi(count)
+	if err != nil || n <= 0 {
+		return "", false
+	}
+	unit, ok = intervalUnits[unit]
+	if !ok {
+		return "", false
+	}
+
+	// Promote the interval to the largest unit it divides, e.g. 60 minutes to 1h.
+	if minutes, ok := map[string]int{"m": 1, "h": 60, "d": 24 * 60}[unit]; ok {
+		n, unit = n*minutes, "m"
+		switch {
+		case n%(7*24*60) == 0:
+			n, unit = n/(7*24*60), "w"
+		case n%(24*60) == 0:
+			n, unit = n/(24*60), "d"
+		case n%60 == 0:
+			n, unit = n/60, "h"
+		}
+	}
+
+	interval = strconv.Itoa(n) + unit
+	if !IsValidInterval(interval) {
+		return "", false
+	}
+	return interval, true
+}
+
+const (
+	// SymbolStatusTrading marks a market open for trading.
+	SymbolStatusTrading = "TRADING"
+	// SymbolStatusBreak marks a market halted by the exchange.
+	SymbolStatusBreak = "BREAK"
+	// SymbolStatusDelisted marks a market no longer listed by the exchange.
+	SymbolStatusDelisted = "DELISTED"
+)
+
+// IntervalDuration returns the length of the interval. A month is counted as 31 days.
+func IntervalDuration(interval string) time.Duration {
+	switch interval {
+	case "1d":
+		return 24 * time.Hour
+	case "3d":
+		return 3 * 24 * time.Hour
+	case "1w":
+		return 7 * 24 * time.Hour
+	case "1M":
+		return 31 * 24 * time.Hour
+	}
+
+	d, _ := time.ParseDuration(interval)
+	return d
+}
+
+// IntervalStart returns the open time of the candle of the interval containing t, in seconds.
+// The weeks start on Monday and the months on their first day, in UTC.
+func IntervalStart(t int64, interval string) int64 {
+	if interval == "1M" {
+		v := time.Unix(t, 0).UTC()
+		return time.Date(v.Year(), v.Month(), 1, 0, 0, 0, 0, time.UTC).Unix()
+	}
+	return time.Unix(t, 0).Truncate(IntervalDuration(interval)).Unix()
+}
+
+// CandleClosed reports whether the candle of the interval is closed at t, i.e. t is past its interval.
+func CandleClosed(candle Candle, interval string, t time.Time) bool {
+	return IntervalStart(t.Unix(), interval) > candle.TimeStart
+}
+
+// ResampleCandles merges the candles into the candles of the longer interval, oldest first.
+// The candles must be sorted by open time. The last candle may cover its interval partially.
+func ResampleCandles(candles []Candle, interval string) []Candle {
+	result := make([]Candle, 0)
+	for _, v := range candles {
+		start := IntervalStart(v.TimeStart, interval)
+
+		if n := len(result); n > 0 && result[n-1].TimeStart == start {
+			last := &result[n-1]
+			last.High = math.Max(last.High, v.High)
+			last.Low = math.Min(last.Low, v.Low)
+			last.Close = v.Close
+			last.Volume += v.Volume
+			last.Anomalous = last.Anomalous || v.Anomalous
+			if v.Time > last.Time {
+				last.Time = v.Time
+			}
+			continue
+		}
+
+		end := start + int64(IntervalDuration(interval).Seconds()) - 1
+		if interval == "1M" {
+			end = time.Unix(start, 0).UTC().AddDate(0, 1, 0).Unix() - 1
+		}
+
+		result = append(result, Candle{
+			TimeStart: start,
+			TimeEnd:   end,
+			Time:      v.Time,
+			Open:      v.Open,
+			Close:     v.Close,
+			High:      v.High,
+			Low:       v.Low,
+			Volume:    v.Volume,
+			Anomalous: v.Anomalous,
+			Source:    CandleSourceResampled,
+		})
+	}
+	return result
+}
+
 // OrderBookAPI represents the order book data format.
 type OrderBookAPI struct {
 	Asks []AskBid `json:"asks"`
@@ -106,7 +326,10 @@ type OrderBookInternal struct {
 	Asks         map[string]string `json:"asks"`
 }
 
-func (obi *OrderBookInternal) Format(depth int) OrderBookAPI {
+// Format returns the depth best levels of each side sorted by price ascending. With a positive
+// groupBy, the levels are first summed into buckets of the price increment, the bids rounded
+// down and the asks up, so the buckets of the two sides never cross.
+func (obi *OrderBookInternal) Format(depth int, groupBy float64) OrderBookAPI {
 	asks := make([]AskBid, 0, len(obi.Asks))
 	for k, v := range obi.Asks {
 		price, err := strconv.ParseFloat(k, 64)
@@ -143,6 +366,11 @@ func (obi *OrderBookInternal) Format(depth int) OrderBookAPI {
 		})
 	}
 
+	if groupBy > 0 {
+		asks = groupLevels(asks, groupBy, true)
+		bids = groupLevels(bids, groupBy, false)
+	}
+
 	sort.Slice(asks, func(i, j int) bool {
 		return asks[i].Price < asks[j].Price
 	})
@@ -167,6 +395,179 @@ func (obi *OrderBookInternal) Format(depth int) OrderBookAPI {
 	}
 }
 
+// groupLevels sums the levels into buckets of the price increment, rounding the prices up or down.
+func groupLevels(levels []AskBid, groupBy float64, up bool) []AskBid {
+	buckets := make(map[float64]float64)
+	for _, v := range levels {
+		// The epsilon keeps the prices which are multiples of the increment in their own bucket
+		// despite the floating point error of the division.
+		var bucket float64
+		if up {
+			bucket = math.Ceil(v.Price/groupBy-1e-9) * groupBy
+		} else {
+			bucket = math.Floor(v.Price/groupBy+1e-9) * groupBy
+		}
+		buckets[math.Round(bucket*1e8)/1e8] += v.Size
+	}
+
+	grouped := make([]AskBid, 0, len(buckets))
+	for price, size := range buckets {
+		grouped = append(grouped, AskBid{Price: price, Size: size})
+	}
+	return grouped
+}
+
+// BBO represents the best bid and offer of an order book.
+type BBO struct {
+	Exchange string  `json:"exchange"`
+	Symbol   string  `json:"symbol"`
+	BidPrice float64 `json:"bidPrice"`
+	BidSize  float64 `json:"bidSize"`
+	AskPrice float64 `json:"askPrice"`
+	AskSize  float64 `json:"askSize"`
+	// Mid is the value of Price, set by BestBidOffer.
+	Mid float64 `json:"mid"`
+}
+
+// Price returns the mid price, or the price of the only non-empty side.
+func (b BBO) Price() float64 {
+	if b.BidPrice == 0 || b.AskPrice == 0 {
+		return b.BidPrice + b.AskPrice
+	}
+	return (b.BidPrice + b.AskPrice) / 2
+}
+
+// MidPrice represents the mid price of an order book snapshot. Time is in milliseconds.
+type MidPrice struct {
+	Time  int64   `json:"time"`
+	Price float64 `json:"price"`
+}
+
+// BookChange represents the top levels of an order book pushed when they move.
+// The bids are sorted by price ascending like in OrderBookAPI. Time is in milliseconds.
+type BookChange struct {
+	Exchange string   `json:"exchange"`
+	Symbol   string   `json:"symbol"`
+	Mid      float64  `json:"mid"`
+	Bids     []AskBid `json:"bids"`
+	Asks     []AskBid `json:"asks"`
+	Time     int64    `json:"time"`
+}
+
+// Price returns the mid price.
+func (c BookChange) Price() float64 {
+	return c.Mid
+}
+
+// Trim keeps only the best levels of each side of the order book, removing the rest.
+func (obi *OrderBookInternal) Trim(levels int) {
+	trimSide(obi.Bids, levels, func(a, b float64) bool { return a > b })
+	trimSide(obi.Asks, levels, func(a, b float64) bool { return a < b })
+}
+
+// trimSide removes the levels after the first ones in the order of better.
+func trimSide(side map[string]string, levels int, better func(a, b float64) bool) {
+	if len(side) <= levels {
+		return
+	}
+
+	type level struct {
+		key   string
+		price float64
+	}
+
+	sorted := make([]level, 0, len(side))
+	for k := range side {
+		price, err := strconv.ParseFloat(k, 64)
+		if err != nil {
+			delete(side, k)
+			continue
+		}
+		sorted = append(sorted, level{key: k, price: price})
+	}
+
+	sort.Slice(sorted, func(i, j int) bool {
+		return better(sorted[i].price, sorted[j].price)
+	})
+
+	for i := levels; i < len(sorted); i++ {
+		delete(side, sorted[i].key)
+	}
+}
+
+// MergeOrderBooks consolidates the order books of the same symbol, summing the sizes of
+// the levels with equal prices, however the exchanges format them.
+func MergeOrderBooks(books ...OrderBookInternal) OrderBookInternal {
+	bids := make(map[float64]float64)
+	asks := make(map[float64]float64)
+	for _, book := range books {
+		mergeSide(bids, book.Bids)
+		mergeSide(asks, book.Asks)
+	}
+
+	merged := OrderBookInternal{
+		Bids: make(map[string]string, len(bids)),
+		Asks: make(map[string]string, len(asks)),
+	}
+	for _, side := range []struct {
+		levels map[float64]float64
+		dst    map[string]string
+	}{{bids, merged.Bids}, {asks, merged.Asks}} {
+		for price, size := range side.levels {
+			side.dst[strconv.FormatFloat(price, 'f', -1, 64)] = strconv.FormatFloat(size, 'f', -1, 64)
+		}
+	}
+	return merged
+}
+
+func mergeSide(dst map[float64]float64, side map[string]string) {
+	for k, v := range side {
+		price, err := strconv.ParseFloat(k, 64)
+		if err != nil {
+			continue
+		}
+
+		size, err := strconv.ParseFloat(v, 64)
+		if err != nil || size <= 0 {
+			continue
+		}
+		dst[price] += size
+	}
+}
+
+// BestBidOffer returns the best bid and offer of the order book.
+// Prices and sizes are zero for an empty side.
+func (obi *OrderBookInternal) BestBidOffer() BBO {
+	var bbo BBO
+
+	for k, v := range obi.Bids {
+		price, err := strconv.ParseFloat(k, 64)
+		if err != nil {
+			continue
+		}
+
+		if price > bbo.BidPrice {
+			bbo.BidPrice = price
+			bbo.BidSize = mustParseFloat64(v)
+		}
+	}
+
+	for k, v := range obi.Asks {
+		price, err := strconv.ParseFloat(k, 64)
+		if err != nil {
+			continue
+		}
+
+		if bbo.AskPrice == 0 || price < bbo.AskPrice {
+			bbo.AskPrice = price
+			bbo.AskSize = mustParseFloat64(v)
+		}
+	}
+
+	bbo.Mid = bbo.Price()
+	return bbo
+}
+
 var EmptyOrderBookInternal = OrderBookInternal{
 	Asks: make(map[string]string),
 	Bids: make(map[string]string),
@@ -197,6 +598,27 @@ func SerializeBinanceOrderBookREST(data OrderBookResponse) OrderBookInternal {
 	}
 }
 
+// SerializeBinancePartialDepthWS converts a partial depth event, which always carries
+// the whole top of the book, to the internal order book format.
+func SerializeBinancePartialDepthWS(event *binance.WsPartialDepthEvent) OrderBookInternal {
+	asks := make(map[string]string)
+	bids := make(map[string]string)
+
+	for _, ask := range event.Asks {
+		asks[ask.Price] = ask.Quantity
+	}
+
+	for _, bid := range event.Bids {
+		bids[bid.Price] = bid.Quantity
+	}
+
+	return OrderBookInternal{
+		LastUpdateID: event.LastUpdateID,
+		Asks:         asks,
+		Bids:         bids,
+	}
+}
+
 func SerializeBinanceOrderBookWS(event *binance.WsDepthEvent) *OrderBookAPI {
 	if event == nil {
 		return nil
@@ -245,10 +667,97 @@ func SerializeBinanceOrderBookWS(event *binance.WsDepthEvent) *OrderBookAPI {
 	}
 }
 
+// Usage represents the API usage of a tenant within a month.
+type Usage struct {
+	Requests   int64 `json:"requests"`
+	Bytes      int64 `json:"bytes"`
+	WsMessages int64 `json:"wsMessages"`
+}
+
+// DeadLetter represents a raw event which could not be parsed.
+type DeadLetter struct {
+	Exchange string `json:"exchange"`
+	Stream   string `json:"stream"`
+	Error    string `json:"error"`
+	Payload  string `json:"payload"`
+	Time     int64  `json:"time"`
+}
+
+// API key scopes. The admin scope includes the read one.
+const (
+	ScopeRead  = "read"
+	ScopeAdmin = "admin"
+)
+
+// APIKey represents an API key of the REST API. The key is stored hashed, so it is
+// only returned when issued.
+type APIKey struct {
+	ID      string   `json:"id"`
+	Name    string   `json:"name"`
+	Scopes  []string `json:"scopes"`
+	Key     string   `json:"key,omitempty"`
+	Created int64    `json:"created"`
+}
+
+// HasScope reports whether the key grants the scope.
+func (k APIKey) HasScope(scope string) bool {
+	for _, v := range k.Scopes {
+		if v == scope || v == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// IsValidScope reports whether the scope is known.
+func IsValidScope(scope string) bool {
+	return scope == ScopeRead || scope == ScopeAdmin
+}
+
+// Webhook conditions.
+const (
+	WebhookPriceCrossed = "price_crossed"
+	WebhookSpreadAbove  = "spread_above"
+	WebhookStale        = "stale"
+)
+
+// Webhook represents a URL notified when its condition on the symbol triggers.
+type Webhook struct {
+	ID    string `json:"id"`
+	Owner string `json:"owner"`
+	URL   string `json:"url"`
+	// Secret signs the payloads, it is only returned on registration.
+	Secret    string `json:"secret,omitempty"`
+	Exchange  string `json:"exchange"`
+	Symbol    string `json:"symbol"`
+	Condition string `json:"condition"`
+	// Price is the level of price_crossed.
+	Price float64 `json:"price,omitempty"`
+	// Spread is the spread_above threshold relative to the mid price, e.g. 0.01.
+	Spread float64 `json:"spread,omitempty"`
+	// MaxAge is the stale threshold, e.g. 1m.
+	MaxAge  string `json:"maxAge,omitempty"`
+	Created int64  `json:"created"`
+}
+
+// WebhookEvent represents the payload posted when a webhook triggers.
+type WebhookEvent struct {
+	WebhookID  string  `json:"webhookId"`
+	Condition  string  `json:"condition"`
+	Exchange   string  `json:"exchange"`
+	Symbol     string  `json:"symbol"`
+	Price      float64 `json:"price"`
+	Spread     float64 `json:"spread"`
+	LastUpdate int64   `json:"lastUpdate"`
+	Time       int64   `json:"time"`
+}
+
 type CandlestickResponse struct {
 	TimeStart int64    `json:"timeStart"`
 	TimeEnd   int64    `json:"timeEnd"`
 	Candles   []Candle `json:"candles"`
+	// NextCursor is passed as cursor to get the next page, it is empty on the last page.
+	NextCursor string `json:"nextCursor,omitempty"`
 }
 
 type Candle struct {
@@ -260,6 +769,188 @@ type Candle struct {
 	High      float64 `json:"high"`
 	Low       float64 `json:"low"`
 	Volume    float64 `json:"volume"`
+	// Anomalous flags an implausible print, which is excluded from aggregation by default.
+	Anomalous bool `json:"anomalous,omitempty"`
+	// Source is the ingestion path which produced the candle.
+	Source string `json:"source,omitempty"`
+}
+
+// Candle sources.
+const (
+	CandleSourceWS        = "ws"
+	CandleSourceREST      = "rest"
+	CandleSourceRollup    = "rollup"
+	CandleSourceIndex     = "index"
+	CandleSourceMerged    = "merged"
+	CandleSourceSynthetic = "synthetic"
+	CandleSourceResampled = "resampled"
+)
+
+// Strategies of merging the candles of the exchanges into one.
+const (
+	// MergeAverage averages open and close over the exchanges having the candle.
+	MergeAverage = "average"
+	// MergeVWAP weights open and close by the volume of every exchange.
+	MergeVWAP = "vwap"
+	// MergeMedian takes the median of every price over the exchanges.
+	MergeMedian = "median"
+	// MergePrimary takes the candle of the first exchange having it, Binance first.
+	MergePrimary = "primary-with-fallback"
+)
+
+// IsValidMerge reports whether s is a known merge strategy.
+func IsValidMerge(s string) bool {
+	switch s {
+	case MergeAverage, MergeVWAP, MergeMedian, MergePrimary:
+		return true
+	}
+	return false
+}
+
+const (
+	TradeSideBuy  = "buy"
+	TradeSideSell = "sell"
+)
+
+// Trade represents a single trade of an exchange. Time is in milliseconds.
+type Trade struct {
+	Exchange string  `json:"exchange"`
+	Symbol   string  `json:"symbol"`
+	ID       string  `json:"id"`
+	Price    float64 `json:"price"`
+	Quantity float64 `json:"quantity"`
+	// Side is the side of the taker.
+	Side string `json:"side"`
+	Time int64  `json:"time"`
+}
+
+// DepthDiff represents a raw order book update. A level with a zero size is removed.
+type DepthDiff struct {
+	Exchange      string   `json:"exchange"`
+	Symbol        string   `json:"symbol"`
+	FirstUpdateID int64    `json:"firstUpdateId"`
+	UpdateID      int64    `json:"updateId"`
+	Bids          []AskBid `json:"bids"`
+	Asks          []AskBid `json:"asks"`
+	// Time is the event time in milliseconds.
+	Time int64 `json:"time"`
+}
+
+// DepthDiffFromBinanceEvent converts a Binance diff depth event, skipping the unparsable levels.
+func DepthDiffFromBinanceEvent(symbol string, event *binance.WsDepthEvent) DepthDiff {
+	diff := DepthDiff{
+		Exchange:      "binance",
+		Symbol:        symbol,
+		FirstUpdateID: event.FirstUpdateID,
+		UpdateID:      event.UpdateID,
+		Bids:          make([]AskBid, 0, len(event.Bids)),
+		Asks:          make([]AskBid, 0, len(event.Asks)),
+		Time:          event.Time,
+	}
+
+	for _, v := range event.Bids {
+		price, err := strconv.ParseFloat(v.Price, 64)
+		if err != nil {
+			continue
+		}
+		diff.Bids = append(diff.Bids, AskBid{Price: price, Size: mustParseFloat64(v.Quantity)})
+	}
+
+	for _, v := range event.Asks {
+		price, err := strconv.ParseFloat(v.Price, 64)
+		if err != nil {
+			continue
+		}
+		diff.Asks = append(diff.Asks, AskBid{Price: price, Size: mustParseFloat64(v.Quantity)})
+	}
+
+	return diff
+}
+
+// TradeFromBinanceEvent converts a Binance trade event.
+func TradeFromBinanceEvent(event *binance.WsTradeEvent) (Trade, error) {
+	price, err := strconv.ParseFloat(event.Price, 64)
+	if err != nil {
+		return Trade{}, err
+	}
+
+	quantity, err := strconv.ParseFloat(event.Quantity, 64)
+	if err != nil {
+		return Trade{}, err
+	}
+
+	side := TradeSideBuy
+	if event.IsBuyerMaker {
+		side = TradeSideSell
+	}
+
+	return Trade{
+		Exchange: "binance",
+		Symbol:   event.Symbol,
+		ID:       strconv.FormatInt(event.TradeID, 10),
+		Price:    price,
+		Quantity: quantity,
+		Side:     side,
+		Time:     event.TradeTime,
+	}, nil
+}
+
+// TradeFromBinanceAggEvent converts a Binance aggregated trade event, the trades
+// of a single taker order at the same price.
+func TradeFromBinanceAggEvent(event *binance.WsAggTradeEvent) (Trade, error) {
+	price, err := strconv.ParseFloat(event.Price, 64)
+	if err != nil {
+		return Trade{}, err
+	}
+
+	quantity, err := strconv.ParseFloat(event.Quantity, 64)
+	if err != nil {
+		return Trade{}, err
+	}
+
+	side := TradeSideBuy
+	if event.IsBuyerMaker {
+		side = TradeSideSell
+	}
+
+	return Trade{
+		Exchange: "binance",
+		Symbol:   event.Symbol,
+		ID:       strconv.FormatInt(event.AggTradeID, 10),
+		Price:    price,
+		Quantity: quantity,
+		Side:     side,
+		Time:     event.TradeTime,
+	}, nil
+}
+
+// TradeFromBittrexAPI converts a Bittrex market history trade of the canonical symbol.
+func TradeFromBittrexAPI(symbol string, trade *bittrex.Trade) Trade {
+	price, _ := trade.Price.Float64()
+	quantity, _ := trade.Quantity.Float64()
+
+	return Trade{
+		Exchange: "bittrex",
+		Symbol:   symbol,
+		ID:       strconv.FormatInt(trade.OrderUuid, 10),
+		Price:    price,
+		Quantity: quantity,
+		Side:     strings.ToLower(trade.OrderType),
+		Time:     trade.Timestamp.UnixNano() / int64(time.Millisecond),
+	}
+}
+
+// CandleUpdate represents a candle pushed to the streaming consumers.
+type CandleUpdate struct {
+	Exchange string `json:"exchange"`
+	Symbol   string `json:"symbol"`
+	Interval string `json:"interval"`
+	Candle
+}
+
+// Price returns the close price of the candle.
+func (u CandleUpdate) Price() float64 {
+	return u.Close
 }
 
 func CandleFromEvent(event *binance.WsKlineEvent) *Candle {
@@ -276,6 +967,7 @@ func CandleFromEvent(event *binance.WsKlineEvent) *Candle {
 		High:      mustParseFloat64(event.Kline.High),
 		Low:       mustParseFloat64(event.Kline.Low),
 		Volume:    mustParseFloat64(event.Kline.Volume),
+		Source:    CandleSourceWS,
 	}
 }
 
@@ -289,6 +981,7 @@ func CandleFromBinanceAPI(candlestick *binance.Kline) *Candle {
 		High:      mustParseFloat64(candlestick.High),
 		Low:       mustParseFloat64(candlestick.Low),
 		Volume:    mustParseFloat64(candlestick.Volume),
+		Source:    CandleSourceREST,
 	}
 }
 
@@ -308,6 +1001,7 @@ func CandleFromBittrexAPI(candlestick *bittrex.Candle) *Candle {
 		High:      high,
 		Low:       low,
 		Volume:    volume,
+		Source:    CandleSourceREST,
 	}
 }
 
@@ -321,7 +1015,70 @@ func CandleFromPoloniexApi(candlestick *poloniex.CandleStick) *Candle {
 		High:      candlestick.High,
 		Low:       candlestick.Low,
 		Volume:    candlestick.Volume,
+		Source:    CandleSourceREST,
+	}
+}
+
+// DeviatesFrom reports whether the high or low of the candle deviates from the median
+// close of the reference candles by more than maxDeviation. Anomalous references are ignored.
+func DeviatesFrom(candle Candle, reference []Candle, maxDeviation float64) bool {
+	closes := make([]float64, 0, len(reference))
+	for _, v := range reference {
+		if !v.Anomalous && v.Close > 0 {
+			closes = append(closes, v.Close)
+		}
+	}
+	if len(closes) == 0 {
+		return false
+	}
+
+	median := Median(closes)
+	return candle.High > median*(1+maxDeviation) || candle.Low < median*(1-maxDeviation)
+}
+
+// Median returns the median of the values. The values are sorted in place.
+func Median(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+
+	sort.Float64s(values)
+
+	middle := len(values) / 2
+	if len(values)%2 == 0 {
+		return (values[middle-1] + values[middle]) / 2
 	}
+	return values[middle]
+}
+
+// Volatility returns the standard deviation of the log returns between the closes of
+// consecutive candles. The candles must be sorted by time.
+func Volatility(candles []Candle) float64 {
+	returns := make([]float64, 0, len(candles))
+	for i := 1; i < len(candles); i++ {
+		if candles[i-1].Close <= 0 || candles[i].Close <= 0 {
+			continue
+		}
+		returns = append(returns, math.Log(candles[i].Close/candles[i-1].Close))
+	}
+
+	if len(returns) < 2 {
+		return 0
+	}
+
+	var mean float64
+	for _, v := range returns {
+		mean += v
+	}
+	mean /= float64(len(returns))
+
+	var variance float64
+	for _, v := range returns {
+		variance += (v - mean) * (v - mean)
+	}
+	variance /= float64(len(returns) - 1)
+
+	return math.Sqrt(variance)
 }
 
 func mustParseFloat64(s string) float64 {
@@ -329,6 +1086,22 @@ func mustParseFloat64(s string) float64 {
 	return val
 }
 
+// quoteAssets are the quote assets the symbols are split by, longest first.
+var quoteAssets = []string{"USDT", "USDC", "BUSD", "TUSD", "USD", "BTC", "ETH", "BNB"}
+
+// USDAssets are priced at 1 USD.
+var USDAssets = map[string]bool{"USD": true, "USDT": true, "USDC": true, "BUSD": true, "TUSD": true}
+
+// QuoteAsset returns the quote asset of the symbol in the Binance format, or "" if it is unknown.
+func QuoteAsset(symbol string) string {
+	for _, v := range quoteAssets {
+		if strings.HasSuffix(symbol, v) && len(symbol) > len(v) {
+			return v
+		}
+	}
+	return ""
+}
+
 var BinanceSymbols = []string{
 	"LTCBTC", "ETHBTC", "DASHBTC", "ZECBTC", "BCHABCBTC", "BCHSVBTC", "XRPBTC", "WAVESBTC",
 	"LTCETH", "DASHETH", "ZECETH",
@@ -347,70 +1120,77 @@ var PoloniexSymbols = []string{
 	"USDT_BTC", "USDT_LTC", "USDT_ETH", "USDT_BCH",
 }
 
-func BittrexSymbolToBinance(symbol string) string {
-	switch symbol {
-	case "BTC-LTC":
-		return "LTCBTC"
-	case "BTC-ETH":
-		return "ETHBTC"
-	case "BTC-DASH":
-		return "DASHBTC"
-	case "BTC-ZEC":
-		return "ZECBTC"
-	case "BTC-BCH":
-		return "BCHABCBTC"
-	case "BTC-BSV":
-		return "BCHSVBTC"
-	case "BTC-XRP":
-		return "XRPBTC"
-	case "BTC-WAVES":
-		return "WAVESBTC"
-	case "ETH-LTC":
-		return "LTCETH"
-	case "ETH-DASH":
-		return "DASHETH"
-	case "ETH-ZEC":
-		return "ZECETH"
-	case "USD-BTC":
-		return "BTCUSDT"
-	case "USD-LTC":
-		return "LTCUSDT"
-	case "USD-ETH":
-		return "ETHUSDT"
-	case "USD-BCH":
-		return "BCHABCUSDT"
-	case "USD-BSV":
-		return "BCHSVUSDT"
+var OKXSymbols = []string{
+	"LTC-BTC", "ETH-BTC", "ZEC-BTC", "XRP-BTC",
+	"BTC-USDT", "LTC-USDT", "ETH-USDT", "BCH-USDT",
+}
+
+// BitfinexSymbols are the trading pairs, funding currencies are not supported.
+var BitfinexSymbols = []string{
+	"tLTCBTC", "tETHBTC", "tXRPBTC",
+	"tBTCUSD", "tLTCUSD", "tETHUSD", "tBTCUST",
+}
+
+var KuCoinSymbols = []string{
+	"LTC-BTC", "ETH-BTC", "XRP-BTC",
+	"BTC-USDT", "LTC-USDT", "ETH-USDT", "BCH-USDT",
+}
+
+var BybitSymbols = []string{
+	"ETHBTC", "XRPBTC",
+	"BTCUSDT", "LTCUSDT", "ETHUSDT", "XRPUSDT", "BCHUSDT",
+}
+
+var HuobiSymbols = []string{
+	"ethbtc", "ltcbtc", "xrpbtc",
+	"btcusdt", "ltcusdt", "ethusdt", "xrpusdt", "bchusdt",
+}
+
+var CoinbaseSymbols = []string{
+	"LTC-BTC", "ETH-BTC", "ZEC-BTC", "BCH-BTC", "XRP-BTC",
+	"BTC-USD", "LTC-USD", "ETH-USD", "BCH-USD",
+}
+
+// DefaultAssetAliases maps the asset names used by other exchanges to the Binance ones.
+var DefaultAssetAliases = map[string]string{
+	"USD": "USDT",
+	"BCH": "BCHABC",
+	"BSV": "BCHSV",
+}
+
+// CanonicalSymbol returns the pair in the Binance format, e.g. LTCBTC for base LTC and quote BTC.
+// Aliases take precedence over DefaultAssetAliases.
+func CanonicalSymbol(base, quote string, aliases map[string]string) string {
+	return canonicalAsset(base, aliases) + canonicalAsset(quote, aliases)
+}
+
+func canonicalAsset(asset string, aliases map[string]string) string {
+	asset = strings.ToUpper(asset)
+	if v, ok := aliases[asset]; ok {
+		return v
 	}
-	return ""
+	if v, ok := DefaultAssetAliases[asset]; ok {
+		return v
+	}
+	return asset
 }
 
-func PoloniexSymbolToBinance(v string) string {
-	switch v {
-	case "BTC_LTC":
-		return "LTCBTC"
-	case "BTC_ETH":
-		return "ETHBTC"
-	case "BTC_DASH":
-		return "DASHBTC"
-	case "BTC_ZEC":
-		return "ZECBTC"
-	case "BTC_BCH":
-		return "BCHABCBTC"
-	case "BTC_XRP":
-		return "XRPBTC"
-	case "BTC_WAVES":
-		return "WAVESBTC"
-	case "ETH_ZEC":
-		return "ZECETH"
-	case "USDT_BTC":
-		return "BTCUSDT"
-	case "USDT_LTC":
-		return "LTCUSDT"
-	case "USDT_ETH":
-		return "ETHUSDT"
-	case "USDT_BCH":
-		return "BCHABCUSDT"
+// ExcludeSymbols returns the symbols which are not in the blacklist.
+func ExcludeSymbols(symbols, blacklist []string) []string {
+	if len(blacklist) == 0 {
+		return symbols
 	}
-	return ""
+
+	excluded := make(map[string]bool, len(blacklist))
+	for _, v := range blacklist {
+		excluded[v] = true
+	}
+
+	result := make([]string, 0, len(symbols))
+	for _, v := range symbols {
+		if !excluded[v] {
+			result = append(result, v)
+		}
+	}
+	return result
 }
@@ -0,0 +1,209 @@
+package nats
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultPort = "4222"
+	dialTimeout = 5 * time.Second
+)
+
+// conn is a publishing connection speaking the NATS client protocol.
+type conn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	writer *bufio.Writer
+}
+
+type connectOptions struct {
+	Verbose  bool   `json:"verbose"`
+	Pedantic bool   `json:"pedantic"`
+	Name     string `json:"name"`
+	Lang     string `json:"lang"`
+	Version  string `json:"version"`
+	User     string `json:"user,omitempty"`
+	Pass     string `json:"pass,omitempty"`
+	Token    string `json:"auth_token,omitempty"`
+}
+
+// dial connects to the server of the nats://[user:password@]host[:port] URL and sends CONNECT.
+func dial(rawURL, token string) (*conn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not parse NATS URL")
+	}
+
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), defaultPort)
+	}
+
+	nc, err := net.DialTimeout("tcp", host, dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &conn{conn: nc, reader: bufio.NewReader(nc), writer: bufio.NewWriter(nc)}
+
+	// The server greets with INFO before accepting CONNECT.
+	line, err := c.readLine()
+	if err != nil {
+		nc.Close()
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		nc.Close()
+		return nil, fmt.Errorf("unexpected greeting %q", line)
+	}
+
+	options := connectOptions{Name: "price-feed", Lang: "go", Version: "1.0.0", Token: token}
+	if u.User != nil {
+		options.User = u.User.Username()
+		options.Pass, _ = u.User.Password()
+	}
+
+	data, err := json.Marshal(options)
+	if err != nil {
+		nc.Close()
+		return nil, err
+	}
+
+	if _, err = fmt.Fprintf(c.writer, "CONNECT %s\r\nPING\r\n", data); err != nil {
+		nc.Close()
+		return nil, err
+	}
+	if err = c.writer.Flush(); err != nil {
+		nc.Close()
+		return nil, err
+	}
+
+	// PONG confirms the CONNECT, an authorization failure is reported with -ERR instead.
+	for {
+		if line, err = c.readLine(); err != nil {
+			nc.Close()
+			return nil, err
+		}
+		switch {
+		case line == "PONG":
+			return c, nil
+		case strings.HasPrefix(line, "-ERR"):
+			nc.Close()
+			return nil, parseServerError(line)
+		}
+	}
+}
+
+// serverError is an -ERR message of the server.
+type serverError string
+
+func parseServerError(line string) serverError {
+	return serverError(strings.Trim(strings.TrimSpace(strings.TrimPrefix(line, "-ERR")), "'"))
+}
+
+func (e serverError) Error() string {
+	return "server error: " + string(e)
+}
+
+// fatal reports whether the server closes the connection after the error. It keeps it
+// open after a permissions violation or an invalid subject, which only fail a single
+// subscription or message.
+func (e serverError) fatal() bool {
+	msg := strings.ToLower(string(e))
+	return !strings.HasPrefix(msg, "permissions violation") && !strings.HasPrefix(msg, "invalid subject")
+}
+
+func (c *conn) readLine() (string, error) {
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// subscribe subscribes to the subject with the subscription ID.
+func (c *conn) subscribe(subject, sid string) error {
+	_, err := fmt.Fprintf(c.writer, "SUB %s %s\r\n", subject, sid)
+	return err
+}
+
+// publish buffers a message, reply is empty unless a response is expected.
+func (c *conn) publish(subject, reply string, data []byte) error {
+	var err error
+	if reply == "" {
+		_, err = fmt.Fprintf(c.writer, "PUB %s %d\r\n", subject, len(data))
+	} else {
+		_, err = fmt.Fprintf(c.writer, "PUB %s %s %d\r\n", subject, reply, len(data))
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, err = c.writer.Write(data); err != nil {
+		return err
+	}
+	_, err = c.writer.WriteString("\r\n")
+	return err
+}
+
+func (c *conn) flush() error {
+	return c.writer.Flush()
+}
+
+func (c *conn) close() error {
+	return c.conn.Close()
+}
+
+// serverMessage is a message delivered to a subscription.
+type serverMessage struct {
+	subject string
+	data    []byte
+}
+
+// read returns the next message, answering the pings of the server on the way.
+// A non-fatal error of the server is returned as a serverError, after which the connection
+// can still be read. The writes must be serialized with pong, see Publisher.
+func (c *conn) read(pong func() error) (serverMessage, error) {
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return serverMessage{}, err
+		}
+
+		switch {
+		case line == "PING":
+			if err = pong(); err != nil {
+				return serverMessage{}, err
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			return serverMessage{}, parseServerError(line)
+		case strings.HasPrefix(line, "MSG "):
+			// MSG <subject> <sid> [reply-to] <#bytes>
+			fields := strings.Fields(line)
+			if len(fields) < 4 {
+				return serverMessage{}, fmt.Errorf("malformed message %q", line)
+			}
+
+			size, err := strconv.Atoi(fields[len(fields)-1])
+			if err != nil {
+				return serverMessage{}, fmt.Errorf("malformed message %q", line)
+			}
+
+			data := make([]byte, size+2)
+			if _, err = io.ReadFull(c.reader, data); err != nil {
+				return serverMessage{}, err
+			}
+			return serverMessage{subject: fields[1], data: data[:size]}, nil
+		}
+	}
+}
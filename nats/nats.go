@@ -0,0 +1,272 @@
+package nats
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"price-feed/hub"
+	"price-feed/logger"
+	"price-feed/models"
+)
+
+const (
+	defaultSubjectPrefix     = "prices"
+	defaultReconnectInterval = 5 * time.Second
+	maxReconnectInterval     = time.Minute
+	queueSize                = 10000
+	ackInbox                 = "_INBOX.price-feed"
+	ackSID                   = "1"
+)
+
+// Config represents a NATS publisher configuration.
+type Config struct {
+	// URL is the server address, nats://[user:password@]host[:port].
+	URL   string `json:"url"`
+	Token string `json:"token"`
+	// SubjectPrefix starts the subjects, e.g. prices.binance.BTCUSDT.kline.1m; prices by default.
+	SubjectPrefix string `json:"subject_prefix"`
+	// JetStream requests an acknowledgement of every message from the JetStream
+	// stream capturing the subjects, so the messages which weren't persisted are logged.
+	JetStream bool `json:"jetstream"`
+	// ReconnectInterval is the first delay before a reconnect, 5s by default. It doubles
+	// on every failed attempt up to a minute.
+	ReconnectInterval string `json:"reconnect_interval"`
+}
+
+type message struct {
+	subject string
+	data    []byte
+}
+
+// Publisher forwards the market data published to the hub to the NATS subjects
+// <prefix>.<exchange>.<symbol>.kline.<interval>, .candle.<interval> for the closed
+// candles, .trades and .book.
+type Publisher struct {
+	config            *Config
+	log               *logger.Logger
+	hub               *hub.Hub
+	reconnectInterval time.Duration
+	queue             chan message
+	dropped           int64
+	writeMu           sync.Mutex
+	sequence          int64
+	quit              chan struct{}
+}
+
+// New returns a new NATS publisher of the hub messages.
+func New(config *Config, log *logger.Logger, hub *hub.Hub) (*Publisher, error) {
+	if config.URL == "" {
+		return nil, errors.New("NATS URL is empty")
+	}
+
+	if config.SubjectPrefix == "" {
+		config.SubjectPrefix = defaultSubjectPrefix
+	}
+
+	p := &Publisher{
+		config:            config,
+		log:               log,
+		hub:               hub,
+		reconnectInterval: defaultReconnectInterval,
+		queue:             make(chan message, queueSize),
+		quit:              make(chan struct{}),
+	}
+
+	if config.ReconnectInterval != "" {
+		interval, err := time.ParseDuration(config.ReconnectInterval)
+		if err != nil {
+			return nil, errors.Wrapf(err, "couldn't parse NATS reconnect interval")
+		}
+		p.reconnectInterval = interval
+	}
+
+	return p, nil
+}
+
+// Start starts forwarding the hub messages.
+func (p *Publisher) Start() {
+	p.hub.AddTap(p.tap)
+	go p.run()
+}
+
+// Stop closes the connection, the queued messages are dropped.
+func (p *Publisher) Stop() {
+	close(p.quit)
+}
+
+// subject returns the subject of the hub message, or "" if it isn't forwarded.
+func (p *Publisher) subject(channel string, data interface{}) string {
+	parts := strings.Split(channel, ".")
+
+	var exchange, symbol string
+	switch v := data.(type) {
+	case models.CandleUpdate:
+		exchange, symbol = v.Exchange, v.Symbol
+	case models.Trade:
+		exchange, symbol = v.Exchange, v.Symbol
+	case models.BookChange:
+		exchange, symbol = v.Exchange, v.Symbol
+	default:
+		return ""
+	}
+
+	subject := p.config.SubjectPrefix + "." + exchange + "." + symbol + "." + parts[0]
+	if len(parts) == 3 {
+		subject += "." + parts[1]
+	}
+	return subject
+}
+
+func (p *Publisher) tap(channel string, data interface{}) {
+	subject := p.subject(channel, data)
+	if subject == "" {
+		return
+	}
+
+	payload, err := json.Marshal(data)
+	if err != nil {
+		p.log.Errorf("Could not marshal NATS message: %v", err)
+		return
+	}
+
+	select {
+	case p.queue <- message{subject: subject, data: payload}:
+	default:
+		if atomic.AddInt64(&p.dropped, 1)%1000 == 1 {
+			p.log.Warnf("NATS queue is full, %v messages dropped", atomic.LoadInt64(&p.dropped))
+		}
+	}
+}
+
+// run publishes the queued messages, reconnecting with an exponential backoff when
+// the connection fails, until the publisher is stopped.
+func (p *Publisher) run() {
+	maxInterval := maxReconnectInterval
+	if p.reconnectInterval > maxInterval {
+		maxInterval = p.reconnectInterval
+	}
+
+	backoff := p.reconnectInterval
+	for {
+		c, err := dial(p.config.URL, p.config.Token)
+		if err != nil {
+			p.log.Errorf("Could not connect to NATS, retrying in %v: %v", backoff, err)
+		} else {
+			p.log.Infof("Publishing market data to NATS %v", p.config.URL)
+			backoff = p.reconnectInterval
+
+			err = p.serve(c)
+			c.close()
+			if err == nil {
+				return
+			}
+			p.log.Warnf("NATS connection is done, reconnecting in %v: %v", backoff, err)
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-p.quit:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		if backoff *= 2; backoff > maxInterval {
+			backoff = maxInterval
+		}
+	}
+}
+
+// serve writes the queued messages and reads the server messages until the connection fails,
+// or returns nil once the publisher is stopped.
+func (p *Publisher) serve(c *conn) error {
+	if p.config.JetStream {
+		if err := c.subscribe(ackInbox+".*", ackSID); err != nil {
+			return err
+		}
+	}
+
+	readErr := make(chan error, 1)
+	go func() {
+		for {
+			msg, err := c.read(func() error {
+				p.writeMu.Lock()
+				defer p.writeMu.Unlock()
+
+				if _, err := c.writer.WriteString("PONG\r\n"); err != nil {
+					return err
+				}
+				return c.flush()
+			})
+			if serr, ok := err.(serverError); ok && !serr.fatal() {
+				p.log.Warnf("NATS rejected a message: %v", serr)
+				continue
+			}
+			if err != nil {
+				readErr <- err
+				return
+			}
+			p.checkAck(msg)
+		}
+	}()
+
+	for {
+		select {
+		case <-p.quit:
+			return nil
+		case err := <-readErr:
+			return err
+		case m := <-p.queue:
+			if err := p.write(c, m); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// write publishes the message and the rest of the queue, then flushes the connection.
+func (p *Publisher) write(c *conn, m message) error {
+	p.writeMu.Lock()
+	defer p.writeMu.Unlock()
+
+	for {
+		reply := ""
+		if p.config.JetStream {
+			reply = ackInbox + "." + strconv.FormatInt(atomic.AddInt64(&p.sequence, 1), 10)
+		}
+
+		if err := c.publish(m.subject, reply, m.data); err != nil {
+			return err
+		}
+
+		select {
+		case m = <-p.queue:
+			continue
+		default:
+		}
+		return c.flush()
+	}
+}
+
+// checkAck logs the JetStream acknowledgements reporting that a message wasn't persisted.
+func (p *Publisher) checkAck(msg serverMessage) {
+	var ack struct {
+		Error *struct {
+			Code        int    `json:"code"`
+			Description string `json:"description"`
+		} `json:"error"`
+	}
+
+	if err := json.Unmarshal(msg.data, &ack); err != nil {
+		p.log.Warnf("Could not parse JetStream acknowledgement %q: %v", msg.data, err)
+		return
+	}
+	if ack.Error != nil {
+		p.log.Warnf("JetStream did not persist message %v: %v %v", msg.subject, ack.Error.Code, ack.Error.Description)
+	}
+}
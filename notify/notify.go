@@ -0,0 +1,217 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	"price-feed/hub"
+	"price-feed/logger"
+	"price-feed/models"
+)
+
+const (
+	defaultLevels         = 5
+	defaultTicks          = 1
+	defaultTickSize       = 1e-8
+	defaultCheckInterval  = time.Second
+	defaultWebhookTimeout = 5 * time.Second
+	webhookQueueSize      = 1024
+	webhookContentType    = "application/json"
+	bookChannelPrefix     = "book."
+)
+
+// Config represents an order book change notifier configuration.
+type Config struct {
+	// Levels is the number of top levels per side which are watched, 5 by default.
+	Levels int `json:"levels"`
+	// Ticks is the price move, in ticks of the symbol, a level or the mid price must
+	// exceed to fire a notification; 1 by default.
+	Ticks float64 `json:"ticks"`
+	// TickSizes maps symbols to their tick sizes. DefaultTickSize is used for the rest.
+	TickSizes       map[string]float64 `json:"tick_sizes"`
+	DefaultTickSize float64            `json:"default_tick_size"`
+	CheckInterval   string             `json:"check_interval"`
+	// Webhooks receive every notification as a JSON POST request.
+	Webhooks       []string `json:"webhooks"`
+	WebhookTimeout string   `json:"webhook_timeout"`
+}
+
+// OrderBookSource represents an exchange worker which maintains order books.
+type OrderBookSource interface {
+	Symbols() []string
+	GetOrderBook(symbol string) (models.OrderBookInternal, bool)
+}
+
+// Notifier watches the order books and reports the changes of their top levels,
+// skipping the ones within the tick threshold.
+type Notifier struct {
+	config        *Config
+	log           *logger.Logger
+	hub           *hub.Hub
+	sources       map[string]OrderBookSource
+	checkInterval time.Duration
+	client        *http.Client
+	webhooks      chan []byte
+	last          map[string]models.BookChange
+}
+
+// New returns a new notifier of the order books of the sources, keyed by exchange.
+func New(config *Config, log *logger.Logger, hub *hub.Hub, sources map[string]OrderBookSource) (*Notifier, error) {
+	n := &Notifier{
+		config:        config,
+		log:           log,
+		hub:           hub,
+		sources:       sources,
+		checkInterval: defaultCheckInterval,
+		client:        &http.Client{Timeout: defaultWebhookTimeout},
+		webhooks:      make(chan []byte, webhookQueueSize),
+		last:          make(map[string]models.BookChange),
+	}
+
+	if config.Levels <= 0 {
+		config.Levels = defaultLevels
+	}
+	if config.Ticks <= 0 {
+		config.Ticks = defaultTicks
+	}
+	if config.DefaultTickSize <= 0 {
+		config.DefaultTickSize = defaultTickSize
+	}
+
+	if config.CheckInterval != "" {
+		interval, err := time.ParseDuration(config.CheckInterval)
+		if err != nil {
+			return nil, errors.Wrapf(err, "couldn't parse notifier check interval")
+		}
+		n.checkInterval = interval
+	}
+
+	if config.WebhookTimeout != "" {
+		timeout, err := time.ParseDuration(config.WebhookTimeout)
+		if err != nil {
+			return nil, errors.Wrapf(err, "couldn't parse notifier webhook timeout")
+		}
+		n.client.Timeout = timeout
+	}
+
+	return n, nil
+}
+
+// Start starts checking the order books and delivering the notifications.
+func (n *Notifier) Start() {
+	if len(n.config.Webhooks) > 0 {
+		go n.deliverWebhooks()
+	}
+	go n.run()
+}
+
+func (n *Notifier) run() {
+	for ; ; <-time.Tick(n.checkInterval) {
+		for exchange, source := range n.sources {
+			for _, symbol := range source.Symbols() {
+				orderBook, ok := source.GetOrderBook(symbol)
+				if !ok {
+					continue
+				}
+				n.check(exchange, symbol, orderBook)
+			}
+		}
+	}
+}
+
+// check notifies about the order book if its top levels moved beyond the threshold
+// since the last notification.
+func (n *Notifier) check(exchange, symbol string, orderBook models.OrderBookInternal) {
+	top := orderBook.Format(n.config.Levels, 0)
+	change := models.BookChange{
+		Exchange: exchange,
+		Symbol:   symbol,
+		Bids:     top.Bids,
+		Asks:     top.Asks,
+		Time:     time.Now().UnixNano() / int64(time.Millisecond),
+	}
+	bbo := orderBook.BestBidOffer()
+	change.Mid = bbo.Price()
+
+	key := exchange + ":" + symbol
+	if last, ok := n.last[key]; ok && !n.changed(last, change) {
+		return
+	}
+	n.last[key] = change
+
+	n.hub.Publish(bookChannelPrefix+symbol, change)
+
+	if len(n.config.Webhooks) == 0 {
+		return
+	}
+
+	data, err := json.Marshal(change)
+	if err != nil {
+		n.log.Errorf("Could not marshal order book change: %v", err)
+		return
+	}
+
+	select {
+	case n.webhooks <- data:
+	default:
+		n.log.Warnf("Webhook queue is full, dropping order book change of %v %v", exchange, symbol)
+	}
+}
+
+// changed reports whether the mid price or any of the top levels moved by more than
+// the threshold, or a level appeared or disappeared.
+func (n *Notifier) changed(last, current models.BookChange) bool {
+	threshold := n.config.Ticks * n.tickSize(current.Symbol)
+
+	if math.Abs(current.Mid-last.Mid) > threshold {
+		return true
+	}
+
+	for _, side := range [][2][]models.AskBid{{last.Bids, current.Bids}, {last.Asks, current.Asks}} {
+		if len(side[0]) != len(side[1]) {
+			return true
+		}
+		for i := range side[0] {
+			if math.Abs(side[1][i].Price-side[0][i].Price) > threshold {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (n *Notifier) tickSize(symbol string) float64 {
+	if v, ok := n.config.TickSizes[symbol]; ok && v > 0 {
+		return v
+	}
+	return n.config.DefaultTickSize
+}
+
+// deliverWebhooks posts the queued notifications to every webhook.
+func (n *Notifier) deliverWebhooks() {
+	for data := range n.webhooks {
+		for _, url := range n.config.Webhooks {
+			if err := n.post(url, data); err != nil {
+				n.log.Errorf("Could not deliver order book change to webhook %v: %v", url, err)
+			}
+		}
+	}
+}
+
+func (n *Notifier) post(url string, data []byte) error {
+	resp, err := n.client.Post(url, webhookContentType, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.Errorf("received bad status code: %v", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package pipeline
+
+import (
+	"sync"
+	"time"
+
+	"price-feed/logger"
+	"price-feed/models"
+	"price-feed/tracing"
+)
+
+// Event is a normalized market data event passed through the stages, a *CandleEvent or a *TradeEvent.
+type Event interface {
+	Source() (exchange, symbol string)
+	span() *tracing.Span
+}
+
+// CandleEvent is an update of a candle of the exchange, the symbol in the Binance format.
+type CandleEvent struct {
+	Exchange string
+	Symbol   string
+	Interval string
+	Candle   models.Candle
+	// Final marks a closed candle, see Persist.
+	Final    bool
+	Received time.Time
+	// Span is the trace of the event, the stages are recorded as its children.
+	Span *tracing.Span
+}
+
+// Source returns the exchange and the symbol of the candle.
+func (e *CandleEvent) Source() (exchange, symbol string) {
+	return e.Exchange, e.Symbol
+}
+
+func (e *CandleEvent) span() *tracing.Span {
+	return e.Span
+}
+
+// TradeEvent is a trade of the exchange.
+type TradeEvent struct {
+	Trade    models.Trade
+	Received time.Time
+	Span     *tracing.Span
+}
+
+// Source returns the exchange and the symbol of the trade.
+func (e *TradeEvent) Source() (exchange, symbol string) {
+	return e.Trade.Exchange, e.Trade.Symbol
+}
+
+func (e *TradeEvent) span() *tracing.Span {
+	return e.Span
+}
+
+// Stage processes the events in turn. It may modify the event, e.g. to enrich it,
+// and returns false to drop the event from the following stages.
+type Stage interface {
+	Name() string
+	Process(event Event) (keep bool, err error)
+}
+
+// Pipeline passes the events received by a worker through the stages, typically
+// validate, enrich, publish and persist, so publishers and filters are plugged in
+// without touching the stream handlers.
+type Pipeline struct {
+	log    *logger.Logger
+	mu     sync.RWMutex
+	stages []Stage
+}
+
+// New returns a pipeline of the stages.
+func New(log *logger.Logger, stages ...Stage) *Pipeline {
+	return &Pipeline{log: log, stages: stages}
+}
+
+// Use inserts the stage before the stage named before, or appends it if there is no such stage.
+func (p *Pipeline) Use(stage Stage, before string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for i, v := range p.stages {
+		if v.Name() == before {
+			p.stages = append(p.stages[:i], append([]Stage{stage}, p.stages[i:]...)...)
+			return
+		}
+	}
+	p.stages = append(p.stages, stage)
+}
+
+// Process passes the event through the stages until one of them drops it.
+// The errors are logged, a failed stage decides itself whether the event goes on.
+func (p *Pipeline) Process(event Event) {
+	p.mu.RLock()
+	stages := p.stages
+	p.mu.RUnlock()
+
+	for _, stage := range stages {
+		span := event.span().Child("pipeline." + stage.Name())
+		keep, err := stage.Process(event)
+		span.SetError(err)
+		span.End()
+
+		if err != nil {
+			exchange, symbol := event.Source()
+			p.log.Warnf("Stage %v failed on %v %v event: %v", stage.Name(), exchange, symbol, err)
+		}
+		if !keep {
+			return
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package pipeline
+
+import (
+	"fmt"
+	"math"
+
+	"price-feed/hub"
+	"price-feed/models"
+)
+
+// Store persists the events.
+type Store interface {
+	StoreExchangeCandlestick(exchange, symbol, interval string, candle *models.Candle) error
+	StoreCurrentCandlestick(exchange, symbol, interval string, candle *models.Candle) error
+	StoreTrades(exchange, symbol string, trades []models.Trade) error
+}
+
+type funcStage struct {
+	name string
+	fn   func(event Event) (bool, error)
+}
+
+func (s funcStage) Name() string {
+	return s.name
+}
+
+func (s funcStage) Process(event Event) (bool, error) {
+	return s.fn(event)
+}
+
+// Func returns a stage calling fn, e.g. to enrich the events or cache them in the worker.
+func Func(name string, fn func(event Event) (keep bool, err error)) Stage {
+	return funcStage{name: name, fn: fn}
+}
+
+// Validate returns a stage dropping the candles and the trades with impossible values,
+// e.g. a high below the low or a non-positive price.
+func Validate() Stage {
+	return Func("validate", func(event Event) (bool, error) {
+		var err error
+		switch e := event.(type) {
+		case *CandleEvent:
+			err = validateCandle(e.Candle)
+		case *TradeEvent:
+			err = validateTrade(e.Trade)
+		}
+		return err == nil, err
+	})
+}
+
+func validateCandle(c models.Candle) error {
+	for _, v := range []float64{c.Open, c.High, c.Low, c.Close, c.Volume} {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return fmt.Errorf("candle of %v has a non-finite value", c.TimeStart)
+		}
+	}
+
+	switch {
+	case c.Low <= 0:
+		return fmt.Errorf("candle of %v has non-positive low %v", c.TimeStart, c.Low)
+	case c.High < c.Low:
+		return fmt.Errorf("candle of %v has high %v below low %v", c.TimeStart, c.High, c.Low)
+	case c.Open < c.Low || c.Open > c.High || c.Close < c.Low || c.Close > c.High:
+		return fmt.Errorf("candle of %v has open or close outside of [%v; %v]", c.TimeStart, c.Low, c.High)
+	case c.Volume < 0:
+		return fmt.Errorf("candle of %v has negative volume %v", c.TimeStart, c.Volume)
+	case c.TimeEnd < c.TimeStart:
+		return fmt.Errorf("candle of %v ends at %v", c.TimeStart, c.TimeEnd)
+	}
+	return nil
+}
+
+func validateTrade(t models.Trade) error {
+	if !(t.Price > 0) || !(t.Quantity > 0) {
+		return fmt.Errorf("trade %v has price %v and quantity %v", t.ID, t.Price, t.Quantity)
+	}
+	return nil
+}
+
+// Publish returns a stage pushing the candles to kline.<interval>.<symbol>
+// and the trades to trades.<symbol> of the hub, stamped with their ingest time.
+func Publish(h *hub.Hub) Stage {
+	return Func("publish", func(event Event) (bool, error) {
+		switch e := event.(type) {
+		case *CandleEvent:
+			h.PublishAt("kline."+e.Interval+"."+e.Symbol, models.CandleUpdate{
+				Exchange: e.Exchange,
+				Symbol:   e.Symbol,
+				Interval: e.Interval,
+				Candle:   e.Candle,
+			}, e.Received)
+		case *TradeEvent:
+			h.PublishAt("trades."+e.Trade.Symbol, e.Trade, e.Received)
+		}
+		return true, nil
+	})
+}
+
+// Persist returns a stage writing the events to the store. With closedOnly, the candles
+// which aren't final are kept under a separate key instead of the candlestick set.
+func Persist(store Store, closedOnly bool) Stage {
+	return Func("persist", func(event Event) (bool, error) {
+		switch e := event.(type) {
+		case *CandleEvent:
+			if closedOnly && !e.Final {
+				return true, store.StoreCurrentCandlestick(e.Exchange, e.Symbol, e.Interval, &e.Candle)
+			}
+			return true, store.StoreExchangeCandlestick(e.Exchange, e.Symbol, e.Interval, &e.Candle)
+		case *TradeEvent:
+			return true, store.StoreTrades(e.Trade.Exchange, e.Trade.Symbol, []models.Trade{e.Trade})
+		}
+		return true, nil
+	})
+}
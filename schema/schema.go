@@ -0,0 +1,100 @@
+package schema
+
+import (
+	"reflect"
+	"strings"
+)
+
+const draft = "http://json-schema.org/draft-07/schema#"
+
+// Schema represents a JSON Schema document.
+type Schema map[string]interface{}
+
+// Generate returns the JSON Schema of the json encoding of v.
+func Generate(v interface{}) Schema {
+	s := generate(reflect.TypeOf(v))
+	s["$schema"] = draft
+	return s
+}
+
+func generate(t reflect.Type) Schema {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return generate(t.Elem())
+	case reflect.Bool:
+		return Schema{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return Schema{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return Schema{"type": "number"}
+	case reflect.String:
+		return Schema{"type": "string"}
+	case reflect.Slice:
+		return Schema{"type": "array", "items": generate(t.Elem())}
+	case reflect.Array:
+		return Schema{
+			"type":     "array",
+			"items":    generate(t.Elem()),
+			"minItems": t.Len(),
+			"maxItems": t.Len(),
+		}
+	case reflect.Map:
+		return Schema{"type": "object", "additionalProperties": generate(t.Elem())}
+	case reflect.Struct:
+		properties := make(map[string]interface{})
+		required := make([]string, 0)
+		addFields(t, properties, &required)
+		return Schema{"type": "object", "properties": properties, "required": required}
+	default:
+		// Interfaces may hold any value.
+		return Schema{}
+	}
+}
+
+// addFields adds the json fields of the struct, including those promoted
+// from embedded structs, to properties.
+func addFields(t reflect.Type, properties map[string]interface{}, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+
+		parts := strings.Split(tag, ",")
+		name := parts[0]
+
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Ptr {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				addFields(embedded, properties, required)
+				continue
+			}
+		}
+
+		if field.PkgPath != "" {
+			continue
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+
+		properties[name] = generate(field.Type)
+
+		omitempty := false
+		for _, v := range parts[1:] {
+			if v == "omitempty" {
+				omitempty = true
+			}
+		}
+		if !omitempty {
+			*required = append(*required, name)
+		}
+	}
+}
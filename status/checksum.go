@@ -0,0 +1,52 @@
+package status
+
+import (
+	"sync"
+)
+
+// Checksum represents the order book checksum verifications of a single symbol.
+type Checksum struct {
+	Checked    int64 `json:"checked"`
+	Mismatches int64 `json:"mismatches"`
+	// Valid reports whether the last verification succeeded.
+	Valid bool `json:"valid"`
+}
+
+// Checksums records the order book checksum verifications of every symbol of an exchange.
+type Checksums struct {
+	mu      sync.Mutex
+	symbols map[string]Checksum
+}
+
+// NewChecksums returns a new empty checksum record.
+func NewChecksums() *Checksums {
+	return &Checksums{
+		symbols: make(map[string]Checksum),
+	}
+}
+
+// Record records a verification of the order book of the symbol.
+func (c *Checksums) Record(symbol string, valid bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	checksum := c.symbols[symbol]
+	checksum.Checked++
+	if !valid {
+		checksum.Mismatches++
+	}
+	checksum.Valid = valid
+	c.symbols[symbol] = checksum
+}
+
+// All returns a copy of the verifications by symbol.
+func (c *Checksums) All() map[string]Checksum {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	result := make(map[string]Checksum, len(c.symbols))
+	for k, v := range c.symbols {
+		result[k] = v
+	}
+	return result
+}
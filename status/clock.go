@@ -0,0 +1,85 @@
+package status
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// Clock estimates the skew of an exchange clock, so the event times reported
+// by the exchange are converted to the local clock at ingest.
+type Clock struct {
+	// precision is the resolution of the server time.
+	precision time.Duration
+	// skew is the exchange time minus the local one in nanoseconds.
+	skew int64
+}
+
+// NewClock returns a new clock with no skew.
+func NewClock(precision time.Duration) *Clock {
+	return &Clock{
+		precision: precision,
+	}
+}
+
+// Measure queries the exchange time and compares it to the middle of the request,
+// which cancels out a symmetric network delay.
+func (c *Clock) Measure(serverTime func() (time.Time, error)) error {
+	before := time.Now()
+	t, err := serverTime()
+	if err != nil {
+		return err
+	}
+	after := time.Now()
+
+	// The skew is known up to the resolution of the server time or half of the round trip,
+	// smaller ones are within the error.
+	tolerance := after.Sub(before) / 2
+	if c.precision > tolerance {
+		tolerance = c.precision
+	}
+
+	skew := t.Sub(before.Add(after.Sub(before) / 2))
+	if skew < tolerance && skew > -tolerance {
+		skew = 0
+	}
+
+	atomic.StoreInt64(&c.skew, int64(skew))
+	return nil
+}
+
+// Skew returns the last measured skew of the exchange clock.
+func (c *Clock) Skew() time.Duration {
+	return time.Duration(atomic.LoadInt64(&c.skew))
+}
+
+// Adjust converts an exchange time in milliseconds to the local clock. Zero times are kept.
+func (c *Clock) Adjust(ms int64) int64 {
+	if ms == 0 {
+		return 0
+	}
+	return ms - int64(c.Skew()/time.Millisecond)
+}
+
+// ServerDate returns the time in the Date header of the response to the URL, for the
+// exchanges without a time endpoint. The header has a precision of a second, so half
+// of it is added to estimate the middle of the second.
+func ServerDate(url string) (time.Time, error) {
+	resp, err := http.Head(url)
+	if err != nil {
+		return time.Time{}, err
+	}
+	resp.Body.Close()
+
+	date := resp.Header.Get("Date")
+	if date == "" {
+		return time.Time{}, fmt.Errorf("%v returned no Date header", url)
+	}
+
+	t, err := http.ParseTime(date)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return t.Add(500 * time.Millisecond), nil
+}
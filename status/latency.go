@@ -0,0 +1,112 @@
+package status
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const defaultLatencySamples = 1024
+
+// Latency represents the end-to-end latency percentiles of a symbol over the last samples.
+type Latency struct {
+	Symbol string `json:"symbol"`
+	// Samples is the number of deliveries the percentiles are computed over.
+	Samples int `json:"samples"`
+	// P50 and P99 are in milliseconds.
+	P50 float64 `json:"p50"`
+	P99 float64 `json:"p99"`
+	Max float64 `json:"max"`
+}
+
+// Latencies records the latency samples of every symbol in a ring of the last samples,
+// so the percentiles follow the current load rather than the whole uptime.
+type Latencies struct {
+	mu      sync.Mutex
+	size    int
+	samples map[string]*latencyRing
+}
+
+type latencyRing struct {
+	values []time.Duration
+	next   int
+}
+
+// NewLatencies returns a new latency recorder keeping the last size samples per symbol,
+// 1024 by default.
+func NewLatencies(size int) *Latencies {
+	if size <= 0 {
+		size = defaultLatencySamples
+	}
+
+	return &Latencies{
+		size:    size,
+		samples: make(map[string]*latencyRing),
+	}
+}
+
+// Record adds a latency sample of the symbol.
+func (l *Latencies) Record(symbol string, latency time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	ring, ok := l.samples[symbol]
+	if !ok {
+		ring = &latencyRing{values: make([]time.Duration, 0, l.size)}
+		l.samples[symbol] = ring
+	}
+
+	if len(ring.values) < l.size {
+		ring.values = append(ring.values, latency)
+		return
+	}
+	ring.values[ring.next] = latency
+	ring.next = (ring.next + 1) % l.size
+}
+
+// All returns the latency percentiles of every symbol sorted by symbol.
+func (l *Latencies) All() []Latency {
+	l.mu.Lock()
+	rings := make(map[string][]time.Duration, len(l.samples))
+	for symbol, ring := range l.samples {
+		rings[symbol] = append([]time.Duration(nil), ring.values...)
+	}
+	l.mu.Unlock()
+
+	latencies := make([]Latency, 0, len(rings))
+	for symbol, values := range rings {
+		sort.Slice(values, func(i, j int) bool {
+			return values[i] < values[j]
+		})
+
+		latencies = append(latencies, Latency{
+			Symbol:  symbol,
+			Samples: len(values),
+			P50:     milliseconds(percentile(values, 0.5)),
+			P99:     milliseconds(percentile(values, 0.99)),
+			Max:     milliseconds(values[len(values)-1]),
+		})
+	}
+
+	sort.Slice(latencies, func(i, j int) bool {
+		return latencies[i].Symbol < latencies[j].Symbol
+	})
+
+	return latencies
+}
+
+// percentile returns the nearest-rank percentile of the sorted values.
+func percentile(values []time.Duration, p float64) time.Duration {
+	i := int(p*float64(len(values))+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(values) {
+		i = len(values) - 1
+	}
+	return values[i]
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
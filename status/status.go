@@ -0,0 +1,91 @@
+package status
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Stream represents the last event received on a single stream.
+type Stream struct {
+	Name          string `json:"name"`
+	LastEventID   int64  `json:"lastEventId"`
+	LastEventTime int64  `json:"lastEventTime"`
+	ReceivedAt    int64  `json:"receivedAt"`
+}
+
+// Tracker records the last event of every stream of an exchange.
+type Tracker struct {
+	mu      sync.Mutex
+	streams map[string]Stream
+}
+
+// NewTracker returns a new stream tracker.
+func NewTracker() *Tracker {
+	return &Tracker{
+		streams: make(map[string]Stream),
+	}
+}
+
+// Track records an event on the stream. Times are in milliseconds.
+func (t *Tracker) Track(name string, eventID, eventTime int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.streams[name] = Stream{
+		Name:          name,
+		LastEventID:   eventID,
+		LastEventTime: eventTime,
+		ReceivedAt:    time.Now().UnixNano() / int64(time.Millisecond),
+	}
+}
+
+// Streams returns all tracked streams sorted by name.
+func (t *Tracker) Streams() []Stream {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	streams := make([]Stream, 0, len(t.streams))
+	for _, v := range t.streams {
+		streams = append(streams, v)
+	}
+
+	sort.Slice(streams, func(i, j int) bool {
+		return streams[i].Name < streams[j].Name
+	})
+
+	return streams
+}
+
+// SymbolStatuses holds the trading statuses of the exchange symbols.
+type SymbolStatuses struct {
+	mu       sync.Mutex
+	statuses map[string]string
+}
+
+// NewSymbolStatuses returns a new empty symbol status set.
+func NewSymbolStatuses() *SymbolStatuses {
+	return &SymbolStatuses{
+		statuses: make(map[string]string),
+	}
+}
+
+// Set replaces the statuses.
+func (s *SymbolStatuses) Set(statuses map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.statuses = statuses
+}
+
+// All returns a copy of the statuses.
+func (s *SymbolStatuses) All() map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := make(map[string]string, len(s.statuses))
+	for k, v := range s.statuses {
+		result[k] = v
+	}
+	return result
+}
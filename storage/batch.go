@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"time"
+
+	"price-feed/models"
+
+	"gopkg.in/redis.v3"
+)
+
+// storeBatchSize is the number of candles written in one transaction.
+const storeBatchSize = 500
+
+// StoreCandlesticks stores the normalized candles of the exchange, e.g. a warmup backfill,
+// writing them in pipelined transactions of storeBatchSize candles instead of one by one.
+// The candles are flagged like the ones stored by StoreCandlestick<Exchange> and the
+// affected rollups are rebuilt once per day.
+func (c *Client) StoreCandlesticks(exchange, symbol, interval string, candles []models.Candle) error {
+	if len(candles) == 0 {
+		return nil
+	}
+
+	sort.Slice(candles, func(i, j int) bool {
+		return candles[i].TimeStart < candles[j].TimeStart
+	})
+
+	c.flagAnomalies(exchange, symbol, interval, candles)
+
+	if c.skipWrite("%v %v candles of %v %v", len(candles), interval, exchange, symbol) {
+		return nil
+	}
+
+	key := c.formatKey(exchange, "candlestick", symbol, interval)
+	for start := 0; start < len(candles); start += storeBatchSize {
+		end := start + storeBatchSize
+		if end > len(candles) {
+			end = len(candles)
+		}
+
+		if err := c.storeBatch(key, candles[start:end]); err != nil {
+			return err
+		}
+	}
+
+	c.storeHistoryCandles(exchange, symbol, interval, candles)
+
+	if rollupSourceIntervals[exchange] != interval {
+		return nil
+	}
+
+	days := make(map[int64]bool)
+	for _, v := range candles {
+		dayStart := time.Unix(v.TimeStart, 0).Truncate(day).Unix()
+		if days[dayStart] {
+			continue
+		}
+		days[dayStart] = true
+
+		if err := c.updateRollups(exchange, symbol, v.TimeStart); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// storeBatch replaces the candles of the sorted set with the same open times in one transaction.
+func (c *Client) storeBatch(key string, candles []models.Candle) error {
+	members := make([]redis.Z, 0, len(candles))
+	for _, v := range candles {
+		data, err := json.Marshal(v)
+		if err != nil {
+			c.log.Errorf("Could not marshal candlestick: %v", err)
+			return err
+		}
+		members = append(members, redis.Z{Score: float64(v.TimeStart), Member: string(data)})
+	}
+
+	multi := c.redis().Multi()
+	defer multi.Close()
+
+	_, err := multi.Exec(func() error {
+		for _, v := range candles {
+			openTime := strconv.FormatInt(v.TimeStart, 10)
+			multi.ZRemRangeByScore(key, openTime, openTime)
+		}
+		multi.ZAdd(key, members...)
+		return nil
+	})
+	return err
+}
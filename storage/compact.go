@@ -0,0 +1,164 @@
+package storage
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"price-feed/models"
+)
+
+const (
+	defaultCompactionInterval = 5 * time.Minute
+	defaultCompactionSource   = "1m"
+)
+
+var defaultCompactionTargets = []string{"1h", "1d"}
+
+// CompactionConfig represents the compaction of the fine-grained candles into the longer intervals.
+type CompactionConfig struct {
+	// CheckInterval is the interval of the compactor runs, 5m by default.
+	CheckInterval string `json:"check_interval"`
+	// Source is the interval the candles are built from, 1m by default.
+	Source string `json:"source"`
+	// Targets are the intervals built from the source candles, 1h and 1d by default.
+	Targets []string `json:"targets"`
+	// Exchanges limits the compaction to the exchanges, all of them when empty.
+	Exchanges []string `json:"exchanges"`
+	// Cutoff deletes the source candles older than it once compacted, e.g. 7d; never when empty.
+	// It must be longer than the longest target interval.
+	Cutoff string `json:"cutoff"`
+}
+
+type compactionPolicy struct {
+	interval  time.Duration
+	source    string
+	targets   []string
+	exchanges map[string]bool
+	cutoff    time.Duration
+}
+
+func newCompactionPolicy(config *CompactionConfig) (*compactionPolicy, error) {
+	p := &compactionPolicy{
+		interval:  defaultCompactionInterval,
+		source:    defaultCompactionSource,
+		targets:   defaultCompactionTargets,
+		exchanges: make(map[string]bool, len(config.Exchanges)),
+	}
+
+	if config.CheckInterval != "" {
+		interval, err := time.ParseDuration(config.CheckInterval)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse compaction check interval: %v", err)
+		}
+		p.interval = interval
+	}
+
+	if config.Source != "" {
+		p.source = config.Source
+	}
+	if len(config.Targets) > 0 {
+		p.targets = config.Targets
+	}
+	for _, v := range config.Exchanges {
+		p.exchanges[v] = true
+	}
+
+	sourceDuration := models.IntervalDuration(p.source)
+	if sourceDuration == 0 {
+		return nil, fmt.Errorf("unknown compaction source interval %v", p.source)
+	}
+
+	var longest time.Duration
+	for _, v := range p.targets {
+		d := models.IntervalDuration(v)
+		if d <= sourceDuration {
+			return nil, fmt.Errorf("compaction target interval %v is not longer than %v", v, p.source)
+		}
+		if d > longest {
+			longest = d
+		}
+	}
+
+	cutoff, err := parseRetention(config.Cutoff)
+	if err != nil {
+		return nil, fmt.Errorf("compaction cutoff: %v", err)
+	}
+	if cutoff > 0 && cutoff <= longest+p.interval {
+		return nil, fmt.Errorf("compaction cutoff %v is shorter than the longest target interval", config.Cutoff)
+	}
+	p.cutoff = cutoff
+
+	return p, nil
+}
+
+// StartCompaction starts the compactor building the target candles from the source ones,
+// so the exchanges don't have to subscribe to every kline interval. It does nothing
+// unless the compaction is configured.
+func (c *Client) StartCompaction() error {
+	if c.config.Compaction == nil {
+		return nil
+	}
+
+	policy, err := newCompactionPolicy(c.config.Compaction)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for ; ; <-time.Tick(policy.interval) {
+			if err := c.compact(policy); err != nil {
+				c.log.Errorf("Could not compact candles: %v", err)
+			}
+		}
+	}()
+	return nil
+}
+
+// compact rebuilds the recent target candles of every source key, <exchange>:candlestick:<symbol>:<source>,
+// and deletes the source candles past the cutoff.
+func (c *Client) compact(policy *compactionPolicy) error {
+	keys, err := c.scanKeys("*:" + candlestickKind + ":*:" + policy.source)
+	if err != nil {
+		return fmt.Errorf("could not scan %v candle keys: %v", policy.source, err)
+	}
+
+	now := time.Now()
+	for _, key := range keys {
+		parts := strings.Split(key, ":")
+		if len(parts) != 4 || parts[3] != policy.source {
+			continue
+		}
+		exchange, symbol := parts[0], parts[2]
+		if len(policy.exchanges) > 0 && !policy.exchanges[exchange] {
+			continue
+		}
+
+		for _, target := range policy.targets {
+			if err = c.compactCandles(exchange, symbol, policy.source, target, now.Add(-policy.interval)); err != nil {
+				c.log.Errorf("Could not compact %v candles of %v %v into %v: %v", policy.source, exchange, symbol, target, err)
+			}
+		}
+
+		if policy.cutoff == 0 {
+			continue
+		}
+		if err = c.redis().ZRemRangeByScore(key, "-inf", strconv.FormatInt(now.Add(-policy.cutoff).Unix(), 10)).Err(); err != nil {
+			c.log.Errorf("Could not delete compacted candles of %v: %v", key, err)
+		}
+	}
+	return nil
+}
+
+// compactCandles resamples the source candles since the target candle containing since,
+// so the candles updated since the previous run are rebuilt in full.
+func (c *Client) compactCandles(exchange, symbol, source, target string, since time.Time) error {
+	start := models.IntervalStart(since.Unix(), target)
+	candles, err := c.loadCandlestickListByExchange(candlestickKind, exchange, symbol, source, start, time.Now().Unix())
+	if err != nil {
+		return err
+	}
+
+	return c.StoreCandlesticks(exchange, symbol, target, models.ResampleCandles(candles, target))
+}
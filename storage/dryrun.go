@@ -0,0 +1,17 @@
+package storage
+
+// WithDryRun returns a client sharing the connection pool which reads as usual but only
+// logs the writes, so a worker can process the production streams without storing anything.
+func (c *Client) WithDryRun() *Client {
+	return &Client{connection: c.connection, trace: c.trace, dryRun: true}
+}
+
+// skipWrite logs the write and reports whether it must be skipped in the dry run mode.
+func (c *Client) skipWrite(format string, args ...interface{}) bool {
+	if !c.dryRun {
+		return false
+	}
+
+	c.log.Infof("Dry run, not storing "+format, args...)
+	return true
+}
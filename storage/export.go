@@ -0,0 +1,184 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"gopkg.in/redis.v3"
+)
+
+const (
+	exportKindZSet   = "zset"
+	exportKindString = "string"
+	// exportPageSize is the number of sorted set members read or written per request.
+	exportPageSize = 1000
+	// exportProgressEvery is the number of keys between the progress logs.
+	exportProgressEvery = 1000
+)
+
+// exportPatterns match the candle and order book keys which are exported.
+var exportPatterns = []string{
+	"*:" + candlestickKind + ":*",
+	"*:" + rollupKind + ":*",
+	"*:currentCandlestick:*",
+	"orderBook:*",
+	"depth:*",
+}
+
+// ExportRecord represents a single key of a snapshot, one JSON object per line.
+type ExportRecord struct {
+	Key  string `json:"key"`
+	Kind string `json:"kind"`
+	// Members are set for the sorted sets, Value for the strings.
+	Members []ExportMember `json:"members,omitempty"`
+	Value   string         `json:"value,omitempty"`
+}
+
+// ExportMember represents a member of a sorted set.
+type ExportMember struct {
+	Score  float64 `json:"score"`
+	Member string  `json:"member"`
+}
+
+// Export writes the candle and order book keys to w as newline delimited JSON, independent
+// of the Redis version and RDB format, and returns the number of exported keys.
+func (c *Client) Export(w io.Writer) (int, error) {
+	encoder := json.NewEncoder(w)
+	exported := 0
+
+	for _, pattern := range exportPatterns {
+		keys, err := c.scanKeys(pattern)
+		if err != nil {
+			return exported, fmt.Errorf("could not scan keys %v: %v", pattern, err)
+		}
+
+		for _, key := range keys {
+			record, err := c.exportKey(key)
+			if err != nil {
+				return exported, fmt.Errorf("could not export key %v: %v", key, err)
+			}
+			if record == nil {
+				continue
+			}
+
+			if err = encoder.Encode(record); err != nil {
+				return exported, err
+			}
+
+			exported++
+			if exported%exportProgressEvery == 0 {
+				c.log.Infof("Export: %v keys written", exported)
+			}
+		}
+	}
+
+	return exported, nil
+}
+
+// exportKey returns the record of the key, nil if the key is gone or of another type.
+func (c *Client) exportKey(key string) (*ExportRecord, error) {
+	kind, err := c.redis().Type(key).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	switch kind {
+	case exportKindZSet:
+		record := &ExportRecord{Key: key, Kind: exportKindZSet}
+		for start := int64(0); ; start += exportPageSize {
+			page, err := c.redis().ZRangeWithScores(key, start, start+exportPageSize-1).Result()
+			if err != nil {
+				return nil, err
+			}
+
+			for _, v := range page {
+				member, ok := v.Member.(string)
+				if !ok {
+					return nil, fmt.Errorf("%v is not string, but %v", v.Member, v.Member)
+				}
+				record.Members = append(record.Members, ExportMember{Score: v.Score, Member: member})
+			}
+
+			if len(page) < exportPageSize {
+				return record, nil
+			}
+		}
+	case exportKindString:
+		value, err := c.redis().Get(key).Result()
+		if err == redis.Nil {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &ExportRecord{Key: key, Kind: exportKindString, Value: value}, nil
+	}
+
+	return nil, nil
+}
+
+// Import reads the records written by Export and stores them, merging the sorted set
+// members into the existing keys unless replace is set, and returns the number of
+// imported keys.
+func (c *Client) Import(r io.Reader, replace bool) (int, error) {
+	if c.skipWrite("import of a snapshot") {
+		return 0, nil
+	}
+
+	scanner := bufio.NewScanner(r)
+	// A key of candles may be much longer than the default line limit.
+	scanner.Buffer(make([]byte, 0, 1024*1024), 1024*1024*1024)
+
+	imported := 0
+	for scanner.Scan() {
+		var record ExportRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return imported, fmt.Errorf("could not unmarshal record %v: %v", imported+1, err)
+		}
+
+		if err := c.importRecord(record, replace); err != nil {
+			return imported, fmt.Errorf("could not import key %v: %v", record.Key, err)
+		}
+
+		imported++
+		if imported%exportProgressEvery == 0 {
+			c.log.Infof("Import: %v keys stored", imported)
+		}
+	}
+
+	return imported, scanner.Err()
+}
+
+func (c *Client) importRecord(record ExportRecord, replace bool) error {
+	switch record.Kind {
+	case exportKindString:
+		return c.redis().Set(record.Key, record.Value, 0).Err()
+	case exportKindZSet:
+	default:
+		return fmt.Errorf("unknown kind %v", record.Kind)
+	}
+
+	if replace {
+		if err := c.redis().Del(record.Key).Err(); err != nil {
+			return err
+		}
+	}
+
+	for start := 0; start < len(record.Members); start += exportPageSize {
+		end := start + exportPageSize
+		if end > len(record.Members) {
+			end = len(record.Members)
+		}
+
+		members := make([]redis.Z, 0, end-start)
+		for _, v := range record.Members[start:end] {
+			members = append(members, redis.Z{Score: v.Score, Member: v.Member})
+		}
+		if err := c.redis().ZAdd(record.Key, members...).Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
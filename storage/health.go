@@ -0,0 +1,127 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultHealthCheckInterval = 5 * time.Second
+	defaultReconnectAfter      = 3
+	defaultStartupTimeout      = 5 * time.Minute
+	startupMinBackoff          = time.Second
+	startupMaxBackoff          = 30 * time.Second
+)
+
+// Healthy reports whether the last health check of the connection succeeded.
+func (c *Client) Healthy() bool {
+	return atomic.LoadInt32(&c.healthy) == 1
+}
+
+// WatchHealth pings the database periodically. Once ReconnectAfter checks in a row fail,
+// the connection pool is replaced by a new one, so broken connections are not reused.
+func (c *Client) WatchHealth() {
+	interval := defaultHealthCheckInterval
+	if c.config.HealthCheckInterval != "" {
+		d, err := time.ParseDuration(c.config.HealthCheckInterval)
+		if err != nil {
+			c.log.Warnf("Could not parse database health check interval, using %v: %v", interval, err)
+		} else {
+			interval = d
+		}
+	}
+
+	reconnectAfter := defaultReconnectAfter
+	if c.config.ReconnectAfter > 0 {
+		reconnectAfter = c.config.ReconnectAfter
+	}
+
+	failures := 0
+	for range time.Tick(interval) {
+		_, err := c.Check()
+		if err == nil {
+			if failures > 0 {
+				c.log.Infof("Database connection restored")
+			}
+			failures = 0
+			atomic.StoreInt32(&c.healthy, 1)
+			continue
+		}
+
+		if failures == 0 {
+			c.log.Errorf("Database health check failed, serving from caches: %v", err)
+		}
+
+		failures++
+		atomic.StoreInt32(&c.healthy, 0)
+
+		if failures%reconnectAfter == 0 {
+			c.log.Warnf("Database is unavailable for %v checks, reconnecting", failures)
+			c.reconnect()
+		}
+	}
+}
+
+// reconnect replaces the connection pool with a new one.
+func (c *Client) reconnect() {
+	client := newRedisClient(c.config)
+
+	c.clientMu.Lock()
+	old := c.client
+	c.client = client
+	c.clientMu.Unlock()
+
+	if err := old.Close(); err != nil {
+		c.log.Warnf("Could not close database connection pool: %v", err)
+	}
+}
+
+// WaitHealthy retries the database check with an exponential backoff until it succeeds,
+// StartupTimeout passes or a quit signal is received, so a boot during a failover waits
+// for the database instead of crashing. It returns the reply of the successful check.
+func (c *Client) WaitHealthy(quit chan os.Signal) (string, error) {
+	timeout := defaultStartupTimeout
+	if c.config.StartupTimeout != "" {
+		d, err := time.ParseDuration(c.config.StartupTimeout)
+		if err != nil {
+			return "", fmt.Errorf("could not parse database startup timeout: %v", err)
+		}
+		timeout = d
+	}
+
+	reconnectAfter := defaultReconnectAfter
+	if c.config.ReconnectAfter > 0 {
+		reconnectAfter = c.config.ReconnectAfter
+	}
+
+	deadline := time.After(timeout)
+	backoff := startupMinBackoff
+	for failures := 1; ; failures++ {
+		pong, err := c.Check()
+		if err == nil {
+			atomic.StoreInt32(&c.healthy, 1)
+			return pong, nil
+		}
+
+		atomic.StoreInt32(&c.healthy, 0)
+		c.log.Warnf("Database is unavailable, retrying in %v: %v", backoff, err)
+
+		if failures%reconnectAfter == 0 {
+			c.reconnect()
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-deadline:
+			return "", fmt.Errorf("database is unavailable for %v: %v", timeout, err)
+		case sig := <-quit:
+			return "", fmt.Errorf("received %v while waiting for database", sig)
+		}
+
+		if backoff *= 2; backoff > startupMaxBackoff {
+			backoff = startupMaxBackoff
+		}
+	}
+}
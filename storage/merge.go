@@ -0,0 +1,107 @@
+package storage
+
+import (
+	"price-feed/models"
+)
+
+// mergeCandles merges the candles of the same period, ordered as candleExchanges, into one.
+// The candles flagged as anomalous are skipped unless includeAnomalous is set, the result
+// isn't ok if no candle is left. A single candle is returned as is.
+func mergeCandles(strategy string, includeAnomalous bool, candles []models.Candle) (models.Candle, bool) {
+	if !includeAnomalous {
+		candles = withoutAnomalous(candles)
+	}
+
+	switch {
+	case len(candles) == 0:
+		return models.Candle{}, false
+	case len(candles) == 1:
+		return candles[0], true
+	}
+
+	switch strategy {
+	case models.MergeVWAP:
+		return mergeVWAP(candles), true
+	case models.MergeMedian:
+		return mergeMedian(candles), true
+	case models.MergePrimary:
+		return candles[0], true
+	default:
+		return mergeAverage(candles), true
+	}
+}
+
+// withoutAnomalous returns the candles which aren't flagged as anomalous.
+func withoutAnomalous(candles []models.Candle) []models.Candle {
+	result := make([]models.Candle, 0, len(candles))
+	for _, v := range candles {
+		if !v.Anomalous {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+// mergeAverage takes the widest range, sums the volume and averages open and close.
+func mergeAverage(candles []models.Candle) models.Candle {
+	merged := candles[0]
+	for i, v := range candles[1:] {
+		if v.High > merged.High {
+			merged.High = v.High
+		}
+		if v.Low < merged.Low {
+			merged.Low = v.Low
+		}
+
+		n := float64(i + 2)
+		merged.Volume = toFixed(merged.Volume + v.Volume)
+		merged.Open = toFixed((merged.Open*(n-1) + v.Open) / n)
+		merged.Close = toFixed((merged.Close*(n-1) + v.Close) / n)
+	}
+	merged.Source = models.CandleSourceMerged
+	return merged
+}
+
+// mergeVWAP is mergeAverage with open and close weighted by volume. It falls back
+// to the plain average when none of the exchanges traded.
+func mergeVWAP(candles []models.Candle) models.Candle {
+	merged := mergeAverage(candles)
+	if merged.Volume == 0 {
+		return merged
+	}
+
+	var open, close float64
+	for _, v := range candles {
+		open += v.Open * v.Volume
+		close += v.Close * v.Volume
+	}
+	merged.Open = toFixed(open / merged.Volume)
+	merged.Close = toFixed(close / merged.Volume)
+	return merged
+}
+
+// mergeMedian takes the median of every price and sums the volume, so a single
+// exchange printing far off the market does not move the merged candle.
+func mergeMedian(candles []models.Candle) models.Candle {
+	merged := candles[0]
+	merged.Volume = 0
+	for _, v := range candles {
+		merged.Volume = toFixed(merged.Volume + v.Volume)
+	}
+
+	for _, field := range []func(c *models.Candle) *float64{
+		func(c *models.Candle) *float64 { return &c.Open },
+		func(c *models.Candle) *float64 { return &c.High },
+		func(c *models.Candle) *float64 { return &c.Low },
+		func(c *models.Candle) *float64 { return &c.Close },
+	} {
+		values := make([]float64, 0, len(candles))
+		for i := range candles {
+			values = append(values, *field(&candles[i]))
+		}
+		*field(&merged) = toFixed(models.Median(values))
+	}
+
+	merged.Source = models.CandleSourceMerged
+	return merged
+}
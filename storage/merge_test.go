@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+
+	"price-feed/models"
+)
+
+func TestMergeCandles(t *testing.T) {
+	binance := models.Candle{TimeStart: 60, Open: 100, Close: 102, High: 103, Low: 99, Volume: 1}
+	coinbase := models.Candle{TimeStart: 60, Open: 102, Close: 104, High: 105, Low: 101, Volume: 3}
+	spike := models.Candle{TimeStart: 60, Open: 101, Close: 300, High: 300, Low: 100, Volume: 2, Anomalous: true}
+
+	tests := []struct {
+		name             string
+		strategy         string
+		includeAnomalous bool
+		candles          []models.Candle
+		want             models.Candle
+		ok               bool
+	}{
+		{
+			name:     "average skips the anomalous candle",
+			strategy: models.MergeAverage,
+			candles:  []models.Candle{binance, spike, coinbase},
+			want: models.Candle{TimeStart: 60, Open: 101, Close: 103, High: 105, Low: 99, Volume: 4,
+				Source: models.CandleSourceMerged},
+			ok: true,
+		},
+		{
+			name:     "vwap skips the anomalous candle",
+			strategy: models.MergeVWAP,
+			candles:  []models.Candle{binance, spike, coinbase},
+			want: models.Candle{TimeStart: 60, Open: 101.5, Close: 103.5, High: 105, Low: 99, Volume: 4,
+				Source: models.CandleSourceMerged},
+			ok: true,
+		},
+		{
+			name:     "median skips the anomalous candle",
+			strategy: models.MergeMedian,
+			candles:  []models.Candle{binance, spike, coinbase},
+			want: models.Candle{TimeStart: 60, Open: 101, Close: 103, High: 104, Low: 100, Volume: 4,
+				Source: models.CandleSourceMerged},
+			ok: true,
+		},
+		{
+			name:     "primary falls back past the anomalous candle",
+			strategy: models.MergePrimary,
+			candles:  []models.Candle{spike, coinbase},
+			want:     coinbase,
+			ok:       true,
+		},
+		{
+			name:     "a period of anomalous candles is dropped",
+			strategy: models.MergeAverage,
+			candles:  []models.Candle{spike},
+		},
+		{
+			name:             "anomalous candles are included on request",
+			strategy:         models.MergePrimary,
+			includeAnomalous: true,
+			candles:          []models.Candle{spike, coinbase},
+			want:             spike,
+			ok:               true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := mergeCandles(tt.strategy, tt.includeAnomalous, tt.candles)
+			if ok != tt.ok {
+				t.Fatalf("ok = %v, want %v", ok, tt.ok)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("merged = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
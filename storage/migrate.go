@@ -0,0 +1,205 @@
+package storage
+
+import (
+	"fmt"
+	"strconv"
+
+	"gopkg.in/redis.v3"
+)
+
+const (
+	schemaVersionKey = "schema:version"
+	migratingSuffix  = ":migrating"
+	// migrationScanCount is the number of keys requested per scan.
+	migrationScanCount = 500
+	// migrationProgressEvery is the number of rewritten keys between progress logs.
+	migrationProgressEvery = 1000
+)
+
+// Migration converts the members of the sorted sets matching Pattern from the
+// format of the previous schema version to the one of Version.
+type Migration struct {
+	Version     int
+	Description string
+	// Pattern is a key glob, e.g. "*:candlestick:*".
+	Pattern string
+	Up      func(member string) (string, error)
+	// Down reverts Up. A migration without it can't be rolled back.
+	Down func(member string) (string, error)
+}
+
+// migrations are the schema changes in the order they are applied, their versions
+// counting from 1. The data stored before the versioning was introduced is version 0.
+var migrations = []Migration{}
+
+// LatestSchemaVersion returns the schema version the storage code reads and writes.
+func LatestSchemaVersion() int {
+	return len(migrations)
+}
+
+// SchemaVersion returns the schema version of the stored data.
+func (c *Client) SchemaVersion() (int, error) {
+	value, err := c.redis().Get(schemaVersionKey).Result()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.Atoi(value)
+}
+
+// Migrate upgrades or rolls back the stored data to the target schema version.
+// It must run before the workers start writing. A failed migration reverts the
+// keys it has already rewritten, so the data stays at the last complete version.
+func (c *Client) Migrate(target int) error {
+	if target < 0 || target > LatestSchemaVersion() {
+		return fmt.Errorf("unknown schema version %v", target)
+	}
+
+	current, err := c.SchemaVersion()
+	if err != nil {
+		return fmt.Errorf("could not load schema version: %v", err)
+	}
+	if current > LatestSchemaVersion() {
+		return fmt.Errorf("schema version %v is newer than the supported %v", current, LatestSchemaVersion())
+	}
+
+	for ; current < target; current++ {
+		m := migrations[current]
+		c.log.Infof("Migrating storage to schema version %v: %v", m.Version, m.Description)
+
+		if err = c.applyMigration(m, m.Up, m.Down); err != nil {
+			return fmt.Errorf("could not migrate to schema version %v: %v", m.Version, err)
+		}
+		if err = c.setSchemaVersion(m.Version); err != nil {
+			return err
+		}
+	}
+
+	for ; current > target; current-- {
+		m := migrations[current-1]
+		if m.Down == nil {
+			return fmt.Errorf("schema version %v can't be rolled back", m.Version)
+		}
+		c.log.Infof("Rolling storage back from schema version %v: %v", m.Version, m.Description)
+
+		if err = c.applyMigration(m, m.Down, m.Up); err != nil {
+			return fmt.Errorf("could not roll back schema version %v: %v", m.Version, err)
+		}
+		if err = c.setSchemaVersion(m.Version - 1); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *Client) setSchemaVersion(version int) error {
+	if err := c.redis().Set(schemaVersionKey, strconv.Itoa(version), 0).Err(); err != nil {
+		return fmt.Errorf("could not store schema version %v: %v", version, err)
+	}
+	return nil
+}
+
+// applyMigration rewrites every key matching the pattern of the migration with convert.
+// If a key fails, the keys rewritten so far are reverted with revert when it is set.
+func (c *Client) applyMigration(m Migration, convert, revert func(string) (string, error)) error {
+	keys, err := c.scanKeys(m.Pattern)
+	if err != nil {
+		return fmt.Errorf("could not scan keys %v: %v", m.Pattern, err)
+	}
+
+	for i, key := range keys {
+		if err = c.rewriteKey(key, convert); err != nil {
+			if revert != nil {
+				c.log.Warnf("Migration %v failed at key %v, reverting %v keys", m.Version, key, i)
+				for _, v := range keys[:i] {
+					if revertErr := c.rewriteKey(v, revert); revertErr != nil {
+						c.log.Errorf("Could not revert key %v: %v", v, revertErr)
+					}
+				}
+			}
+			return fmt.Errorf("could not rewrite key %v: %v", key, err)
+		}
+
+		if (i+1)%migrationProgressEvery == 0 {
+			c.log.Infof("Migration %v: %v of %v keys rewritten", m.Version, i+1, len(keys))
+		}
+	}
+
+	c.log.Infof("Migration %v: %v keys rewritten", m.Version, len(keys))
+	return nil
+}
+
+// scanKeys returns the keys matching the pattern. The scan may return a key more
+// than once, so they are deduplicated to convert every key exactly once.
+func (c *Client) scanKeys(pattern string) ([]string, error) {
+	seen := make(map[string]bool)
+	keys := make([]string, 0)
+
+	var cursor int64
+	for {
+		next, page, err := c.redis().Scan(cursor, pattern, migrationScanCount).Result()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, v := range page {
+			if seen[v] || v == schemaVersionKey {
+				continue
+			}
+			seen[v] = true
+			keys = append(keys, v)
+		}
+
+		if next == 0 {
+			return keys, nil
+		}
+		cursor = next
+	}
+}
+
+// rewriteKey converts every member of the sorted set, keeping the scores, and atomically
+// replaces the set with the converted one. Keys of other types are left as they are.
+func (c *Client) rewriteKey(key string, convert func(string) (string, error)) error {
+	keyType, err := c.redis().Type(key).Result()
+	if err != nil {
+		return err
+	}
+	if keyType != "zset" {
+		return nil
+	}
+
+	result, err := c.redis().ZRangeWithScores(key, 0, -1).Result()
+	if err != nil {
+		return err
+	}
+	if len(result) == 0 {
+		return nil
+	}
+
+	members := make([]redis.Z, 0, len(result))
+	for _, v := range result {
+		str, ok := v.Member.(string)
+		if !ok {
+			return fmt.Errorf("%v is not string, but %v", v.Member, v.Member)
+		}
+
+		converted, err := convert(str)
+		if err != nil {
+			return fmt.Errorf("could not convert %v: %v", str, err)
+		}
+		members = append(members, redis.Z{Score: v.Score, Member: converted})
+	}
+
+	tmp := key + migratingSuffix
+	if err = c.redis().Del(tmp).Err(); err != nil {
+		return err
+	}
+	if err = c.redis().ZAdd(tmp, members...).Err(); err != nil {
+		return err
+	}
+	return c.redis().Rename(tmp, key).Err()
+}
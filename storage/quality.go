@@ -0,0 +1,212 @@
+package storage
+
+import (
+	"sort"
+	"sync"
+
+	"price-feed/models"
+)
+
+const (
+	defaultAnomalyDeviation = 0.25
+	anomalyNeighbors        = 5
+)
+
+// candleExchanges are the exchanges whose candles are compared to each other and merged.
+// The synthetic pairs are computed from the merged candles, so they are neither merged
+// nor compared, see syntheticExchange.
+var candleExchanges = []string{"binance", "bittrex", "poloniex", "coinbase", "okx", "bitfinex", "kucoin", "bybit", "huobi"}
+
+// syntheticExchange is the exchange key of the synthetic pairs, served as the merged
+// candles of the pairs no exchange trades.
+const syntheticExchange = "synthetic"
+
+// recentCandles keeps the last candles stored of every exchange series in memory, so the
+// live candles are flagged without reading their neighbors back from Redis.
+type recentCandles struct {
+	mu     sync.Mutex
+	series map[string][]models.Candle
+}
+
+func newRecentCandles() *recentCandles {
+	return &recentCandles{series: make(map[string][]models.Candle)}
+}
+
+func recentKey(exchange, symbol, interval string) string {
+	return exchange + ":" + symbol + ":" + interval
+}
+
+// get returns the cached candles of the series, which aren't ok until the series is seeded.
+func (r *recentCandles) get(exchange, symbol, interval string) ([]models.Candle, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	series, ok := r.series[recentKey(exchange, symbol, interval)]
+	return append([]models.Candle(nil), series...), ok
+}
+
+// add caches the candles in their series, replacing the cached candles of the same periods.
+// Like the storage, the series skip the candles without trades.
+func (r *recentCandles) add(exchange, symbol, interval string, candles ...models.Candle) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := recentKey(exchange, symbol, interval)
+	series := r.series[key]
+	for _, candle := range candles {
+		if candle.Volume == 0 {
+			continue
+		}
+
+		i := sort.Search(len(series), func(i int) bool { return series[i].TimeStart >= candle.TimeStart })
+		if i < len(series) && series[i].TimeStart == candle.TimeStart {
+			series[i] = candle
+			continue
+		}
+		series = append(series, models.Candle{})
+		copy(series[i+1:], series[i:])
+		series[i] = candle
+	}
+
+	// The neighbors of the next candle and the candle being built are kept.
+	if len(series) > anomalyNeighbors+1 {
+		series = append([]models.Candle(nil), series[len(series)-anomalyNeighbors-1:]...)
+	}
+	if series == nil {
+		series = []models.Candle{}
+	}
+	r.series[key] = series
+}
+
+// peers returns the cached candles of the other exchanges starting at the time.
+func (r *recentCandles) peers(exchange, symbol, interval string, timeStart int64) []models.Candle {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	peers := make([]models.Candle, 0, len(candleExchanges)-1)
+	for _, v := range candleExchanges {
+		if v == exchange {
+			continue
+		}
+
+		for _, candle := range r.series[recentKey(v, symbol, interval)] {
+			if candle.TimeStart == timeStart {
+				peers = append(peers, candle)
+			}
+		}
+	}
+	return peers
+}
+
+// neighborsOf returns the candles of the sorted series within anomalyNeighbors periods before the time.
+func neighborsOf(series []models.Candle, timeStart, length int64) []models.Candle {
+	neighbors := make([]models.Candle, 0, anomalyNeighbors)
+	for _, v := range series {
+		if v.TimeStart >= timeStart-anomalyNeighbors*length && v.TimeStart < timeStart {
+			neighbors = append(neighbors, v)
+		}
+	}
+	return neighbors
+}
+
+// flagAnomaly marks the candle as anomalous when its high or low deviates implausibly
+// from the preceding candles of the exchange and from the candles of the other exchanges
+// too, so market-wide moves are not flagged. A candle without peers isn't flagged, as a
+// breakout of a single venue can't be told from a bad print. The candles are compared
+// to the recent candles kept in memory, so only the neighbors of a series which isn't
+// cached yet are loaded from Redis.
+func (c *Client) flagAnomaly(exchange, symbol, interval string, candle *models.Candle) {
+	if candle == nil {
+		return
+	}
+
+	length := int64(models.IntervalDuration(interval).Seconds())
+	if length == 0 {
+		return
+	}
+
+	series, ok := c.recent.get(exchange, symbol, interval)
+	if !ok {
+		stored, err := c.LoadCandlestickListByExchange(exchange, symbol, interval,
+			candle.TimeStart-anomalyNeighbors*length, candle.TimeStart-1)
+		if err != nil {
+			c.log.Warnf("Could not load neighbors of %v %v %v candle: %v", exchange, symbol, interval, err)
+			return
+		}
+		c.recent.add(exchange, symbol, interval, stored...)
+		series = stored
+	}
+
+	c.checkAnomaly(exchange, symbol, interval, candle, neighborsOf(series, candle.TimeStart, length),
+		func() []models.Candle { return c.recent.peers(exchange, symbol, interval, candle.TimeStart) })
+	c.recent.add(exchange, symbol, interval, *candle)
+}
+
+// flagAnomalies flags the sorted candles like flagAnomaly, but loads the stored neighbors
+// of the whole batch at once and takes the rest of the neighbors from the batch itself.
+// The candles of a backfill are mostly older than the cached ones, so their peers are
+// loaded from Redis.
+func (c *Client) flagAnomalies(exchange, symbol, interval string, candles []models.Candle) {
+	length := int64(models.IntervalDuration(interval).Seconds())
+	if length == 0 {
+		return
+	}
+
+	series, err := c.LoadCandlestickListByExchange(exchange, symbol, interval,
+		candles[0].TimeStart-anomalyNeighbors*length, candles[0].TimeStart-1)
+	if err != nil {
+		c.log.Warnf("Could not load neighbors of %v %v %v candles: %v", exchange, symbol, interval, err)
+		return
+	}
+
+	for i := range candles {
+		from := len(series) - anomalyNeighbors
+		if from < 0 {
+			from = 0
+		}
+
+		candle := &candles[i]
+		c.checkAnomaly(exchange, symbol, interval, candle, neighborsOf(series[from:], candle.TimeStart, length),
+			func() []models.Candle { return c.loadPeers(exchange, symbol, interval, candle.TimeStart) })
+
+		// Like the storage, the neighbors skip the candles without trades.
+		if candle.Volume != 0 {
+			series = append(series, *candle)
+		}
+	}
+
+	c.recent.add(exchange, symbol, interval, candles...)
+}
+
+// loadPeers loads the candles of the other exchanges starting at the time.
+func (c *Client) loadPeers(exchange, symbol, interval string, timeStart int64) []models.Candle {
+	peers := make([]models.Candle, 0, len(candleExchanges)-1)
+	for _, v := range candleExchanges {
+		if v == exchange {
+			continue
+		}
+
+		candles, err := c.LoadCandlestickListByExchange(v, symbol, interval, timeStart, timeStart)
+		if err != nil {
+			c.log.Warnf("Could not load %v %v %v candle: %v", v, symbol, interval, err)
+			continue
+		}
+		peers = append(peers, candles...)
+	}
+	return peers
+}
+
+// checkAnomaly sets the anomalous flag of the candle given its preceding candles, the peers
+// are only looked up if the candle deviates from them. Without peers the flag is cleared.
+func (c *Client) checkAnomaly(exchange, symbol, interval string, candle *models.Candle, neighbors []models.Candle,
+	peers func() []models.Candle) {
+	if !models.DeviatesFrom(*candle, neighbors, c.anomalyDeviation) {
+		candle.Anomalous = false
+		return
+	}
+
+	candle.Anomalous = models.DeviatesFrom(*candle, peers(), c.anomalyDeviation)
+	if candle.Anomalous {
+		c.log.Warnf("Flagged anomalous %v %v %v candle at %v: %+v", exchange, symbol, interval, candle.TimeStart, *candle)
+	}
+}
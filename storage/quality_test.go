@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"testing"
+
+	"price-feed/logger"
+	"price-feed/models"
+)
+
+func TestFlagAnomaly(t *testing.T) {
+	const interval = "1m"
+	neighbors := []models.Candle{
+		{TimeStart: 0, Open: 100, Close: 101, High: 102, Low: 99, Volume: 1},
+		{TimeStart: 60, Open: 101, Close: 100, High: 102, Low: 99, Volume: 1},
+		{TimeStart: 120, Open: 100, Close: 100, High: 101, Low: 99, Volume: 1},
+	}
+	breakout := models.Candle{TimeStart: 180, Open: 118, Close: 138, High: 140, Low: 118, Volume: 5}
+
+	tests := []struct {
+		name   string
+		candle models.Candle
+		peers  map[string]models.Candle
+		want   bool
+	}{
+		{
+			name:   "within the deviation",
+			candle: models.Candle{TimeStart: 180, Open: 100, Close: 110, High: 112, Low: 99, Volume: 1},
+			peers:  map[string]models.Candle{"coinbase": {TimeStart: 180, Close: 100, High: 101, Low: 99, Volume: 1}},
+		},
+		{
+			name:   "breakout of a single venue",
+			candle: breakout,
+		},
+		{
+			name:   "breakout of the market",
+			candle: breakout,
+			peers:  map[string]models.Candle{"coinbase": {TimeStart: 180, Close: 137, High: 139, Low: 117, Volume: 3}},
+		},
+		{
+			name:   "bad print",
+			candle: breakout,
+			peers: map[string]models.Candle{
+				"coinbase": {TimeStart: 180, Close: 100, High: 101, Low: 99, Volume: 3},
+				"okx":      {TimeStart: 180, Close: 101, High: 101, Low: 100, Volume: 2},
+			},
+			want: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := New(&Config{}, logger.New(&logger.Config{Level: "error"}))
+			// The series are cached, so the neighbors aren't loaded from Redis.
+			c.recent.add("binance", "BTCUSDT", interval, neighbors...)
+			for exchange, v := range tt.peers {
+				c.recent.add(exchange, "BTCUSDT", interval, v)
+			}
+
+			candle := tt.candle
+			c.flagAnomaly("binance", "BTCUSDT", interval, &candle)
+			if candle.Anomalous != tt.want {
+				t.Errorf("anomalous = %v, want %v", candle.Anomalous, tt.want)
+			}
+		})
+	}
+}
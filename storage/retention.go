@@ -0,0 +1,164 @@
+package storage
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultRetentionInterval = time.Hour
+	defaultCandleRetention   = 5 * 12 * 30 * day
+	retentionForever         = "forever"
+)
+
+// RetentionConfig represents the retention policy of the stored candles.
+type RetentionConfig struct {
+	// CheckInterval is the interval of the janitor runs, 1h by default.
+	CheckInterval string `json:"check_interval"`
+	// Default is the retention of the candles not matched by any rule, 1800d by default.
+	Default string `json:"default"`
+	// Rules override the default per exchange and interval, the most specific rule applies.
+	Rules []RetentionRule `json:"rules"`
+}
+
+// RetentionRule represents the retention of the candles of an exchange and an interval,
+// e.g. keep the 1m candles 30d and the 1d candles forever.
+type RetentionRule struct {
+	// Exchange and Interval match every exchange or interval when empty.
+	Exchange string `json:"exchange"`
+	Interval string `json:"interval"`
+	// Keep is a duration with an optional d suffix for days, e.g. 30d or 72h, or forever.
+	Keep string `json:"keep"`
+}
+
+// retentionPolicy is the parsed retention config, a zero duration keeping the candles forever.
+type retentionPolicy struct {
+	interval time.Duration
+	fallback time.Duration
+	rules    map[string]time.Duration
+}
+
+// parseRetention parses a duration with an optional d suffix, forever or empty is zero.
+func parseRetention(s string) (time.Duration, error) {
+	switch {
+	case s == "" || s == retentionForever:
+		return 0, nil
+	case strings.HasSuffix(s, "d"):
+		days, err := strconv.ParseFloat(strings.TrimSuffix(s, "d"), 64)
+		if err != nil || days < 0 {
+			return 0, fmt.Errorf("invalid retention %v", s)
+		}
+		return time.Duration(days * float64(day)), nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid retention %v", s)
+	}
+	return d, nil
+}
+
+func newRetentionPolicy(config *RetentionConfig) (*retentionPolicy, error) {
+	p := &retentionPolicy{
+		interval: defaultRetentionInterval,
+		fallback: defaultCandleRetention,
+		rules:    make(map[string]time.Duration, len(config.Rules)),
+	}
+
+	if config.CheckInterval != "" {
+		interval, err := time.ParseDuration(config.CheckInterval)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse retention check interval: %v", err)
+		}
+		p.interval = interval
+	}
+
+	if config.Default != "" {
+		fallback, err := parseRetention(config.Default)
+		if err != nil {
+			return nil, err
+		}
+		p.fallback = fallback
+	}
+
+	for _, v := range config.Rules {
+		keep, err := parseRetention(v.Keep)
+		if err != nil {
+			return nil, fmt.Errorf("rule of %v %v: %v", v.Exchange, v.Interval, err)
+		}
+		p.rules[v.Exchange+":"+v.Interval] = keep
+	}
+
+	return p, nil
+}
+
+// retention returns the retention of the candles of the exchange and the interval.
+func (p *retentionPolicy) retention(exchange, interval string) time.Duration {
+	for _, key := range []string{exchange + ":" + interval, exchange + ":", ":" + interval} {
+		if keep, ok := p.rules[key]; ok {
+			return keep
+		}
+	}
+	return p.fallback
+}
+
+// StartRetention starts the janitor trimming the candles past their retention.
+// It does nothing unless the retention is configured.
+func (c *Client) StartRetention() error {
+	if c.config.Retention == nil {
+		return nil
+	}
+
+	policy, err := newRetentionPolicy(c.config.Retention)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for ; ; <-time.Tick(policy.interval) {
+			if err := c.applyRetention(policy); err != nil {
+				c.log.Errorf("Could not apply candle retention: %v", err)
+			}
+		}
+	}()
+	return nil
+}
+
+// applyRetention trims the candlestick and rollup sets, keys <exchange>:<kind>:<symbol>:<interval>.
+func (c *Client) applyRetention(policy *retentionPolicy) error {
+	now := time.Now()
+	var trimmed int64
+
+	for _, kind := range []string{candlestickKind, rollupKind} {
+		keys, err := c.scanKeys("*:" + kind + ":*")
+		if err != nil {
+			return fmt.Errorf("could not scan %v keys: %v", kind, err)
+		}
+
+		for _, key := range keys {
+			parts := strings.Split(key, ":")
+			if len(parts) != 4 {
+				continue
+			}
+
+			keep := policy.retention(parts[0], parts[3])
+			if keep == 0 {
+				continue
+			}
+
+			n, err := c.redis().ZRemRangeByScore(key, "-inf", strconv.FormatInt(now.Add(-keep).Unix(), 10)).Result()
+			if err != nil {
+				c.log.Errorf("Could not trim %v: %v", key, err)
+				continue
+			}
+			trimmed += n
+		}
+	}
+
+	if trimmed > 0 {
+		c.log.Infof("Retention trimmed %v candles", trimmed)
+	}
+	return nil
+}
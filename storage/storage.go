@@ -1,20 +1,17 @@
 package storage
 
 import (
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"math"
 	"math/big"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/jyap808/go-poloniex"
-
-	"github.com/toorop/go-bittrex"
-
-	"github.com/adshao/go-binance"
-
 	"price-feed/logger"
 	"price-feed/models"
 
@@ -22,14 +19,19 @@ import (
 )
 
 const (
-	roundTime             = 10 * time.Millisecond
-	orderBookExpiration   = 1 * time.Minute
-	candlestickExpiration = 5 * 12 * 30 * 24 * time.Hour
-	day                   = 24 * time.Hour
-	threeDays             = 3 * day
-	week                  = 7 * day
-	millisecond           = 1 * time.Millisecond
-	precision             = 8
+	roundTime           = 10 * time.Millisecond
+	orderBookExpiration = 1 * time.Minute
+	day                 = 24 * time.Hour
+	threeDays           = 3 * day
+	week                = 7 * day
+	millisecond         = 1 * time.Millisecond
+	precision           = 8
+	candlestickKind     = "candlestick"
+	rollupKind          = "rollup"
+	deadLetterLimit     = 1000
+	tradeRetention      = day
+	defaultTradeLimit   = 10000
+	midPriceRetention   = day
 )
 
 // Config represents a database configuration.
@@ -38,41 +40,118 @@ type Config struct {
 	Password string `json:"password"`
 	Database int64  `json:"database"`
 	PoolSize int    `json:"poolSize"`
+	Persist  bool   `json:"persist"`
+	// AnomalyDeviation is the relative deviation of a high or low above which
+	// a candle is flagged as anomalous, 0.25 by default.
+	AnomalyDeviation float64 `json:"anomaly_deviation"`
+	// IncludeAnomalous keeps the candles flagged as anomalous prints in the merged candles.
+	IncludeAnomalous bool `json:"include_anomalous"`
+	// HealthCheckInterval is the interval of the connection health checks, 5s by default.
+	HealthCheckInterval string `json:"health_check_interval"`
+	// ReconnectAfter is the number of failed health checks after which
+	// the connection pool is recreated, 3 by default.
+	ReconnectAfter int `json:"reconnect_after"`
+	// StartupTimeout limits how long the connection is retried at boot, 5m by default.
+	StartupTimeout string `json:"startup_timeout"`
+	// TradeLimit caps the trades kept per exchange symbol, 10000 by default.
+	TradeLimit int64 `json:"trade_limit"`
+	// Retention trims the candles past their retention when present.
+	Retention *RetentionConfig `json:"retention"`
+	// Compaction builds the longer candles from the fine-grained ones when present.
+	Compaction *CompactionConfig `json:"compaction"`
+	// WriteBatch batches the candle and order book writes in pipelines when present.
+	WriteBatch *WriteBatchConfig `json:"write_batch"`
+	// Timescale enables the TimescaleDB history of the candles and the trades when present.
+	Timescale *TimescaleConfig `json:"timescale"`
 }
 
 // Client represents a database client instance.
 type Client struct {
-	client *redis.Client
-	log    *logger.Logger
+	*connection
+	// trace records the queries when set, see WithTrace.
+	trace *Trace
+	// dryRun skips the writes, see WithDryRun.
+	dryRun bool
+}
+
+// connection is the state shared by a client and its traced views.
+type connection struct {
+	config           *Config
+	clientMu         sync.RWMutex
+	client           *redis.Client
+	log              *logger.Logger
+	anomalyDeviation float64
+	healthy          int32
+	// history is nil unless the TimescaleDB history is initialized, see InitHistory.
+	history *sql.DB
+	// historyWrites queues the history statements, see runHistoryWriter.
+	historyWrites chan historyWrite
+	// historyDropped counts the history rows which were never written.
+	historyDropped int64
+	// writer is nil unless the writes are batched, see writeBatcher.
+	writer *writeBatcher
+	// recent caches the neighbors of the candles flagged as anomalous, see flagAnomaly.
+	recent *recentCandles
 }
 
 // New returns a new database client instance.
 func New(cfg *Config, log *logger.Logger) *Client {
-	client := redis.NewClient(&redis.Options{
+	anomalyDeviation := defaultAnomalyDeviation
+	if cfg.AnomalyDeviation > 0 {
+		anomalyDeviation = cfg.AnomalyDeviation
+	}
+
+	c := &Client{connection: &connection{
+		config:           cfg,
+		client:           newRedisClient(cfg),
+		log:              log,
+		anomalyDeviation: anomalyDeviation,
+		healthy:          1,
+		recent:           newRecentCandles(),
+	}}
+
+	if cfg.WriteBatch != nil {
+		writer, err := newWriteBatcher(cfg.WriteBatch)
+		if err != nil {
+			log.Errorf("Could not parse storage write batch config, writing unbatched: %v", err)
+			return c
+		}
+		c.writer = writer
+		go c.runWriteBatcher()
+	}
+
+	return c
+}
+
+func newRedisClient(cfg *Config) *redis.Client {
+	return redis.NewClient(&redis.Options{
 		Addr:     cfg.Endpoint,
 		Password: cfg.Password,
 		DB:       cfg.Database,
 		PoolSize: cfg.PoolSize,
 	})
+}
 
-	return &Client{
-		client: client,
-		log:    log,
-	}
+// redis returns the current connection pool, which is replaced when it stays unhealthy.
+func (c *Client) redis() *redis.Client {
+	c.clientMu.RLock()
+	defer c.clientMu.RUnlock()
+
+	return c.client
 }
 
 // Check sends a ping to the database.
 func (c *Client) Check() (string, error) {
-	return c.client.Ping().Result()
+	return c.redis().Ping().Result()
 }
 
 func (c *Client) Flush() error {
-	_, err := c.client.FlushDb().Result()
+	_, err := c.redis().FlushDb().Result()
 	return err
 }
 
 func (c *Client) LoadOrderBook(pair string) (models.OrderBookAPI, error) {
-	result, err := c.client.ZRangeWithScores(c.formatKey("depth", pair), -2, -1).Result()
+	result, err := c.redis().ZRangeWithScores(c.formatKey("depth", pair), -2, -1).Result()
 	if err != nil {
 		return models.OrderBookAPI{}, err
 	}
@@ -95,42 +174,118 @@ func (c *Client) LoadOrderBook(pair string) (models.OrderBookAPI, error) {
 }
 
 func (c *Client) StoreOrderBook(pair string, depth *models.OrderBookAPI) error {
+	if c.skipWrite("order book of %v with %v bids and %v asks", pair, len(depth.Bids), len(depth.Asks)) {
+		return nil
+	}
+
 	data, err := json.Marshal(depth)
 	if err != nil {
 		c.log.Errorf("Could not marshal depth: %v", err)
 		return err
 	}
 
-	return c.store(c.formatKey("depth", pair), float64(time.Now().Unix()), string(data))
+	key := c.formatKey("depth", pair)
+	if c.writer != nil {
+		c.writer.add(zAdd(key, float64(time.Now().Unix()), string(data)))
+		return nil
+	}
+
+	return c.store(key, float64(time.Now().Unix()), string(data))
 }
 
 func (c *Client) LoadOrderBookInternal(symbol string, depth int) (models.OrderBookAPI, error) {
-	result, err := c.client.ZRangeWithScores(c.formatKey("orderBook", symbol), -1, -1).Result()
+	ob, ok, err := c.LoadOrderBookSnapshot(symbol)
 	if err != nil {
 		return models.OrderBookAPI{}, err
 	}
 
+	if !ok {
+		return models.EmptyOrderBook, nil
+	}
+
+	orderBook := ob.Format(depth, 0)
+
+	c.log.Debugf("LoadOrderBookInternal result: %+v", orderBook)
+	return orderBook, nil
+}
+
+// LoadOrderBookSnapshot returns the most recent stored order book for the symbol.
+// The second return value is false if nothing is stored yet.
+func (c *Client) LoadOrderBookSnapshot(symbol string) (models.OrderBookInternal, bool, error) {
+	result, err := c.redis().ZRangeWithScores(c.formatKey("orderBook", symbol), -1, -1).Result()
+	if err != nil {
+		return models.OrderBookInternal{}, false, err
+	}
+
 	if len(result) == 0 {
-		return models.EmptyOrderBook, err
+		return models.OrderBookInternal{}, false, nil
 	}
 
 	str, ok := result[0].Member.(string)
 	if !ok {
-		return models.OrderBookAPI{}, fmt.Errorf("%v is not string, but %v", result[0].Member, result[0].Member)
+		return models.OrderBookInternal{}, false, fmt.Errorf("%v is not string, but %v", result[0].Member, result[0].Member)
 	}
 
 	var ob models.OrderBookInternal
 	if err = json.Unmarshal([]byte(str), &ob); err != nil {
-		return models.OrderBookAPI{}, fmt.Errorf("could not unmarshal %v: %v", str, err)
+		return models.OrderBookInternal{}, false, fmt.Errorf("could not unmarshal %v: %v", str, err)
 	}
 
-	orderBook := ob.Format(depth)
+	if ob.Asks == nil {
+		ob.Asks = make(map[string]string)
+	}
 
-	c.log.Debugf("LoadOrderBookInternal result: %+v", orderBook)
-	return orderBook, nil
+	if ob.Bids == nil {
+		ob.Bids = make(map[string]string)
+	}
+
+	return ob, true, nil
+}
+
+// LoadLastCandlestick returns the most recent stored candle of the exchange for the symbol and interval.
+// The second return value is false if nothing is stored yet.
+func (c *Client) LoadLastCandlestick(exchange, symbol, interval string) (models.Candle, bool, error) {
+	result, err := c.redis().ZRangeWithScores(c.formatKey(exchange, "candlestick", symbol, interval), -1, -1).Result()
+	if err != nil {
+		return models.Candle{}, false, err
+	}
+
+	result, err = c.appendCurrentCandlestick(result, exchange, symbol, interval, 0, math.MaxInt64)
+	if err != nil {
+		return models.Candle{}, false, err
+	}
+
+	if len(result) == 0 {
+		return models.Candle{}, false, nil
+	}
+
+	last := result[len(result)-1]
+	str, ok := last.Member.(string)
+	if !ok {
+		return models.Candle{}, false, fmt.Errorf("%v is not string, but %v", last.Member, last.Member)
+	}
+
+	var candle models.Candle
+	if err = json.Unmarshal([]byte(str), &candle); err != nil {
+		return models.Candle{}, false, fmt.Errorf("could not unmarshal %v: %v", str, err)
+	}
+
+	return candle, true, nil
 }
 
 func (c *Client) LoadCandlestickListByExchange(exchange, symbol, interval string, timeStart, timeEnd int64) ([]models.Candle, error) {
+	if c.history != nil && c.config.Timescale.ReadCandles {
+		return c.loadHistoryCandles(exchange, symbol, interval, timeStart, timeEnd)
+	}
+	return c.loadCandlestickListByExchange(candlestickKind, exchange, symbol, interval, timeStart, timeEnd)
+}
+
+// LoadRollupListByExchange returns the daily or weekly rollups of the exchange.
+func (c *Client) LoadRollupListByExchange(exchange, symbol, interval string, timeStart, timeEnd int64) ([]models.Candle, error) {
+	return c.loadCandlestickListByExchange(rollupKind, exchange, symbol, interval, timeStart, timeEnd)
+}
+
+func (c *Client) loadCandlestickListByExchange(kind, exchange, symbol, interval string, timeStart, timeEnd int64) ([]models.Candle, error) {
 	var timeStartRounded, timeEndRounded time.Time
 	switch interval {
 	case "1d":
@@ -154,7 +309,9 @@ func (c *Client) LoadCandlestickListByExchange(exchange, symbol, interval string
 
 	timeEndRounded = time.Unix(timeEnd, 0)
 
-	result, err := c.client.ZRangeByScoreWithScores(c.formatKey(exchange, "candlestick", symbol, interval),
+	key := c.formatKey(exchange, kind, symbol, interval)
+	start := time.Now()
+	result, err := c.redis().ZRangeByScoreWithScores(key,
 		redis.ZRangeByScore{
 			Min: strconv.FormatInt(timeStartRounded.Unix(), 10),
 			Max: strconv.FormatInt(timeEndRounded.Unix(), 10),
@@ -162,6 +319,12 @@ func (c *Client) LoadCandlestickListByExchange(exchange, symbol, interval string
 	if err != nil {
 		return nil, err
 	}
+	c.trace.Record("zrangebyscore", key, len(result), start)
+
+	result, err = c.appendCurrentCandlestick(result, exchange, symbol, interval, timeStartRounded.Unix(), timeEndRounded.Unix())
+	if err != nil {
+		return nil, err
+	}
 
 	candleList := make([]models.Candle, 0, len(result))
 
@@ -185,7 +348,17 @@ func (c *Client) LoadCandlestickListByExchange(exchange, symbol, interval string
 	return candleList, nil
 }
 
-func (c *Client) LoadCandlestickListAll(symbol, interval string, timeStart, timeEnd int64) ([]models.Candle, error) {
+// LoadCandlestickListAll returns the candles merged across the exchanges with the merge strategy.
+func (c *Client) LoadCandlestickListAll(symbol, interval, merge string, timeStart, timeEnd int64) ([]models.Candle, error) {
+	return c.loadCandlestickListAll(candlestickKind, symbol, interval, merge, timeStart, timeEnd)
+}
+
+// LoadRollupListAll returns the daily or weekly rollups merged across the exchanges.
+func (c *Client) LoadRollupListAll(symbol, interval, merge string, timeStart, timeEnd int64) ([]models.Candle, error) {
+	return c.loadCandlestickListAll(rollupKind, symbol, interval, merge, timeStart, timeEnd)
+}
+
+func (c *Client) loadCandlestickListAll(kind, symbol, interval, merge string, timeStart, timeEnd int64) ([]models.Candle, error) {
 	var timeStartRounded, timeEndRounded time.Time
 	switch interval {
 	case "1d":
@@ -209,146 +382,223 @@ func (c *Client) LoadCandlestickListAll(symbol, interval string, timeStart, time
 
 	timeEndRounded = time.Unix(timeEnd, 0)
 
-	resultBinance, err := c.client.ZRangeByScoreWithScores(c.formatKey("binance", "candlestick", symbol, interval),
-		redis.ZRangeByScore{
-			Min: strconv.FormatInt(timeStartRounded.Unix(), 10),
-			Max: strconv.FormatInt(timeEndRounded.Unix(), 10),
-		}).Result()
+	periods, err := c.loadPeriods(kind, candleExchanges, symbol, interval, timeStartRounded.Unix(), timeEndRounded.Unix())
 	if err != nil {
 		return nil, err
 	}
+	// A pair no exchange has candles of may be a synthetic one.
+	if len(periods) == 0 {
+		periods, err = c.loadPeriods(kind, []string{syntheticExchange}, symbol, interval, timeStartRounded.Unix(), timeEndRounded.Unix())
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	resultBittrex, err := c.client.ZRangeByScoreWithScores(c.formatKey("bittrex", "candlestick", symbol, interval),
-		redis.ZRangeByScore{
-			Min: strconv.FormatInt(timeStartRounded.Unix(), 10),
-			Max: strconv.FormatInt(timeEndRounded.Unix(), 10),
-		}).Result()
-	if err != nil {
-		return nil, err
+	start := time.Now()
+	candleList := make([]models.Candle, 0, len(periods))
+	for _, candles := range periods {
+		if candle, ok := mergeCandles(merge, c.config.IncludeAnomalous, candles); ok {
+			candleList = append(candleList, candle)
+		}
 	}
+	c.trace.Record("merge "+merge, "", len(periods), start)
 
-	resultPoloniex, err := c.client.ZRangeByScoreWithScores(c.formatKey("poloniex", "candlestick", symbol, interval),
-		redis.ZRangeByScore{
-			Min: strconv.FormatInt(timeStartRounded.Unix(), 10),
-			Max: strconv.FormatInt(timeEndRounded.Unix(), 10),
-		}).Result()
+	sort.Slice(candleList, func(i, j int) bool {
+		return candleList[i].TimeStart < candleList[j].TimeStart
+	})
+
+	c.log.Debugf("LoadCandlestickList result: %+v", candleList)
+	return candleList, nil
+}
+
+// loadPeriods returns the candles of the exchanges within [timeStart; timeEnd] by their open time,
+// the candles of every period in the order of the exchanges, which is the priority order
+// of the primary-with-fallback strategy. The series are read in one round trip.
+func (c *Client) loadPeriods(kind string, exchanges []string, symbol, interval string, timeStart, timeEnd int64) (map[int64][]models.Candle, error) {
+	if kind == candlestickKind && c.history != nil && c.config.Timescale.ReadCandles {
+		return c.loadHistoryPeriods(exchanges, symbol, interval, timeStart, timeEnd)
+	}
+
+	keys := make([]string, 0, len(exchanges))
+	cmds := make([]*redis.ZSliceCmd, 0, len(exchanges))
+	start := time.Now()
+	_, err := c.redis().Pipelined(func(pipe *redis.Pipeline) error {
+		for _, exchange := range exchanges {
+			key := c.formatKey(exchange, kind, symbol, interval)
+			keys = append(keys, key)
+			cmds = append(cmds, pipe.ZRangeByScoreWithScores(key, redis.ZRangeByScore{
+				Min: strconv.FormatInt(timeStart, 10),
+				Max: strconv.FormatInt(timeEnd, 10),
+			}))
+		}
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	candleList := make([]models.Candle, 0)
-	counts := make(map[int64]int)
-	indexes := make(map[int64]int)
+	periods := make(map[int64][]models.Candle)
+	for i, exchange := range exchanges {
+		result := cmds[i].Val()
+		c.trace.Record("zrangebyscore", keys[i], len(result), start)
 
-	for _, v := range resultBinance {
-		str, ok := v.Member.(string)
-		if !ok {
-			return nil, fmt.Errorf("%v is not string, but %v", v.Member, v.Member)
+		// The exchanges storing closed candles only keep the in-progress one aside.
+		result, err = c.appendCurrentCandlestick(result, exchange, symbol, interval, timeStart, timeEnd)
+		if err != nil {
+			return nil, err
 		}
 
-		var ob models.Candle
-		if err = json.Unmarshal([]byte(str), &ob); err != nil {
-			return nil, fmt.Errorf("could not unmarshal %v: %v", str, err)
+		if err = addPeriods(periods, result); err != nil {
+			return nil, err
 		}
-
-		counts[ob.TimeStart]++
-		indexes[ob.TimeStart] = len(candleList)
-		candleList = append(candleList, ob)
 	}
+	return periods, nil
+}
 
-	for _, v := range resultBittrex {
+// addPeriods adds the candles of the result to their periods.
+func addPeriods(periods map[int64][]models.Candle, result []redis.Z) error {
+	for _, v := range result {
 		str, ok := v.Member.(string)
 		if !ok {
-			return nil, fmt.Errorf("%v is not string, but %v", v.Member, v.Member)
+			return fmt.Errorf("%v is not string, but %v", v.Member, v.Member)
 		}
 
 		var ob models.Candle
-		if err = json.Unmarshal([]byte(str), &ob); err != nil {
-			return nil, fmt.Errorf("could not unmarshal %v: %v", str, err)
+		if err := json.Unmarshal([]byte(str), &ob); err != nil {
+			return fmt.Errorf("could not unmarshal %v: %v", str, err)
 		}
 
-		counts[ob.TimeStart]++
+		periods[ob.TimeStart] = append(periods[ob.TimeStart], ob)
+	}
+	return nil
+}
 
-		r, ok := indexes[ob.TimeStart]
-		if !ok {
-			indexes[ob.TimeStart] = len(candleList)
-			candleList = append(candleList, ob)
-			continue
-		}
+func (c *Client) StoreOrderBookInternal(symbol string, orderBook models.OrderBookInternal) error {
+	if c.skipWrite("order book snapshot of %v with %v bids and %v asks", symbol, len(orderBook.Bids), len(orderBook.Asks)) {
+		return nil
+	}
 
-		if ob.High > candleList[r].High {
-			candleList[r].High = ob.High
-		}
+	data, err := json.Marshal(orderBook)
+	if err != nil {
+		c.log.Errorf("Could not marshal order book: %v", err)
+		return err
+	}
 
-		if ob.Low < candleList[r].Low {
-			candleList[r].Low = ob.Low
-		}
+	key := c.formatKey("orderBook", symbol)
+	if c.writer != nil {
+		c.writer.add(zRemRangeByScore(key, 0, time.Now().Add(-orderBookExpiration).Unix()),
+			zAdd(key, float64(time.Now().Unix()), string(data)))
+		c.storeMidPrice(symbol, orderBook)
+		return nil
+	}
 
-		candleList[r].Volume = toFixed(candleList[r].Volume + ob.Volume)
-		candleList[r].Open = toFixed((candleList[r].Open + ob.Open) / 2)
-		candleList[r].Close = toFixed((candleList[r].Close + ob.Close) / 2)
+	if err = c.storeMidPrice(symbol, orderBook); err != nil {
+		return err
 	}
 
-	for _, v := range resultPoloniex {
-		str, ok := v.Member.(string)
-		if !ok {
-			return nil, fmt.Errorf("%v is not string, but %v", v.Member, v.Member)
-		}
+	if err = c.purge(key, 0, time.Now().Add(-orderBookExpiration).Unix()); err != nil {
+		return err
+	}
 
-		var ob models.Candle
-		if err = json.Unmarshal([]byte(str), &ob); err != nil {
-			return nil, fmt.Errorf("could not unmarshal %v: %v", str, err)
-		}
+	return c.store(key, float64(time.Now(). /*.Round(roundTime)*/ Unix()), string(data))
+}
 
-		counts[ob.TimeStart]++
+// storeMidPrice records the mid price of the order book snapshot, members <time>:<price>.
+// The mid prices outlive the snapshots, so they can be time-weighted over longer windows.
+func (c *Client) storeMidPrice(symbol string, orderBook models.OrderBookInternal) error {
+	price := orderBook.BestBidOffer().Price()
+	if price <= 0 {
+		return nil
+	}
 
-		r, ok := indexes[ob.TimeStart]
-		if !ok {
-			indexes[ob.TimeStart] = len(candleList)
-			candleList = append(candleList, ob)
-			continue
-		}
+	now := time.Now()
+	key := c.formatKey("orderBookMid", symbol)
+	ms := now.UnixNano() / int64(time.Millisecond)
+	member := strconv.FormatInt(ms, 10) + ":" + strconv.FormatFloat(price, 'f', -1, 64)
+	expired := now.Add(-midPriceRetention).UnixNano() / int64(time.Millisecond)
 
-		if ob.High > candleList[r].High {
-			candleList[r].High = ob.High
-		}
+	if c.writer != nil {
+		c.writer.add(zRemRangeByScore(key, 0, expired), zAdd(key, float64(ms), member))
+		return nil
+	}
 
-		if ob.Low > candleList[r].Low {
-			candleList[r].Low = ob.Low
-		}
+	if err := c.purge(key, 0, expired); err != nil {
+		return err
+	}
+	return c.store(key, float64(ms), member)
+}
 
-		candleList[r].Volume = toFixed(candleList[r].Volume + ob.Volume)
+// LoadMidPrices returns the mid prices of the order book snapshots of the symbol within
+// [timeStart; timeEnd] in milliseconds, oldest first, preceded by the last one before timeStart
+// if there is one, so the price at timeStart is known.
+func (c *Client) LoadMidPrices(symbol string, timeStart, timeEnd int64) ([]models.MidPrice, error) {
+	key := c.formatKey("orderBookMid", symbol)
+	start := time.Now()
+	previous, err := c.redis().ZRevRangeByScore(key, redis.ZRangeByScore{
+		Max:   "(" + strconv.FormatInt(timeStart, 10),
+		Min:   "-inf",
+		Count: 1,
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
 
-		if counts[ob.TimeStart] == 1 {
-			candleList[r].Open = toFixed((candleList[r].Open + ob.Open) / 2)
-			candleList[r].Close = toFixed((candleList[r].Close + ob.Close) / 2)
+	result, err := c.redis().ZRangeByScore(key, redis.ZRangeByScore{
+		Min: strconv.FormatInt(timeStart, 10),
+		Max: strconv.FormatInt(timeEnd, 10),
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+	c.trace.Record("zrangebyscore", key, len(previous)+len(result), start)
+
+	prices := make([]models.MidPrice, 0, len(previous)+len(result))
+	for _, v := range append(previous, result...) {
+		parts := strings.SplitN(v, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid mid price %v", v)
 		}
-		if counts[ob.TimeStart] == 2 {
-			candleList[r].Open = toFixed((candleList[r].Open*2 + ob.Open) / 3)
-			candleList[r].Close = toFixed((candleList[r].Close*2 + ob.Close) / 3)
+
+		var price models.MidPrice
+		if price.Time, err = strconv.ParseInt(parts[0], 10, 64); err != nil {
+			return nil, fmt.Errorf("could not parse mid price time %v: %v", v, err)
+		}
+		if price.Price, err = strconv.ParseFloat(parts[1], 64); err != nil {
+			return nil, fmt.Errorf("could not parse mid price %v: %v", v, err)
 		}
+		prices = append(prices, price)
 	}
-
-	c.log.Debugf("LoadCandlestickList result: %+v", candleList)
-	return candleList, nil
+	return prices, nil
 }
 
-func (c *Client) StoreOrderBookInternal(symbol string, orderBook models.OrderBookInternal) error {
-	data, err := json.Marshal(orderBook)
+// StoreExchangeCandlestick flags and stores a normalized candle received from the exchange.
+func (c *Client) StoreExchangeCandlestick(exchange, symbol, interval string, candle *models.Candle) error {
+	c.flagAnomaly(exchange, symbol, interval, candle)
+
+	data, err := json.Marshal(candle)
 	if err != nil {
-		c.log.Errorf("Could not marshal order book: %v", err)
+		c.log.Errorf("Could not marshal candlestick: %v", err)
 		return err
 	}
 
-	if err = c.purge(c.formatKey("orderBook", symbol), 0, time.Now().Add(-orderBookExpiration).Unix()); err != nil {
+	return c.storeCandlestick(exchange, symbol, interval, candle, data)
+}
+
+// StoreCandlestick stores an already normalized candle of the exchange.
+func (c *Client) StoreCandlestick(exchange, symbol, interval string, candle *models.Candle) error {
+	data, err := json.Marshal(candle)
+	if err != nil {
+		c.log.Errorf("Could not marshal candlestick: %v", err)
 		return err
 	}
 
-	return c.store(c.formatKey("orderBook", symbol), float64(time.Now(). /*.Round(roundTime)*/ Unix()), string(data))
+	return c.storeCandlestick(exchange, symbol, interval, candle, data)
 }
 
-func (c *Client) StoreCandlestickBinance(symbol, interval string, candlestick *binance.WsKlineEvent) error {
-	candle := models.CandleFromEvent(candlestick)
+// StoreCurrentCandlestick keeps the in-progress candle of the exchange under a separate key
+// instead of rewriting the candlestick sorted set on every tick.
+func (c *Client) StoreCurrentCandlestick(exchange, symbol, interval string, candle *models.Candle) error {
+	c.flagAnomaly(exchange, symbol, interval, candle)
 
 	data, err := json.Marshal(candle)
 	if err != nil {
@@ -356,60 +606,448 @@ func (c *Client) StoreCandlestickBinance(symbol, interval string, candlestick *b
 		return err
 	}
 
-	return c.storeCandlestick("binance", symbol, interval, candle.TimeStart, data)
+	if c.skipWrite("current %v candle of %v %v: %s", interval, exchange, symbol, data) {
+		return nil
+	}
+
+	return c.redis().Set(c.formatKey(exchange, "currentCandlestick", symbol, interval), string(data), 0).Err()
+}
+
+// appendCurrentCandlestick adds the in-progress candle of the exchange to the result
+// if it is in range and has not been closed and stored yet.
+func (c *Client) appendCurrentCandlestick(result []redis.Z, exchange, symbol, interval string, min, max int64) ([]redis.Z, error) {
+	key := c.formatKey(exchange, "currentCandlestick", symbol, interval)
+	start := time.Now()
+	str, err := c.redis().Get(key).Result()
+	if err == redis.Nil {
+		c.trace.Record("get", key, 0, start)
+		return result, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	c.trace.Record("get", key, 1, start)
+
+	return appendCurrent(result, str, min, max)
+}
+
+// appendCurrent adds the in-progress candle to the result unless it is out of range
+// or already stored.
+func appendCurrent(result []redis.Z, str string, min, max int64) ([]redis.Z, error) {
+	var candle models.Candle
+	if err := json.Unmarshal([]byte(str), &candle); err != nil {
+		return nil, fmt.Errorf("could not unmarshal %v: %v", str, err)
+	}
+
+	if candle.TimeStart < min || candle.TimeStart > max {
+		return result, nil
+	}
+
+	if len(result) > 0 && int64(result[len(result)-1].Score) >= candle.TimeStart {
+		return result, nil
+	}
+
+	return append(result, redis.Z{Score: float64(candle.TimeStart), Member: str}), nil
+}
+
+// IncrementUsage adds the usage to the counters of the tenant for the month.
+func (c *Client) IncrementUsage(tenant, month string, usage models.Usage) error {
+	key := c.formatKey("usage", tenant, month)
+	_, err := c.redis().Pipelined(func(pipe *redis.Pipeline) error {
+		pipe.HIncrBy(key, "requests", usage.Requests)
+		pipe.HIncrBy(key, "bytes", usage.Bytes)
+		pipe.HIncrBy(key, "wsMessages", usage.WsMessages)
+		return nil
+	})
+	return err
+}
+
+// LoadUsage returns the usage counters of the tenant for the month.
+func (c *Client) LoadUsage(tenant, month string) (models.Usage, error) {
+	result, err := c.redis().HGetAllMap(c.formatKey("usage", tenant, month)).Result()
+	if err != nil {
+		return models.Usage{}, err
+	}
+
+	var usage models.Usage
+	usage.Requests, _ = strconv.ParseInt(result["requests"], 10, 64)
+	usage.Bytes, _ = strconv.ParseInt(result["bytes"], 10, 64)
+	usage.WsMessages, _ = strconv.ParseInt(result["wsMessages"], 10, 64)
+	return usage, nil
+}
+
+// StoreSymbolStatuses saves the trading statuses of the exchange symbols.
+func (c *Client) StoreSymbolStatuses(exchange string, statuses map[string]string) error {
+	if len(statuses) == 0 || c.skipWrite("%v symbol statuses of %v", len(statuses), exchange) {
+		return nil
+	}
+
+	return c.redis().HMSetMap(c.formatKey(exchange, "symbolStatus"), statuses).Err()
+}
+
+// LoadSymbolStatuses returns the trading statuses of the exchange symbols.
+func (c *Client) LoadSymbolStatuses(exchange string) (map[string]string, error) {
+	return c.redis().HGetAllMap(c.formatKey(exchange, "symbolStatus")).Result()
+}
+
+// StoreTrades adds the trades of the exchange symbol and drops the ones older than
+// the retention or beyond the trade limit.
+func (c *Client) StoreTrades(exchange, symbol string, trades []models.Trade) error {
+	if len(trades) == 0 || c.skipWrite("%v trades of %v %v", len(trades), exchange, symbol) {
+		return nil
+	}
+
+	members := make([]redis.Z, 0, len(trades))
+	for _, v := range trades {
+		data, err := json.Marshal(v)
+		if err != nil {
+			c.log.Errorf("Could not marshal trade: %v", err)
+			return err
+		}
+		members = append(members, redis.Z{Score: float64(v.Time), Member: string(data)})
+	}
+
+	key := c.formatKey(exchange, "trade", symbol)
+	if err := c.redis().ZAdd(key, members...).Err(); err != nil {
+		return err
+	}
+
+	c.storeHistoryTrades(trades)
+
+	limit := c.config.TradeLimit
+	if limit <= 0 {
+		limit = defaultTradeLimit
+	}
+	if err := c.redis().ZRemRangeByRank(key, 0, -limit-1).Err(); err != nil {
+		return err
+	}
+
+	return c.purge(key, 0, time.Now().Add(-tradeRetention).UnixNano()/int64(time.Millisecond))
 }
 
-func (c *Client) StoreCandlestickBinanceAPI(symbol, interval string, candlestick *binance.Kline) error {
-	candle := models.CandleFromBinanceAPI(candlestick)
-	data, err := json.Marshal(candle)
+// LoadTrades returns up to limit most recent trades of the exchange symbol within
+// [timeStart; timeEnd] in milliseconds, newest first.
+func (c *Client) LoadTrades(exchange, symbol string, timeStart, timeEnd, limit int64) ([]models.Trade, error) {
+	key := c.formatKey(exchange, "trade", symbol)
+	start := time.Now()
+	result, err := c.redis().ZRevRangeByScore(key, redis.ZRangeByScore{
+		Min:   strconv.FormatInt(timeStart, 10),
+		Max:   strconv.FormatInt(timeEnd, 10),
+		Count: limit,
+	}).Result()
 	if err != nil {
-		c.log.Errorf("Could not marshal candlestick: %v", err)
+		return nil, err
+	}
+	c.trace.Record("zrevrangebyscore", key, len(result), start)
+
+	trades := make([]models.Trade, 0, len(result))
+	for _, v := range result {
+		var trade models.Trade
+		if err = json.Unmarshal([]byte(v), &trade); err != nil {
+			return nil, fmt.Errorf("could not unmarshal %v: %v", v, err)
+		}
+		trades = append(trades, trade)
+	}
+	return trades, nil
+}
+
+// StoreSubscription records a symbol subscribed at runtime, so it is restored on startup.
+func (c *Client) StoreSubscription(exchange, symbol string) error {
+	if c.skipWrite("subscription of %v to %v", exchange, symbol) {
+		return nil
+	}
+
+	return c.redis().SAdd(c.formatKey(exchange, "subscriptions"), symbol).Err()
+}
+
+// LoadSubscriptions returns the symbols subscribed at runtime.
+func (c *Client) LoadSubscriptions(exchange string) ([]string, error) {
+	return c.redis().SMembers(c.formatKey(exchange, "subscriptions")).Result()
+}
+
+// StoreBackfillCursor records the open time of the last candle stored by a backfill,
+// so an interrupted backfill resumes after it.
+func (c *Client) StoreBackfillCursor(exchange, symbol, interval string, openTime int64) error {
+	if c.skipWrite("backfill cursor of %v %v %v at %v", exchange, symbol, interval, openTime) {
+		return nil
+	}
+
+	return c.redis().Set(c.formatKey(exchange, "backfill", symbol, interval), strconv.FormatInt(openTime, 10), 0).Err()
+}
+
+// LoadBackfillCursor returns the open time of the last candle stored by a backfill.
+func (c *Client) LoadBackfillCursor(exchange, symbol, interval string) (int64, bool, error) {
+	value, err := c.redis().Get(c.formatKey(exchange, "backfill", symbol, interval)).Result()
+	if err == redis.Nil {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+
+	openTime, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("could not parse backfill cursor %v: %v", value, err)
+	}
+	return openTime, true, nil
+}
+
+// DeleteBackfillCursor removes the cursor of a completed backfill.
+func (c *Client) DeleteBackfillCursor(exchange, symbol, interval string) error {
+	return c.redis().Del(c.formatKey(exchange, "backfill", symbol, interval)).Err()
+}
+
+// StoreDeadLetter keeps the raw event in a capped list for later inspection.
+func (c *Client) StoreDeadLetter(deadLetter models.DeadLetter) error {
+	data, err := json.Marshal(deadLetter)
+	if err != nil {
+		c.log.Errorf("Could not marshal dead letter: %v", err)
 		return err
 	}
 
-	return c.storeCandlestick("binance", symbol, interval, candle.TimeStart, data)
+	if c.skipWrite("dead letter of %v %v: %v", deadLetter.Exchange, deadLetter.Stream, deadLetter.Error) {
+		return nil
+	}
+
+	key := c.formatKey("deadLetters")
+	if err = c.redis().LPush(key, string(data)).Err(); err != nil {
+		return err
+	}
+
+	return c.redis().LTrim(key, 0, deadLetterLimit-1).Err()
 }
 
-func (c *Client) StoreCandlestickBittrexAPI(symbol, interval string, candlestick *bittrex.Candle) error {
-	candle := models.CandleFromBittrexAPI(candlestick)
-	data, err := json.Marshal(candle)
+// LoadDeadLetters returns up to limit most recent dead letters.
+func (c *Client) LoadDeadLetters(limit int64) ([]models.DeadLetter, error) {
+	result, err := c.redis().LRange(c.formatKey("deadLetters"), 0, limit-1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	deadLetters := make([]models.DeadLetter, 0, len(result))
+	for _, v := range result {
+		var deadLetter models.DeadLetter
+		if err = json.Unmarshal([]byte(v), &deadLetter); err != nil {
+			return nil, fmt.Errorf("could not unmarshal %v: %v", v, err)
+		}
+		deadLetters = append(deadLetters, deadLetter)
+	}
+
+	return deadLetters, nil
+}
+
+// StoreWebhook creates or replaces the webhook.
+func (c *Client) StoreWebhook(webhook models.Webhook) error {
+	data, err := json.Marshal(webhook)
 	if err != nil {
-		c.log.Errorf("Could not marshal candlestick: %v", err)
 		return err
 	}
 
-	return c.storeCandlestick("bittrex", models.BittrexSymbolToBinance(symbol), interval, candle.TimeStart, data)
+	if c.skipWrite("webhook %v of %v", webhook.ID, webhook.Owner) {
+		return nil
+	}
+
+	return c.redis().HSet(c.formatKey("webhooks"), webhook.ID, string(data)).Err()
 }
 
-func (c *Client) StoreCandlestickPoloniexAPI(symbol, interval string, candlestick *poloniex.CandleStick) error {
-	candle := models.CandleFromPoloniexApi(candlestick)
-	data, err := json.Marshal(candle)
+// DeleteWebhook deletes the webhook, it reports whether the webhook existed.
+func (c *Client) DeleteWebhook(id string) (bool, error) {
+	if c.skipWrite("deletion of webhook %v", id) {
+		return true, nil
+	}
+
+	deleted, err := c.redis().HDel(c.formatKey("webhooks"), id).Result()
+	return deleted > 0, err
+}
+
+// LoadWebhooks returns all webhooks.
+func (c *Client) LoadWebhooks() ([]models.Webhook, error) {
+	result, err := c.redis().HVals(c.formatKey("webhooks")).Result()
 	if err != nil {
-		c.log.Errorf("Could not marshal candlestick: %v", err)
+		return nil, err
+	}
+
+	webhooks := make([]models.Webhook, 0, len(result))
+	for _, v := range result {
+		var webhook models.Webhook
+		if err = json.Unmarshal([]byte(v), &webhook); err != nil {
+			return nil, fmt.Errorf("could not unmarshal %v: %v", v, err)
+		}
+		webhooks = append(webhooks, webhook)
+	}
+
+	return webhooks, nil
+}
+
+// StoreAPIKey creates or replaces the API key with the hash. The key itself isn't stored.
+func (c *Client) StoreAPIKey(hash string, key models.APIKey) error {
+	key.Key = ""
+	data, err := json.Marshal(key)
+	if err != nil {
+		return err
+	}
+
+	if c.skipWrite("API key %v", key.ID) {
+		return nil
+	}
+
+	return c.redis().HSet(c.formatKey("apiKeys"), hash, string(data)).Err()
+}
+
+// DeleteAPIKey deletes the API key with the hash, it reports whether the key existed.
+func (c *Client) DeleteAPIKey(hash string) (bool, error) {
+	if c.skipWrite("deletion of API key %v", hash) {
+		return true, nil
+	}
+
+	deleted, err := c.redis().HDel(c.formatKey("apiKeys"), hash).Result()
+	return deleted > 0, err
+}
+
+// LoadAPIKeys returns all API keys by their hashes.
+func (c *Client) LoadAPIKeys() (map[string]models.APIKey, error) {
+	result, err := c.redis().HGetAllMap(c.formatKey("apiKeys")).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]models.APIKey, len(result))
+	for hash, v := range result {
+		var key models.APIKey
+		if err = json.Unmarshal([]byte(v), &key); err != nil {
+			return nil, fmt.Errorf("could not unmarshal %v: %v", v, err)
+		}
+		keys[hash] = key
+	}
+
+	return keys, nil
+}
+
+func (c *Client) storeCandlestick(exchange, symbol, interval string, candle *models.Candle, candlestick []byte) error {
+	if c.skipWrite("%v candle of %v %v: %s", interval, exchange, symbol, candlestick) {
+		return nil
+	}
+
+	openTime := candle.TimeStart
+	c.storeHistoryCandles(exchange, symbol, interval, []models.Candle{*candle})
+
+	key := c.formatKey(exchange, "candlestick", symbol, interval)
+	if c.writer != nil {
+		c.writer.add(zRemRangeByScore(key, openTime, openTime), zAdd(key, float64(openTime), string(candlestick)))
+		if rollupSourceIntervals[exchange] == interval {
+			c.writer.addRollup(exchange, symbol, openTime)
+		}
+		return nil
+	}
+
+	if err := c.purge(key, openTime, openTime); err != nil {
+		return err
+	}
+
+	if err := c.store(key, float64(openTime), string(candlestick)); err != nil {
 		return err
 	}
 
-	return c.storeCandlestick("poloniex", models.PoloniexSymbolToBinance(symbol), interval, candle.TimeStart, data)
+	if rollupSourceIntervals[exchange] == interval {
+		return c.updateRollups(exchange, symbol, openTime)
+	}
+
+	return nil
+}
+
+// rollupSourceIntervals are the intervals the daily rollups of the exchanges are built from.
+var rollupSourceIntervals = map[string]string{
+	"binance":   "1h",
+	"bittrex":   "1h",
+	"poloniex":  "2h",
+	"coinbase":  "1h",
+	"okx":       "1h",
+	"bitfinex":  "1h",
+	"kucoin":    "1h",
+	"bybit":     "1h",
+	"huobi":     "1h",
+	"synthetic": "1h",
 }
 
-func (c *Client) storeCandlestick(exchange, symbol, interval string, openTime int64, candlestick []byte) error {
-	if err := c.purge(c.formatKey(exchange, "candlestick", symbol, interval), openTime, openTime); err != nil {
+// updateRollups rebuilds the daily rollup containing openTime from the source candles
+// and the weekly rollup from the daily ones, so only a bounded number of candles is read.
+func (c *Client) updateRollups(exchange, symbol string, openTime int64) error {
+	dayStart := time.Unix(openTime, 0).Truncate(day).Unix()
+	if err := c.updateRollup(exchange, c.formatKey(exchange, "candlestick", symbol, rollupSourceIntervals[exchange]),
+		c.formatKey(exchange, rollupKind, symbol, "1d"), dayStart, int64(day.Seconds())); err != nil {
 		return err
 	}
 
-	return c.store(c.formatKey(exchange, "candlestick", symbol, interval), float64(openTime), string(candlestick))
+	weekStart := time.Unix(openTime, 0).Truncate(week).Unix()
+	return c.updateRollup(exchange, c.formatKey(exchange, rollupKind, symbol, "1d"),
+		c.formatKey(exchange, rollupKind, symbol, "1w"), weekStart, int64(week.Seconds()))
+}
+
+// updateRollup merges the candles of sourceKey within [start; start+length) into one candle stored in key.
+func (c *Client) updateRollup(exchange, sourceKey, key string, start, length int64) error {
+	result, err := c.redis().ZRangeByScore(sourceKey, redis.ZRangeByScore{
+		Min: strconv.FormatInt(start, 10),
+		Max: strconv.FormatInt(start+length-1, 10),
+	}).Result()
+	if err != nil {
+		return err
+	}
+
+	var rollup *models.Candle
+	for _, v := range result {
+		var candle models.Candle
+		if err = json.Unmarshal([]byte(v), &candle); err != nil {
+			return fmt.Errorf("could not unmarshal %v: %v", v, err)
+		}
+
+		if rollup == nil {
+			rollup = &candle
+			rollup.TimeStart = start
+			rollup.TimeEnd = start + length - 1
+			rollup.Source = models.CandleSourceRollup
+			continue
+		}
+
+		rollup.Close = candle.Close
+		if candle.High > rollup.High {
+			rollup.High = candle.High
+		}
+		if candle.Low < rollup.Low {
+			rollup.Low = candle.Low
+		}
+		if candle.Time > rollup.Time {
+			rollup.Time = candle.Time
+		}
+		rollup.Volume = toFixed(rollup.Volume + candle.Volume)
+	}
+
+	if rollup == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(rollup)
+	if err != nil {
+		c.log.Errorf("Could not marshal rollup: %v", err)
+		return err
+	}
+
+	if err = c.purge(key, start, start); err != nil {
+		return err
+	}
+
+	return c.store(key, float64(start), string(data))
 }
 
 // store adds a new value and score in a sorted set with specified key.
 func (c *Client) store(key string, score float64, val string) error {
-	return c.client.ZAdd(key, redis.Z{
+	return c.redis().ZAdd(key, redis.Z{
 		Score:  score,
 		Member: val,
 	}).Err()
 }
 
 func (c *Client) purge(key string, min, max int64) error {
-	return c.client.ZRemRangeByScore(key, strconv.FormatInt(min, 10), strconv.FormatInt(max, 10)).Err()
+	return c.redis().ZRemRangeByScore(key, strconv.FormatInt(min, 10), strconv.FormatInt(max, 10)).Err()
 }
 
 // formatKey formats keys using given args separating them with a colon.
@@ -460,3 +1098,10 @@ func toFixed(x float64) float64 {
 	output := math.Pow(10, float64(precision))
 	return float64(round(x*output)) / output
 }
+
+// PendingQueries returns the number of database connections currently in use,
+// which is the depth of the queue of storage operations in flight.
+func (c *Client) PendingQueries() int {
+	stats := c.redis().PoolStats()
+	return int(stats.TotalConns) - int(stats.FreeConns)
+}
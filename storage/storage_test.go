@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"gopkg.in/redis.v3"
+
+	"price-feed/models"
+)
+
+// stored returns the candles as they are read from a sorted set.
+func stored(t *testing.T, candles ...models.Candle) []redis.Z {
+	result := make([]redis.Z, 0, len(candles))
+	for _, candle := range candles {
+		data, err := json.Marshal(candle)
+		if err != nil {
+			t.Fatalf("could not marshal candle: %v", err)
+		}
+		result = append(result, redis.Z{Score: float64(candle.TimeStart), Member: string(data)})
+	}
+	return result
+}
+
+func TestMergedPeriodsOfClosedCandlesOnly(t *testing.T) {
+	binance := []models.Candle{
+		{TimeStart: 60, Open: 100, Close: 102, High: 103, Low: 99, Volume: 1},
+		{TimeStart: 120, Open: 102, Close: 104, High: 105, Low: 101, Volume: 1},
+	}
+	// Bittrex and Coinbase store the closed candles only; the in-progress ones are kept aside.
+	bittrex := []models.Candle{
+		{TimeStart: 60, Open: 101, Close: 103, High: 104, Low: 100, Volume: 3},
+	}
+	currents := map[string]models.Candle{
+		"binance":  binance[1],
+		"bittrex":  {TimeStart: 120, Open: 103, Close: 106, High: 107, Low: 102, Volume: 3},
+		"coinbase": {TimeStart: 120, Open: 104, Close: 105, High: 106, Low: 103, Volume: 2},
+	}
+	series := map[string][]models.Candle{"binance": binance, "bittrex": bittrex}
+
+	periods := make(map[int64][]models.Candle)
+	for _, exchange := range []string{"binance", "bittrex", "coinbase"} {
+		current, err := json.Marshal(currents[exchange])
+		if err != nil {
+			t.Fatalf("could not marshal candle: %v", err)
+		}
+
+		result, err := appendCurrent(stored(t, series[exchange]...), string(current), 60, 180)
+		if err != nil {
+			t.Fatalf("could not append the current candle of %v: %v", exchange, err)
+		}
+		if err = addPeriods(periods, result); err != nil {
+			t.Fatalf("could not add the periods of %v: %v", exchange, err)
+		}
+	}
+
+	want := map[int64][]models.Candle{
+		60:  {binance[0], bittrex[0]},
+		120: {binance[1], currents["bittrex"], currents["coinbase"]},
+	}
+	if !reflect.DeepEqual(periods, want) {
+		t.Fatalf("periods = %+v, want %+v", periods, want)
+	}
+
+	merged, ok := mergeCandles(models.MergeAverage, false, periods[120])
+	if !ok {
+		t.Fatalf("could not merge the in-progress candles")
+	}
+	if merged.Volume != 6 {
+		t.Errorf("volume = %v, want the in-progress candles of every exchange", merged.Volume)
+	}
+}
+
+func TestAppendCurrent(t *testing.T) {
+	closed := models.Candle{TimeStart: 60, Open: 1, Close: 2, High: 2, Low: 1, Volume: 1}
+
+	tests := []struct {
+		name    string
+		current models.Candle
+		want    int
+	}{
+		{
+			name:    "in-progress candle",
+			current: models.Candle{TimeStart: 120, Open: 2, Close: 3, High: 3, Low: 2, Volume: 1},
+			want:    2,
+		},
+		{
+			name:    "already stored",
+			current: closed,
+			want:    1,
+		},
+		{
+			name:    "out of range",
+			current: models.Candle{TimeStart: 240, Open: 2, Close: 3, High: 3, Low: 2, Volume: 1},
+			want:    1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			current, err := json.Marshal(tt.current)
+			if err != nil {
+				t.Fatalf("could not marshal candle: %v", err)
+			}
+
+			result, err := appendCurrent(stored(t, closed), string(current), 0, 180)
+			if err != nil {
+				t.Fatalf("could not append the current candle: %v", err)
+			}
+			if len(result) != tt.want {
+				t.Errorf("candles = %v, want %v", len(result), tt.want)
+			}
+		})
+	}
+}
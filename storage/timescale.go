@@ -0,0 +1,319 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	// The default driver of the history, also encoding the array parameters.
+	"github.com/lib/pq"
+	"price-feed/models"
+)
+
+const (
+	defaultTimescaleDriver = "postgres"
+	// timescaleBatchSize is the number of rows inserted by one statement.
+	timescaleBatchSize        = 500
+	defaultHistoryQueueSize   = 1000
+	defaultHistoryExecTimeout = 10 * time.Second
+)
+
+// TimescaleConfig represents a Postgres/TimescaleDB history configuration. The candles and
+// the trades are written there in addition to Redis. Driver defaults to postgres, served
+// by github.com/lib/pq, any other driver must be registered by an import in main.
+type TimescaleConfig struct {
+	DSN    string `json:"dsn"`
+	Driver string `json:"driver"`
+	// MaxOpenConns limits the connection pool, unlimited by default.
+	MaxOpenConns int `json:"max_open_conns"`
+	// QueueSize is the number of statements waiting for the history writer, 1000 by default.
+	// The rows of the statements over it are dropped, so the history never blocks the workers.
+	QueueSize int `json:"queue_size"`
+	// ExecTimeout limits a single statement, 10s by default.
+	ExecTimeout string `json:"exec_timeout"`
+	// ReadCandles serves the candle ranges of the exchanges from TimescaleDB instead of Redis.
+	ReadCandles bool `json:"read_candles"`
+}
+
+// timescaleSchema creates the hypertables. The primary keys lead with the columns the API
+// filters by and end with the time, which TimescaleDB requires in every unique index.
+var timescaleSchema = []string{
+	`CREATE EXTENSION IF NOT EXISTS timescaledb`,
+	`CREATE TABLE IF NOT EXISTS candles (
+		exchange   TEXT             NOT NULL,
+		symbol     TEXT             NOT NULL,
+		interval   TEXT             NOT NULL,
+		time_start TIMESTAMPTZ      NOT NULL,
+		time_end   TIMESTAMPTZ      NOT NULL,
+		updated    TIMESTAMPTZ      NOT NULL,
+		open       DOUBLE PRECISION NOT NULL,
+		high       DOUBLE PRECISION NOT NULL,
+		low        DOUBLE PRECISION NOT NULL,
+		close      DOUBLE PRECISION NOT NULL,
+		volume     DOUBLE PRECISION NOT NULL,
+		anomalous  BOOLEAN          NOT NULL DEFAULT FALSE,
+		source     TEXT             NOT NULL DEFAULT '',
+		PRIMARY KEY (exchange, symbol, interval, time_start)
+	)`,
+	`SELECT create_hypertable('candles', 'time_start', chunk_time_interval => INTERVAL '30 days', if_not_exists => TRUE)`,
+	`CREATE INDEX IF NOT EXISTS candles_symbol_interval_time ON candles (symbol, interval, time_start DESC)`,
+	`CREATE TABLE IF NOT EXISTS trades (
+		exchange TEXT             NOT NULL,
+		symbol   TEXT             NOT NULL,
+		id       TEXT             NOT NULL,
+		time     TIMESTAMPTZ      NOT NULL,
+		price    DOUBLE PRECISION NOT NULL,
+		quantity DOUBLE PRECISION NOT NULL,
+		side     TEXT             NOT NULL,
+		PRIMARY KEY (exchange, symbol, id, time)
+	)`,
+	`SELECT create_hypertable('trades', 'time', chunk_time_interval => INTERVAL '1 day', if_not_exists => TRUE)`,
+	`CREATE INDEX IF NOT EXISTS trades_exchange_symbol_time ON trades (exchange, symbol, time DESC)`,
+}
+
+const candleColumns = "exchange, symbol, interval, time_start, time_end, updated, open, high, low, close, volume, anomalous, source"
+
+const tradeColumns = "exchange, symbol, id, time, price, quantity, side"
+
+// historyWrite is a statement queued for the history writer.
+type historyWrite struct {
+	query string
+	args  []interface{}
+	rows  int
+	// what describes the rows in the logs, e.g. binance BTCUSDT 1m candles.
+	what string
+}
+
+// InitHistory connects to TimescaleDB and creates the hypertables if the history is
+// configured. It must be called before the workers start writing.
+func (c *Client) InitHistory() error {
+	cfg := c.config.Timescale
+	if cfg == nil {
+		return nil
+	}
+
+	driver := cfg.Driver
+	if driver == "" {
+		driver = defaultTimescaleDriver
+	}
+
+	db, err := sql.Open(driver, cfg.DSN)
+	if err != nil {
+		return fmt.Errorf("could not open TimescaleDB: %v", err)
+	}
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+
+	execTimeout := defaultHistoryExecTimeout
+	if cfg.ExecTimeout != "" {
+		if execTimeout, err = time.ParseDuration(cfg.ExecTimeout); err != nil {
+			db.Close()
+			return fmt.Errorf("could not parse TimescaleDB exec timeout: %v", err)
+		}
+	}
+
+	queueSize := defaultHistoryQueueSize
+	if cfg.QueueSize > 0 {
+		queueSize = cfg.QueueSize
+	}
+
+	if err = db.Ping(); err != nil {
+		db.Close()
+		return fmt.Errorf("could not connect to TimescaleDB: %v", err)
+	}
+
+	for _, statement := range timescaleSchema {
+		if _, err = db.Exec(statement); err != nil {
+			db.Close()
+			return fmt.Errorf("could not create TimescaleDB schema: %v", err)
+		}
+	}
+
+	c.history = db
+	c.historyWrites = make(chan historyWrite, queueSize)
+	go c.runHistoryWriter(execTimeout)
+	return nil
+}
+
+// runHistoryWriter executes the queued statements one at a time. The failures are logged.
+func (c *Client) runHistoryWriter(execTimeout time.Duration) {
+	for write := range c.historyWrites {
+		ctx, cancel := context.WithTimeout(context.Background(), execTimeout)
+		if _, err := c.history.ExecContext(ctx, write.query, write.args...); err != nil {
+			atomic.AddInt64(&c.historyDropped, int64(write.rows))
+			c.log.Errorf("Could not store %v to TimescaleDB: %v", write.what, err)
+		}
+		cancel()
+	}
+}
+
+// queueHistoryWrite queues the statement for the history writer, or drops it if the queue
+// is full, so the history never blocks the writes to Redis.
+func (c *Client) queueHistoryWrite(write historyWrite) {
+	select {
+	case c.historyWrites <- write:
+	default:
+		if atomic.AddInt64(&c.historyDropped, int64(write.rows)) == int64(write.rows) {
+			c.log.Warnf("TimescaleDB history writer is behind, dropping rows")
+		}
+	}
+}
+
+// storeHistoryCandles queues the upsert of the candles into the history.
+func (c *Client) storeHistoryCandles(exchange, symbol, interval string, candles []models.Candle) {
+	if c.history == nil || len(candles) == 0 {
+		return
+	}
+
+	for start := 0; start < len(candles); start += timescaleBatchSize {
+		end := start + timescaleBatchSize
+		if end > len(candles) {
+			end = len(candles)
+		}
+
+		batch := candles[start:end]
+		args := make([]interface{}, 0, len(batch)*13)
+		for _, v := range batch {
+			args = append(args, exchange, symbol, interval, time.Unix(v.TimeStart, 0), time.Unix(v.TimeEnd, 0),
+				time.Unix(v.Time, 0), v.Open, v.High, v.Low, v.Close, v.Volume, v.Anomalous, v.Source)
+		}
+
+		query := "INSERT INTO candles (" + candleColumns + ") VALUES " + placeholders(len(batch), 13) +
+			` ON CONFLICT (exchange, symbol, interval, time_start) DO UPDATE SET
+				time_end = EXCLUDED.time_end, updated = EXCLUDED.updated, open = EXCLUDED.open,
+				high = EXCLUDED.high, low = EXCLUDED.low, close = EXCLUDED.close, volume = EXCLUDED.volume,
+				anomalous = EXCLUDED.anomalous, source = EXCLUDED.source`
+
+		c.queueHistoryWrite(historyWrite{
+			query: query,
+			args:  args,
+			rows:  len(batch),
+			what:  fmt.Sprintf("%v %v %v candles", exchange, symbol, interval),
+		})
+	}
+}
+
+// storeHistoryTrades queues the insert of the trades into the history, skipping the ones
+// already stored.
+func (c *Client) storeHistoryTrades(trades []models.Trade) {
+	if c.history == nil || len(trades) == 0 {
+		return
+	}
+
+	for start := 0; start < len(trades); start += timescaleBatchSize {
+		end := start + timescaleBatchSize
+		if end > len(trades) {
+			end = len(trades)
+		}
+
+		batch := trades[start:end]
+		args := make([]interface{}, 0, len(batch)*7)
+		for _, v := range batch {
+			args = append(args, v.Exchange, v.Symbol, v.ID, time.Unix(0, v.Time*int64(time.Millisecond)),
+				v.Price, v.Quantity, v.Side)
+		}
+
+		query := "INSERT INTO trades (" + tradeColumns + ") VALUES " + placeholders(len(batch), 7) +
+			" ON CONFLICT DO NOTHING"
+
+		c.queueHistoryWrite(historyWrite{query: query, args: args, rows: len(batch), what: "trades"})
+	}
+}
+
+// loadHistoryCandles returns the candles of the exchange with the open time within
+// [timeStart; timeEnd], oldest first.
+func (c *Client) loadHistoryCandles(exchange, symbol, interval string, timeStart, timeEnd int64) ([]models.Candle, error) {
+	start := time.Now()
+	rows, err := c.history.Query(`SELECT time_start, time_end, updated, open, high, low, close, volume, anomalous, source
+		FROM candles
+		WHERE exchange = $1 AND symbol = $2 AND interval = $3 AND time_start BETWEEN $4 AND $5 AND volume <> 0
+		ORDER BY time_start`,
+		exchange, symbol, interval, time.Unix(timeStart, 0), time.Unix(timeEnd, 0))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	candles := make([]models.Candle, 0)
+	for rows.Next() {
+		candle, err := scanHistoryCandle(rows)
+		if err != nil {
+			return nil, err
+		}
+		candles = append(candles, candle)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	c.trace.Record("select candles", strings.Join([]string{exchange, symbol, interval}, ":"), len(candles), start)
+	return candles, nil
+}
+
+// loadHistoryPeriods returns the candles of the exchanges with the open time within
+// [timeStart; timeEnd] by their open time, the candles of every period in the order
+// of the exchanges, with one query.
+func (c *Client) loadHistoryPeriods(exchanges []string, symbol, interval string, timeStart, timeEnd int64) (map[int64][]models.Candle, error) {
+	start := time.Now()
+	rows, err := c.history.Query(`SELECT time_start, time_end, updated, open, high, low, close, volume, anomalous, source
+		FROM candles
+		WHERE exchange = ANY($1::text[]) AND symbol = $2 AND interval = $3 AND time_start BETWEEN $4 AND $5 AND volume <> 0
+		ORDER BY time_start, array_position($1::text[], exchange)`,
+		pq.Array(exchanges), symbol, interval, time.Unix(timeStart, 0), time.Unix(timeEnd, 0))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	periods := make(map[int64][]models.Candle)
+	count := 0
+	for rows.Next() {
+		candle, err := scanHistoryCandle(rows)
+		if err != nil {
+			return nil, err
+		}
+		periods[candle.TimeStart] = append(periods[candle.TimeStart], candle)
+		count++
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	c.trace.Record("select candles", strings.Join([]string{strings.Join(exchanges, ","), symbol, interval}, ":"), count, start)
+	return periods, nil
+}
+
+func scanHistoryCandle(rows *sql.Rows) (models.Candle, error) {
+	var candle models.Candle
+	var candleStart, candleEnd, updated time.Time
+	if err := rows.Scan(&candleStart, &candleEnd, &updated, &candle.Open, &candle.High, &candle.Low,
+		&candle.Close, &candle.Volume, &candle.Anomalous, &candle.Source); err != nil {
+		return models.Candle{}, fmt.Errorf("could not scan candle: %v", err)
+	}
+
+	candle.TimeStart, candle.TimeEnd, candle.Time = candleStart.Unix(), candleEnd.Unix(), updated.Unix()
+	return candle, nil
+}
+
+// placeholders returns the VALUES list of rows of $n parameters, e.g. ($1, $2), ($3, $4).
+func placeholders(rows, columns int) string {
+	var b strings.Builder
+	for i := 0; i < rows; i++ {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteByte('(')
+		for j := 0; j < columns; j++ {
+			if j > 0 {
+				b.WriteString(", ")
+			}
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(i*columns + j + 1))
+		}
+		b.WriteByte(')')
+	}
+	return b.String()
+}
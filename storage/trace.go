@@ -0,0 +1,43 @@
+package storage
+
+import (
+	"sync"
+	"time"
+)
+
+// Trace records the queries of a single request, so slow ones can be diagnosed.
+type Trace struct {
+	mu    sync.Mutex
+	Steps []TraceStep `json:"steps"`
+}
+
+// TraceStep is a single database read or processing step of a traced request.
+type TraceStep struct {
+	Step string `json:"step"`
+	Key  string `json:"key,omitempty"`
+	// Members is the number of members read or processed by the step.
+	Members    int     `json:"members"`
+	DurationMs float64 `json:"durationMs"`
+}
+
+// Record adds a step which started at start. It does nothing on a nil trace.
+func (t *Trace) Record(step, key string, members int, start time.Time) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.Steps = append(t.Steps, TraceStep{
+		Step:       step,
+		Key:        key,
+		Members:    members,
+		DurationMs: float64(time.Since(start)) / float64(time.Millisecond),
+	})
+}
+
+// WithTrace returns a client sharing the connection pool which records its queries to the trace.
+func (c *Client) WithTrace(trace *Trace) *Client {
+	return &Client{connection: c.connection, trace: trace, dryRun: c.dryRun}
+}
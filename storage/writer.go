@@ -0,0 +1,237 @@
+package storage
+
+import (
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/redis.v3"
+)
+
+const (
+	defaultWriteFlushInterval = 50 * time.Millisecond
+	defaultWriteMaxCommands   = 500
+	// maxRequeuedBatches bounds the commands kept for the next flush after a failed one
+	// to that many batches, the oldest commands are dropped beyond it.
+	maxRequeuedBatches = 10
+)
+
+// WriteBatchConfig represents the configuration of the batched candle and order book writes.
+type WriteBatchConfig struct {
+	// FlushInterval is the longest a write is held before it is sent, 50ms by default.
+	FlushInterval string `json:"flush_interval"`
+	// MaxCommands flushes the batch early once it holds that many commands, 500 by default.
+	MaxCommands int `json:"max_commands"`
+}
+
+// writeCommand queues a single command on the pipeline and returns it.
+type writeCommand func(pipe *redis.Pipeline) redis.Cmder
+
+// rollupUpdate is a rollup rebuild postponed until the source candle is flushed.
+type rollupUpdate struct {
+	exchange string
+	symbol   string
+	openTime int64
+}
+
+// writeBatcher accumulates the candle and order book writes and sends them in one pipeline
+// every flush interval or max commands, instead of a round trip per command. The writes are
+// applied in order, but they are seen by the reads only once flushed.
+type writeBatcher struct {
+	mu            sync.Mutex
+	commands      []writeCommand
+	rollups       map[string]rollupUpdate
+	maxCommands   int
+	flushInterval time.Duration
+	full          chan struct{}
+	// flushMu serializes the flushes, so the batches are applied in order.
+	flushMu sync.Mutex
+	// dropped counts the commands which were never applied.
+	dropped int64
+}
+
+func newWriteBatcher(config *WriteBatchConfig) (*writeBatcher, error) {
+	b := &writeBatcher{
+		rollups:       make(map[string]rollupUpdate),
+		maxCommands:   defaultWriteMaxCommands,
+		flushInterval: defaultWriteFlushInterval,
+		full:          make(chan struct{}, 1),
+	}
+
+	if config.MaxCommands > 0 {
+		b.maxCommands = config.MaxCommands
+	}
+
+	if config.FlushInterval != "" {
+		interval, err := time.ParseDuration(config.FlushInterval)
+		if err != nil {
+			return nil, err
+		}
+		b.flushInterval = interval
+	}
+
+	return b, nil
+}
+
+// add queues the commands of a single write, which are always sent in the same batch.
+func (b *writeBatcher) add(commands ...writeCommand) {
+	b.mu.Lock()
+	b.commands = append(b.commands, commands...)
+	full := len(b.commands) >= b.maxCommands
+	b.mu.Unlock()
+
+	if full {
+		select {
+		case b.full <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// addRollup postpones the rollup rebuild of the candle until the batch is flushed.
+// The rebuilds of the same day are done once per batch.
+func (b *writeBatcher) addRollup(exchange, symbol string, openTime int64) {
+	dayStart := time.Unix(openTime, 0).Truncate(day).Unix()
+	key := exchange + ":" + symbol + ":" + strconv.FormatInt(dayStart, 10)
+
+	b.mu.Lock()
+	b.rollups[key] = rollupUpdate{exchange: exchange, symbol: symbol, openTime: openTime}
+	b.mu.Unlock()
+}
+
+// requeue puts the commands of a failed flush back in front of the batch, so they are
+// retried by the next flush, along with the rollups to rebuild after them.
+func (b *writeBatcher) requeue(commands []writeCommand, rollups map[string]rollupUpdate) (dropped int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.commands = append(commands, b.commands...)
+	if max := maxRequeuedBatches * b.maxCommands; len(b.commands) > max {
+		dropped = len(b.commands) - max
+		b.commands = b.commands[dropped:]
+	}
+	for k, v := range rollups {
+		b.rollups[k] = v
+	}
+	return dropped
+}
+
+// runWriteBatcher flushes the batch every flush interval or once it is full.
+func (c *Client) runWriteBatcher() {
+	ticker := time.NewTicker(c.writer.flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-c.writer.full:
+		}
+
+		if err := c.FlushWrites(); err != nil {
+			c.log.Errorf("Could not flush batched writes: %v", err)
+		}
+	}
+}
+
+// FlushWrites sends the batched writes and rebuilds the affected rollups.
+// The commands which failed on a connection error are retried by the next flush,
+// the ones rejected by Redis are dropped. It does nothing when the writes aren't batched.
+func (c *Client) FlushWrites() error {
+	b := c.writer
+	if b == nil {
+		return nil
+	}
+
+	b.flushMu.Lock()
+	defer b.flushMu.Unlock()
+
+	b.mu.Lock()
+	commands, rollups := b.commands, b.rollups
+	b.commands = nil
+	b.rollups = make(map[string]rollupUpdate)
+	b.mu.Unlock()
+
+	var flushErr error
+	if len(commands) > 0 {
+		var cmds []redis.Cmder
+		_, flushErr = c.redis().Pipelined(func(pipe *redis.Pipeline) error {
+			for _, command := range commands {
+				cmds = append(cmds, command(pipe))
+			}
+			return nil
+		})
+
+		if flushErr != nil {
+			var retried []writeCommand
+			applied, rejected := 0, 0
+			for i, cmd := range cmds {
+				switch err := cmd.Err(); {
+				case err == nil || err == redis.Nil:
+					applied++
+				case isConnectionError(err):
+					retried = append(retried, commands[i])
+				default:
+					rejected++
+				}
+			}
+
+			if len(retried) > 0 {
+				// The rollups are rebuilt again once the retried commands are applied.
+				if dropped := b.requeue(retried, rollups); dropped > 0 {
+					rejected += dropped
+					c.log.Warnf("Dropped %v batched writes over the retry limit", dropped)
+				}
+			}
+			if rejected > 0 {
+				atomic.AddInt64(&b.dropped, int64(rejected))
+			}
+			if applied == 0 {
+				return flushErr
+			}
+		}
+	}
+
+	for _, v := range rollups {
+		if err := c.updateRollups(v.exchange, v.symbol, v.openTime); err != nil {
+			c.log.Errorf("Could not update rollups of %v %v: %v", v.exchange, v.symbol, err)
+		}
+	}
+	return flushErr
+}
+
+// isConnectionError reports whether the command failed to reach Redis, rather than
+// being rejected by it.
+func isConnectionError(err error) bool {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return true
+	}
+	_, ok := err.(net.Error)
+	return ok
+}
+
+// DroppedWrites returns the number of writes which were never applied by store:
+// redis for the batched writes and timescale for the history rows.
+func (c *Client) DroppedWrites() map[string]int64 {
+	dropped := map[string]int64{
+		"timescale": atomic.LoadInt64(&c.historyDropped),
+	}
+	if c.writer != nil {
+		dropped["redis"] = atomic.LoadInt64(&c.writer.dropped)
+	}
+	return dropped
+}
+
+func zAdd(key string, score float64, val string) writeCommand {
+	return func(pipe *redis.Pipeline) redis.Cmder {
+		return pipe.ZAdd(key, redis.Z{Score: score, Member: val})
+	}
+}
+
+func zRemRangeByScore(key string, min, max int64) writeCommand {
+	return func(pipe *redis.Pipeline) redis.Cmder {
+		return pipe.ZRemRangeByScore(key, strconv.FormatInt(min, 10), strconv.FormatInt(max, 10))
+	}
+}
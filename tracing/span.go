@@ -0,0 +1,77 @@
+package tracing
+
+import (
+	"time"
+)
+
+// Span represents a timed operation of a trace. A nil span is valid and does nothing,
+// so callers don't check whether tracing is enabled.
+type Span struct {
+	tracer     *Tracer
+	name       string
+	traceID    string
+	spanID     string
+	parentID   string
+	start      time.Time
+	end        time.Time
+	attributes map[string]interface{}
+	err        string
+}
+
+// Child starts a span of the same trace with this span as the parent.
+func (s *Span) Child(name string) *Span {
+	if s == nil {
+		return nil
+	}
+
+	return &Span{tracer: s.tracer, name: name, start: time.Now(), traceID: s.traceID, parentID: s.spanID, spanID: randomID(8)}
+}
+
+// SetStart moves the start of the span back, e.g. to the time an exchange sent the event.
+func (s *Span) SetStart(t time.Time) {
+	if s == nil {
+		return
+	}
+	s.start = t
+}
+
+// SetAttribute records a string, bool, integer or float attribute of the span.
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+
+	if s.attributes == nil {
+		s.attributes = make(map[string]interface{})
+	}
+	s.attributes[key] = value
+}
+
+// SetError marks the span as failed.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.err = err.Error()
+}
+
+// End ends the span and queues it for the export. Spans are dropped if the queue is full.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+
+	s.end = time.Now()
+	select {
+	case s.tracer.spans <- s:
+	default:
+	}
+}
+
+// Traceparent returns the W3C traceparent header of the span, or "" for a nil span.
+func (s *Span) Traceparent() string {
+	if s == nil {
+		return ""
+	}
+	return "00-" + s.traceID + "-" + s.spanID + "-01"
+}
@@ -0,0 +1,208 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	mathrand "math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"price-feed/logger"
+)
+
+const (
+	defaultServiceName   = "price-feed"
+	defaultSampleRatio   = 0.01
+	defaultFlushInterval = 5 * time.Second
+	defaultExportTimeout = 10 * time.Second
+	queueSize            = 4096
+	maxBatchSize         = 512
+)
+
+// Config represents a tracing configuration.
+type Config struct {
+	// Endpoint is the base URL of the OTLP/HTTP collector, e.g. http://localhost:4318.
+	Endpoint string `json:"endpoint"`
+	// Headers are added to every export request, e.g. for the collector authentication.
+	Headers     map[string]string `json:"headers"`
+	ServiceName string            `json:"service_name"`
+	// SampleRatio is the share of the traces started by the service which are recorded,
+	// 0.01 by default. Sampled traces continued from a traceparent header are always recorded.
+	SampleRatio   float64 `json:"sample_ratio"`
+	FlushInterval string  `json:"flush_interval"`
+	ExportTimeout string  `json:"export_timeout"`
+}
+
+// Tracer records spans and exports them to the collector in batches.
+// The default tracer is a no-op until Init is called.
+type Tracer struct {
+	config        *Config
+	log           *logger.Logger
+	flushInterval time.Duration
+	exporter      *exporter
+	spans         chan *Span
+	randMu        sync.Mutex
+	rand          *mathrand.Rand
+}
+
+var defaultTracer *Tracer
+
+// Init makes the tracer configured by config the default one and starts the exporter.
+// Tracing stays disabled if config is nil.
+func Init(config *Config, log *logger.Logger) error {
+	if config == nil {
+		return nil
+	}
+
+	if config.Endpoint == "" {
+		return errors.New("tracing endpoint is empty")
+	}
+	if config.ServiceName == "" {
+		config.ServiceName = defaultServiceName
+	}
+	if config.SampleRatio <= 0 {
+		config.SampleRatio = defaultSampleRatio
+	}
+
+	flushInterval, err := parseDuration(config.FlushInterval, defaultFlushInterval)
+	if err != nil {
+		return errors.Wrapf(err, "couldn't parse tracing flush interval")
+	}
+
+	exportTimeout, err := parseDuration(config.ExportTimeout, defaultExportTimeout)
+	if err != nil {
+		return errors.Wrapf(err, "couldn't parse tracing export timeout")
+	}
+
+	t := &Tracer{
+		config:        config,
+		log:           log,
+		flushInterval: flushInterval,
+		exporter:      newExporter(config, exportTimeout),
+		spans:         make(chan *Span, queueSize),
+		rand:          mathrand.New(mathrand.NewSource(time.Now().UnixNano())),
+	}
+	go t.run()
+
+	defaultTracer = t
+	log.Infof("Exporting traces to %v", config.Endpoint)
+	return nil
+}
+
+func parseDuration(s string, fallback time.Duration) (time.Duration, error) {
+	if s == "" {
+		return fallback, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// Start starts a span of a new trace. The span is nil, and every method of it a no-op,
+// if tracing is disabled or the trace isn't sampled.
+func Start(name string) *Span {
+	t := defaultTracer
+	if t == nil || !t.sample() {
+		return nil
+	}
+
+	s := &Span{tracer: t, name: name, start: time.Now()}
+	s.traceID = randomID(16)
+	s.spanID = randomID(8)
+	return s
+}
+
+// StartFromHeader starts a span continuing the trace of a W3C traceparent header.
+// A new trace is started if the header is empty or invalid.
+func StartFromHeader(name, traceparent string) *Span {
+	t := defaultTracer
+	if t == nil {
+		return nil
+	}
+
+	traceID, parentID, sampled, ok := parseTraceparent(traceparent)
+	if !ok {
+		return Start(name)
+	}
+	if !sampled {
+		return nil
+	}
+
+	return &Span{tracer: t, name: name, start: time.Now(), traceID: traceID, parentID: parentID, spanID: randomID(8)}
+}
+
+func (t *Tracer) sample() bool {
+	if t.config.SampleRatio >= 1 {
+		return true
+	}
+
+	t.randMu.Lock()
+	defer t.randMu.Unlock()
+	return t.rand.Float64() < t.config.SampleRatio
+}
+
+// parseTraceparent parses a version 00 traceparent header, 00-<trace ID>-<parent ID>-<flags>.
+func parseTraceparent(header string) (traceID, parentID string, sampled, ok bool) {
+	parts := strings.Split(header, "-")
+	if len(parts) != 4 || parts[0] != "00" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return "", "", false, false
+	}
+
+	for _, v := range parts[1:] {
+		if _, err := hex.DecodeString(v); err != nil {
+			return "", "", false, false
+		}
+	}
+
+	flags, _ := hex.DecodeString(parts[3])
+	return parts[1], parts[2], flags[0]&1 == 1, true
+}
+
+func randomID(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("could not read random bytes: %v", err))
+	}
+	return hex.EncodeToString(b)
+}
+
+// run exports the ended spans in batches of up to maxBatchSize, at least every flush interval.
+func (t *Tracer) run() {
+	ticker := time.NewTicker(t.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*Span, 0, maxBatchSize)
+	for {
+		select {
+		case s := <-t.spans:
+			batch = append(batch, s)
+			if len(batch) < maxBatchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		}
+
+		if err := t.exporter.export(batch); err != nil {
+			t.log.Warnf("Could not export %v spans: %v", len(batch), err)
+		}
+		batch = batch[:0]
+	}
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying the span.
+func NewContext(ctx context.Context, s *Span) context.Context {
+	return context.WithValue(ctx, contextKey{}, s)
+}
+
+// FromContext returns the span carried by ctx, or nil.
+func FromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(contextKey{}).(*Span)
+	return s
+}
@@ -0,0 +1,99 @@
+package warmup
+
+import (
+	"sync"
+
+	"price-feed/logger"
+)
+
+const (
+	// DefaultConcurrency is used unless the exchange config sets its own.
+	DefaultConcurrency = 4
+	// progressEvery is the number of finished requests between the progress logs.
+	progressEvery = 20
+)
+
+// Scheduler runs the warmup requests of an exchange, e.g. the candle backfills,
+// on a fixed number of goroutines, so a start or a reload doesn't hit the REST
+// API with every symbol and interval at once.
+type Scheduler struct {
+	name string
+	log  *logger.Logger
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	queue  []func()
+	queued int
+	done   int
+}
+
+// New returns a new scheduler of the exchange running up to concurrency requests at once.
+func New(name string, concurrency int, log *logger.Logger) *Scheduler {
+	if concurrency <= 0 {
+		concurrency = DefaultConcurrency
+	}
+
+	s := &Scheduler{
+		name: name,
+		log:  log,
+	}
+	s.cond = sync.NewCond(&s.mu)
+
+	for i := 0; i < concurrency; i++ {
+		go s.work()
+	}
+
+	return s
+}
+
+// Submit queues the request.
+func (s *Scheduler) Submit(task func()) {
+	s.mu.Lock()
+	s.queue = append(s.queue, task)
+	s.queued++
+	s.mu.Unlock()
+
+	s.cond.Signal()
+}
+
+// Run queues the request and waits until it is done.
+func (s *Scheduler) Run(task func()) {
+	done := make(chan struct{})
+	s.Submit(func() {
+		defer close(done)
+		task()
+	})
+	<-done
+}
+
+func (s *Scheduler) work() {
+	for {
+		s.mu.Lock()
+		for len(s.queue) == 0 {
+			s.cond.Wait()
+		}
+		task := s.queue[0]
+		s.queue[0] = nil
+		s.queue = s.queue[1:]
+		s.mu.Unlock()
+
+		task()
+		s.finish()
+	}
+}
+
+// finish counts the finished request and logs the progress. The counters are reset
+// once the queue is drained, so every start or reload is reported on its own.
+func (s *Scheduler) finish() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.done++
+	switch {
+	case s.done == s.queued:
+		s.log.Infof("%v warmup is done: %d requests", s.name, s.done)
+		s.done, s.queued = 0, 0
+	case s.done%progressEvery == 0:
+		s.log.Infof("%v warmup: %d of %d requests done", s.name, s.done, s.queued)
+	}
+}
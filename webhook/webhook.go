@@ -0,0 +1,413 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"price-feed/hub"
+	"price-feed/logger"
+	"price-feed/models"
+)
+
+const (
+	defaultCheckInterval = time.Second
+	defaultTimeout       = 5 * time.Second
+	defaultMaxPerOwner   = 100
+	deliveryQueueSize    = 1024
+	contentType          = "application/json"
+	timestampHeader      = "X-Webhook-Timestamp"
+	signatureHeader      = "X-Webhook-Signature"
+	klineChannelPrefix   = "kline."
+)
+
+// Config represents a webhook manager configuration.
+type Config struct {
+	CheckInterval string `json:"check_interval"`
+	Timeout       string `json:"timeout"`
+	// MaxPerOwner limits the webhooks of an API key, 100 by default.
+	MaxPerOwner int `json:"max_per_owner"`
+}
+
+// Store persists the webhooks, so they survive restarts.
+type Store interface {
+	StoreWebhook(webhook models.Webhook) error
+	DeleteWebhook(id string) (bool, error)
+	LoadWebhooks() ([]models.Webhook, error)
+}
+
+// OrderBookSource represents an exchange worker which maintains order books.
+type OrderBookSource interface {
+	GetOrderBook(symbol string) (models.OrderBookInternal, bool)
+}
+
+// entry is a webhook with the state of its condition.
+type entry struct {
+	webhook models.Webhook
+	maxAge  time.Duration
+	// lastPrice is the price of the previous check, zero before the first one.
+	lastPrice float64
+	// triggered is set while the spread or stale condition holds, so it fires once.
+	triggered bool
+}
+
+type delivery struct {
+	url    string
+	secret string
+	data   []byte
+}
+
+// Manager checks the conditions of the registered webhooks and posts
+// a signed JSON payload when one triggers.
+type Manager struct {
+	config        *Config
+	log           *logger.Logger
+	store         Store
+	hub           *hub.Hub
+	sources       map[string]OrderBookSource
+	checkInterval time.Duration
+	client        *http.Client
+	started       time.Time
+	deliveries    chan delivery
+
+	mu       sync.Mutex
+	webhooks map[string]*entry
+
+	updatesMu sync.Mutex
+	// updates holds the time of the last candle update by exchange:symbol.
+	updates map[string]time.Time
+}
+
+// New returns a new webhook manager of the order books of the sources, keyed by exchange.
+// The stored webhooks are restored.
+func New(config *Config, log *logger.Logger, store Store, hub *hub.Hub, sources map[string]OrderBookSource) (*Manager, error) {
+	m := &Manager{
+		config:        config,
+		log:           log,
+		store:         store,
+		hub:           hub,
+		sources:       sources,
+		checkInterval: defaultCheckInterval,
+		client:        &http.Client{Timeout: defaultTimeout},
+		started:       time.Now(),
+		deliveries:    make(chan delivery, deliveryQueueSize),
+		webhooks:      make(map[string]*entry),
+		updates:       make(map[string]time.Time),
+	}
+
+	if config.MaxPerOwner <= 0 {
+		config.MaxPerOwner = defaultMaxPerOwner
+	}
+
+	if config.CheckInterval != "" {
+		interval, err := time.ParseDuration(config.CheckInterval)
+		if err != nil {
+			return nil, errors.Wrapf(err, "couldn't parse webhook check interval")
+		}
+		m.checkInterval = interval
+	}
+
+	if config.Timeout != "" {
+		timeout, err := time.ParseDuration(config.Timeout)
+		if err != nil {
+			return nil, errors.Wrapf(err, "couldn't parse webhook timeout")
+		}
+		m.client.Timeout = timeout
+	}
+
+	webhooks, err := store.LoadWebhooks()
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldn't load webhooks")
+	}
+
+	for _, v := range webhooks {
+		maxAge, _ := time.ParseDuration(v.MaxAge)
+		m.webhooks[v.ID] = &entry{webhook: v, maxAge: maxAge}
+	}
+
+	return m, nil
+}
+
+// Start starts checking the conditions and delivering the payloads.
+func (m *Manager) Start() {
+	m.hub.AddTap(m.tap)
+	go m.deliver()
+	go m.run()
+}
+
+// Validate checks the URL, the exchange and the parameters of the condition of the webhook.
+func (m *Manager) Validate(webhook models.Webhook) error {
+	u, err := url.Parse(webhook.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("url should be an absolute http or https URL")
+	}
+
+	if _, ok := m.sources[webhook.Exchange]; !ok {
+		return errors.Errorf("exchange %v has no order books", webhook.Exchange)
+	}
+
+	if webhook.Symbol == "" {
+		return errors.New("no symbol specified")
+	}
+
+	switch webhook.Condition {
+	case models.WebhookPriceCrossed:
+		if webhook.Price <= 0 {
+			return errors.New("price should be a positive number")
+		}
+	case models.WebhookSpreadAbove:
+		if webhook.Spread <= 0 {
+			return errors.New("spread should be a positive number")
+		}
+	case models.WebhookStale:
+		if maxAge, err := time.ParseDuration(webhook.MaxAge); err != nil || maxAge <= 0 {
+			return errors.New("maxAge should be a positive duration")
+		}
+	default:
+		return errors.Errorf("condition should be one of %v, %v, %v",
+			models.WebhookPriceCrossed, models.WebhookSpreadAbove, models.WebhookStale)
+	}
+
+	return nil
+}
+
+// Register stores a validated webhook of the owner and returns it with its ID and secret.
+func (m *Manager) Register(owner string, webhook models.Webhook) (models.Webhook, error) {
+	if len(m.List(owner)) >= m.config.MaxPerOwner {
+		return models.Webhook{}, errors.Errorf("webhook limit of %v reached", m.config.MaxPerOwner)
+	}
+
+	webhook.ID = randomHex(16)
+	webhook.Secret = randomHex(32)
+	webhook.Owner = owner
+	webhook.Created = time.Now().Unix()
+	if webhook.ID == "" || webhook.Secret == "" {
+		return models.Webhook{}, errors.New("could not generate webhook secret")
+	}
+
+	if err := m.store.StoreWebhook(webhook); err != nil {
+		return models.Webhook{}, errors.Wrapf(err, "could not store webhook")
+	}
+
+	maxAge, _ := time.ParseDuration(webhook.MaxAge)
+
+	m.mu.Lock()
+	m.webhooks[webhook.ID] = &entry{webhook: webhook, maxAge: maxAge}
+	m.mu.Unlock()
+
+	return webhook, nil
+}
+
+// List returns the webhooks of the owner without their secrets, oldest first.
+func (m *Manager) List(owner string) []models.Webhook {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	webhooks := make([]models.Webhook, 0)
+	for _, v := range m.webhooks {
+		if v.webhook.Owner != owner {
+			continue
+		}
+
+		webhook := v.webhook
+		webhook.Secret = ""
+		webhooks = append(webhooks, webhook)
+	}
+
+	sort.Slice(webhooks, func(i, j int) bool {
+		if webhooks[i].Created != webhooks[j].Created {
+			return webhooks[i].Created < webhooks[j].Created
+		}
+		return webhooks[i].ID < webhooks[j].ID
+	})
+	return webhooks
+}
+
+// Delete deletes the webhook of the owner, it reports whether the webhook was found.
+func (m *Manager) Delete(owner, id string) (bool, error) {
+	m.mu.Lock()
+	v, ok := m.webhooks[id]
+	m.mu.Unlock()
+
+	if !ok || v.webhook.Owner != owner {
+		return false, nil
+	}
+
+	if _, err := m.store.DeleteWebhook(id); err != nil {
+		return false, errors.Wrapf(err, "could not delete webhook")
+	}
+
+	m.mu.Lock()
+	delete(m.webhooks, id)
+	m.mu.Unlock()
+
+	return true, nil
+}
+
+// tap records the time of the candle updates for the stale condition.
+func (m *Manager) tap(channel string, data interface{}) {
+	if !strings.HasPrefix(channel, klineChannelPrefix) {
+		return
+	}
+
+	update, ok := data.(models.CandleUpdate)
+	if !ok {
+		return
+	}
+
+	m.updatesMu.Lock()
+	m.updates[update.Exchange+":"+update.Symbol] = time.Now()
+	m.updatesMu.Unlock()
+}
+
+// lastUpdate returns the time of the last candle update, or the start time if there was none.
+func (m *Manager) lastUpdate(exchange, symbol string) time.Time {
+	m.updatesMu.Lock()
+	defer m.updatesMu.Unlock()
+
+	if v, ok := m.updates[exchange+":"+symbol]; ok {
+		return v
+	}
+	return m.started
+}
+
+func (m *Manager) run() {
+	for ; ; <-time.Tick(m.checkInterval) {
+		m.mu.Lock()
+		for _, v := range m.webhooks {
+			m.check(v)
+		}
+		m.mu.Unlock()
+	}
+}
+
+// check evaluates the condition of the webhook and queues a payload if it triggers.
+// It must be called with mu held.
+func (m *Manager) check(e *entry) {
+	webhook := e.webhook
+	now := time.Now()
+	lastUpdate := m.lastUpdate(webhook.Exchange, webhook.Symbol)
+
+	var bbo models.BBO
+	if orderBook, ok := m.sources[webhook.Exchange].GetOrderBook(webhook.Symbol); ok {
+		bbo = orderBook.BestBidOffer()
+	}
+	price := bbo.Price()
+
+	var spread float64
+	if bbo.BidPrice > 0 && bbo.AskPrice > 0 && price > 0 {
+		spread = (bbo.AskPrice - bbo.BidPrice) / price
+	}
+
+	var fire bool
+	switch webhook.Condition {
+	case models.WebhookPriceCrossed:
+		if price == 0 {
+			return
+		}
+		last := e.lastPrice
+		e.lastPrice = price
+		fire = last > 0 && (last < webhook.Price) != (price < webhook.Price)
+	case models.WebhookSpreadAbove:
+		if price == 0 {
+			return
+		}
+		fire = m.edge(e, spread > webhook.Spread)
+	case models.WebhookStale:
+		fire = m.edge(e, now.Sub(lastUpdate) > e.maxAge)
+	}
+
+	if !fire {
+		return
+	}
+
+	data, err := json.Marshal(models.WebhookEvent{
+		WebhookID:  webhook.ID,
+		Condition:  webhook.Condition,
+		Exchange:   webhook.Exchange,
+		Symbol:     webhook.Symbol,
+		Price:      price,
+		Spread:     spread,
+		LastUpdate: lastUpdate.Unix(),
+		Time:       now.Unix(),
+	})
+	if err != nil {
+		m.log.Errorf("Could not marshal webhook event: %v", err)
+		return
+	}
+
+	select {
+	case m.deliveries <- delivery{url: webhook.URL, secret: webhook.Secret, data: data}:
+	default:
+		m.log.Warnf("Webhook queue is full, dropping event of webhook %v", webhook.ID)
+	}
+}
+
+// edge reports whether the condition became true since the previous check.
+func (m *Manager) edge(e *entry, holds bool) bool {
+	fire := holds && !e.triggered
+	e.triggered = holds
+	return fire
+}
+
+// deliver posts the queued payloads.
+func (m *Manager) deliver() {
+	for d := range m.deliveries {
+		if err := m.post(d); err != nil {
+			m.log.Errorf("Could not deliver webhook event to %v: %v", d.url, err)
+		}
+	}
+}
+
+// post sends the payload signed with HMAC-SHA256 of "<timestamp>.<body>" in the signature header.
+func (m *Manager) post(d delivery) error {
+	req, err := http.NewRequest(http.MethodPost, d.url, bytes.NewReader(d.data))
+	if err != nil {
+		return err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set(timestampHeader, timestamp)
+	req.Header.Set(signatureHeader, "sha256="+Sign(d.secret, timestamp, d.data))
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.Errorf("received bad status code: %v", resp.StatusCode)
+	}
+	return nil
+}
+
+// Sign returns the hex HMAC-SHA256 of the timestamp and the body, which receivers
+// compare to the signature header.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}